@@ -0,0 +1,151 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Middleware that only lets requests carrying the configured admin token through
+func (cfg *apiConfig) middlewareAdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.adminToken == "" {
+			log.Printf("Rejected admin request: ADMIN_TOKEN is not configured")
+			marshallError(w, fmt.Errorf("admin access is disabled"), 403)
+			return
+		}
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			marshallError(w, err, 401)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.adminToken)) != 1 {
+			log.Printf("Rejected admin request with invalid token from %s", clientIP(r))
+			marshallError(w, fmt.Errorf("invalid admin token"), 401)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Registers an admin-only route; keep every /admin pattern going through here
+func (cfg *apiConfig) handleAdmin(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	mux.Handle(pattern, withCachePolicy("no-store", cfg.middlewareAdminAuth(withTimeout(cfg.routeTimeout(pattern), handler))))
+}
+
+// Registers the net/http/pprof handlers under /admin/debug/pprof/ behind admin auth.
+// CPU profiles must use a ?seconds= value shorter than SERVER_WRITE_TIMEOUT.
+func (cfg *apiConfig) registerPprof(mux *http.ServeMux) {
+	// pprof.Index resolves profile names relative to /debug/pprof/, so strip the /admin prefix
+	profiles := http.NewServeMux()
+	profiles.HandleFunc("/debug/pprof/", pprof.Index)
+	profiles.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	profiles.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	profiles.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	profiles.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/admin/debug/pprof/", cfg.middlewareAdminAuth(http.StripPrefix("/admin", profiles)))
+}
+
+type adminUserResponse struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Email       string    `json:"email"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	TenantID    uuid.UUID `json:"tenant_id"`
+}
+
+// Lists the users of every tenant in id order, ?limit= (default 100, max 1000) after
+// the ?after= id, with a Link header to the next page. As CSV it streams every user
+// after ?after= instead, read in one snapshot.
+func (cfg *apiConfig) handlerAdminUsers(w http.ResponseWriter, r *http.Request) {
+	type query struct {
+		After string `query:"after"`
+		Limit int32  `query:"limit" validate:"min=1,max=1000"`
+	}
+	q := query{Limit: 100}
+	err := decodeQuery(r, &q)
+	if err != nil {
+		marshallError(w, err, 400)
+		return
+	}
+	after := uuid.Nil
+	if q.After != "" {
+		after, err = uuid.Parse(q.After)
+		if err != nil {
+			marshallError(w, invalidInputError{"after must be a user id"}, 400)
+			return
+		}
+	}
+	if wantsCSV(r) {
+		cfg.exportUsersCSV(w, r, after)
+		return
+	}
+	users, err := cfg.store.ListUsersAfter(r.Context(), database.ListUsersAfterParams{ID: after, Limit: q.Limit})
+	if err != nil {
+		log.Printf("Error listing users: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]adminUserResponse, 0, len(users))
+	for _, user := range users {
+		resp = append(resp, adminUserResponse{
+			ID:          user.ID,
+			CreatedAt:   user.CreatedAt,
+			UpdatedAt:   user.UpdatedAt,
+			Email:       user.Email,
+			IsChirpyRed: user.IsChirpyRed,
+			TenantID:    user.TenantID,
+		})
+	}
+	if len(users) == int(q.Limit) {
+		next := r.URL.Query()
+		next.Set("after", users[len(users)-1].ID.String())
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, next.Encode()))
+	}
+	render(w, r, 200, resp)
+}
+
+func (cfg *apiConfig) exportUsersCSV(w http.ResponseWriter, r *http.Request, after uuid.UUID) {
+	ctx := r.Context()
+	// every user takes longer to send than SERVER_WRITE_TIMEOUT allows
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	export := newCSVExport(w, "chirpy-users", "id", "created_at", "updated_at", "email", "is_chirpy_red", "tenant_id")
+	err := cfg.store.Snapshot(ctx, func(tx store.Store) error {
+		for {
+			users, err := tx.ListUsersAfter(ctx, database.ListUsersAfterParams{ID: after, Limit: backupPageSize})
+			if err != nil {
+				return err
+			}
+			for _, user := range users {
+				err = export.Write(user.ID.String(), csvTime(user.CreatedAt), csvTime(user.UpdatedAt), user.Email, strconv.FormatBool(user.IsChirpyRed), user.TenantID.String())
+				if err != nil {
+					return err
+				}
+				after = user.ID
+			}
+			if len(users) < backupPageSize {
+				return export.Close()
+			}
+		}
+	})
+	if err != nil {
+		log.Printf("Error exporting users: %s", err.Error())
+		if !export.Started() {
+			marshallError(w, err, 500)
+			return
+		}
+		// part of the export is out, so drop the connection rather than end the
+		// response normally and have the rows look complete
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Audit log actor of admin requests made with a client certificate, followed by the
+// certificate's common name
+const adminCertActorPrefix = "admin-cert:"
+
+// Reports whether path is under /admin/, the subtree ADMIN_TOKEN, ADMIN_IP_ALLOWLIST and
+// ADMIN_CLIENT_CA_FILE guard
+func isAdminPath(path string) bool {
+	return path == "/admin" || strings.HasPrefix(path, "/admin/")
+}
+
+// Reads the certificate and key at TLS_CERT_FILE and TLS_KEY_FILE to serve HTTPS, or
+// returns nil to serve plain HTTP when neither is set. prefix picks another pair, such
+// as ADMIN_TLS_CERT_FILE and ADMIN_TLS_KEY_FILE with "ADMIN_".
+func loadServerTLS(prefix string) (*tls.Config, error) {
+	certVar, keyVar := prefix+"TLS_CERT_FILE", prefix+"TLS_KEY_FILE"
+	certFile, keyFile := os.Getenv(certVar), os.Getenv(keyVar)
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("%s and %s must be set together", certVar, keyVar)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s and %s: %w", certVar, keyVar, err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Reads the PEM bundle at ADMIN_CLIENT_CA_FILE, the CAs whose client certificates the
+// admin endpoints accept, or returns nil when it is unset
+func loadAdminClientCAs() (*x509.CertPool, error) {
+	path := os.Getenv("ADMIN_CLIENT_CA_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	dat, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading ADMIN_CLIENT_CA_FILE: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(dat) {
+		return nil, fmt.Errorf("ADMIN_CLIENT_CA_FILE %s holds no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// Returns a copy of base that asks clients for a certificate signed by one of clientCAs.
+// With require the handshake fails without one, as on ADMIN_ADDR; otherwise clients may
+// leave it out, and middlewareAdminClientCert turns them away from /admin/ only.
+func withClientCAs(base *tls.Config, clientCAs *x509.CertPool, require bool) *tls.Config {
+	config := base.Clone()
+	config.ClientCAs = clientCAs
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if require {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config
+}
+
+// Middleware that answers 403 for /admin/ requests without a client certificate verified
+// against ADMIN_CLIENT_CA_FILE, before the admin token is looked at. It does nothing when
+// no CA bundle is configured.
+func (cfg *apiConfig) middlewareAdminClientCert(next http.Handler) http.Handler {
+	if cfg.adminClientCAs == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) && clientCertName(r) == "" {
+			log.Printf("Rejected admin request without a client certificate from %s", clientIP(r))
+			marshallError(w, &apiError{
+				Code:    "client_certificate_required",
+				Message: "admin endpoints require a client certificate",
+			}, 403)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns the common name of the verified client certificate of r, or "" when there is
+// none. Certificates without a common name are named by their serial number.
+func clientCertName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return "serial:" + cert.SerialNumber.String()
+}
+
+// Serves HTTPS on l when srv has a TLS configuration, plain HTTP otherwise
+func serveHTTP(srv *http.Server, l net.Listener) error {
+	if srv.TLSConfig != nil {
+		return srv.ServeTLS(l, "", "")
+	}
+	return srv.Serve(l)
+}
+
+// Serves only the /admin/ subtree, for the ADMIN_ADDR listener
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdminPath(r.URL.Path) {
+			marshallError(w, fmt.Errorf("only admin endpoints are served on this port"), 404)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Serves everything but the /admin/ subtree, for the public listener when the admin
+// endpoints have their own
+func withoutAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(r.URL.Path) {
+			marshallError(w, fmt.Errorf("no route matches %s", r.URL.Path), 404)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
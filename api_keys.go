@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Developer API keys let programs read the public API without signing in as anyone.
+// Signed-in users issue them through /api/api-keys, and programs send them as
+// "Authorization: ApiKey chirpy_...". A key only works for GET requests, which are
+// served as if they were anonymous, so it grants nothing a visitor does not have. Each
+// key has a rate limit and a daily quota of its own.
+
+// Every key starts with this, which tells them apart from the Polka key sent the same way
+const apiKeyPrefix = "chirpy_"
+
+// keeps one account from multiplying its limits without bound
+const maxAPIKeysPerUser = 10
+
+// How stale a key's last_used_at may get, so a busy key is not written on every request
+const apiKeyTouchInterval = time.Minute
+
+// Days of usage GET /api/usage shows, today included
+const apiUsageDays = 30
+
+type apiKeyContextKey struct{}
+
+// Returns ctx for a request made with key
+func withAPIKey(ctx context.Context, key database.ApiKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+// Returns the key a request was made with, if it was made with one
+func apiKeyFrom(ctx context.Context) (database.ApiKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(database.ApiKey)
+	return key, ok
+}
+
+// Helper function to hash an API key, which is only stored hashed
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type apiKeyResponse struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	// the start of the key, to tell keys apart by
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	// only returned when the key is created
+	Key string `json:"key,omitempty"`
+}
+
+func newAPIKeyResponse(key database.ApiKey) apiKeyResponse {
+	resp := apiKeyResponse{ID: key.ID, Name: key.Name, Prefix: key.Prefix, CreatedAt: key.CreatedAt}
+	if key.LastUsedAt.Valid {
+		resp.LastUsedAt = &key.LastUsedAt.Time
+	}
+	return resp
+}
+
+// Requests made with a key on one UTC day
+type apiUsageDay struct {
+	Day      string `json:"day"`
+	Requests int64  `json:"requests"`
+}
+
+// One key's quota for today and its requests on each of the last days that had any
+type apiKeyUsage struct {
+	Key   apiKeyResponse `json:"key"`
+	Quota quotaUsage     `json:"quota"`
+	Days  []apiUsageDay  `json:"days"`
+}
+
+// Response of GET /api/usage
+type apiUsageResponse struct {
+	RateLimitRPS   float64       `json:"rate_limit_rps"`
+	RateLimitBurst int           `json:"rate_limit_burst"`
+	Keys           []apiKeyUsage `json:"keys"`
+}
+
+// Issues the caller a new API key. The key is generated here and shown only once.
+func (cfg *apiConfig) handlerCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	type parameters struct {
+		Name string `json:"name" validate:"required,max=100"`
+	}
+	params := parameters{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	ctx := r.Context()
+	users, err := cfg.store.GetUsersByIds(ctx, database.GetUsersByIdsParams{TenantID: tenantID(ctx), Ids: userID.String()})
+	if err != nil {
+		log.Printf("Error getting user: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if len(users) == 0 {
+		marshallError(w, errOtherTenant, 403)
+		return
+	}
+	existing, err := cfg.store.ListAPIKeysByUser(ctx, userID)
+	if err != nil {
+		log.Printf("Error listing API keys: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if len(existing) >= maxAPIKeysPerUser {
+		marshallError(w, &apiError{Code: "api_key_limit_reached", Message: fmt.Sprintf("at most %d API keys are allowed per user", maxAPIKeysPerUser)}, 409)
+		return
+	}
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error generating API key: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	key := apiKeyPrefix + secret
+	apiKey, err := cfg.store.CreateAPIKey(ctx, database.CreateAPIKeyParams{
+		UserID:   userID,
+		TenantID: tenantID(ctx),
+		Name:     params.Name,
+		KeyHash:  hashAPIKey(key),
+		Prefix:   key[:len(apiKeyPrefix)+8],
+	})
+	if err != nil {
+		log.Printf("Error creating API key: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.recordAudit(r, "api_key.create", apiKey.ID.String(), map[string]any{"user_id": userID, "name": apiKey.Name})
+	resp := newAPIKeyResponse(apiKey)
+	resp.Key = key
+	render(w, r, 201, resp)
+}
+
+// Lists the caller's API keys
+func (cfg *apiConfig) handlerListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	rows, err := cfg.store.ListAPIKeysByUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error listing API keys: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]apiKeyResponse, 0, len(rows))
+	for _, key := range rows {
+		resp = append(resp, newAPIKeyResponse(key))
+	}
+	render(w, r, 200, resp)
+}
+
+// Revokes one of the caller's API keys along with its usage
+func (cfg *apiConfig) handlerDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("keyID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid API key id"), 400)
+		return
+	}
+	deleted, err := cfg.store.DeleteAPIKey(r.Context(), database.DeleteAPIKeyParams{ID: id, UserID: userID})
+	if err != nil {
+		log.Printf("Error deleting API key: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if deleted == 0 {
+		marshallError(w, fmt.Errorf("no API key with id %s", id), 404)
+		return
+	}
+	cfg.recordAudit(r, "api_key.delete", id.String(), map[string]any{"user_id": userID})
+	w.WriteHeader(204)
+}
+
+// Shows the limits and usage of the key the request is made with, or of all the
+// caller's keys when it is made with an access token
+func (cfg *apiConfig) handlerGetAPIUsage(w http.ResponseWriter, r *http.Request) {
+	keys := []database.ApiKey{}
+	if key, ok := apiKeyFrom(r.Context()); ok {
+		keys = append(keys, key)
+	} else {
+		userID, ok := cfg.authenticateUser(w, r)
+		if !ok {
+			return
+		}
+		var err error
+		keys, err = cfg.store.ListAPIKeysByUser(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error listing API keys: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+	}
+	settings := cfg.settings.Load()
+	limit := settings.quotas.limit(quotaAPIKeyRequests, false)
+	today := time.Now().UTC().Truncate(quotaWindows[quotaAPIKeyRequests])
+	resp := apiUsageResponse{RateLimitRPS: settings.apiKeyRateLimitRPS, RateLimitBurst: settings.apiKeyRateLimitBurst, Keys: []apiKeyUsage{}}
+	for _, key := range keys {
+		rows, err := cfg.store.ListAPIKeyUsage(r.Context(), database.ListAPIKeyUsageParams{ApiKeyID: key.ID, Day: today.AddDate(0, 0, 1-apiUsageDays)})
+		if err != nil {
+			log.Printf("Error listing API key usage: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		usage := apiKeyUsage{Key: newAPIKeyResponse(key), Days: make([]apiUsageDay, 0, len(rows))}
+		var used int64
+		for _, row := range rows {
+			if row.Day.Equal(today) {
+				used = row.Requests
+			}
+			usage.Days = append(usage.Days, apiUsageDay{Day: row.Day.UTC().Format(time.DateOnly), Requests: row.Requests})
+		}
+		usage.Quota = newQuotaUsage(quotaAPIKeyRequests, limit, used, today)
+		resp.Keys = append(resp.Keys, usage)
+	}
+	render(w, r, 200, resp)
+}
+
+// Counts a request made with key towards its daily quota, returning a
+// *quotaExceededError once it is past the limit. Requests are counted even when there
+// is no limit, for GET /api/usage.
+func (cfg *apiConfig) takeAPIKeyQuota(ctx context.Context, key database.ApiKey) (quotaUsage, error) {
+	limit := cfg.settings.Load().quotas.limit(quotaAPIKeyRequests, false)
+	day := time.Now().UTC().Truncate(quotaWindows[quotaAPIKeyRequests])
+	used, err := cfg.store.AddAPIKeyUsage(ctx, database.AddAPIKeyUsageParams{ApiKeyID: key.ID, Day: day, Requests: 1})
+	if err != nil {
+		return quotaUsage{}, err
+	}
+	usage := newQuotaUsage(quotaAPIKeyRequests, limit, used, day)
+	if limit > 0 && used > limit {
+		return usage, &quotaExceededError{usage}
+	}
+	return usage, nil
+}
+
+// Middleware that authenticates /api requests made with an API key and holds them to
+// the key's rate limit and daily quota. The request continues without its
+// Authorization header, as an anonymous one.
+func (cfg *apiConfig) middlewareAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		secret, err := auth.GetAPIKey(r.Header)
+		if err != nil || !strings.HasPrefix(secret, apiKeyPrefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		key, err := cfg.store.GetAPIKeyByHash(ctx, hashAPIKey(secret))
+		// a key of another tenant looks the same as a revoked one
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && key.TenantID != tenantID(ctx)) {
+			marshallError(w, &apiError{Code: "invalid_api_key", Message: "invalid API key"}, 401)
+			return
+		}
+		if err != nil {
+			log.Printf("Error finding API key: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			marshallError(w, &apiError{Code: "api_key_read_only", Message: "API keys can only be used for GET requests"}, 403)
+			return
+		}
+		res, err := cfg.settings.Load().apiKeyRateLimiter.Allow(ctx, "apikey:"+key.ID.String())
+		if err != nil {
+			// fail open, like the per-address limit
+			log.Printf("Error checking API key rate limit: %s", err.Error())
+		} else {
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(res.RetryAfter.Seconds())+1))
+				marshallError(w, &apiError{
+					Message: "API key rate limit exceeded",
+					Details: map[string]any{"retry_after_seconds": int(res.RetryAfter.Seconds()) + 1},
+				}, 429)
+				return
+			}
+		}
+		usage, err := cfg.takeAPIKeyQuota(ctx, key)
+		var exceeded *quotaExceededError
+		if errors.As(err, &exceeded) {
+			w.Header().Set("Retry-After", exceeded.retryAfter())
+			marshallError(w, err, 429)
+			return
+		}
+		if err != nil {
+			log.Printf("Error counting API key usage: %s", err.Error())
+		} else if usage.Remaining != nil {
+			w.Header().Set("X-Quota-Remaining", strconv.FormatInt(*usage.Remaining, 10))
+		}
+		now := time.Now().UTC()
+		if !key.LastUsedAt.Valid || now.Sub(key.LastUsedAt.Time) > apiKeyTouchInterval {
+			key.LastUsedAt = sql.NullTime{Time: now, Valid: true}
+			err = cfg.store.TouchAPIKey(ctx, database.TouchAPIKeyParams{ID: key.ID, LastUsedAt: key.LastUsedAt})
+			if err != nil {
+				log.Printf("Error recording API key use: %s", err.Error())
+			}
+		}
+		r = r.WithContext(withAPIKey(ctx, key))
+		r.Header = r.Header.Clone()
+		r.Header.Del("Authorization")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Every error response has this shape:
+//
+//	{"error": {"code": "not_found", "message": "...", "details": ..., "request_id": "..."}}
+//
+// Clients branch on code, which is stable; message is for humans and may change.
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// An error that knows how it should be reported to API clients. Handlers return one
+// when a more specific code than the status default, or details, help the client.
+type apiError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Details   any    `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// Codes for errors that are not specific to one handler, by status
+var statusErrorCodes = map[int]string{
+	400: "bad_request",
+	401: "unauthorized",
+	403: "forbidden",
+	404: "not_found",
+	405: "method_not_allowed",
+	406: "not_acceptable",
+	409: "conflict",
+	413: "payload_too_large",
+	415: "unsupported_media_type",
+	422: "unprocessable",
+	429: "rate_limited",
+	500: "internal_error",
+	503: "unavailable",
+	504: "timeout",
+}
+
+// Returns the code for err answered with status: the code of an *apiError, then the
+// code for a known service layer error, then the default for the status
+func errorCode(err error, status int) string {
+	var apiErr *apiError
+	var invalid invalidInputError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code != "":
+		return apiErr.Code
+	case errors.As(err, &invalid):
+		return "invalid_input"
+	case errors.Is(err, errInvalidPassword):
+		return "invalid_credentials"
+	case errors.Is(err, sql.ErrNoRows) && status == 404:
+		return "not_found"
+	}
+	if code, ok := statusErrorCodes[status]; ok {
+		return code
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return "error"
+}
+
+// Helper function to send an error response with the specified status code. The
+// messages of server errors are replaced with the status text so internals do not
+// leak; callers log the original error. The request ID is read back from the header
+// middlewareRequestID set, so callers do not need the request.
+func marshallError(w http.ResponseWriter, err error, code int) {
+	if isQueryTimeout(err) {
+		// whatever the handler expected, the database simply did not answer in time
+		code = 504
+	}
+	if constraint, ok := uniqueViolation(err); ok {
+		err = conflictError(constraint)
+		code = 409
+	}
+	if code >= 500 {
+		reportServerError(w, err)
+	}
+	body := apiError{
+		Code:      errorCode(err, code),
+		Message:   http.StatusText(code),
+		RequestID: w.Header().Get("X-Request-ID"),
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		body.Message = apiErr.Message
+		body.Details = apiErr.Details
+	} else if err != nil && code < 500 {
+		body.Message = err.Error()
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	dat, marshalErr := encodeJSON(buf, apiErrorResponse{Error: body})
+	if marshalErr != nil {
+		log.Printf("Error marshalling error response: %s", marshalErr.Error())
+		dat = []byte(`{"error":{"code":"internal_error","message":"Internal Server Error"}}`)
+	}
+	writeBody(w, code, "application/json", dat)
+}
+
+// Reports whether err comes from a database query that ran out of time. Besides the
+// store's own error, the drivers report cancelled statements in their own ways when
+// the deadline hits while a row is being read.
+func isQueryTimeout(err error) bool {
+	var pqErr *pq.Error
+	var pgErr *pgconn.PgError
+	var sqliteErr *sqlite.Error
+	switch {
+	case errors.Is(err, store.ErrQueryTimeout), errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.As(err, &pqErr):
+		return pqErr.Code == "57014" // query_canceled
+	case errors.As(err, &pgErr):
+		return pgErr.Code == "57014"
+	case errors.As(err, &sqliteErr):
+		return sqliteErr.Code() == sqlite3.SQLITE_INTERRUPT
+	}
+	return false
+}
+
+// Fields behind the unique constraints clients can run into, by Postgres constraint
+// name and by the column list SQLite reports instead
+var uniqueConstraintFields = map[string]string{
+	"users_tenant_id_email_idx":               "email",
+	"users.tenant_id, users.email":            "email",
+	"tenants_slug_key":                        "slug",
+	"tenants.slug":                            "slug",
+	"word_filters_kind_pattern_idx":           "pattern",
+	"word_filters.kind, word_filters.pattern": "pattern",
+}
+
+// Reports whether err is a write that broke a unique constraint, and which one when the
+// driver says so: pq and pgx name the constraint, SQLite lists its columns
+func uniqueViolation(err error) (string, bool) {
+	var pqErr *pq.Error
+	var pgErr *pgconn.PgError
+	var sqliteErr *sqlite.Error
+	var memErr *store.UniqueViolation
+	switch {
+	case errors.As(err, &memErr):
+		return memErr.Constraint, true
+	case errors.As(err, &pqErr):
+		return pqErr.Constraint, pqErr.Code == "23505" // unique_violation
+	case errors.As(err, &pgErr):
+		return pgErr.ConstraintName, pgErr.Code == "23505"
+	case errors.As(err, &sqliteErr):
+		if sqliteErr.Code() != sqlite3.SQLITE_CONSTRAINT_UNIQUE && sqliteErr.Code() != sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+			return "", false
+		}
+		_, columns, _ := strings.Cut(sqliteErr.Error(), "UNIQUE constraint failed: ")
+		columns, _, _ = strings.Cut(columns, " (")
+		return columns, true
+	}
+	return "", false
+}
+
+// Helper function to describe a unique violation to the client, naming the field when
+// the constraint is one of uniqueConstraintFields
+func conflictError(constraint string) *apiError {
+	field, ok := uniqueConstraintFields[constraint]
+	if !ok {
+		return &apiError{Code: "already_exists", Message: "a record with the same values already exists"}
+	}
+	return &apiError{
+		Code:    "already_exists",
+		Message: fmt.Sprintf("%s is already taken", field),
+		Details: map[string]any{"field": field},
+	}
+}
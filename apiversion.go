@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// API versions served under /api/v<N>/, oldest first. A breaking change to a response
+// shape ships as a new version here plus a byAPIVersion entry on the affected routes.
+var apiVersions = []int{1}
+
+// Unversioned /api/ paths predate versioning, so they keep the original shapes unless
+// the client asks for something newer with the API-Version header
+const defaultAPIVersion = 1
+
+type apiVersionKey struct{}
+
+// Registers an /api route for every supported version plus the unversioned alias.
+// pattern omits the /api prefix, e.g. "GET /chirps/{chirpID}".
+func (cfg *apiConfig) handleAPI(mux *http.ServeMux, pattern string, handler http.Handler) {
+	cfg.apiRoutes = append(cfg.apiRoutes, pattern)
+	handler = withCachePolicy(apiCacheControl(pattern), withTimeout(cfg.routeTimeout(pattern), handler))
+	method, path, _ := strings.Cut(pattern, " ")
+	for _, version := range apiVersions {
+		mux.Handle(fmt.Sprintf("%s /api/v%d%s", method, version, path), withAPIVersion(version, handler))
+	}
+	mux.Handle(method+" /api"+path, negotiateAPIVersion(handler))
+}
+
+// Pins requests on a /api/v<N>/ path to that version
+func withAPIVersion(version int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", strconv.Itoa(version))
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, version)))
+	})
+}
+
+// Picks the version for an unversioned path from the API-Version request header,
+// rejecting versions this server does not know
+func negotiateAPIVersion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := defaultAPIVersion
+		if value := r.Header.Get("API-Version"); value != "" {
+			parsed, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(value), "v"))
+			if err != nil || !slices.Contains(apiVersions, parsed) {
+				marshallError(w, &apiError{
+					Code:    "unsupported_api_version",
+					Message: fmt.Sprintf("unsupported API-Version %q, supported versions are %s", value, apiVersionList()),
+					Details: map[string]any{"supported": apiVersions},
+				}, 400)
+				return
+			}
+			version = parsed
+		}
+		withAPIVersion(version, next).ServeHTTP(w, r)
+	})
+}
+
+// Returns the version selected for the request, or the default outside of handleAPI
+func apiVersion(ctx context.Context) int {
+	if version, ok := ctx.Value(apiVersionKey{}).(int); ok {
+		return version
+	}
+	return defaultAPIVersion
+}
+
+func apiVersionList() string {
+	names := make([]string, 0, len(apiVersions))
+	for _, v := range apiVersions {
+		names = append(names, strconv.Itoa(v))
+	}
+	return strings.Join(names, ", ")
+}
+
+// byAPIVersion serves each request with the handler for the newest version that is not
+// newer than the requested one, so a route only lists the versions where it changed:
+//
+//	byAPIVersion{1: http.HandlerFunc(cfg.handlerGetChirps), 2: http.HandlerFunc(cfg.handlerGetChirpsV2)}
+type byAPIVersion map[int]http.Handler
+
+func (handlers byAPIVersion) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requested := apiVersion(r.Context())
+	best := 0
+	for version := range handlers {
+		if version <= requested && version > best {
+			best = version
+		}
+	}
+	handler, ok := handlers[best]
+	if !ok {
+		marshallError(w, fmt.Errorf("this endpoint is not available in API version %d", requested), 404)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,606 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/automod"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/google/uuid"
+)
+
+// A chirp a reject rule matched. Which rule is not said, so it cannot be worked around.
+var errChirpRejectedAutomod = errors.New("chirp was rejected by auto-moderation")
+
+const (
+	// Reason of the report filed for a chirp a flag rule matched
+	automodFlagReason = "automod"
+	// Reason of the report filed for a chirp a hold rule matched. Until a moderator
+	// decides on it, the read queries hide the chirp from everyone but its author.
+	automodHoldReason = "automod_hold"
+	// Quota name of the posting limit a tighten_rate_limit rule puts on a user
+	automodLimitQuota = "automod_limit"
+	// Audit log actor of the limits the rules put on users
+	automodActor = "automod"
+)
+
+// Request body of creating or replacing an auto-moderation rule. enabled defaults to true.
+type automodRuleRequest struct {
+	Name       string              `json:"name" validate:"required,max=200"`
+	Enabled    *bool               `json:"enabled"`
+	Conditions []automod.Condition `json:"conditions"`
+	Action     string              `json:"action" validate:"required,oneof=flag hold reject tighten_rate_limit"`
+	Tighten    *automod.Tighten    `json:"tighten"`
+}
+
+func (req automodRuleRequest) definition() automod.Definition {
+	return automod.Definition{Conditions: req.Conditions, Action: automod.Action(req.Action), Tighten: req.Tighten}
+}
+
+type automodRuleResponse struct {
+	ID         uuid.UUID           `json:"id"`
+	Name       string              `json:"name"`
+	Version    int32               `json:"version"`
+	Enabled    bool                `json:"enabled"`
+	Conditions []automod.Condition `json:"conditions"`
+	Action     automod.Action      `json:"action"`
+	Tighten    *automod.Tighten    `json:"tighten,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+}
+
+func newAutomodRuleResponse(rule database.AutomodRule) automodRuleResponse {
+	def := parseAutomodDefinition(rule.Definition)
+	return automodRuleResponse{
+		ID:         rule.ID,
+		Name:       rule.Name,
+		Version:    rule.Version,
+		Enabled:    rule.Enabled,
+		Conditions: def.Conditions,
+		Action:     def.Action,
+		Tighten:    def.Tighten,
+		CreatedAt:  rule.CreatedAt,
+		UpdatedAt:  rule.UpdatedAt,
+	}
+}
+
+type automodRuleVersionResponse struct {
+	RuleID     uuid.UUID           `json:"rule_id"`
+	Version    int32               `json:"version"`
+	Name       string              `json:"name"`
+	Enabled    bool                `json:"enabled"`
+	Conditions []automod.Condition `json:"conditions"`
+	Action     automod.Action      `json:"action"`
+	Tighten    *automod.Tighten    `json:"tighten,omitempty"`
+	CreatedBy  string              `json:"created_by"`
+	CreatedAt  time.Time           `json:"created_at"`
+}
+
+func newAutomodRuleVersionResponse(version database.AutomodRuleVersion) automodRuleVersionResponse {
+	def := parseAutomodDefinition(version.Definition)
+	return automodRuleVersionResponse{
+		RuleID:     version.RuleID,
+		Version:    version.Version,
+		Name:       version.Name,
+		Enabled:    version.Enabled,
+		Conditions: def.Conditions,
+		Action:     def.Action,
+		Tighten:    def.Tighten,
+		CreatedBy:  version.CreatedBy,
+		CreatedAt:  version.CreatedAt,
+	}
+}
+
+// Reads a stored definition. Definitions are checked before they are stored, so one
+// that does not parse is logged and read as empty.
+func parseAutomodDefinition(dat string) automod.Definition {
+	var def automod.Definition
+	err := json.Unmarshal([]byte(dat), &def)
+	if err != nil {
+		log.Printf("Error reading automod rule definition: %s", err.Error())
+	}
+	return def
+}
+
+func automodRule(rule database.AutomodRule) automod.Rule {
+	return automod.Rule{
+		ID:         rule.ID.String(),
+		Name:       rule.Name,
+		Version:    int(rule.Version),
+		Definition: parseAutomodDefinition(rule.Definition),
+	}
+}
+
+// Loads the enabled auto-moderation rules into cfg.automod and returns every rule
+func (cfg *apiConfig) syncAutomod(ctx context.Context) ([]database.AutomodRule, error) {
+	rules, err := cfg.store.ListAutomodRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	enabled := make([]automod.Rule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Enabled {
+			enabled = append(enabled, automodRule(rule))
+		}
+	}
+	engine, err := automod.NewEngine(enabled)
+	if err != nil {
+		return nil, err
+	}
+	cfg.automod.Store(engine)
+	return rules, nil
+}
+
+// Picks up rules changed on other instances every interval until ctx is done. A failed
+// read keeps the last known rules.
+func (cfg *apiConfig) syncAutomodEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := cfg.syncAutomod(ctx)
+			if err != nil {
+				log.Printf("Error reading automod rules: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Applies an edit on this instance right away; others pick it up with syncAutomodEvery
+func (cfg *apiConfig) automodChanged(ctx context.Context) {
+	_, err := cfg.syncAutomod(ctx)
+	if err != nil {
+		log.Printf("Error reading automod rules: %s", err.Error())
+	}
+}
+
+// Gathers what the rules of engine look at about the author of a chirp: their account
+// age and how many chirps they posted within each velocity window. Only what some rule
+// needs is looked up.
+func (cfg *apiConfig) automodInput(ctx context.Context, engine *automod.Engine, userID uuid.UUID, body string) (automod.Input, error) {
+	in := automod.Input{Body: body, Velocity: map[time.Duration]int64{}}
+	now := time.Now().UTC()
+	if engine.NeedsAccountAge() {
+		users, err := cfg.store.GetUsersByIds(ctx, database.GetUsersByIdsParams{TenantID: tenantID(ctx), Ids: userID.String()})
+		if err != nil {
+			return in, err
+		}
+		if len(users) == 0 {
+			return in, errOtherTenant
+		}
+		in.AccountAge = now.Sub(users[0].CreatedAt)
+	}
+	for _, window := range engine.Windows() {
+		count, err := cfg.countChirpsSince(ctx, userID, now.Add(-window))
+		if err != nil {
+			return in, err
+		}
+		in.Velocity[window] = count
+	}
+	return in, nil
+}
+
+// Counts the chirps userID posted since since
+func (cfg *apiConfig) countChirpsSince(ctx context.Context, userID uuid.UUID, since time.Time) (int64, error) {
+	return cfg.store.CountChirpsMatching(ctx, database.CountChirpsMatchingParams{
+		CreatedAfter:  since,
+		CreatedBefore: time.Now().UTC(),
+		UserID:        userID,
+		TenantID:      tenantID(ctx),
+	})
+}
+
+// Returns a *quotaExceededError when a tighten_rate_limit rule limited how often userID
+// may post and they have posted as often as it allows
+func (cfg *apiConfig) checkAutomodLimit(ctx context.Context, userID uuid.UUID) error {
+	now := time.Now().UTC()
+	limit, err := cfg.store.GetAutomodRestriction(ctx, database.GetAutomodRestrictionParams{UserID: userID, ExpiresAt: now})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	window := time.Duration(limit.WindowSeconds) * time.Second
+	used, err := cfg.countChirpsSince(ctx, userID, now.Add(-window))
+	if err != nil {
+		return err
+	}
+	if used < int64(limit.MaxChirps) {
+		return nil
+	}
+	// the window slides, so it frees up a chirp a window from now at the latest
+	resetsAt := now.Add(window)
+	if limit.ExpiresAt.Before(resetsAt) {
+		resetsAt = limit.ExpiresAt
+	}
+	remaining := int64(0)
+	return &quotaExceededError{quotaUsage{
+		Name:      automodLimitQuota,
+		Limit:     int64(limit.MaxChirps),
+		Used:      used,
+		Remaining: &remaining,
+		ResetsAt:  resetsAt,
+	}}
+}
+
+// Records the reports and posting limit the auto-moderation result asks for, in the
+// transaction that creates the chirp
+func recordAutomodResult(ctx context.Context, tx store.Store, chirp database.Chirp, res automod.Result) (*database.AutomodRestriction, error) {
+	if res.Action == automod.ActionFlag || res.Action == automod.ActionHold {
+		reason := automodFlagReason
+		if res.Action == automod.ActionHold {
+			reason = automodHoldReason
+		}
+		_, err := tx.CreateChirpReport(ctx, database.CreateChirpReportParams{
+			ChirpID:  chirp.ID,
+			AuthorID: chirp.UserID,
+			TenantID: chirp.TenantID,
+			Reason:   reason,
+			Details:  automodDetails(res.Matches),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if res.Restriction == nil {
+		return nil, nil
+	}
+	var ruleID uuid.UUID
+	for _, match := range res.Matches {
+		if match.Rule.Action == automod.ActionTightenRateLimit {
+			ruleID, _ = uuid.Parse(match.Rule.ID)
+			break
+		}
+	}
+	limit, err := tx.SetAutomodRestriction(ctx, database.SetAutomodRestrictionParams{
+		UserID:        chirp.UserID,
+		ExpiresAt:     time.Now().UTC().Add(res.Restriction.Duration),
+		MaxChirps:     int32(res.Restriction.MaxChirps),
+		WindowSeconds: int32(res.Restriction.Window.Seconds()),
+		RuleID:        ruleID,
+	})
+	return &limit, err
+}
+
+// Describes the rules that matched a chirp, for the details of its report
+func automodDetails(matches []automod.Match) string {
+	matched := make([]string, 0, len(matches))
+	for _, match := range matches {
+		matched = append(matched, fmt.Sprintf("%q v%d (%s)", match.Rule.Name, match.Rule.Version, strings.Join(match.Reasons, ", ")))
+	}
+	return "matched " + strings.Join(matched, "; ")
+}
+
+type automodLimitResponse struct {
+	UserID    uuid.UUID `json:"user_id"`
+	RuleID    uuid.UUID `json:"rule_id"`
+	MaxChirps int32     `json:"max_chirps"`
+	Window    string    `json:"window"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Audits the posting limit a rule put on the author of a chirp
+func (cfg *apiConfig) auditAutomodLimit(ctx context.Context, limit database.AutomodRestriction) {
+	writeAudit(ctx, cfg.store, database.CreateAuditEntryParams{
+		Actor:     automodActor,
+		Action:    "automod.limit",
+		Target:    limit.UserID.String(),
+		RequestID: requestID(ctx),
+	}, automodLimitResponse{
+		UserID:    limit.UserID,
+		RuleID:    limit.RuleID,
+		MaxChirps: limit.MaxChirps,
+		Window:    (time.Duration(limit.WindowSeconds) * time.Second).String(),
+		ExpiresAt: limit.ExpiresAt,
+	})
+}
+
+// Decodes and checks the rule in the request body, answering 400 when it is not valid
+func decodeAutomodRule(w http.ResponseWriter, r *http.Request) (automodRuleRequest, string, bool) {
+	params := automodRuleRequest{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return params, "", false
+	}
+	def := params.definition()
+	err = automod.Compile(def)
+	if err != nil {
+		marshallError(w, &apiError{Code: "invalid_rule", Message: err.Error()}, 400)
+		return params, "", false
+	}
+	if params.Enabled == nil {
+		enabled := true
+		params.Enabled = &enabled
+	}
+	dat, err := json.Marshal(def)
+	if err != nil {
+		marshallError(w, err, 500)
+		return params, "", false
+	}
+	return params, string(dat), true
+}
+
+// Lists the auto-moderation rules, oldest first
+func (cfg *apiConfig) handlerListAutomodRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := cfg.syncAutomod(r.Context())
+	if err != nil {
+		log.Printf("Error reading automod rules: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]automodRuleResponse, 0, len(rules))
+	for _, rule := range rules {
+		resp = append(resp, newAutomodRuleResponse(rule))
+	}
+	render(w, r, 200, resp)
+}
+
+// Adds an auto-moderation rule as its version 1, evaluated on chirps posted from now on
+func (cfg *apiConfig) handlerCreateAutomodRule(w http.ResponseWriter, r *http.Request) {
+	params, def, ok := decodeAutomodRule(w, r)
+	if !ok {
+		return
+	}
+	var rule database.AutomodRule
+	err := cfg.store.WithTx(r.Context(), func(tx store.Store) error {
+		var err error
+		rule, err = tx.CreateAutomodRule(r.Context(), database.CreateAutomodRuleParams{Name: params.Name, Enabled: *params.Enabled, Definition: def})
+		if err != nil {
+			return err
+		}
+		return saveAutomodRuleVersion(r, tx, rule)
+	})
+	if err != nil {
+		log.Printf("Error creating automod rule: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.automodChanged(r.Context())
+	cfg.recordAudit(r, "automod_rule.create", rule.ID.String(), newAutomodRuleResponse(rule))
+	log.Printf("Automod rule %s created: %q -> %s", rule.ID, rule.Name, params.Action)
+	render(w, r, 201, newAutomodRuleResponse(rule))
+}
+
+// Replaces the auto-moderation rule with the id in the path as its next version
+func (cfg *apiConfig) handlerUpdateAutomodRule(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("ruleID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid automod rule id"), 400)
+		return
+	}
+	params, def, ok := decodeAutomodRule(w, r)
+	if !ok {
+		return
+	}
+	cfg.updateAutomodRule(w, r, database.UpdateAutomodRuleParams{ID: id, Name: params.Name, Enabled: *params.Enabled, Definition: def}, "automod_rule.update")
+}
+
+// Makes an earlier version of the rule in the path its next version, so the restore
+// itself can be undone the same way
+func (cfg *apiConfig) handlerRestoreAutomodRule(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("ruleID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid automod rule id"), 400)
+		return
+	}
+	number, err := strconv.ParseInt(r.PathValue("version"), 10, 32)
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid version"), 400)
+		return
+	}
+	version, err := cfg.store.GetAutomodRuleVersion(r.Context(), database.GetAutomodRuleVersionParams{RuleID: id, Version: int32(number)})
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("version %d of automod rule %s not found", number, id), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading automod rule version: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.updateAutomodRule(w, r, database.UpdateAutomodRuleParams{ID: id, Name: version.Name, Enabled: version.Enabled, Definition: version.Definition}, "automod_rule.restore")
+}
+
+// Stores a new version of a rule and answers with it, or 404 when the rule does not exist
+func (cfg *apiConfig) updateAutomodRule(w http.ResponseWriter, r *http.Request, arg database.UpdateAutomodRuleParams, action string) {
+	var rule database.AutomodRule
+	err := cfg.store.WithTx(r.Context(), func(tx store.Store) error {
+		var err error
+		rule, err = tx.UpdateAutomodRule(r.Context(), arg)
+		if err != nil {
+			return err
+		}
+		return saveAutomodRuleVersion(r, tx, rule)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("automod rule %s not found", arg.ID), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating automod rule: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.automodChanged(r.Context())
+	cfg.recordAudit(r, action, rule.ID.String(), newAutomodRuleResponse(rule))
+	log.Printf("Automod rule %s is now version %d", rule.ID, rule.Version)
+	render(w, r, 200, newAutomodRuleResponse(rule))
+}
+
+// Records rule as it now is in its version history
+func saveAutomodRuleVersion(r *http.Request, tx store.Store, rule database.AutomodRule) error {
+	_, err := tx.CreateAutomodRuleVersion(r.Context(), database.CreateAutomodRuleVersionParams{
+		RuleID:     rule.ID,
+		Version:    rule.Version,
+		Name:       rule.Name,
+		Enabled:    rule.Enabled,
+		Definition: rule.Definition,
+		CreatedBy:  auditActor(r),
+	})
+	return err
+}
+
+// Deletes the auto-moderation rule with the id in the path along with its versions.
+// Posting limits it put on users stay until they expire.
+func (cfg *apiConfig) handlerDeleteAutomodRule(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("ruleID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid automod rule id"), 400)
+		return
+	}
+	n, err := cfg.store.DeleteAutomodRule(r.Context(), id)
+	if err != nil {
+		log.Printf("Error deleting automod rule: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if n == 0 {
+		marshallError(w, fmt.Errorf("automod rule %s not found", id), 404)
+		return
+	}
+	cfg.automodChanged(r.Context())
+	cfg.recordAudit(r, "automod_rule.delete", id.String(), nil)
+	log.Printf("Automod rule %s deleted", id)
+	w.WriteHeader(204)
+}
+
+// Lists every version of the rule in the path, the current one first
+func (cfg *apiConfig) handlerListAutomodRuleVersions(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("ruleID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid automod rule id"), 400)
+		return
+	}
+	versions, err := cfg.store.ListAutomodRuleVersions(r.Context(), id)
+	if err != nil {
+		log.Printf("Error reading automod rule versions: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if len(versions) == 0 {
+		marshallError(w, fmt.Errorf("automod rule %s not found", id), 404)
+		return
+	}
+	resp := make([]automodRuleVersionResponse, 0, len(versions))
+	for _, version := range versions {
+		resp = append(resp, newAutomodRuleVersionResponse(version))
+	}
+	render(w, r, 200, resp)
+}
+
+type automodMatchResponse struct {
+	RuleID  string         `json:"rule_id,omitempty"`
+	Name    string         `json:"name"`
+	Version int            `json:"version,omitempty"`
+	Action  automod.Action `json:"action"`
+	Reasons []string       `json:"reasons"`
+}
+
+type automodDryRunResponse struct {
+	// flag, hold or reject, null when the chirp would be posted as it is
+	Action  *automod.Action        `json:"action"`
+	Matches []automodMatchResponse `json:"matches"`
+	// the posting limit the author would get, null when none
+	Limit      *automod.Tighten `json:"limit"`
+	AccountAge string           `json:"account_age"`
+	Velocity   map[string]int64 `json:"velocity"`
+}
+
+// Evaluates the rules against a chirp without posting it or recording anything. The
+// rules in the body are tried instead of the enabled ones when given, so a rule can be
+// tested before it is saved. With a user_id of the request's tenant, the author's
+// account age and velocity are theirs; without, those of a new account with no chirps.
+func (cfg *apiConfig) handlerAutomodDryRun(w http.ResponseWriter, r *http.Request) {
+	type rule struct {
+		Name       string              `json:"name" validate:"max=200"`
+		Conditions []automod.Condition `json:"conditions"`
+		Action     string              `json:"action" validate:"required,oneof=flag hold reject tighten_rate_limit"`
+		Tighten    *automod.Tighten    `json:"tighten"`
+	}
+	type parameters struct {
+		Body   string     `json:"body" validate:"required"`
+		UserID *uuid.UUID `json:"user_id"`
+		Rules  []rule     `json:"rules"`
+	}
+	params := parameters{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	engine := cfg.automod.Load()
+	if params.Rules != nil {
+		rules := make([]automod.Rule, 0, len(params.Rules))
+		for i, dry := range params.Rules {
+			def := automod.Definition{Conditions: dry.Conditions, Action: automod.Action(dry.Action), Tighten: dry.Tighten}
+			if err := automod.Compile(def); err != nil {
+				marshallError(w, &apiError{Code: "invalid_rule", Message: fmt.Sprintf("rule %d: %s", i+1, err.Error())}, 400)
+				return
+			}
+			rules = append(rules, automod.Rule{Name: dry.Name, Definition: def})
+		}
+		engine, err = automod.NewEngine(rules)
+		if err != nil {
+			marshallError(w, err, 500)
+			return
+		}
+	}
+	in := automod.Input{Body: params.Body, Velocity: map[time.Duration]int64{}}
+	if params.UserID != nil {
+		in, err = cfg.automodInput(r.Context(), engine, *params.UserID, params.Body)
+		if errors.Is(err, errOtherTenant) {
+			marshallError(w, fmt.Errorf("user %s not found", *params.UserID), 404)
+			return
+		}
+		if err != nil {
+			log.Printf("Error reading automod input: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+	}
+	res := engine.Evaluate(in)
+	resp := automodDryRunResponse{
+		Matches:    []automodMatchResponse{},
+		AccountAge: in.AccountAge.Round(time.Second).String(),
+		Velocity:   map[string]int64{},
+	}
+	if res.Action != "" {
+		resp.Action = &res.Action
+	}
+	for _, match := range res.Matches {
+		resp.Matches = append(resp.Matches, automodMatchResponse{
+			RuleID:  match.Rule.ID,
+			Name:    match.Rule.Name,
+			Version: match.Rule.Version,
+			Action:  match.Rule.Action,
+			Reasons: match.Reasons,
+		})
+	}
+	if res.Restriction != nil {
+		resp.Limit = &automod.Tighten{
+			MaxChirps: res.Restriction.MaxChirps,
+			Window:    res.Restriction.Window.String(),
+			Duration:  res.Restriction.Duration.String(),
+		}
+	}
+	for window, count := range in.Velocity {
+		resp.Velocity[window.String()] = count
+	}
+	render(w, r, 200, resp)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type jobResponse struct {
+	ID          uuid.UUID       `json:"id"`
+	Kind        string          `json:"kind"`
+	Status      string          `json:"status"`
+	Attempts    int32           `json:"attempts"`
+	MaxAttempts int32           `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"`
+	Progress    json.RawMessage `json:"progress,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+func newJobResponse(job database.Job) jobResponse {
+	resp := jobResponse{
+		ID:          job.ID,
+		Kind:        job.Kind,
+		Status:      job.Status,
+		Attempts:    job.Attempts,
+		MaxAttempts: job.MaxAttempts,
+		RunAt:       job.RunAt,
+		LastError:   job.LastError,
+		CreatedAt:   job.CreatedAt,
+		UpdatedAt:   job.UpdatedAt,
+	}
+	if job.Payload != "" {
+		resp.Payload = json.RawMessage(job.Payload)
+	}
+	if job.Progress != "" {
+		resp.Progress = json.RawMessage(job.Progress)
+	}
+	return resp
+}
+
+// Lists background jobs in one state, ?status=dead by default so failures are easy to find
+func (cfg *apiConfig) handlerListJobs(w http.ResponseWriter, r *http.Request) {
+	type query struct {
+		Status string `query:"status" validate:"oneof=pending running done dead"`
+		Limit  int32  `query:"limit" validate:"min=1,max=1000"`
+	}
+	params := query{Status: "dead", Limit: 100}
+	err := decodeQuery(r, &params)
+	if err != nil {
+		marshallError(w, err, 400)
+		return
+	}
+	rows, err := cfg.store.ListJobsByStatus(r.Context(), database.ListJobsByStatusParams{Status: params.Status, Limit: params.Limit})
+	if err != nil {
+		log.Printf("Error listing jobs: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]jobResponse, 0, len(rows))
+	for _, job := range rows {
+		resp = append(resp, newJobResponse(job))
+	}
+	render(w, r, 200, resp)
+}
+
+// Shows one background job, including the progress a long one reports
+func (cfg *apiConfig) handlerGetJob(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid job id"), 400)
+		return
+	}
+	job, err := cfg.store.GetJob(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("no job with id %s", id), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting job: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	render(w, r, 200, newJobResponse(job))
+}
+
+// Gives a dead job a fresh set of attempts
+func (cfg *apiConfig) handlerRetryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid job id"), 400)
+		return
+	}
+	job, err := cfg.store.RequeueDeadJob(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("no dead job with id %s", id), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error requeueing job: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.recordAudit(r, "job.retry", job.ID.String(), map[string]string{"kind": job.Kind})
+	render(w, r, 200, newJobResponse(job))
+}
@@ -0,0 +1,759 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/google/uuid"
+)
+
+const (
+	backupFormat  = "chirpy-backup"
+	backupVersion = 1
+	// rows read per query while writing a backup
+	backupPageSize = 500
+	// table of the line that closes a backup
+	backupEnd = "end"
+)
+
+// Tables in a backup, in the order they are written and restored so every row comes
+// after the rows it refers to
+var backupTables = []string{
+	"tenants", "users", "chirps", "webhooks", "shadowbans",
+	"automod_rules", "automod_rule_versions", "automod_restrictions", "automod_holds",
+	"chirp_favourites", "activitypub_keys", "activitypub_followers", "word_filters", "ip_bans",
+	"api_keys", "oauth_apps", "oauth_tokens", "digest_subscriptions",
+}
+
+// First line of a backup
+type backupHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Every line after the header holds one row of a table. The last one has table "end"
+// and the number of rows written per table, so a backup cut short is refused.
+type backupLine struct {
+	Table  string           `json:"table"`
+	Row    json.RawMessage  `json:"row,omitempty"`
+	Counts map[string]int64 `json:"counts,omitempty"`
+}
+
+// Rows as they appear in a backup. Each has the fields of its database struct in the
+// same order, so the two convert into each other.
+type backupTenant struct {
+	ID             uuid.UUID `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Slug           string    `json:"slug"`
+	Name           string    `json:"name"`
+	AdminTokenHash string    `json:"admin_token_hash"`
+	MaxUsers       int32     `json:"max_users"`
+}
+
+type backupUser struct {
+	ID             uuid.UUID `json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	Email          string    `json:"email"`
+	HashedPassword string    `json:"hashed_password"`
+	IsChirpyRed    bool      `json:"is_chirpy_red"`
+	TenantID       uuid.UUID `json:"tenant_id"`
+}
+
+type backupChirp struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Body      string    `json:"body"`
+	UserID    uuid.UUID `json:"user_id"`
+	TenantID  uuid.UUID `json:"tenant_id"`
+}
+
+type backupWebhook struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	Url       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    string    `json:"events"`
+	Active    bool      `json:"active"`
+}
+
+type backupShadowban struct {
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	TenantID  uuid.UUID `json:"tenant_id"`
+	Reason    string    `json:"reason"`
+	BannedBy  string    `json:"banned_by"`
+}
+
+type backupAutomodRule struct {
+	ID         uuid.UUID `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	Name       string    `json:"name"`
+	Version    int32     `json:"version"`
+	Enabled    bool      `json:"enabled"`
+	Definition string    `json:"definition"`
+}
+
+type backupAutomodRuleVersion struct {
+	RuleID     uuid.UUID `json:"rule_id"`
+	Version    int32     `json:"version"`
+	CreatedAt  time.Time `json:"created_at"`
+	Name       string    `json:"name"`
+	Enabled    bool      `json:"enabled"`
+	Definition string    `json:"definition"`
+	CreatedBy  string    `json:"created_by"`
+}
+
+type backupAutomodRestriction struct {
+	UserID        uuid.UUID `json:"user_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	MaxChirps     int32     `json:"max_chirps"`
+	WindowSeconds int32     `json:"window_seconds"`
+	RuleID        uuid.UUID `json:"rule_id"`
+}
+
+// An open automod_hold or word_filter report, which keeps its chirp hidden until a
+// moderator decides on it. Other reports are left out, so only the fields a hold has
+// are kept. Backups from before word_filter holds have no reason.
+type backupAutomodHold struct {
+	ID          uuid.UUID  `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ChirpID     uuid.UUID  `json:"chirp_id"`
+	AuthorID    uuid.UUID  `json:"author_id"`
+	TenantID    uuid.UUID  `json:"tenant_id"`
+	Reason      string     `json:"reason,omitempty"`
+	Details     string     `json:"details"`
+	EscalatedAt *time.Time `json:"escalated_at,omitempty"`
+}
+
+func newBackupAutomodHold(report database.ChirpReport) backupAutomodHold {
+	hold := backupAutomodHold{
+		ID:        report.ID,
+		CreatedAt: report.CreatedAt,
+		ChirpID:   report.ChirpID,
+		AuthorID:  report.AuthorID,
+		TenantID:  report.TenantID,
+		Reason:    report.Reason,
+		Details:   report.Details,
+	}
+	if report.EscalatedAt.Valid {
+		hold.EscalatedAt = &report.EscalatedAt.Time
+	}
+	return hold
+}
+
+func (hold backupAutomodHold) restoreParams() database.RestoreChirpReportParams {
+	params := database.RestoreChirpReportParams{
+		ID:        hold.ID,
+		CreatedAt: hold.CreatedAt,
+		ChirpID:   hold.ChirpID,
+		AuthorID:  hold.AuthorID,
+		TenantID:  hold.TenantID,
+		Reason:    cmp.Or(hold.Reason, automodHoldReason),
+		Details:   hold.Details,
+	}
+	if hold.EscalatedAt != nil {
+		params.EscalatedAt = sql.NullTime{Time: *hold.EscalatedAt, Valid: true}
+	}
+	return params
+}
+
+type backupChirpFavourite struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type backupActivityPubKey struct {
+	UserID        uuid.UUID `json:"user_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	PublicKeyPem  string    `json:"public_key_pem"`
+	PrivateKeyPem string    `json:"private_key_pem"`
+}
+
+type backupActivityPubFollower struct {
+	UserID      uuid.UUID `json:"user_id"`
+	ActorID     string    `json:"actor_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Inbox       string    `json:"inbox"`
+	SharedInbox string    `json:"shared_inbox"`
+	FollowID    string    `json:"follow_id"`
+}
+
+type backupWordFilter struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Pattern   string    `json:"pattern"`
+	Kind      string    `json:"kind"`
+	Action    string    `json:"action"`
+	Note      string    `json:"note"`
+}
+
+// An IP ban that has not expired. Bans without an expiry have no expires_at.
+type backupIPBan struct {
+	ID        uuid.UUID  `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Cidr      string     `json:"cidr"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedBy string     `json:"created_by"`
+}
+
+func newBackupIPBan(ban database.IpBan) backupIPBan {
+	row := backupIPBan{ID: ban.ID, CreatedAt: ban.CreatedAt, Cidr: ban.Cidr, Reason: ban.Reason, CreatedBy: ban.CreatedBy}
+	if ban.ExpiresAt.Valid {
+		row.ExpiresAt = &ban.ExpiresAt.Time
+	}
+	return row
+}
+
+func (ban backupIPBan) restoreParams() database.RestoreIPBanParams {
+	params := database.RestoreIPBanParams{ID: ban.ID, CreatedAt: ban.CreatedAt, Cidr: ban.Cidr, Reason: ban.Reason, CreatedBy: ban.CreatedBy}
+	if ban.ExpiresAt != nil {
+		params.ExpiresAt = sql.NullTime{Time: *ban.ExpiresAt, Valid: true}
+	}
+	return params
+}
+
+// An API key as it is stored, with only the hash of the key. Keys never used have no
+// last_used_at.
+type backupAPIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uuid.UUID  `json:"user_id"`
+	TenantID   uuid.UUID  `json:"tenant_id"`
+	Name       string     `json:"name"`
+	KeyHash    string     `json:"key_hash"`
+	Prefix     string     `json:"prefix"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+func newBackupAPIKey(key database.ApiKey) backupAPIKey {
+	row := backupAPIKey{
+		ID:        key.ID,
+		CreatedAt: key.CreatedAt,
+		UserID:    key.UserID,
+		TenantID:  key.TenantID,
+		Name:      key.Name,
+		KeyHash:   key.KeyHash,
+		Prefix:    key.Prefix,
+	}
+	if key.LastUsedAt.Valid {
+		row.LastUsedAt = &key.LastUsedAt.Time
+	}
+	return row
+}
+
+func (key backupAPIKey) restoreParams() database.RestoreAPIKeyParams {
+	params := database.RestoreAPIKeyParams{
+		ID:        key.ID,
+		CreatedAt: key.CreatedAt,
+		UserID:    key.UserID,
+		TenantID:  key.TenantID,
+		Name:      key.Name,
+		KeyHash:   key.KeyHash,
+		Prefix:    key.Prefix,
+	}
+	if key.LastUsedAt != nil {
+		params.LastUsedAt = sql.NullTime{Time: *key.LastUsedAt, Valid: true}
+	}
+	return params
+}
+
+type backupOAuthApp struct {
+	ID           uuid.UUID `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	ClientID     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret"`
+	Name         string    `json:"name"`
+	Website      string    `json:"website"`
+	RedirectUris string    `json:"redirect_uris"`
+	Scopes       string    `json:"scopes"`
+}
+
+// An OAuth access token, with only its hash as the table stores it
+type backupOAuthToken struct {
+	TokenHash string    `json:"token_hash"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uuid.UUID `json:"user_id"`
+	ClientID  string    `json:"client_id"`
+	Scopes    string    `json:"scopes"`
+}
+
+type backupDigestSubscription struct {
+	UserID     uuid.UUID `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSentAt time.Time `json:"last_sent_at"`
+}
+
+var errRestoreNotEmpty = &apiError{
+	Code:    "database_not_empty",
+	Message: "restore only loads into a database without users, POST /admin/reset?confirm=true empties it",
+}
+
+func invalidBackupError(format string, args ...any) *apiError {
+	return &apiError{Code: "invalid_backup", Message: fmt.Sprintf(format, args...)}
+}
+
+// Admin endpoint that streams a logical backup of every tenant, user, chirp, webhook and
+// shadowban, the auto-moderation rules and what they did to users and chirps, favourites,
+// ActivityPub keys and followers, word filters, IP bans, API keys, OAuth apps and tokens
+// and digest subscriptions, as newline delimited JSON. The rows are read in one
+// snapshot, so the backup is consistent while the server keeps taking writes. Refresh
+// tokens are left out, every user logs in again after a restore, and so are logs and
+// queues.
+func (cfg *apiConfig) handlerBackup(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	now := time.Now().UTC()
+	// a large backup takes longer to send than SERVER_WRITE_TIMEOUT allows
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	out := bufio.NewWriter(w)
+	counts := make(map[string]int64, len(backupTables))
+	err := cfg.store.Snapshot(ctx, func(tx store.Store) error {
+		// headers are only set once the database answered, so a failure to read it is
+		// still answered with an error status
+		tenants, err := tx.ListTenants(ctx)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-backup-%s.ndjson"`, now.Format("20060102-150405")))
+		enc := json.NewEncoder(out)
+		err = enc.Encode(backupHeader{Format: backupFormat, Version: backupVersion, CreatedAt: now})
+		if err != nil {
+			return err
+		}
+		for _, tenant := range tenants {
+			err = writeBackupRow(enc, "tenants", backupTenant(tenant))
+			if err != nil {
+				return err
+			}
+		}
+		counts["tenants"] = int64(len(tenants))
+		counts["users"], err = writeBackupTable(enc, "users", func(after uuid.UUID) ([]database.User, error) {
+			return tx.ListUsersAfter(ctx, database.ListUsersAfterParams{ID: after, Limit: backupPageSize})
+		}, func(user database.User) (uuid.UUID, any) { return user.ID, backupUser(user) })
+		if err != nil {
+			return err
+		}
+		counts["chirps"], err = writeBackupTable(enc, "chirps", func(after uuid.UUID) ([]database.Chirp, error) {
+			return tx.ListChirpsAfter(ctx, database.ListChirpsAfterParams{ID: after, Limit: backupPageSize})
+		}, func(chirp database.Chirp) (uuid.UUID, any) { return chirp.ID, backupChirp(chirp) })
+		if err != nil {
+			return err
+		}
+		counts["webhooks"], err = writeBackupTable(enc, "webhooks", func(after uuid.UUID) ([]database.Webhook, error) {
+			return tx.ListWebhooksAfter(ctx, database.ListWebhooksAfterParams{ID: after, Limit: backupPageSize})
+		}, func(webhook database.Webhook) (uuid.UUID, any) { return webhook.ID, backupWebhook(webhook) })
+		if err != nil {
+			return err
+		}
+		// shadowbans are listed whole, as the admin endpoint does
+		bans, err := tx.ListShadowbans(ctx)
+		if err != nil {
+			return err
+		}
+		for _, ban := range bans {
+			err = writeBackupRow(enc, "shadowbans", backupShadowban(ban))
+			if err != nil {
+				return err
+			}
+		}
+		counts["shadowbans"] = int64(len(bans))
+		// rules are few, like tenants, and written whole with their versions
+		rules, err := tx.ListAutomodRules(ctx)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			err = writeBackupRow(enc, "automod_rules", backupAutomodRule(rule))
+			if err != nil {
+				return err
+			}
+		}
+		counts["automod_rules"] = int64(len(rules))
+		counts["automod_rule_versions"] = 0
+		for _, rule := range rules {
+			versions, err := tx.ListAutomodRuleVersions(ctx, rule.ID)
+			if err != nil {
+				return err
+			}
+			for _, version := range versions {
+				err = writeBackupRow(enc, "automod_rule_versions", backupAutomodRuleVersion(version))
+				if err != nil {
+					return err
+				}
+			}
+			counts["automod_rule_versions"] += int64(len(versions))
+		}
+		counts["automod_restrictions"], err = writeBackupTable(enc, "automod_restrictions", func(after uuid.UUID) ([]database.AutomodRestriction, error) {
+			return tx.ListAutomodRestrictionsAfter(ctx, database.ListAutomodRestrictionsAfterParams{UserID: after, Limit: backupPageSize})
+		}, func(restriction database.AutomodRestriction) (uuid.UUID, any) {
+			return restriction.UserID, backupAutomodRestriction(restriction)
+		})
+		if err != nil {
+			return err
+		}
+		// without its hold, a held chirp would be public after a restore
+		counts["automod_holds"], err = writeBackupTable(enc, "automod_holds", func(after uuid.UUID) ([]database.ChirpReport, error) {
+			return tx.ListAutomodHoldsAfter(ctx, database.ListAutomodHoldsAfterParams{ID: after, Limit: backupPageSize})
+		}, func(report database.ChirpReport) (uuid.UUID, any) { return report.ID, newBackupAutomodHold(report) })
+		if err != nil {
+			return err
+		}
+		// favourites and followers have two column keys, so a page starts after a whole row
+		counts["chirp_favourites"], err = writeBackupTable(enc, "chirp_favourites", func(after database.ChirpFavourite) ([]database.ChirpFavourite, error) {
+			return tx.ListChirpFavouritesAfter(ctx, database.ListChirpFavouritesAfterParams{UserID: after.UserID, ChirpID: after.ChirpID, RowLimit: backupPageSize})
+		}, func(favourite database.ChirpFavourite) (database.ChirpFavourite, any) {
+			return favourite, backupChirpFavourite(favourite)
+		})
+		if err != nil {
+			return err
+		}
+		// without their keys, remote servers could no longer verify what users send them
+		counts["activitypub_keys"], err = writeBackupTable(enc, "activitypub_keys", func(after uuid.UUID) ([]database.ActivitypubKey, error) {
+			return tx.ListActivityPubKeysAfter(ctx, database.ListActivityPubKeysAfterParams{UserID: after, Limit: backupPageSize})
+		}, func(key database.ActivitypubKey) (uuid.UUID, any) { return key.UserID, backupActivityPubKey(key) })
+		if err != nil {
+			return err
+		}
+		counts["activitypub_followers"], err = writeBackupTable(enc, "activitypub_followers", func(after database.ActivitypubFollower) ([]database.ActivitypubFollower, error) {
+			return tx.ListActivityPubFollowersAfter(ctx, database.ListActivityPubFollowersAfterParams{UserID: after.UserID, ActorID: after.ActorID, RowLimit: backupPageSize})
+		}, func(follower database.ActivitypubFollower) (database.ActivitypubFollower, any) {
+			return follower, backupActivityPubFollower(follower)
+		})
+		if err != nil {
+			return err
+		}
+		// word filters and IP bans are few and listed whole, as their admin endpoints do.
+		// Expired bans are left out, the prune_ip_bans task would delete them anyway.
+		filters, err := tx.GetWordFilters(ctx)
+		if err != nil {
+			return err
+		}
+		for _, filter := range filters {
+			err = writeBackupRow(enc, "word_filters", backupWordFilter(filter))
+			if err != nil {
+				return err
+			}
+		}
+		counts["word_filters"] = int64(len(filters))
+		ipBans, err := tx.ListActiveIPBans(ctx)
+		if err != nil {
+			return err
+		}
+		for _, ban := range ipBans {
+			err = writeBackupRow(enc, "ip_bans", newBackupIPBan(ban))
+			if err != nil {
+				return err
+			}
+		}
+		counts["ip_bans"] = int64(len(ipBans))
+		counts["api_keys"], err = writeBackupTable(enc, "api_keys", func(after uuid.UUID) ([]database.ApiKey, error) {
+			return tx.ListAPIKeysAfter(ctx, database.ListAPIKeysAfterParams{ID: after, Limit: backupPageSize})
+		}, func(key database.ApiKey) (uuid.UUID, any) { return key.ID, newBackupAPIKey(key) })
+		if err != nil {
+			return err
+		}
+		counts["oauth_apps"], err = writeBackupTable(enc, "oauth_apps", func(after uuid.UUID) ([]database.OauthApp, error) {
+			return tx.ListOAuthAppsAfter(ctx, database.ListOAuthAppsAfterParams{ID: after, Limit: backupPageSize})
+		}, func(app database.OauthApp) (uuid.UUID, any) { return app.ID, backupOAuthApp(app) })
+		if err != nil {
+			return err
+		}
+		// authorization codes live for minutes and are left out, tokens keep apps signed in
+		counts["oauth_tokens"], err = writeBackupTable(enc, "oauth_tokens", func(after string) ([]database.OauthToken, error) {
+			return tx.ListOAuthTokensAfter(ctx, database.ListOAuthTokensAfterParams{TokenHash: after, Limit: backupPageSize})
+		}, func(token database.OauthToken) (string, any) { return token.TokenHash, backupOAuthToken(token) })
+		if err != nil {
+			return err
+		}
+		counts["digest_subscriptions"], err = writeBackupTable(enc, "digest_subscriptions", func(after uuid.UUID) ([]database.DigestSubscription, error) {
+			return tx.ListDigestSubscriptionsAfter(ctx, database.ListDigestSubscriptionsAfterParams{UserID: after, Limit: backupPageSize})
+		}, func(sub database.DigestSubscription) (uuid.UUID, any) {
+			return sub.UserID, backupDigestSubscription(sub)
+		})
+		if err != nil {
+			return err
+		}
+		err = enc.Encode(backupLine{Table: backupEnd, Counts: counts})
+		if err != nil {
+			return err
+		}
+		return out.Flush()
+	})
+	if err != nil {
+		log.Printf("Error writing backup: %s", err.Error())
+		if w.Header().Get("Content-Type") == "" {
+			marshallError(w, err, 500)
+		}
+		// otherwise part of the backup is out, and the missing end line marks it as unusable
+		return
+	}
+	cfg.recordAudit(r, "backup.create", "", counts)
+}
+
+// Helper function to write every row of a table, a page at a time in key order. list
+// returns the page after a key, starting from the zero key, and row gives the key of a
+// row and what to write for it.
+func writeBackupTable[T, K any](enc *json.Encoder, table string, list func(after K) ([]T, error), row func(T) (K, any)) (int64, error) {
+	var written int64
+	var after K
+	for {
+		page, err := list(after)
+		if err != nil {
+			return written, err
+		}
+		for _, item := range page {
+			key, value := row(item)
+			err = writeBackupRow(enc, table, value)
+			if err != nil {
+				return written, err
+			}
+			after = key
+			written++
+		}
+		if len(page) < backupPageSize {
+			return written, nil
+		}
+	}
+}
+
+func writeBackupRow(enc *json.Encoder, table string, row any) error {
+	dat, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(backupLine{Table: table, Row: dat})
+}
+
+// Admin endpoint that loads a backup from POST /admin/backup into a database without
+// users, such as a new one or one emptied with POST /admin/reset. Every row is inserted
+// in one transaction, so a backup that turns out to be truncated or invalid part way
+// through leaves nothing behind. Tenants in the backup replace ones with the same id.
+func (cfg *apiConfig) handlerRestore(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	// a large backup takes longer to upload than SERVER_READ_TIMEOUT allows
+	http.NewResponseController(w).SetReadDeadline(time.Time{})
+	dec := json.NewDecoder(r.Body)
+	var header backupHeader
+	err := dec.Decode(&header)
+	if err != nil || header.Format != backupFormat {
+		marshallError(w, invalidBackupError("the request body is not a backup from POST /admin/backup"), 400)
+		return
+	}
+	if header.Version != backupVersion {
+		marshallError(w, invalidBackupError("backup format version %d is not supported, expected %d", header.Version, backupVersion), 400)
+		return
+	}
+	counts := make(map[string]int64, len(backupTables))
+	for _, table := range backupTables {
+		counts[table] = 0
+	}
+	err = cfg.store.WithTx(ctx, func(tx store.Store) error {
+		users, err := tx.ListUsersAfter(ctx, database.ListUsersAfterParams{ID: uuid.Nil, Limit: 1})
+		if err != nil {
+			return err
+		}
+		if len(users) > 0 {
+			return errRestoreNotEmpty
+		}
+		for line := 2; ; line++ {
+			var next backupLine
+			err := dec.Decode(&next)
+			var maxBytesErr *http.MaxBytesError
+			switch {
+			case errors.Is(err, io.EOF):
+				return invalidBackupError("the backup ends at line %d without its end line, it was cut short", line)
+			case errors.As(err, &maxBytesErr):
+				return err
+			case err != nil:
+				return invalidBackupError("line %d: %s", line, err.Error())
+			}
+			if next.Table == backupEnd {
+				// older backups do not count the tables added since, which they hold no rows of
+				restored := maps.Clone(counts)
+				maps.DeleteFunc(restored, func(table string, n int64) bool {
+					_, listed := next.Counts[table]
+					return !listed && n == 0
+				})
+				if !maps.Equal(next.Counts, restored) {
+					return invalidBackupError("the backup lists %v rows but holds %v", next.Counts, counts)
+				}
+				return nil
+			}
+			err = restoreBackupRow(ctx, tx, next)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			counts[next.Table]++
+		}
+	})
+	if errors.Is(err, errRestoreNotEmpty) {
+		marshallError(w, err, 409)
+		return
+	}
+	if err != nil {
+		log.Printf("Error restoring backup: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	// anything cached was read from the database before the restore
+	cfg.tenantCache.Purge()
+	cfg.purgeChirpCache(ctx)
+	// and the rules this instance applies are reloaded, other instances pick them up on
+	// their next sync
+	if _, err := cfg.syncShadowbans(ctx); err != nil {
+		log.Printf("Error loading shadowbans after restore: %s", err.Error())
+	}
+	if _, err := cfg.syncAutomod(ctx); err != nil {
+		log.Printf("Error loading automod rules after restore: %s", err.Error())
+	}
+	if _, err := cfg.syncWordFilters(ctx); err != nil {
+		log.Printf("Error loading word filters after restore: %s", err.Error())
+	}
+	if _, err := cfg.syncIPBans(ctx); err != nil {
+		log.Printf("Error loading IP bans after restore: %s", err.Error())
+	}
+	cfg.recordAudit(r, "backup.restore", "", map[string]any{"backup_created_at": header.CreatedAt, "counts": counts})
+	render(w, r, 200, map[string]any{"backup_created_at": header.CreatedAt, "restored": counts})
+}
+
+// Helper function to insert one row of a backup line
+func restoreBackupRow(ctx context.Context, tx store.Store, line backupLine) error {
+	switch line.Table {
+	case "tenants":
+		var row backupTenant
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid tenant: %s", err.Error())
+		}
+		return tx.RestoreTenant(ctx, database.RestoreTenantParams(row))
+	case "users":
+		var row backupUser
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid user: %s", err.Error())
+		}
+		return tx.RestoreUser(ctx, database.RestoreUserParams(row))
+	case "chirps":
+		var row backupChirp
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid chirp: %s", err.Error())
+		}
+		return tx.RestoreChirp(ctx, database.RestoreChirpParams(row))
+	case "webhooks":
+		// backups taken before webhooks could be paused have no active field
+		row := backupWebhook{Active: true}
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid webhook: %s", err.Error())
+		}
+		return tx.RestoreWebhook(ctx, database.RestoreWebhookParams(row))
+	case "shadowbans":
+		var row backupShadowban
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid shadowban: %s", err.Error())
+		}
+		return tx.RestoreShadowban(ctx, database.RestoreShadowbanParams(row))
+	case "automod_rules":
+		var row backupAutomodRule
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid automod rule: %s", err.Error())
+		}
+		return tx.RestoreAutomodRule(ctx, database.RestoreAutomodRuleParams(row))
+	case "automod_rule_versions":
+		var row backupAutomodRuleVersion
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid automod rule version: %s", err.Error())
+		}
+		return tx.RestoreAutomodRuleVersion(ctx, database.RestoreAutomodRuleVersionParams(row))
+	case "automod_restrictions":
+		var row backupAutomodRestriction
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid automod restriction: %s", err.Error())
+		}
+		return tx.RestoreAutomodRestriction(ctx, database.RestoreAutomodRestrictionParams(row))
+	case "automod_holds":
+		var row backupAutomodHold
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid automod hold: %s", err.Error())
+		}
+		if row.Reason != "" && row.Reason != automodHoldReason && row.Reason != wordFilterReason {
+			return invalidBackupError("invalid automod hold: unknown reason %q", row.Reason)
+		}
+		return tx.RestoreChirpReport(ctx, row.restoreParams())
+	case "chirp_favourites":
+		var row backupChirpFavourite
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid chirp favourite: %s", err.Error())
+		}
+		return tx.RestoreChirpFavourite(ctx, database.RestoreChirpFavouriteParams(row))
+	case "activitypub_keys":
+		var row backupActivityPubKey
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid ActivityPub key: %s", err.Error())
+		}
+		return tx.RestoreActivityPubKey(ctx, database.RestoreActivityPubKeyParams(row))
+	case "activitypub_followers":
+		var row backupActivityPubFollower
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid ActivityPub follower: %s", err.Error())
+		}
+		return tx.RestoreActivityPubFollower(ctx, database.RestoreActivityPubFollowerParams(row))
+	case "word_filters":
+		var row backupWordFilter
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid word filter: %s", err.Error())
+		}
+		return tx.RestoreWordFilter(ctx, database.RestoreWordFilterParams(row))
+	case "ip_bans":
+		var row backupIPBan
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid IP ban: %s", err.Error())
+		}
+		return tx.RestoreIPBan(ctx, row.restoreParams())
+	case "api_keys":
+		var row backupAPIKey
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid API key: %s", err.Error())
+		}
+		return tx.RestoreAPIKey(ctx, row.restoreParams())
+	case "oauth_apps":
+		var row backupOAuthApp
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid OAuth app: %s", err.Error())
+		}
+		return tx.RestoreOAuthApp(ctx, database.RestoreOAuthAppParams(row))
+	case "oauth_tokens":
+		var row backupOAuthToken
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid OAuth token: %s", err.Error())
+		}
+		return tx.RestoreOAuthToken(ctx, database.RestoreOAuthTokenParams(row))
+	case "digest_subscriptions":
+		var row backupDigestSubscription
+		if err := json.Unmarshal(line.Row, &row); err != nil {
+			return invalidBackupError("invalid digest subscription: %s", err.Error())
+		}
+		return tx.RestoreDigestSubscription(ctx, database.RestoreDigestSubscriptionParams(row))
+	}
+	return invalidBackupError("unknown table %q", line.Table)
+}
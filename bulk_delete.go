@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+	"github.com/google/uuid"
+)
+
+const bulkDeleteJobKind = "chirps.bulk_delete"
+
+// Upper bound of a bulk delete given no created_before, later than any chirp can be
+var endOfTime = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// Which chirps a bulk delete removes, stored as the job payload. Nil fields do not filter.
+type bulkDeleteFilter struct {
+	UserID        *uuid.UUID `json:"user_id,omitempty"`
+	TenantID      *uuid.UUID `json:"tenant_id,omitempty"`
+	CreatedAfter  *time.Time `json:"created_after,omitempty"`
+	CreatedBefore *time.Time `json:"created_before,omitempty"`
+	// chirps deleted per statement
+	BatchSize int32 `json:"batch_size"`
+}
+
+func (f bulkDeleteFilter) countParams() database.CountChirpsMatchingParams {
+	arg := database.CountChirpsMatchingParams{AllUsers: f.UserID == nil, AllTenants: f.TenantID == nil, CreatedBefore: endOfTime}
+	if f.UserID != nil {
+		arg.UserID = *f.UserID
+	}
+	if f.TenantID != nil {
+		arg.TenantID = *f.TenantID
+	}
+	if f.CreatedAfter != nil {
+		arg.CreatedAfter = f.CreatedAfter.UTC()
+	}
+	if f.CreatedBefore != nil {
+		arg.CreatedBefore = f.CreatedBefore.UTC()
+	}
+	return arg
+}
+
+// Progress of a bulk delete job, as GET /admin/jobs/{jobID} shows it
+type bulkDeleteProgress struct {
+	Matched int64 `json:"matched"`
+	Deleted int64 `json:"deleted"`
+	Batches int64 `json:"batches"`
+}
+
+// Starts deleting the chirps that match a filter in the background. Every filter given
+// must match; chirps posted after the request are left alone unless created_before says
+// otherwise. With dry_run the matching chirps are only counted.
+func (cfg *apiConfig) handlerBulkDeleteChirps(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		UserID        string     `json:"user_id" validate:"uuid"`
+		Tenant        string     `json:"tenant"`
+		CreatedAfter  *time.Time `json:"created_after"`
+		CreatedBefore *time.Time `json:"created_before"`
+		BatchSize     int32      `json:"batch_size" validate:"min=1,max=10000"`
+		DryRun        bool       `json:"dry_run"`
+	}
+	params := parameters{BatchSize: 1000}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	if params.UserID == "" && params.Tenant == "" && params.CreatedAfter == nil && params.CreatedBefore == nil {
+		marshallError(w, fmt.Errorf("give at least one of user_id, tenant, created_after or created_before; POST /admin/reset deletes everything"), 400)
+		return
+	}
+	filter := bulkDeleteFilter{CreatedAfter: params.CreatedAfter, CreatedBefore: params.CreatedBefore, BatchSize: params.BatchSize}
+	if params.UserID != "" {
+		userID := uuid.MustParse(params.UserID)
+		filter.UserID = &userID
+	}
+	if params.Tenant != "" {
+		tenant, err := cfg.store.GetTenantBySlug(r.Context(), params.Tenant)
+		if errors.Is(err, sql.ErrNoRows) {
+			marshallError(w, unknownTenantError(params.Tenant), 404)
+			return
+		}
+		if err != nil {
+			log.Printf("Error getting tenant: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		filter.TenantID = &tenant.ID
+	}
+	if params.DryRun {
+		matched, err := cfg.store.CountChirpsMatching(r.Context(), filter.countParams())
+		if err != nil {
+			log.Printf("Error counting chirps: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		render(w, r, 200, map[string]int64{"matched": matched})
+		return
+	}
+	cfg.startBulkDelete(w, r, filter)
+}
+
+// Starts deleting every chirp of a user in the background
+func (cfg *apiConfig) handlerDeleteUserChirps(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid user id"), 400)
+		return
+	}
+	cfg.startBulkDelete(w, r, bulkDeleteFilter{UserID: &userID, BatchSize: 1000})
+}
+
+// Helper function to enqueue a bulk delete and answer 202 with the job to follow
+func (cfg *apiConfig) startBulkDelete(w http.ResponseWriter, r *http.Request, filter bulkDeleteFilter) {
+	if filter.CreatedBefore == nil {
+		now := time.Now().UTC()
+		filter.CreatedBefore = &now
+	}
+	job, err := cfg.jobs.Enqueue(r.Context(), bulkDeleteJobKind, filter)
+	if err != nil {
+		log.Printf("Error enqueueing bulk delete: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.recordAudit(r, "chirps.bulk_delete", job.ID.String(), filter)
+	w.Header().Set("Location", "/admin/jobs/"+job.ID.String())
+	render(w, r, 202, newJobResponse(job))
+}
+
+// Job handler deleting the chirps of a bulkDeleteFilter a batch at a time, saving
+// progress after each. A retried job carries on from the chirps that are left and
+// keeps counting from where the failed attempt stopped.
+func (cfg *apiConfig) runBulkDeleteChirps(ctx context.Context, payload json.RawMessage) error {
+	var filter bulkDeleteFilter
+	err := json.Unmarshal(payload, &filter)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	var progress bulkDeleteProgress
+	if id, ok := jobs.ID(ctx); ok {
+		job, err := cfg.store.GetJob(ctx, id)
+		if err == nil && job.Progress != "" {
+			json.Unmarshal([]byte(job.Progress), &progress)
+		}
+	}
+	count := filter.countParams()
+	remaining, err := cfg.store.CountChirpsMatching(ctx, count)
+	if err != nil {
+		return err
+	}
+	progress.Matched = progress.Deleted + remaining
+	// cached chirps and timelines may hold deleted chirps, even when a batch fails
+	defer cfg.purgeChirpCache(context.WithoutCancel(ctx))
+	for {
+		err = cfg.jobs.SetProgress(ctx, progress)
+		if err != nil {
+			log.Printf("Error saving bulk delete progress: %s", err.Error())
+		}
+		deleted, err := cfg.store.DeleteChirpsMatching(ctx, database.DeleteChirpsMatchingParams{
+			CreatedAfter:  count.CreatedAfter,
+			CreatedBefore: count.CreatedBefore,
+			AllUsers:      count.AllUsers,
+			UserID:        count.UserID,
+			AllTenants:    count.AllTenants,
+			TenantID:      count.TenantID,
+			RowLimit:      filter.BatchSize,
+		})
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			log.Printf("Bulk delete removed %d chirps in %d batches", progress.Deleted, progress.Batches)
+			return nil
+		}
+		progress.Deleted += deleted
+		progress.Batches++
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// How long browsers (maxAge) and CDNs or other shared caches (sharedMaxAge) may keep a
+// public response
+type cachePolicy struct {
+	maxAge       time.Duration
+	sharedMaxAge time.Duration
+}
+
+func (p cachePolicy) header() string {
+	return fmt.Sprintf("public, max-age=%d, s-maxage=%d", int(p.maxAge.Seconds()), int(p.sharedMaxAge.Seconds()))
+}
+
+// Policies for public /api routes, keyed like apiDocs. Every other route, and every
+// response to a request with credentials, gets no-store. Shared caches keep listings
+// briefly, since a new chirp should show up within seconds, and single chirps longer.
+// ETags let both revalidate cheaply once the time is up.
+var cachePolicies = map[string]cachePolicy{
+	"GET /chirps":           {maxAge: 0, sharedMaxAge: 10 * time.Second},
+	"GET /chirps/{chirpID}": {maxAge: time.Minute, sharedMaxAge: 5 * time.Minute},
+	"GET /openapi.json":     {maxAge: 5 * time.Minute, sharedMaxAge: time.Hour},
+	"GET /docs":             {maxAge: 5 * time.Minute, sharedMaxAge: time.Hour},
+}
+
+// Files of the embedded frontend change only with a new build, but their names do not,
+// so they are kept for an hour rather than forever
+var staticCachePolicy = cachePolicy{maxAge: time.Hour, sharedMaxAge: time.Hour}
+
+// Sets Cache-Control on responses that do not set their own: header for 200, 206 and 304
+// responses to requests without credentials, no-store for anything else. Public API
+// responses vary by tenant, which the X-Tenant header can pick.
+func withCachePolicy(header string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := header
+		if r.Header.Get("Authorization") != "" {
+			policy = "no-store"
+		}
+		next.ServeHTTP(&cachePolicyWriter{ResponseWriter: w, header: policy}, r)
+	})
+}
+
+// Returns the Cache-Control header for an /api route pattern
+func apiCacheControl(pattern string) string {
+	policy, ok := cachePolicies[pattern]
+	if !ok {
+		return "no-store"
+	}
+	return policy.header()
+}
+
+type cachePolicyWriter struct {
+	http.ResponseWriter
+	header      string
+	wroteHeader bool
+}
+
+func (w *cachePolicyWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		if h.Get("Cache-Control") == "" {
+			if code == 200 || code == 206 || code == 304 {
+				h.Set("Cache-Control", w.header)
+				if w.header != "no-store" {
+					h.Add("Vary", "X-Tenant")
+				}
+			} else {
+				h.Set("Cache-Control", "no-store")
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cachePolicyWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *cachePolicyWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"slices"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Reads a single chirp of the caller's tenant through the in-process cache. Only its
+// author reads the chirp of a shadowbanned user, and never from the cache.
+func (cfg *apiConfig) getChirpById(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	tenant, viewer := tenantID(ctx), viewerID(ctx)
+	if cfg.isShadowbanned(viewer) {
+		return cfg.store.GetChirpById(ctx, database.GetChirpByIdParams{ID: id, TenantID: tenant, ViewerID: viewer})
+	}
+	if chirp, ok := cfg.chirpCache.Get(id); ok && chirp.TenantID == tenant {
+		if cfg.isShadowbanned(chirp.UserID) {
+			return database.Chirp{}, sql.ErrNoRows
+		}
+		return chirp, nil
+	}
+	chirp, _, err := coalesce(cfg, ctx, "chirp:"+tenant.String()+":"+id.String(), func(ctx context.Context) (database.Chirp, error) {
+		chirp, err := cfg.store.GetChirpById(ctx, database.GetChirpByIdParams{ID: id, TenantID: tenant})
+		if err != nil {
+			return database.Chirp{}, err
+		}
+		cfg.chirpCache.Set(id, chirp)
+		return chirp, nil
+	})
+	return chirp, err
+}
+
+// Reads the caller's tenant's full chirp timeline through the in-process cache, then the
+// shared cache. The returned slice is a copy so callers may reorder it without
+// corrupting the cached entry. The caches hold the timeline without the chirps of
+// shadowbanned users, so those users read their own timeline from the store.
+func (cfg *apiConfig) getChirps(ctx context.Context) ([]database.Chirp, error) {
+	tenant, viewer := tenantID(ctx), viewerID(ctx)
+	if cfg.isShadowbanned(viewer) {
+		return cfg.store.GetChirps(ctx, database.GetChirpsParams{TenantID: tenant, ViewerID: viewer})
+	}
+	if chirps, ok := cfg.chirpListCache.Get(chirpListKey(tenant)); ok {
+		return slices.Clone(chirps), nil
+	}
+	chirps, err := cfg.cachedTimeline(ctx, timelineKey(tenant, uuid.Nil), func(ctx context.Context) ([]database.Chirp, error) {
+		return cfg.store.GetChirps(ctx, database.GetChirpsParams{TenantID: tenant})
+	})
+	if err != nil {
+		return nil, err
+	}
+	cfg.chirpListCache.Set(chirpListKey(tenant), chirps)
+	return slices.Clone(chirps), nil
+}
+
+// Reads one author's timeline through the shared cache, or from the store for a
+// shadowbanned author reading their own
+func (cfg *apiConfig) getChirpsByAuthor(ctx context.Context, authorId uuid.UUID) ([]database.Chirp, error) {
+	tenant := tenantID(ctx)
+	if authorId == viewerID(ctx) && cfg.isShadowbanned(authorId) {
+		return cfg.store.GetChirpsById(ctx, database.GetChirpsByIdParams{UserID: authorId, TenantID: tenant, ViewerID: authorId})
+	}
+	return cfg.cachedTimeline(ctx, timelineKey(tenant, authorId), func(ctx context.Context) ([]database.Chirp, error) {
+		return cfg.store.GetChirpsById(ctx, database.GetChirpsByIdParams{UserID: authorId, TenantID: tenant})
+	})
+}
+
+// Helper function for read-through caching of timelines in the shared cache. Cache
+// errors are logged and treated as misses so an outage only costs performance.
+// Concurrent misses for the same timeline share a single load.
+func (cfg *apiConfig) cachedTimeline(ctx context.Context, key string, load func(context.Context) ([]database.Chirp, error)) ([]database.Chirp, error) {
+	chirps, shared, err := coalesce(cfg, ctx, key, func(ctx context.Context) ([]database.Chirp, error) {
+		return cfg.readThroughTimeline(ctx, key, load)
+	})
+	if shared {
+		// every caller got the same slice, give each its own
+		chirps = slices.Clone(chirps)
+	}
+	return chirps, err
+}
+
+func (cfg *apiConfig) readThroughTimeline(ctx context.Context, key string, load func(context.Context) ([]database.Chirp, error)) ([]database.Chirp, error) {
+	dat, ok, err := cfg.sharedCache.Get(ctx, key)
+	if err != nil {
+		log.Printf("Error reading %s from cache: %s", key, err.Error())
+	}
+	if ok {
+		var chirps []database.Chirp
+		if err := json.Unmarshal(dat, &chirps); err == nil {
+			return chirps, nil
+		}
+	}
+	chirps, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dat, err = json.Marshal(chirps)
+	if err == nil {
+		err = cfg.sharedCache.Set(ctx, key, dat, cfg.timelineCacheTTL)
+	}
+	if err != nil {
+		log.Printf("Error writing %s to cache: %s", key, err.Error())
+	}
+	return chirps, nil
+}
+
+// Runs load once for every concurrent caller with the same key, so a spike of identical
+// reads costs one query. The load runs without the first caller's cancellation so one
+// client hanging up does not fail the others. shared reports whether the result went to
+// more than one caller.
+func coalesce[T any](cfg *apiConfig, ctx context.Context, key string, load func(context.Context) (T, error)) (T, bool, error) {
+	v, err, shared := cfg.chirpLoads.Do(key, func() (any, error) {
+		return load(context.WithoutCancel(ctx))
+	})
+	if err != nil {
+		var zero T
+		return zero, shared, err
+	}
+	return v.(T), shared, nil
+}
+
+// Write-through on create: the new chirp is cached and the affected timelines are rebuilt on next read
+func (cfg *apiConfig) cacheCreatedChirp(ctx context.Context, chirp database.Chirp) {
+	cfg.chirpCache.Set(chirp.ID, chirp)
+	cfg.invalidateTimelines(ctx, chirp.TenantID, chirp.UserID)
+}
+
+func (cfg *apiConfig) invalidateChirp(ctx context.Context, chirp database.Chirp) {
+	cfg.chirpCache.Delete(chirp.ID)
+	cfg.invalidateTimelines(ctx, chirp.TenantID, chirp.UserID)
+}
+
+// Drops the tenant's global timeline and the author's timeline from both cache layers.
+// The in-process layer on other instances is only bounded by CHIRP_CACHE_TTL.
+func (cfg *apiConfig) invalidateTimelines(ctx context.Context, tenantID, authorId uuid.UUID) {
+	cfg.chirpListCache.Delete(chirpListKey(tenantID))
+	err := cfg.sharedCache.Delete(ctx, timelineKey(tenantID, uuid.Nil), timelineKey(tenantID, authorId))
+	if err != nil {
+		log.Printf("Error invalidating timelines: %s", err.Error())
+	}
+}
+
+func (cfg *apiConfig) purgeChirpCache(ctx context.Context) {
+	cfg.chirpCache.Purge()
+	cfg.chirpListCache.Purge()
+	err := cfg.sharedCache.Purge(ctx)
+	if err != nil {
+		log.Printf("Error purging shared cache: %s", err.Error())
+	}
+}
+
+// In-process cache key for a tenant's global timeline
+func chirpListKey(tenantID uuid.UUID) string {
+	return tenantID.String()
+}
+
+// Shared cache key for a timeline of a tenant, uuid.Nil is the tenant's global
+// timeline. The default tenant keeps the keys used before tenants existed.
+func timelineKey(tenantID, authorId uuid.UUID) string {
+	prefix := "timeline:"
+	if tenantID != uuid.Nil {
+		prefix += "tenant:" + tenantID.String() + ":"
+	}
+	if authorId == uuid.Nil {
+		return prefix + "all"
+	}
+	return prefix + "author:" + authorId.String()
+}
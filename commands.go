@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+)
+
+const usage = `Usage: chirpy <command> [flags]
+
+Commands:
+  serve                       run the HTTP API (default)
+  migrate <up|down|status|force> [version]
+                              manage the database schema
+  seed                        fill the database with deterministic fake data
+  loadtest                    measure latency and errors of a running server
+  admin create-user           create an account
+  admin promote               upgrade an account to Chirpy Red
+  help                        show this message
+
+Run "chirpy <command> -h" for the flags of a command.
+`
+
+// Dispatches to a subcommand; no arguments means serve
+func run(args []string) error {
+	if len(args) == 0 {
+		return runServe(nil)
+	}
+	switch args[0] {
+	case "serve":
+		return runServe(args[1:])
+	case "migrate":
+		// Migrations are the point of this command, so skip the automatic run
+		err := loadSecrets(context.Background())
+		if err != nil {
+			return err
+		}
+		db, dialect, err := openDatabase(currentDBURL)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return runMigrateCommand(context.Background(), db, dialect, args[1:])
+	case "seed":
+		appStore, db, err := openStore()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		return runSeedCommand(context.Background(), appStore, args[1:])
+	case "loadtest":
+		return runLoadTestCommand(context.Background(), args[1:], os.Stdout)
+	case "admin":
+		return runAdminCommand(context.Background(), args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// Helper function to open DB_URL, bring the schema up to date and wrap it in a store
+func openStore() (store.Store, *sql.DB, error) {
+	err := loadSecrets(context.Background())
+	if err != nil {
+		return nil, nil, err
+	}
+	db, dialect, err := openDatabase(currentDBURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	prepareDatabase(db, dialect)
+	return store.NewSQL(db, nil), db, nil
+}
+
+// Implements `chirpy admin ...` for account management from the command line
+func runAdminCommand(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: chirpy admin <create-user|promote> [flags]")
+	}
+	var handler func(context.Context, store.Store, []string) error
+	switch args[0] {
+	case "create-user":
+		handler = adminCreateUser
+	case "promote":
+		handler = adminPromote
+	default:
+		return fmt.Errorf("unknown admin command %q", args[0])
+	}
+	appStore, db, err := openStore()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return handler(ctx, appStore, args[1:])
+}
+
+// Implements `chirpy admin create-user -email <email> -password <password> [-red]`
+func adminCreateUser(ctx context.Context, appStore store.Store, args []string) error {
+	flags := flag.NewFlagSet("admin create-user", flag.ContinueOnError)
+	email := flags.String("email", "", "email address of the new account")
+	password := flags.String("password", "", "password of the new account")
+	red := flags.Bool("red", false, "upgrade the new account to Chirpy Red")
+	tenantSlug := flags.String("tenant", defaultTenantSlug, "slug of the tenant the account belongs to")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *email == "" || *password == "" {
+		return errors.New("-email and -password are required")
+	}
+	tenant, err := cliTenant(ctx, appStore, *tenantSlug)
+	if err != nil {
+		return err
+	}
+	hashedPassword, err := auth.HashPassword(*password)
+	if err != nil {
+		return err
+	}
+	// account creation and the upgrade either both happen or neither does
+	return appStore.WithTx(ctx, func(tx store.Store) error {
+		user, err := tx.CreateUser(ctx, database.CreateUserParams{Email: *email, HashedPassword: hashedPassword, TenantID: tenant.ID})
+		if err != nil {
+			return err
+		}
+		if *red {
+			user, err = tx.UpgradeUserById(ctx, database.UpgradeUserByIdParams{ID: user.ID, TenantID: tenant.ID})
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("Created user %s (%s), chirpy red: %t", user.Email, user.ID, user.IsChirpyRed)
+		recordCLIAudit(ctx, tx, "user.create", user.ID.String(), map[string]any{"email": user.Email, "chirpy_red": user.IsChirpyRed})
+		return nil
+	})
+}
+
+// Implements `chirpy admin promote -email <email>`
+func adminPromote(ctx context.Context, appStore store.Store, args []string) error {
+	flags := flag.NewFlagSet("admin promote", flag.ContinueOnError)
+	email := flags.String("email", "", "email address of the account to upgrade")
+	tenantSlug := flags.String("tenant", defaultTenantSlug, "slug of the tenant the account belongs to")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+	tenant, err := cliTenant(ctx, appStore, *tenantSlug)
+	if err != nil {
+		return err
+	}
+	user, err := appStore.GetUserByEmail(ctx, database.GetUserByEmailParams{TenantID: tenant.ID, Email: *email})
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no user with email %s", *email)
+	}
+	if err != nil {
+		return err
+	}
+	user, err = appStore.UpgradeUserById(ctx, database.UpgradeUserByIdParams{ID: user.ID, TenantID: tenant.ID})
+	if err != nil {
+		return err
+	}
+	log.Printf("Upgraded user %s (%s) to Chirpy Red", user.Email, user.ID)
+	recordCLIAudit(ctx, appStore, "user.promote", user.ID.String(), map[string]any{"email": user.Email})
+	return nil
+}
+
+// Looks up the tenant named by a -tenant flag
+func cliTenant(ctx context.Context, appStore store.Store, slug string) (database.Tenant, error) {
+	tenant, err := appStore.GetTenantBySlug(ctx, slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Tenant{}, fmt.Errorf("no tenant named %s", slug)
+	}
+	return tenant, err
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/metrics"
+)
+
+type dashboardStats struct {
+	GeneratedAt    time.Time            `json:"generated_at"`
+	TotalVisits    int64                `json:"total_visits"`
+	DailyVisits    []dailyVisits        `json:"daily_visits"`
+	Users          int64                `json:"users"`
+	NewUsers24h    int64                `json:"new_users_24h"`
+	Chirps         int64                `json:"chirps"`
+	NewChirps24h   int64                `json:"new_chirps_24h"`
+	ActiveSessions int64                `json:"active_sessions"`
+	Database       *databaseHealth      `json:"database,omitempty"`
+	Routes         []metrics.RouteStat  `json:"routes"`
+	RecentErrors   []metrics.ErrorEvent `json:"recent_errors"`
+}
+
+type dailyVisits struct {
+	Day  string `json:"day"`
+	Hits int64  `json:"hits"`
+}
+
+type databaseHealth struct {
+	Status          string `json:"status"`
+	Error           string `json:"error,omitempty"`
+	MaxOpen         int    `json:"max_open_connections"`
+	Open            int    `json:"open_connections"`
+	InUse           int    `json:"in_use"`
+	Idle            int    `json:"idle"`
+	WaitCount       int64  `json:"wait_count"`
+	WaitDurationMs  int64  `json:"wait_duration_ms"`
+	MaxIdleClosed   int64  `json:"max_idle_closed"`
+	MaxLifetimeDrop int64  `json:"max_lifetime_closed"`
+}
+
+// Gathers everything the admin dashboard shows
+func (cfg *apiConfig) collectDashboardStats(ctx context.Context) (dashboardStats, error) {
+	now := time.Now().UTC()
+	stats := dashboardStats{GeneratedAt: now}
+	// persisted totals plus whatever has not been flushed yet
+	pending := cfg.pendingHits.Load()
+	total, err := cfg.store.GetTotalVisits(ctx)
+	if err != nil {
+		return stats, err
+	}
+	stats.TotalVisits = total + pending
+	days, err := cfg.store.GetDailyVisits(ctx, 30)
+	if err != nil {
+		return stats, err
+	}
+	today := now.Format(time.DateOnly)
+	if pending > 0 && (len(days) == 0 || days[0].Day != today) {
+		days = append([]database.Visit{{Day: today}}, days...)
+	}
+	stats.DailyVisits = make([]dailyVisits, 0, len(days))
+	for _, day := range days {
+		if day.Day == today {
+			day.Hits += pending
+		}
+		stats.DailyVisits = append(stats.DailyVisits, dailyVisits{Day: day.Day, Hits: day.Hits})
+	}
+	counts, err := cfg.store.GetDashboardCounts(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		return stats, err
+	}
+	stats.Users, stats.NewUsers24h = counts.Users, counts.NewUsers
+	stats.Chirps, stats.NewChirps24h = counts.Chirps, counts.NewChirps
+	stats.ActiveSessions = counts.ActiveSessions
+	// demo mode has no pool to report on
+	if cfg.db != nil {
+		pool := cfg.db.Stats()
+		stats.Database = &databaseHealth{
+			Status:          "ok",
+			MaxOpen:         pool.MaxOpenConnections,
+			Open:            pool.OpenConnections,
+			InUse:           pool.InUse,
+			Idle:            pool.Idle,
+			WaitCount:       pool.WaitCount,
+			WaitDurationMs:  pool.WaitDuration.Milliseconds(),
+			MaxIdleClosed:   pool.MaxIdleClosed,
+			MaxLifetimeDrop: pool.MaxLifetimeClosed,
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, cfg.readinessTimeout)
+		defer cancel()
+		err = cfg.db.PingContext(pingCtx)
+		if err != nil {
+			stats.Database.Status = "error"
+			stats.Database.Error = err.Error()
+		}
+	}
+	stats.Routes, err = cfg.metrics.RouteStats()
+	if err != nil {
+		return stats, err
+	}
+	stats.RecentErrors = cfg.metrics.RecentErrors()
+	if stats.RecentErrors == nil {
+		stats.RecentErrors = []metrics.ErrorEvent{}
+	}
+	return stats, nil
+}
+
+// JSON stats consumed by the dashboard page
+func (cfg *apiConfig) handlerAdminStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := cfg.collectDashboardStats(r.Context())
+	if err != nil {
+		log.Printf("Error collecting dashboard stats: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	render(w, r, 200, stats)
+}
+
+// Per-query counts, time and errors, which routes run them, and the recent slow queries
+func (cfg *apiConfig) handlerAdminDBStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := cfg.metrics.DBStats()
+	if err != nil {
+		log.Printf("Error collecting database stats: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	render(w, r, 200, stats)
+}
+
+// Admin dashboard, rendered with the current stats and refreshed from /admin/api/stats.
+// The page never contains the admin token: its script asks for one and keeps it in
+// sessionStorage, so it is gone when the tab is closed.
+func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	stats, err := cfg.collectDashboardStats(r.Context())
+	if err != nil {
+		log.Printf("Error collecting dashboard stats: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	w.Header().Add("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(200)
+	err = dashboardTemplate.Execute(w, struct {
+		Stats dashboardStats
+	}{stats})
+	if err != nil {
+		log.Printf("Error rendering dashboard: %s", err.Error())
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<html>
+<head>
+<title>Chirpy Admin</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.75em; text-align: left; }
+.tiles span { display: inline-block; margin-right: 2em; }
+</style>
+</head>
+<body>
+<h1>Welcome, Chirpy Admin</h1>
+<form id="token-form" hidden>
+<label>Admin token to refresh the stats: <input id="token" type="password" autocomplete="off"></label>
+<button>Save for this tab</button>
+</form>
+<p id="total-visits">Chirpy has been visited {{.Stats.TotalVisits}} times!</p>
+<div class="tiles">
+<span>Users: <b id="users">{{.Stats.Users}}</b> (<span id="new-users">{{.Stats.NewUsers24h}}</span> in 24h)</span>
+<span>Chirps: <b id="chirps">{{.Stats.Chirps}}</b> (<span id="new-chirps">{{.Stats.NewChirps24h}}</span> in 24h)</span>
+<span>Active sessions: <b id="sessions">{{.Stats.ActiveSessions}}</b></span>
+</div>
+<h2>Database</h2>
+<p id="database">{{with .Stats.Database}}{{.Status}} {{.Error}} - {{.InUse}} in use, {{.Idle}} idle, {{.Open}}/{{.MaxOpen}} open, {{.WaitCount}} waits ({{.WaitDurationMs}} ms){{else}}in-memory store{{end}}</p>
+<h2>Routes</h2>
+<table><thead><tr><th>Route</th><th>Method</th><th>Requests</th><th>5xx</th><th>Avg latency (ms)</th></tr></thead>
+<tbody id="routes">{{range .Stats.Routes}}<tr><td>{{.Route}}</td><td>{{.Method}}</td><td>{{.Requests}}</td><td>{{.ServerErrors}}</td><td>{{printf "%.2f" .AvgLatencyMs}}</td></tr>{{end}}</tbody></table>
+<h2>Recent errors</h2>
+<table><thead><tr><th>Time</th><th>Request</th><th>Status</th></tr></thead>
+<tbody id="errors">{{range .Stats.RecentErrors}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Method}} {{.Path}}</td><td>{{.Status}}</td></tr>{{end}}</tbody></table>
+<h2>Visits</h2>
+<table><thead><tr><th>Day (UTC)</th><th>Visits</th></tr></thead>
+<tbody id="visits">{{range .Stats.DailyVisits}}<tr><td>{{.Day}}</td><td>{{.Hits}}</td></tr>{{end}}</tbody></table>
+<script>
+function rows(id, items, cells) {
+  const body = document.getElementById(id);
+  body.replaceChildren(...items.map(item => {
+    const tr = document.createElement("tr");
+    for (const text of cells(item)) {
+      const td = document.createElement("td");
+      td.textContent = text;
+      tr.appendChild(td);
+    }
+    return tr;
+  }));
+}
+const tokenForm = document.getElementById("token-form");
+tokenForm.addEventListener("submit", event => {
+  event.preventDefault();
+  sessionStorage.setItem("chirpyAdminToken", document.getElementById("token").value);
+  document.getElementById("token").value = "";
+  tokenForm.hidden = true;
+  refresh();
+});
+async function refresh() {
+  const token = sessionStorage.getItem("chirpyAdminToken");
+  if (!token) {
+    tokenForm.hidden = false;
+    return;
+  }
+  const res = await fetch("/admin/api/stats", {headers: {Authorization: "Bearer " + token}});
+  if (res.status === 401) {
+    sessionStorage.removeItem("chirpyAdminToken");
+    tokenForm.hidden = false;
+    return;
+  }
+  if (!res.ok) return;
+  const s = await res.json();
+  const set = (id, value) => document.getElementById(id).textContent = value;
+  set("total-visits", "Chirpy has been visited " + s.total_visits + " times!");
+  set("users", s.users);
+  set("new-users", s.new_users_24h);
+  set("chirps", s.chirps);
+  set("new-chirps", s.new_chirps_24h);
+  set("sessions", s.active_sessions);
+  const db = s.database;
+  set("database", db ? db.status + " " + (db.error || "") + " - " + db.in_use + " in use, " + db.idle + " idle, " + db.open_connections + "/" + db.max_open_connections + " open, " + db.wait_count + " waits (" + db.wait_duration_ms + " ms)" : "in-memory store");
+  rows("routes", s.routes, r => [r.route, r.method, r.requests, r.server_errors, r.avg_latency_ms.toFixed(2)]);
+  rows("errors", s.recent_errors, e => [e.time.replace("T", " ").slice(0, 19), e.method + " " + e.path, e.status]);
+  rows("visits", s.daily_visits, v => [v.day, v.hits]);
+}
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`))
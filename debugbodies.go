@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/metrics"
+)
+
+// Routes whose request and response bodies are logged, as set by the admin endpoints.
+// It is stored in the database like maintenance mode, so every instance logs the same
+// routes, and turns itself off at Until so it is not left on by mistake.
+type debugBodyState struct {
+	// keyed like apiDocs, e.g. "POST /chirps"
+	Routes []string  `json:"routes"`
+	Until  time.Time `json:"until"`
+	Since  time.Time `json:"since"`
+}
+
+// Name of the runtime_state row holding the debug body logging state while it is on
+const debugBodyStateName = "debug_bodies"
+
+// Each logged body is cut off after this many bytes
+const debugBodyLogLimit = 16 << 10
+
+// JSON fields whose values are replaced before a body is logged
+var redactedFields = regexp.MustCompile(`(?i)password|token|secret|authorization|api_?key`)
+
+// Reports whether bodies of requests to route are logged at now
+func (s *debugBodyState) logs(route string, now time.Time) bool {
+	return s != nil && now.Before(s.Until) && slices.Contains(s.Routes, route)
+}
+
+// Middleware that logs the redacted bodies of requests to the routes debug body logging
+// is on for, and of their responses
+func (cfg *apiConfig) middlewareDebugBodies(routes metrics.RouteResolver, next http.Handler) http.Handler {
+	if !cfg.debugBodiesAllowed {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := apiRoute(routes, r)
+		if !cfg.debugBodies.Load().logs(route, time.Now()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id := w.Header().Get("X-Request-ID")
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		// the handler still gets whatever could be read, and the error the read ended with
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), errReader{err}))
+		log.Printf("Debug %s request_id=%s request headers=%s body=%s", route, id, redactHeaders(r.Header), redactBody(r.Header.Get("Content-Type"), body))
+		rec := &bodyRecorder{statusRecorder: statusRecorder{ResponseWriter: w, status: 200}}
+		next.ServeHTTP(rec, r)
+		log.Printf("Debug %s request_id=%s response status=%d body=%s", route, id, rec.status, redactBody(w.Header().Get("Content-Type"), rec.body.Bytes()))
+	})
+}
+
+// Returns err from every Read, or io.EOF when it is nil
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) {
+	if e.err == nil {
+		return 0, io.EOF
+	}
+	return 0, e.err
+}
+
+// bodyRecorder keeps the first debugBodyLogLimit bytes of a response
+type bodyRecorder struct {
+	statusRecorder
+	body bytes.Buffer
+}
+
+func (rec *bodyRecorder) Write(b []byte) (int, error) {
+	if room := debugBodyLogLimit - rec.body.Len(); room > 0 {
+		rec.body.Write(b[:min(len(b), room)])
+	}
+	return rec.statusRecorder.Write(b)
+}
+
+// Headers as JSON with credentials replaced
+func redactHeaders(h http.Header) string {
+	redacted := make(map[string]string, len(h))
+	for name := range h {
+		redacted[name] = h.Get(name)
+		for _, secret := range scrubbedHeaders {
+			if strings.EqualFold(name, secret) {
+				redacted[name] = scrubbed
+			}
+		}
+	}
+	dat, _ := json.Marshal(redacted)
+	return string(dat)
+}
+
+// A body for the log: JSON with the values of secret fields replaced, other text with
+// tokens and passwords replaced, or just the size and type of anything else
+func redactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return "(empty)"
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var doc any
+	switch {
+	// clients often leave out the Content-Type of JSON bodies, as decodeJSON does not need it
+	case (mediaType == "" || strings.HasSuffix(mediaType, "json")) && json.Unmarshal(body, &doc) == nil:
+		dat, _ := json.Marshal(redactJSON(doc))
+		return truncateLogged(string(dat))
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/x-www-form-urlencoded" || mediaType == "application/xml":
+		return truncateLogged(scrubText(string(body)))
+	}
+	return fmt.Sprintf("(%d bytes of %s)", len(body), cmp.Or(mediaType, "unknown type"))
+}
+
+func redactJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if redactedFields.MatchString(key) {
+				v[key] = scrubbed
+			} else {
+				v[key] = redactJSON(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactJSON(value)
+		}
+	case string:
+		return scrubText(v)
+	}
+	return v
+}
+
+func truncateLogged(s string) string {
+	if len(s) <= debugBodyLogLimit {
+		return s
+	}
+	return s[:debugBodyLogLimit] + fmt.Sprintf("... (%d more bytes)", len(s)-debugBodyLogLimit)
+}
+
+// Loads the shared debug body logging state into cfg.debugBodies and returns it, nil
+// when it is off or has run out
+func (cfg *apiConfig) syncDebugBodies(ctx context.Context) (*debugBodyState, error) {
+	row, err := cfg.store.GetRuntimeState(ctx, debugBodyStateName)
+	if errors.Is(err, sql.ErrNoRows) {
+		cfg.debugBodies.Store(nil)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &debugBodyState{}
+	err = json.Unmarshal([]byte(row.Value), state)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding debug body logging state: %w", err)
+	}
+	if !time.Now().Before(state.Until) {
+		state = nil
+	}
+	cfg.debugBodies.Store(state)
+	return state, nil
+}
+
+// Picks up debug body logging switched on other instances every interval until ctx is
+// done. A failed read keeps the last known state.
+func (cfg *apiConfig) syncDebugBodiesEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := cfg.syncDebugBodies(ctx)
+			if err != nil {
+				log.Printf("Error reading debug body logging state: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Answers 404 unless DEBUG_BODY_LOGGING allows turning debug body logging on
+func (cfg *apiConfig) checkDebugBodiesAllowed(w http.ResponseWriter) bool {
+	if !cfg.debugBodiesAllowed {
+		marshallError(w, fmt.Errorf("debug body logging is disabled, set DEBUG_BODY_LOGGING=true to allow it"), 404)
+		return false
+	}
+	return true
+}
+
+// Shows which routes have their bodies logged and until when; the body is null when
+// debug body logging is off
+func (cfg *apiConfig) handlerGetDebugBodies(w http.ResponseWriter, r *http.Request) {
+	if !cfg.checkDebugBodiesAllowed(w) {
+		return
+	}
+	state, err := cfg.syncDebugBodies(r.Context())
+	if err != nil {
+		log.Printf("Error reading debug body logging state: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	render(w, r, 200, state)
+}
+
+// Turns debug body logging on for a list of routes, replacing the previous list
+func (cfg *apiConfig) handlerPutDebugBodies(w http.ResponseWriter, r *http.Request) {
+	if !cfg.checkDebugBodiesAllowed(w) {
+		return
+	}
+	type parameters struct {
+		Routes          []string `json:"routes" validate:"required,min=1,max=50"`
+		DurationSeconds *int     `json:"duration_seconds" validate:"min=1,max=86400"`
+	}
+	params := parameters{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	for _, route := range params.Routes {
+		if !slices.Contains(cfg.apiRoutes, route) {
+			marshallError(w, &apiError{
+				Code:    "unknown_route",
+				Message: fmt.Sprintf("%q is not an /api route, name routes like \"POST /chirps\"", route),
+			}, 400)
+			return
+		}
+	}
+	now := time.Now().UTC()
+	duration := 15 * time.Minute
+	if params.DurationSeconds != nil {
+		duration = time.Duration(*params.DurationSeconds) * time.Second
+	}
+	state := &debugBodyState{Routes: params.Routes, Since: now, Until: now.Add(duration)}
+	value, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("Error encoding debug body logging state: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	_, err = cfg.store.SetRuntimeState(r.Context(), database.SetRuntimeStateParams{Name: debugBodyStateName, Value: string(value)})
+	if err != nil {
+		log.Printf("Error saving debug body logging state: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.debugBodies.Store(state)
+	log.Printf("Debug body logging on for %s until %s", strings.Join(state.Routes, ", "), state.Until.Format(time.RFC3339))
+	cfg.recordAudit(r, "debug_bodies.enable", "", state)
+	render(w, r, 200, state)
+}
+
+// Turns debug body logging off
+func (cfg *apiConfig) handlerDeleteDebugBodies(w http.ResponseWriter, r *http.Request) {
+	if !cfg.checkDebugBodiesAllowed(w) {
+		return
+	}
+	n, err := cfg.store.DeleteRuntimeState(r.Context(), debugBodyStateName)
+	if err != nil {
+		log.Printf("Error clearing debug body logging state: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if n == 0 {
+		marshallError(w, fmt.Errorf("debug body logging is not on"), 404)
+		return
+	}
+	cfg.debugBodies.Store(nil)
+	log.Printf("Debug body logging off")
+	cfg.recordAudit(r, "debug_bodies.disable", "", nil)
+	w.WriteHeader(204)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/validation"
+)
+
+// Returns the 400 error listing every offending field, in details.fields. Field is a
+// dotted path such as "data.user_id" or "events[2]".
+func validationError(fields ...validation.FieldError) *apiError {
+	return &apiError{
+		Code:    "validation_failed",
+		Message: "the request body has invalid fields",
+		Details: map[string]any{"fields": fields},
+	}
+}
+
+// Helper function to pick the status code for a failed body decode
+func decodeErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return 413
+	}
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return 400
+	}
+	return 500
+}
+
+// Decodes the JSON request body into dst, strictly: the body must be a single JSON
+// value, with no fields dst does not have and no values of the wrong type. The result
+// is then checked against the validate tags of dst. Every unknown, mistyped or invalid
+// field is reported at once rather than only the first.
+func decodeJSON(r *http.Request, dst any) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &apiError{Code: "malformed_json", Message: "the request body must be a JSON document"}
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value any
+	err = decoder.Decode(&value)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return &apiError{
+				Code:    "malformed_json",
+				Message: fmt.Sprintf("the request body is not valid JSON: %s", syntaxErr.Error()),
+				Details: map[string]any{"offset": syntaxErr.Offset},
+			}
+		}
+		return &apiError{Code: "malformed_json", Message: "the request body is not valid JSON"}
+	}
+	if decoder.More() {
+		return &apiError{Code: "malformed_json", Message: "the request body has data after the JSON document"}
+	}
+	fields := checkJSONValue(nil, "", value, reflect.TypeOf(dst).Elem())
+	if len(fields) > 0 {
+		slices.SortFunc(fields, func(a, b validation.FieldError) int { return strings.Compare(a.Field, b.Field) })
+		return validationError(fields...)
+	}
+	decoder = json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(dst)
+	if err != nil {
+		// checkJSONValue accepts everything encoding/json does, so this is a value
+		// it could not judge, like a number out of range
+		return validationError(validation.FieldError{Field: "", Message: err.Error()})
+	}
+	return validateRequest(dst)
+}
+
+// Checks the validate tags of v, see internal/validation
+func validateRequest(v any) error {
+	fields := validation.Struct(v)
+	if len(fields) > 0 {
+		return validationError(fields...)
+	}
+	return nil
+}
+
+// Fills the fields of dst that have a query tag from the URL query and checks the
+// validate tags. Parameters that are absent leave the field as it is, so callers set
+// defaults in dst first. Supports strings, integers and time.Time (RFC 3339), and
+// pointers to them for parameters whose absence matters.
+func decodeQuery(r *http.Request, dst any) error {
+	query := r.URL.Query()
+	value := reflect.ValueOf(dst).Elem()
+	var fields []validation.FieldError
+	for i := range value.NumField() {
+		field := value.Type().Field(i)
+		name := field.Tag.Get("query")
+		if name == "" || !query.Has(name) {
+			continue
+		}
+		raw := query.Get(name)
+		target := value.Field(i)
+		if target.Kind() == reflect.Pointer {
+			// pointer fields stay nil unless the parameter is given
+			target.Set(reflect.New(target.Type().Elem()))
+			target = target.Elem()
+		}
+		switch {
+		case target.Type() == timeType:
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				fields = append(fields, validation.FieldError{Field: name, Message: "must be an RFC 3339 timestamp"})
+				continue
+			}
+			target.Set(reflect.ValueOf(parsed.UTC()))
+		case target.Kind() == reflect.String:
+			target.SetString(raw)
+		case target.CanInt():
+			n, err := strconv.ParseInt(raw, 10, target.Type().Bits())
+			if err != nil {
+				fields = append(fields, validation.FieldError{Field: name, Message: "must be an integer"})
+				continue
+			}
+			target.SetInt(n)
+		default:
+			panic(fmt.Sprintf("decodeQuery: unsupported type %s for %s", target.Type(), name))
+		}
+	}
+	if len(fields) > 0 {
+		return validationError(fields...)
+	}
+	return validateRequest(dst)
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// Walks a decoded JSON value alongside the Go type it will be decoded into and
+// collects what encoding/json would reject
+func checkJSONValue(fields []validation.FieldError, path string, value any, t reflect.Type) []validation.FieldError {
+	if value == nil {
+		// null leaves the field at its zero value
+		return fields
+	}
+	if t.Kind() == reflect.Pointer {
+		return checkJSONValue(fields, path, value, t.Elem())
+	}
+	if t == rawType || t.Kind() == reflect.Interface {
+		return fields
+	}
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) && t.Kind() != reflect.Slice {
+		if _, ok := value.(string); !ok {
+			return append(fields, validation.FieldError{Field: path, Message: "must be a string"})
+		}
+		return fields
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return append(fields, validation.FieldError{Field: path, Message: "must be an object"})
+		}
+		for key, child := range object {
+			field, found := jsonField(t, key)
+			if !found {
+				fields = append(fields, validation.FieldError{Field: joinFieldPath(path, key), Message: "unknown field"})
+				continue
+			}
+			fields = checkJSONValue(fields, joinFieldPath(path, key), child, field.Type)
+		}
+	case reflect.Map:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return append(fields, validation.FieldError{Field: path, Message: "must be an object"})
+		}
+		for key, child := range object {
+			fields = checkJSONValue(fields, joinFieldPath(path, key), child, t.Elem())
+		}
+	case reflect.Slice, reflect.Array:
+		list, ok := value.([]any)
+		if !ok {
+			return append(fields, validation.FieldError{Field: path, Message: "must be an array"})
+		}
+		for i, child := range list {
+			fields = checkJSONValue(fields, fmt.Sprintf("%s[%d]", path, i), child, t.Elem())
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return append(fields, validation.FieldError{Field: path, Message: "must be a string"})
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return append(fields, validation.FieldError{Field: path, Message: "must be a boolean"})
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := value.(json.Number)
+		if _, err := number.Int64(); !ok || err != nil {
+			return append(fields, validation.FieldError{Field: path, Message: "must be an integer"})
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := value.(json.Number); !ok {
+			return append(fields, validation.FieldError{Field: path, Message: "must be a number"})
+		}
+	}
+	return fields
+}
+
+// Finds the struct field encoding/json would decode key into, matching names
+// case-insensitively as it does
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+func joinFieldPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/email"
+	"github.com/google/uuid"
+)
+
+const (
+	// the subscriptions the send_digests task reads at a time
+	digestPageSize = 100
+	// the most chirps one digest lists, newest first
+	digestMaxChirps = 20
+)
+
+type digestResponse struct {
+	Subscribed bool `json:"subscribed"`
+	// when the chirps the next digest lists start, which is when the last one went out
+	Since *time.Time `json:"since"`
+}
+
+func newDigestResponse(sub database.DigestSubscription) digestResponse {
+	return digestResponse{Subscribed: true, Since: &sub.LastSentAt}
+}
+
+// Looks up the caller, answering 403 when they belong to another tenant
+func (cfg *apiConfig) authenticateDigestUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return uuid.Nil, false
+	}
+	users, err := cfg.store.GetUsersByIds(r.Context(), database.GetUsersByIdsParams{TenantID: tenantID(r.Context()), Ids: userID.String()})
+	if err != nil {
+		log.Printf("Error getting user: %s", err.Error())
+		marshallError(w, err, 500)
+		return uuid.Nil, false
+	}
+	if len(users) == 0 {
+		marshallError(w, errOtherTenant, 403)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// Shows whether the caller gets the digest email
+func (cfg *apiConfig) handlerGetDigest(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateDigestUser(w, r)
+	if !ok {
+		return
+	}
+	sub, err := cfg.store.GetDigestSubscription(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		render(w, r, 200, digestResponse{})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting digest subscription: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	render(w, r, 200, newDigestResponse(sub))
+}
+
+// Subscribes the caller to the digest email. The first one lists the chirps posted
+// after they subscribed.
+func (cfg *apiConfig) handlerSubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateDigestUser(w, r)
+	if !ok {
+		return
+	}
+	sub, err := cfg.store.CreateDigestSubscription(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error creating digest subscription: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	render(w, r, 200, newDigestResponse(sub))
+}
+
+// Stops the digest email for the caller
+func (cfg *apiConfig) handlerUnsubscribeDigest(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateDigestUser(w, r)
+	if !ok {
+		return
+	}
+	err := cfg.store.DeleteDigestSubscription(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error deleting digest subscription: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// Queues the digest email of every subscriber whose last one went out before now,
+// listing the newest chirps of their tenant since then, and returns how many were
+// queued. Subscribers with nothing new get no email, but their window still moves on.
+func (cfg *apiConfig) sendDigests(ctx context.Context, now time.Time) (int, error) {
+	sent := 0
+	for {
+		subs, err := cfg.store.ListDueDigestSubscriptions(ctx, database.ListDueDigestSubscriptionsParams{
+			SentBefore: now,
+			MaxRows:    digestPageSize,
+		})
+		if err != nil {
+			return sent, err
+		}
+		if len(subs) == 0 {
+			return sent, nil
+		}
+		for _, sub := range subs {
+			chirps, err := cfg.store.ListDigestChirps(ctx, database.ListDigestChirpsParams{
+				TenantID:  sub.TenantID,
+				UserID:    sub.UserID,
+				Since:     sub.LastSentAt,
+				Until:     now,
+				MaxChirps: digestMaxChirps,
+			})
+			if err != nil {
+				return sent, err
+			}
+			if len(chirps) > 0 {
+				data := email.DigestData{Email: sub.Email, Since: sub.LastSentAt}
+				for _, chirp := range chirps {
+					// authors are named by ID, as everywhere else, so the digest never
+					// gives away anyone's address
+					data.Chirps = append(data.Chirps, email.DigestChirp{Author: chirp.UserID.String(), Body: chirp.Body})
+				}
+				err = cfg.mailer.Send(ctx, sub.Email, email.Digest, data)
+				if err != nil {
+					return sent, err
+				}
+				sent++
+			}
+			err = cfg.store.MarkDigestSent(ctx, database.MarkDigestSentParams{UserID: sub.UserID, LastSentAt: now})
+			if err != nil {
+				return sent, err
+			}
+		}
+	}
+}
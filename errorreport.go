@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/diamondoughnut/httpChirpy/internal/metrics"
+	"github.com/getsentry/sentry-go"
+)
+
+// Headers, query parameters and JSON fields whose values never leave the server
+var (
+	scrubbedHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "X-Api-Key", "X-Admin-Token"}
+	scrubbedParams  = []string{"token", "access_token", "refresh_token", "password", "signature", "api_key", "key"}
+	// bearer tokens, "password": "..." pairs and JWTs inside error messages, with what
+	// each is replaced by
+	scrubbedValues = []struct {
+		pattern     *regexp.Regexp
+		replacement string
+	}{
+		{regexp.MustCompile(`(?i)\b(bearer|apikey)\s+\S+`), "$1 " + scrubbed},
+		{regexp.MustCompile(`(?i)("?(?:password|token|secret)"?\s*[:=]\s*"?)[^\s",}]+`), "${1}" + scrubbed},
+		{regexp.MustCompile(`eyJ[\w-]+\.[\w-]+\.[\w-]+`), scrubbed},
+	}
+)
+
+const scrubbed = "[Filtered]"
+
+// Connects to the Sentry-compatible service at SENTRY_DSN, or returns nil when it is
+// unset. SENTRY_SAMPLE_RATE is the share of errors sent, from above 0 to 1.
+func newErrorReporter(platform string) *sentry.Client {
+	dsn := os.Getenv("SENTRY_DSN")
+	if dsn == "" {
+		return nil
+	}
+	rate, err := strconv.ParseFloat(getEnvDefault("SENTRY_SAMPLE_RATE", "1"), 64)
+	if err != nil || rate <= 0 || rate > 1 {
+		log.Fatalf("Invalid SENTRY_SAMPLE_RATE: must be above 0 and at most 1")
+	}
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: getEnvDefault("SENTRY_ENVIRONMENT", platform),
+		Release:     os.Getenv("SENTRY_RELEASE"),
+		SampleRate:  rate,
+		BeforeSend:  scrubEvent,
+	})
+	if err != nil {
+		log.Fatalf("Invalid SENTRY_DSN: %s", err.Error())
+	}
+	log.Printf("Reporting panics and server errors to %s", client.Options().Environment)
+	return client
+}
+
+// Removes credentials from an event before it is sent: auth headers and cookies, secret
+// query parameters, request bodies, and tokens or passwords quoted in error messages
+func scrubEvent(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+	if req := event.Request; req != nil {
+		for name := range req.Headers {
+			for _, secret := range scrubbedHeaders {
+				if strings.EqualFold(name, secret) {
+					req.Headers[name] = scrubbed
+				}
+			}
+		}
+		req.Cookies = ""
+		req.Data = ""
+		if query, err := url.ParseQuery(req.QueryString); err == nil {
+			for name := range query {
+				for _, secret := range scrubbedParams {
+					if strings.EqualFold(name, secret) {
+						query.Set(name, scrubbed)
+					}
+				}
+			}
+			req.QueryString = query.Encode()
+		}
+	}
+	event.Message = scrubText(event.Message)
+	for i := range event.Exception {
+		event.Exception[i].Value = scrubText(event.Exception[i].Value)
+	}
+	return event
+}
+
+func scrubText(s string) string {
+	for _, value := range scrubbedValues {
+		s = value.pattern.ReplaceAllString(s, value.replacement)
+	}
+	return s
+}
+
+// The error behind a 5xx response and where it was written, see reportServerError.
+// Handlers given up on by withTimeout may still write it while the middleware reads it.
+type serverError struct {
+	mu    sync.Mutex
+	err   error
+	stack *sentry.Stacktrace
+}
+
+// 5xx errors by request ID, for the requests middlewareErrorReporting is serving
+var serverErrors sync.Map
+
+// Keeps the error of a 5xx response for middlewareErrorReporting, with the stack of the
+// handler that wrote it. Like marshallError, it finds the request by its ID header.
+func reportServerError(w http.ResponseWriter, err error) {
+	if err == nil {
+		return
+	}
+	if pending, ok := serverErrors.Load(w.Header().Get("X-Request-ID")); ok {
+		pending := pending.(*serverError)
+		pending.mu.Lock()
+		defer pending.mu.Unlock()
+		pending.err, pending.stack = err, sentry.NewStacktrace()
+	}
+}
+
+// Middleware that sends panics and 5xx responses to the error reporter, with the
+// request, route and request ID. Panics carry on to the server after they are sent.
+func (cfg *apiConfig) middlewareErrorReporting(routes metrics.RouteResolver, next http.Handler) http.Handler {
+	if cfg.errorReporter == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub := sentry.NewHub(cfg.errorReporter, sentry.NewScope())
+		hub.Scope().SetRequest(r)
+		id := w.Header().Get("X-Request-ID")
+		hub.Scope().SetTag("request_id", id)
+		if _, pattern := routes.Handler(r); pattern != "" {
+			hub.Scope().SetTag("route", pattern)
+		}
+		pending := &serverError{}
+		serverErrors.Store(id, pending)
+		// clients may reuse an ID, only this request's entry is removed
+		defer serverErrors.CompareAndDelete(id, pending)
+		defer func() {
+			if p := recover(); p != nil {
+				if p != http.ErrAbortHandler {
+					hub.RecoverWithContext(r.Context(), p)
+				}
+				panic(p)
+			}
+		}()
+		rec := &statusRecorder{ResponseWriter: w, status: 200}
+		next.ServeHTTP(rec, r)
+		if rec.status < 500 {
+			return
+		}
+		event := sentry.NewEvent()
+		event.Level = sentry.LevelError
+		event.Message = fmt.Sprintf("%d %s", rec.status, http.StatusText(rec.status))
+		pending.mu.Lock()
+		defer pending.mu.Unlock()
+		if pending.err != nil {
+			event.Exception = []sentry.Exception{{
+				Type:       fmt.Sprintf("%T", pending.err),
+				Value:      pending.err.Error(),
+				Stacktrace: pending.stack,
+			}}
+		}
+		hub.CaptureEvent(event)
+	})
+}
+
+// statusRecorder captures the status the wrapped handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
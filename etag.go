@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+)
+
+// Weak ETag for a single chirp, changes whenever the chirp is updated
+func chirpETag(chirp database.Chirp) string {
+	return fmt.Sprintf(`W/"%s-%d"`, chirp.ID, chirp.UpdatedAt.UnixNano())
+}
+
+// Weak ETag for a list of chirps built from the count and newest updated_at, so any
+// create, update, or delete within the listed set produces a new tag
+func chirpsETag(chirps []database.Chirp) string {
+	var latest int64
+	for _, chirp := range chirps {
+		if updated := chirp.UpdatedAt.UnixNano(); updated > latest {
+			latest = updated
+		}
+	}
+	return fmt.Sprintf(`W/"%d-%d"`, len(chirps), latest)
+}
+
+// Weak ETag for one page of a list of total chirps, which also changes when chirps on
+// other pages are created or deleted, as the page headers count them
+func chirpsPageETag(chirps []database.Chirp, total int) string {
+	return strings.Replace(chirpsETag(chirps), `W/"`, fmt.Sprintf(`W/"%d-`, total), 1)
+}
+
+// Sets the ETag header and writes a 304 if the client already has this version.
+// Returns true when the response is complete and the handler should stop.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(304)
+	return true
+}
+
+// If-None-Match uses weak comparison, so W/ prefixes are ignored on both sides
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/metrics"
+)
+
+// Faults injected into /api requests with FAULT_INJECTION=true, to test how clients retry
+// and whether alerts fire. Rates are the chance of each fault per request by route,
+// keyed like apiDocs (e.g. "POST /chirps") or "*" for routes not listed.
+type faultInjector struct {
+	// slow requests are held back by a random delay of up to this long
+	maxLatency  time.Duration
+	latencyRate map[string]float64
+	errorRate   map[string]float64
+	dropRate    map[string]float64
+	// returns a number in [0, 1), replaced in tests
+	random func() float64
+}
+
+// Reads the fault injection settings. Only dev and demo platforms may turn it on.
+func (cfg *apiConfig) newFaultInjector() *faultInjector {
+	if os.Getenv("FAULT_INJECTION") != "true" {
+		return nil
+	}
+	if !cfg.isDevPlatform() {
+		log.Fatalf("FAULT_INJECTION is only allowed with PLATFORM=dev or demo")
+	}
+	faults := &faultInjector{
+		maxLatency:  getEnvDuration("FAULT_LATENCY", time.Second),
+		latencyRate: getEnvRates("FAULT_LATENCY_RATES"),
+		errorRate:   getEnvRates("FAULT_ERROR_RATES"),
+		dropRate:    getEnvRates("FAULT_DROP_RATES"),
+		random:      rand.Float64,
+	}
+	log.Printf("FAULT_INJECTION: adding latency %v, errors %v and dropped connections %v", faults.latencyRate, faults.errorRate, faults.dropRate)
+	return faults
+}
+
+// Reports whether a fault with the given rates hits a request to route
+func (f *faultInjector) hit(rates map[string]float64, route string) bool {
+	rate, ok := rates[route]
+	if !ok {
+		rate = rates["*"]
+	}
+	return rate > 0 && f.random() < rate
+}
+
+// Middleware that delays /api requests, fails them with 500 or drops their connection
+// without a response, at the rates configured for their route
+func (cfg *apiConfig) middlewareFaults(routes metrics.RouteResolver, next http.Handler) http.Handler {
+	if cfg.faults == nil {
+		return next
+	}
+	f := cfg.faults
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := apiRoute(routes, r)
+		if route == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if f.hit(f.latencyRate, route) {
+			select {
+			case <-time.After(time.Duration(f.random() * float64(f.maxLatency))):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if f.hit(f.dropRate, route) {
+			// the server closes the connection, or resets the HTTP/2 stream, without answering
+			panic(http.ErrAbortHandler)
+		}
+		if f.hit(f.errorRate, route) {
+			marshallError(w, &apiError{Code: "injected_fault", Message: "Internal Server Error"}, 500)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns the route r matches keyed like apiDocs, without the /api prefix and version,
+// or "" outside /api
+func apiRoute(routes metrics.RouteResolver, r *http.Request) string {
+	_, pattern := routes.Handler(r)
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || !strings.HasPrefix(path, "/api/") {
+		return ""
+	}
+	return method + " " + apiVersionPrefix.ReplaceAllString(path, "")
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/flags"
+	"github.com/google/uuid"
+)
+
+var flagNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+
+type featureFlagResponse struct {
+	Name              string    `json:"name"`
+	Description       string    `json:"description"`
+	Enabled           bool      `json:"enabled"`
+	RolloutPercentage int32     `json:"rollout_percentage"`
+	Environments      []string  `json:"environments"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+func newFeatureFlagResponse(flag database.FeatureFlag) featureFlagResponse {
+	environments := flags.SplitEnvironments(flag.Environments)
+	if environments == nil {
+		environments = []string{}
+	}
+	return featureFlagResponse{
+		Name:              flag.Name,
+		Description:       flag.Description,
+		Enabled:           flag.Enabled,
+		RolloutPercentage: flag.RolloutPercentage,
+		Environments:      environments,
+		CreatedAt:         flag.CreatedAt,
+		UpdatedAt:         flag.UpdatedAt,
+	}
+}
+
+// Lists every feature flag definition
+func (cfg *apiConfig) handlerListFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	rows, err := cfg.store.GetFeatureFlags(r.Context())
+	if err != nil {
+		log.Printf("Error getting feature flags: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]featureFlagResponse, 0, len(rows))
+	for _, flag := range rows {
+		resp = append(resp, newFeatureFlagResponse(flag))
+	}
+	render(w, r, 200, resp)
+}
+
+// Creates or replaces the flag named in the path
+func (cfg *apiConfig) handlerPutFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !flagNamePattern.MatchString(name) {
+		marshallError(w, fmt.Errorf("invalid flag name %q", name), 400)
+		return
+	}
+	type parameters struct {
+		Description       string   `json:"description"`
+		Enabled           bool     `json:"enabled"`
+		RolloutPercentage *int32   `json:"rollout_percentage" validate:"min=0,max=100"`
+		Environments      []string `json:"environments"`
+	}
+	params := parameters{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	// an omitted percentage means the flag applies to everyone
+	rollout := int32(100)
+	if params.RolloutPercentage != nil {
+		rollout = *params.RolloutPercentage
+	}
+	for _, env := range params.Environments {
+		if env == "" || strings.ContainsAny(env, ", ") {
+			marshallError(w, fmt.Errorf("invalid environment %q", env), 400)
+			return
+		}
+	}
+	flag, err := cfg.store.UpsertFeatureFlag(r.Context(), database.UpsertFeatureFlagParams{
+		Name:              name,
+		Description:       params.Description,
+		Enabled:           params.Enabled,
+		RolloutPercentage: rollout,
+		Environments:      strings.Join(params.Environments, ","),
+	})
+	if err != nil {
+		log.Printf("Error saving feature flag: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.flags.Invalidate()
+	cfg.recordAudit(r, "feature_flag.set", flag.Name, newFeatureFlagResponse(flag))
+	log.Printf("Feature flag %s set: enabled=%t rollout=%d%% environments=%q", flag.Name, flag.Enabled, flag.RolloutPercentage, flag.Environments)
+	render(w, r, 200, newFeatureFlagResponse(flag))
+}
+
+// Deletes the flag named in the path, which turns it off everywhere
+func (cfg *apiConfig) handlerDeleteFeatureFlag(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	_, err := cfg.store.GetFeatureFlag(r.Context(), name)
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("feature flag %s not found", name), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting feature flag: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	err = cfg.store.DeleteFeatureFlag(r.Context(), name)
+	if err != nil {
+		log.Printf("Error deleting feature flag: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.flags.Invalidate()
+	cfg.recordAudit(r, "feature_flag.delete", name, nil)
+	log.Printf("Feature flag %s deleted", name)
+	w.WriteHeader(204)
+}
+
+// Reports which flags are on for the caller, so clients can hide unreleased features.
+// Authentication is optional; anonymous callers only see fully rolled out flags.
+func (cfg *apiConfig) handlerGetFeatureFlags(w http.ResponseWriter, r *http.Request) {
+	userID := uuid.Nil
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		userID, err = cfg.validateJWT(token)
+		if err != nil {
+			marshallError(w, err, 401)
+			return
+		}
+	}
+	render(w, r, 200, cfg.flags.All(r.Context(), userID))
+}
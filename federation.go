@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/activitypub"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/diamondoughnut/httpChirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+// how many of a user's latest chirps their outbox lists
+const activityPubOutboxSize = 20
+
+// Actor documents and notes change rarely, and other servers refetch them on their own
+var federationCachePolicy = cachePolicy{maxAge: time.Minute, sharedMaxAge: 5 * time.Minute}
+
+// Registers an ActivityPub route. Other servers expect these at fixed paths outside
+// /api, and they only exist when ACTIVITYPUB_URL is set and for the default tenant.
+func (cfg *apiConfig) handleFederation(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	mux.Handle(pattern, withCachePolicy(federationCachePolicy.header(), withTimeout(cfg.routeTimeout(pattern), cfg.middlewareFederation(handler))))
+}
+
+func (cfg *apiConfig) middlewareFederation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.federation == nil || tenantID(r.Context()) != uuid.Nil {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeActivity(w http.ResponseWriter, contentType string, v any) {
+	dat, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshalling ActivityPub document: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	writeBody(w, 200, contentType, dat)
+}
+
+// Reads the user of an /ap/users/{userID} path, answering 404 when there is none
+func (cfg *apiConfig) federatedUser(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	id, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		marshallError(w, err, 404)
+		return database.User{}, false
+	}
+	return cfg.federatedUserByID(w, r, id)
+}
+
+func (cfg *apiConfig) federatedUserByID(w http.ResponseWriter, r *http.Request, id uuid.UUID) (database.User, bool) {
+	users, err := cfg.store.GetUsersByIds(r.Context(), database.GetUsersByIdsParams{TenantID: tenantID(r.Context()), Ids: id.String()})
+	if err != nil {
+		log.Printf("Error getting federated user: %s", err.Error())
+		marshallError(w, err, 500)
+		return database.User{}, false
+	}
+	if len(users) == 0 {
+		marshallError(w, errors.New("user not found"), 404)
+		return database.User{}, false
+	}
+	return users[0], true
+}
+
+// Finds the actor of a user from their account, acct:<user id>@<domain>, or actor id
+func (cfg *apiConfig) handlerWebFinger(w http.ResponseWriter, r *http.Request) {
+	type query struct {
+		Resource string `query:"resource" validate:"required"`
+	}
+	var q query
+	err := decodeQuery(r, &q)
+	if err != nil {
+		marshallError(w, err, 400)
+		return
+	}
+	// looked up from web clients on other origins
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	id, ok := cfg.federation.ParseResource(q.Resource)
+	if !ok {
+		marshallError(w, errors.New("resource not found"), 404)
+		return
+	}
+	user, ok := cfg.federatedUserByID(w, r, id)
+	if !ok {
+		return
+	}
+	writeActivity(w, "application/jrd+json", cfg.federation.WebFinger(user.ID))
+}
+
+// A user's Person actor, with the key their deliveries are signed with
+func (cfg *apiConfig) handlerActivityPubActor(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	actor, err := cfg.federation.Actor(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error building actor: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	writeActivity(w, activitypub.ContentType, actor)
+}
+
+// The Creates of a user's latest chirps, newest first, and how many chirps they have
+func (cfg *apiConfig) handlerActivityPubOutbox(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	// read as anyone else would, so held chirps and a shadowbanned user's are left out
+	chirps, err := cfg.store.GetChirpsByUserIds(r.Context(), database.GetChirpsByUserIdsParams{TenantID: user.TenantID, Ids: user.ID.String()})
+	if err != nil {
+		log.Printf("Error getting outbox chirps: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	items := []any{}
+	for _, chirp := range slices.Backward(chirps) {
+		if len(items) == activityPubOutboxSize {
+			break
+		}
+		items = append(items, cfg.federation.Create(cfg.federation.Note(chirp.ID, chirp.UserID, chirp.Body, chirp.CreatedAt)))
+	}
+	id := cfg.federation.ActorID(user.ID) + "/outbox"
+	writeActivity(w, activitypub.ContentType, cfg.federation.Collection(id, int64(len(chirps)), items))
+}
+
+// How many remote actors follow a user. The followers themselves are not listed.
+func (cfg *apiConfig) handlerActivityPubFollowers(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	count, err := cfg.store.CountActivityPubFollowers(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("Error counting followers: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	id := cfg.federation.ActorID(user.ID) + "/followers"
+	writeActivity(w, activitypub.ContentType, cfg.federation.Collection(id, count, nil))
+}
+
+// A chirp as a Note, for servers resolving one they were sent or linked to
+func (cfg *apiConfig) handlerActivityPubNote(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		marshallError(w, err, 404)
+		return
+	}
+	chirp, err := cfg.getChirpById(r.Context(), id)
+	if err != nil {
+		marshallError(w, err, 404)
+		return
+	}
+	note := cfg.federation.Note(chirp.ID, chirp.UserID, chirp.Body, chirp.CreatedAt)
+	note.Context = activitypub.ActivityStreams
+	writeActivity(w, activitypub.ContentType, note)
+}
+
+// Receives activities from other servers. Every request must carry an HTTP signature
+// by the activity's actor. Follows are accepted straight away and Undos of them remove
+// the follower; anything else is acknowledged and dropped.
+func (cfg *apiConfig) handlerActivityPubInbox(w http.ResponseWriter, r *http.Request) {
+	user, ok := cfg.federatedUser(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		marshallError(w, err, 400)
+		return
+	}
+	signer, err := cfg.federation.Verify(ctx, r, body)
+	if err != nil {
+		log.Printf("Rejected ActivityPub delivery to %s: %s", user.ID, err.Error())
+		marshallError(w, err, 401)
+		return
+	}
+	var activity activitypub.Incoming
+	err = json.Unmarshal(body, &activity)
+	if err != nil {
+		marshallError(w, invalidInputError{"body is not an activity"}, 400)
+		return
+	}
+	if activity.Actor != signer.ID {
+		marshallError(w, errors.New("the activity's actor did not sign it"), 401)
+		return
+	}
+	switch {
+	case activity.Type == "Follow":
+		if activity.ObjectID() != cfg.federation.ActorID(user.ID) {
+			marshallError(w, invalidInputError{"the Follow is not of this user"}, 400)
+			return
+		}
+		err = cfg.store.WithTx(ctx, func(tx store.Store) error {
+			_, err := tx.AddActivityPubFollower(ctx, database.AddActivityPubFollowerParams{
+				UserID:      user.ID,
+				ActorID:     signer.ID,
+				Inbox:       signer.Inbox,
+				SharedInbox: signer.SharedInbox(),
+				FollowID:    activity.ID,
+			})
+			if err != nil {
+				return err
+			}
+			return cfg.federation.Accept(ctx, tx, user.ID, signer, json.RawMessage(body))
+		})
+		if err != nil {
+			log.Printf("Error adding follower: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		log.Printf("%s now follows %s", signer.ID, user.ID)
+	case activity.Type == "Undo" && activity.ObjectType() == "Follow":
+		_, err = cfg.store.DeleteActivityPubFollower(ctx, database.DeleteActivityPubFollowerParams{UserID: user.ID, ActorID: signer.ID})
+		if err != nil {
+			log.Printf("Error removing follower: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		log.Printf("%s no longer follows %s", signer.ID, user.ID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Delivers the chirps of the default tenant's users to their ActivityPub followers,
+// through tx so it is part of relaying the event
+func (cfg *apiConfig) federateEvent(ctx context.Context, tx store.Store, event database.OutboxEvent) error {
+	if cfg.federation == nil || event.TenantID != uuid.Nil {
+		return nil
+	}
+	var chirp struct {
+		ID        uuid.UUID `json:"id"`
+		Body      string    `json:"body"`
+		UserID    uuid.UUID `json:"user_id"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+	err := json.Unmarshal([]byte(event.Payload), &chirp)
+	if err != nil {
+		return err
+	}
+	switch event.Event {
+	case webhooks.ChirpCreated:
+		return cfg.federation.PublishCreate(ctx, tx, cfg.federation.Note(chirp.ID, chirp.UserID, chirp.Body, chirp.CreatedAt), chirp.UserID)
+	case webhooks.ChirpDeleted:
+		// a chirp replaced by a tombstone is as good as deleted to followers
+		return cfg.federation.PublishDelete(ctx, tx, chirp.ID, chirp.UserID)
+	}
+	return nil
+}
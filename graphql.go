@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/dataloader"
+	"github.com/google/uuid"
+	"github.com/graph-gophers/graphql-go"
+)
+
+// Chirps are listed oldest first like GET /chirps. Cursors are opaque to clients but
+// are the base64 of the last chirp ID seen.
+const graphqlSchema = `
+schema {
+	query: Query
+}
+
+scalar Time
+
+type Query {
+	chirp(id: ID!): Chirp
+	chirps(first: Int, after: String, authorId: ID): ChirpConnection!
+	user(id: ID!): User
+	"The user whose access token was sent, null without one"
+	me: User
+}
+
+type User {
+	id: ID!
+	createdAt: Time!
+	isChirpyRed: Boolean!
+	"Only visible to the user themselves"
+	email: String
+	chirps(first: Int, after: String): ChirpConnection!
+	"The chirps the user favourited, most recently favourited first. Only visible to the user themselves"
+	favourites(first: Int, after: String): ChirpConnection
+}
+
+type Chirp {
+	id: ID!
+	body: String!
+	createdAt: Time!
+	author: User
+	"How many users favourited the chirp"
+	favouritesCount: Int!
+	"Whether the user whose access token was sent favourited the chirp, false without one"
+	favourited: Boolean!
+}
+
+type ChirpConnection {
+	edges: [ChirpEdge!]!
+	pageInfo: PageInfo!
+}
+
+type ChirpEdge {
+	cursor: String!
+	node: Chirp!
+}
+
+type PageInfo {
+	hasNextPage: Boolean!
+	endCursor: String
+}
+`
+
+const (
+	graphqlDefaultPageSize = 20
+	graphqlMaxPageSize     = 100
+)
+
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Per-request state for the resolvers. The loaders batch the author, chirps-by-author
+// and favourite lookups of every node in a connection into one query each.
+type graphqlLoaders struct {
+	viewer          uuid.UUID
+	users           *dataloader.Loader[uuid.UUID, database.User]
+	chirpsByAuthor  *dataloader.Loader[uuid.UUID, []database.Chirp]
+	favouriteCounts *dataloader.Loader[uuid.UUID, int64]
+	favourited      *dataloader.Loader[uuid.UUID, bool]
+	favourites      *dataloader.Loader[uuid.UUID, []database.Chirp]
+}
+
+type graphqlLoadersKey struct{}
+
+func (cfg *apiConfig) newGraphQLLoaders(viewer uuid.UUID) *graphqlLoaders {
+	return &graphqlLoaders{
+		viewer: viewer,
+		users: dataloader.New(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]database.User, error) {
+			users, err := cfg.store.GetUsersByIds(ctx, database.GetUsersByIdsParams{TenantID: tenantID(ctx), Ids: joinIDs(ids)})
+			if err != nil {
+				return nil, err
+			}
+			byID := make(map[uuid.UUID]database.User, len(users))
+			for _, user := range users {
+				byID[user.ID] = user
+			}
+			return byID, nil
+		}),
+		chirpsByAuthor: dataloader.New(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID][]database.Chirp, error) {
+			chirps, err := cfg.store.GetChirpsByUserIds(ctx, database.GetChirpsByUserIdsParams{TenantID: tenantID(ctx), Ids: joinIDs(ids), ViewerID: viewer})
+			if err != nil {
+				return nil, err
+			}
+			// authors without chirps have an empty list rather than a missing one
+			byAuthor := make(map[uuid.UUID][]database.Chirp, len(ids))
+			for _, id := range ids {
+				byAuthor[id] = nil
+			}
+			for _, chirp := range chirps {
+				byAuthor[chirp.UserID] = append(byAuthor[chirp.UserID], chirp)
+			}
+			return byAuthor, nil
+		}),
+		// chirps nobody favourited are left out, and count as 0
+		favouriteCounts: dataloader.New(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]int64, error) {
+			rows, err := cfg.store.CountChirpFavourites(ctx, joinIDs(ids))
+			if err != nil {
+				return nil, err
+			}
+			counts := make(map[uuid.UUID]int64, len(rows))
+			for _, row := range rows {
+				counts[row.ChirpID] = row.Favourites
+			}
+			return counts, nil
+		}),
+		// only asked for with a viewer, and chirps they did not favourite are left out
+		favourited: dataloader.New(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]bool, error) {
+			favourited, err := cfg.store.ListFavouritedChirpIds(ctx, database.ListFavouritedChirpIdsParams{UserID: viewer, Ids: joinIDs(ids)})
+			if err != nil {
+				return nil, err
+			}
+			byID := make(map[uuid.UUID]bool, len(favourited))
+			for _, id := range favourited {
+				byID[id] = true
+			}
+			return byID, nil
+		}),
+		// favourites are only shown to the viewer, so this loads at most one user's
+		favourites: dataloader.New(func(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID][]database.Chirp, error) {
+			byUser := make(map[uuid.UUID][]database.Chirp, len(ids))
+			for _, id := range ids {
+				rows, err := cfg.store.ListFavouriteChirps(ctx, database.ListFavouriteChirpsParams{
+					UserID:     id,
+					TenantID:   tenantID(ctx),
+					Before:     time.Now(),
+					MaxResults: math.MaxInt32,
+				})
+				if err != nil {
+					return nil, err
+				}
+				chirps := make([]database.Chirp, len(rows))
+				for i, row := range rows {
+					chirps[i] = database.Chirp{ID: row.ID, CreatedAt: row.CreatedAt, UpdatedAt: row.UpdatedAt, Body: row.Body, UserID: row.UserID, TenantID: row.TenantID}
+				}
+				byUser[id] = chirps
+			}
+			return byUser, nil
+		}),
+	}
+}
+
+func loadersFrom(ctx context.Context) *graphqlLoaders {
+	return ctx.Value(graphqlLoadersKey{}).(*graphqlLoaders)
+}
+
+func joinIDs(ids []uuid.UUID) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = id.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// Builds the POST /graphql handler. Sending an access token is optional; it only
+// decides who "me" is, whose email is visible and whether a shadowbanned user's chirps are.
+func (cfg *apiConfig) newGraphQLHandler() http.Handler {
+	schema := graphql.MustParseSchema(graphqlSchema, &graphqlQuery{cfg: cfg}, graphql.MaxDepth(10))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		viewer := uuid.Nil
+		if r.Header.Get("Authorization") != "" {
+			var err error
+			viewer, err = cfg.userFromHeader(r.Header)
+			if err != nil {
+				log.Printf("Error validating token: %s", err.Error())
+				marshallError(w, err, 401)
+				return
+			}
+		}
+		params := graphqlRequest{}
+		err := decodeJSON(r, &params)
+		if err != nil {
+			log.Printf("Error decoding parameters: %s", err.Error())
+			marshallError(w, err, decodeErrorStatus(err))
+			return
+		}
+		ctx := context.WithValue(withViewer(r.Context(), viewer), graphqlLoadersKey{}, cfg.newGraphQLLoaders(viewer))
+		render(w, r, 200, schema.Exec(ctx, params.Query, params.OperationName, params.Variables))
+	})
+}
+
+// Helper function to turn a store error into a GraphQL error without leaking details
+func graphqlError(action string, err error) error {
+	log.Printf("Error %s over GraphQL: %s", action, err.Error())
+	return errors.New("internal error")
+}
+
+type graphqlQuery struct {
+	cfg *apiConfig
+}
+
+func (q *graphqlQuery) Chirp(ctx context.Context, args struct{ ID graphql.ID }) (*graphqlChirp, error) {
+	id, err := uuid.Parse(string(args.ID))
+	if err != nil {
+		return nil, errors.New("invalid chirp id")
+	}
+	chirp, err := q.cfg.getChirpById(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, graphqlError("getting chirp", err)
+	}
+	return &graphqlChirp{chirp}, nil
+}
+
+type graphqlPageArgs struct {
+	First *int32
+	After *string
+}
+
+func (q *graphqlQuery) Chirps(ctx context.Context, args struct {
+	First    *int32
+	After    *string
+	AuthorID *graphql.ID
+}) (*graphqlChirpConnection, error) {
+	authorID := uuid.Nil
+	if args.AuthorID != nil {
+		var err error
+		authorID, err = uuid.Parse(string(*args.AuthorID))
+		if err != nil {
+			return nil, errors.New("invalid authorId")
+		}
+	}
+	chirps, err := q.cfg.listChirps(ctx, authorID, false)
+	if err != nil {
+		return nil, graphqlError("listing chirps", err)
+	}
+	return newChirpConnection(ctx, chirps, graphqlPageArgs{First: args.First, After: args.After})
+}
+
+func (q *graphqlQuery) User(ctx context.Context, args struct{ ID graphql.ID }) (*graphqlUser, error) {
+	id, err := uuid.Parse(string(args.ID))
+	if err != nil {
+		return nil, errors.New("invalid user id")
+	}
+	return loadUser(ctx, id)
+}
+
+func (q *graphqlQuery) Me(ctx context.Context) (*graphqlUser, error) {
+	viewer := loadersFrom(ctx).viewer
+	if viewer == uuid.Nil {
+		return nil, nil
+	}
+	return loadUser(ctx, viewer)
+}
+
+func loadUser(ctx context.Context, id uuid.UUID) (*graphqlUser, error) {
+	user, ok, err := loadersFrom(ctx).users.Load(ctx, id)
+	if err != nil {
+		return nil, graphqlError("getting user", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return &graphqlUser{user}, nil
+}
+
+type graphqlUser struct {
+	user database.User
+}
+
+func (u *graphqlUser) ID() graphql.ID          { return graphql.ID(u.user.ID.String()) }
+func (u *graphqlUser) CreatedAt() graphql.Time { return graphql.Time{Time: u.user.CreatedAt} }
+func (u *graphqlUser) IsChirpyRed() bool       { return u.user.IsChirpyRed }
+
+func (u *graphqlUser) Email(ctx context.Context) *string {
+	if loadersFrom(ctx).viewer != u.user.ID {
+		return nil
+	}
+	return &u.user.Email
+}
+
+func (u *graphqlUser) Chirps(ctx context.Context, args graphqlPageArgs) (*graphqlChirpConnection, error) {
+	chirps, _, err := loadersFrom(ctx).chirpsByAuthor.Load(ctx, u.user.ID)
+	if err != nil {
+		return nil, graphqlError("listing chirps", err)
+	}
+	return newChirpConnection(ctx, chirps, args)
+}
+
+func (u *graphqlUser) Favourites(ctx context.Context, args graphqlPageArgs) (*graphqlChirpConnection, error) {
+	loaders := loadersFrom(ctx)
+	if loaders.viewer != u.user.ID {
+		return nil, nil
+	}
+	chirps, _, err := loaders.favourites.Load(ctx, u.user.ID)
+	if err != nil {
+		return nil, graphqlError("listing favourites", err)
+	}
+	return newChirpConnection(ctx, chirps, args)
+}
+
+type graphqlChirp struct {
+	chirp database.Chirp
+}
+
+func (c *graphqlChirp) ID() graphql.ID          { return graphql.ID(c.chirp.ID.String()) }
+func (c *graphqlChirp) Body() string            { return c.chirp.Body }
+func (c *graphqlChirp) CreatedAt() graphql.Time { return graphql.Time{Time: c.chirp.CreatedAt} }
+
+func (c *graphqlChirp) Author(ctx context.Context) (*graphqlUser, error) {
+	return loadUser(ctx, c.chirp.UserID)
+}
+
+func (c *graphqlChirp) FavouritesCount(ctx context.Context) (int32, error) {
+	count, _, err := loadersFrom(ctx).favouriteCounts.Load(ctx, c.chirp.ID)
+	if err != nil {
+		return 0, graphqlError("counting favourites", err)
+	}
+	return int32(count), nil
+}
+
+func (c *graphqlChirp) Favourited(ctx context.Context) (bool, error) {
+	loaders := loadersFrom(ctx)
+	if loaders.viewer == uuid.Nil {
+		return false, nil
+	}
+	favourited, _, err := loaders.favourited.Load(ctx, c.chirp.ID)
+	if err != nil {
+		return false, graphqlError("getting favourites", err)
+	}
+	return favourited, nil
+}
+
+type graphqlChirpConnection struct {
+	edges       []*graphqlChirpEdge
+	hasNextPage bool
+}
+
+// Cuts one page out of chirps and announces the page's chirps and authors to the
+// loaders, so that resolving author, author.chirps or the favourites of every node
+// costs one query each in total
+func newChirpConnection(ctx context.Context, chirps []database.Chirp, args graphqlPageArgs) (*graphqlChirpConnection, error) {
+	first := int32(graphqlDefaultPageSize)
+	if args.First != nil {
+		first = min(max(*args.First, 0), graphqlMaxPageSize)
+	}
+	start := 0
+	if args.After != nil {
+		after, err := decodeChirpCursor(*args.After)
+		if err != nil {
+			return nil, errors.New("invalid cursor")
+		}
+		i := slices.IndexFunc(chirps, func(c database.Chirp) bool { return c.ID == after })
+		if i < 0 {
+			return nil, errors.New("cursor is not in this list")
+		}
+		start = i + 1
+	}
+	page := chirps[start:min(start+int(first), len(chirps))]
+	conn := &graphqlChirpConnection{hasNextPage: start+len(page) < len(chirps)}
+	loaders := loadersFrom(ctx)
+	for _, chirp := range page {
+		conn.edges = append(conn.edges, &graphqlChirpEdge{&graphqlChirp{chirp}})
+		loaders.users.Want(chirp.UserID)
+		loaders.chirpsByAuthor.Want(chirp.UserID)
+		loaders.favouriteCounts.Want(chirp.ID)
+		loaders.favourited.Want(chirp.ID)
+	}
+	return conn, nil
+}
+
+func (c *graphqlChirpConnection) Edges() []*graphqlChirpEdge { return c.edges }
+
+func (c *graphqlChirpConnection) PageInfo() *graphqlPageInfo {
+	info := &graphqlPageInfo{hasNextPage: c.hasNextPage}
+	if len(c.edges) > 0 {
+		cursor := c.edges[len(c.edges)-1].Cursor()
+		info.endCursor = &cursor
+	}
+	return info
+}
+
+type graphqlChirpEdge struct {
+	node *graphqlChirp
+}
+
+func (e *graphqlChirpEdge) Cursor() string      { return encodeChirpCursor(e.node.chirp.ID) }
+func (e *graphqlChirpEdge) Node() *graphqlChirp { return e.node }
+
+type graphqlPageInfo struct {
+	hasNextPage bool
+	endCursor   *string
+}
+
+func (p *graphqlPageInfo) HasNextPage() bool  { return p.hasNextPage }
+func (p *graphqlPageInfo) EndCursor() *string { return p.endCursor }
+
+func encodeChirpCursor(id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString(id[:])
+}
+
+func decodeChirpCursor(cursor string) (uuid.UUID, error) {
+	dat, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return uuid.FromBytes(dat)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/grpcapi/chirpyv1"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Methods that run without an access token
+var grpcPublicMethods = map[string]bool{
+	chirpyv1.ChirpyService_Register_FullMethodName:   true,
+	chirpyv1.ChirpyService_Login_FullMethodName:      true,
+	chirpyv1.ChirpyService_ListChirps_FullMethodName: true,
+	chirpyv1.ChirpyService_GetChirp_FullMethodName:   true,
+}
+
+// Methods that only read, and keep working in read-only maintenance mode
+var grpcReadMethods = map[string]bool{
+	chirpyv1.ChirpyService_ListChirps_FullMethodName: true,
+	chirpyv1.ChirpyService_GetChirp_FullMethodName:   true,
+}
+
+type grpcUserIDKey struct{}
+
+// Builds the gRPC server for the ChirpyService. Served on GRPC_ADDR when that is set.
+func (cfg *apiConfig) newGRPCServer() *grpc.Server {
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(cfg.grpcIPFilterInterceptor, cfg.grpcTenantInterceptor, cfg.grpcMaintenanceInterceptor, cfg.grpcAuthInterceptor))
+	chirpyv1.RegisterChirpyServiceServer(srv, &grpcService{cfg: cfg})
+	return srv
+}
+
+// Validates the access token in the "authorization" metadata the same way the HTTP
+// handlers validate the Authorization header, and passes the user ID on in the context.
+// Public methods only check a token that is sent, which lets a shadowbanned user read
+// their own chirps.
+func (cfg *apiConfig) grpcAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if grpcPublicMethods[info.FullMethod] && len(md.Get("authorization")) == 0 {
+		return handler(ctx, req)
+	}
+	userID, err := cfg.userFromHeader(http.Header{"Authorization": md.Get("authorization")})
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return handler(withViewer(context.WithValue(ctx, grpcUserIDKey{}, userID), userID), req)
+}
+
+type grpcService struct {
+	chirpyv1.UnimplementedChirpyServiceServer
+	cfg *apiConfig
+}
+
+func (s *grpcService) Register(ctx context.Context, req *chirpyv1.RegisterRequest) (*chirpyv1.User, error) {
+	if !s.cfg.isDevPlatform() {
+		return nil, status.Error(codes.PermissionDenied, "registration is only available in dev mode")
+	}
+	user, err := s.cfg.registerUser(ctx, req.GetEmail(), req.GetPassword())
+	if err != nil {
+		return nil, grpcError("registering user", err)
+	}
+	return &chirpyv1.User{
+		Id:          user.ID.String(),
+		CreatedAt:   timestamppb.New(user.CreatedAt),
+		UpdatedAt:   timestamppb.New(user.UpdatedAt),
+		Email:       user.Email,
+		IsChirpyRed: user.IsChirpyRed,
+	}, nil
+}
+
+func (s *grpcService) Login(ctx context.Context, req *chirpyv1.LoginRequest) (*chirpyv1.LoginResponse, error) {
+	user, err := s.cfg.login(ctx, req.GetEmail(), req.GetPassword())
+	if err != nil {
+		return nil, grpcError("logging in", err)
+	}
+	return &chirpyv1.LoginResponse{
+		User: &chirpyv1.User{
+			Id:          user.ID.String(),
+			CreatedAt:   timestamppb.New(user.CreatedAt),
+			UpdatedAt:   timestamppb.New(user.UpdatedAt),
+			Email:       user.Email,
+			IsChirpyRed: user.IsChirpyRed,
+		},
+		Token:        user.Token,
+		RefreshToken: user.RefreshToken,
+	}, nil
+}
+
+func (s *grpcService) CreateChirp(ctx context.Context, req *chirpyv1.CreateChirpRequest) (*chirpyv1.Chirp, error) {
+	userID, _ := ctx.Value(grpcUserIDKey{}).(uuid.UUID)
+	chirp, err := s.cfg.createChirp(ctx, userID, req.GetBody())
+	if err != nil {
+		return nil, grpcError("creating chirp", err)
+	}
+	return newGRPCChirp(chirp), nil
+}
+
+func (s *grpcService) ListChirps(ctx context.Context, req *chirpyv1.ListChirpsRequest) (*chirpyv1.ListChirpsResponse, error) {
+	authorID := uuid.Nil
+	if req.GetAuthorId() != "" {
+		var err error
+		authorID, err = uuid.Parse(req.GetAuthorId())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid author_id")
+		}
+	}
+	chirps, err := s.cfg.listChirps(ctx, authorID, req.GetDescending())
+	if err != nil {
+		return nil, grpcError("listing chirps", err)
+	}
+	resp := &chirpyv1.ListChirpsResponse{Chirps: make([]*chirpyv1.Chirp, 0, len(chirps))}
+	for _, chirp := range chirps {
+		resp.Chirps = append(resp.Chirps, newGRPCChirp(chirp))
+	}
+	return resp, nil
+}
+
+func (s *grpcService) GetChirp(ctx context.Context, req *chirpyv1.GetChirpRequest) (*chirpyv1.Chirp, error) {
+	id, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid id")
+	}
+	chirp, err := s.cfg.getChirpById(ctx, id)
+	if err != nil {
+		return nil, grpcError("getting chirp", err)
+	}
+	return newGRPCChirp(chirp), nil
+}
+
+func newGRPCChirp(chirp database.Chirp) *chirpyv1.Chirp {
+	return &chirpyv1.Chirp{Id: chirp.ID.String(), Body: chirp.Body, UserId: chirp.UserID.String()}
+}
+
+// Helper function to map a service layer error to a gRPC status, logging unexpected ones
+func grpcError(action string, err error) error {
+	if constraint, ok := uniqueViolation(err); ok {
+		return status.Error(codes.AlreadyExists, conflictError(constraint).Message)
+	}
+	var invalid invalidInputError
+	var exceeded *quotaExceededError
+	switch {
+	case errors.As(err, &invalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.As(err, &exceeded):
+		return status.Error(codes.ResourceExhausted, exceeded.Error())
+	case errors.Is(err, errInvalidPassword):
+		return status.Error(codes.Unauthenticated, "incorrect email or password")
+	case errors.Is(err, errTenantUserLimit):
+		return status.Error(codes.ResourceExhausted, errTenantUserLimit.Message)
+	case errors.Is(err, errOtherTenant):
+		return status.Error(codes.PermissionDenied, errOtherTenant.Message)
+	case errors.Is(err, sql.ErrNoRows):
+		return status.Error(codes.NotFound, "not found")
+	case isQueryTimeout(err):
+		log.Printf("Error %s over gRPC: %s", action, err.Error())
+		return status.Error(codes.DeadlineExceeded, "the database did not answer in time")
+	}
+	log.Printf("Error %s over gRPC: %s", action, err.Error())
+	return status.Error(codes.Internal, "internal error")
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Response headers stored with an idempotent response and replayed with it, besides
+// Content-Type. Headers the surrounding middleware sets per request, such as
+// X-Request-ID and the rate limit ones, are set afresh on a replay instead.
+var idempotentHeaders = []string{"Location", "ETag", "Link", "Content-Disposition"}
+
+// Middleware that makes a POST safe to retry: the first response to an Idempotency-Key
+// is stored and replayed for later requests with the same key, instead of running the
+// handler again. Requests without the header are unaffected.
+func (cfg *apiConfig) middlewareIdempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > 255 {
+			marshallError(w, fmt.Errorf("Idempotency-Key must be at most 255 characters"), 400)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			marshallError(w, err, decodeErrorStatus(err))
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(body)
+		fingerprint := hex.EncodeToString(sum[:])
+		scope := r.Method + " " + r.URL.Path
+		// keys are per caller, so callers cannot read back or block each other's responses
+		if caller := cfg.idempotencyCaller(r); caller != "" {
+			scope = caller + " " + scope
+		}
+		// keys are per tenant as well, the default tenant keeps the plain scope
+		if id := tenantID(r.Context()); id != uuid.Nil {
+			scope = id.String() + " " + scope
+		}
+
+		claimed, err := cfg.claimIdempotencyKey(r.Context(), scope, key, fingerprint)
+		if err != nil {
+			log.Printf("Error claiming idempotency key: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		if !claimed {
+			cfg.replayIdempotentResponse(w, r, scope, key, fingerprint)
+			return
+		}
+		ids := database.DeleteIdempotencyKeyParams{Scope: scope, IdempotencyKey: key}
+		rec := &recordingWriter{ResponseWriter: w, status: 200}
+		defer func() {
+			// a panicking handler must not leave the key stuck in processing
+			if p := recover(); p != nil {
+				cfg.store.DeleteIdempotencyKey(context.WithoutCancel(r.Context()), ids)
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		// server errors are not remembered, so the client can retry them with the same key
+		if rec.status >= 500 {
+			err = cfg.store.DeleteIdempotencyKey(context.WithoutCancel(r.Context()), ids)
+		} else {
+			err = cfg.store.CompleteIdempotencyKey(context.WithoutCancel(r.Context()), database.CompleteIdempotencyKeyParams{
+				Scope:          scope,
+				IdempotencyKey: key,
+				StatusCode:     int32(rec.status),
+				ContentType:    rec.Header().Get("Content-Type"),
+				Body:           rec.body.String(),
+				Headers:        encodeIdempotentHeaders(rec.Header()),
+			})
+		}
+		if err != nil {
+			log.Printf("Error saving idempotent response: %s", err.Error())
+		}
+	})
+}
+
+// Helper function to identify who sent a request: the signed-in user, so a retry with a
+// refreshed access token still matches, or else a hash of the credentials it sent, such
+// as an API key. Requests without credentials share the empty caller.
+func (cfg *apiConfig) idempotencyCaller(r *http.Request) string {
+	if viewer := viewerID(r.Context()); viewer != uuid.Nil {
+		return viewer.String()
+	}
+	if token, err := auth.GetBearerToken(r.Header); err == nil {
+		if userID, err := cfg.validateJWT(token); err == nil {
+			return userID.String()
+		}
+	}
+	credentials := r.Header.Get("Authorization")
+	if credentials == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(credentials))
+	return hex.EncodeToString(sum[:])
+}
+
+// Helper function to reserve a key for this request, reclaiming it if an earlier use has expired
+func (cfg *apiConfig) claimIdempotencyKey(ctx context.Context, scope, key, fingerprint string) (bool, error) {
+	now := time.Now().UTC()
+	for range 2 {
+		_, err := cfg.store.ClaimIdempotencyKey(ctx, database.ClaimIdempotencyKeyParams{
+			Scope:          scope,
+			IdempotencyKey: key,
+			Fingerprint:    fingerprint,
+			ExpiresAt:      now.Add(cfg.idempotencyTTL),
+		})
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+		existing, err := cfg.store.GetIdempotencyKey(ctx, database.GetIdempotencyKeyParams{Scope: scope, IdempotencyKey: key})
+		if errors.Is(err, sql.ErrNoRows) {
+			// deleted after a server error in between, try again
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if !existing.ExpiresAt.Before(now) {
+			return false, nil
+		}
+		err = cfg.store.DeleteIdempotencyKey(ctx, database.DeleteIdempotencyKeyParams{Scope: scope, IdempotencyKey: key})
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// Helper function to keep the idempotentHeaders of a response, as a JSON object
+func encodeIdempotentHeaders(header http.Header) string {
+	kept := make(http.Header)
+	for _, name := range idempotentHeaders {
+		if values := header.Values(name); len(values) > 0 {
+			kept[name] = values
+		}
+	}
+	dat, err := json.Marshal(kept)
+	if err != nil {
+		return "{}"
+	}
+	return string(dat)
+}
+
+// Helper function to answer a repeated key with the stored response
+func (cfg *apiConfig) replayIdempotentResponse(w http.ResponseWriter, r *http.Request, scope, key, fingerprint string) {
+	existing, err := cfg.store.GetIdempotencyKey(r.Context(), database.GetIdempotencyKeyParams{Scope: scope, IdempotencyKey: key})
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, &apiError{Code: "idempotency_key_in_use", Message: "a request with this Idempotency-Key is in progress"}, 409)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading idempotency key: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if existing.Fingerprint != fingerprint {
+		marshallError(w, &apiError{Code: "idempotency_key_reused", Message: "Idempotency-Key was already used for a different request"}, 422)
+		return
+	}
+	if existing.Status != "done" {
+		marshallError(w, &apiError{Code: "idempotency_key_in_use", Message: "a request with this Idempotency-Key is in progress"}, 409)
+		return
+	}
+	if existing.ContentType != "" {
+		w.Header().Set("Content-Type", existing.ContentType)
+	}
+	var headers http.Header
+	if err := json.Unmarshal([]byte(existing.Headers), &headers); err != nil {
+		log.Printf("Error reading idempotent response headers: %s", err.Error())
+	}
+	for name, values := range headers {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(existing.StatusCode))
+	w.Write([]byte(existing.Body))
+}
+
+// recordingWriter passes a response through while keeping a copy of its status and body
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,540 @@
+// Package activitypub federates users with ActivityPub servers such as Mastodon. Each
+// user is a Person actor with an RSA key of its own. Remote actors follow users through
+// their inbox, and chirps are delivered to the followers' inboxes as Notes by background
+// jobs, so each delivery is retried with the job queue's backoff like a webhook's.
+// Requests are signed and verified with HTTP signatures.
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/cache"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+	"github.com/diamondoughnut/httpChirpy/internal/netguard"
+	"github.com/google/uuid"
+)
+
+const (
+	// ContentType is the media type of ActivityPub documents
+	ContentType = "application/activity+json"
+	// ActivityStreams is the JSON-LD context of every document
+	ActivityStreams = "https://www.w3.org/ns/activitystreams"
+	// Public addresses an activity to everyone
+	Public = ActivityStreams + "#Public"
+	// the context of actors, which publish their key
+	security = "https://w3id.org/security/v1"
+)
+
+// JobKind is the background job kind used for deliveries
+const JobKind = "activitypub.deliver"
+
+// SignatureTolerance is how far the Date of a signed request may be from now
+const SignatureTolerance = time.Hour
+
+// the largest remote document read
+const maxDocumentBytes = 1 << 20
+
+// remote actors are cached by key id for this long, so a burst of activities from one
+// server does not fetch its actor every time
+const actorCacheTTL = time.Hour
+
+// Store is the persistence federation needs; store.Store satisfies it
+type Store interface {
+	jobs.Store
+	EnqueueJobs(ctx context.Context, args []database.EnqueueJobParams) error
+	CreateActivityPubKey(ctx context.Context, arg database.CreateActivityPubKeyParams) (database.ActivitypubKey, error)
+	GetActivityPubKey(ctx context.Context, userID uuid.UUID) (database.ActivitypubKey, error)
+	ListActivityPubInboxes(ctx context.Context, userID uuid.UUID) ([]string, error)
+}
+
+// PublicKey is the key an actor signs its requests with
+type PublicKey struct {
+	ID           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// Endpoints are an actor's optional server-wide endpoints
+type Endpoints struct {
+	SharedInbox string `json:"sharedInbox,omitempty"`
+}
+
+// Actor is the document describing a user, a local or a remote one
+type Actor struct {
+	Context                   any        `json:"@context,omitempty"`
+	ID                        string     `json:"id"`
+	Type                      string     `json:"type"`
+	PreferredUsername         string     `json:"preferredUsername,omitempty"`
+	Inbox                     string     `json:"inbox"`
+	Outbox                    string     `json:"outbox,omitempty"`
+	Followers                 string     `json:"followers,omitempty"`
+	URL                       string     `json:"url,omitempty"`
+	ManuallyApprovesFollowers bool       `json:"manuallyApprovesFollowers"`
+	PublicKey                 PublicKey  `json:"publicKey"`
+	Endpoints                 *Endpoints `json:"endpoints,omitempty"`
+}
+
+// SharedInbox returns the actor's server-wide inbox, "" when it has none
+func (a Actor) SharedInbox() string {
+	if a.Endpoints == nil {
+		return ""
+	}
+	return a.Endpoints.SharedInbox
+}
+
+// Note is a chirp as ActivityPub object
+type Note struct {
+	Context      any       `json:"@context,omitempty"`
+	ID           string    `json:"id"`
+	Type         string    `json:"type"`
+	AttributedTo string    `json:"attributedTo"`
+	Content      string    `json:"content"`
+	Published    time.Time `json:"published"`
+	To           []string  `json:"to"`
+	Cc           []string  `json:"cc"`
+}
+
+// Tombstone replaces a deleted object
+type Tombstone struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// Activity is an activity sent by a local actor
+type Activity struct {
+	Context any      `json:"@context,omitempty"`
+	ID      string   `json:"id"`
+	Type    string   `json:"type"`
+	Actor   string   `json:"actor"`
+	Object  any      `json:"object"`
+	To      []string `json:"to,omitempty"`
+	Cc      []string `json:"cc,omitempty"`
+}
+
+// Incoming is an activity received in an inbox. Its object is left undecoded, as it
+// may be embedded or only referenced by id.
+type Incoming struct {
+	ID     string          `json:"id"`
+	Type   string          `json:"type"`
+	Actor  string          `json:"actor"`
+	Object json.RawMessage `json:"object"`
+}
+
+// ObjectID returns the id of the activity's object, whether embedded or referenced
+func (a Incoming) ObjectID() string {
+	var id string
+	if json.Unmarshal(a.Object, &id) == nil {
+		return id
+	}
+	var object struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(a.Object, &object)
+	return object.ID
+}
+
+// ObjectType returns the type of an embedded object, "" when it is only referenced
+func (a Incoming) ObjectType() string {
+	var object struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(a.Object, &object)
+	return object.Type
+}
+
+// OrderedCollection is an actor's outbox or followers
+type OrderedCollection struct {
+	Context      any    `json:"@context,omitempty"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	TotalItems   int64  `json:"totalItems"`
+	OrderedItems []any  `json:"orderedItems,omitempty"`
+}
+
+// WebFinger is the JRD a WebFinger lookup answers with
+type WebFinger struct {
+	Subject string          `json:"subject"`
+	Aliases []string        `json:"aliases,omitempty"`
+	Links   []WebFingerLink `json:"links"`
+}
+
+// WebFingerLink is one link of a WebFinger JRD
+type WebFingerLink struct {
+	Rel  string `json:"rel"`
+	Type string `json:"type,omitempty"`
+	Href string `json:"href"`
+}
+
+// delivery is the payload of a delivery job
+type delivery struct {
+	UserID   uuid.UUID       `json:"user_id"`
+	Inbox    string          `json:"inbox"`
+	Activity json.RawMessage `json:"activity"`
+}
+
+// Options configures a Federation
+type Options struct {
+	// BaseURL is the public URL of the server, such as https://chirpy.example. The ids
+	// of actors and notes are built from it, so it must not change once federating.
+	BaseURL string
+	// Timeout bounds each request to another server
+	Timeout time.Duration
+	// MaxAttempts is how many times a delivery is tried before its job is dead
+	MaxAttempts int
+	// AllowPrivate lets requests reach loopback and private addresses, which are
+	// refused otherwise so a remote actor cannot point us at internal services
+	AllowPrivate bool
+}
+
+// Federation serves local actors and delivers their activities
+type Federation struct {
+	store       Store
+	base        *url.URL
+	client      *http.Client
+	actors      *cache.LRU[string, Actor]
+	maxAttempts int
+	now         func() time.Time
+}
+
+// New builds a Federation and registers its delivery handler on queue
+func New(store Store, queue *jobs.Queue, opts Options) (*Federation, error) {
+	base, err := url.Parse(strings.TrimSuffix(opts.BaseURL, "/"))
+	if err != nil || (base.Scheme != "https" && base.Scheme != "http") || base.Host == "" || base.Path != "" {
+		return nil, fmt.Errorf("ActivityPub base URL %q must be an absolute http(s) URL without a path", opts.BaseURL)
+	}
+	f := &Federation{
+		store: store,
+		base:  base,
+		client: &http.Client{
+			Transport: netguard.Transport(opts.Timeout, opts.AllowPrivate),
+			Timeout:   opts.Timeout,
+			// a redirect could take a signed delivery or a key lookup to another server
+			CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		actors:      cache.NewLRU[string, Actor](1000, actorCacheTTL),
+		maxAttempts: max(opts.MaxAttempts, 1),
+		now:         func() time.Time { return time.Now().UTC() },
+	}
+	queue.Register(JobKind, f.deliver)
+	return f, nil
+}
+
+// Domain is the host users' WebFinger addresses are at
+func (f *Federation) Domain() string {
+	return f.base.Host
+}
+
+// ActorID is the id of a user's actor, which is also where its document is served
+func (f *Federation) ActorID(userID uuid.UUID) string {
+	return f.base.String() + "/ap/users/" + userID.String()
+}
+
+// KeyID is the id of the key a user signs with
+func (f *Federation) KeyID(userID uuid.UUID) string {
+	return f.ActorID(userID) + "#main-key"
+}
+
+// NoteID is the id of a chirp's Note
+func (f *Federation) NoteID(chirpID uuid.UUID) string {
+	return f.base.String() + "/ap/chirps/" + chirpID.String()
+}
+
+// Account is a user's WebFinger address. Users have no handle, so their id is the name.
+func (f *Federation) Account(userID uuid.UUID) string {
+	return "acct:" + userID.String() + "@" + f.Domain()
+}
+
+// ParseResource reads the user a WebFinger resource names, either their account or
+// their actor id
+func (f *Federation) ParseResource(resource string) (uuid.UUID, bool) {
+	if name, ok := strings.CutPrefix(resource, "acct:"); ok {
+		user, domain, ok := strings.Cut(name, "@")
+		if !ok || !strings.EqualFold(domain, f.Domain()) {
+			return uuid.Nil, false
+		}
+		id, err := uuid.Parse(user)
+		return id, err == nil
+	}
+	if id, ok := strings.CutPrefix(resource, f.base.String()+"/ap/users/"); ok {
+		userID, err := uuid.Parse(id)
+		return userID, err == nil
+	}
+	return uuid.Nil, false
+}
+
+// WebFinger describes where a user's actor is
+func (f *Federation) WebFinger(userID uuid.UUID) WebFinger {
+	return WebFinger{
+		Subject: f.Account(userID),
+		Aliases: []string{f.ActorID(userID)},
+		Links:   []WebFingerLink{{Rel: "self", Type: ContentType, Href: f.ActorID(userID)}},
+	}
+}
+
+// Actor builds a user's actor document, creating their key the first time
+func (f *Federation) Actor(ctx context.Context, userID uuid.UUID) (Actor, error) {
+	key, err := f.key(ctx, userID)
+	if err != nil {
+		return Actor{}, err
+	}
+	id := f.ActorID(userID)
+	return Actor{
+		Context:                   []string{ActivityStreams, security},
+		ID:                        id,
+		Type:                      "Person",
+		PreferredUsername:         userID.String(),
+		Inbox:                     id + "/inbox",
+		Outbox:                    id + "/outbox",
+		Followers:                 id + "/followers",
+		URL:                       id,
+		ManuallyApprovesFollowers: false,
+		PublicKey:                 PublicKey{ID: f.KeyID(userID), Owner: id, PublicKeyPem: key.PublicKeyPem},
+	}, nil
+}
+
+func (f *Federation) key(ctx context.Context, userID uuid.UUID) (database.ActivitypubKey, error) {
+	key, err := f.store.GetActivityPubKey(ctx, userID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		return key, err
+	}
+	public, private, err := GenerateKey()
+	if err != nil {
+		return database.ActivitypubKey{}, err
+	}
+	return f.store.CreateActivityPubKey(ctx, database.CreateActivityPubKeyParams{UserID: userID, PublicKeyPem: public, PrivateKeyPem: private})
+}
+
+// Note builds a chirp's Note, public and addressed to its author's followers
+func (f *Federation) Note(chirpID, userID uuid.UUID, body string, published time.Time) Note {
+	return Note{
+		ID:           f.NoteID(chirpID),
+		Type:         "Note",
+		AttributedTo: f.ActorID(userID),
+		Content:      "<p>" + html.EscapeString(body) + "</p>",
+		Published:    published.UTC(),
+		To:           []string{Public},
+		Cc:           []string{f.ActorID(userID) + "/followers"},
+	}
+}
+
+// Create wraps a Note in the activity announcing it
+func (f *Federation) Create(note Note) Activity {
+	return Activity{ID: note.ID + "#create", Type: "Create", Actor: note.AttributedTo, Object: note, To: note.To, Cc: note.Cc}
+}
+
+// Collection builds an ordered collection of a user's, such as their outbox
+func (f *Federation) Collection(id string, total int64, items []any) OrderedCollection {
+	return OrderedCollection{Context: ActivityStreams, ID: id, Type: "OrderedCollection", TotalItems: total, OrderedItems: items}
+}
+
+// Verify checks the HTTP signature of an inbox request whose body is body, and returns
+// the actor that signed it. Every error means the request could not be verified.
+func (f *Federation) Verify(ctx context.Context, r *http.Request, body []byte) (Actor, error) {
+	sig, err := ParseSignature(r.Header.Get("Signature"))
+	if err != nil {
+		return Actor{}, err
+	}
+	actor, cached, err := f.keyOwner(ctx, sig.KeyID)
+	if err != nil {
+		return Actor{}, err
+	}
+	err = verifyWith(r, body, sig, actor, f.now())
+	if errors.Is(err, errMismatch) && cached {
+		// the actor may have a new key since it was cached
+		f.actors.Delete(sig.KeyID)
+		actor, _, err = f.keyOwner(ctx, sig.KeyID)
+		if err != nil {
+			return Actor{}, err
+		}
+		err = verifyWith(r, body, sig, actor, f.now())
+	}
+	if err != nil {
+		return Actor{}, err
+	}
+	return actor, nil
+}
+
+func verifyWith(r *http.Request, body []byte, sig Signature, actor Actor, now time.Time) error {
+	key, err := ParsePublicKey(actor.PublicKey.PublicKeyPem)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidSignature, err.Error())
+	}
+	return VerifyRequest(r, body, sig, key, now, SignatureTolerance)
+}
+
+// keyOwner returns the actor a key id belongs to, and whether it came from the cache
+func (f *Federation) keyOwner(ctx context.Context, keyID string) (Actor, bool, error) {
+	if actor, ok := f.actors.Get(keyID); ok {
+		return actor, true, nil
+	}
+	keyURL, err := url.Parse(keyID)
+	if err != nil {
+		return Actor{}, false, fmt.Errorf("%w: invalid keyId", ErrInvalidSignature)
+	}
+	keyURL.Fragment = ""
+	actor, err := f.FetchActor(ctx, keyURL.String())
+	if err != nil {
+		return Actor{}, false, fmt.Errorf("%w: fetching the key's actor: %s", ErrInvalidSignature, err.Error())
+	}
+	if actor.PublicKey.ID != keyID {
+		return Actor{}, false, fmt.Errorf("%w: the key does not belong to %s", ErrInvalidSignature, actor.ID)
+	}
+	f.actors.Set(keyID, actor)
+	return actor, false, nil
+}
+
+// FetchActor fetches a remote actor's document. Its id must be on the host it was
+// fetched from, so one server cannot speak for another's actors.
+func (f *Federation) FetchActor(ctx context.Context, id string) (Actor, error) {
+	target, err := url.Parse(id)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") {
+		return Actor{}, fmt.Errorf("actor id %q is not an http(s) URL", id)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return Actor{}, err
+	}
+	req.Header.Set("Accept", ContentType+`, application/ld+json; profile="`+ActivityStreams+`"`)
+	req.Header.Set("User-Agent", "Chirpy-ActivityPub/1.0")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return Actor{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Actor{}, fmt.Errorf("%s responded %d", id, resp.StatusCode)
+	}
+	var actor Actor
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxDocumentBytes)).Decode(&actor)
+	if err != nil {
+		return Actor{}, fmt.Errorf("decoding actor %s: %w", id, err)
+	}
+	actorURL, err := url.Parse(actor.ID)
+	if err != nil || actorURL.Host != target.Host {
+		return Actor{}, fmt.Errorf("actor %s is not on %s", actor.ID, target.Host)
+	}
+	if actor.Inbox == "" {
+		return Actor{}, fmt.Errorf("actor %s has no inbox", actor.ID)
+	}
+	return actor, nil
+}
+
+// Accept enqueues through store the Accept of a remote actor's Follow of a user
+func (f *Federation) Accept(ctx context.Context, store Store, userID uuid.UUID, follower Actor, follow json.RawMessage) error {
+	accept := Activity{
+		Context: ActivityStreams,
+		ID:      f.ActorID(userID) + "#accepts/" + uuid.NewString(),
+		Type:    "Accept",
+		Actor:   f.ActorID(userID),
+		Object:  follow,
+	}
+	return f.enqueue(ctx, store, userID, []string{follower.Inbox}, accept)
+}
+
+// PublishCreate enqueues through store the delivery of a new chirp to its author's
+// followers, so it can be part of the transaction relaying the chirp's event
+func (f *Federation) PublishCreate(ctx context.Context, store Store, note Note, userID uuid.UUID) error {
+	inboxes, err := store.ListActivityPubInboxes(ctx, userID)
+	if err != nil || len(inboxes) == 0 {
+		return err
+	}
+	create := f.Create(note)
+	create.Context = ActivityStreams
+	return f.enqueue(ctx, store, userID, inboxes, create)
+}
+
+// PublishDelete enqueues through store the deletion of a chirp at its author's followers
+func (f *Federation) PublishDelete(ctx context.Context, store Store, chirpID, userID uuid.UUID) error {
+	inboxes, err := store.ListActivityPubInboxes(ctx, userID)
+	if err != nil || len(inboxes) == 0 {
+		return err
+	}
+	id := f.NoteID(chirpID)
+	return f.enqueue(ctx, store, userID, inboxes, Activity{
+		Context: ActivityStreams,
+		ID:      id + "#delete",
+		Type:    "Delete",
+		Actor:   f.ActorID(userID),
+		Object:  Tombstone{ID: id, Type: "Tombstone"},
+		To:      []string{Public},
+	})
+}
+
+// enqueue adds one delivery job per inbox
+func (f *Federation) enqueue(ctx context.Context, store Store, userID uuid.UUID, inboxes []string, activity Activity) error {
+	body, err := json.Marshal(activity)
+	if err != nil {
+		return fmt.Errorf("encoding %s activity: %w", activity.Type, err)
+	}
+	deliveries := make([]database.EnqueueJobParams, 0, len(inboxes))
+	for _, inbox := range inboxes {
+		params, err := jobs.JobParams(JobKind, delivery{UserID: userID, Inbox: inbox, Activity: body}, f.now(), f.maxAttempts)
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, params)
+	}
+	err = store.EnqueueJobs(ctx, deliveries)
+	if err != nil {
+		return fmt.Errorf("enqueueing %s deliveries: %w", activity.Type, err)
+	}
+	return nil
+}
+
+// deliver is the job handler for one delivery attempt
+func (f *Federation) deliver(ctx context.Context, payload json.RawMessage) error {
+	var job delivery
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return jobs.Permanent(fmt.Errorf("decoding delivery: %w", err))
+	}
+	key, err := f.store.GetActivityPubKey(ctx, job.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the user was deleted after the activity was enqueued
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	private, err := parsePrivateKey(key.PrivateKeyPem)
+	if err != nil {
+		return jobs.Permanent(fmt.Errorf("reading the key of %s: %w", job.UserID, err))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.Inbox, bytes.NewReader(job.Activity))
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	req.Header.Set("Content-Type", ContentType)
+	req.Header.Set("User-Agent", "Chirpy-ActivityPub/1.0")
+	err = SignRequest(req, job.Activity, f.KeyID(job.UserID), private, f.now())
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxDocumentBytes))
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+	err = fmt.Errorf("inbox %s responded %d", job.Inbox, resp.StatusCode)
+	if resp.StatusCode >= 400 && resp.StatusCode <= 499 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		// the server refused the activity, and would again
+		return jobs.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,212 @@
+package activitypub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/google/uuid"
+)
+
+// newKey generates a key pair, returning the private key and the public one as PEM
+func newKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+	public, private, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("Expected no error generating a key, got %v", err)
+	}
+	key, err := parsePrivateKey(private)
+	if err != nil {
+		t.Fatalf("Expected no error parsing the private key, got %v", err)
+	}
+	return key, public
+}
+
+func signedRequest(t *testing.T, key *rsa.PrivateKey, body []byte, now time.Time) *http.Request {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "https://chirpy.example/ap/users/1/inbox", bytes.NewReader(body))
+	err := SignRequest(req, body, "https://remote.example/users/alice#main-key", key, now)
+	if err != nil {
+		t.Fatalf("Expected no error signing, got %v", err)
+	}
+	return req
+}
+
+func TestVerifyRequest(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"type":"Follow"}`)
+	private, publicPEM := newKey(t)
+	public, err := ParsePublicKey(publicPEM)
+	if err != nil {
+		t.Fatalf("Expected no error parsing the public key, got %v", err)
+	}
+	_, otherPEM := newKey(t)
+	other, _ := ParsePublicKey(otherPEM)
+
+	tests := []struct {
+		name    string
+		modify  func(req *http.Request) []byte
+		key     *rsa.PublicKey
+		wantErr bool
+	}{
+		{name: "valid", modify: func(req *http.Request) []byte { return body }, key: public},
+		{name: "other key", modify: func(req *http.Request) []byte { return body }, key: other, wantErr: true},
+		{name: "tampered body", modify: func(req *http.Request) []byte { return []byte(`{"type":"Undo"}`) }, key: public, wantErr: true},
+		{name: "other path", modify: func(req *http.Request) []byte {
+			req.URL.Path = "/ap/users/2/inbox"
+			return body
+		}, key: public, wantErr: true},
+		{name: "stale date", modify: func(req *http.Request) []byte {
+			req.Header.Set("Date", now.Add(-2*time.Hour).UTC().Format(http.TimeFormat))
+			return body
+		}, key: public, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := signedRequest(t, private, body, now)
+			got := tt.modify(req)
+			sig, err := ParseSignature(req.Header.Get("Signature"))
+			if err != nil {
+				t.Fatalf("Expected no error parsing the signature, got %v", err)
+			}
+			err = VerifyRequest(req, got, sig, tt.key, now, SignatureTolerance)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %t, got %v", tt.wantErr, err)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRequest_RequiresDigestToBeSigned(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{}`)
+	private, publicPEM := newKey(t)
+	public, _ := ParsePublicKey(publicPEM)
+	req := signedRequest(t, private, body, now)
+	sig, _ := ParseSignature(req.Header.Get("Signature"))
+	sig.Headers = []string{"(request-target)", "host", "date"}
+	err := VerifyRequest(req, body, sig, public, now, SignatureTolerance)
+	if err == nil || !strings.Contains(err.Error(), "digest is not signed") {
+		t.Errorf("Expected digest to be required, got %v", err)
+	}
+}
+
+func TestFederation_ParseResource(t *testing.T) {
+	s := store.NewMemory()
+	f, err := New(s, jobs.New(s, jobs.Options{}), Options{BaseURL: "https://chirpy.example/"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	id := uuid.New()
+	tests := []struct {
+		resource string
+		want     bool
+	}{
+		{"acct:" + id.String() + "@chirpy.example", true},
+		{"acct:" + id.String() + "@CHIRPY.example", true},
+		{"https://chirpy.example/ap/users/" + id.String(), true},
+		{"acct:" + id.String() + "@elsewhere.example", false},
+		{"acct:alice@chirpy.example", false},
+		{"https://elsewhere.example/ap/users/" + id.String(), false},
+	}
+	for _, tt := range tests {
+		got, ok := f.ParseResource(tt.resource)
+		if ok != tt.want || (ok && got != id) {
+			t.Errorf("ParseResource(%q) = %v, %t, want %t", tt.resource, got, ok, tt.want)
+		}
+	}
+}
+
+func TestNew_RejectsBaseURLWithPath(t *testing.T) {
+	s := store.NewMemory()
+	_, err := New(s, jobs.New(s, jobs.Options{}), Options{BaseURL: "https://chirpy.example/chirpy"})
+	if err == nil {
+		t.Error("Expected an error for a base URL with a path")
+	}
+}
+
+// A federation delivering to itself: the server serves its users' actors and checks
+// the signatures of what arrives in their inboxes
+func TestFederation_DeliversSignedActivities(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemory()
+	q := jobs.New(s, jobs.Options{})
+	var f *Federation
+	var verified []Actor
+	var verifyErr error
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ap/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		actor, err := f.Actor(r.Context(), uuid.MustParse(r.PathValue("id")))
+		if err != nil {
+			t.Errorf("Expected no error building the actor, got %v", err)
+		}
+		json.NewEncoder(w).Encode(actor)
+	})
+	mux.HandleFunc("POST /inbox", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		actor, err := f.Verify(r.Context(), r, body)
+		verifyErr = err
+		verified = append(verified, actor)
+		w.WriteHeader(http.StatusAccepted)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	f, err := New(s, q, Options{BaseURL: server.URL, Timeout: time.Second, MaxAttempts: 3, AllowPrivate: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	user, _ := s.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	_, err = s.AddActivityPubFollower(ctx, database.AddActivityPubFollowerParams{UserID: user.ID, ActorID: server.URL + "/remote", Inbox: server.URL + "/inbox", FollowID: "f"})
+	if err != nil {
+		t.Fatalf("Expected no error adding a follower, got %v", err)
+	}
+	_, err = f.Actor(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Expected no error creating the key, got %v", err)
+	}
+	chirpID := uuid.New()
+	err = f.PublishCreate(ctx, s, f.Note(chirpID, user.ID, "hello <world>", time.Now()), user.ID)
+	if err != nil {
+		t.Fatalf("Expected no error publishing, got %v", err)
+	}
+	ran, err := q.RunOnce(ctx)
+	if err != nil || !ran {
+		t.Fatalf("Expected the delivery to run, got %t, %v", ran, err)
+	}
+	if verifyErr != nil {
+		t.Fatalf("Expected the delivery to verify, got %v", verifyErr)
+	}
+	if len(verified) != 1 || verified[0].ID != f.ActorID(user.ID) {
+		t.Errorf("Expected the delivery to be signed by %s, got %+v", f.ActorID(user.ID), verified)
+	}
+}
+
+func TestFederation_RefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no request to reach a loopback address")
+	}))
+	defer server.Close()
+	s := store.NewMemory()
+	f, err := New(s, jobs.New(s, jobs.Options{}), Options{BaseURL: "https://chirpy.example", Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	_, err = f.FetchActor(context.Background(), server.URL+"/users/alice")
+	if err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Errorf("Expected the loopback address to be refused, got %v", err)
+	}
+}
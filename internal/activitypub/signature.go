@@ -0,0 +1,203 @@
+package activitypub
+
+import (
+	"cmp"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// the headers outgoing requests sign, and that incoming ones must sign
+var signedHeaders = []string{"(request-target)", "host", "date", "digest"}
+
+// ErrInvalidSignature is wrapped by every error meaning a request is not signed by
+// the actor it claims to come from
+var ErrInvalidSignature = errors.New("invalid HTTP signature")
+
+// errMismatch means the signature was checked and does not match the key, which is
+// worth retrying with a freshly fetched key in case the actor rotated it
+var errMismatch = fmt.Errorf("%w: signature does not match the key", ErrInvalidSignature)
+
+var signatureParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// Signature is a parsed Signature header, as described by draft-cavage-http-signatures
+// which Mastodon and most of the fediverse use
+type Signature struct {
+	KeyID     string
+	Algorithm string
+	Headers   []string
+	Value     []byte
+}
+
+// ParseSignature reads a Signature header
+func ParseSignature(header string) (Signature, error) {
+	var sig Signature
+	headers := "date"
+	for _, match := range signatureParam.FindAllStringSubmatch(header, -1) {
+		switch match[1] {
+		case "keyId":
+			sig.KeyID = match[2]
+		case "algorithm":
+			sig.Algorithm = match[2]
+		case "headers":
+			headers = match[2]
+		case "signature":
+			value, err := base64.StdEncoding.DecodeString(match[2])
+			if err != nil {
+				return Signature{}, fmt.Errorf("%w: signature is not base64", ErrInvalidSignature)
+			}
+			sig.Value = value
+		}
+	}
+	if sig.KeyID == "" || sig.Value == nil {
+		return Signature{}, fmt.Errorf("%w: missing keyId or signature", ErrInvalidSignature)
+	}
+	sig.Headers = strings.Fields(strings.ToLower(headers))
+	return sig, nil
+}
+
+// Digest returns the Digest header of body
+func Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// SignRequest sets the Date, Digest and Signature headers of req, whose body is body,
+// signing them along with its target and host with the key keyID names
+func SignRequest(req *http.Request, body []byte, keyID string, key *rsa.PrivateKey, now time.Time) error {
+	req.Header.Set("Date", now.UTC().Format(http.TimeFormat))
+	req.Header.Set("Digest", Digest(body))
+	signing, err := signingString(req, signedHeaders)
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256([]byte(signing))
+	value, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Signature", fmt.Sprintf(`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyID, strings.Join(signedHeaders, " "), base64.StdEncoding.EncodeToString(value)))
+	return nil
+}
+
+// VerifyRequest checks that sig, the signature of req whose body is body, was made
+// with key. The signature must cover the request target, host, date and digest, the
+// Digest header must match body, and Date must be within tolerance of now so a
+// captured request cannot be replayed later.
+func VerifyRequest(req *http.Request, body []byte, sig Signature, key *rsa.PublicKey, now time.Time, tolerance time.Duration) error {
+	if sig.Algorithm != "" && sig.Algorithm != "rsa-sha256" && sig.Algorithm != "hs2019" {
+		return fmt.Errorf("%w: unsupported algorithm %s", ErrInvalidSignature, sig.Algorithm)
+	}
+	for _, name := range signedHeaders {
+		if !slices.Contains(sig.Headers, name) {
+			return fmt.Errorf("%w: %s is not signed", ErrInvalidSignature, name)
+		}
+	}
+	date, err := http.ParseTime(req.Header.Get("Date"))
+	if err != nil {
+		return fmt.Errorf("%w: missing or invalid Date", ErrInvalidSignature)
+	}
+	if age := now.Sub(date); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: Date is outside the tolerance", ErrInvalidSignature)
+	}
+	if req.Header.Get("Digest") != Digest(body) {
+		return fmt.Errorf("%w: Digest does not match the body", ErrInvalidSignature)
+	}
+	signing, err := signingString(req, sig.Headers)
+	if err != nil {
+		return err
+	}
+	hashed := sha256.Sum256([]byte(signing))
+	if rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig.Value) != nil {
+		return errMismatch
+	}
+	return nil
+}
+
+func signingString(req *http.Request, headers []string) (string, error) {
+	lines := make([]string, len(headers))
+	for i, name := range headers {
+		var value string
+		switch name {
+		case "(request-target)":
+			value = strings.ToLower(req.Method) + " " + req.URL.RequestURI()
+		case "host":
+			value = cmp.Or(req.Host, req.URL.Host)
+		default:
+			values := req.Header.Values(name)
+			if len(values) == 0 {
+				return "", fmt.Errorf("%w: signed header %s is missing", ErrInvalidSignature, name)
+			}
+			value = strings.Join(values, ", ")
+		}
+		lines[i] = name + ": " + value
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// GenerateKey creates an RSA key pair, PEM-encoded as PKIX and PKCS #8
+func GenerateKey() (publicPEM, privatePEM string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return "", "", err
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", "", err
+	}
+	private, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	publicPEM = string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public}))
+	privatePEM = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: private}))
+	return publicPEM, privatePEM, nil
+}
+
+// ParsePublicKey reads an RSA public key in PKIX or PKCS #1 PEM, as actors publish them
+func ParsePublicKey(text string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(text))
+	if block == nil {
+		return nil, errors.New("public key is not PEM")
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+func parsePrivateKey(text string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(text))
+	if block == nil {
+		return nil, errors.New("private key is not PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,435 @@
+// Package automod evaluates auto-moderation rules against new chirps. A rule is a set
+// of conditions over the chirp's content, its links and its author's account age and
+// posting velocity, all of which must hold, and the action to take when they do.
+package automod
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Action is what happens to a chirp a rule matches
+type Action string
+
+const (
+	// ActionFlag lets the chirp through but puts it up for review
+	ActionFlag Action = "flag"
+	// ActionHold keeps the chirp from readers until a moderator approves it
+	ActionHold Action = "hold"
+	// ActionReject refuses the chirp
+	ActionReject Action = "reject"
+	// ActionTightenRateLimit lets the chirp through and then limits how often its author
+	// may post, as set by the rule's Tighten
+	ActionTightenRateLimit Action = "tighten_rate_limit"
+)
+
+// How severe each action that decides the chirp's fate is. Tightening the rate limit
+// happens on top of whichever of them applies.
+var severity = map[Action]int{ActionFlag: 1, ActionHold: 2, ActionReject: 3}
+
+// What a condition looks at
+const (
+	// FieldContent is the chirp's text: contains a string ignoring case, or matches an
+	// RE2 regular expression
+	FieldContent = "content"
+	// FieldLength is how many characters the chirp has
+	FieldLength = "length"
+	// FieldLinks is how many http(s) and www. links the chirp has
+	FieldLinks = "links"
+	// FieldLinkDomain is whether a link points into one of a list of domains, their
+	// subdomains included
+	FieldLinkDomain = "link_domain"
+	// FieldAccountAge is how long ago the author signed up, as a Go duration
+	FieldAccountAge = "account_age"
+	// FieldVelocity is how many chirps the author posted within the condition's Window
+	// before this one
+	FieldVelocity = "velocity"
+)
+
+// Longest window a velocity condition or a tightened rate limit may count over
+const MaxWindow = 7 * 24 * time.Hour
+
+// Condition is one test of a chirp. Value is a JSON string, number or list of strings
+// depending on Field and Op.
+type Condition struct {
+	Field  string          `json:"field"`
+	Op     string          `json:"op"`
+	Value  json.RawMessage `json:"value"`
+	Window string          `json:"window,omitempty"`
+}
+
+// Tighten is the posting limit an ActionTightenRateLimit rule puts on the author: at
+// most MaxChirps within Window, for Duration
+type Tighten struct {
+	MaxChirps int    `json:"max_chirps"`
+	Window    string `json:"window"`
+	Duration  string `json:"duration"`
+}
+
+// Definition is what a rule tests and does, as it is stored
+type Definition struct {
+	Conditions []Condition `json:"conditions"`
+	Action     Action      `json:"action"`
+	Tighten    *Tighten    `json:"tighten,omitempty"`
+}
+
+// Rule is a stored definition with what identifies it
+type Rule struct {
+	ID      string
+	Name    string
+	Version int
+	Definition
+}
+
+// Restriction is a parsed Tighten
+type Restriction struct {
+	MaxChirps int
+	Window    time.Duration
+	Duration  time.Duration
+}
+
+// Input is what rules are evaluated against. Velocity has the author's chirp count for
+// every window Engine.Windows lists.
+type Input struct {
+	Body       string
+	AccountAge time.Duration
+	Velocity   map[time.Duration]int64
+}
+
+type compiledCondition struct {
+	Condition
+	text    string
+	re      *regexp.Regexp
+	number  float64
+	domains []string
+	window  time.Duration
+}
+
+type compiledRule struct {
+	Rule
+	conditions  []compiledCondition
+	restriction *Restriction
+}
+
+// Compile checks that def has at least one condition, that every condition tests a
+// known field in a way that field supports, and that its action is known
+func Compile(def Definition) error {
+	_, err := compile(Rule{Definition: def})
+	return err
+}
+
+func compile(rule Rule) (compiledRule, error) {
+	c := compiledRule{Rule: rule}
+	if len(rule.Conditions) == 0 {
+		return c, fmt.Errorf("a rule needs at least one condition")
+	}
+	for i, cond := range rule.Conditions {
+		compiled, err := compileCondition(cond)
+		if err != nil {
+			return c, fmt.Errorf("condition %d: %w", i+1, err)
+		}
+		c.conditions = append(c.conditions, compiled)
+	}
+	switch rule.Action {
+	case ActionFlag, ActionHold, ActionReject:
+		if rule.Tighten != nil {
+			return c, fmt.Errorf("tighten only goes with action %q", ActionTightenRateLimit)
+		}
+	case ActionTightenRateLimit:
+		if rule.Tighten == nil {
+			return c, fmt.Errorf("action %q needs tighten", ActionTightenRateLimit)
+		}
+		restriction, err := compileTighten(*rule.Tighten)
+		if err != nil {
+			return c, fmt.Errorf("tighten: %w", err)
+		}
+		c.restriction = &restriction
+	default:
+		return c, fmt.Errorf("unknown action %q", rule.Action)
+	}
+	return c, nil
+}
+
+func compileTighten(t Tighten) (Restriction, error) {
+	if t.MaxChirps < 1 {
+		return Restriction{}, fmt.Errorf("max_chirps must be at least 1")
+	}
+	window, err := parseDuration("window", t.Window, MaxWindow)
+	if err != nil {
+		return Restriction{}, err
+	}
+	duration, err := parseDuration("duration", t.Duration, 0)
+	if err != nil {
+		return Restriction{}, err
+	}
+	return Restriction{MaxChirps: t.MaxChirps, Window: window, Duration: duration}, nil
+}
+
+// Parses a duration greater than 0, and at most limit unless limit is 0
+func parseDuration(name, s string, limit time.Duration) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s %q must be a duration greater than 0, such as 10m", name, s)
+	}
+	if limit > 0 && d > limit {
+		return 0, fmt.Errorf("%s may be at most %s", name, limit)
+	}
+	return d, nil
+}
+
+func compileCondition(cond Condition) (compiledCondition, error) {
+	c := compiledCondition{Condition: cond}
+	if cond.Window != "" && cond.Field != FieldVelocity {
+		return c, fmt.Errorf("only %s takes a window", FieldVelocity)
+	}
+	switch cond.Field {
+	case FieldContent:
+		var s string
+		if err := decodeValue(cond.Value, &s); err != nil || s == "" {
+			return c, fmt.Errorf("%s needs a non-empty string value", cond.Field)
+		}
+		switch cond.Op {
+		case "contains":
+			c.text = strings.ToLower(s)
+		case "matches":
+			re, err := regexp.Compile(s)
+			if err != nil {
+				return c, fmt.Errorf("invalid regex: %w", err)
+			}
+			c.re = re
+		default:
+			return c, fmt.Errorf("%s supports contains and matches, not %q", cond.Field, cond.Op)
+		}
+	case FieldLinkDomain:
+		if cond.Op != "in" {
+			return c, fmt.Errorf("%s supports in, not %q", cond.Field, cond.Op)
+		}
+		if err := decodeValue(cond.Value, &c.domains); err != nil || len(c.domains) == 0 {
+			return c, fmt.Errorf("%s needs a list of domains", cond.Field)
+		}
+		for i, domain := range c.domains {
+			c.domains[i] = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), ".")
+		}
+	case FieldAccountAge:
+		var s string
+		if err := decodeValue(cond.Value, &s); err != nil {
+			return c, fmt.Errorf("%s needs a duration such as \"24h\"", cond.Field)
+		}
+		d, err := parseDuration("value", s, 0)
+		if err != nil {
+			return c, err
+		}
+		c.number = float64(d)
+	case FieldLength, FieldLinks, FieldVelocity:
+		if err := decodeValue(cond.Value, &c.number); err != nil || c.number < 0 {
+			return c, fmt.Errorf("%s needs a number of at least 0", cond.Field)
+		}
+		if cond.Field == FieldVelocity {
+			window, err := parseDuration("window", cond.Window, MaxWindow)
+			if err != nil {
+				return c, err
+			}
+			c.window = window
+		}
+	default:
+		return c, fmt.Errorf("unknown field %q", cond.Field)
+	}
+	if cond.Field != FieldContent && cond.Field != FieldLinkDomain {
+		if _, ok := compare[cond.Op]; !ok {
+			return c, fmt.Errorf("%s supports gt, gte, lt, lte and eq, not %q", cond.Field, cond.Op)
+		}
+	}
+	return c, nil
+}
+
+// Decodes a condition value, refusing a missing one
+func decodeValue(raw json.RawMessage, v any) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("missing value")
+	}
+	return json.Unmarshal(raw, v)
+}
+
+var compare = map[string]func(a, b float64) bool{
+	"gt":  func(a, b float64) bool { return a > b },
+	"gte": func(a, b float64) bool { return a >= b },
+	"lt":  func(a, b float64) bool { return a < b },
+	"lte": func(a, b float64) bool { return a <= b },
+	"eq":  func(a, b float64) bool { return a == b },
+}
+
+// Links are http(s) URLs, or addresses starting with www.
+var linkPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+|\bwww\.[^\s<>"]+`)
+
+// Returns the lowercased hosts of the links in s, in order
+func linkHosts(s string) []string {
+	links := linkPattern.FindAllString(s, -1)
+	hosts := make([]string, 0, len(links))
+	for _, link := range links {
+		if !strings.Contains(link, "://") {
+			link = "http://" + link
+		}
+		u, err := url.Parse(link)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		hosts = append(hosts, strings.ToLower(u.Hostname()))
+	}
+	return hosts
+}
+
+// Reports whether the condition holds for in, and describes what it saw
+func (c *compiledCondition) match(in Input, hosts []string) (bool, string) {
+	switch c.Field {
+	case FieldContent:
+		if c.re != nil {
+			return c.re.MatchString(in.Body), fmt.Sprintf("content matches %q", c.re.String())
+		}
+		return strings.Contains(strings.ToLower(in.Body), c.text), fmt.Sprintf("content contains %q", c.text)
+	case FieldLinkDomain:
+		for _, host := range hosts {
+			for _, domain := range c.domains {
+				if host == domain || strings.HasSuffix(host, "."+domain) {
+					return true, fmt.Sprintf("links to %s", host)
+				}
+			}
+		}
+		return false, ""
+	case FieldAccountAge:
+		return compare[c.Op](float64(in.AccountAge), c.number), fmt.Sprintf("account age %s %s %s", in.AccountAge.Round(time.Second), c.Op, time.Duration(c.number))
+	}
+	var got float64
+	switch c.Field {
+	case FieldLength:
+		got = float64(utf8.RuneCountInString(in.Body))
+	case FieldLinks:
+		got = float64(len(hosts))
+	case FieldVelocity:
+		got = float64(in.Velocity[c.window])
+		return compare[c.Op](got, c.number), fmt.Sprintf("%g chirps within %s %s %g", got, c.window, c.Op, c.number)
+	}
+	return compare[c.Op](got, c.number), fmt.Sprintf("%s %g %s %g", c.Field, got, c.Op, c.number)
+}
+
+// Engine evaluates rules against chirps. Build one with NewEngine and share it; it is
+// never modified and safe for concurrent use. A nil *Engine has no rules.
+type Engine struct {
+	rules []compiledRule
+}
+
+// NewEngine compiles rules, failing on the first one Compile refuses
+func NewEngine(rules []Rule) (*Engine, error) {
+	e := &Engine{rules: make([]compiledRule, 0, len(rules))}
+	for _, rule := range rules {
+		c, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+		}
+		e.rules = append(e.rules, c)
+	}
+	return e, nil
+}
+
+// Len reports how many rules the engine evaluates
+func (e *Engine) Len() int {
+	if e == nil {
+		return 0
+	}
+	return len(e.rules)
+}
+
+// NeedsAccountAge reports whether any rule looks at the author's account age, so
+// callers only look it up when it matters
+func (e *Engine) NeedsAccountAge() bool {
+	for _, rule := range e.rulesOrNil() {
+		for _, cond := range rule.conditions {
+			if cond.Field == FieldAccountAge {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Windows lists the windows velocity conditions count the author's chirps over
+func (e *Engine) Windows() []time.Duration {
+	var windows []time.Duration
+	for _, rule := range e.rulesOrNil() {
+		for _, cond := range rule.conditions {
+			if cond.Field == FieldVelocity && !slices.Contains(windows, cond.window) {
+				windows = append(windows, cond.window)
+			}
+		}
+	}
+	return windows
+}
+
+func (e *Engine) rulesOrNil() []compiledRule {
+	if e == nil {
+		return nil
+	}
+	return e.rules
+}
+
+// Match is a rule whose conditions all held, with what each of them saw
+type Match struct {
+	Rule    Rule
+	Reasons []string
+}
+
+// Result is what the rules made of a chirp
+type Result struct {
+	// every rule that matched, in the order they were given
+	Matches []Match
+	// the most severe of flag, hold and reject among the matches, "" when none
+	Action Action
+	// the strictest limit of the tighten_rate_limit rules that matched, nil when none
+	Restriction *Restriction
+}
+
+// Evaluate runs every rule against in
+func (e *Engine) Evaluate(in Input) Result {
+	var res Result
+	if e.Len() == 0 {
+		return res
+	}
+	hosts := linkHosts(in.Body)
+	for i := range e.rules {
+		rule := &e.rules[i]
+		reasons, ok := rule.match(in, hosts)
+		if !ok {
+			continue
+		}
+		res.Matches = append(res.Matches, Match{Rule: rule.Rule, Reasons: reasons})
+		if severity[rule.Action] > severity[res.Action] {
+			res.Action = rule.Action
+		}
+		if rule.restriction != nil && (res.Restriction == nil || rule.restriction.stricter(*res.Restriction)) {
+			res.Restriction = rule.restriction
+		}
+	}
+	return res
+}
+
+func (r *compiledRule) match(in Input, hosts []string) ([]string, bool) {
+	reasons := make([]string, 0, len(r.conditions))
+	for i := range r.conditions {
+		ok, reason := r.conditions[i].match(in, hosts)
+		if !ok {
+			return nil, false
+		}
+		reasons = append(reasons, reason)
+	}
+	return reasons, true
+}
+
+// Reports whether r allows fewer chirps over time than other
+func (r Restriction) stricter(other Restriction) bool {
+	return float64(r.MaxChirps)/r.Window.Seconds() < float64(other.MaxChirps)/other.Window.Seconds()
+}
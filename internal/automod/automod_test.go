@@ -0,0 +1,150 @@
+package automod
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func cond(field, op string, value any, window string) Condition {
+	raw, _ := json.Marshal(value)
+	return Condition{Field: field, Op: op, Value: raw, Window: window}
+}
+
+func TestEngine_Evaluate(t *testing.T) {
+	e, err := NewEngine([]Rule{
+		{ID: "spam", Definition: Definition{Action: ActionFlag, Conditions: []Condition{
+			cond(FieldContent, "contains", "Free Money", ""),
+		}}},
+		{ID: "new-linker", Definition: Definition{Action: ActionHold, Conditions: []Condition{
+			cond(FieldLinks, "gte", 1, ""),
+			cond(FieldAccountAge, "lt", "24h", ""),
+		}}},
+		{ID: "bad-domain", Definition: Definition{Action: ActionReject, Conditions: []Condition{
+			cond(FieldLinkDomain, "in", []string{"Spam.example"}, ""),
+		}}},
+		{ID: "burst", Definition: Definition{Action: ActionTightenRateLimit, Conditions: []Condition{
+			cond(FieldVelocity, "gte", 5, "1m"),
+		}, Tighten: &Tighten{MaxChirps: 1, Window: "1m", Duration: "1h"}}},
+		{ID: "shouting", Definition: Definition{Action: ActionFlag, Conditions: []Condition{
+			cond(FieldContent, "matches", `^[A-Z !]{10,}$`, ""),
+			cond(FieldLength, "gt", 9, ""),
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := 48 * time.Hour
+	cases := []struct {
+		name   string
+		in     Input
+		action Action
+		rules  []string
+	}{
+		{"clean", Input{Body: "hello there", AccountAge: old}, "", nil},
+		{"flagged", Input{Body: "get FREE money", AccountAge: old}, ActionFlag, []string{"spam"}},
+		{"old linker", Input{Body: "see https://ok.example/x", AccountAge: old}, "", nil},
+		{"new linker", Input{Body: "see www.ok.example", AccountAge: time.Hour}, ActionHold, []string{"new-linker"}},
+		{"subdomain", Input{Body: "free money at http://a.spam.example/", AccountAge: old}, ActionReject, []string{"spam", "bad-domain"}},
+		{"lookalike domain", Input{Body: "http://notspam.example", AccountAge: old}, "", nil},
+		{"shouting", Input{Body: "STOP THIS NOW!", AccountAge: old}, ActionFlag, []string{"shouting"}},
+		{"burst", Input{Body: "hi", AccountAge: old, Velocity: map[time.Duration]int64{time.Minute: 5}}, "", []string{"burst"}},
+	}
+	for _, c := range cases {
+		res := e.Evaluate(c.in)
+		var got []string
+		for _, m := range res.Matches {
+			got = append(got, m.Rule.ID)
+			if len(m.Reasons) != len(m.Rule.Conditions) {
+				t.Errorf("%s: expected a reason per condition of %s, got %v", c.name, m.Rule.ID, m.Reasons)
+			}
+		}
+		if res.Action != c.action || len(got) != len(c.rules) {
+			t.Errorf("%s: got %q from %v, want %q from %v", c.name, res.Action, got, c.action, c.rules)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.rules[i] {
+				t.Errorf("%s: matched %v, want %v", c.name, got, c.rules)
+			}
+		}
+	}
+	res := e.Evaluate(Input{Body: "hi", Velocity: map[time.Duration]int64{time.Minute: 9}})
+	if res.Restriction == nil || *res.Restriction != (Restriction{MaxChirps: 1, Window: time.Minute, Duration: time.Hour}) {
+		t.Fatalf("Expected the burst rule's restriction, got %+v", res.Restriction)
+	}
+}
+
+func TestEngine_StrictestRestriction(t *testing.T) {
+	tighten := func(id string, max int, window string) Rule {
+		return Rule{ID: id, Definition: Definition{Action: ActionTightenRateLimit, Conditions: []Condition{
+			cond(FieldLength, "gte", 0, ""),
+		}, Tighten: &Tighten{MaxChirps: max, Window: window, Duration: "1h"}}}
+	}
+	e, err := NewEngine([]Rule{tighten("a", 2, "1m"), tighten("b", 10, "1h"), tighten("c", 3, "1m")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := e.Evaluate(Input{Body: "hi"})
+	if res.Restriction == nil || res.Restriction.MaxChirps != 10 || res.Action != "" {
+		t.Fatalf("Expected 10 an hour as the strictest limit, got %+v", res)
+	}
+}
+
+func TestEngine_Needs(t *testing.T) {
+	e, err := NewEngine([]Rule{
+		{Definition: Definition{Action: ActionFlag, Conditions: []Condition{cond(FieldVelocity, "gt", 3, "10m")}}},
+		{Definition: Definition{Action: ActionFlag, Conditions: []Condition{cond(FieldVelocity, "gt", 30, "1h"), cond(FieldVelocity, "gt", 5, "10m")}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.NeedsAccountAge() {
+		t.Error("Expected no rule to need the account age")
+	}
+	if w := e.Windows(); len(w) != 2 || w[0] != 10*time.Minute || w[1] != time.Hour {
+		t.Errorf("Expected the windows 10m and 1h once each, got %v", w)
+	}
+}
+
+func TestEngine_NilHasNoRules(t *testing.T) {
+	var e *Engine
+	if res := e.Evaluate(Input{Body: "anything"}); res.Action != "" || res.Matches != nil || res.Restriction != nil {
+		t.Fatalf("Expected a nil engine to match nothing, got %+v", res)
+	}
+	if e.Len() != 0 || e.NeedsAccountAge() || e.Windows() != nil {
+		t.Fatal("Expected a nil engine to need nothing")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	invalid := []Definition{
+		{Action: ActionFlag},
+		{Action: "ban", Conditions: []Condition{cond(FieldLength, "gt", 1, "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond("mood", "eq", 1, "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldContent, "gt", "x", "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldContent, "contains", "", "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldContent, "matches", "(unclosed", "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldLength, "contains", 1, "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldLength, "gt", "long", "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldLength, "gt", 1, "1m")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldLinkDomain, "in", []string{}, "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldAccountAge, "lt", "a day", "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldAccountAge, "in", "24h", "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldVelocity, "gt", 3, "")}},
+		{Action: ActionFlag, Conditions: []Condition{cond(FieldVelocity, "gt", 3, "30d")}},
+		{Action: ActionFlag, Conditions: []Condition{{Field: FieldLength, Op: "gt"}}},
+		{Action: ActionTightenRateLimit, Conditions: []Condition{cond(FieldLength, "gt", 1, "")}},
+		{Action: ActionTightenRateLimit, Conditions: []Condition{cond(FieldLength, "gt", 1, "")}, Tighten: &Tighten{MaxChirps: 0, Window: "1m", Duration: "1h"}},
+		{Action: ActionTightenRateLimit, Conditions: []Condition{cond(FieldLength, "gt", 1, "")}, Tighten: &Tighten{MaxChirps: 1, Window: "1m"}},
+		{Action: ActionHold, Conditions: []Condition{cond(FieldLength, "gt", 1, "")}, Tighten: &Tighten{MaxChirps: 1, Window: "1m", Duration: "1h"}},
+	}
+	for _, def := range invalid {
+		if err := Compile(def); err == nil {
+			t.Errorf("Expected %+v to be refused", def)
+		}
+	}
+	if err := Compile(Definition{Action: ActionHold, Conditions: []Condition{cond(FieldAccountAge, "lt", "72h", "")}}); err != nil {
+		t.Errorf("Expected an account age rule to compile, got %v", err)
+	}
+}
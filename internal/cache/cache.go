@@ -0,0 +1,27 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Cache is a shared byte cache for expensive reads. Callers own serialization and
+// must invalidate keys explicitly from their write paths.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Purge removes every key owned by this cache
+	Purge(ctx context.Context) error
+}
+
+// Noop is used when no shared cache is configured; every read misses
+type Noop struct{}
+
+func (Noop) Get(ctx context.Context, key string) ([]byte, bool, error) { return nil, false, nil }
+
+func (Noop) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error { return nil }
+
+func (Noop) Delete(ctx context.Context, keys ...string) error { return nil }
+
+func (Noop) Purge(ctx context.Context) error { return nil }
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRU_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU[string, int](2, time.Minute)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("Expected b to be evicted")
+	}
+	if v, ok := c.Get("a"); !ok || v != 1 {
+		t.Fatalf("Expected a=1 to survive, got %v %v", v, ok)
+	}
+	if v, ok := c.Get("c"); !ok || v != 3 {
+		t.Fatalf("Expected c=3, got %v %v", v, ok)
+	}
+}
+
+func TestLRU_ExpiresAfterTTL(t *testing.T) {
+	c := NewLRU[string, int](2, time.Second)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	c.Set("a", 1)
+
+	now = now.Add(2 * time.Second)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("Expected a to expire")
+	}
+	if c.Len() != 0 {
+		t.Fatalf("Expected expired entry to be removed, got len %d", c.Len())
+	}
+}
+
+func TestLRU_DeleteAndPurge(t *testing.T) {
+	c := NewLRU[string, int](4, time.Minute)
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Delete("a")
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("Expected a to be deleted")
+	}
+	c.Purge()
+	if c.Len() != 0 {
+		t.Fatalf("Expected empty cache after purge, got len %d", c.Len())
+	}
+}
+
+func TestLRU_ZeroCapacityDisables(t *testing.T) {
+	c := NewLRU[string, int](0, time.Minute)
+	c.Set("a", 1)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("Expected zero-capacity cache to never hit")
+	}
+}
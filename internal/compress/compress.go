@@ -0,0 +1,250 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Brotli quality for responses compressed on the fly. Higher levels compress little
+// better for far more CPU, those are left to Precompress.
+const brotliLevel = 5
+
+// encoder produces a compressing writer for one Content-Encoding
+type encoder struct {
+	name string
+	pool sync.Pool
+}
+
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+var encoders = map[string]func() resetWriter{
+	"gzip": func() resetWriter {
+		return gzip.NewWriter(nil)
+	},
+	"br": func() resetWriter {
+		return brotli.NewWriterLevel(nil, brotliLevel)
+	},
+	"zstd": func() resetWriter {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	},
+}
+
+// Compressor compresses text-like responses for clients that advertise support
+type Compressor struct {
+	minSize  int
+	encoders []*encoder
+}
+
+// New returns a Compressor that only compresses bodies of at least minSize bytes.
+// encodings lists the supported Content-Encodings in server preference order.
+func New(minSize int, encodings ...string) *Compressor {
+	c := &Compressor{minSize: minSize}
+	for _, name := range encodings {
+		newWriter, ok := encoders[name]
+		if !ok {
+			continue
+		}
+		c.encoders = append(c.encoders, &encoder{name: name, pool: sync.Pool{New: func() any { return newWriter() }}})
+	}
+	return c
+}
+
+func (c *Compressor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := c.negotiate(r.Header.Get("Accept-Encoding"))
+		if enc == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, enc: enc, minSize: c.minSize, status: http.StatusOK}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiate picks the first server-preferred encoding that the client accepts with q > 0
+func (c *Compressor) negotiate(acceptEncoding string) *encoder {
+	accepted := parseAcceptEncoding(acceptEncoding)
+	for _, enc := range c.encoders {
+		if accepts(accepted, enc.name) {
+			return enc
+		}
+	}
+	return nil
+}
+
+// Accepts reports whether an Accept-Encoding header allows encoding
+func Accepts(acceptEncoding, encoding string) bool {
+	return accepts(parseAcceptEncoding(acceptEncoding), encoding)
+}
+
+// parseAcceptEncoding maps each listed encoding to whether its q value is above 0
+func parseAcceptEncoding(acceptEncoding string) map[string]bool {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[strings.ToLower(name)] = q > 0
+	}
+	return accepted
+}
+
+func accepts(accepted map[string]bool, encoding string) bool {
+	ok, listed := accepted[encoding]
+	return ok || (!listed && accepted["*"])
+}
+
+// compressible reports whether a media type is worth compressing. Images, video,
+// archives, and other already-compressed formats are left alone.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/problem+json", "application/javascript",
+		"application/xml", "application/xhtml+xml", "image/svg+xml":
+		return true
+	}
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// compressWriter buffers the start of a response until it knows whether the body is
+// large enough and of a compressible type, then either compresses or passes through.
+type compressWriter struct {
+	http.ResponseWriter
+	enc         *encoder
+	minSize     int
+	status      int
+	wroteHeader bool
+	buf         []byte
+	decided     bool
+	writer      resetWriter
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = code
+	// bodiless and partial responses are never compressed
+	if code < 200 || code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent {
+		cw.passthrough()
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.writer != nil {
+			return cw.writer.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) < cw.minSize {
+		return len(b), nil
+	}
+	if err := cw.decide(); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// decide looks at the buffered bytes and headers and starts the real response
+func (cw *compressWriter) decide() error {
+	h := cw.Header()
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	if len(cw.buf) < cw.minSize || h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" || !compressible(h.Get("Content-Type")) {
+		return cw.passthrough()
+	}
+	cw.decided = true
+	h.Set("Content-Encoding", cw.enc.name)
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	// the encoded bytes differ from the ones a strong ETag names
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.writer = cw.enc.pool.Get().(resetWriter)
+	cw.writer.Reset(cw.ResponseWriter)
+	_, err := cw.writer.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+func (cw *compressWriter) passthrough() error {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.ResponseWriter.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// Flush forces a decision so streaming handlers are not held back by the size threshold
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if !cw.decided {
+			cw.decide()
+		}
+	}
+	if f, ok := cw.writer.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the compressed stream, or writes out a response smaller than minSize
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if !cw.wroteHeader {
+			// handler wrote nothing at all
+			return nil
+		}
+		return cw.passthrough()
+	}
+	if cw.writer == nil {
+		return nil
+	}
+	err := cw.writer.Close()
+	cw.enc.pool.Put(cw.writer)
+	cw.writer = nil
+	return err
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,161 @@
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func serve(c *Compressor, contentType, body, acceptEncoding string) *httptest.ResponseRecorder {
+	handler := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(200)
+		w.Write([]byte(body))
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware_CompressesLargeJSON(t *testing.T) {
+	body := "[" + strings.Repeat(`{"body":"chirp"},`, 200) + "{}]"
+	rec := serve(New(1024, "gzip"), "application/json", body, "gzip, deflate")
+
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Expected valid gzip stream, got %v", err)
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Expected no error reading gzip stream, got %v", err)
+	}
+	if string(decoded) != body {
+		t.Fatal("Expected decompressed body to match original")
+	}
+}
+
+func TestMiddleware_SkipsSmallBodies(t *testing.T) {
+	rec := serve(New(1024, "gzip"), "application/json", `{"ok":true}`, "gzip")
+
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Fatal("Expected small body to be sent uncompressed")
+	}
+	if rec.Body.String() != `{"ok":true}` {
+		t.Fatalf("Expected original body, got %q", rec.Body.String())
+	}
+}
+
+func TestMiddleware_SkipsCompressedMediaTypes(t *testing.T) {
+	body := strings.Repeat("x", 4096)
+	rec := serve(New(1024, "gzip"), "image/png", body, "gzip")
+
+	if rec.Header().Get("Content-Encoding") != "" {
+		t.Fatal("Expected image to be sent uncompressed")
+	}
+	if rec.Body.Len() != len(body) {
+		t.Fatalf("Expected %d bytes, got %d", len(body), rec.Body.Len())
+	}
+}
+
+func TestNegotiate_ServerPreferenceAndQValues(t *testing.T) {
+	c := New(0, "zstd", "gzip")
+
+	if enc := c.negotiate("gzip, zstd"); enc == nil || enc.name != "zstd" {
+		t.Fatal("Expected zstd to be preferred when both are accepted")
+	}
+	if enc := c.negotiate("zstd;q=0, gzip"); enc == nil || enc.name != "gzip" {
+		t.Fatal("Expected gzip when zstd is refused with q=0")
+	}
+	if enc := c.negotiate("br"); enc != nil {
+		t.Fatal("Expected no encoding when nothing supported is accepted")
+	}
+}
+
+func TestMiddleware_WeakensStrongETags(t *testing.T) {
+	handler := New(0, "gzip").Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte(strings.Repeat("a{}", 100)))
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Header().Get("ETag") != `W/"abc"` {
+		t.Fatalf("Expected a weak ETag for the gzipped body, got %q", rec.Header().Get("ETag"))
+	}
+}
+
+func TestMiddleware_Brotli(t *testing.T) {
+	body := "[" + strings.Repeat(`{"body":"chirp"},`, 200) + "{}]"
+	rec := serve(New(1024, "br", "gzip"), "application/json", body, "gzip, br")
+
+	if rec.Header().Get("Content-Encoding") != "br" {
+		t.Fatalf("Expected br encoding, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	decoded, err := io.ReadAll(brotli.NewReader(rec.Body))
+	if err != nil {
+		t.Fatalf("Expected valid brotli stream, got %v", err)
+	}
+	if string(decoded) != body {
+		t.Fatal("Expected decompressed body to match original")
+	}
+}
+
+func TestPrecompressed_ServeFile(t *testing.T) {
+	script := strings.Repeat("console.log('chirp');\n", 100)
+	p, err := Precompress(fstest.MapFS{
+		"app.js":   {Data: []byte(script)},
+		"logo.png": {Data: []byte(strings.Repeat("x", 4096))},
+		"tiny.css": {Data: []byte("a{}")},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name, acceptEncoding string
+		served               bool
+	}{
+		{"app.js", "gzip, br", true},
+		{"app.js", "gzip", false},
+		{"app.js", "br;q=0", false},
+		{"logo.png", "br", false},
+		{"tiny.css", "br", false},
+	} {
+		req := httptest.NewRequest("GET", "/"+tc.name, nil)
+		req.Header.Set("Accept-Encoding", tc.acceptEncoding)
+		rec := httptest.NewRecorder()
+		if served := p.ServeFile(rec, req, tc.name, time.Time{}); served != tc.served {
+			t.Fatalf("%s with %q: expected served=%v, got %v", tc.name, tc.acceptEncoding, tc.served, served)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/app.js", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	rec := httptest.NewRecorder()
+	p.ServeFile(rec, req, "app.js", time.Time{})
+	if rec.Header().Get("Content-Encoding") != "br" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Expected br encoding varying by Accept-Encoding, got %v", rec.Header())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/javascript") {
+		t.Fatalf("Expected the type of the file, got %q", rec.Header().Get("Content-Type"))
+	}
+	decoded, err := io.ReadAll(brotli.NewReader(rec.Body))
+	if err != nil || string(decoded) != script {
+		t.Fatalf("Expected the file back, got error %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package compress
+
+import (
+	"bytes"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Precompressed holds Brotli encodings of the compressible files of an fs.FS. Files
+// that never change, such as embedded ones, are worth compressing once at the highest
+// quality, which would be far too slow to do per request.
+type Precompressed struct {
+	files map[string][]byte
+}
+
+// Precompress compresses every file of fsys whose type is worth compressing, keeping
+// the ones that come out smaller
+func Precompress(fsys fs.FS) (*Precompressed, error) {
+	p := &Precompressed{files: map[string][]byte{}}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !compressible(mime.TypeByExtension(path.Ext(name))) {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		w := brotli.NewWriterLevel(&buf, brotli.BestCompression)
+		_, err = w.Write(data)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return err
+		}
+		if buf.Len() < len(data) {
+			p.files[name] = buf.Bytes()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ServeFile writes the Brotli encoding of the file name when there is one and the
+// request accepts br, and reports whether it did. Range and conditional requests apply
+// to the encoded bytes, last modified at modtime. A strong ETag already set for the file
+// gets a -br suffix, since the encoded bytes differ.
+func (p *Precompressed) ServeFile(w http.ResponseWriter, r *http.Request, name string, modtime time.Time) bool {
+	data, ok := p.files[name]
+	if !ok || !Accepts(r.Header.Get("Accept-Encoding"), "br") {
+		return false
+	}
+	h := w.Header()
+	h.Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+	h.Set("Content-Encoding", "br")
+	addVary(h, "Accept-Encoding")
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", strings.TrimSuffix(etag, `"`)+`-br"`)
+	}
+	http.ServeContent(w, r, name, modtime, bytes.NewReader(data))
+	return true
+}
+
+// addVary adds a field to the Vary header unless it is already listed, as it is when
+// the Middleware runs as well
+func addVary(h http.Header, field string) {
+	for _, value := range h.Values("Vary") {
+		if value == field {
+			return
+		}
+	}
+	h.Add("Vary", field)
+}
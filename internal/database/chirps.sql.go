@@ -7,23 +7,87 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES (gen_random_uuid(), now(), now(), $1, $2)
-RETURNING id, created_at, updated_at, body, user_id
+const CountChirpsMatching = `-- name: CountChirpsMatching :one
+SELECT COUNT(*) FROM chirps
+WHERE created_at >= $1 AND created_at < $2
+AND (CAST($3 AS BOOLEAN) OR user_id = $4)
+AND (CAST($5 AS BOOLEAN) OR tenant_id = $6)
+`
+
+type CountChirpsMatchingParams struct {
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	AllUsers      bool
+	UserID        uuid.UUID
+	AllTenants    bool
+	TenantID      uuid.UUID
+}
+
+// Counts the chirps an admin bulk delete matches. all_users and all_tenants turn the
+// user and tenant filters off.
+func (q *Queries) CountChirpsMatching(ctx context.Context, arg CountChirpsMatchingParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, CountChirpsMatching,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.AllUsers,
+		arg.UserID,
+		arg.AllTenants,
+		arg.TenantID,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const CountChirpsPage = `-- name: CountChirpsPage :one
+SELECT COUNT(*) FROM chirps
+WHERE tenant_id = $1
+AND (CAST($2 AS BOOLEAN) OR user_id = $3)
+AND (user_id = $4 OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
+`
+
+type CountChirpsPageParams struct {
+	TenantID   uuid.UUID
+	AllAuthors bool
+	AuthorID   uuid.UUID
+	ViewerID   uuid.UUID
+}
+
+// How many chirps ListChirpsPage pages through, for X-Total-Count
+func (q *Queries) CountChirpsPage(ctx context.Context, arg CountChirpsPageParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, CountChirpsPage,
+		arg.TenantID,
+		arg.AllAuthors,
+		arg.AuthorID,
+		arg.ViewerID,
+	)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const CreateChirp = `-- name: CreateChirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, tenant_id)
+SELECT gen_random_uuid(), now(), now(), CAST($1 AS TEXT), users.id, users.tenant_id
+FROM users
+WHERE users.id = $2 AND users.tenant_id = $3
+RETURNING id, created_at, updated_at, body, user_id, tenant_id
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body     string
+	UserID   uuid.UUID
+	TenantID uuid.UUID
 }
 
+// Inserts nothing unless the author belongs to the tenant
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, CreateChirp, arg.Body, arg.UserID, arg.TenantID)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -31,32 +95,101 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.TenantID,
 	)
 	return i, err
 }
 
-const deleteChirpById = `-- name: DeleteChirpById :exec
+const DeleteChirpById = `-- name: DeleteChirpById :exec
 DELETE FROM chirps
-WHERE id = $1 AND user_id = $2
+WHERE id = $1 AND user_id = $2 AND tenant_id = $3
 `
 
 type DeleteChirpByIdParams struct {
-	ID     uuid.UUID
-	UserID uuid.UUID
+	ID       uuid.UUID
+	UserID   uuid.UUID
+	TenantID uuid.UUID
 }
 
 func (q *Queries) DeleteChirpById(ctx context.Context, arg DeleteChirpByIdParams) error {
-	_, err := q.db.ExecContext(ctx, deleteChirpById, arg.ID, arg.UserID)
+	_, err := q.db.ExecContext(ctx, DeleteChirpById, arg.ID, arg.UserID, arg.TenantID)
 	return err
 }
 
-const getChirpById = `-- name: GetChirpById :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const DeleteChirpsMatching = `-- name: DeleteChirpsMatching :execrows
+DELETE FROM chirps
+WHERE id IN (
+    SELECT id FROM chirps
+    WHERE created_at >= $1 AND created_at < $2
+    AND (CAST($3 AS BOOLEAN) OR user_id = $4)
+    AND (CAST($5 AS BOOLEAN) OR tenant_id = $6)
+    ORDER BY created_at ASC
+    LIMIT $7
+)
+`
+
+type DeleteChirpsMatchingParams struct {
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	AllUsers      bool
+	UserID        uuid.UUID
+	AllTenants    bool
+	TenantID      uuid.UUID
+	RowLimit      int32
+}
+
+// Deletes up to row_limit of the chirps CountChirpsMatching counts, oldest first, so a
+// bulk delete runs as a series of short statements instead of one long lock
+func (q *Queries) DeleteChirpsMatching(ctx context.Context, arg DeleteChirpsMatchingParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, DeleteChirpsMatching,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.AllUsers,
+		arg.UserID,
+		arg.AllTenants,
+		arg.TenantID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const GetChirpById = `-- name: GetChirpById :one
+SELECT id, created_at, updated_at, body, user_id, tenant_id FROM chirps
+WHERE id = $1 AND tenant_id = $2
+AND (user_id = $3 OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
+`
+
+type GetChirpByIdParams struct {
+	ID       uuid.UUID
+	TenantID uuid.UUID
+	ViewerID uuid.UUID
+}
+
+func (q *Queries) GetChirpById(ctx context.Context, arg GetChirpByIdParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, GetChirpById, arg.ID, arg.TenantID, arg.ViewerID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.TenantID,
+	)
+	return i, err
+}
+
+const GetChirpByIdAnyTenant = `-- name: GetChirpByIdAnyTenant :one
+SELECT id, created_at, updated_at, body, user_id, tenant_id FROM chirps
 WHERE id = $1
 `
 
-func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getChirpById, id)
+// Finds a chirp whatever its tenant, for operator admins
+func (q *Queries) GetChirpByIdAnyTenant(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, GetChirpByIdAnyTenant, id)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -64,17 +197,71 @@ func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.TenantID,
 	)
 	return i, err
 }
 
-const getChirps = `-- name: GetChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+const GetChirps = `-- name: GetChirps :many
+SELECT id, created_at, updated_at, body, user_id, tenant_id FROM chirps
+WHERE tenant_id = $1
+AND (user_id = $2 OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
+ORDER BY created_at ASC
+`
+
+type GetChirpsParams struct {
+	TenantID uuid.UUID
+	ViewerID uuid.UUID
+}
+
+// The chirps of shadowbanned users, and chirps auto-moderation or a word filter holds
+// until a moderator approves them, are only read back by their author, the viewer.
+// Pass uuid.Nil as the viewer to read them as anyone else.
+func (q *Queries) GetChirps(ctx context.Context, arg GetChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, GetChirps, arg.TenantID, arg.ViewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const GetChirpsById = `-- name: GetChirpsById :many
+SELECT id, created_at, updated_at, body, user_id, tenant_id FROM chirps
+WHERE user_id = $1 AND tenant_id = $2
+AND (user_id = $3 OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
 ORDER BY created_at ASC
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+type GetChirpsByIdParams struct {
+	UserID   uuid.UUID
+	TenantID uuid.UUID
+	ViewerID uuid.UUID
+}
+
+func (q *Queries) GetChirpsById(ctx context.Context, arg GetChirpsByIdParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, GetChirpsById, arg.UserID, arg.TenantID, arg.ViewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -88,6 +275,7 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.TenantID,
 		); err != nil {
 			return nil, err
 		}
@@ -102,14 +290,29 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirpsById = `-- name: GetChirpsById :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE user_id = $1
+const GetChirpsByUserIds = `-- name: GetChirpsByUserIds :many
+SELECT id, created_at, updated_at, body, user_id, tenant_id FROM chirps
+WHERE user_id IN (
+    SELECT users.id FROM users
+    WHERE users.tenant_id = $1
+    AND (',' || CAST($2 AS TEXT) || ',') LIKE ('%,' || CAST(users.id AS TEXT) || ',%')
+)
+AND (user_id = $3 OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
 ORDER BY created_at ASC
 `
 
-func (q *Queries) GetChirpsById(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsById, userID)
+type GetChirpsByUserIdsParams struct {
+	TenantID uuid.UUID
+	Ids      string
+	ViewerID uuid.UUID
+}
+
+// Lists the chirps of a batch of authors from a comma separated list of IDs. The list is
+// matched against the tenant's users, which is far smaller than chirps, so each author's
+// chirps come from chirps_user_id_created_at_idx rather than a scan of every chirp. A
+// chirp's tenant is always its author's.
+func (q *Queries) GetChirpsByUserIds(ctx context.Context, arg GetChirpsByUserIdsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, GetChirpsByUserIds, arg.TenantID, arg.Ids, arg.ViewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -123,6 +326,7 @@ func (q *Queries) GetChirpsById(ctx context.Context, userID uuid.UUID) ([]Chirp,
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.TenantID,
 		); err != nil {
 			return nil, err
 		}
@@ -136,3 +340,290 @@ func (q *Queries) GetChirpsById(ctx context.Context, userID uuid.UUID) ([]Chirp,
 	}
 	return items, nil
 }
+
+const ImportChirp = `-- name: ImportChirp :execrows
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, tenant_id)
+SELECT $1, $2, NOW(), $3, users.id, users.tenant_id
+FROM users
+WHERE users.id = $4 AND users.tenant_id = $5
+ON CONFLICT (id) DO NOTHING
+`
+
+type ImportChirpParams struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	TenantID  uuid.UUID
+}
+
+// Inserts a chirp posted elsewhere with the time it was first posted, and updated now so
+// the sitemap refresh finds it. Inserts nothing if a chirp with the id exists, so
+// importing twice adds nothing, or unless the author belongs to the tenant.
+func (q *Queries) ImportChirp(ctx context.Context, arg ImportChirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, ImportChirp,
+		arg.ID,
+		arg.CreatedAt,
+		arg.Body,
+		arg.UserID,
+		arg.TenantID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const ListChirpsAfter = `-- name: ListChirpsAfter :many
+SELECT id, created_at, updated_at, body, user_id, tenant_id FROM chirps
+WHERE id > $1
+ORDER BY id ASC
+LIMIT $2
+`
+
+type ListChirpsAfterParams struct {
+	ID    uuid.UUID
+	Limit int32
+}
+
+func (q *Queries) ListChirpsAfter(ctx context.Context, arg ListChirpsAfterParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, ListChirpsAfter, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ListChirpsPage = `-- name: ListChirpsPage :many
+SELECT id, created_at, updated_at, body, user_id, tenant_id FROM chirps
+WHERE tenant_id = $1
+AND (CAST($2 AS BOOLEAN) OR user_id = $3)
+AND (user_id = $4 OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
+ORDER BY created_at ASC, id ASC
+LIMIT $5 OFFSET $6
+`
+
+type ListChirpsPageParams struct {
+	TenantID   uuid.UUID
+	AllAuthors bool
+	AuthorID   uuid.UUID
+	ViewerID   uuid.UUID
+	RowLimit   int32
+	RowOffset  int32
+}
+
+// One page of the chirps GET /api/chirps?page= lists, oldest first. all_authors turns the
+// author filter off, and viewer_id sees their own chirps whatever hides them.
+func (q *Queries) ListChirpsPage(ctx context.Context, arg ListChirpsPageParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, ListChirpsPage,
+		arg.TenantID,
+		arg.AllAuthors,
+		arg.AuthorID,
+		arg.ViewerID,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ListChirpsPageDesc = `-- name: ListChirpsPageDesc :many
+SELECT id, created_at, updated_at, body, user_id, tenant_id FROM chirps
+WHERE tenant_id = $1
+AND (CAST($2 AS BOOLEAN) OR user_id = $3)
+AND (user_id = $4 OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
+ORDER BY created_at DESC, id DESC
+LIMIT $5 OFFSET $6
+`
+
+type ListChirpsPageDescParams struct {
+	TenantID   uuid.UUID
+	AllAuthors bool
+	AuthorID   uuid.UUID
+	ViewerID   uuid.UUID
+	RowLimit   int32
+	RowOffset  int32
+}
+
+// ListChirpsPage newest first
+func (q *Queries) ListChirpsPageDesc(ctx context.Context, arg ListChirpsPageDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, ListChirpsPageDesc,
+		arg.TenantID,
+		arg.AllAuthors,
+		arg.AuthorID,
+		arg.ViewerID,
+		arg.RowLimit,
+		arg.RowOffset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const RestoreChirp = `-- name: RestoreChirp :exec
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, tenant_id)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type RestoreChirpParams struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.UUID
+	TenantID  uuid.UUID
+}
+
+func (q *Queries) RestoreChirp(ctx context.Context, arg RestoreChirpParams) error {
+	_, err := q.db.ExecContext(ctx, RestoreChirp,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.Body,
+		arg.UserID,
+		arg.TenantID,
+	)
+	return err
+}
+
+const SearchChirps = `-- name: SearchChirps :many
+SELECT id, created_at, updated_at, body, user_id, tenant_id FROM chirps
+WHERE LOWER(body) LIKE $1 ESCAPE '\' OR CAST(id AS TEXT) = $2
+ORDER BY created_at DESC
+LIMIT $3
+`
+
+type SearchChirpsParams struct {
+	Pattern    string
+	ExactID    string
+	MaxResults int32
+}
+
+// Finds chirps of every tenant whose body contains a LIKE pattern, or whose id is
+// exact_id, newest first, for admin search
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, SearchChirps, arg.Pattern, arg.ExactID, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const UpdateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = now()
+WHERE id = $1 AND tenant_id = $3
+RETURNING id, created_at, updated_at, body, user_id, tenant_id
+`
+
+type UpdateChirpBodyParams struct {
+	ID       uuid.UUID
+	Body     string
+	TenantID uuid.UUID
+}
+
+// Replaces the body of a chirp, e.g. with the tombstone of a chirp moderators removed
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, UpdateChirpBody, arg.ID, arg.Body, arg.TenantID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.TenantID,
+	)
+	return i, err
+}
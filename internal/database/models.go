@@ -11,12 +11,221 @@ import (
 	"github.com/google/uuid"
 )
 
+type ActivitypubFollower struct {
+	UserID      uuid.UUID
+	ActorID     string
+	CreatedAt   time.Time
+	Inbox       string
+	SharedInbox string
+	FollowID    string
+}
+
+type ActivitypubKey struct {
+	UserID        uuid.UUID
+	CreatedAt     time.Time
+	PublicKeyPem  string
+	PrivateKeyPem string
+}
+
+type ApiKey struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UserID     uuid.UUID
+	TenantID   uuid.UUID
+	Name       string
+	KeyHash    string
+	Prefix     string
+	LastUsedAt sql.NullTime
+}
+
+type ApiKeyUsage struct {
+	ApiKeyID uuid.UUID
+	Day      time.Time
+	Requests int64
+}
+
+type AuditLog struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Actor     string
+	Action    string
+	Target    string
+	RequestID string
+	Ip        string
+	Details   string
+}
+
+type AutomodRestriction struct {
+	UserID        uuid.UUID
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	MaxChirps     int32
+	WindowSeconds int32
+	RuleID        uuid.UUID
+}
+
+type AutomodRule struct {
+	ID         uuid.UUID
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Name       string
+	Version    int32
+	Enabled    bool
+	Definition string
+}
+
+type AutomodRuleVersion struct {
+	RuleID     uuid.UUID
+	Version    int32
+	CreatedAt  time.Time
+	Name       string
+	Enabled    bool
+	Definition string
+	CreatedBy  string
+}
+
 type Chirp struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Body      string
 	UserID    uuid.UUID
+	TenantID  uuid.UUID
+}
+
+type ChirpFavourite struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
+}
+
+type ChirpReport struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	ChirpID     uuid.UUID
+	AuthorID    uuid.UUID
+	TenantID    uuid.UUID
+	ReporterID  uuid.NullUUID
+	Reason      string
+	Details     string
+	EscalatedAt sql.NullTime
+	ResolvedAt  sql.NullTime
+	DecisionID  uuid.NullUUID
+}
+
+type DigestSubscription struct {
+	UserID     uuid.UUID
+	CreatedAt  time.Time
+	LastSentAt time.Time
+}
+
+type FeatureFlag struct {
+	Name              string
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Description       string
+	Enabled           bool
+	RolloutPercentage int32
+	Environments      string
+}
+
+type IdempotencyKey struct {
+	Scope          string
+	IdempotencyKey string
+	Fingerprint    string
+	Status         string
+	StatusCode     int32
+	ContentType    string
+	Body           string
+	CreatedAt      time.Time
+	ExpiresAt      time.Time
+	Headers        string
+}
+
+type IpBan struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	Cidr      string
+	Reason    string
+	ExpiresAt sql.NullTime
+	CreatedBy string
+}
+
+type Job struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Kind        string
+	Payload     string
+	Status      string
+	Attempts    int32
+	MaxAttempts int32
+	RunAt       time.Time
+	LockedUntil sql.NullTime
+	LastError   string
+	Progress    string
+}
+
+type ModerationDecision struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	ChirpID   uuid.UUID
+	AuthorID  uuid.UUID
+	TenantID  uuid.UUID
+	Moderator string
+	Action    string
+	Note      string
+	Reports   int32
+}
+
+type OauthApp struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	ClientID     string
+	ClientSecret string
+	Name         string
+	Website      string
+	RedirectUris string
+	Scopes       string
+}
+
+type OauthCode struct {
+	CodeHash    string
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+	UserID      uuid.UUID
+	ClientID    string
+	RedirectUri string
+	Scopes      string
+}
+
+type OauthToken struct {
+	TokenHash string
+	CreatedAt time.Time
+	UserID    uuid.UUID
+	ClientID  string
+	Scopes    string
+}
+
+type OutboxEvent struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	TenantID    uuid.UUID
+	Event       string
+	Payload     string
+	PublishedAt sql.NullTime
+}
+
+type QuotaUsage struct {
+	UserID      uuid.UUID
+	Quota       string
+	WindowStart time.Time
+	Used        int64
+}
+
+type RateLimit struct {
+	Bucket string
+	Tat    int64
 }
 
 type RefreshToken struct {
@@ -28,6 +237,66 @@ type RefreshToken struct {
 	RevokedAt sql.NullTime
 }
 
+type RuntimeState struct {
+	Name      string
+	Value     string
+	UpdatedAt time.Time
+}
+
+type ScheduledRun struct {
+	Task         string
+	ScheduledFor time.Time
+	Instance     string
+	StartedAt    time.Time
+	FinishedAt   sql.NullTime
+	Status       string
+	Error        string
+}
+
+type Shadowban struct {
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	TenantID  uuid.UUID
+	Reason    string
+	BannedBy  string
+}
+
+type SitemapPage struct {
+	TenantID     uuid.UUID
+	Kind         string
+	Day          time.Time
+	Urls         int64
+	LastModified time.Time
+}
+
+type StatsRollup struct {
+	Period         string
+	StartsAt       time.Time
+	EndsAt         time.Time
+	NewUsers       int64
+	ActiveUsers    int64
+	ChirpsPosted   int64
+	ChirpsDeleted  int64
+	RedConversions int64
+	UpdatedAt      time.Time
+}
+
+type Tenant struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Slug           string
+	Name           string
+	AdminTokenHash string
+	MaxUsers       int32
+}
+
+type TwitterImportBatch struct {
+	JobID  uuid.UUID
+	Seq    int32
+	Tweets string
+}
+
 type User struct {
 	ID             uuid.UUID
 	CreatedAt      time.Time
@@ -35,4 +304,44 @@ type User struct {
 	Email          string
 	HashedPassword string
 	IsChirpyRed    bool
+	TenantID       uuid.UUID
+}
+
+type Visit struct {
+	Day  string
+	Hits int64
+}
+
+type Webhook struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uuid.UUID
+	Url       string
+	Secret    string
+	Events    string
+	Active    bool
+}
+
+type WebhookDelivery struct {
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	WebhookID    uuid.UUID
+	EventID      uuid.UUID
+	Event        string
+	StatusCode   int32
+	Error        string
+	DurationMs   int64
+	RequestBody  string
+	ResponseBody string
+}
+
+type WordFilter struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Pattern   string
+	Kind      string
+	Action    string
+	Note      string
 }
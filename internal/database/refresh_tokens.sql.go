@@ -12,7 +12,20 @@ import (
 	"github.com/google/uuid"
 )
 
-const createRefreshToken = `-- name: CreateRefreshToken :one
+const CountStaleRefreshTokens = `-- name: CountStaleRefreshTokens :one
+SELECT COUNT(*) FROM refresh_tokens
+WHERE expires_at < $1 OR revoked_at < $1
+`
+
+// Counts the rows DeleteStaleRefreshTokens removes, for retention dry runs
+func (q *Queries) CountStaleRefreshTokens(ctx context.Context, expiresAt time.Time) (int64, error) {
+	row := q.db.QueryRowContext(ctx, CountStaleRefreshTokens, expiresAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const CreateRefreshToken = `-- name: CreateRefreshToken :one
 INSERT INTO refresh_tokens (token, user_id, expires_at)
 VALUES ($1, $2, $3)
 RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
@@ -25,7 +38,7 @@ type CreateRefreshTokenParams struct {
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, CreateRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
 	var i RefreshToken
 	err := row.Scan(
 		&i.Token,
@@ -38,7 +51,21 @@ func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshToken
 	return i, err
 }
 
-const getRefreshToken = `-- name: GetRefreshToken :one
+const DeleteStaleRefreshTokens = `-- name: DeleteStaleRefreshTokens :execrows
+DELETE FROM refresh_tokens
+WHERE expires_at < $1 OR revoked_at < $1
+`
+
+// Expired or revoked tokens can never be used again
+func (q *Queries) DeleteStaleRefreshTokens(ctx context.Context, expiresAt time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, DeleteStaleRefreshTokens, expiresAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const GetRefreshToken = `-- name: GetRefreshToken :one
 SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens
 WHERE token = $1 AND expires_at > NOW() AND revoked_at IS NULL
 ORDER BY expires_at DESC
@@ -46,7 +73,7 @@ LIMIT 1
 `
 
 func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getRefreshToken, token)
+	row := q.db.QueryRowContext(ctx, GetRefreshToken, token)
 	var i RefreshToken
 	err := row.Scan(
 		&i.Token,
@@ -59,8 +86,8 @@ func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshTok
 	return i, err
 }
 
-const getUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
-SELECT users.id, users.created_at, users.updated_at, users.email, users.hashed_password, users.is_chirpy_red FROM users
+const GetUserFromRefreshToken = `-- name: GetUserFromRefreshToken :one
+SELECT users.id, users.created_at, users.updated_at, users.email, users.hashed_password, users.is_chirpy_red, users.tenant_id FROM users
 JOIN refresh_tokens ON users.id = refresh_tokens.user_id
 WHERE refresh_tokens.token = $1 AND refresh_tokens.expires_at > NOW()
 ORDER BY refresh_tokens.expires_at DESC
@@ -68,7 +95,7 @@ LIMIT 1
 `
 
 func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserFromRefreshToken, token)
+	row := q.db.QueryRowContext(ctx, GetUserFromRefreshToken, token)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -77,28 +104,29 @@ func (q *Queries) GetUserFromRefreshToken(ctx context.Context, token string) (Us
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TenantID,
 	)
 	return i, err
 }
 
-const revokeRefreshToken = `-- name: RevokeRefreshToken :exec
+const RevokeRefreshToken = `-- name: RevokeRefreshToken :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW()
 WHERE token = $1
 `
 
 func (q *Queries) RevokeRefreshToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, revokeRefreshToken, token)
+	_, err := q.db.ExecContext(ctx, RevokeRefreshToken, token)
 	return err
 }
 
-const revokeRefreshTokensForUser = `-- name: RevokeRefreshTokensForUser :exec
+const RevokeRefreshTokensForUser = `-- name: RevokeRefreshTokensForUser :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW()
 WHERE user_id = $1
 `
 
 func (q *Queries) RevokeRefreshTokensForUser(ctx context.Context, userID uuid.UUID) error {
-	_, err := q.db.ExecContext(ctx, revokeRefreshTokensForUser, userID)
+	_, err := q.db.ExecContext(ctx, RevokeRefreshTokensForUser, userID)
 	return err
 }
@@ -7,23 +7,36 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
-VALUES (gen_random_uuid(), now(), now(), $1, $2)
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+const CountUsersByTenant = `-- name: CountUsersByTenant :one
+SELECT COUNT(*) FROM users WHERE tenant_id = $1
+`
+
+func (q *Queries) CountUsersByTenant(ctx context.Context, tenantID uuid.UUID) (int64, error) {
+	row := q.db.QueryRowContext(ctx, CountUsersByTenant, tenantID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const CreateUser = `-- name: CreateUser :one
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, tenant_id)
+VALUES (gen_random_uuid(), now(), now(), $1, $2, $3)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, tenant_id
 `
 
 type CreateUserParams struct {
 	Email          string
 	HashedPassword string
+	TenantID       uuid.UUID
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, CreateUser, arg.Email, arg.HashedPassword, arg.TenantID)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -32,25 +45,31 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TenantID,
 	)
 	return i, err
 }
 
-const deleteUsers = `-- name: DeleteUsers :exec
+const DeleteUsers = `-- name: DeleteUsers :exec
 DELETE FROM users
 `
 
 func (q *Queries) DeleteUsers(ctx context.Context) error {
-	_, err := q.db.ExecContext(ctx, deleteUsers)
+	_, err := q.db.ExecContext(ctx, DeleteUsers)
 	return err
 }
 
-const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email = $1
+const GetUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, tenant_id FROM users WHERE tenant_id = $1 AND email = $2
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+type GetUserByEmailParams struct {
+	TenantID uuid.UUID
+	Email    string
+}
+
+func (q *Queries) GetUserByEmail(ctx context.Context, arg GetUserByEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, GetUserByEmail, arg.TenantID, arg.Email)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -59,25 +78,113 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TenantID,
 	)
 	return i, err
 }
 
-const putNewUserData = `-- name: PutNewUserData :one
+const GetUsersByIds = `-- name: GetUsersByIds :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, tenant_id FROM users
+WHERE tenant_id = $1
+AND (',' || CAST($2 AS TEXT) || ',') LIKE ('%,' || CAST(id AS TEXT) || ',%')
+`
+
+type GetUsersByIdsParams struct {
+	TenantID uuid.UUID
+	Ids      string
+}
+
+// Looks up a batch of users from a comma separated list of IDs
+func (q *Queries) GetUsersByIds(ctx context.Context, arg GetUsersByIdsParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, GetUsersByIds, arg.TenantID, arg.Ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const ListUsersAfter = `-- name: ListUsersAfter :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, tenant_id FROM users
+WHERE id > $1
+ORDER BY id ASC
+LIMIT $2
+`
+
+type ListUsersAfterParams struct {
+	ID    uuid.UUID
+	Limit int32
+}
+
+func (q *Queries) ListUsersAfter(ctx context.Context, arg ListUsersAfterParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, ListUsersAfter, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const PutNewUserData = `-- name: PutNewUserData :one
 UPDATE users
 SET email = $1, hashed_password = $2, updated_at = NOW()
-WHERE id = $3
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+WHERE id = $3 AND tenant_id = $4
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, tenant_id
 `
 
 type PutNewUserDataParams struct {
 	Email          string
 	HashedPassword string
 	ID             uuid.UUID
+	TenantID       uuid.UUID
 }
 
 func (q *Queries) PutNewUserData(ctx context.Context, arg PutNewUserDataParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, putNewUserData, arg.Email, arg.HashedPassword, arg.ID)
+	row := q.db.QueryRowContext(ctx, PutNewUserData, arg.Email, arg.HashedPassword, arg.ID, arg.TenantID)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -86,19 +193,99 @@ func (q *Queries) PutNewUserData(ctx context.Context, arg PutNewUserDataParams)
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TenantID,
 	)
 	return i, err
 }
 
-const upgradeUserById = `-- name: UpgradeUserById :one
+const RestoreUser = `-- name: RestoreUser :exec
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red, tenant_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type RestoreUserParams struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Email          string
+	HashedPassword string
+	IsChirpyRed    bool
+	TenantID       uuid.UUID
+}
+
+func (q *Queries) RestoreUser(ctx context.Context, arg RestoreUserParams) error {
+	_, err := q.db.ExecContext(ctx, RestoreUser,
+		arg.ID,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.Email,
+		arg.HashedPassword,
+		arg.IsChirpyRed,
+		arg.TenantID,
+	)
+	return err
+}
+
+const SearchUsers = `-- name: SearchUsers :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, tenant_id FROM users
+WHERE LOWER(email) LIKE $1 ESCAPE '\' OR CAST(id AS TEXT) = $2
+ORDER BY created_at DESC
+LIMIT $3
+`
+
+type SearchUsersParams struct {
+	Pattern    string
+	ExactID    string
+	MaxResults int32
+}
+
+// Finds users of every tenant whose email contains a LIKE pattern, or whose id is
+// exact_id, newest first, for admin search
+func (q *Queries) SearchUsers(ctx context.Context, arg SearchUsersParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, SearchUsers, arg.Pattern, arg.ExactID, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.TenantID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const UpgradeUserById = `-- name: UpgradeUserById :one
 UPDATE users
 SET is_chirpy_red = TRUE, updated_at = NOW()
-WHERE id = $1
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+WHERE id = $1 AND tenant_id = $2
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, tenant_id
 `
 
-func (q *Queries) UpgradeUserById(ctx context.Context, id uuid.UUID) (User, error) {
-	row := q.db.QueryRowContext(ctx, upgradeUserById, id)
+type UpgradeUserByIdParams struct {
+	ID       uuid.UUID
+	TenantID uuid.UUID
+}
+
+func (q *Queries) UpgradeUserById(ctx context.Context, arg UpgradeUserByIdParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, UpgradeUserById, arg.ID, arg.TenantID)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -107,6 +294,7 @@ func (q *Queries) UpgradeUserById(ctx context.Context, id uuid.UUID) (User, erro
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TenantID,
 	)
 	return i, err
 }
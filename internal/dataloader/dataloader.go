@@ -0,0 +1,77 @@
+// Package dataloader batches lookups made while resolving one request, so that a
+// list of N items whose fields each need a related row costs one query instead of N.
+package dataloader
+
+import (
+	"context"
+	"sync"
+)
+
+// FetchFunc loads every key in one round trip. Keys it leaves out of the map are
+// remembered as missing.
+type FetchFunc[K comparable, V any] func(ctx context.Context, keys []K) (map[K]V, error)
+
+// Loader caches what it has fetched for its lifetime, so it must be created per
+// request. It is safe for concurrent use.
+type Loader[K comparable, V any] struct {
+	mu      sync.Mutex
+	fetch   FetchFunc[K, V]
+	pending map[K]bool
+	loaded  map[K]V
+	missing map[K]bool
+}
+
+func New[K comparable, V any](fetch FetchFunc[K, V]) *Loader[K, V] {
+	return &Loader[K, V]{
+		fetch:   fetch,
+		pending: make(map[K]bool),
+		loaded:  make(map[K]V),
+		missing: make(map[K]bool),
+	}
+}
+
+// Want announces keys that are about to be loaded. Resolvers of a list call it for
+// every item before any of them calls Load, and the first Load fetches them all.
+func (l *Loader[K, V]) Want(keys ...K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if !l.known(key) {
+			l.pending[key] = true
+		}
+	}
+}
+
+// Load returns the value for key, fetching it together with every wanted key that
+// has not been fetched yet. ok is false when the fetch did not return the key.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (value V, ok bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.known(key) {
+		l.pending[key] = true
+		keys := make([]K, 0, len(l.pending))
+		for k := range l.pending {
+			keys = append(keys, k)
+		}
+		clear(l.pending)
+		values, err := l.fetch(ctx, keys)
+		if err != nil {
+			return value, false, err
+		}
+		for _, k := range keys {
+			if v, found := values[k]; found {
+				l.loaded[k] = v
+			} else {
+				l.missing[k] = true
+			}
+		}
+	}
+	value, ok = l.loaded[key]
+	return value, ok, nil
+}
+
+// known reports whether key has been fetched. Callers must hold the lock.
+func (l *Loader[K, V]) known(key K) bool {
+	_, ok := l.loaded[key]
+	return ok || l.missing[key]
+}
@@ -0,0 +1,61 @@
+package dataloader
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestLoader_BatchesWantedKeys(t *testing.T) {
+	var batches [][]int
+	l := New(func(ctx context.Context, keys []int) (map[int]string, error) {
+		slices.Sort(keys)
+		batches = append(batches, keys)
+		values := map[int]string{}
+		for _, k := range keys {
+			if k != 3 {
+				values[k] = string(rune('a' + k))
+			}
+		}
+		return values, nil
+	})
+
+	l.Want(1, 2, 3)
+	for _, k := range []int{1, 2, 3, 1} {
+		v, ok, err := l.Load(context.Background(), k)
+		if err != nil {
+			t.Fatalf("Load(%d): %v", k, err)
+		}
+		if ok != (k != 3) || (ok && v != string(rune('a'+k))) {
+			t.Fatalf("Load(%d) = %q %v", k, v, ok)
+		}
+	}
+	if len(batches) != 1 || !slices.Equal(batches[0], []int{1, 2, 3}) {
+		t.Fatalf("Expected one batch of [1 2 3], got %v", batches)
+	}
+
+	l.Load(context.Background(), 4)
+	if len(batches) != 2 || !slices.Equal(batches[1], []int{4}) {
+		t.Fatalf("Expected a second batch of [4], got %v", batches)
+	}
+}
+
+func TestLoader_RetriesAfterError(t *testing.T) {
+	calls := 0
+	l := New(func(ctx context.Context, keys []string) (map[string]int, error) {
+		calls++
+		if calls == 1 {
+			return nil, errors.New("boom")
+		}
+		return map[string]int{"a": 1}, nil
+	})
+
+	if _, _, err := l.Load(context.Background(), "a"); err == nil {
+		t.Fatal("Expected the fetch error")
+	}
+	v, ok, err := l.Load(context.Background(), "a")
+	if err != nil || !ok || v != 1 {
+		t.Fatalf("Expected a=1 after retry, got %v %v %v", v, ok, err)
+	}
+}
@@ -0,0 +1,189 @@
+// Package email renders templated messages and sends them through a pluggable
+// Sender: SMTP in production, or the log in development. Mailer queues messages
+// as background jobs so a slow or failing mail server never holds up a request.
+package email
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/mail"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+)
+
+// Template names, one per file in templates/
+const (
+	VerifyEmail    = "verify_email"
+	PasswordReset  = "password_reset"
+	Digest         = "digest"
+	Test           = "test"
+	ReportResolved = "report_resolved"
+	ChirpRemoved   = "chirp_removed"
+)
+
+// LinkData fills the verify_email and password_reset templates
+type LinkData struct {
+	Email     string
+	Link      string
+	ExpiresIn string
+}
+
+// DigestData fills the digest template
+type DigestData struct {
+	Email  string
+	Since  time.Time
+	Chirps []DigestChirp
+}
+
+type DigestChirp struct {
+	Author string
+	Body   string
+}
+
+// ReportResolvedData fills the report_resolved template, sent to whoever reported a chirp
+type ReportResolvedData struct {
+	Email   string
+	Chirp   string
+	Removed bool
+}
+
+// ChirpRemovedData fills the chirp_removed template, sent to the author of a removed chirp
+type ChirpRemovedData struct {
+	Email string
+	Chirp string
+	// what the chirp was reported for, e.g. "spam, harassment"
+	Reasons string
+}
+
+// TestData fills the test template
+type TestData struct {
+	SentAt time.Time
+}
+
+// JobKind is the background job kind used for queued emails
+const JobKind = "email.send"
+
+// Message is a rendered email. HTML is optional.
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html,omitempty"`
+}
+
+// Sender delivers a rendered message
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// LogSender writes messages to the log instead of sending them, for development
+type LogSender struct{}
+
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	log.Printf("Email to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+type templateSet struct {
+	text *template.Template
+	html *htmltemplate.Template
+}
+
+var templates = mustParseTemplates()
+
+// mustParseTemplates parses every template file twice: subject and text with
+// text/template, and html with html/template so data is escaped
+func mustParseTemplates() map[string]templateSet {
+	names, err := templateFiles.ReadDir("templates")
+	if err != nil {
+		panic(err)
+	}
+	sets := make(map[string]templateSet, len(names))
+	for _, entry := range names {
+		file := "templates/" + entry.Name()
+		name := strings.TrimSuffix(entry.Name(), path.Ext(entry.Name()))
+		sets[name] = templateSet{
+			text: template.Must(template.New(name).Option("missingkey=error").ParseFS(templateFiles, file)),
+			html: htmltemplate.Must(htmltemplate.New(name).Option("missingkey=error").ParseFS(templateFiles, file)),
+		}
+	}
+	return sets
+}
+
+// Render builds the message for template name addressed to to
+func Render(name, to string, data any) (Message, error) {
+	set, ok := templates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("no email template named %s", name)
+	}
+	var subject, text, html bytes.Buffer
+	err := set.text.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return Message{}, fmt.Errorf("rendering %s subject: %w", name, err)
+	}
+	err = set.text.ExecuteTemplate(&text, "text", data)
+	if err != nil {
+		return Message{}, fmt.Errorf("rendering %s text: %w", name, err)
+	}
+	if set.html.Lookup("html") != nil {
+		err = set.html.ExecuteTemplate(&html, "html", data)
+		if err != nil {
+			return Message{}, fmt.Errorf("rendering %s html: %w", name, err)
+		}
+	}
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    strings.TrimSpace(html.String()),
+	}, nil
+}
+
+// Mailer renders messages and queues them for delivery
+type Mailer struct {
+	sender Sender
+	queue  *jobs.Queue
+}
+
+// NewMailer builds a Mailer and registers its delivery handler on queue
+func NewMailer(sender Sender, queue *jobs.Queue) *Mailer {
+	m := &Mailer{sender: sender, queue: queue}
+	queue.Register(JobKind, m.deliver)
+	return m
+}
+
+// Send renders template name and queues it for to. Rendering errors are returned
+// right away, delivery errors are retried by the job queue.
+func (m *Mailer) Send(ctx context.Context, to, name string, data any) error {
+	addr, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", to, err)
+	}
+	msg, err := Render(name, addr.Address, data)
+	if err != nil {
+		return err
+	}
+	_, err = m.queue.Enqueue(ctx, JobKind, msg)
+	return err
+}
+
+func (m *Mailer) deliver(ctx context.Context, payload json.RawMessage) error {
+	var msg Message
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return jobs.Permanent(fmt.Errorf("decoding email: %w", err))
+	}
+	return m.sender.Send(ctx, msg)
+}
@@ -0,0 +1,81 @@
+package email
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+)
+
+func TestRender_AllTemplates(t *testing.T) {
+	link := LinkData{Email: "a@example.com", Link: "https://chirpy.example/verify?token=abc", ExpiresIn: "24 hours"}
+	cases := map[string]any{
+		VerifyEmail:    link,
+		PasswordReset:  link,
+		Digest:         DigestData{Email: "a@example.com", Since: time.Now(), Chirps: []DigestChirp{{Author: "walt", Body: "hi"}}},
+		Test:           TestData{SentAt: time.Now()},
+		ReportResolved: ReportResolvedData{Email: "a@example.com", Chirp: "hi", Removed: true},
+		ChirpRemoved:   ChirpRemovedData{Email: "a@example.com", Chirp: "hi", Reasons: "spam"},
+	}
+	for name, data := range cases {
+		msg, err := Render(name, "a@example.com", data)
+		if err != nil {
+			t.Fatalf("Expected %s to render, got %v", name, err)
+		}
+		if msg.Subject == "" || msg.Text == "" || msg.HTML == "" {
+			t.Errorf("Expected %s to have a subject, text and html, got %+v", name, msg)
+		}
+	}
+}
+
+func TestRender_EscapesHTMLOnly(t *testing.T) {
+	msg, err := Render(Digest, "a@example.com", DigestData{Since: time.Now(), Chirps: []DigestChirp{{Author: "eve", Body: "<script>x</script>"}}})
+	if err != nil {
+		t.Fatalf("Expected digest to render, got %v", err)
+	}
+	if strings.Contains(msg.HTML, "<script>") {
+		t.Fatalf("Expected the html part to be escaped, got %s", msg.HTML)
+	}
+	if !strings.Contains(msg.Text, "<script>x</script>") {
+		t.Fatalf("Expected the text part to be left alone, got %s", msg.Text)
+	}
+}
+
+func TestRender_Errors(t *testing.T) {
+	if _, err := Render("missing", "a@example.com", nil); err == nil {
+		t.Fatalf("Expected an unknown template to be an error")
+	}
+	if _, err := Render(VerifyEmail, "a@example.com", TestData{}); err == nil {
+		t.Fatalf("Expected data without the template's fields to be an error")
+	}
+}
+
+type captureSender struct{ sent []Message }
+
+func (c *captureSender) Send(ctx context.Context, msg Message) error {
+	c.sent = append(c.sent, msg)
+	return nil
+}
+
+func TestMailer_QueuesAndSends(t *testing.T) {
+	sender := &captureSender{}
+	q := jobs.New(store.NewMemory(), jobs.Options{})
+	mailer := NewMailer(sender, q)
+	ctx := context.Background()
+	if err := mailer.Send(ctx, "not an address", Test, TestData{}); err == nil {
+		t.Fatalf("Expected an invalid recipient to be rejected")
+	}
+	if err := mailer.Send(ctx, "Walt <walt@example.com>", Test, TestData{SentAt: time.Now()}); err != nil {
+		t.Fatalf("Expected no error queueing email, got %v", err)
+	}
+	if len(sender.sent) != 0 {
+		t.Fatalf("Expected nothing to be sent before the job runs")
+	}
+	q.RunOnce(ctx)
+	if len(sender.sent) != 1 || sender.sent[0].To != "walt@example.com" {
+		t.Fatalf("Expected one email to walt@example.com, got %+v", sender.sent)
+	}
+}
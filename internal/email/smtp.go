@@ -0,0 +1,173 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TLS modes for SMTPSender
+const (
+	// TLSStartTLS upgrades a plain connection, usually on port 587
+	TLSStartTLS = "starttls"
+	// TLSImplicit connects with TLS from the start, usually on port 465
+	TLSImplicit = "tls"
+	// TLSNone sends in the clear, only for local relays and test servers
+	TLSNone = "none"
+)
+
+// SMTPSender sends through an SMTP server, authenticating with PLAIN when a
+// username is set
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Credentials, when set, is called for every message instead of reading Username
+	// and Password, so credentials rotated while the server runs are used
+	Credentials func() (username, password string)
+	From        string
+	TLS         string
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender %q: %w", s.From, err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+	body, err := buildMessage(from, to, msg)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, strconv.Itoa(s.Port)))
+	if err != nil {
+		return err
+	}
+	// net/smtp has no context support, so bound the whole conversation with a deadline
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	conn.SetDeadline(deadline)
+	if s.TLS == TLSImplicit {
+		conn = tls.Client(conn, &tls.Config{ServerName: s.Host})
+	}
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+	if s.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%s does not support STARTTLS", s.Host)
+		}
+		err = client.StartTLS(&tls.Config{ServerName: s.Host})
+		if err != nil {
+			return err
+		}
+	}
+	username, password := s.Username, s.Password
+	if s.Credentials != nil {
+		username, password = s.Credentials()
+	}
+	if username != "" {
+		err = client.Auth(smtp.PlainAuth("", username, password, s.Host))
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(to.Address)
+	if err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildMessage encodes msg as a MIME message, multipart/alternative when it has HTML
+func buildMessage(from, to *mail.Address, msg Message) ([]byte, error) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	domain := from.Address[strings.LastIndex(from.Address, "@")+1:]
+	var buf bytes.Buffer
+	// Q-encoding also covers CR and LF, so a subject cannot inject extra headers
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n")
+		err := writeQuotedPrintable(&buf, msg.Text)
+		return buf.Bytes(), err
+	}
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		qp.Write([]byte(part.body))
+		qp.Close()
+	}
+	err := parts.Close()
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	_, err := qp.Write([]byte(s))
+	if err != nil {
+		return err
+	}
+	return qp.Close()
+}
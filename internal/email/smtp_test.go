@@ -0,0 +1,88 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts one message and returns its DATA section on the channel
+func fakeSMTP(t *testing.T) (int, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected to listen, got %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	data := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		tp.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250 fake")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				lines, _ := tp.ReadDotLines()
+				data <- strings.Join(lines, "\n")
+				tp.PrintfLine("250 queued")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				return
+			default:
+				tp.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, data
+}
+
+func TestSMTPSender_Send(t *testing.T) {
+	port, data := fakeSMTP(t)
+	sender := &SMTPSender{Host: "127.0.0.1", Port: port, From: "Chirpy <noreply@chirpy.example>", TLS: TLSNone}
+	msg, _ := Render(Test, "walt@example.com", TestData{SentAt: time.Now()})
+	msg.Subject = "Hello\r\nBcc: eve@example.com"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sender.Send(ctx, msg); err != nil {
+		t.Fatalf("Expected no error sending, got %v", err)
+	}
+	got := <-data
+	tp := textproto.NewReader(bufio.NewReader(strings.NewReader(got + "\n")))
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("Expected a valid message header, got %v", err)
+	}
+	if header.Get("To") != "<walt@example.com>" || !strings.HasPrefix(header.Get("Content-Type"), "multipart/alternative") {
+		t.Fatalf("Expected a multipart message to walt, got %v", header)
+	}
+	if header.Get("Bcc") != "" {
+		t.Fatalf("Expected the subject not to inject headers, got %v", header)
+	}
+	if !strings.Contains(got, "This is a test email from Chirpy") {
+		t.Fatalf("Expected the body in the message, got %s", got)
+	}
+}
+
+func TestSMTPSender_RequiresStartTLS(t *testing.T) {
+	port, _ := fakeSMTP(t)
+	sender := &SMTPSender{Host: "127.0.0.1", Port: port, From: "noreply@chirpy.example", TLS: TLSStartTLS}
+	msg, _ := Render(Test, "walt@example.com", TestData{SentAt: time.Now()})
+	if err := sender.Send(context.Background(), msg); err == nil {
+		t.Fatalf("Expected a server without STARTTLS to be refused")
+	}
+}
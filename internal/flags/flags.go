@@ -0,0 +1,130 @@
+// Package flags evaluates feature flags stored in the database. Definitions are
+// cached in process and reloaded periodically, so checking a flag on a hot path
+// does not cost a query.
+package flags
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Source loads every flag definition
+type Source interface {
+	GetFeatureFlags(ctx context.Context) ([]database.FeatureFlag, error)
+}
+
+// Evaluator answers whether a flag is on for a user in the current environment
+type Evaluator struct {
+	source      Source
+	environment string
+	ttl         time.Duration
+	now         func() time.Time
+
+	mu       sync.RWMutex
+	flags    map[string]database.FeatureFlag
+	loadedAt time.Time
+}
+
+// New returns an Evaluator for the given environment (the PLATFORM value) that
+// reloads definitions from source at most once per ttl
+func New(source Source, environment string, ttl time.Duration) *Evaluator {
+	return &Evaluator{source: source, environment: environment, ttl: ttl, now: time.Now}
+}
+
+// Enabled reports whether the named flag is on for userID. Unknown flags are off.
+// Partially rolled out flags are off for anonymous callers (uuid.Nil).
+func (e *Evaluator) Enabled(ctx context.Context, name string, userID uuid.UUID) bool {
+	flag, ok := e.load(ctx)[name]
+	return ok && e.evaluate(flag, userID)
+}
+
+// All evaluates every known flag for userID
+func (e *Evaluator) All(ctx context.Context, userID uuid.UUID) map[string]bool {
+	flags := e.load(ctx)
+	result := make(map[string]bool, len(flags))
+	for name, flag := range flags {
+		result[name] = e.evaluate(flag, userID)
+	}
+	return result
+}
+
+// Invalidate makes the next evaluation reload definitions, used after an admin edit
+func (e *Evaluator) Invalidate() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.loadedAt = time.Time{}
+}
+
+func (e *Evaluator) evaluate(flag database.FeatureFlag, userID uuid.UUID) bool {
+	if !flag.Enabled {
+		return false
+	}
+	environments := SplitEnvironments(flag.Environments)
+	if len(environments) > 0 && !slices.Contains(environments, e.environment) {
+		return false
+	}
+	if flag.RolloutPercentage >= 100 {
+		return true
+	}
+	if userID == uuid.Nil {
+		return false
+	}
+	return bucket(flag.Name, userID) < int(flag.RolloutPercentage)
+}
+
+// load returns the cached definitions, refreshing them once they are older than
+// ttl. A failed refresh keeps serving the previous definitions.
+func (e *Evaluator) load(ctx context.Context) map[string]database.FeatureFlag {
+	e.mu.RLock()
+	flags, fresh := e.flags, e.now().Sub(e.loadedAt) < e.ttl
+	e.mu.RUnlock()
+	if fresh {
+		return flags
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// another request may have refreshed while this one waited for the lock
+	if e.now().Sub(e.loadedAt) < e.ttl {
+		return e.flags
+	}
+	rows, err := e.source.GetFeatureFlags(ctx)
+	if err != nil {
+		log.Printf("Error loading feature flags, keeping previous definitions: %s", err.Error())
+		return e.flags
+	}
+	e.flags = make(map[string]database.FeatureFlag, len(rows))
+	for _, flag := range rows {
+		e.flags[flag.Name] = flag
+	}
+	e.loadedAt = e.now()
+	return e.flags
+}
+
+// bucket places a user in [0, 100) for a flag. Hashing the flag name in keeps
+// the same users from landing in the first few percent of every rollout.
+func bucket(name string, userID uuid.UUID) int {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	h.Write(userID[:])
+	return int(h.Sum32() % 100)
+}
+
+// SplitEnvironments parses the comma separated environments column; empty means all
+func SplitEnvironments(s string) []string {
+	var environments []string
+	for _, env := range strings.Split(s, ",") {
+		env = strings.TrimSpace(env)
+		if env != "" {
+			environments = append(environments, env)
+		}
+	}
+	return environments
+}
@@ -0,0 +1,98 @@
+package flags
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type fakeSource struct {
+	flags []database.FeatureFlag
+	err   error
+	loads int
+}
+
+func (f *fakeSource) GetFeatureFlags(ctx context.Context) ([]database.FeatureFlag, error) {
+	f.loads++
+	return f.flags, f.err
+}
+
+func TestEvaluator_EnabledAndEnvironments(t *testing.T) {
+	source := &fakeSource{flags: []database.FeatureFlag{
+		{Name: "polls", Enabled: true, RolloutPercentage: 100},
+		{Name: "dms", Enabled: false, RolloutPercentage: 100},
+		{Name: "ranking", Enabled: true, RolloutPercentage: 100, Environments: "dev, staging"},
+	}}
+	e := New(source, "prod", time.Minute)
+	ctx := context.Background()
+	user := uuid.New()
+
+	if !e.Enabled(ctx, "polls", user) {
+		t.Errorf("Expected polls to be enabled")
+	}
+	if e.Enabled(ctx, "dms", user) {
+		t.Errorf("Expected disabled flag to be off")
+	}
+	if e.Enabled(ctx, "ranking", user) {
+		t.Errorf("Expected flag scoped to other environments to be off")
+	}
+	if e.Enabled(ctx, "missing", user) {
+		t.Errorf("Expected unknown flag to be off")
+	}
+	if !New(source, "staging", time.Minute).Enabled(ctx, "ranking", user) {
+		t.Errorf("Expected flag to be on in a listed environment")
+	}
+}
+
+func TestEvaluator_PercentageRollout(t *testing.T) {
+	source := &fakeSource{flags: []database.FeatureFlag{{Name: "ranking", Enabled: true, RolloutPercentage: 30}}}
+	e := New(source, "prod", time.Minute)
+	ctx := context.Background()
+
+	on := 0
+	for i := 0; i < 2000; i++ {
+		user := uuid.New()
+		enabled := e.Enabled(ctx, "ranking", user)
+		if enabled != e.Enabled(ctx, "ranking", user) {
+			t.Fatalf("Expected evaluation to be stable for a user")
+		}
+		if enabled {
+			on++
+		}
+	}
+	if on < 500 || on > 700 {
+		t.Errorf("Expected roughly 30%% of users in a 30%% rollout, got %d of 2000", on)
+	}
+	if e.Enabled(ctx, "ranking", uuid.Nil) {
+		t.Errorf("Expected partial rollout to be off for anonymous callers")
+	}
+}
+
+func TestEvaluator_CachesDefinitions(t *testing.T) {
+	source := &fakeSource{flags: []database.FeatureFlag{{Name: "polls", Enabled: true, RolloutPercentage: 100}}}
+	e := New(source, "prod", time.Minute)
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	e.Enabled(ctx, "polls", uuid.Nil)
+	e.Enabled(ctx, "polls", uuid.Nil)
+	if source.loads != 1 {
+		t.Fatalf("Expected 1 load within the ttl, got %d", source.loads)
+	}
+	e.Invalidate()
+	e.Enabled(ctx, "polls", uuid.Nil)
+	if source.loads != 2 {
+		t.Fatalf("Expected Invalidate to force a reload, got %d loads", source.loads)
+	}
+	// failed refreshes keep the last good definitions
+	source.err = errors.New("database down")
+	now = now.Add(2 * time.Minute)
+	if !e.Enabled(ctx, "polls", uuid.Nil) {
+		t.Errorf("Expected previous definitions to survive a failed reload")
+	}
+}
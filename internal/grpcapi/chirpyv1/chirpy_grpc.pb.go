@@ -0,0 +1,282 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             (unknown)
+// source: chirpy.proto
+
+// Core Chirpy operations for internal services that prefer gRPC over REST. The
+// messages mirror the JSON bodies of the matching /api/v1 endpoints.
+
+package chirpyv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ChirpyService_Register_FullMethodName    = "/chirpy.v1.ChirpyService/Register"
+	ChirpyService_Login_FullMethodName       = "/chirpy.v1.ChirpyService/Login"
+	ChirpyService_CreateChirp_FullMethodName = "/chirpy.v1.ChirpyService/CreateChirp"
+	ChirpyService_ListChirps_FullMethodName  = "/chirpy.v1.ChirpyService/ListChirps"
+	ChirpyService_GetChirp_FullMethodName    = "/chirpy.v1.ChirpyService/GetChirp"
+)
+
+// ChirpyServiceClient is the client API for ChirpyService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Register, Login, ListChirps and GetChirp are public. CreateChirp needs an access
+// token in the "authorization" metadata as "Bearer <token>".
+type ChirpyServiceClient interface {
+	Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*User, error)
+	Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*LoginResponse, error)
+	CreateChirp(ctx context.Context, in *CreateChirpRequest, opts ...grpc.CallOption) (*Chirp, error)
+	ListChirps(ctx context.Context, in *ListChirpsRequest, opts ...grpc.CallOption) (*ListChirpsResponse, error)
+	GetChirp(ctx context.Context, in *GetChirpRequest, opts ...grpc.CallOption) (*Chirp, error)
+}
+
+type chirpyServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewChirpyServiceClient(cc grpc.ClientConnInterface) ChirpyServiceClient {
+	return &chirpyServiceClient{cc}
+}
+
+func (c *chirpyServiceClient) Register(ctx context.Context, in *RegisterRequest, opts ...grpc.CallOption) (*User, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(User)
+	err := c.cc.Invoke(ctx, ChirpyService_Register_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chirpyServiceClient) Login(ctx context.Context, in *LoginRequest, opts ...grpc.CallOption) (*LoginResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LoginResponse)
+	err := c.cc.Invoke(ctx, ChirpyService_Login_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chirpyServiceClient) CreateChirp(ctx context.Context, in *CreateChirpRequest, opts ...grpc.CallOption) (*Chirp, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Chirp)
+	err := c.cc.Invoke(ctx, ChirpyService_CreateChirp_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chirpyServiceClient) ListChirps(ctx context.Context, in *ListChirpsRequest, opts ...grpc.CallOption) (*ListChirpsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListChirpsResponse)
+	err := c.cc.Invoke(ctx, ChirpyService_ListChirps_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *chirpyServiceClient) GetChirp(ctx context.Context, in *GetChirpRequest, opts ...grpc.CallOption) (*Chirp, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Chirp)
+	err := c.cc.Invoke(ctx, ChirpyService_GetChirp_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ChirpyServiceServer is the server API for ChirpyService service.
+// All implementations must embed UnimplementedChirpyServiceServer
+// for forward compatibility.
+//
+// Register, Login, ListChirps and GetChirp are public. CreateChirp needs an access
+// token in the "authorization" metadata as "Bearer <token>".
+type ChirpyServiceServer interface {
+	Register(context.Context, *RegisterRequest) (*User, error)
+	Login(context.Context, *LoginRequest) (*LoginResponse, error)
+	CreateChirp(context.Context, *CreateChirpRequest) (*Chirp, error)
+	ListChirps(context.Context, *ListChirpsRequest) (*ListChirpsResponse, error)
+	GetChirp(context.Context, *GetChirpRequest) (*Chirp, error)
+	mustEmbedUnimplementedChirpyServiceServer()
+}
+
+// UnimplementedChirpyServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedChirpyServiceServer struct{}
+
+func (UnimplementedChirpyServiceServer) Register(context.Context, *RegisterRequest) (*User, error) {
+	return nil, status.Error(codes.Unimplemented, "method Register not implemented")
+}
+func (UnimplementedChirpyServiceServer) Login(context.Context, *LoginRequest) (*LoginResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Login not implemented")
+}
+func (UnimplementedChirpyServiceServer) CreateChirp(context.Context, *CreateChirpRequest) (*Chirp, error) {
+	return nil, status.Error(codes.Unimplemented, "method CreateChirp not implemented")
+}
+func (UnimplementedChirpyServiceServer) ListChirps(context.Context, *ListChirpsRequest) (*ListChirpsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListChirps not implemented")
+}
+func (UnimplementedChirpyServiceServer) GetChirp(context.Context, *GetChirpRequest) (*Chirp, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetChirp not implemented")
+}
+func (UnimplementedChirpyServiceServer) mustEmbedUnimplementedChirpyServiceServer() {}
+func (UnimplementedChirpyServiceServer) testEmbeddedByValue()                       {}
+
+// UnsafeChirpyServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ChirpyServiceServer will
+// result in compilation errors.
+type UnsafeChirpyServiceServer interface {
+	mustEmbedUnimplementedChirpyServiceServer()
+}
+
+func RegisterChirpyServiceServer(s grpc.ServiceRegistrar, srv ChirpyServiceServer) {
+	// If the following call panics, it indicates UnimplementedChirpyServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ChirpyService_ServiceDesc, srv)
+}
+
+func _ChirpyService_Register_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChirpyServiceServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChirpyService_Register_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChirpyServiceServer).Register(ctx, req.(*RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChirpyService_Login_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LoginRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChirpyServiceServer).Login(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChirpyService_Login_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChirpyServiceServer).Login(ctx, req.(*LoginRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChirpyService_CreateChirp_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateChirpRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChirpyServiceServer).CreateChirp(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChirpyService_CreateChirp_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChirpyServiceServer).CreateChirp(ctx, req.(*CreateChirpRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChirpyService_ListChirps_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListChirpsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChirpyServiceServer).ListChirps(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChirpyService_ListChirps_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChirpyServiceServer).ListChirps(ctx, req.(*ListChirpsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ChirpyService_GetChirp_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetChirpRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ChirpyServiceServer).GetChirp(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ChirpyService_GetChirp_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ChirpyServiceServer).GetChirp(ctx, req.(*GetChirpRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// ChirpyService_ServiceDesc is the grpc.ServiceDesc for ChirpyService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ChirpyService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "chirpy.v1.ChirpyService",
+	HandlerType: (*ChirpyServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Register",
+			Handler:    _ChirpyService_Register_Handler,
+		},
+		{
+			MethodName: "Login",
+			Handler:    _ChirpyService_Login_Handler,
+		},
+		{
+			MethodName: "CreateChirp",
+			Handler:    _ChirpyService_CreateChirp_Handler,
+		},
+		{
+			MethodName: "ListChirps",
+			Handler:    _ChirpyService_ListChirps_Handler,
+		},
+		{
+			MethodName: "GetChirp",
+			Handler:    _ChirpyService_GetChirp_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "chirpy.proto",
+}
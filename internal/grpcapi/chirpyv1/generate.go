@@ -0,0 +1,4 @@
+// Package chirpyv1 holds the protobuf messages and gRPC service generated from chirpy.proto
+package chirpyv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative chirpy.proto
@@ -0,0 +1,101 @@
+// Package ipfilter matches client addresses against lists of CIDR ranges.
+package ipfilter
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// List is a set of address ranges. The zero value and nil are empty lists that contain
+// no address. A List is never modified once built and is safe for concurrent use.
+type List struct {
+	prefixes []netip.Prefix
+}
+
+// Parse builds a List from entries in CIDR notation ("10.0.0.0/8", "2001:db8::/32") or
+// single addresses, which stand for a range of one. Entries are trimmed and empty ones
+// are dropped, so a comma separated environment variable can be split and passed as is.
+// Host bits are cleared, and duplicates removed.
+func Parse(entries []string) (*List, error) {
+	l := &List{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(l.prefixes, prefix) {
+			l.prefixes = append(l.prefixes, prefix)
+		}
+	}
+	return l, nil
+}
+
+func parsePrefix(entry string) (netip.Prefix, error) {
+	if !strings.Contains(entry, "/") {
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid address %q: want an IP or a CIDR range", entry)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(entry)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q: %w", entry, err)
+	}
+	if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+		prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+	}
+	return prefix.Masked(), nil
+}
+
+// Contains reports whether addr is in any range of the list. IPv4 addresses mapped into
+// IPv6 ("::ffff:10.0.0.1") match IPv4 ranges.
+func (l *List) Contains(addr netip.Addr) bool {
+	if l == nil || !addr.IsValid() {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range l.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsString is Contains for an address in text form. Anything that is not an IP
+// address, such as the path of a unix socket client, is in no list.
+func (l *List) ContainsString(addr string) bool {
+	parsed, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	return l.Contains(parsed)
+}
+
+// Len reports how many ranges are in the list
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.prefixes)
+}
+
+// Strings returns the ranges in CIDR notation, in the order they were given
+func (l *List) Strings() []string {
+	if l == nil {
+		return []string{}
+	}
+	entries := make([]string, len(l.prefixes))
+	for i, prefix := range l.prefixes {
+		entries[i] = prefix.String()
+	}
+	return entries
+}
@@ -0,0 +1,60 @@
+package ipfilter
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestList_Contains(t *testing.T) {
+	l, err := Parse([]string{"10.0.0.0/8", " 192.168.1.7 ", "2001:db8::/32", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"192.168.1.7", true},
+		{"192.168.1.8", false},
+		{"::ffff:10.0.0.1", true},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+		{"not-an-ip", false},
+		{"@", false},
+	}
+	for _, c := range cases {
+		if got := l.ContainsString(c.addr); got != c.want {
+			t.Errorf("ContainsString(%q) = %t, want %t", c.addr, got, c.want)
+		}
+	}
+}
+
+func TestParse_Normalizes(t *testing.T) {
+	l, err := Parse([]string{"10.1.2.3/8", "10.0.0.0/8", "::ffff:172.16.0.0/108", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(l.Strings(), ","); got != "10.0.0.0/8,172.16.0.0/12,::1/128" {
+		t.Fatalf("Expected masked, unmapped, deduplicated ranges, got %q", got)
+	}
+	if l.Len() != 3 {
+		t.Fatalf("Expected 3 ranges, got %d", l.Len())
+	}
+}
+
+func TestParse_RejectsInvalidEntries(t *testing.T) {
+	for _, entry := range []string{"10.0.0.0/33", "example.com", "10.0.0/8"} {
+		if _, err := Parse([]string{entry}); err == nil {
+			t.Errorf("Expected an error for %q", entry)
+		}
+	}
+}
+
+func TestList_NilIsEmpty(t *testing.T) {
+	var l *List
+	if l.ContainsString("10.0.0.1") || l.Len() != 0 || len(l.Strings()) != 0 {
+		t.Fatal("Expected a nil list to contain nothing")
+	}
+}
@@ -0,0 +1,264 @@
+// Package jobs runs background work stored in the jobs table. Jobs are claimed
+// with a lease, so several instances can share one table and a job whose worker
+// died is picked up again once its lease runs out. Failed jobs are retried with
+// exponential backoff until they run out of attempts and are marked dead.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Store is the persistence the queue needs; store.Store satisfies it
+type Store interface {
+	EnqueueJob(ctx context.Context, arg database.EnqueueJobParams) (database.Job, error)
+	ClaimJob(ctx context.Context, arg database.ClaimJobParams) (database.Job, error)
+	CompleteJob(ctx context.Context, id uuid.UUID) error
+	RetryJob(ctx context.Context, arg database.RetryJobParams) error
+	BuryJob(ctx context.Context, arg database.BuryJobParams) error
+	SetJobProgress(ctx context.Context, arg database.SetJobProgressParams) error
+}
+
+// Handler processes one job. The payload is the JSON value passed to Enqueue.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error as not worth retrying, the job goes straight to dead
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// Options tune a Queue; zero values pick the defaults noted on each field
+type Options struct {
+	// Workers is the number of jobs processed concurrently. Zero disables processing
+	// so the instance only enqueues.
+	Workers int
+	// PollInterval is how long an idle worker waits before looking again (1s)
+	PollInterval time.Duration
+	// Lease is how long a claimed job is reserved for its worker (5m). Handlers
+	// running longer than this may be run twice.
+	Lease time.Duration
+	// MaxAttempts is how many times a job is tried before it is dead (5)
+	MaxAttempts int
+	// BaseBackoff is the delay before the first retry, doubling each attempt up to an hour (10s)
+	BaseBackoff time.Duration
+}
+
+// Queue enqueues jobs and runs registered handlers for them
+type Queue struct {
+	store    Store
+	opts     Options
+	now      func() time.Time
+	mu       sync.RWMutex
+	handlers map[string]Handler
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+func New(store Store, opts Options) *Queue {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = 5 * time.Minute
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 10 * time.Second
+	}
+	return &Queue{
+		store:    store,
+		opts:     opts,
+		now:      func() time.Time { return time.Now().UTC() },
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Register sets the handler for a job kind. Register every kind before Start.
+func (q *Queue) Register(kind string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+}
+
+// Enqueue schedules a job to run as soon as a worker is free
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload any) (database.Job, error) {
+	return q.EnqueueAt(ctx, kind, payload, q.now())
+}
+
+// EnqueueAt schedules a job to run no earlier than runAt
+func (q *Queue) EnqueueAt(ctx context.Context, kind string, payload any, runAt time.Time) (database.Job, error) {
+	return EnqueueWith(ctx, q.store, kind, payload, runAt, q.opts.MaxAttempts)
+}
+
+// EnqueueTx schedules a job like Enqueue, through store, so it can be inserted in the
+// same transaction as the rows it works on
+func (q *Queue) EnqueueTx(ctx context.Context, store Store, kind string, payload any) (database.Job, error) {
+	return EnqueueWith(ctx, store, kind, payload, q.now(), q.opts.MaxAttempts)
+}
+
+// EnqueueWith inserts a job through store directly, so it can be part of a
+// transaction alongside the write that caused it
+func EnqueueWith(ctx context.Context, store Store, kind string, payload any, runAt time.Time, maxAttempts int) (database.Job, error) {
+	params, err := JobParams(kind, payload, runAt, maxAttempts)
+	if err != nil {
+		return database.Job{}, err
+	}
+	return store.EnqueueJob(ctx, params)
+}
+
+// JobParams encodes a job for EnqueueJob, or for EnqueueJobs when inserting several at once
+func JobParams(kind string, payload any, runAt time.Time, maxAttempts int) (database.EnqueueJobParams, error) {
+	dat, err := json.Marshal(payload)
+	if err != nil {
+		return database.EnqueueJobParams{}, fmt.Errorf("encoding %s job payload: %w", kind, err)
+	}
+	return database.EnqueueJobParams{
+		Kind:        kind,
+		Payload:     string(dat),
+		MaxAttempts: int32(maxAttempts),
+		RunAt:       runAt.UTC(),
+	}, nil
+}
+
+// Start launches the workers. They run until Stop is called or ctx is done.
+func (q *Queue) Start(ctx context.Context) {
+	ctx, q.cancel = context.WithCancel(ctx)
+	for i := 0; i < q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.work(ctx)
+	}
+}
+
+// Stop asks the workers to finish their current job and waits for them, or until
+// ctx is done. Jobs still running when ctx expires are retried after their lease.
+func (q *Queue) Stop(ctx context.Context) error {
+	if q.cancel == nil {
+		return nil
+	}
+	q.cancel()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	defer q.wg.Done()
+	for {
+		ran, err := q.RunOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error running job: %s", err.Error())
+		}
+		if ran {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.opts.PollInterval):
+		}
+	}
+}
+
+// RunOnce claims and runs a single due job, reporting whether there was one
+func (q *Queue) RunOnce(ctx context.Context) (bool, error) {
+	now := q.now()
+	job, err := q.store.ClaimJob(ctx, database.ClaimJobParams{
+		LockedUntil: sql.NullTime{Time: now.Add(q.opts.Lease), Valid: true},
+		Now:         now,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("claiming job: %w", err)
+	}
+	// a job that was started keeps going through shutdown so it is not half done
+	runErr := q.run(context.WithoutCancel(ctx), job)
+	if runErr == nil {
+		return true, q.store.CompleteJob(context.WithoutCancel(ctx), job.ID)
+	}
+	var permanent permanentError
+	if errors.As(runErr, &permanent) || job.Attempts >= job.MaxAttempts {
+		log.Printf("Job %s (%s) failed for good after %d attempts: %s", job.ID, job.Kind, job.Attempts, runErr.Error())
+		return true, q.store.BuryJob(context.WithoutCancel(ctx), database.BuryJobParams{ID: job.ID, LastError: runErr.Error()})
+	}
+	delay := q.backoff(int(job.Attempts))
+	log.Printf("Job %s (%s) failed attempt %d, retrying in %s: %s", job.ID, job.Kind, job.Attempts, delay.Round(time.Second), runErr.Error())
+	return true, q.store.RetryJob(context.WithoutCancel(ctx), database.RetryJobParams{ID: job.ID, RunAt: q.now().Add(delay), LastError: runErr.Error()})
+}
+
+// run calls the handler for a job, turning panics and unknown kinds into errors
+func (q *Queue) run(ctx context.Context, job database.Job) (err error) {
+	q.mu.RLock()
+	handler, ok := q.handlers[job.Kind]
+	q.mu.RUnlock()
+	if !ok {
+		return Permanent(fmt.Errorf("no handler registered for job kind %q", job.Kind))
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, q.opts.Lease)
+	defer cancel()
+	ctx = context.WithValue(ctx, jobIDKey{}, job.ID)
+	return handler(ctx, json.RawMessage(job.Payload))
+}
+
+type jobIDKey struct{}
+
+// ID reports the job whose handler ctx belongs to
+func ID(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := ctx.Value(jobIDKey{}).(uuid.UUID)
+	return id, ok
+}
+
+// SetProgress saves progress, as JSON, on the job whose handler ctx belongs to, so
+// admins can follow a long job while it runs. It outlasts the job and does nothing
+// outside a handler.
+func (q *Queue) SetProgress(ctx context.Context, progress any) error {
+	id, ok := ID(ctx)
+	if !ok {
+		return nil
+	}
+	dat, err := json.Marshal(progress)
+	if err != nil {
+		return err
+	}
+	return q.store.SetJobProgress(ctx, database.SetJobProgressParams{ID: id, Progress: string(dat)})
+}
+
+// backoff doubles the delay with every attempt, capped at an hour, with ±20% jitter
+// so retries from a burst of failures spread out
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.opts.BaseBackoff << min(attempt-1, 20)
+	delay = min(delay, time.Hour)
+	jitter := time.Duration(rand.Int64N(int64(delay)/5*2+1)) - delay/5
+	return delay + jitter
+}
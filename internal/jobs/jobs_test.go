@@ -0,0 +1,159 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+)
+
+func TestQueue_RunsJob(t *testing.T) {
+	q := New(store.NewMemory(), Options{})
+	ctx := context.Background()
+	var got string
+	q.Register("greet", func(ctx context.Context, payload json.RawMessage) error {
+		return json.Unmarshal(payload, &got)
+	})
+	_, err := q.Enqueue(ctx, "greet", "hello")
+	if err != nil {
+		t.Fatalf("Expected no error enqueueing, got %v", err)
+	}
+	ran, err := q.RunOnce(ctx)
+	if !ran || err != nil {
+		t.Fatalf("Expected the job to run, got ran=%v err=%v", ran, err)
+	}
+	if got != "hello" {
+		t.Fatalf("Expected payload hello, got %q", got)
+	}
+	ran, _ = q.RunOnce(ctx)
+	if ran {
+		t.Fatalf("Expected a completed job not to run again")
+	}
+}
+
+func TestQueue_RetriesWithBackoffThenDies(t *testing.T) {
+	s := store.NewMemory()
+	q := New(s, Options{MaxAttempts: 2, BaseBackoff: time.Minute})
+	now := time.Now().UTC()
+	q.now = func() time.Time { return now }
+	ctx := context.Background()
+	q.Register("flaky", func(ctx context.Context, payload json.RawMessage) error {
+		return errors.New("remote is down")
+	})
+	job, _ := q.Enqueue(ctx, "flaky", nil)
+
+	q.RunOnce(ctx)
+	if ran, _ := q.RunOnce(ctx); ran {
+		t.Fatalf("Expected the retry to wait for its backoff")
+	}
+	now = now.Add(2 * time.Minute)
+	if ran, _ := q.RunOnce(ctx); !ran {
+		t.Fatalf("Expected the retry to run once the backoff passed")
+	}
+	dead, _ := s.ListJobsByStatus(ctx, database.ListJobsByStatusParams{Status: "dead", Limit: 10})
+	if len(dead) != 1 || dead[0].ID != job.ID || dead[0].LastError != "remote is down" {
+		t.Fatalf("Expected the job to be dead with its last error, got %+v", dead)
+	}
+}
+
+func TestQueue_PermanentErrorsAndUnknownKinds(t *testing.T) {
+	s := store.NewMemory()
+	q := New(s, Options{})
+	ctx := context.Background()
+	q.Register("bad", func(ctx context.Context, payload json.RawMessage) error {
+		return Permanent(errors.New("invalid payload"))
+	})
+	q.Enqueue(ctx, "bad", nil)
+	q.Enqueue(ctx, "unknown", nil)
+	q.RunOnce(ctx)
+	q.RunOnce(ctx)
+
+	dead, _ := s.ListJobsByStatus(ctx, database.ListJobsByStatusParams{Status: "dead", Limit: 10})
+	if len(dead) != 2 {
+		t.Fatalf("Expected both jobs dead after one attempt, got %+v", dead)
+	}
+	for _, job := range dead {
+		if job.Attempts != 1 {
+			t.Errorf("Expected 1 attempt for %s, got %d", job.Kind, job.Attempts)
+		}
+	}
+}
+
+func TestQueue_ReclaimsExpiredLease(t *testing.T) {
+	s := store.NewMemory()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	EnqueueWith(ctx, s, "slow", nil, now, 5)
+	// a worker that claimed the job and then died
+	_, err := s.ClaimJob(ctx, database.ClaimJobParams{LockedUntil: nullTime(now.Add(time.Minute)), Now: now})
+	if err != nil {
+		t.Fatalf("Expected claim to succeed, got %v", err)
+	}
+
+	q := New(s, Options{})
+	q.now = func() time.Time { return now.Add(2 * time.Minute) }
+	ran := false
+	q.Register("slow", func(ctx context.Context, payload json.RawMessage) error {
+		ran = true
+		return nil
+	})
+	q.RunOnce(ctx)
+	if !ran {
+		t.Fatalf("Expected the job to be reclaimed after its lease expired")
+	}
+}
+
+func TestQueue_StartStop(t *testing.T) {
+	q := New(store.NewMemory(), Options{Workers: 2, PollInterval: 10 * time.Millisecond})
+	done := make(chan struct{})
+	q.Register("signal", func(ctx context.Context, payload json.RawMessage) error {
+		close(done)
+		return nil
+	})
+	q.Start(context.Background())
+	q.Enqueue(context.Background(), "signal", nil)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected a worker to pick up the job")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := q.Stop(ctx); err != nil {
+		t.Fatalf("Expected workers to stop, got %v", err)
+	}
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: true}
+}
+
+func TestQueue_SetProgressSavesOnTheRunningJob(t *testing.T) {
+	s := store.NewMemory()
+	q := New(s, Options{})
+	ctx := context.Background()
+	q.Register("count", func(ctx context.Context, payload json.RawMessage) error {
+		for i := 1; i <= 3; i++ {
+			if err := q.SetProgress(ctx, map[string]int{"done": i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	job, _ := q.Enqueue(ctx, "count", nil)
+	if err := q.SetProgress(ctx, "ignored"); err != nil {
+		t.Fatalf("Expected SetProgress outside a job to do nothing, got %v", err)
+	}
+	if ran, err := q.RunOnce(ctx); !ran || err != nil {
+		t.Fatalf("Expected the job to run, got ran=%v err=%v", ran, err)
+	}
+	job, err := s.GetJob(ctx, job.ID)
+	if err != nil || job.Status != "done" || job.Progress != `{"done":3}` {
+		t.Fatalf("Expected the last progress on the finished job, got %+v (%v)", job, err)
+	}
+}
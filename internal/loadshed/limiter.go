@@ -0,0 +1,77 @@
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrLimited is returned by Acquire when a request could not start in time
+var ErrLimited = errors.New("too many concurrent requests")
+
+// LimiterOptions bound how many requests run at once and how many may wait for a slot
+type LimiterOptions struct {
+	MaxConcurrent int
+	// MaxQueued requests wait for a slot, any more are turned away at once
+	MaxQueued int
+	// QueueTimeout is how long a request waits for a slot before it is turned away
+	QueueTimeout time.Duration
+}
+
+// Limiter is a semaphore that lets up to MaxConcurrent requests run, queues a few more
+// briefly, and turns away the rest, so a burst cannot exhaust the database pool
+type Limiter struct {
+	opts     LimiterOptions
+	slots    chan struct{}
+	queued   atomic.Int64
+	rejected atomic.Int64
+}
+
+func NewLimiter(opts LimiterOptions) *Limiter {
+	return &Limiter{opts: opts, slots: make(chan struct{}, opts.MaxConcurrent)}
+}
+
+// Acquire waits for a slot and returns the function that frees it. It returns
+// ErrLimited when the queue is full or the wait exceeds QueueTimeout, and the context's
+// error when ctx ends first.
+func (l *Limiter) Acquire(ctx context.Context) (func(), error) {
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if l.queued.Add(1) > int64(l.opts.MaxQueued) {
+		l.queued.Add(-1)
+		l.rejected.Add(1)
+		return nil, ErrLimited
+	}
+	defer l.queued.Add(-1)
+	timer := time.NewTimer(l.opts.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		l.rejected.Add(1)
+		return nil, ErrLimited
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Running is the number of requests holding a slot
+func (l *Limiter) Running() int {
+	return len(l.slots)
+}
+
+// Queued is the number of requests waiting for a slot
+func (l *Limiter) Queued() int64 {
+	return l.queued.Load()
+}
+
+// Rejected is the number of requests turned away since the limiter was created
+func (l *Limiter) Rejected() int64 {
+	return l.rejected.Load()
+}
@@ -0,0 +1,101 @@
+// Package loadshed tells when the server is saturated, from the number of requests in
+// flight and the recent p99 latency, so callers can turn away work that can wait. Its
+// Limiter caps how many requests run at once.
+package loadshed
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options are the limits past which the server counts as overloaded. A zero limit is
+// not checked.
+type Options struct {
+	MaxInFlight int
+	TargetP99   time.Duration
+	// Window is how far back latencies count towards the p99
+	Window time.Duration
+}
+
+type sample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// Shedder tracks requests in flight and their latencies. The p99 is recomputed at most
+// once a second, so checking it is cheap.
+type Shedder struct {
+	opts     Options
+	inFlight atomic.Int64
+	mu       sync.Mutex
+	samples  []sample
+	computed time.Time
+	p99      time.Duration
+	now      func() time.Time
+}
+
+func New(opts Options) *Shedder {
+	if opts.Window <= 0 {
+		opts.Window = 10 * time.Second
+	}
+	return &Shedder{opts: opts, now: time.Now}
+}
+
+// Start records a request entering the server. The returned function must be called
+// when it finishes.
+func (s *Shedder) Start() func() {
+	s.inFlight.Add(1)
+	start := s.now()
+	return func() {
+		s.inFlight.Add(-1)
+		now := s.now()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.samples = append(s.samples, sample{at: now, duration: now.Sub(start)})
+		if now.Sub(s.computed) >= time.Second {
+			s.recompute(now)
+		}
+	}
+}
+
+// InFlight is the number of requests started and not yet finished
+func (s *Shedder) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// P99 is the 99th percentile latency of the requests that finished within the window
+func (s *Shedder) P99() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := s.now(); now.Sub(s.computed) >= time.Second {
+		s.recompute(now)
+	}
+	return s.p99
+}
+
+// recompute drops samples older than the window and sorts the rest. Callers must hold the lock.
+func (s *Shedder) recompute(now time.Time) {
+	// old samples drop out, so an idle server recovers even when nothing is admitted
+	cutoff := now.Add(-s.opts.Window)
+	s.samples = slices.DeleteFunc(s.samples, func(x sample) bool { return x.at.Before(cutoff) })
+	s.p99 = 0
+	if len(s.samples) > 0 {
+		durations := make([]time.Duration, len(s.samples))
+		for i, x := range s.samples {
+			durations[i] = x.duration
+		}
+		slices.Sort(durations)
+		s.p99 = durations[(len(durations)*99-1)/100]
+	}
+	s.computed = now
+}
+
+// Overloaded reports whether either limit is exceeded
+func (s *Shedder) Overloaded() bool {
+	if s.opts.MaxInFlight > 0 && s.InFlight() >= int64(s.opts.MaxInFlight) {
+		return true
+	}
+	return s.opts.TargetP99 > 0 && s.P99() > s.opts.TargetP99
+}
@@ -0,0 +1,87 @@
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShedder_InFlightLimit(t *testing.T) {
+	s := New(Options{MaxInFlight: 2})
+	first, second := s.Start(), s.Start()
+	if !s.Overloaded() {
+		t.Fatal("Expected two requests in flight to reach the limit")
+	}
+	first()
+	if s.Overloaded() {
+		t.Fatal("Expected the server to recover once a request finished")
+	}
+	second()
+	if s.InFlight() != 0 {
+		t.Fatalf("Expected nothing in flight, got %d", s.InFlight())
+	}
+}
+
+func TestShedder_P99RecoversAfterWindow(t *testing.T) {
+	s := New(Options{TargetP99: 100 * time.Millisecond, Window: 10 * time.Second})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	for i := range 100 {
+		done := s.Start()
+		if i >= 98 {
+			now = now.Add(time.Second)
+		} else {
+			now = now.Add(time.Millisecond)
+		}
+		done()
+	}
+	now = now.Add(time.Second)
+	if got := s.P99(); got != time.Second {
+		t.Fatalf("Expected a p99 of 1s, got %v", got)
+	}
+	if !s.Overloaded() {
+		t.Fatal("Expected a slow p99 to count as overloaded")
+	}
+	now = now.Add(11 * time.Second)
+	if s.Overloaded() {
+		t.Fatalf("Expected old samples to age out, p99 is %v", s.P99())
+	}
+}
+
+func TestLimiter_QueuesThenRejects(t *testing.T) {
+	l := NewLimiter(LimiterOptions{MaxConcurrent: 1, MaxQueued: 1, QueueTimeout: 50 * time.Millisecond})
+	release, err := l.Acquire(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the first request to run, got %v", err)
+	}
+
+	// the second request waits for the first, the third finds the queue full
+	acquired := make(chan error)
+	go func() {
+		release, err := l.Acquire(context.Background())
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	for l.Queued() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := l.Acquire(context.Background()); !errors.Is(err, ErrLimited) {
+		t.Fatalf("Expected ErrLimited with the queue full, got %v", err)
+	}
+	release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("Expected the queued request to run once a slot was freed, got %v", err)
+	}
+
+	release, _ = l.Acquire(context.Background())
+	if _, err := l.Acquire(context.Background()); !errors.Is(err, ErrLimited) {
+		t.Fatalf("Expected ErrLimited after QueueTimeout, got %v", err)
+	}
+	release()
+	if l.Rejected() != 2 || l.Queued() != 0 || l.Running() != 0 {
+		t.Fatalf("Expected 2 rejected and nothing queued or running, got %d, %d, %d", l.Rejected(), l.Queued(), l.Running())
+	}
+}
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/tracing"
+)
+
+// Route label of queries run outside an HTTP request: background jobs, scheduled
+// tasks and gRPC calls
+const noRoute = "other"
+
+type routeKey struct{}
+
+// SlowQuery is a query that ran for at least the slow query threshold
+type SlowQuery struct {
+	Time       time.Time `json:"time"`
+	Query      string    `json:"query"`
+	Route      string    `json:"route"`
+	DurationMs float64   `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// QueryStat summarizes every run of one sqlc query
+type QueryStat struct {
+	Query     string  `json:"query"`
+	Calls     uint64  `json:"calls"`
+	Errors    uint64  `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	TotalMs   float64 `json:"total_ms"`
+	AvgMs     float64 `json:"avg_ms"`
+}
+
+// RouteQueryStat is the database work done on behalf of one route
+type RouteQueryStat struct {
+	Route  string `json:"route"`
+	Calls  uint64 `json:"calls"`
+	Errors uint64 `json:"errors"`
+	// calls by sqlc query name
+	Queries map[string]uint64 `json:"queries"`
+}
+
+// DBStats is what GET /admin/db-stats reports
+type DBStats struct {
+	SlowQueryThresholdMs float64          `json:"slow_query_threshold_ms"`
+	Queries              []QueryStat      `json:"queries"`
+	Routes               []RouteQueryStat `json:"routes"`
+	SlowQueries          []SlowQuery      `json:"slow_queries"`
+}
+
+// SetSlowQueryThreshold sets how long a query runs before it is logged and kept as
+// one of the recent slow queries; zero turns that off
+func (m *Metrics) SetSlowQueryThreshold(threshold time.Duration) {
+	m.slowQueryThreshold = threshold
+}
+
+// DB wraps the connection handed to sqlc so every generated query is counted and timed.
+// Rows are read after QueryContext returns, so its duration covers running the query
+// but not reading the result.
+type DB struct {
+	db      database.DBTX
+	metrics *Metrics
+}
+
+func (m *Metrics) WrapDB(db database.DBTX) *DB {
+	return &DB{db: db, metrics: m}
+}
+
+func (d *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := d.db.ExecContext(ctx, query, args...)
+	d.metrics.observeQuery(ctx, query, start, err)
+	return res, err
+}
+
+func (d *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	start := time.Now()
+	stmt, err := d.db.PrepareContext(ctx, query)
+	d.metrics.observeQuery(ctx, query, start, err)
+	return stmt, err
+}
+
+func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	d.metrics.observeQuery(ctx, query, start, err)
+	return rows, err
+}
+
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := d.db.QueryRowContext(ctx, query, args...)
+	d.metrics.observeQuery(ctx, query, start, row.Err())
+	return row
+}
+
+// Counts and times one query. A lookup that finds nothing is an answer, not an error.
+func (m *Metrics) observeQuery(ctx context.Context, query string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	name := tracing.QueryName(query)
+	route, ok := ctx.Value(routeKey{}).(string)
+	if !ok {
+		route = noRoute
+	}
+	status := "ok"
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		status = "error"
+	}
+	m.queriesTotal.WithLabelValues(m.label("query", name), m.label("route", route), m.label("status", status)).Inc()
+	m.queryDuration.WithLabelValues(m.label("query", name)).Observe(elapsed.Seconds())
+	if m.slowQueryThreshold <= 0 || elapsed < m.slowQueryThreshold {
+		return
+	}
+	log.Printf("Slow query %s from %s took %s", name, route, elapsed.Round(time.Millisecond))
+	slow := SlowQuery{Time: start, Query: name, Route: route, DurationMs: float64(elapsed.Microseconds()) / 1000}
+	if status == "error" {
+		slow.Error = err.Error()
+	}
+	m.slowQueries.add(slow)
+}
+
+// DBStats reads query counts, errors and time back out of the registry. Queries come
+// most total time first and routes most calls first, so whatever loads the database
+// most tops each list.
+func (m *Metrics) DBStats() (DBStats, error) {
+	stats := DBStats{SlowQueryThresholdMs: m.slowQueryThreshold.Seconds() * 1000, Queries: []QueryStat{}, Routes: []RouteQueryStat{}}
+	families, err := m.registry.Gather()
+	if err != nil {
+		return stats, err
+	}
+	queries := make(map[string]*QueryStat)
+	query := func(name string) *QueryStat {
+		if queries[name] == nil {
+			queries[name] = &QueryStat{Query: name}
+		}
+		return queries[name]
+	}
+	routes := make(map[string]*RouteQueryStat)
+	for _, family := range families {
+		switch family.GetName() {
+		case "chirpy_db_queries_total":
+			for _, metric := range family.GetMetric() {
+				labels := make(map[string]string)
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				count := uint64(metric.GetCounter().GetValue())
+				q := query(labels["query"])
+				q.Calls += count
+				r := routes[labels["route"]]
+				if r == nil {
+					r = &RouteQueryStat{Route: labels["route"], Queries: map[string]uint64{}}
+					routes[labels["route"]] = r
+				}
+				r.Calls += count
+				r.Queries[labels["query"]] += count
+				if labels["status"] == "error" {
+					q.Errors += count
+					r.Errors += count
+				}
+			}
+		case "chirpy_db_query_duration_seconds":
+			for _, metric := range family.GetMetric() {
+				for _, label := range metric.GetLabel() {
+					if label.GetName() == "query" {
+						query(label.GetValue()).TotalMs = metric.GetHistogram().GetSampleSum() * 1000
+					}
+				}
+			}
+		}
+	}
+	for _, q := range queries {
+		if q.Calls > 0 {
+			q.ErrorRate = float64(q.Errors) / float64(q.Calls)
+			q.AvgMs = q.TotalMs / float64(q.Calls)
+		}
+		stats.Queries = append(stats.Queries, *q)
+	}
+	slices.SortFunc(stats.Queries, func(a, b QueryStat) int {
+		return cmp.Or(cmp.Compare(b.TotalMs, a.TotalMs), cmp.Compare(a.Query, b.Query))
+	})
+	for _, r := range routes {
+		stats.Routes = append(stats.Routes, *r)
+	}
+	slices.SortFunc(stats.Routes, func(a, b RouteQueryStat) int {
+		return cmp.Or(cmp.Compare(b.Calls, a.Calls), cmp.Compare(a.Route, b.Route))
+	})
+	stats.SlowQueries = m.slowQueries.snapshot()
+	slices.SortStableFunc(stats.SlowQueries, func(a, b SlowQuery) int { return b.Time.Compare(a.Time) })
+	if stats.SlowQueries == nil {
+		stats.SlowQueries = []SlowQuery{}
+	}
+	return stats, nil
+}
@@ -0,0 +1,225 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics owns the Prometheus registry and the collectors exported by Chirpy
+type Metrics struct {
+	registry        *prometheus.Registry
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	inFlight        prometheus.Gauge
+	recentErrors    ring[ErrorEvent]
+	queriesTotal    *prometheus.CounterVec
+	queryDuration   *prometheus.HistogramVec
+	slowQueries     ring[SlowQuery]
+	// queries taking at least this long are logged and kept for DBStats, zero keeps none
+	slowQueryThreshold time.Duration
+	// labels left empty on every series, see DropLabels
+	dropped map[string]bool
+}
+
+// Label dimensions the collectors may use, each with a bounded set of values: route
+// patterns rather than paths, known methods, status codes, sqlc query names and ok or
+// error. Raw paths, IDs and other values chosen by clients never become labels.
+var Labels = []string{"route", "method", "code", "query", "status"}
+
+// Methods a request may be labeled with, any other is counted as OTHER
+var knownMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+	http.MethodConnect: true, http.MethodTrace: true,
+}
+
+// Go runtime metrics exported on top of the default memory stats: GC cycles, pauses,
+// heap goal and the CPU the collector takes, and how long goroutines wait to be
+// scheduled. Both climb as a process runs out of headroom, before requests slow down.
+var runtimeMetrics = []collectors.GoRuntimeMetricsRule{
+	collectors.MetricsGC,
+	collectors.MetricsScheduler,
+	{Matcher: regexp.MustCompile(`^/cpu/classes/gc/`)},
+}
+
+// RouteResolver reports the registered pattern that serves a request; *http.ServeMux satisfies it
+type RouteResolver interface {
+	Handler(r *http.Request) (http.Handler, string)
+}
+
+// New registers request, database pool, Go runtime, and process collectors.
+// db may be nil when no database connection is configured.
+func New(db *sql.DB) *Metrics {
+	registry := prometheus.NewRegistry()
+	m := &Metrics{
+		registry: registry,
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "chirpy",
+			Name:      "http_requests_total",
+			Help:      "Total HTTP requests handled, by route pattern, method, and status code.",
+		}, []string{"route", "method", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "chirpy",
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency, by route pattern and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: "chirpy",
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests currently being served.",
+		}),
+		queriesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "chirpy",
+			Name:      "db_queries_total",
+			Help:      "Database queries run, by sqlc query name, the route that ran them, and status (ok or error).",
+		}, []string{"query", "route", "status"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "chirpy",
+			Name:      "db_query_duration_seconds",
+			Help:      "Database query latency, by sqlc query name.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"query"}),
+	}
+	registry.MustRegister(
+		m.requestsTotal,
+		m.requestDuration,
+		m.inFlight,
+		m.queriesTotal,
+		m.queryDuration,
+		collectors.NewGoCollector(collectors.WithGoCollectorRuntimeMetrics(runtimeMetrics...)),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		// go_sql_* with db_name="chirpy": open, in use and idle connections against the
+		// limit, and how often and how long requests waited for one
+		registry.MustRegister(collectors.NewDBStatsCollector(db, "chirpy"))
+	}
+	return m
+}
+
+// RegisterGaugeFunc exports a value computed on every scrape, such as an existing counter
+func (m *Metrics) RegisterGaugeFunc(name, help string, fn func() float64) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "chirpy",
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// RegisterCounterFunc exports a count computed on every scrape that only ever goes up
+func (m *Metrics) RegisterCounterFunc(name, help string, fn func() float64) {
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: "chirpy",
+		Name:      name,
+		Help:      help,
+	}, fn))
+}
+
+// DropLabels leaves the named labels empty on every series, so series that differed
+// only by them are counted as one. Per-route counts are the first to grow as routes are
+// added; dropping route keeps the totals but loses the breakdown, in RouteStats and
+// DBStats too. Names must be in Labels.
+func (m *Metrics) DropLabels(names ...string) error {
+	dropped := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !slices.Contains(Labels, name) {
+			return fmt.Errorf("unknown metric label %q, labels are %s", name, strings.Join(Labels, ", "))
+		}
+		dropped[name] = true
+	}
+	m.dropped = dropped
+	return nil
+}
+
+// label returns value, or "" when the label is dropped
+func (m *Metrics) label(name, value string) string {
+	if m.dropped[name] {
+		return ""
+	}
+	return value
+}
+
+// Handler serves the registry in the Prometheus text exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records status counts, latency, and in-flight requests for every request.
+// Requests are labeled with the route pattern from routes rather than the raw path,
+// so that path parameters such as chirp IDs do not create new series. The route is
+// also put in the request context, so queries the handler runs are counted against it.
+func (m *Metrics) Middleware(routes RouteResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeLabel(routes, r)
+		method := methodLabel(r.Method)
+		r = r.WithContext(context.WithValue(r.Context(), routeKey{}, method+" "+route))
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		m.requestDuration.WithLabelValues(m.label("route", route), m.label("method", method)).Observe(time.Since(start).Seconds())
+		m.requestsTotal.WithLabelValues(m.label("route", route), m.label("method", method), m.label("code", strconv.Itoa(rec.status))).Inc()
+		if rec.status >= 500 {
+			m.recentErrors.add(ErrorEvent{Time: start, Method: r.Method, Route: route, Path: r.URL.Path, Status: rec.status})
+		}
+	})
+}
+
+// routeLabel strips the method from a "METHOD /path" pattern, and groups unrouted requests together
+func routeLabel(routes RouteResolver, r *http.Request) string {
+	_, pattern := routes.Handler(r)
+	if pattern == "" {
+		return "unmatched"
+	}
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
+
+// methodLabel keeps the standard methods and groups any others, which clients may make
+// up freely
+func methodLabel(method string) string {
+	if knownMethods[method] {
+		return method
+	}
+	return "OTHER"
+}
+
+// statusRecorder captures the status code written by the wrapped handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if !rec.wroteHeader {
+		rec.status = code
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddleware_LabelsByRoutePattern(t *testing.T) {
+	m := New(nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(404)
+	})
+	handler := m.Middleware(mux, mux)
+
+	for _, id := range []string{"a", "b", "c"} {
+		req := httptest.NewRequest("GET", "/api/chirps/"+id, nil)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	count := testutil.ToFloat64(m.requestsTotal.WithLabelValues("/api/chirps/{chirpID}", "GET", "404"))
+	if count != 3 {
+		t.Fatalf("Expected 3 requests for route pattern, got %v", count)
+	}
+	if series := testutil.CollectAndCount(m.requestDuration); series != 1 {
+		t.Fatalf("Expected a single latency series, got %d", series)
+	}
+}
+
+func TestMiddleware_UnmatchedRoute(t *testing.T) {
+	m := New(nil)
+	mux := http.NewServeMux()
+	handler := m.Middleware(mux, mux)
+
+	req := httptest.NewRequest("GET", "/nope", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	count := testutil.ToFloat64(m.requestsTotal.WithLabelValues("unmatched", "GET", "404"))
+	if count != 1 {
+		t.Fatalf("Expected 1 unmatched request, got %v", count)
+	}
+}
+
+func TestMiddleware_BoundsLabels(t *testing.T) {
+	m := New(nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {})
+	handler := m.Middleware(mux, mux)
+
+	for _, method := range []string{"GET", "BREW", "WHEN"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/api/chirps/a", nil))
+	}
+	if count := testutil.ToFloat64(m.requestsTotal.WithLabelValues("/api/chirps/{chirpID}", "OTHER", "200")); count != 2 {
+		t.Fatalf("Expected made-up methods to be counted as OTHER, got %v", count)
+	}
+
+	if err := m.DropLabels("route", "code"); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/chirps/b", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope", nil))
+	if count := testutil.ToFloat64(m.requestsTotal.WithLabelValues("", "GET", "")); count != 2 {
+		t.Fatalf("Expected requests to be counted without route and code, got %v", count)
+	}
+
+	if err := m.DropLabels("user_id"); err == nil {
+		t.Fatal("Expected an unknown label to be rejected")
+	}
+}
+
+func TestRouteStatsAndRecentErrors(t *testing.T) {
+	m := New(nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	})
+	handler := m.Middleware(mux, mux)
+
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/broken", nil))
+
+	stats, err := m.RouteStats()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(stats) != 2 || stats[0].Route != "/ok" || stats[0].Requests != 3 {
+		t.Fatalf("Expected /ok first with 3 requests, got %+v", stats)
+	}
+	if stats[1].Route != "/broken" || stats[1].ServerErrors != 1 {
+		t.Fatalf("Expected /broken with 1 server error, got %+v", stats[1])
+	}
+	events := m.RecentErrors()
+	if len(events) != 1 || events[0].Path != "/broken" || events[0].Status != 500 {
+		t.Fatalf("Expected one recent error for /broken, got %+v", events)
+	}
+}
+
+// execOnly answers ExecContext after delay with err; the DBStats test needs nothing else
+type execOnly struct {
+	database.DBTX
+	delay time.Duration
+	err   error
+}
+
+func (e execOnly) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	time.Sleep(e.delay)
+	return nil, e.err
+}
+
+func TestDBStats_CountsQueriesByRoute(t *testing.T) {
+	m := New(nil)
+	m.SetSlowQueryThreshold(20 * time.Millisecond)
+	fast := m.WrapDB(execOnly{})
+	failing := m.WrapDB(execOnly{err: errors.New("connection reset")})
+	slow := m.WrapDB(execOnly{delay: 25 * time.Millisecond})
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/chirps", func(w http.ResponseWriter, r *http.Request) {
+		fast.ExecContext(r.Context(), "-- name: CreateChirp :one\nINSERT ...")
+		failing.ExecContext(r.Context(), "-- name: CreateChirp :one\nINSERT ...")
+		fast.ExecContext(r.Context(), "-- name: GetUser :one\nSELECT ...")
+	})
+	handler := m.Middleware(mux, mux)
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/chirps", nil))
+	slow.ExecContext(context.Background(), "-- name: ClaimJobs :many\nUPDATE ...")
+
+	stats, err := m.DBStats()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(stats.Queries) != 3 || stats.Queries[0].Query != "ClaimJobs" {
+		t.Fatalf("Expected three queries with the slow one first, got %+v", stats.Queries)
+	}
+	for _, q := range stats.Queries {
+		if q.Query == "CreateChirp" && (q.Calls != 2 || q.Errors != 1 || q.ErrorRate != 0.5) {
+			t.Fatalf("Expected two CreateChirp calls with one error, got %+v", q)
+		}
+	}
+	if len(stats.Routes) != 2 || stats.Routes[0].Route != "POST /api/chirps" || stats.Routes[0].Calls != 3 || stats.Routes[0].Queries["CreateChirp"] != 2 {
+		t.Fatalf("Expected the route to be charged three queries, got %+v", stats.Routes)
+	}
+	if stats.Routes[1].Route != "other" {
+		t.Fatalf("Expected queries outside a request under other, got %+v", stats.Routes[1])
+	}
+	if len(stats.SlowQueries) != 1 || stats.SlowQueries[0].Query != "ClaimJobs" || stats.SlowQueryThresholdMs != 20 {
+		t.Fatalf("Expected ClaimJobs as the only slow query, got %+v", stats.SlowQueries)
+	}
+}
+
+// noConnector lets sql.OpenDB build a pool that never connects, which is all its stats need
+type noConnector struct{}
+
+func (noConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return nil, errors.New("no database")
+}
+
+func (noConnector) Driver() driver.Driver { return nil }
+
+func TestHandler_ExportsPoolAndRuntimeStats(t *testing.T) {
+	db := sql.OpenDB(noConnector{})
+	defer db.Close()
+	db.SetMaxOpenConns(7)
+	m := New(db)
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`go_sql_max_open_connections{db_name="chirpy"} 7`,
+		`go_sql_idle_connections{db_name="chirpy"}`,
+		`go_sql_wait_count_total{db_name="chirpy"}`,
+		`go_sql_wait_duration_seconds_total{db_name="chirpy"}`,
+		"go_goroutines ",
+		"go_gc_duration_seconds",
+		"go_gc_heap_goal_bytes ",
+		"go_cpu_classes_gc_total_cpu_seconds_total ",
+		"go_sched_latencies_seconds_bucket",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %s in the exported metrics", want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// RouteStat summarizes the traffic a single route pattern and method has served
+type RouteStat struct {
+	Route        string  `json:"route"`
+	Method       string  `json:"method"`
+	Requests     uint64  `json:"requests"`
+	ServerErrors uint64  `json:"server_errors"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// ErrorEvent is a request that ended in a 5xx response
+type ErrorEvent struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Route  string    `json:"route"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+}
+
+// ring keeps the most recent events of one kind for the admin pages
+type ring[T any] struct {
+	mu     sync.Mutex
+	events []T
+	next   int
+}
+
+const recentEventLimit = 20
+
+func (e *ring[T]) add(event T) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.events) < recentEventLimit {
+		e.events = append(e.events, event)
+		return
+	}
+	e.events[e.next] = event
+	e.next = (e.next + 1) % recentEventLimit
+}
+
+func (e *ring[T]) snapshot() []T {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.events)
+}
+
+// RecentErrors returns up to the last 20 server errors, newest first
+func (m *Metrics) RecentErrors() []ErrorEvent {
+	events := m.recentErrors.snapshot()
+	slices.SortStableFunc(events, func(a, b ErrorEvent) int { return b.Time.Compare(a.Time) })
+	return events
+}
+
+// RouteStats reads per-route request counts and mean latency back out of the
+// registry, busiest routes first
+func (m *Metrics) RouteStats() ([]RouteStat, error) {
+	families, err := m.registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	type key struct{ route, method string }
+	stats := make(map[key]*RouteStat)
+	stat := func(route, method string) *RouteStat {
+		k := key{route, method}
+		if stats[k] == nil {
+			stats[k] = &RouteStat{Route: route, Method: method}
+		}
+		return stats[k]
+	}
+	for _, family := range families {
+		switch family.GetName() {
+		case "chirpy_http_requests_total":
+			for _, metric := range family.GetMetric() {
+				labels := make(map[string]string)
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				s := stat(labels["route"], labels["method"])
+				count := uint64(metric.GetCounter().GetValue())
+				s.Requests += count
+				if len(labels["code"]) == 3 && labels["code"][0] == '5' {
+					s.ServerErrors += count
+				}
+			}
+		case "chirpy_http_request_duration_seconds":
+			for _, metric := range family.GetMetric() {
+				labels := make(map[string]string)
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				histogram := metric.GetHistogram()
+				if histogram.GetSampleCount() > 0 {
+					stat(labels["route"], labels["method"]).AvgLatencyMs = histogram.GetSampleSum() / float64(histogram.GetSampleCount()) * 1000
+				}
+			}
+		}
+	}
+	result := make([]RouteStat, 0, len(stats))
+	for _, s := range stats {
+		result = append(result, *s)
+	}
+	slices.SortFunc(result, func(a, b RouteStat) int {
+		return cmp.Or(cmp.Compare(b.Requests, a.Requests), cmp.Compare(a.Route, b.Route), cmp.Compare(a.Method, b.Method))
+	})
+	return result, nil
+}
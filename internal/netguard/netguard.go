@@ -0,0 +1,42 @@
+// Package netguard keeps outgoing requests to URLs chosen by users, such as webhooks
+// and ActivityPub inboxes, away from the server's own network. Addresses are checked
+// when dialing rather than when a URL is accepted, so DNS rebinding and redirects
+// cannot get around it.
+package netguard
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// RefusePrivate is a net.Dialer Control, run on every address a host resolves to. It
+// rejects loopback, private, link-local and unspecified addresses.
+func RefusePrivate(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	addr := addrPort.Addr().Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return fmt.Errorf("refusing to connect to non-public address %s", addr)
+	}
+	return nil
+}
+
+// Transport clones the default transport to dial with timeout, refusing non-public
+// addresses unless allowPrivate is set
+func Transport(timeout time.Duration, allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = RefusePrivate
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would make the dialer check the proxy's address instead of the server's
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
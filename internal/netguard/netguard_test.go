@@ -0,0 +1,46 @@
+package netguard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRefusePrivate(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1:80":          false,
+		"[::1]:443":             false,
+		"10.1.2.3:443":          false,
+		"192.168.0.10:443":      false,
+		"169.254.169.254:80":    false,
+		"0.0.0.0:80":            false,
+		"[::ffff:127.0.0.1]:80": false,
+		"[fd00::1]:443":         false,
+		"93.184.216.34:443":     true,
+		"[2606:4700::1111]:443": true,
+	}
+	for address, allowed := range cases {
+		err := RefusePrivate("tcp", address, nil)
+		if (err == nil) != allowed {
+			t.Errorf("Expected %s allowed=%v, got %v", address, allowed, err)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	client := &http.Client{Transport: Transport(time.Second, false)}
+	_, err := client.Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Fatalf("Expected the loopback server to be refused, got %v", err)
+	}
+	client = &http.Client{Transport: Transport(time.Second, true)}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Expected private addresses to be allowed, got %v", err)
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,127 @@
+// Package profanity masks banned words in chirps and applies the word-filter rules
+// admins define, which can also hold a chirp for review or reject it.
+package profanity
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Replacement is what every banned word is masked with, whatever its length
+const Replacement = "****"
+
+// Matcher finds banned words in text. Words are runs of letters and digits, so a banned
+// word is caught next to punctuation ("kerfuffle!") but not inside a longer word
+// ("kerfuffles"). Matching ignores case. Build one with New and share it; it is never
+// modified and safe for concurrent use.
+type Matcher struct {
+	words map[string]struct{}
+	// bytes in the longest banned word
+	maxLen int
+}
+
+// New builds a Matcher for words. Entries are trimmed and lowercased, and empty ones
+// are dropped.
+func New(words []string) *Matcher {
+	m := &Matcher{words: make(map[string]struct{}, len(words))}
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" {
+			m.words[word] = struct{}{}
+			m.maxLen = max(m.maxLen, len(word))
+		}
+	}
+	return m
+}
+
+// Words returns the banned words, sorted
+func (m *Matcher) Words() []string {
+	words := make([]string, 0, len(m.words))
+	for word := range m.words {
+		words = append(words, word)
+	}
+	slices.Sort(words)
+	return words
+}
+
+// Len reports how many words are banned
+func (m *Matcher) Len() int {
+	return len(m.words)
+}
+
+// Contains reports whether word, in any case, is banned
+func (m *Matcher) Contains(word string) bool {
+	_, ok := m.words[strings.ToLower(word)]
+	return ok
+}
+
+// Clean returns s with every banned word replaced by Replacement. Everything else,
+// spacing and punctuation included, is kept as it was. s itself is returned when
+// nothing matched, so clean text costs no allocation.
+func (m *Matcher) Clean(s string) string {
+	if len(m.words) == 0 {
+		return s
+	}
+	var out strings.Builder
+	// s[:copied] has been written to out, or nothing has when out is still empty
+	copied := 0
+	var buf [64]byte
+	for start := 0; start < len(s); {
+		r, size := utf8.DecodeRuneInString(s[start:])
+		if !isWordRune(r) {
+			start += size
+			continue
+		}
+		end := start + size
+		for end < len(s) {
+			r, size := utf8.DecodeRuneInString(s[end:])
+			if !isWordRune(r) {
+				break
+			}
+			end += size
+		}
+		// lowercasing shrinks a word to a third of its bytes at most (the Kelvin sign
+		// is three bytes, k one), so anything longer cannot be banned
+		if end-start > 3*m.maxLen {
+			start = end
+			continue
+		}
+		lower := appendLower(buf[:0], s[start:end])
+		// the conversion in a map index does not allocate
+		if _, banned := m.words[string(lower)]; banned {
+			if out.Len() == 0 {
+				out.Grow(len(s))
+			}
+			out.WriteString(s[copied:start])
+			out.WriteString(Replacement)
+			copied = end
+		}
+		start = end
+	}
+	if copied == 0 {
+		return s
+	}
+	out.WriteString(s[copied:])
+	return out.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// Helper function to append the lowercase form of word to dst, byte by byte for ASCII
+func appendLower(dst []byte, word string) []byte {
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		if c >= utf8.RuneSelf {
+			return append(dst[:0], strings.ToLower(word)...)
+		}
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
@@ -0,0 +1,55 @@
+package profanity
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMatcher_Clean(t *testing.T) {
+	m := New([]string{"kerfuffle", " Sharbert ", "fornax", ""})
+	cases := []struct {
+		in, want string
+	}{
+		{"what a kerfuffle", "what a ****"},
+		{"Kerfuffle! What a SHARBERT.", "****! What a ****."},
+		{"(fornax),kerfuffle?", "(****),****?"},
+		{"keep  double\tspaces and\nnewlines kerfuffle ", "keep  double\tspaces and\nnewlines **** "},
+		{"kerfuffles and sharbertly stay", "kerfuffles and sharbertly stay"},
+		{"kerfuffle's", "****'s"},
+		{"ünïcode fornax ünïcode", "ünïcode **** ünïcode"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		if got := m.Clean(c.in); got != c.want {
+			t.Errorf("Clean(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+func TestMatcher_WordsAreNormalized(t *testing.T) {
+	m := New([]string{"Fornax", "kerfuffle", "fornax", " "})
+	if got := strings.Join(m.Words(), ","); got != "fornax,kerfuffle" {
+		t.Fatalf("Expected trimmed, lowercased, deduplicated words, got %q", got)
+	}
+	if !m.Contains("KERFUFFLE") || m.Contains("sharbert") {
+		t.Fatal("Expected Contains to ignore case and only know the configured words")
+	}
+}
+
+func TestMatcher_CleanTextDoesNotAllocate(t *testing.T) {
+	m := New([]string{"kerfuffle", "sharbert", "fornax"})
+	s := "a perfectly polite chirp, with some punctuation!"
+	allocs := testing.AllocsPerRun(100, func() { m.Clean(s) })
+	if allocs != 0 {
+		t.Fatalf("Expected clean text to cost no allocations, got %.0f", allocs)
+	}
+}
+
+func BenchmarkMatcher_Clean(b *testing.B) {
+	m := New([]string{"kerfuffle", "sharbert", "fornax"})
+	s := strings.Repeat("Well, that was a kerfuffle! Nothing to see here. ", 3)
+	b.ReportAllocs()
+	for b.Loop() {
+		m.Clean(s)
+	}
+}
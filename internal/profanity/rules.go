@@ -0,0 +1,244 @@
+package profanity
+
+import (
+	"cmp"
+	"fmt"
+	"iter"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Kind is how a rule's pattern is matched
+type Kind string
+
+const (
+	// KindWord matches a whole word exactly, like the words of a Matcher
+	KindWord Kind = "word"
+	// KindWildcard matches whole words, with * standing for any run of letters and
+	// digits and ? for one of them: "kerf*" catches "kerfuffle" and "kerfuffles"
+	KindWildcard Kind = "wildcard"
+	// KindRegex matches an RE2 regular expression anywhere in the text, so it can
+	// catch phrases and words glued to others
+	KindRegex Kind = "regex"
+)
+
+// Action is what happens to a chirp a rule matches, from the mildest to the most severe
+type Action string
+
+const (
+	// ActionMask replaces the match with Replacement
+	ActionMask Action = "mask"
+	// ActionHold lets the chirp through but puts it up for review
+	ActionHold Action = "hold"
+	// ActionReject refuses the chirp
+	ActionReject Action = "reject"
+)
+
+// Rule is one pattern and what to do when it matches. Matching ignores case.
+type Rule struct {
+	ID      string
+	Pattern string
+	Kind    Kind
+	Action  Action
+}
+
+type compiledRule struct {
+	Rule
+	// lowercased pattern of a word rule
+	word string
+	// a wildcard rule anchored to one whole word, or a regex rule
+	re *regexp.Regexp
+}
+
+// Compile checks that rule has a known kind and action and a pattern that parses
+func Compile(rule Rule) error {
+	_, err := compile(rule)
+	return err
+}
+
+func compile(rule Rule) (compiledRule, error) {
+	c := compiledRule{Rule: rule}
+	switch rule.Action {
+	case ActionMask, ActionHold, ActionReject:
+	default:
+		return c, fmt.Errorf("unknown action %q", rule.Action)
+	}
+	pattern := strings.TrimSpace(rule.Pattern)
+	if pattern == "" {
+		return c, fmt.Errorf("empty pattern")
+	}
+	switch rule.Kind {
+	case KindWord:
+		for _, r := range pattern {
+			if !isWordRune(r) {
+				return c, fmt.Errorf("word %q may only contain letters and digits", pattern)
+			}
+		}
+		c.word = strings.ToLower(pattern)
+	case KindWildcard:
+		var expr strings.Builder
+		expr.WriteString(`(?i)^`)
+		for _, r := range pattern {
+			switch {
+			case r == '*':
+				expr.WriteString(`[\p{L}\p{N}]*`)
+			case r == '?':
+				expr.WriteString(`[\p{L}\p{N}]`)
+			case isWordRune(r):
+				expr.WriteString(regexp.QuoteMeta(string(r)))
+			default:
+				return c, fmt.Errorf("wildcard %q may only contain letters, digits, * and ?", pattern)
+			}
+		}
+		expr.WriteString(`$`)
+		c.re = regexp.MustCompile(expr.String())
+	case KindRegex:
+		re, err := regexp.Compile(`(?i)` + pattern)
+		if err != nil {
+			return c, fmt.Errorf("invalid regex: %w", err)
+		}
+		if re.MatchString("") {
+			return c, fmt.Errorf("regex %q matches empty text", pattern)
+		}
+		c.re = re
+	default:
+		return c, fmt.Errorf("unknown kind %q", rule.Kind)
+	}
+	return c, nil
+}
+
+// Filter applies rules to chirps. Build one with NewFilter and share it; it is never
+// modified and safe for concurrent use. A nil *Filter has no rules.
+type Filter struct {
+	rules []compiledRule
+}
+
+// NewFilter compiles rules, failing on the first one Compile refuses
+func NewFilter(rules []Rule) (*Filter, error) {
+	f := &Filter{rules: make([]compiledRule, 0, len(rules))}
+	for _, rule := range rules {
+		c, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", rule.ID, err)
+		}
+		f.rules = append(f.rules, c)
+	}
+	return f, nil
+}
+
+// Len reports how many rules the filter applies
+func (f *Filter) Len() int {
+	if f == nil {
+		return 0
+	}
+	return len(f.rules)
+}
+
+// Result is what a Filter made of a chirp
+type Result struct {
+	// the text with every mask match replaced
+	Text string
+	// the first reject rule that matched, when the chirp is refused
+	Rejected *Rule
+	// the hold rules that matched
+	Held []Rule
+}
+
+// Apply runs every rule over s. Reject and hold rules look at s as it was, before
+// anything is masked. Text is s itself when nothing was masked.
+func (f *Filter) Apply(s string) Result {
+	res := Result{Text: s}
+	if f.Len() == 0 {
+		return res
+	}
+	var masked [][2]int
+	for i := range f.rules {
+		rule := &f.rules[i]
+		spans := rule.find(s, rule.Action != ActionMask)
+		if len(spans) == 0 {
+			continue
+		}
+		switch rule.Action {
+		case ActionReject:
+			if res.Rejected == nil {
+				res.Rejected = &rule.Rule
+			}
+		case ActionHold:
+			res.Held = append(res.Held, rule.Rule)
+		case ActionMask:
+			masked = append(masked, spans...)
+		}
+	}
+	res.Text = mask(s, masked)
+	return res
+}
+
+// Returns the byte ranges of s the rule matches, only the first one when first is set
+func (c *compiledRule) find(s string, first bool) [][2]int {
+	var spans [][2]int
+	if c.Kind == KindRegex {
+		n := -1
+		if first {
+			n = 1
+		}
+		for _, loc := range c.re.FindAllStringIndex(s, n) {
+			spans = append(spans, [2]int{loc[0], loc[1]})
+		}
+		return spans
+	}
+	for start, end := range words(s) {
+		word := s[start:end]
+		if (c.word != "" && strings.EqualFold(word, c.word)) || (c.re != nil && c.re.MatchString(word)) {
+			spans = append(spans, [2]int{start, end})
+			if first {
+				break
+			}
+		}
+	}
+	return spans
+}
+
+// Iterates over the start and end of every run of letters and digits in s
+func words(s string) iter.Seq2[int, int] {
+	return func(yield func(int, int) bool) {
+		start := -1
+		for i, r := range s {
+			switch {
+			case isWordRune(r) && start < 0:
+				start = i
+			case !isWordRune(r) && start >= 0:
+				if !yield(start, i) {
+					return
+				}
+				start = -1
+			}
+		}
+		if start >= 0 {
+			yield(start, len(s))
+		}
+	}
+}
+
+// Replaces the byte ranges of s with Replacement, overlapping ones as one
+func mask(s string, spans [][2]int) string {
+	if len(spans) == 0 {
+		return s
+	}
+	slices.SortFunc(spans, func(a, b [2]int) int { return cmp.Compare(a[0], b[0]) })
+	var out strings.Builder
+	out.Grow(len(s))
+	copied := 0
+	for _, span := range spans {
+		if span[1] <= copied {
+			continue
+		}
+		if span[0] >= copied {
+			out.WriteString(s[copied:span[0]])
+			out.WriteString(Replacement)
+		}
+		copied = span[1]
+	}
+	out.WriteString(s[copied:])
+	return out.String()
+}
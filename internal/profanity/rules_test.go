@@ -0,0 +1,80 @@
+package profanity
+
+import (
+	"testing"
+)
+
+func TestFilter_Apply(t *testing.T) {
+	f, err := NewFilter([]Rule{
+		{ID: "1", Pattern: "Kerfuffle", Kind: KindWord, Action: ActionMask},
+		{ID: "2", Pattern: "sharb*", Kind: KindWildcard, Action: ActionMask},
+		{ID: "3", Pattern: `free\s+money`, Kind: KindRegex, Action: ActionMask},
+		{ID: "4", Pattern: "f?rnax", Kind: KindWildcard, Action: ActionHold},
+		{ID: "5", Pattern: `buy (now|today)`, Kind: KindRegex, Action: ActionReject},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		in, want string
+		held     int
+		rejected string
+	}{
+		{"what a KERFUFFLE!", "what a ****!", 0, ""},
+		{"sharbert and sharbs, but not asharb", "**** and ****, but not asharb", 0, ""},
+		{"get FREE  money here", "get **** here", 0, ""},
+		{"kerfuffles stay", "kerfuffles stay", 0, ""},
+		{"a fornax and a firnax", "a fornax and a firnax", 1, ""},
+		{"buy now, kerfuffle", "buy now, ****", 0, "5"},
+		{"", "", 0, ""},
+	}
+	for _, c := range cases {
+		res := f.Apply(c.in)
+		if res.Text != c.want || len(res.Held) != c.held {
+			t.Errorf("Apply(%q) = %q with %d held, want %q with %d", c.in, res.Text, len(res.Held), c.want, c.held)
+		}
+		if (res.Rejected == nil) != (c.rejected == "") || (res.Rejected != nil && res.Rejected.ID != c.rejected) {
+			t.Errorf("Apply(%q) rejected by %v, want %q", c.in, res.Rejected, c.rejected)
+		}
+	}
+}
+
+func TestFilter_OverlappingMasks(t *testing.T) {
+	f, err := NewFilter([]Rule{
+		{Pattern: "kerfuffle", Kind: KindWord, Action: ActionMask},
+		{Pattern: "a kerf", Kind: KindRegex, Action: ActionMask},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Apply("what a kerfuffle").Text; got != "what ****" {
+		t.Fatalf("Expected overlapping matches masked once, got %q", got)
+	}
+}
+
+func TestFilter_NilHasNoRules(t *testing.T) {
+	var f *Filter
+	if res := f.Apply("kerfuffle"); res.Text != "kerfuffle" || res.Rejected != nil || res.Held != nil {
+		t.Fatalf("Expected a nil filter to change nothing, got %+v", res)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	invalid := []Rule{
+		{Pattern: "two words", Kind: KindWord, Action: ActionMask},
+		{Pattern: "kerf-*", Kind: KindWildcard, Action: ActionMask},
+		{Pattern: "(unclosed", Kind: KindRegex, Action: ActionMask},
+		{Pattern: "a*", Kind: KindRegex, Action: ActionMask},
+		{Pattern: " ", Kind: KindWord, Action: ActionMask},
+		{Pattern: "kerfuffle", Kind: "glob", Action: ActionMask},
+		{Pattern: "kerfuffle", Kind: KindWord, Action: "ban"},
+	}
+	for _, rule := range invalid {
+		if err := Compile(rule); err == nil {
+			t.Errorf("Expected %+v to be refused", rule)
+		}
+	}
+	if err := Compile(Rule{Pattern: "ünï*", Kind: KindWildcard, Action: ActionHold}); err != nil {
+		t.Errorf("Expected a unicode wildcard to compile, got %v", err)
+	}
+}
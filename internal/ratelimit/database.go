@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+)
+
+// Store is the persistence DatabaseLimiter needs; store.Store satisfies it
+type Store interface {
+	TakeRateLimitToken(ctx context.Context, arg database.TakeRateLimitTokenParams) (int64, error)
+	GetRateLimit(ctx context.Context, bucket string) (int64, error)
+}
+
+// DatabaseLimiter shares GCRA state between every instance on the same database,
+// for deployments that run several replicas without Redis. Each check is a single
+// conditional upsert, so it is atomic without locking. Time comes from the local
+// clock, so instances need synchronized clocks.
+type DatabaseLimiter struct {
+	store    Store
+	emission time.Duration
+	burst    int
+	now      func() time.Time
+}
+
+func NewDatabaseLimiter(store Store, rate float64, burst int) *DatabaseLimiter {
+	return &DatabaseLimiter{
+		store:    store,
+		emission: emissionInterval(rate),
+		burst:    burst,
+		now:      time.Now,
+	}
+}
+
+func (l *DatabaseLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	now := l.now().UnixMicro()
+	emission := l.emission.Microseconds()
+	window := int64(l.burst) * emission
+	tat, err := l.store.TakeRateLimitToken(ctx, database.TakeRateLimitTokenParams{
+		Bucket:   key,
+		Now:      now,
+		Emission: emission,
+		Window:   window,
+	})
+	if err == nil {
+		return Result{Allowed: true, Remaining: int((now - (tat - window)) / emission)}, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return Result{}, err
+	}
+	// rejected, read the state back to tell the caller how long to wait
+	tat, err = l.store.GetRateLimit(ctx, key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// pruned in between, so the next attempt will go through
+		return Result{Allowed: false, RetryAfter: l.emission}, nil
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	allowAt := tat + emission - window
+	return Result{Allowed: false, RetryAfter: time.Duration(max(allowAt-now, 0)) * time.Microsecond}, nil
+}
@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+)
+
+func TestDatabaseLimiter_Burst(t *testing.T) {
+	limiter := NewDatabaseLimiter(store.NewMemory(), 1, 3)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		res, err := limiter.Allow(context.Background(), "client")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !res.Allowed {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+		if res.Remaining != 2-i {
+			t.Fatalf("Expected %d remaining, got %d", 2-i, res.Remaining)
+		}
+	}
+	res, err := limiter.Allow(context.Background(), "client")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if res.Allowed {
+		t.Fatal("Expected request beyond burst to be rejected")
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > time.Second {
+		t.Fatalf("Expected retry after within one second, got %v", res.RetryAfter)
+	}
+}
+
+func TestDatabaseLimiter_SharedBetweenInstances(t *testing.T) {
+	shared := store.NewMemory()
+	now := time.Now()
+	first := NewDatabaseLimiter(shared, 1, 1)
+	first.now = func() time.Time { return now }
+	second := NewDatabaseLimiter(shared, 1, 1)
+	second.now = func() time.Time { return now }
+
+	res, _ := first.Allow(context.Background(), "client")
+	if !res.Allowed {
+		t.Fatal("Expected first request to be allowed")
+	}
+	res, _ = second.Allow(context.Background(), "client")
+	if res.Allowed {
+		t.Fatal("Expected the other instance to see the used burst")
+	}
+	now = now.Add(time.Second)
+	res, _ = second.Allow(context.Background(), "client")
+	if !res.Allowed {
+		t.Fatal("Expected request to be allowed after refill")
+	}
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryLimiter keeps GCRA state in process memory. It is only correct for a
+// single instance; use RedisLimiter or DatabaseLimiter when running several replicas.
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	tats      map[string]time.Time
+	emission  time.Duration
+	burst     int
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryLimiter(rate float64, burst int) *MemoryLimiter {
+	return &MemoryLimiter{
+		tats:     make(map[string]time.Time),
+		emission: emissionInterval(rate),
+		burst:    burst,
+		now:      time.Now,
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	// theoretical arrival time can never lag behind the current time
+	tat, ok := l.tats[key]
+	if !ok || tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(l.emission)
+	allowAt := newTat.Add(-time.Duration(l.burst) * l.emission)
+	if now.Before(allowAt) {
+		return Result{Allowed: false, RetryAfter: allowAt.Sub(now)}, nil
+	}
+	l.tats[key] = newTat
+	return Result{Allowed: true, Remaining: int(now.Sub(allowAt) / l.emission)}, nil
+}
+
+// sweep drops keys whose state has fully decayed so the map does not grow forever
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	for key, tat := range l.tats {
+		if tat.Before(now) {
+			delete(l.tats, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter_Burst(t *testing.T) {
+	limiter := NewMemoryLimiter(1, 3)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		res, err := limiter.Allow(context.Background(), "client")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !res.Allowed {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+	}
+	res, err := limiter.Allow(context.Background(), "client")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if res.Allowed {
+		t.Fatal("Expected request beyond burst to be rejected")
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > time.Second {
+		t.Fatalf("Expected retry after within one second, got %v", res.RetryAfter)
+	}
+}
+
+func TestMemoryLimiter_Refill(t *testing.T) {
+	limiter := NewMemoryLimiter(1, 1)
+	now := time.Now()
+	limiter.now = func() time.Time { return now }
+
+	res, _ := limiter.Allow(context.Background(), "client")
+	if !res.Allowed {
+		t.Fatal("Expected first request to be allowed")
+	}
+	res, _ = limiter.Allow(context.Background(), "client")
+	if res.Allowed {
+		t.Fatal("Expected second request to be rejected")
+	}
+	now = now.Add(time.Second)
+	res, _ = limiter.Allow(context.Background(), "client")
+	if !res.Allowed {
+		t.Fatal("Expected request to be allowed after refill")
+	}
+}
+
+func TestMemoryLimiter_SeparateKeys(t *testing.T) {
+	limiter := NewMemoryLimiter(1, 1)
+
+	res, _ := limiter.Allow(context.Background(), "a")
+	if !res.Allowed {
+		t.Fatal("Expected key a to be allowed")
+	}
+	res, _ = limiter.Allow(context.Background(), "b")
+	if !res.Allowed {
+		t.Fatal("Expected key b to be allowed independently of key a")
+	}
+}
+
+func TestNew_UnknownBackend(t *testing.T) {
+	_, err := New("carrier-pigeon", 1, 1, "", nil)
+	if err == nil {
+		t.Fatal("Expected error for unknown backend")
+	}
+}
@@ -0,0 +1,46 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Result describes the outcome of a single rate limit check
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether the caller identified by key may make another request.
+// Implementations use GCRA so that every backend enforces the same rate and burst.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Result, error)
+}
+
+// New builds the limiter selected by backend ("memory", "redis" or "database").
+// store is only used by the database backend.
+func New(backend string, rate float64, burst int, redisURL string, store Store) (Limiter, error) {
+	if rate <= 0 {
+		return nil, fmt.Errorf("rate limit must be greater than zero")
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	switch backend {
+	case "", "memory":
+		return NewMemoryLimiter(rate, burst), nil
+	case "redis":
+		return NewRedisLimiter(redisURL, rate, burst)
+	case "database":
+		return NewDatabaseLimiter(store, rate, burst), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend: %s", backend)
+	}
+}
+
+// emissionInterval returns the time between requests at a steady rate
+func emissionInterval(rate float64) time.Duration {
+	return time.Duration(float64(time.Second) / rate)
+}
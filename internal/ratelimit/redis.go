@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// gcraScript runs the GCRA check atomically inside Redis. Time comes from the
+// Redis server so that instances with skewed clocks still agree.
+var gcraScript = redis.NewScript(`
+local emission = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local tat = tonumber(redis.call('GET', KEYS[1]))
+if not tat or tat < now then
+	tat = now
+end
+local new_tat = tat + emission
+local allow_at = new_tat - burst * emission
+if now < allow_at then
+	return {0, 0, allow_at - now}
+end
+redis.call('SET', KEYS[1], new_tat, 'PX', math.ceil((new_tat - now) / 1000))
+return {1, math.floor((now - allow_at) / emission), 0}
+`)
+
+// RedisLimiter shares GCRA state between every instance pointed at the same Redis
+type RedisLimiter struct {
+	client   *redis.Client
+	emission time.Duration
+	burst    int
+	prefix   string
+}
+
+func NewRedisLimiter(redisURL string, rate float64, burst int) (*RedisLimiter, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisLimiter{
+		client:   redis.NewClient(opts),
+		emission: emissionInterval(rate),
+		burst:    burst,
+		prefix:   "chirpy:ratelimit:",
+	}, nil
+}
+
+// Close releases the Redis connection pool
+func (l *RedisLimiter) Close() error {
+	return l.client.Close()
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (Result, error) {
+	res, err := gcraScript.Run(ctx, l.client, []string{l.prefix + key}, l.emission.Microseconds(), l.burst).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:    res[0] == 1,
+		Remaining:  int(res[1]),
+		RetryAfter: time.Duration(res[2]) * time.Microsecond,
+	}, nil
+}
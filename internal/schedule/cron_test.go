@@ -0,0 +1,54 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse_Next(t *testing.T) {
+	from := time.Date(2024, time.February, 28, 22, 17, 30, 0, time.UTC) // a Wednesday
+	cases := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.February, 28, 22, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.February, 28, 22, 30, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.February, 28, 23, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2024, time.February, 29, 3, 30, 0, 0, time.UTC)},
+		{"0 9 * * 1-5", time.Date(2024, time.February, 29, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.March, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		// day of month and day of week restricted together match either one
+		{"0 0 13 * 5", time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"@every 10m", time.Date(2024, time.February, 28, 22, 20, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		schedule, err := Parse(c.spec)
+		if err != nil {
+			t.Fatalf("Expected %q to parse, got %v", c.spec, err)
+		}
+		if got := schedule.Next(from); !got.Equal(c.want) {
+			t.Errorf("Expected %q to run next at %s, got %s", c.spec, c.want, got)
+		}
+	}
+}
+
+func TestParse_ImpossibleDateNeverRuns(t *testing.T) {
+	schedule, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatalf("Expected February 30th to parse, got %v", err)
+	}
+	if got := schedule.Next(time.Now()); !got.IsZero() {
+		t.Fatalf("Expected no next run, got %s", got)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@every soon", "@every 10ms"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
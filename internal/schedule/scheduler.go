@@ -0,0 +1,209 @@
+// Package schedule runs recurring maintenance tasks on cron-style schedules. Every
+// instance of the server runs the scheduler, and each run is claimed by inserting
+// its (task, slot) row into scheduled_runs first, so a slot runs on exactly one
+// instance and the table doubles as the run history.
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+)
+
+// Store is the persistence the scheduler needs; store.Store satisfies it
+type Store interface {
+	ClaimScheduledRun(ctx context.Context, arg database.ClaimScheduledRunParams) (database.ScheduledRun, error)
+	FinishScheduledRun(ctx context.Context, arg database.FinishScheduledRunParams) error
+}
+
+// Func is the work done by a task
+type Func func(ctx context.Context) error
+
+// TaskInfo describes a registered task for the admin API
+type TaskInfo struct {
+	Name     string
+	Schedule string
+	Next     time.Time
+}
+
+type task struct {
+	name     string
+	spec     string
+	schedule Schedule
+	run      Func
+}
+
+// Scheduler runs registered tasks when their schedule comes due
+type Scheduler struct {
+	store    Store
+	instance string
+	timeout  time.Duration
+	now      func() time.Time
+	mu       sync.RWMutex
+	tasks    []*task
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// New builds a Scheduler. instance names this process in the run history, and
+// timeout bounds how long a single run may take.
+func New(store Store, instance string, timeout time.Duration) *Scheduler {
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	return &Scheduler{
+		store:    store,
+		instance: instance,
+		timeout:  timeout,
+		now:      func() time.Time { return time.Now().UTC() },
+	}
+}
+
+// Add registers a task under name with a schedule understood by Parse. Add every
+// task before Start.
+func (s *Scheduler) Add(name, spec string, run Func) error {
+	schedule, err := Parse(spec)
+	if err != nil {
+		return fmt.Errorf("schedule for %s: %w", name, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tasks {
+		if t.name == name {
+			return fmt.Errorf("task %s is already registered", name)
+		}
+	}
+	s.tasks = append(s.tasks, &task{name: name, spec: spec, schedule: schedule, run: run})
+	return nil
+}
+
+// Tasks lists the registered tasks with their next run time, sorted by name
+func (s *Scheduler) Tasks() []TaskInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := s.now()
+	infos := make([]TaskInfo, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		infos = append(infos, TaskInfo{Name: t.name, Schedule: t.spec, Next: t.schedule.Next(now)})
+	}
+	slices.SortFunc(infos, func(a, b TaskInfo) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// Start runs every task in its own goroutine until Stop is called or ctx is done
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go s.loop(ctx, t)
+	}
+}
+
+// Stop stops scheduling new runs and waits for running ones to finish, or until
+// ctx is done
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, t *task) {
+	defer s.wg.Done()
+	for {
+		slot := t.schedule.Next(s.now())
+		if slot.IsZero() {
+			log.Printf("Scheduled task %s will never run again", t.name)
+			return
+		}
+		timer := time.NewTimer(slot.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := s.run(ctx, t, slot); err != nil && ctx.Err() == nil {
+			log.Printf("Error running scheduled task %s: %s", t.name, err.Error())
+		}
+	}
+}
+
+// RunSlot runs the named task for the given slot unless some instance already has,
+// reporting whether this call ran it. The task's own failure is recorded in the run
+// history and returned as the error.
+func (s *Scheduler) RunSlot(ctx context.Context, name string, slot time.Time) (bool, error) {
+	s.mu.RLock()
+	idx := slices.IndexFunc(s.tasks, func(t *task) bool { return t.name == name })
+	s.mu.RUnlock()
+	if idx < 0 {
+		return false, fmt.Errorf("no task named %s", name)
+	}
+	return s.run(ctx, s.tasks[idx], slot)
+}
+
+func (s *Scheduler) run(ctx context.Context, t *task, slot time.Time) (bool, error) {
+	slot = slot.UTC()
+	_, err := s.store.ClaimScheduledRun(ctx, database.ClaimScheduledRunParams{
+		Task:         t.name,
+		ScheduledFor: slot,
+		Instance:     s.instance,
+		StartedAt:    s.now(),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("claiming run: %w", err)
+	}
+	// a run that was started keeps going through shutdown so it is not half done
+	ctx = context.WithoutCancel(ctx)
+	runErr := s.call(ctx, t)
+	finish := database.FinishScheduledRunParams{
+		Task:         t.name,
+		ScheduledFor: slot,
+		FinishedAt:   sql.NullTime{Time: s.now(), Valid: true},
+		Status:       "succeeded",
+	}
+	if runErr != nil {
+		finish.Status = "failed"
+		finish.Error = runErr.Error()
+	}
+	if err := s.store.FinishScheduledRun(ctx, finish); err != nil {
+		return true, errors.Join(runErr, fmt.Errorf("recording run: %w", err))
+	}
+	return true, runErr
+}
+
+// call runs a task with the run timeout, turning panics into errors
+func (s *Scheduler) call(ctx context.Context, t *task) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("task panicked: %v", p)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return t.run(ctx)
+}
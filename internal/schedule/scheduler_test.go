@@ -0,0 +1,106 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+)
+
+func TestScheduler_SlotRunsOnce(t *testing.T) {
+	s := store.NewMemory()
+	first := New(s, "a", 0)
+	second := New(s, "b", 0)
+	calls := 0
+	for _, sched := range []*Scheduler{first, second} {
+		if err := sched.Add("count", "@hourly", func(ctx context.Context) error {
+			calls++
+			return nil
+		}); err != nil {
+			t.Fatalf("Expected no error adding task, got %v", err)
+		}
+	}
+	ctx := context.Background()
+	slot := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	ran, err := first.RunSlot(ctx, "count", slot)
+	if !ran || err != nil {
+		t.Fatalf("Expected the first instance to run the slot, got ran=%v err=%v", ran, err)
+	}
+	ran, err = second.RunSlot(ctx, "count", slot)
+	if ran || err != nil {
+		t.Fatalf("Expected the second instance to skip the slot, got ran=%v err=%v", ran, err)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected 1 call, got %d", calls)
+	}
+
+	runs, _ := s.ListScheduledRuns(ctx, database.ListScheduledRunsParams{Task: "count", MaxRuns: 10})
+	if len(runs) != 1 || runs[0].Status != "succeeded" || runs[0].Instance != "a" || !runs[0].FinishedAt.Valid {
+		t.Fatalf("Expected one succeeded run on instance a, got %+v", runs)
+	}
+}
+
+func TestScheduler_RecordsFailures(t *testing.T) {
+	s := store.NewMemory()
+	sched := New(s, "a", 0)
+	sched.Add("broken", "@daily", func(ctx context.Context) error {
+		return errors.New("disk full")
+	})
+	sched.Add("panics", "@daily", func(ctx context.Context) error {
+		panic("oops")
+	})
+	ctx := context.Background()
+	slot := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	if _, err := sched.RunSlot(ctx, "broken", slot); err == nil {
+		t.Fatalf("Expected the task error to be returned")
+	}
+	if _, err := sched.RunSlot(ctx, "panics", slot); err == nil {
+		t.Fatalf("Expected the panic to be returned as an error")
+	}
+	runs, _ := s.ListScheduledRuns(ctx, database.ListScheduledRunsParams{MaxRuns: 10})
+	if len(runs) != 2 {
+		t.Fatalf("Expected 2 runs, got %d", len(runs))
+	}
+	for _, run := range runs {
+		if run.Status != "failed" || run.Error == "" {
+			t.Errorf("Expected %s to be recorded as failed, got %+v", run.Task, run)
+		}
+	}
+}
+
+func TestScheduler_AddRejectsBadInput(t *testing.T) {
+	sched := New(store.NewMemory(), "a", 0)
+	if err := sched.Add("bad", "every day", func(ctx context.Context) error { return nil }); err == nil {
+		t.Fatalf("Expected an invalid schedule to be rejected")
+	}
+	sched.Add("dup", "@daily", func(ctx context.Context) error { return nil })
+	if err := sched.Add("dup", "@hourly", func(ctx context.Context) error { return nil }); err == nil {
+		t.Fatalf("Expected a duplicate task name to be rejected")
+	}
+	if _, err := sched.RunSlot(context.Background(), "missing", time.Now()); err == nil {
+		t.Fatalf("Expected an unknown task to be an error")
+	}
+}
+
+func TestScheduler_StartRunsDueTasks(t *testing.T) {
+	s := store.NewMemory()
+	sched := New(s, "a", 0)
+	done := make(chan struct{}, 1)
+	sched.Add("tick", "@every 1s", func(ctx context.Context) error {
+		select {
+		case done <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	sched.Start(context.Background())
+	defer sched.Stop(context.Background())
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Expected the task to run within 3 seconds")
+	}
+}
@@ -0,0 +1,53 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+)
+
+// AWS reads secrets from AWS Secrets Manager. Paths are secret names or ARNs. Secrets
+// stored as a JSON object, as the console does for key/value pairs, have one field per
+// key; any other string secret is a single value.
+type AWS struct {
+	Client *secretsmanager.Client
+}
+
+// NewAWSFromEnv configures AWS Secrets Manager the way the AWS CLI is configured: the
+// AWS_REGION, AWS_PROFILE and AWS_ACCESS_KEY_ID family of variables, shared config
+// files, and instance or task roles
+func NewAWSFromEnv(ctx context.Context) (Provider, error) {
+	awsConfig, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &AWS{Client: secretsmanager.NewFromConfig(awsConfig)}, nil
+}
+
+func (a *AWS) Fetch(ctx context.Context, path string) (map[string]string, error) {
+	out, err := a.Client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: aws.String(path)})
+	var notFound *types.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if out.SecretString == nil {
+		return nil, fmt.Errorf("%s is a binary secret, only string secrets are supported", path)
+	}
+	var object map[string]any
+	if json.Unmarshal([]byte(*out.SecretString), &object) != nil {
+		return map[string]string{"": *out.SecretString}, nil
+	}
+	fields := stringFields(object)
+	// the whole document is still there for references without a field
+	fields[""] = *out.SecretString
+	return fields, nil
+}
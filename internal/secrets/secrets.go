@@ -0,0 +1,151 @@
+// Package secrets fetches secrets named by references such as
+// "vault:secret/data/chirpy#jwt_secret" from HashiCorp Vault or AWS Secrets Manager.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Provider fetches secrets from one secret store
+type Provider interface {
+	// Fetch returns the fields of the secret at path. A secret that is a single string
+	// rather than a set of fields is returned under the empty field name.
+	Fetch(ctx context.Context, path string) (map[string]string, error)
+}
+
+// ErrNotFound is returned when a secret or one of its fields does not exist
+var ErrNotFound = errors.New("secret not found")
+
+// Ref is a parsed secret reference, "<scheme>:<path>#<field>". Without a field, Vault
+// secrets use their "value" field and AWS secrets their whole string.
+type Ref struct {
+	Scheme string
+	Path   string
+	Field  string
+}
+
+func (ref Ref) String() string {
+	if ref.Field == "" {
+		return ref.Scheme + ":" + ref.Path
+	}
+	return ref.Scheme + ":" + ref.Path + "#" + ref.Field
+}
+
+// Resolver turns references into secrets with the provider registered for their scheme.
+// Providers are built the first time a reference needs them, so a store that is never
+// used needs no configuration. It is safe for concurrent use.
+type Resolver struct {
+	mu        sync.Mutex
+	builders  map[string]func(context.Context) (Provider, error)
+	providers map[string]Provider
+}
+
+// NewResolver returns a Resolver for "vault:" references, configured by VAULT_ADDR and
+// VAULT_TOKEN, and "aws-sm:" references, configured like the AWS CLI
+func NewResolver() *Resolver {
+	return &Resolver{
+		builders: map[string]func(context.Context) (Provider, error){
+			"vault":  NewVaultFromEnv,
+			"aws-sm": NewAWSFromEnv,
+		},
+		providers: map[string]Provider{},
+	}
+}
+
+// Register makes p serve references with scheme, replacing any provider it had
+func (r *Resolver) Register(scheme string, p Provider) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.builders == nil {
+		r.builders = map[string]func(context.Context) (Provider, error){}
+		r.providers = map[string]Provider{}
+	}
+	r.builders[scheme] = func(context.Context) (Provider, error) { return p, nil }
+	r.providers[scheme] = p
+}
+
+// Parse reports whether value is a reference to a secret for one of the registered
+// schemes, and if so returns it parsed. Anything else is a literal value.
+func (r *Resolver) Parse(value string) (Ref, bool) {
+	scheme, rest, ok := strings.Cut(value, ":")
+	if !ok || rest == "" {
+		return Ref{}, false
+	}
+	r.mu.Lock()
+	_, known := r.builders[scheme]
+	r.mu.Unlock()
+	if !known {
+		return Ref{}, false
+	}
+	path, field, _ := strings.Cut(rest, "#")
+	return Ref{Scheme: scheme, Path: path, Field: field}, true
+}
+
+// Resolve returns the secret value names, or value itself when it is not a reference
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	ref, ok := r.Parse(value)
+	if !ok {
+		return value, nil
+	}
+	return r.Fetch(ctx, ref)
+}
+
+// Fetch returns the secret ref names
+func (r *Resolver) Fetch(ctx context.Context, ref Ref) (string, error) {
+	provider, err := r.provider(ctx, ref.Scheme)
+	if err != nil {
+		return "", err
+	}
+	fields, err := provider.Fetch(ctx, ref.Path)
+	if err != nil {
+		return "", fmt.Errorf("fetching %s: %w", ref, err)
+	}
+	field := ref.Field
+	if field == "" {
+		if _, ok := fields[""]; !ok {
+			field = "value"
+		}
+	}
+	secret, ok := fields[field]
+	if !ok {
+		return "", fmt.Errorf("fetching %s: %w: no field %q", ref, ErrNotFound, field)
+	}
+	return secret, nil
+}
+
+func (r *Resolver) provider(ctx context.Context, scheme string) (Provider, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.providers[scheme]; ok {
+		return p, nil
+	}
+	build, ok := r.builders[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown secret store %q", scheme)
+	}
+	p, err := build(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("configuring %s secrets: %w", scheme, err)
+	}
+	r.providers[scheme] = p
+	return p, nil
+}
+
+// Converts the fields of a JSON object to strings, non-string values to their JSON
+func stringFields(object map[string]any) map[string]string {
+	fields := make(map[string]string, len(object))
+	for key, value := range object {
+		if s, ok := value.(string); ok {
+			fields[key] = s
+		} else {
+			dat, _ := json.Marshal(value)
+			fields[key] = string(dat)
+		}
+	}
+	return fields
+}
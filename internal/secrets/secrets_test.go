@@ -0,0 +1,146 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+type fakeProvider map[string]map[string]string
+
+func (f fakeProvider) Fetch(ctx context.Context, path string) (map[string]string, error) {
+	fields, ok := f[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return fields, nil
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	r := NewResolver()
+	r.Register("vault", fakeProvider{
+		"secret/data/chirpy": {"jwt_secret": "s3cret", "value": "default"},
+	})
+	r.Register("aws-sm", fakeProvider{
+		"prod/db": {"": "postgres://chirpy:pw@db/chirpy"},
+	})
+	cases := []struct {
+		value, want string
+	}{
+		{"vault:secret/data/chirpy#jwt_secret", "s3cret"},
+		{"vault:secret/data/chirpy", "default"},
+		{"aws-sm:prod/db", "postgres://chirpy:pw@db/chirpy"},
+		// literal values, including URLs with other schemes, are returned as they are
+		{"postgres://chirpy:pw@localhost/chirpy", "postgres://chirpy:pw@localhost/chirpy"},
+		{"plain-secret", "plain-secret"},
+		{"", ""},
+	}
+	for _, c := range cases {
+		got, err := r.Resolve(context.Background(), c.value)
+		if err != nil || got != c.want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", c.value, got, err, c.want)
+		}
+	}
+	for _, value := range []string{"vault:secret/data/chirpy#missing", "vault:secret/data/other#x"} {
+		if _, err := r.Resolve(context.Background(), value); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound resolving %q, got %v", value, err)
+		}
+	}
+}
+
+func TestResolver_ProvidersAreBuiltOnFirstUse(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "")
+	r := NewResolver()
+	if _, err := r.Resolve(context.Background(), "not-a-reference"); err != nil {
+		t.Fatalf("Expected literal values to need no provider, got %v", err)
+	}
+	_, err := r.Resolve(context.Background(), "vault:secret/data/chirpy#x")
+	if err == nil || !strings.Contains(err.Error(), "VAULT_ADDR") {
+		t.Fatalf("Expected a configuration error for vault, got %v", err)
+	}
+}
+
+func TestVault_Fetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" || r.Header.Get("X-Vault-Namespace") != "team" {
+			w.WriteHeader(403)
+			io.WriteString(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/chirpy":
+			io.WriteString(w, `{"data":{"data":{"jwt_secret":"s3cret","port":5432},"metadata":{"version":3}}}`)
+		case "/v1/kv/chirpy":
+			io.WriteString(w, `{"data":{"jwt_secret":"v1-secret"}}`)
+		default:
+			w.WriteHeader(404)
+			io.WriteString(w, `{"errors":[]}`)
+		}
+	}))
+	defer srv.Close()
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("vault-token\n"), 0o600)
+	v := &Vault{Address: srv.URL, TokenFile: tokenFile, Namespace: "team"}
+
+	fields, err := v.Fetch(context.Background(), "secret/data/chirpy")
+	if err != nil || fields["jwt_secret"] != "s3cret" || fields["port"] != "5432" {
+		t.Fatalf("Expected the fields of a KV v2 secret, got %v (%v)", fields, err)
+	}
+	fields, err = v.Fetch(context.Background(), "kv/chirpy")
+	if err != nil || fields["jwt_secret"] != "v1-secret" {
+		t.Fatalf("Expected the fields of a KV v1 secret, got %v (%v)", fields, err)
+	}
+	if _, err = v.Fetch(context.Background(), "secret/data/missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	v.TokenFile, v.Token = "", "wrong"
+	if _, err = v.Fetch(context.Background(), "kv/chirpy"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("Expected Vault's error, got %v", err)
+	}
+}
+
+func TestAWS_Fetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch {
+		case r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue":
+			w.WriteHeader(400)
+		case strings.Contains(string(body), `"prod/chirpy"`):
+			io.WriteString(w, `{"Name":"prod/chirpy","SecretString":"{\"jwt_secret\":\"s3cret\"}"}`)
+		case strings.Contains(string(body), `"prod/db"`):
+			io.WriteString(w, `{"Name":"prod/db","SecretString":"postgres://chirpy:pw@db/chirpy"}`)
+		default:
+			w.WriteHeader(400)
+			io.WriteString(w, `{"__type":"ResourceNotFoundException","message":"Secrets Manager can't find the specified secret."}`)
+		}
+	}))
+	defer srv.Close()
+	a := &AWS{Client: secretsmanager.New(secretsmanager.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("key", "secret", ""),
+	})}
+
+	fields, err := a.Fetch(context.Background(), "prod/chirpy")
+	if err != nil || fields["jwt_secret"] != "s3cret" || fields[""] != `{"jwt_secret":"s3cret"}` {
+		t.Fatalf("Expected the keys of a JSON secret, got %v (%v)", fields, err)
+	}
+	fields, err = a.Fetch(context.Background(), "prod/db")
+	if err != nil || len(fields) != 1 || fields[""] != "postgres://chirpy:pw@db/chirpy" {
+		t.Fatalf("Expected a plain string secret as one value, got %v (%v)", fields, err)
+	}
+	if _, err = a.Fetch(context.Background(), "prod/missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
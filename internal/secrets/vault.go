@@ -0,0 +1,96 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Vault reads secrets from the KV secrets engine of a HashiCorp Vault server over its
+// HTTP API. Paths are API paths below /v1/: "secret/data/chirpy" for version 2 of the
+// engine, "kv/chirpy" for version 1.
+type Vault struct {
+	// Address is the server's URL, e.g. "https://vault.example.com:8200"
+	Address string
+	// Token authenticates every request. TokenFile, when set, is read for every request
+	// instead, so a Vault Agent sink can keep renewing the token.
+	Token     string
+	TokenFile string
+	// Namespace is sent as X-Vault-Namespace on Vault Enterprise
+	Namespace string
+	Client    *http.Client
+}
+
+// NewVaultFromEnv configures Vault from VAULT_ADDR, VAULT_TOKEN or VAULT_TOKEN_FILE, and
+// VAULT_NAMESPACE, the variables the vault CLI reads
+func NewVaultFromEnv(context.Context) (Provider, error) {
+	v := &Vault{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		TokenFile: os.Getenv("VAULT_TOKEN_FILE"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	if v.Address == "" {
+		return nil, errors.New("VAULT_ADDR is required")
+	}
+	if v.Token == "" && v.TokenFile == "" {
+		return nil, errors.New("VAULT_TOKEN or VAULT_TOKEN_FILE is required")
+	}
+	return v, nil
+}
+
+func (v *Vault) Fetch(ctx context.Context, path string) (map[string]string, error) {
+	token := v.Token
+	if v.TokenFile != "" {
+		dat, err := os.ReadFile(v.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(dat))
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(v.Address, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	client := v.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	var body struct {
+		Data   map[string]any `json:"data"`
+		Errors []string       `json:"errors"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault answered %s: %s", resp.Status, strings.Join(body.Errors, "; "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error decoding vault response: %w", err)
+	}
+	data := body.Data
+	// version 2 of the KV engine nests the fields next to their metadata
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	return stringFields(data), nil
+}
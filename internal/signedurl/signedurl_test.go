@@ -0,0 +1,75 @@
+package signedurl
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := New("secret")
+	s.now = func() time.Time { return now }
+
+	signed, err := s.Sign("/media/abc.mp4", time.Minute)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	u, _ := url.Parse(signed)
+	if err := s.Verify(u); err != nil {
+		t.Fatalf("Expected a valid signature, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		name, url string
+		want      error
+	}{
+		{"unsigned", "/media/abc.mp4", ErrMissing},
+		{"other path", strings.Replace(signed, "abc", "xyz", 1), ErrInvalid},
+		{"other secret", mustSign(t, New("other"), "/media/abc.mp4"), ErrInvalid},
+		{"extended expiry", strings.Replace(signed, "expires=17", "expires=27", 1), ErrInvalid},
+	} {
+		u, _ := url.Parse(tc.url)
+		if err := s.Verify(u); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: expected %v, got %v", tc.name, tc.want, err)
+		}
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := s.Verify(u); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Expected the URL to expire, got %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	s := New("secret")
+	handler := s.Middleware(func(w http.ResponseWriter, r *http.Request, err error) {
+		http.Error(w, err.Error(), 403)
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("bytes"))
+	}))
+
+	for path, want := range map[string]int{
+		mustSign(t, s, "/media/abc.png"): 200,
+		"/media/abc.png":                 403,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != want {
+			t.Fatalf("Expected %d for %s, got %d", want, path, rec.Code)
+		}
+	}
+}
+
+func mustSign(t *testing.T, s *Signer, path string) string {
+	t.Helper()
+	signed, err := s.Sign(path, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
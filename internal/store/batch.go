@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// Batch collects writes to send to the database together. Results are discarded, so
+// only statements whose effect is all that matters belong in one.
+type Batch struct {
+	queries []batchQuery
+}
+
+type batchQuery struct {
+	sql  string
+	args []any
+}
+
+// Queue adds a statement, usually one of the exported sqlc queries such as
+// database.EnqueueJob with its arguments in the order of the generated method
+func (b *Batch) Queue(query string, args ...any) {
+	b.queries = append(b.queries, batchQuery{sql: query, args: args})
+}
+
+// Len reports how many statements are queued
+func (b *Batch) Len() int {
+	return len(b.queries)
+}
+
+// SendBatch runs the queued statements in order and stops at the first error. On the
+// pgx driver they go to Postgres in one round trip, inside the transaction when called
+// from WithTx; other drivers run them one at a time.
+func (s *SQL) SendBatch(ctx context.Context, b *Batch) error {
+	if b.Len() == 0 {
+		return nil
+	}
+	conn := s.conn
+	if conn == nil {
+		var err error
+		conn, err = s.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+	}
+	pipelined := false
+	err := conn.Raw(func(driverConn any) error {
+		pgxConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return nil
+		}
+		pipelined = true
+		batch := &pgx.Batch{}
+		for _, q := range b.queries {
+			batch.Queue(q.sql, q.args...)
+		}
+		return pgxConn.Conn().SendBatch(ctx, batch).Close()
+	})
+	if err != nil || pipelined {
+		return err
+	}
+	var dbtx database.DBTX = conn
+	if s.tx != nil {
+		dbtx = s.tx
+	}
+	dbtx = s.wrap(dbtx)
+	for _, q := range b.queries {
+		_, err := dbtx.ExecContext(ctx, q.sql, q.args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueJobs inserts several jobs with one SendBatch
+func (s *SQL) EnqueueJobs(ctx context.Context, args []database.EnqueueJobParams) error {
+	batch := &Batch{}
+	for _, arg := range args {
+		batch.Queue(database.EnqueueJob, arg.Kind, arg.Payload, arg.MaxAttempts, arg.RunAt)
+	}
+	return s.SendBatch(ctx, batch)
+}
@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestSQL_SendBatchRunsInOrderAndJoinsTransactions(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:batch?mode=memory&cache=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE items (name TEXT PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	s := NewSQL(db, nil)
+	count := func() int {
+		var n int
+		db.QueryRowContext(ctx, "SELECT COUNT(*) FROM items").Scan(&n)
+		return n
+	}
+
+	batch := &Batch{}
+	batch.Queue("INSERT INTO items (name) VALUES ($1)", "a")
+	batch.Queue("INSERT INTO items (name) VALUES ($1)", "b")
+	if err := s.SendBatch(ctx, batch); err != nil || count() != 2 {
+		t.Fatalf("Expected both rows inserted, got %d rows (%v)", count(), err)
+	}
+
+	errFailed := errors.New("later step failed")
+	err = s.WithTx(ctx, func(tx Store) error {
+		batch := &Batch{}
+		batch.Queue("INSERT INTO items (name) VALUES ($1)", "c")
+		if err := tx.(*SQL).SendBatch(ctx, batch); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) || count() != 2 {
+		t.Fatalf("Expected the batch to roll back with the transaction, got %d rows (%v)", count(), err)
+	}
+
+	batch = &Batch{}
+	batch.Queue("INSERT INTO items (name) VALUES ($1)", "d")
+	batch.Queue("INSERT INTO items (name) VALUES ($1)", "a")
+	batch.Queue("INSERT INTO items (name) VALUES ($1)", "e")
+	if err := s.SendBatch(ctx, batch); err == nil || count() != 3 {
+		t.Fatalf("Expected to stop at the duplicate, got %d rows (%v)", count(), err)
+	}
+}
@@ -0,0 +1,3208 @@
+package store
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"iter"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// UniqueViolation is the Memory store's error for a write that breaks a unique
+// constraint. Constraint is named like the Postgres one.
+type UniqueViolation struct {
+	Constraint string
+}
+
+func (e *UniqueViolation) Error() string {
+	return fmt.Sprintf("duplicate key value violates unique constraint %q", e.Constraint)
+}
+
+// Memory is a Store kept entirely in process memory, for demos and tests. It mirrors
+// the SQL behavior the handlers rely on: sql.ErrNoRows for missing rows, emails unique
+// per tenant (a *UniqueViolation), and cascading deletes from users to their chirps and tokens.
+type Memory struct {
+	txMu          sync.Mutex
+	mu            sync.RWMutex
+	tenants       map[uuid.UUID]database.Tenant
+	users         map[uuid.UUID]database.User
+	chirps        map[uuid.UUID]database.Chirp
+	refreshTokens map[string]database.RefreshToken
+	featureFlags  map[string]database.FeatureFlag
+	visits        map[string]int64
+	auditLog      []database.AuditLog
+	jobs          map[uuid.UUID]database.Job
+	scheduledRuns map[scheduledRunKey]database.ScheduledRun
+	webhooks      map[uuid.UUID]database.Webhook
+	deliveries    []database.WebhookDelivery
+	outbox        []database.OutboxEvent
+	idempotency   map[idempotencyKey]database.IdempotencyKey
+	rateLimits    map[string]int64
+	quotaUsage    map[quotaUsageKey]int64
+	apiKeys       map[uuid.UUID]database.ApiKey
+	apiKeyUsage   map[apiKeyUsageKey]int64
+	reports       []database.ChirpReport
+	decisions     []database.ModerationDecision
+	wordFilters   map[uuid.UUID]database.WordFilter
+	shadowbans    map[uuid.UUID]database.Shadowban
+	ipBans        map[uuid.UUID]database.IpBan
+	automodRules  map[uuid.UUID]database.AutomodRule
+	ruleVersions  []database.AutomodRuleVersion
+	restrictions  map[uuid.UUID]database.AutomodRestriction
+	statsRollups  map[statsRollupKey]database.StatsRollup
+	runtimeState  map[string]database.RuntimeState
+	apKeys        map[uuid.UUID]database.ActivitypubKey
+	apFollowers   map[apFollowerKey]database.ActivitypubFollower
+	favourites    map[favouriteKey]database.ChirpFavourite
+	oauthApps     map[string]database.OauthApp
+	oauthCodes    map[string]database.OauthCode
+	oauthTokens   map[string]database.OauthToken
+	sitemapPages  map[sitemapPageKey]database.SitemapPage
+	importBatches map[importBatchKey]string
+	digests       map[uuid.UUID]database.DigestSubscription
+	now           func() time.Time
+}
+
+func NewMemory() *Memory {
+	now := time.Now().UTC()
+	return &Memory{
+		// the default tenant the migration creates
+		tenants:       map[uuid.UUID]database.Tenant{uuid.Nil: {ID: uuid.Nil, CreatedAt: now, UpdatedAt: now, Slug: "default", Name: "Default"}},
+		users:         make(map[uuid.UUID]database.User),
+		chirps:        make(map[uuid.UUID]database.Chirp),
+		refreshTokens: make(map[string]database.RefreshToken),
+		featureFlags:  make(map[string]database.FeatureFlag),
+		visits:        make(map[string]int64),
+		jobs:          make(map[uuid.UUID]database.Job),
+		scheduledRuns: make(map[scheduledRunKey]database.ScheduledRun),
+		webhooks:      make(map[uuid.UUID]database.Webhook),
+		idempotency:   make(map[idempotencyKey]database.IdempotencyKey),
+		rateLimits:    make(map[string]int64),
+		quotaUsage:    make(map[quotaUsageKey]int64),
+		apiKeys:       make(map[uuid.UUID]database.ApiKey),
+		apiKeyUsage:   make(map[apiKeyUsageKey]int64),
+		wordFilters:   make(map[uuid.UUID]database.WordFilter),
+		shadowbans:    make(map[uuid.UUID]database.Shadowban),
+		ipBans:        make(map[uuid.UUID]database.IpBan),
+		automodRules:  make(map[uuid.UUID]database.AutomodRule),
+		restrictions:  make(map[uuid.UUID]database.AutomodRestriction),
+		statsRollups:  make(map[statsRollupKey]database.StatsRollup),
+		runtimeState:  make(map[string]database.RuntimeState),
+		apKeys:        make(map[uuid.UUID]database.ActivitypubKey),
+		apFollowers:   make(map[apFollowerKey]database.ActivitypubFollower),
+		favourites:    make(map[favouriteKey]database.ChirpFavourite),
+		oauthApps:     make(map[string]database.OauthApp),
+		oauthCodes:    make(map[string]database.OauthCode),
+		oauthTokens:   make(map[string]database.OauthToken),
+		sitemapPages:  make(map[sitemapPageKey]database.SitemapPage),
+		importBatches: make(map[importBatchKey]string),
+		digests:       make(map[uuid.UUID]database.DigestSubscription),
+		now:           func() time.Time { return time.Now().UTC() },
+	}
+}
+
+var _ Store = (*Memory)(nil)
+
+func (m *Memory) CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// like the INSERT ... SELECT, an author outside the tenant inserts nothing
+	if user, ok := m.users[arg.UserID]; !ok || user.TenantID != arg.TenantID {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+	now := m.now()
+	chirp := database.Chirp{ID: uuid.New(), CreatedAt: now, UpdatedAt: now, Body: arg.Body, UserID: arg.UserID, TenantID: arg.TenantID}
+	m.chirps[chirp.ID] = chirp
+	return chirp, nil
+}
+
+func (m *Memory) GetChirps(ctx context.Context, arg database.GetChirpsParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sortedChirps(func(c database.Chirp) bool { return c.TenantID == arg.TenantID && m.visibleTo(c, arg.ViewerID) }), nil
+}
+
+func (m *Memory) GetChirpById(ctx context.Context, arg database.GetChirpByIdParams) (database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chirp, ok := m.chirps[arg.ID]
+	if !ok || chirp.TenantID != arg.TenantID || !m.visibleTo(chirp, arg.ViewerID) {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+	return chirp, nil
+}
+
+func (m *Memory) GetChirpByIdAnyTenant(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chirp, ok := m.chirps[id]
+	if !ok {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+	return chirp, nil
+}
+
+func (m *Memory) UpdateChirpBody(ctx context.Context, arg database.UpdateChirpBodyParams) (database.Chirp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	chirp, ok := m.chirps[arg.ID]
+	if !ok || chirp.TenantID != arg.TenantID {
+		return database.Chirp{}, sql.ErrNoRows
+	}
+	chirp.Body = arg.Body
+	chirp.UpdatedAt = m.now()
+	m.chirps[arg.ID] = chirp
+	return chirp, nil
+}
+
+func (m *Memory) GetChirpsById(ctx context.Context, arg database.GetChirpsByIdParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sortedChirps(func(c database.Chirp) bool {
+		return c.UserID == arg.UserID && c.TenantID == arg.TenantID && m.visibleTo(c, arg.ViewerID)
+	}), nil
+}
+
+func (m *Memory) GetChirpsByUserIds(ctx context.Context, arg database.GetChirpsByUserIdsParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	userIDs := parseIDList(arg.Ids)
+	return m.sortedChirps(func(c database.Chirp) bool {
+		return userIDs[c.UserID] && c.TenantID == arg.TenantID && m.visibleTo(c, arg.ViewerID)
+	}), nil
+}
+
+func (m *Memory) ImportChirp(ctx context.Context, arg database.ImportChirpParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[arg.UserID]
+	if !ok || user.TenantID != arg.TenantID {
+		return 0, nil
+	}
+	if _, ok := m.chirps[arg.ID]; ok {
+		return 0, nil
+	}
+	m.chirps[arg.ID] = database.Chirp{ID: arg.ID, CreatedAt: arg.CreatedAt, UpdatedAt: m.now(), Body: arg.Body, UserID: arg.UserID, TenantID: arg.TenantID}
+	return 1, nil
+}
+
+func (m *Memory) DeleteChirpById(ctx context.Context, arg database.DeleteChirpByIdParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if chirp, ok := m.chirps[arg.ID]; ok && chirp.UserID == arg.UserID && chirp.TenantID == arg.TenantID {
+		delete(m.chirps, arg.ID)
+	}
+	return nil
+}
+
+func (m *Memory) CountChirpsMatching(ctx context.Context, arg database.CountChirpsMatchingParams) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	match := chirpFilter(arg.CreatedAfter, arg.CreatedBefore, arg.AllUsers, arg.UserID, arg.AllTenants, arg.TenantID)
+	return int64(len(m.sortedChirps(match))), nil
+}
+
+func (m *Memory) DeleteChirpsMatching(ctx context.Context, arg database.DeleteChirpsMatchingParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	match := chirpFilter(arg.CreatedAfter, arg.CreatedBefore, arg.AllUsers, arg.UserID, arg.AllTenants, arg.TenantID)
+	chirps := m.sortedChirps(match)
+	chirps = chirps[:min(len(chirps), max(int(arg.RowLimit), 0))]
+	for _, chirp := range chirps {
+		delete(m.chirps, chirp.ID)
+	}
+	return int64(len(chirps)), nil
+}
+
+func (m *Memory) ListChirpsAfter(ctx context.Context, arg database.ListChirpsAfterParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return pageAfter(m.chirps, arg.ID, arg.Limit), nil
+}
+
+func (m *Memory) RestoreChirp(ctx context.Context, arg database.RestoreChirpParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.chirps[arg.ID]; ok {
+		return &UniqueViolation{Constraint: "chirps_pkey"}
+	}
+	m.chirps[arg.ID] = database.Chirp(arg)
+	return nil
+}
+
+func (m *Memory) ListChirpsPage(ctx context.Context, arg database.ListChirpsPageParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chirps := m.sortedChirps(m.chirpsPageFilter(arg.TenantID, arg.AllAuthors, arg.AuthorID, arg.ViewerID))
+	return offsetPage(chirps, arg.RowOffset, arg.RowLimit), nil
+}
+
+func (m *Memory) ListChirpsPageDesc(ctx context.Context, arg database.ListChirpsPageDescParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chirps := m.sortedChirps(m.chirpsPageFilter(arg.TenantID, arg.AllAuthors, arg.AuthorID, arg.ViewerID))
+	slices.Reverse(chirps)
+	return offsetPage(chirps, arg.RowOffset, arg.RowLimit), nil
+}
+
+func (m *Memory) CountChirpsPage(ctx context.Context, arg database.CountChirpsPageParams) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(maps.Values(m.chirps), m.chirpsPageFilter(arg.TenantID, arg.AllAuthors, arg.AuthorID, arg.ViewerID)), nil
+}
+
+// chirpsPageFilter matches what the WHERE clause of ListChirpsPage and CountChirpsPage
+// does. Callers must hold the lock.
+func (m *Memory) chirpsPageFilter(tenantID uuid.UUID, allAuthors bool, authorID, viewerID uuid.UUID) func(database.Chirp) bool {
+	return func(c database.Chirp) bool {
+		return c.TenantID == tenantID && (allAuthors || c.UserID == authorID) && m.visibleTo(c, viewerID)
+	}
+}
+
+func (m *Memory) SearchChirps(ctx context.Context, arg database.SearchChirpsParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	like := likeMatcher(arg.Pattern)
+	return searchNewest(maps.Values(m.chirps), arg.MaxResults, func(c database.Chirp) bool {
+		return like(strings.ToLower(c.Body)) || c.ID.String() == arg.ExactID
+	}, func(c database.Chirp) time.Time { return c.CreatedAt }), nil
+}
+
+// chirpFilter matches what the WHERE clause of CountChirpsMatching and DeleteChirpsMatching does
+func chirpFilter(after, before time.Time, allUsers bool, userID uuid.UUID, allTenants bool, tenantID uuid.UUID) func(database.Chirp) bool {
+	return func(c database.Chirp) bool {
+		return !c.CreatedAt.Before(after) && c.CreatedAt.Before(before) &&
+			(allUsers || c.UserID == userID) && (allTenants || c.TenantID == tenantID)
+	}
+}
+
+func (m *Memory) CountUsersByTenant(ctx context.Context, tenantID uuid.UUID) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var count int64
+	for _, user := range m.users {
+		if user.TenantID == tenantID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Memory) CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tenants[arg.TenantID]; !ok {
+		return database.User{}, fmt.Errorf("tenant %s does not exist", arg.TenantID)
+	}
+	if m.emailTaken(arg.TenantID, arg.Email, uuid.Nil) {
+		return database.User{}, &UniqueViolation{Constraint: "users_tenant_id_email_idx"}
+	}
+	now := m.now()
+	user := database.User{ID: uuid.New(), CreatedAt: now, UpdatedAt: now, Email: arg.Email, HashedPassword: arg.HashedPassword, TenantID: arg.TenantID}
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *Memory) DeleteUsers(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.users)
+	clear(m.chirps)
+	clear(m.refreshTokens)
+	clear(m.webhooks)
+	clear(m.quotaUsage)
+	clear(m.apiKeys)
+	clear(m.apiKeyUsage)
+	clear(m.shadowbans)
+	clear(m.restrictions)
+	clear(m.apKeys)
+	clear(m.apFollowers)
+	clear(m.favourites)
+	clear(m.oauthCodes)
+	clear(m.oauthTokens)
+	clear(m.digests)
+	m.deliveries, m.reports, m.decisions = nil, nil, nil
+	return nil
+}
+
+func (m *Memory) GetUserByEmail(ctx context.Context, arg database.GetUserByEmailParams) (database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, user := range m.users {
+		if user.Email == arg.Email && user.TenantID == arg.TenantID {
+			return user, nil
+		}
+	}
+	return database.User{}, sql.ErrNoRows
+}
+
+func (m *Memory) GetUsersByIds(ctx context.Context, arg database.GetUsersByIdsParams) ([]database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var users []database.User
+	for id := range parseIDList(arg.Ids) {
+		if user, ok := m.users[id]; ok && user.TenantID == arg.TenantID {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (m *Memory) ListUsersAfter(ctx context.Context, arg database.ListUsersAfterParams) ([]database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return pageAfter(m.users, arg.ID, arg.Limit), nil
+}
+
+func (m *Memory) PutNewUserData(ctx context.Context, arg database.PutNewUserDataParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[arg.ID]
+	if !ok || user.TenantID != arg.TenantID {
+		return database.User{}, sql.ErrNoRows
+	}
+	if m.emailTaken(arg.TenantID, arg.Email, arg.ID) {
+		return database.User{}, &UniqueViolation{Constraint: "users_tenant_id_email_idx"}
+	}
+	user.Email = arg.Email
+	user.HashedPassword = arg.HashedPassword
+	user.UpdatedAt = m.now()
+	m.users[user.ID] = user
+	return user, nil
+}
+
+func (m *Memory) RestoreUser(ctx context.Context, arg database.RestoreUserParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.tenants[arg.TenantID]; !ok {
+		return fmt.Errorf("tenant %s does not exist", arg.TenantID)
+	}
+	if _, ok := m.users[arg.ID]; ok {
+		return &UniqueViolation{Constraint: "users_pkey"}
+	}
+	if m.emailTaken(arg.TenantID, arg.Email, uuid.Nil) {
+		return &UniqueViolation{Constraint: "users_tenant_id_email_idx"}
+	}
+	m.users[arg.ID] = database.User(arg)
+	return nil
+}
+
+func (m *Memory) SearchUsers(ctx context.Context, arg database.SearchUsersParams) ([]database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	like := likeMatcher(arg.Pattern)
+	return searchNewest(maps.Values(m.users), arg.MaxResults, func(u database.User) bool {
+		return like(strings.ToLower(u.Email)) || u.ID.String() == arg.ExactID
+	}, func(u database.User) time.Time { return u.CreatedAt }), nil
+}
+
+func (m *Memory) UpgradeUserById(ctx context.Context, arg database.UpgradeUserByIdParams) (database.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[arg.ID]
+	if !ok || user.TenantID != arg.TenantID {
+		return database.User{}, sql.ErrNoRows
+	}
+	user.IsChirpyRed = true
+	user.UpdatedAt = m.now()
+	m.users[arg.ID] = user
+	return user, nil
+}
+
+func (m *Memory) CreateTenant(ctx context.Context, arg database.CreateTenantParams) (database.Tenant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, tenant := range m.tenants {
+		if tenant.Slug == arg.Slug {
+			return database.Tenant{}, &UniqueViolation{Constraint: "tenants_slug_key"}
+		}
+	}
+	now := m.now()
+	tenant := database.Tenant{ID: uuid.New(), CreatedAt: now, UpdatedAt: now, Slug: arg.Slug, Name: arg.Name, AdminTokenHash: arg.AdminTokenHash, MaxUsers: arg.MaxUsers}
+	m.tenants[tenant.ID] = tenant
+	return tenant, nil
+}
+
+func (m *Memory) GetTenantBySlug(ctx context.Context, slug string) (database.Tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, tenant := range m.tenants {
+		if tenant.Slug == slug {
+			return tenant, nil
+		}
+	}
+	return database.Tenant{}, sql.ErrNoRows
+}
+
+func (m *Memory) ListTenants(ctx context.Context) ([]database.Tenant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tenants := slices.Collect(maps.Values(m.tenants))
+	slices.SortFunc(tenants, func(a, b database.Tenant) int { return strings.Compare(a.Slug, b.Slug) })
+	return tenants, nil
+}
+
+func (m *Memory) RestoreTenant(ctx context.Context, arg database.RestoreTenantParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, tenant := range m.tenants {
+		if tenant.Slug == arg.Slug && id != arg.ID {
+			return &UniqueViolation{Constraint: "tenants_slug_key"}
+		}
+	}
+	m.tenants[arg.ID] = database.Tenant(arg)
+	return nil
+}
+
+func (m *Memory) SetTenantAdminToken(ctx context.Context, arg database.SetTenantAdminTokenParams) (database.Tenant, error) {
+	return m.updateTenant(arg.Slug, func(tenant *database.Tenant) { tenant.AdminTokenHash = arg.AdminTokenHash })
+}
+
+func (m *Memory) UpdateTenant(ctx context.Context, arg database.UpdateTenantParams) (database.Tenant, error) {
+	return m.updateTenant(arg.Slug, func(tenant *database.Tenant) {
+		tenant.Name = arg.Name
+		tenant.MaxUsers = arg.MaxUsers
+	})
+}
+
+// updateTenant applies change to the tenant with slug, or returns sql.ErrNoRows
+func (m *Memory) updateTenant(slug string, change func(*database.Tenant)) (database.Tenant, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, tenant := range m.tenants {
+		if tenant.Slug == slug {
+			change(&tenant)
+			tenant.UpdatedAt = m.now()
+			m.tenants[id] = tenant
+			return tenant, nil
+		}
+	}
+	return database.Tenant{}, sql.ErrNoRows
+}
+
+func (m *Memory) CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.RefreshToken{}, fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.refreshTokens[arg.Token]; ok {
+		return database.RefreshToken{}, &UniqueViolation{Constraint: "refresh_tokens_pkey"}
+	}
+	now := m.now()
+	token := database.RefreshToken{Token: arg.Token, CreatedAt: now, UpdatedAt: now, UserID: arg.UserID, ExpiresAt: arg.ExpiresAt}
+	m.refreshTokens[arg.Token] = token
+	return token, nil
+}
+
+func (m *Memory) GetRefreshToken(ctx context.Context, token string) (database.RefreshToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rt, ok := m.refreshTokens[token]
+	if !ok || !rt.ExpiresAt.After(m.now()) || rt.RevokedAt.Valid {
+		return database.RefreshToken{}, sql.ErrNoRows
+	}
+	return rt, nil
+}
+
+func (m *Memory) GetUserFromRefreshToken(ctx context.Context, token string) (database.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rt, ok := m.refreshTokens[token]
+	if !ok || !rt.ExpiresAt.After(m.now()) {
+		return database.User{}, sql.ErrNoRows
+	}
+	user, ok := m.users[rt.UserID]
+	if !ok {
+		return database.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+func (m *Memory) RevokeRefreshToken(ctx context.Context, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rt, ok := m.refreshTokens[token]; ok {
+		now := m.now()
+		rt.RevokedAt = sql.NullTime{Time: now, Valid: true}
+		rt.UpdatedAt = now
+		m.refreshTokens[token] = rt
+	}
+	return nil
+}
+
+func (m *Memory) RevokeRefreshTokensForUser(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for token, rt := range m.refreshTokens {
+		if rt.UserID == userID {
+			rt.RevokedAt = sql.NullTime{Time: now, Valid: true}
+			m.refreshTokens[token] = rt
+		}
+	}
+	return nil
+}
+
+func (m *Memory) CountStaleRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(maps.Values(m.refreshTokens), func(rt database.RefreshToken) bool { return staleRefreshToken(rt, before) }), nil
+}
+
+func (m *Memory) DeleteStaleRefreshTokens(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for token, rt := range m.refreshTokens {
+		if staleRefreshToken(rt, before) {
+			delete(m.refreshTokens, token)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// staleRefreshToken matches what DeleteStaleRefreshTokens removes
+func staleRefreshToken(rt database.RefreshToken, before time.Time) bool {
+	return rt.ExpiresAt.Before(before) || (rt.RevokedAt.Valid && rt.RevokedAt.Time.Before(before))
+}
+
+func (m *Memory) UpsertFeatureFlag(ctx context.Context, arg database.UpsertFeatureFlagParams) (database.FeatureFlag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if arg.RolloutPercentage < 0 || arg.RolloutPercentage > 100 {
+		return database.FeatureFlag{}, fmt.Errorf("rollout percentage %d is out of range", arg.RolloutPercentage)
+	}
+	now := m.now()
+	flag, ok := m.featureFlags[arg.Name]
+	if !ok {
+		flag = database.FeatureFlag{Name: arg.Name, CreatedAt: now}
+	}
+	flag.UpdatedAt = now
+	flag.Description = arg.Description
+	flag.Enabled = arg.Enabled
+	flag.RolloutPercentage = arg.RolloutPercentage
+	flag.Environments = arg.Environments
+	m.featureFlags[arg.Name] = flag
+	return flag, nil
+}
+
+func (m *Memory) GetFeatureFlags(ctx context.Context) ([]database.FeatureFlag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.SortedFunc(maps.Values(m.featureFlags), func(a, b database.FeatureFlag) int {
+		return cmp.Compare(a.Name, b.Name)
+	}), nil
+}
+
+func (m *Memory) GetFeatureFlag(ctx context.Context, name string) (database.FeatureFlag, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	flag, ok := m.featureFlags[name]
+	if !ok {
+		return database.FeatureFlag{}, sql.ErrNoRows
+	}
+	return flag, nil
+}
+
+func (m *Memory) DeleteFeatureFlag(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.featureFlags, name)
+	return nil
+}
+
+func (m *Memory) AddVisits(ctx context.Context, arg database.AddVisitsParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.visits[arg.Day] += arg.Hits
+	return nil
+}
+
+func (m *Memory) GetTotalVisits(ctx context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var total int64
+	for _, hits := range m.visits {
+		total += hits
+	}
+	return total, nil
+}
+
+func (m *Memory) GetDailyVisits(ctx context.Context, limit int32) ([]database.Visit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	days := slices.Sorted(maps.Keys(m.visits))
+	slices.Reverse(days)
+	var visits []database.Visit
+	for _, day := range days[:min(len(days), max(int(limit), 0))] {
+		visits = append(visits, database.Visit{Day: day, Hits: m.visits[day]})
+	}
+	return visits, nil
+}
+
+func (m *Memory) DeleteVisits(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.visits)
+	return nil
+}
+
+func (m *Memory) GetDashboardCounts(ctx context.Context, since time.Time) (database.GetDashboardCountsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	counts := database.GetDashboardCountsRow{Users: int64(len(m.users)), Chirps: int64(len(m.chirps))}
+	for _, user := range m.users {
+		if user.CreatedAt.After(since) {
+			counts.NewUsers++
+		}
+	}
+	for _, chirp := range m.chirps {
+		if chirp.CreatedAt.After(since) {
+			counts.NewChirps++
+		}
+	}
+	now := m.now()
+	for _, rt := range m.refreshTokens {
+		if !rt.RevokedAt.Valid && rt.ExpiresAt.After(now) {
+			counts.ActiveSessions++
+		}
+	}
+	return counts, nil
+}
+
+type statsRollupKey struct {
+	period string
+	starts int64
+}
+
+func (m *Memory) GetStatsRollupCounts(ctx context.Context, arg database.GetStatsRollupCountsParams) (database.GetStatsRollupCountsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	in := func(t time.Time) bool { return !t.Before(arg.Since) && t.Before(arg.Until) }
+	var counts database.GetStatsRollupCountsRow
+	active := make(map[uuid.UUID]bool)
+	for _, user := range m.users {
+		if in(user.CreatedAt) {
+			counts.NewUsers++
+		}
+	}
+	for _, chirp := range m.chirps {
+		if in(chirp.CreatedAt) {
+			active[chirp.UserID] = true
+		}
+	}
+	for _, rt := range m.refreshTokens {
+		if in(rt.CreatedAt) {
+			active[rt.UserID] = true
+		}
+	}
+	counts.ActiveUsers = int64(len(active))
+	for _, event := range m.outbox {
+		if !in(event.CreatedAt) {
+			continue
+		}
+		switch event.Event {
+		case "chirp.created":
+			counts.ChirpsPosted++
+		case "chirp.deleted":
+			counts.ChirpsDeleted++
+		case "user.upgraded":
+			counts.RedConversions++
+		}
+	}
+	return counts, nil
+}
+
+func (m *Memory) UpsertStatsRollup(ctx context.Context, arg database.UpsertStatsRollupParams) (database.StatsRollup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rollup := database.StatsRollup{
+		Period:         arg.Period,
+		StartsAt:       arg.StartsAt,
+		EndsAt:         arg.EndsAt,
+		NewUsers:       arg.NewUsers,
+		ActiveUsers:    arg.ActiveUsers,
+		ChirpsPosted:   arg.ChirpsPosted,
+		ChirpsDeleted:  arg.ChirpsDeleted,
+		RedConversions: arg.RedConversions,
+		UpdatedAt:      m.now(),
+	}
+	m.statsRollups[statsRollupKey{arg.Period, arg.StartsAt.UnixNano()}] = rollup
+	return rollup, nil
+}
+
+func (m *Memory) ListStatsRollups(ctx context.Context, arg database.ListStatsRollupsParams) ([]database.StatsRollup, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rollups []database.StatsRollup
+	for _, rollup := range m.statsRollups {
+		if rollup.Period == arg.Period && !rollup.StartsAt.Before(arg.Since) && rollup.StartsAt.Before(arg.Until) {
+			rollups = append(rollups, rollup)
+		}
+	}
+	slices.SortFunc(rollups, func(a, b database.StatsRollup) int { return a.StartsAt.Compare(b.StartsAt) })
+	return rollups, nil
+}
+
+func (m *Memory) CreateAuditEntry(ctx context.Context, arg database.CreateAuditEntryParams) (database.AuditLog, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := database.AuditLog{
+		ID:        uuid.New(),
+		CreatedAt: m.now(),
+		Actor:     arg.Actor,
+		Action:    arg.Action,
+		Target:    arg.Target,
+		RequestID: arg.RequestID,
+		Ip:        arg.Ip,
+		Details:   arg.Details,
+	}
+	m.auditLog = append(m.auditLog, entry)
+	return entry, nil
+}
+
+func (m *Memory) ListAuditEntries(ctx context.Context, arg database.ListAuditEntriesParams) ([]database.AuditLog, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var entries []database.AuditLog
+	// newest first, like ORDER BY created_at DESC
+	for _, entry := range slices.Backward(m.auditLog) {
+		if len(entries) >= int(arg.MaxEntries) {
+			break
+		}
+		if (arg.Actor != "" && entry.Actor != arg.Actor) ||
+			(arg.Action != "" && entry.Action != arg.Action) ||
+			(arg.Target != "" && entry.Target != arg.Target) ||
+			entry.CreatedAt.Before(arg.Since) || !entry.CreatedAt.Before(arg.Until) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (m *Memory) EnqueueJob(ctx context.Context, arg database.EnqueueJobParams) (database.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	job := database.Job{
+		ID:          uuid.New(),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Kind:        arg.Kind,
+		Payload:     arg.Payload,
+		Status:      "pending",
+		MaxAttempts: arg.MaxAttempts,
+		RunAt:       arg.RunAt,
+	}
+	m.jobs[job.ID] = job
+	return job, nil
+}
+
+func (m *Memory) EnqueueJobs(ctx context.Context, args []database.EnqueueJobParams) error {
+	for _, arg := range args {
+		_, err := m.EnqueueJob(ctx, arg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) ClaimJob(ctx context.Context, arg database.ClaimJobParams) (database.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var claimed *database.Job
+	for _, job := range m.jobs {
+		due := (job.Status == "pending" && !job.RunAt.After(arg.Now)) ||
+			(job.Status == "running" && job.LockedUntil.Valid && job.LockedUntil.Time.Before(arg.Now))
+		if due && (claimed == nil || job.RunAt.Before(claimed.RunAt)) {
+			claimed = &job
+		}
+	}
+	if claimed == nil {
+		return database.Job{}, sql.ErrNoRows
+	}
+	claimed.Status = "running"
+	claimed.Attempts++
+	claimed.LockedUntil = arg.LockedUntil
+	claimed.UpdatedAt = m.now()
+	m.jobs[claimed.ID] = *claimed
+	return *claimed, nil
+}
+
+func (m *Memory) CompleteJob(ctx context.Context, id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updateJob(id, func(job *database.Job) {
+		job.Status = "done"
+		job.LastError = ""
+	})
+	return nil
+}
+
+func (m *Memory) RetryJob(ctx context.Context, arg database.RetryJobParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updateJob(arg.ID, func(job *database.Job) {
+		job.Status = "pending"
+		job.RunAt = arg.RunAt
+		job.LastError = arg.LastError
+	})
+	return nil
+}
+
+func (m *Memory) BuryJob(ctx context.Context, arg database.BuryJobParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updateJob(arg.ID, func(job *database.Job) {
+		job.Status = "dead"
+		job.LastError = arg.LastError
+	})
+	return nil
+}
+
+func (m *Memory) GetJob(ctx context.Context, id uuid.UUID) (database.Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return database.Job{}, sql.ErrNoRows
+	}
+	return job, nil
+}
+
+func (m *Memory) ListJobsByStatus(ctx context.Context, arg database.ListJobsByStatusParams) ([]database.Job, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var jobs []database.Job
+	for _, job := range m.jobs {
+		if job.Status == arg.Status {
+			jobs = append(jobs, job)
+		}
+	}
+	slices.SortFunc(jobs, func(a, b database.Job) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	return jobs[:min(len(jobs), max(int(arg.Limit), 0))], nil
+}
+
+func (m *Memory) RequeueDeadJob(ctx context.Context, id uuid.UUID) (database.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok || job.Status != "dead" {
+		return database.Job{}, sql.ErrNoRows
+	}
+	job.Status = "pending"
+	job.Attempts = 0
+	job.RunAt = m.now()
+	job.LastError = ""
+	job.UpdatedAt = job.RunAt
+	m.jobs[id] = job
+	return job, nil
+}
+
+func (m *Memory) CountFinishedJobs(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(maps.Values(m.jobs), func(job database.Job) bool { return job.Status == "done" && job.UpdatedAt.Before(before) }), nil
+}
+
+func (m *Memory) DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for id, job := range m.jobs {
+		if job.Status == "done" && job.UpdatedAt.Before(before) {
+			delete(m.jobs, id)
+			maps.DeleteFunc(m.importBatches, func(key importBatchKey, _ string) bool { return key.jobID == id })
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *Memory) CountJobsByStatus(ctx context.Context) ([]database.CountJobsByStatusRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	counts := make(map[[2]string]int64)
+	for _, job := range m.jobs {
+		if job.Status != "done" {
+			counts[[2]string{job.Kind, job.Status}]++
+		}
+	}
+	var rows []database.CountJobsByStatusRow
+	for key, count := range counts {
+		rows = append(rows, database.CountJobsByStatusRow{Kind: key[0], Status: key[1], Count: count})
+	}
+	slices.SortFunc(rows, func(a, b database.CountJobsByStatusRow) int {
+		return cmp.Or(cmp.Compare(a.Kind, b.Kind), cmp.Compare(a.Status, b.Status))
+	})
+	return rows, nil
+}
+
+func (m *Memory) GetOldestDueJob(ctx context.Context, arg database.GetOldestDueJobParams) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var oldest time.Time
+	for _, job := range m.jobs {
+		if job.Kind == arg.Kind && job.Status == "pending" && !job.RunAt.After(arg.RunAt) && (oldest.IsZero() || job.RunAt.Before(oldest)) {
+			oldest = job.RunAt
+		}
+	}
+	if oldest.IsZero() {
+		return time.Time{}, sql.ErrNoRows
+	}
+	return oldest, nil
+}
+
+func (m *Memory) SetJobProgress(ctx context.Context, arg database.SetJobProgressParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[arg.ID]; ok {
+		job.Progress = arg.Progress
+		job.UpdatedAt = m.now()
+		m.jobs[arg.ID] = job
+	}
+	return nil
+}
+
+// updateJob applies change to a job and releases its lease. Callers must hold the lock.
+func (m *Memory) updateJob(id uuid.UUID, change func(*database.Job)) {
+	job, ok := m.jobs[id]
+	if !ok {
+		return
+	}
+	change(&job)
+	job.LockedUntil = sql.NullTime{}
+	job.UpdatedAt = m.now()
+	m.jobs[id] = job
+}
+
+// scheduledRunKey is the primary key of scheduled_runs. Times are stored as Unix
+// nanoseconds so equal instants compare equal whatever their location.
+type scheduledRunKey struct {
+	task string
+	at   int64
+}
+
+func newScheduledRunKey(task string, at time.Time) scheduledRunKey {
+	return scheduledRunKey{task: task, at: at.UnixNano()}
+}
+
+func (m *Memory) ClaimScheduledRun(ctx context.Context, arg database.ClaimScheduledRunParams) (database.ScheduledRun, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := newScheduledRunKey(arg.Task, arg.ScheduledFor)
+	if _, ok := m.scheduledRuns[key]; ok {
+		return database.ScheduledRun{}, sql.ErrNoRows
+	}
+	run := database.ScheduledRun{
+		Task:         arg.Task,
+		ScheduledFor: arg.ScheduledFor,
+		Instance:     arg.Instance,
+		StartedAt:    arg.StartedAt,
+		Status:       "running",
+	}
+	m.scheduledRuns[key] = run
+	return run, nil
+}
+
+func (m *Memory) FinishScheduledRun(ctx context.Context, arg database.FinishScheduledRunParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := newScheduledRunKey(arg.Task, arg.ScheduledFor)
+	run, ok := m.scheduledRuns[key]
+	if !ok {
+		return nil
+	}
+	run.FinishedAt = arg.FinishedAt
+	run.Status = arg.Status
+	run.Error = arg.Error
+	m.scheduledRuns[key] = run
+	return nil
+}
+
+func (m *Memory) ListScheduledRuns(ctx context.Context, arg database.ListScheduledRunsParams) ([]database.ScheduledRun, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var runs []database.ScheduledRun
+	for _, run := range m.scheduledRuns {
+		if arg.Task == "" || run.Task == arg.Task {
+			runs = append(runs, run)
+		}
+	}
+	slices.SortFunc(runs, func(a, b database.ScheduledRun) int {
+		return cmp.Or(b.ScheduledFor.Compare(a.ScheduledFor), cmp.Compare(a.Task, b.Task))
+	})
+	return runs[:min(len(runs), max(int(arg.MaxRuns), 0))], nil
+}
+
+func (m *Memory) CountScheduledRunsBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(maps.Values(m.scheduledRuns), func(run database.ScheduledRun) bool { return run.ScheduledFor.Before(before) }), nil
+}
+
+func (m *Memory) DeleteScheduledRunsBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for key, run := range m.scheduledRuns {
+		if run.ScheduledFor.Before(before) {
+			delete(m.scheduledRuns, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *Memory) CreateWebhook(ctx context.Context, arg database.CreateWebhookParams) (database.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.Webhook{}, fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	now := m.now()
+	webhook := database.Webhook{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		UserID:    arg.UserID,
+		Url:       arg.Url,
+		Secret:    arg.Secret,
+		Events:    arg.Events,
+		Active:    arg.Active,
+	}
+	m.webhooks[webhook.ID] = webhook
+	return webhook, nil
+}
+
+func (m *Memory) GetWebhook(ctx context.Context, id uuid.UUID) (database.Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	webhook, ok := m.webhooks[id]
+	if !ok {
+		return database.Webhook{}, sql.ErrNoRows
+	}
+	return webhook, nil
+}
+
+func (m *Memory) ListWebhooksByUser(ctx context.Context, userID uuid.UUID) ([]database.Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sortedWebhooks(func(webhook database.Webhook) bool { return webhook.UserID == userID }), nil
+}
+
+func (m *Memory) ListWebhooksForEvent(ctx context.Context, arg database.ListWebhooksForEventParams) ([]database.Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.sortedWebhooks(func(webhook database.Webhook) bool {
+		if _, banned := m.shadowbans[arg.ActorID]; banned && webhook.UserID != arg.ActorID {
+			return false
+		}
+		return webhook.Active && m.users[webhook.UserID].TenantID == arg.TenantID && slices.Contains(strings.Split(webhook.Events, ","), arg.Event)
+	}), nil
+}
+
+func (m *Memory) ListWebhooksAfter(ctx context.Context, arg database.ListWebhooksAfterParams) ([]database.Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return pageAfter(m.webhooks, arg.ID, arg.Limit), nil
+}
+
+func (m *Memory) UpdateWebhook(ctx context.Context, arg database.UpdateWebhookParams) (database.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	webhook, ok := m.webhooks[arg.ID]
+	if !ok || webhook.UserID != arg.UserID {
+		return database.Webhook{}, sql.ErrNoRows
+	}
+	webhook.Url = arg.Url
+	webhook.Secret = arg.Secret
+	webhook.Events = arg.Events
+	webhook.Active = arg.Active
+	webhook.UpdatedAt = m.now()
+	m.webhooks[arg.ID] = webhook
+	return webhook, nil
+}
+
+func (m *Memory) DeleteWebhook(ctx context.Context, arg database.DeleteWebhookParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	webhook, ok := m.webhooks[arg.ID]
+	if !ok || webhook.UserID != arg.UserID {
+		return 0, nil
+	}
+	delete(m.webhooks, arg.ID)
+	m.deliveries = slices.DeleteFunc(m.deliveries, func(d database.WebhookDelivery) bool { return d.WebhookID == arg.ID })
+	return 1, nil
+}
+
+func (m *Memory) RestoreWebhook(ctx context.Context, arg database.RestoreWebhookParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.webhooks[arg.ID]; ok {
+		return &UniqueViolation{Constraint: "webhooks_pkey"}
+	}
+	m.webhooks[arg.ID] = database.Webhook(arg)
+	return nil
+}
+
+func (m *Memory) CreateWebhookDelivery(ctx context.Context, arg database.CreateWebhookDeliveryParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[arg.WebhookID]; !ok {
+		return fmt.Errorf("webhook %s does not exist", arg.WebhookID)
+	}
+	m.deliveries = append(m.deliveries, database.WebhookDelivery{
+		ID:           uuid.New(),
+		CreatedAt:    m.now(),
+		WebhookID:    arg.WebhookID,
+		EventID:      arg.EventID,
+		Event:        arg.Event,
+		StatusCode:   arg.StatusCode,
+		Error:        arg.Error,
+		DurationMs:   arg.DurationMs,
+		RequestBody:  arg.RequestBody,
+		ResponseBody: arg.ResponseBody,
+	})
+	return nil
+}
+
+func (m *Memory) ListWebhookDeliveries(ctx context.Context, arg database.ListWebhookDeliveriesParams) ([]database.WebhookDelivery, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var deliveries []database.WebhookDelivery
+	// newest first, like ORDER BY created_at DESC
+	for _, delivery := range slices.Backward(m.deliveries) {
+		if len(deliveries) >= int(arg.Limit) {
+			break
+		}
+		if delivery.WebhookID == arg.WebhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *Memory) CountWebhookDeliveriesBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(slices.Values(m.deliveries), func(d database.WebhookDelivery) bool { return d.CreatedAt.Before(before) }), nil
+}
+
+func (m *Memory) DeleteWebhookDeliveriesBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := len(m.deliveries)
+	m.deliveries = slices.DeleteFunc(m.deliveries, func(d database.WebhookDelivery) bool { return d.CreatedAt.Before(before) })
+	return int64(kept - len(m.deliveries)), nil
+}
+
+// sortedWebhooks returns matching webhooks oldest first. Callers must hold the lock.
+func (m *Memory) sortedWebhooks(match func(database.Webhook) bool) []database.Webhook {
+	var webhooks []database.Webhook
+	for _, webhook := range m.webhooks {
+		if match(webhook) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	slices.SortFunc(webhooks, func(a, b database.Webhook) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID.String(), b.ID.String()))
+	})
+	return webhooks
+}
+
+func (m *Memory) CreateOutboxEvent(ctx context.Context, arg database.CreateOutboxEventParams) (database.OutboxEvent, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	event := database.OutboxEvent{ID: uuid.New(), CreatedAt: m.now(), TenantID: arg.TenantID, Event: arg.Event, Payload: arg.Payload}
+	m.outbox = append(m.outbox, event)
+	return event, nil
+}
+
+func (m *Memory) ListUnpublishedOutboxEvents(ctx context.Context, limit int32) ([]database.OutboxEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	// the slice is in the order events were recorded
+	var events []database.OutboxEvent
+	for _, event := range m.outbox {
+		if len(events) >= int(limit) {
+			break
+		}
+		if !event.PublishedAt.Valid {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (m *Memory) MarkOutboxEventPublished(ctx context.Context, id uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.outbox, func(event database.OutboxEvent) bool { return event.ID == id })
+	if i < 0 || m.outbox[i].PublishedAt.Valid {
+		return 0, nil
+	}
+	m.outbox[i].PublishedAt = sql.NullTime{Time: m.now(), Valid: true}
+	return 1, nil
+}
+
+func (m *Memory) CountUnpublishedOutboxEvents(ctx context.Context) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(slices.Values(m.outbox), func(event database.OutboxEvent) bool { return !event.PublishedAt.Valid }), nil
+}
+
+func (m *Memory) CountPublishedOutboxEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(slices.Values(m.outbox), publishedBefore(before)), nil
+}
+
+func (m *Memory) DeletePublishedOutboxEventsBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := len(m.outbox)
+	m.outbox = slices.DeleteFunc(m.outbox, publishedBefore(before))
+	return int64(kept - len(m.outbox)), nil
+}
+
+// publishedBefore matches events published before a time, like published_at < $1
+func publishedBefore(before time.Time) func(database.OutboxEvent) bool {
+	return func(event database.OutboxEvent) bool {
+		return event.PublishedAt.Valid && event.PublishedAt.Time.Before(before)
+	}
+}
+
+// idempotencyKey is the primary key of idempotency_keys
+type idempotencyKey struct{ scope, key string }
+
+func (m *Memory) ClaimIdempotencyKey(ctx context.Context, arg database.ClaimIdempotencyKeyParams) (database.IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := idempotencyKey{arg.Scope, arg.IdempotencyKey}
+	if _, ok := m.idempotency[key]; ok {
+		return database.IdempotencyKey{}, sql.ErrNoRows
+	}
+	row := database.IdempotencyKey{
+		Scope:          arg.Scope,
+		IdempotencyKey: arg.IdempotencyKey,
+		Fingerprint:    arg.Fingerprint,
+		Status:         "processing",
+		CreatedAt:      m.now(),
+		ExpiresAt:      arg.ExpiresAt,
+		Headers:        "{}",
+	}
+	m.idempotency[key] = row
+	return row, nil
+}
+
+func (m *Memory) GetIdempotencyKey(ctx context.Context, arg database.GetIdempotencyKeyParams) (database.IdempotencyKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	row, ok := m.idempotency[idempotencyKey{arg.Scope, arg.IdempotencyKey}]
+	if !ok {
+		return database.IdempotencyKey{}, sql.ErrNoRows
+	}
+	return row, nil
+}
+
+func (m *Memory) CompleteIdempotencyKey(ctx context.Context, arg database.CompleteIdempotencyKeyParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := idempotencyKey{arg.Scope, arg.IdempotencyKey}
+	row, ok := m.idempotency[key]
+	if !ok {
+		return nil
+	}
+	row.Status = "done"
+	row.StatusCode = arg.StatusCode
+	row.ContentType = arg.ContentType
+	row.Body = arg.Body
+	row.Headers = arg.Headers
+	m.idempotency[key] = row
+	return nil
+}
+
+func (m *Memory) DeleteIdempotencyKey(ctx context.Context, arg database.DeleteIdempotencyKeyParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.idempotency, idempotencyKey{arg.Scope, arg.IdempotencyKey})
+	return nil
+}
+
+func (m *Memory) CountExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(maps.Values(m.idempotency), func(row database.IdempotencyKey) bool { return row.ExpiresAt.Before(before) }), nil
+}
+
+func (m *Memory) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for key, row := range m.idempotency {
+		if row.ExpiresAt.Before(before) {
+			delete(m.idempotency, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// WithTx snapshots the data, runs fn, and restores the snapshot if fn fails.
+// Transactions are serialized with each other but not isolated from writes made
+// outside a transaction, which is enough for demo and test use.
+func (m *Memory) WithTx(ctx context.Context, fn func(Store) error) (err error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	saved := m.snapshot()
+	defer func() {
+		if p := recover(); p != nil {
+			m.restore(saved)
+			panic(p)
+		}
+		if err != nil {
+			m.restore(saved)
+		}
+	}()
+	return fn(m)
+}
+
+// Snapshot runs fn while holding the transaction lock, so no WithTx commits part way
+// through it. Like WithTx it is not isolated from writes made outside a transaction.
+func (m *Memory) Snapshot(ctx context.Context, fn func(Store) error) error {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	return fn(m)
+}
+
+// memorySnapshot holds copies of every table for WithTx to roll back to
+func (m *Memory) TakeRateLimitToken(ctx context.Context, arg database.TakeRateLimitTokenParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tat, ok := m.rateLimits[arg.Bucket]
+	if !ok || tat < arg.Now {
+		tat = arg.Now
+	}
+	if ok && tat+arg.Emission-arg.Window > arg.Now {
+		return 0, sql.ErrNoRows
+	}
+	m.rateLimits[arg.Bucket] = tat + arg.Emission
+	return tat + arg.Emission, nil
+}
+
+func (m *Memory) GetRateLimit(ctx context.Context, bucket string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tat, ok := m.rateLimits[bucket]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return tat, nil
+}
+
+func (m *Memory) CountExpiredRateLimits(ctx context.Context, before int64) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(maps.Values(m.rateLimits), func(tat int64) bool { return tat < before }), nil
+}
+
+func (m *Memory) DeleteExpiredRateLimits(ctx context.Context, before int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for bucket, tat := range m.rateLimits {
+		if tat < before {
+			delete(m.rateLimits, bucket)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// quotaUsageKey is the primary key of quota_usage, with the window start in microseconds
+type quotaUsageKey struct {
+	userID      uuid.UUID
+	quota       string
+	windowStart int64
+}
+
+func (m *Memory) AddQuotaUsage(ctx context.Context, arg database.AddQuotaUsageParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return 0, fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	key := quotaUsageKey{arg.UserID, arg.Quota, arg.WindowStart.UnixMicro()}
+	m.quotaUsage[key] += arg.Used
+	return m.quotaUsage[key], nil
+}
+
+func (m *Memory) GetQuotaUsage(ctx context.Context, arg database.GetQuotaUsageParams) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.quotaUsage[quotaUsageKey{arg.UserID, arg.Quota, arg.WindowStart.UnixMicro()}], nil
+}
+
+func (m *Memory) CountQuotaUsageBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(maps.Keys(m.quotaUsage), func(key quotaUsageKey) bool { return key.windowStart < before.UnixMicro() }), nil
+}
+
+func (m *Memory) DeleteQuotaUsageBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := len(m.quotaUsage)
+	maps.DeleteFunc(m.quotaUsage, func(key quotaUsageKey, used int64) bool { return key.windowStart < before.UnixMicro() })
+	return int64(kept - len(m.quotaUsage)), nil
+}
+
+// apiKeyUsageKey is the primary key of api_key_usage, with the day in microseconds
+type apiKeyUsageKey struct {
+	apiKeyID uuid.UUID
+	day      int64
+}
+
+func (m *Memory) CreateAPIKey(ctx context.Context, arg database.CreateAPIKeyParams) (database.ApiKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.ApiKey{}, fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	key := database.ApiKey{
+		ID:        uuid.New(),
+		CreatedAt: m.now(),
+		UserID:    arg.UserID,
+		TenantID:  arg.TenantID,
+		Name:      arg.Name,
+		KeyHash:   arg.KeyHash,
+		Prefix:    arg.Prefix,
+	}
+	m.apiKeys[key.ID] = key
+	return key, nil
+}
+
+func (m *Memory) GetAPIKeyByHash(ctx context.Context, keyHash string) (database.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.apiKeys {
+		if key.KeyHash == keyHash {
+			return key, nil
+		}
+	}
+	return database.ApiKey{}, sql.ErrNoRows
+}
+
+func (m *Memory) ListAPIKeysByUser(ctx context.Context, userID uuid.UUID) ([]database.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var keys []database.ApiKey
+	for _, key := range m.apiKeys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	slices.SortFunc(keys, func(a, b database.ApiKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return keys, nil
+}
+
+func (m *Memory) DeleteAPIKey(ctx context.Context, arg database.DeleteAPIKeyParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.apiKeys[arg.ID]
+	if !ok || key.UserID != arg.UserID {
+		return 0, nil
+	}
+	delete(m.apiKeys, arg.ID)
+	maps.DeleteFunc(m.apiKeyUsage, func(usage apiKeyUsageKey, requests int64) bool { return usage.apiKeyID == arg.ID })
+	return 1, nil
+}
+
+func (m *Memory) TouchAPIKey(ctx context.Context, arg database.TouchAPIKeyParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key, ok := m.apiKeys[arg.ID]; ok {
+		key.LastUsedAt = arg.LastUsedAt
+		m.apiKeys[arg.ID] = key
+	}
+	return nil
+}
+
+func (m *Memory) ListAPIKeysAfter(ctx context.Context, arg database.ListAPIKeysAfterParams) ([]database.ApiKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return pageAfter(m.apiKeys, arg.ID, arg.Limit), nil
+}
+
+func (m *Memory) RestoreAPIKey(ctx context.Context, arg database.RestoreAPIKeyParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.apiKeys[arg.ID]; ok {
+		return &UniqueViolation{Constraint: "api_keys_pkey"}
+	}
+	for _, key := range m.apiKeys {
+		if key.KeyHash == arg.KeyHash {
+			return &UniqueViolation{Constraint: "api_keys_key_hash_key"}
+		}
+	}
+	m.apiKeys[arg.ID] = database.ApiKey(arg)
+	return nil
+}
+
+func (m *Memory) AddAPIKeyUsage(ctx context.Context, arg database.AddAPIKeyUsageParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.apiKeys[arg.ApiKeyID]; !ok {
+		return 0, fmt.Errorf("api key %s does not exist", arg.ApiKeyID)
+	}
+	key := apiKeyUsageKey{arg.ApiKeyID, arg.Day.UnixMicro()}
+	m.apiKeyUsage[key] += arg.Requests
+	return m.apiKeyUsage[key], nil
+}
+
+func (m *Memory) ListAPIKeyUsage(ctx context.Context, arg database.ListAPIKeyUsageParams) ([]database.ApiKeyUsage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rows []database.ApiKeyUsage
+	for key, requests := range m.apiKeyUsage {
+		if key.apiKeyID == arg.ApiKeyID && key.day >= arg.Day.UnixMicro() {
+			rows = append(rows, database.ApiKeyUsage{ApiKeyID: key.apiKeyID, Day: time.UnixMicro(key.day).UTC(), Requests: requests})
+		}
+	}
+	slices.SortFunc(rows, func(a, b database.ApiKeyUsage) int { return b.Day.Compare(a.Day) })
+	return rows, nil
+}
+
+func (m *Memory) CountAPIKeyUsageBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(maps.Keys(m.apiKeyUsage), func(key apiKeyUsageKey) bool { return key.day < before.UnixMicro() }), nil
+}
+
+func (m *Memory) DeleteAPIKeyUsageBefore(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := len(m.apiKeyUsage)
+	maps.DeleteFunc(m.apiKeyUsage, func(key apiKeyUsageKey, requests int64) bool { return key.day < before.UnixMicro() })
+	return int64(kept - len(m.apiKeyUsage)), nil
+}
+
+func (m *Memory) CreateChirpReport(ctx context.Context, arg database.CreateChirpReportParams) (database.ChirpReport, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.AuthorID]; !ok {
+		return database.ChirpReport{}, fmt.Errorf("user %s does not exist", arg.AuthorID)
+	}
+	if arg.ReporterID.Valid {
+		if _, ok := m.users[arg.ReporterID.UUID]; !ok {
+			return database.ChirpReport{}, fmt.Errorf("user %s does not exist", arg.ReporterID.UUID)
+		}
+		for _, report := range m.reports {
+			if report.ChirpID == arg.ChirpID && report.ReporterID == arg.ReporterID && !report.ResolvedAt.Valid {
+				return database.ChirpReport{}, &UniqueViolation{Constraint: "chirp_reports_reporter_idx"}
+			}
+		}
+	}
+	report := database.ChirpReport{
+		ID:         uuid.New(),
+		CreatedAt:  m.now(),
+		ChirpID:    arg.ChirpID,
+		AuthorID:   arg.AuthorID,
+		TenantID:   arg.TenantID,
+		ReporterID: arg.ReporterID,
+		Reason:     arg.Reason,
+		Details:    arg.Details,
+	}
+	m.reports = append(m.reports, report)
+	return report, nil
+}
+
+func (m *Memory) ListOpenChirpReports(ctx context.Context) ([]database.ListOpenChirpReportsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rows []database.ListOpenChirpReportsRow
+	// reports are appended in creation order, like ORDER BY created_at ASC
+	for _, report := range m.reports {
+		chirp, ok := m.chirps[report.ChirpID]
+		if !ok || report.ResolvedAt.Valid {
+			continue
+		}
+		rows = append(rows, database.ListOpenChirpReportsRow{
+			ID:             report.ID,
+			CreatedAt:      report.CreatedAt,
+			ChirpID:        report.ChirpID,
+			AuthorID:       report.AuthorID,
+			TenantID:       report.TenantID,
+			ReporterID:     report.ReporterID,
+			Reason:         report.Reason,
+			Details:        report.Details,
+			EscalatedAt:    report.EscalatedAt,
+			ResolvedAt:     report.ResolvedAt,
+			DecisionID:     report.DecisionID,
+			ChirpBody:      chirp.Body,
+			ChirpCreatedAt: chirp.CreatedAt,
+		})
+	}
+	return rows, nil
+}
+
+func (m *Memory) GetOpenChirpReports(ctx context.Context, chirpID uuid.UUID) ([]database.ChirpReport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var reports []database.ChirpReport
+	for _, report := range m.reports {
+		if report.ChirpID == chirpID && !report.ResolvedAt.Valid {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
+
+func (m *Memory) CreateModerationDecision(ctx context.Context, arg database.CreateModerationDecisionParams) (database.ModerationDecision, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.AuthorID]; !ok {
+		return database.ModerationDecision{}, fmt.Errorf("user %s does not exist", arg.AuthorID)
+	}
+	decision := database.ModerationDecision{
+		ID:        uuid.New(),
+		CreatedAt: m.now(),
+		ChirpID:   arg.ChirpID,
+		AuthorID:  arg.AuthorID,
+		TenantID:  arg.TenantID,
+		Moderator: arg.Moderator,
+		Action:    arg.Action,
+		Note:      arg.Note,
+		Reports:   arg.Reports,
+	}
+	m.decisions = append(m.decisions, decision)
+	return decision, nil
+}
+
+func (m *Memory) ResolveChirpReports(ctx context.Context, arg database.ResolveChirpReportsParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.updateOpenReports(arg.ChirpID, func(report *database.ChirpReport) bool {
+		report.ResolvedAt = sql.NullTime{Time: m.now(), Valid: true}
+		report.DecisionID = arg.DecisionID
+		return true
+	}), nil
+}
+
+func (m *Memory) EscalateChirpReports(ctx context.Context, chirpID uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.updateOpenReports(chirpID, func(report *database.ChirpReport) bool {
+		if report.EscalatedAt.Valid {
+			return false
+		}
+		report.EscalatedAt = sql.NullTime{Time: m.now(), Valid: true}
+		return true
+	}), nil
+}
+
+// updateOpenReports applies change to the open reports of a chirp and counts those it
+// changed. Callers must hold the lock.
+func (m *Memory) updateOpenReports(chirpID uuid.UUID, change func(*database.ChirpReport) bool) int64 {
+	var updated int64
+	for i := range m.reports {
+		if m.reports[i].ChirpID == chirpID && !m.reports[i].ResolvedAt.Valid && change(&m.reports[i]) {
+			updated++
+		}
+	}
+	return updated
+}
+
+// reportsIn returns the reports made in [since, until). Callers must hold the lock.
+func (m *Memory) reportsIn(since, until time.Time) []database.ChirpReport {
+	var reports []database.ChirpReport
+	for _, report := range m.reports {
+		if !report.CreatedAt.Before(since) && report.CreatedAt.Before(until) {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+// decisionAction returns the action of the decision that resolved report, "" while it
+// is open. Callers must hold the lock.
+func (m *Memory) decisionAction(report database.ChirpReport) string {
+	if !report.DecisionID.Valid {
+		return ""
+	}
+	for _, decision := range m.decisions {
+		if decision.ID == report.DecisionID.UUID {
+			return decision.Action
+		}
+	}
+	return ""
+}
+
+func (m *Memory) CountChirpReportsByDay(ctx context.Context, arg database.CountChirpReportsByDayParams) ([]database.CountChirpReportsByDayRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	type key struct{ day, reason string }
+	counts := make(map[key]int64)
+	for _, report := range m.reportsIn(arg.Since, arg.Until) {
+		counts[key{report.CreatedAt.UTC().Format(time.DateOnly), report.Reason}]++
+	}
+	rows := make([]database.CountChirpReportsByDayRow, 0, len(counts))
+	for k, n := range counts {
+		rows = append(rows, database.CountChirpReportsByDayRow{Day: k.day, Reason: k.reason, Reports: n})
+	}
+	slices.SortFunc(rows, func(a, b database.CountChirpReportsByDayRow) int {
+		return cmp.Or(cmp.Compare(a.Day, b.Day), cmp.Compare(a.Reason, b.Reason))
+	})
+	return rows, nil
+}
+
+func (m *Memory) CountChirpReportsByReason(ctx context.Context, arg database.CountChirpReportsByReasonParams) ([]database.CountChirpReportsByReasonRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	byReason := make(map[string]*database.CountChirpReportsByReasonRow)
+	for _, report := range m.reportsIn(arg.Since, arg.Until) {
+		row, ok := byReason[report.Reason]
+		if !ok {
+			row = &database.CountChirpReportsByReasonRow{Reason: report.Reason}
+			byReason[report.Reason] = row
+		}
+		row.Reports++
+		if !report.ResolvedAt.Valid {
+			row.OpenReports++
+		}
+		if report.EscalatedAt.Valid {
+			row.Escalated++
+		}
+		switch m.decisionAction(report) {
+		case "approve":
+			row.Approved++
+		case "remove":
+			row.Removed++
+		}
+	}
+	rows := make([]database.CountChirpReportsByReasonRow, 0, len(byReason))
+	for _, row := range byReason {
+		rows = append(rows, *row)
+	}
+	slices.SortFunc(rows, func(a, b database.CountChirpReportsByReasonRow) int {
+		return cmp.Or(cmp.Compare(b.Reports, a.Reports), cmp.Compare(a.Reason, b.Reason))
+	})
+	return rows, nil
+}
+
+func (m *Memory) ListMostReportedUsers(ctx context.Context, arg database.ListMostReportedUsersParams) ([]database.ListMostReportedUsersRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	byAuthor := make(map[uuid.UUID]*database.ListMostReportedUsersRow)
+	chirps := make(map[uuid.UUID]map[uuid.UUID]bool)
+	removed := make(map[uuid.UUID]map[uuid.UUID]bool)
+	for _, report := range m.reportsIn(arg.Since, arg.Until) {
+		user, ok := m.users[report.AuthorID]
+		if !ok {
+			continue
+		}
+		row, ok := byAuthor[report.AuthorID]
+		if !ok {
+			row = &database.ListMostReportedUsersRow{AuthorID: report.AuthorID, Email: user.Email}
+			byAuthor[report.AuthorID] = row
+			chirps[report.AuthorID] = make(map[uuid.UUID]bool)
+			removed[report.AuthorID] = make(map[uuid.UUID]bool)
+		}
+		row.Reports++
+		chirps[report.AuthorID][report.ChirpID] = true
+		if m.decisionAction(report) == "remove" {
+			removed[report.AuthorID][report.ChirpID] = true
+		}
+	}
+	rows := make([]database.ListMostReportedUsersRow, 0, len(byAuthor))
+	for id, row := range byAuthor {
+		row.Chirps = int64(len(chirps[id]))
+		row.RemovedChirps = int64(len(removed[id]))
+		rows = append(rows, *row)
+	}
+	slices.SortFunc(rows, func(a, b database.ListMostReportedUsersRow) int {
+		return cmp.Or(cmp.Compare(b.Reports, a.Reports), bytes.Compare(a.AuthorID[:], b.AuthorID[:]))
+	})
+	return rows[:min(len(rows), int(arg.MaxRows))], nil
+}
+
+func (m *Memory) ListMostReportedChirps(ctx context.Context, arg database.ListMostReportedChirpsParams) ([]database.ListMostReportedChirpsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	byChirp := make(map[uuid.UUID]*database.ListMostReportedChirpsRow)
+	for _, report := range m.reportsIn(arg.Since, arg.Until) {
+		row, ok := byChirp[report.ChirpID]
+		if !ok {
+			row = &database.ListMostReportedChirpsRow{ChirpID: report.ChirpID, AuthorID: report.AuthorID}
+			if chirp, ok := m.chirps[report.ChirpID]; ok {
+				row.ChirpBody = sql.NullString{String: chirp.Body, Valid: true}
+			}
+			byChirp[report.ChirpID] = row
+		}
+		row.Reports++
+		if !report.ResolvedAt.Valid {
+			row.OpenReports++
+		}
+	}
+	rows := make([]database.ListMostReportedChirpsRow, 0, len(byChirp))
+	for _, row := range byChirp {
+		rows = append(rows, *row)
+	}
+	slices.SortFunc(rows, func(a, b database.ListMostReportedChirpsRow) int {
+		return cmp.Or(cmp.Compare(b.Reports, a.Reports), bytes.Compare(a.ChirpID[:], b.ChirpID[:]))
+	})
+	return rows[:min(len(rows), int(arg.MaxRows))], nil
+}
+
+func (m *Memory) ListReportResponseTimes(ctx context.Context, arg database.ListReportResponseTimesParams) ([]database.ListReportResponseTimesRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rows []database.ListReportResponseTimesRow
+	// decisions are appended in creation order, like ORDER BY created_at ASC
+	for _, decision := range m.decisions {
+		if decision.CreatedAt.Before(arg.Since) || !decision.CreatedAt.Before(arg.Until) {
+			continue
+		}
+		for _, report := range m.reports {
+			if report.DecisionID.Valid && report.DecisionID.UUID == decision.ID {
+				rows = append(rows, database.ListReportResponseTimesRow{
+					ReportedAt:  report.CreatedAt,
+					EscalatedAt: report.EscalatedAt,
+					DecidedAt:   decision.CreatedAt,
+					Moderator:   decision.Moderator,
+					Action:      decision.Action,
+				})
+			}
+		}
+	}
+	return rows, nil
+}
+
+func (m *Memory) ListAutomodHoldsAfter(ctx context.Context, arg database.ListAutomodHoldsAfterParams) ([]database.ChirpReport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	holds := make(map[uuid.UUID]database.ChirpReport)
+	for _, report := range m.reports {
+		if isHold(report) {
+			holds[report.ID] = report
+		}
+	}
+	return pageAfter(holds, arg.ID, arg.Limit), nil
+}
+
+func (m *Memory) RestoreChirpReport(ctx context.Context, arg database.RestoreChirpReportParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.AuthorID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.AuthorID)
+	}
+	if slices.ContainsFunc(m.reports, func(report database.ChirpReport) bool { return report.ID == arg.ID }) {
+		return &UniqueViolation{Constraint: "chirp_reports_pkey"}
+	}
+	m.reports = append(m.reports, database.ChirpReport(arg))
+	return nil
+}
+
+func (m *Memory) CreateWordFilter(ctx context.Context, arg database.CreateWordFilterParams) (database.WordFilter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.wordFilterExists(uuid.Nil, arg.Kind, arg.Pattern) {
+		return database.WordFilter{}, &UniqueViolation{Constraint: "word_filters_kind_pattern_idx"}
+	}
+	now := m.now()
+	filter := database.WordFilter{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		UpdatedAt: now,
+		Pattern:   arg.Pattern,
+		Kind:      arg.Kind,
+		Action:    arg.Action,
+		Note:      arg.Note,
+	}
+	m.wordFilters[filter.ID] = filter
+	return filter, nil
+}
+
+func (m *Memory) GetWordFilters(ctx context.Context) ([]database.WordFilter, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.SortedFunc(maps.Values(m.wordFilters), func(a, b database.WordFilter) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID.String(), b.ID.String()))
+	}), nil
+}
+
+func (m *Memory) UpdateWordFilter(ctx context.Context, arg database.UpdateWordFilterParams) (database.WordFilter, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	filter, ok := m.wordFilters[arg.ID]
+	if !ok {
+		return database.WordFilter{}, sql.ErrNoRows
+	}
+	if m.wordFilterExists(arg.ID, arg.Kind, arg.Pattern) {
+		return database.WordFilter{}, &UniqueViolation{Constraint: "word_filters_kind_pattern_idx"}
+	}
+	filter.Pattern, filter.Kind, filter.Action, filter.Note = arg.Pattern, arg.Kind, arg.Action, arg.Note
+	filter.UpdatedAt = m.now()
+	m.wordFilters[arg.ID] = filter
+	return filter, nil
+}
+
+func (m *Memory) DeleteWordFilter(ctx context.Context, id uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.wordFilters[id]; !ok {
+		return 0, nil
+	}
+	delete(m.wordFilters, id)
+	return 1, nil
+}
+
+func (m *Memory) RestoreWordFilter(ctx context.Context, arg database.RestoreWordFilterParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.wordFilterExists(arg.ID, arg.Kind, arg.Pattern) {
+		return &UniqueViolation{Constraint: "word_filters_kind_pattern_idx"}
+	}
+	m.wordFilters[arg.ID] = database.WordFilter(arg)
+	return nil
+}
+
+// wordFilterExists reports whether a rule other than except has the same kind and
+// pattern. Callers must hold the lock.
+func (m *Memory) wordFilterExists(except uuid.UUID, kind, pattern string) bool {
+	for id, filter := range m.wordFilters {
+		if id != except && filter.Kind == kind && filter.Pattern == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) SetShadowban(ctx context.Context, arg database.SetShadowbanParams) (database.Shadowban, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[arg.UserID]
+	if !ok {
+		return database.Shadowban{}, sql.ErrNoRows
+	}
+	ban, ok := m.shadowbans[arg.UserID]
+	if !ok {
+		ban = database.Shadowban{UserID: user.ID, CreatedAt: m.now(), TenantID: user.TenantID}
+	}
+	ban.Reason, ban.BannedBy = arg.Reason, arg.BannedBy
+	m.shadowbans[arg.UserID] = ban
+	return ban, nil
+}
+
+func (m *Memory) ListShadowbans(ctx context.Context) ([]database.Shadowban, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.SortedFunc(maps.Values(m.shadowbans), func(a, b database.Shadowban) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.UserID.String(), b.UserID.String()))
+	}), nil
+}
+
+func (m *Memory) DeleteShadowban(ctx context.Context, userID uuid.UUID) (database.Shadowban, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ban, ok := m.shadowbans[userID]
+	if !ok {
+		return database.Shadowban{}, sql.ErrNoRows
+	}
+	delete(m.shadowbans, userID)
+	return ban, nil
+}
+
+func (m *Memory) RestoreShadowban(ctx context.Context, arg database.RestoreShadowbanParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.shadowbans[arg.UserID]; ok {
+		return &UniqueViolation{Constraint: "shadowbans_pkey"}
+	}
+	m.shadowbans[arg.UserID] = database.Shadowban(arg)
+	return nil
+}
+
+// visibleTo reports whether viewer may read chirp, which is not the case when someone
+// else reads the chirp of a shadowbanned user or one auto-moderation or a word filter
+// holds for review. Callers must hold the lock.
+func (m *Memory) visibleTo(chirp database.Chirp, viewer uuid.UUID) bool {
+	if chirp.UserID == viewer {
+		return true
+	}
+	if _, banned := m.shadowbans[chirp.UserID]; banned {
+		return false
+	}
+	return !slices.ContainsFunc(m.reports, func(report database.ChirpReport) bool {
+		return report.ChirpID == chirp.ID && isHold(report)
+	})
+}
+
+// isHold reports whether report is an open hold, which hides its chirp from everyone
+// but the author until a moderator decides on it
+func isHold(report database.ChirpReport) bool {
+	return (report.Reason == "automod_hold" || report.Reason == "word_filter") && !report.ResolvedAt.Valid
+}
+
+func (m *Memory) CreateIPBan(ctx context.Context, arg database.CreateIPBanParams) (database.IpBan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for id, ban := range m.ipBans {
+		if ban.Cidr != arg.Cidr {
+			continue
+		}
+		// like the upsert, only an expired ban of the range is replaced
+		if !ban.ExpiresAt.Valid || ban.ExpiresAt.Time.After(now) {
+			return database.IpBan{}, sql.ErrNoRows
+		}
+		delete(m.ipBans, id)
+	}
+	ban := database.IpBan{
+		ID:        uuid.New(),
+		CreatedAt: now,
+		Cidr:      arg.Cidr,
+		Reason:    arg.Reason,
+		ExpiresAt: arg.ExpiresAt,
+		CreatedBy: arg.CreatedBy,
+	}
+	m.ipBans[ban.ID] = ban
+	return ban, nil
+}
+
+func (m *Memory) ListActiveIPBans(ctx context.Context) ([]database.IpBan, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := m.now()
+	var bans []database.IpBan
+	for _, ban := range m.ipBans {
+		if !ban.ExpiresAt.Valid || ban.ExpiresAt.Time.After(now) {
+			bans = append(bans, ban)
+		}
+	}
+	slices.SortFunc(bans, func(a, b database.IpBan) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID.String(), b.ID.String()))
+	})
+	return bans, nil
+}
+
+func (m *Memory) DeleteIPBan(ctx context.Context, id uuid.UUID) (database.IpBan, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ban, ok := m.ipBans[id]
+	if !ok {
+		return database.IpBan{}, sql.ErrNoRows
+	}
+	delete(m.ipBans, id)
+	return ban, nil
+}
+
+func (m *Memory) RestoreIPBan(ctx context.Context, arg database.RestoreIPBanParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, ban := range m.ipBans {
+		if id != arg.ID && ban.Cidr == arg.Cidr {
+			return &UniqueViolation{Constraint: "ip_bans_cidr_idx"}
+		}
+	}
+	m.ipBans[arg.ID] = database.IpBan(arg)
+	return nil
+}
+
+func (m *Memory) CountExpiredIPBans(ctx context.Context, expiresAt sql.NullTime) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(maps.Values(m.ipBans), func(ban database.IpBan) bool {
+		return ban.ExpiresAt.Valid && ban.ExpiresAt.Time.Before(expiresAt.Time)
+	}), nil
+}
+
+func (m *Memory) DeleteExpiredIPBans(ctx context.Context, expiresAt sql.NullTime) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for id, ban := range m.ipBans {
+		if ban.ExpiresAt.Valid && ban.ExpiresAt.Time.Before(expiresAt.Time) {
+			delete(m.ipBans, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *Memory) CreateAutomodRule(ctx context.Context, arg database.CreateAutomodRuleParams) (database.AutomodRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	rule := database.AutomodRule{
+		ID:         uuid.New(),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Name:       arg.Name,
+		Version:    1,
+		Enabled:    arg.Enabled,
+		Definition: arg.Definition,
+	}
+	m.automodRules[rule.ID] = rule
+	return rule, nil
+}
+
+func (m *Memory) ListAutomodRules(ctx context.Context) ([]database.AutomodRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return slices.SortedFunc(maps.Values(m.automodRules), func(a, b database.AutomodRule) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID.String(), b.ID.String()))
+	}), nil
+}
+
+func (m *Memory) GetAutomodRule(ctx context.Context, id uuid.UUID) (database.AutomodRule, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rule, ok := m.automodRules[id]
+	if !ok {
+		return database.AutomodRule{}, sql.ErrNoRows
+	}
+	return rule, nil
+}
+
+func (m *Memory) UpdateAutomodRule(ctx context.Context, arg database.UpdateAutomodRuleParams) (database.AutomodRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rule, ok := m.automodRules[arg.ID]
+	if !ok {
+		return database.AutomodRule{}, sql.ErrNoRows
+	}
+	rule.Name, rule.Enabled, rule.Definition = arg.Name, arg.Enabled, arg.Definition
+	rule.Version++
+	rule.UpdatedAt = m.now()
+	m.automodRules[arg.ID] = rule
+	return rule, nil
+}
+
+func (m *Memory) DeleteAutomodRule(ctx context.Context, id uuid.UUID) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.automodRules[id]; !ok {
+		return 0, nil
+	}
+	delete(m.automodRules, id)
+	m.ruleVersions = slices.DeleteFunc(m.ruleVersions, func(version database.AutomodRuleVersion) bool {
+		return version.RuleID == id
+	})
+	return 1, nil
+}
+
+func (m *Memory) CreateAutomodRuleVersion(ctx context.Context, arg database.CreateAutomodRuleVersionParams) (database.AutomodRuleVersion, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.automodRules[arg.RuleID]; !ok {
+		return database.AutomodRuleVersion{}, fmt.Errorf("automod rule %s does not exist", arg.RuleID)
+	}
+	if slices.ContainsFunc(m.ruleVersions, func(version database.AutomodRuleVersion) bool {
+		return version.RuleID == arg.RuleID && version.Version == arg.Version
+	}) {
+		return database.AutomodRuleVersion{}, &UniqueViolation{Constraint: "automod_rule_versions_pkey"}
+	}
+	version := database.AutomodRuleVersion{
+		RuleID:     arg.RuleID,
+		Version:    arg.Version,
+		CreatedAt:  m.now(),
+		Name:       arg.Name,
+		Enabled:    arg.Enabled,
+		Definition: arg.Definition,
+		CreatedBy:  arg.CreatedBy,
+	}
+	m.ruleVersions = append(m.ruleVersions, version)
+	return version, nil
+}
+
+func (m *Memory) ListAutomodRuleVersions(ctx context.Context, ruleID uuid.UUID) ([]database.AutomodRuleVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var versions []database.AutomodRuleVersion
+	for _, version := range m.ruleVersions {
+		if version.RuleID == ruleID {
+			versions = append(versions, version)
+		}
+	}
+	slices.SortFunc(versions, func(a, b database.AutomodRuleVersion) int { return cmp.Compare(b.Version, a.Version) })
+	return versions, nil
+}
+
+func (m *Memory) GetAutomodRuleVersion(ctx context.Context, arg database.GetAutomodRuleVersionParams) (database.AutomodRuleVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, version := range m.ruleVersions {
+		if version.RuleID == arg.RuleID && version.Version == arg.Version {
+			return version, nil
+		}
+	}
+	return database.AutomodRuleVersion{}, sql.ErrNoRows
+}
+
+func (m *Memory) SetAutomodRestriction(ctx context.Context, arg database.SetAutomodRestrictionParams) (database.AutomodRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.AutomodRestriction{}, fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	restriction := database.AutomodRestriction{
+		UserID:        arg.UserID,
+		CreatedAt:     m.now(),
+		ExpiresAt:     arg.ExpiresAt,
+		MaxChirps:     arg.MaxChirps,
+		WindowSeconds: arg.WindowSeconds,
+		RuleID:        arg.RuleID,
+	}
+	m.restrictions[arg.UserID] = restriction
+	return restriction, nil
+}
+
+func (m *Memory) GetAutomodRestriction(ctx context.Context, arg database.GetAutomodRestrictionParams) (database.AutomodRestriction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	restriction, ok := m.restrictions[arg.UserID]
+	if !ok || !restriction.ExpiresAt.After(arg.ExpiresAt) {
+		return database.AutomodRestriction{}, sql.ErrNoRows
+	}
+	return restriction, nil
+}
+
+func (m *Memory) ListAutomodRestrictionsAfter(ctx context.Context, arg database.ListAutomodRestrictionsAfterParams) ([]database.AutomodRestriction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return pageAfter(m.restrictions, arg.UserID, arg.Limit), nil
+}
+
+func (m *Memory) RestoreAutomodRule(ctx context.Context, arg database.RestoreAutomodRuleParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.automodRules[arg.ID] = database.AutomodRule(arg)
+	return nil
+}
+
+func (m *Memory) RestoreAutomodRuleVersion(ctx context.Context, arg database.RestoreAutomodRuleVersionParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.automodRules[arg.RuleID]; !ok {
+		return fmt.Errorf("automod rule %s does not exist", arg.RuleID)
+	}
+	if !slices.ContainsFunc(m.ruleVersions, func(version database.AutomodRuleVersion) bool {
+		return version.RuleID == arg.RuleID && version.Version == arg.Version
+	}) {
+		m.ruleVersions = append(m.ruleVersions, database.AutomodRuleVersion(arg))
+	}
+	return nil
+}
+
+func (m *Memory) RestoreAutomodRestriction(ctx context.Context, arg database.RestoreAutomodRestrictionParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.restrictions[arg.UserID]; ok {
+		return &UniqueViolation{Constraint: "automod_restrictions_pkey"}
+	}
+	m.restrictions[arg.UserID] = database.AutomodRestriction(arg)
+	return nil
+}
+
+func (m *Memory) SetRuntimeState(ctx context.Context, arg database.SetRuntimeStateParams) (database.RuntimeState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	row := database.RuntimeState{Name: arg.Name, Value: arg.Value, UpdatedAt: m.now()}
+	m.runtimeState[arg.Name] = row
+	return row, nil
+}
+
+func (m *Memory) GetRuntimeState(ctx context.Context, name string) (database.RuntimeState, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	row, ok := m.runtimeState[name]
+	if !ok {
+		return database.RuntimeState{}, sql.ErrNoRows
+	}
+	return row, nil
+}
+
+func (m *Memory) DeleteRuntimeState(ctx context.Context, name string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.runtimeState[name]; !ok {
+		return 0, nil
+	}
+	delete(m.runtimeState, name)
+	return 1, nil
+}
+
+func (m *Memory) CreateActivityPubKey(ctx context.Context, arg database.CreateActivityPubKeyParams) (database.ActivitypubKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.ActivitypubKey{}, fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if key, ok := m.apKeys[arg.UserID]; ok {
+		return key, nil
+	}
+	key := database.ActivitypubKey{UserID: arg.UserID, CreatedAt: m.now(), PublicKeyPem: arg.PublicKeyPem, PrivateKeyPem: arg.PrivateKeyPem}
+	m.apKeys[arg.UserID] = key
+	return key, nil
+}
+
+func (m *Memory) GetActivityPubKey(ctx context.Context, userID uuid.UUID) (database.ActivitypubKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, ok := m.apKeys[userID]
+	if !ok {
+		return database.ActivitypubKey{}, sql.ErrNoRows
+	}
+	return key, nil
+}
+
+func (m *Memory) ListActivityPubKeysAfter(ctx context.Context, arg database.ListActivityPubKeysAfterParams) ([]database.ActivitypubKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return pageAfter(m.apKeys, arg.UserID, arg.Limit), nil
+}
+
+func (m *Memory) RestoreActivityPubKey(ctx context.Context, arg database.RestoreActivityPubKeyParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.apKeys[arg.UserID]; ok {
+		return &UniqueViolation{Constraint: "activitypub_keys_pkey"}
+	}
+	m.apKeys[arg.UserID] = database.ActivitypubKey(arg)
+	return nil
+}
+
+type apFollowerKey struct {
+	userID  uuid.UUID
+	actorID string
+}
+
+func (m *Memory) AddActivityPubFollower(ctx context.Context, arg database.AddActivityPubFollowerParams) (database.ActivitypubFollower, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return database.ActivitypubFollower{}, fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	key := apFollowerKey{arg.UserID, arg.ActorID}
+	follower, ok := m.apFollowers[key]
+	if !ok {
+		follower = database.ActivitypubFollower{UserID: arg.UserID, ActorID: arg.ActorID, CreatedAt: m.now()}
+	}
+	follower.Inbox, follower.SharedInbox, follower.FollowID = arg.Inbox, arg.SharedInbox, arg.FollowID
+	m.apFollowers[key] = follower
+	return follower, nil
+}
+
+func (m *Memory) DeleteActivityPubFollower(ctx context.Context, arg database.DeleteActivityPubFollowerParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := apFollowerKey{arg.UserID, arg.ActorID}
+	if _, ok := m.apFollowers[key]; !ok {
+		return 0, nil
+	}
+	delete(m.apFollowers, key)
+	return 1, nil
+}
+
+func (m *Memory) CountActivityPubFollowers(ctx context.Context, userID uuid.UUID) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countMatching(maps.Values(m.apFollowers), func(follower database.ActivitypubFollower) bool {
+		return follower.UserID == userID
+	}), nil
+}
+
+func (m *Memory) ListActivityPubFollowersAfter(ctx context.Context, arg database.ListActivityPubFollowersAfterParams) ([]database.ActivitypubFollower, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return pageAfterFunc(m.apFollowers, func(a, b apFollowerKey) int {
+		return cmp.Or(bytes.Compare(a.userID[:], b.userID[:]), cmp.Compare(a.actorID, b.actorID))
+	}, apFollowerKey{arg.UserID, arg.ActorID}, arg.RowLimit), nil
+}
+
+func (m *Memory) RestoreActivityPubFollower(ctx context.Context, arg database.RestoreActivityPubFollowerParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	key := apFollowerKey{arg.UserID, arg.ActorID}
+	if _, ok := m.apFollowers[key]; ok {
+		return &UniqueViolation{Constraint: "activitypub_followers_pkey"}
+	}
+	m.apFollowers[key] = database.ActivitypubFollower(arg)
+	return nil
+}
+
+func (m *Memory) ListActivityPubInboxes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if _, banned := m.shadowbans[userID]; banned {
+		return nil, nil
+	}
+	var inboxes []string
+	for _, follower := range m.apFollowers {
+		if follower.UserID != userID {
+			continue
+		}
+		inbox := cmp.Or(follower.SharedInbox, follower.Inbox)
+		if !slices.Contains(inboxes, inbox) {
+			inboxes = append(inboxes, inbox)
+		}
+	}
+	slices.Sort(inboxes)
+	return inboxes, nil
+}
+
+type favouriteKey struct {
+	userID  uuid.UUID
+	chirpID uuid.UUID
+}
+
+func (m *Memory) FavouriteChirp(ctx context.Context, arg database.FavouriteChirpParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return 0, fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.chirps[arg.ChirpID]; !ok {
+		return 0, fmt.Errorf("chirp %s does not exist", arg.ChirpID)
+	}
+	key := favouriteKey{arg.UserID, arg.ChirpID}
+	if _, ok := m.favourites[key]; ok {
+		return 0, nil
+	}
+	m.favourites[key] = database.ChirpFavourite{UserID: arg.UserID, ChirpID: arg.ChirpID, CreatedAt: m.now()}
+	return 1, nil
+}
+
+func (m *Memory) UnfavouriteChirp(ctx context.Context, arg database.UnfavouriteChirpParams) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := favouriteKey{arg.UserID, arg.ChirpID}
+	if _, ok := m.favourites[key]; !ok {
+		return 0, nil
+	}
+	delete(m.favourites, key)
+	return 1, nil
+}
+
+func (m *Memory) ListChirpFavouritesAfter(ctx context.Context, arg database.ListChirpFavouritesAfterParams) ([]database.ChirpFavourite, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	// favourites of deleted chirps are left in place, see below, and not backed up
+	favourites := maps.Clone(m.favourites)
+	maps.DeleteFunc(favourites, func(key favouriteKey, _ database.ChirpFavourite) bool {
+		_, ok := m.chirps[key.chirpID]
+		return !ok
+	})
+	return pageAfterFunc(favourites, func(a, b favouriteKey) int {
+		return cmp.Or(bytes.Compare(a.userID[:], b.userID[:]), bytes.Compare(a.chirpID[:], b.chirpID[:]))
+	}, favouriteKey{arg.UserID, arg.ChirpID}, arg.RowLimit), nil
+}
+
+func (m *Memory) RestoreChirpFavourite(ctx context.Context, arg database.RestoreChirpFavouriteParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.chirps[arg.ChirpID]; !ok {
+		return fmt.Errorf("chirp %s does not exist", arg.ChirpID)
+	}
+	key := favouriteKey{arg.UserID, arg.ChirpID}
+	if _, ok := m.favourites[key]; ok {
+		return &UniqueViolation{Constraint: "chirp_favourites_pkey"}
+	}
+	m.favourites[key] = database.ChirpFavourite(arg)
+	return nil
+}
+
+// Favourites of deleted chirps are left in place rather than cascaded, so the reads
+// below skip them.
+
+func (m *Memory) CountChirpFavourites(ctx context.Context, ids string) ([]database.CountChirpFavouritesRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chirpIDs := parseIDList(ids)
+	counts := make(map[uuid.UUID]int64)
+	for key := range m.favourites {
+		if _, ok := m.chirps[key.chirpID]; ok && chirpIDs[key.chirpID] {
+			counts[key.chirpID]++
+		}
+	}
+	var rows []database.CountChirpFavouritesRow
+	for id, count := range counts {
+		rows = append(rows, database.CountChirpFavouritesRow{ChirpID: id, Favourites: count})
+	}
+	return rows, nil
+}
+
+func (m *Memory) ListFavouritedChirpIds(ctx context.Context, arg database.ListFavouritedChirpIdsParams) ([]uuid.UUID, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chirpIDs := parseIDList(arg.Ids)
+	var ids []uuid.UUID
+	for key := range m.favourites {
+		if _, ok := m.chirps[key.chirpID]; ok && key.userID == arg.UserID && chirpIDs[key.chirpID] {
+			ids = append(ids, key.chirpID)
+		}
+	}
+	return ids, nil
+}
+
+func (m *Memory) ListFavouriteChirps(ctx context.Context, arg database.ListFavouriteChirpsParams) ([]database.ListFavouriteChirpsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rows []database.ListFavouriteChirpsRow
+	for key, favourite := range m.favourites {
+		chirp, ok := m.chirps[key.chirpID]
+		if !ok || key.userID != arg.UserID || chirp.TenantID != arg.TenantID || !favourite.CreatedAt.Before(arg.Before) || !m.visibleTo(chirp, arg.UserID) {
+			continue
+		}
+		rows = append(rows, database.ListFavouriteChirpsRow{
+			ID:           chirp.ID,
+			CreatedAt:    chirp.CreatedAt,
+			UpdatedAt:    chirp.UpdatedAt,
+			Body:         chirp.Body,
+			UserID:       chirp.UserID,
+			TenantID:     chirp.TenantID,
+			FavouritedAt: favourite.CreatedAt,
+		})
+	}
+	slices.SortFunc(rows, func(a, b database.ListFavouriteChirpsRow) int {
+		return cmp.Or(b.FavouritedAt.Compare(a.FavouritedAt), cmp.Compare(a.ID.String(), b.ID.String()))
+	})
+	return rows[:min(len(rows), max(int(arg.MaxResults), 0))], nil
+}
+
+func (m *Memory) CreateOAuthApp(ctx context.Context, arg database.CreateOAuthAppParams) (database.OauthApp, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.oauthApps[arg.ClientID]; ok {
+		return database.OauthApp{}, &UniqueViolation{Constraint: "oauth_apps_client_id_key"}
+	}
+	app := database.OauthApp{
+		ID:           uuid.New(),
+		CreatedAt:    m.now(),
+		ClientID:     arg.ClientID,
+		ClientSecret: arg.ClientSecret,
+		Name:         arg.Name,
+		Website:      arg.Website,
+		RedirectUris: arg.RedirectUris,
+		Scopes:       arg.Scopes,
+	}
+	m.oauthApps[app.ClientID] = app
+	return app, nil
+}
+
+func (m *Memory) GetOAuthApp(ctx context.Context, clientID string) (database.OauthApp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	app, ok := m.oauthApps[clientID]
+	if !ok {
+		return database.OauthApp{}, sql.ErrNoRows
+	}
+	return app, nil
+}
+
+func (m *Memory) ListOAuthAppsAfter(ctx context.Context, arg database.ListOAuthAppsAfterParams) ([]database.OauthApp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	apps := make(map[uuid.UUID]database.OauthApp, len(m.oauthApps))
+	for _, app := range m.oauthApps {
+		apps[app.ID] = app
+	}
+	return pageAfter(apps, arg.ID, arg.Limit), nil
+}
+
+func (m *Memory) RestoreOAuthApp(ctx context.Context, arg database.RestoreOAuthAppParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if app, ok := m.oauthApps[arg.ClientID]; ok && app.ID != arg.ID {
+		return &UniqueViolation{Constraint: "oauth_apps_client_id_key"}
+	}
+	// like the upsert, the app with the same id is replaced, client id and all
+	for clientID, app := range m.oauthApps {
+		if app.ID == arg.ID {
+			delete(m.oauthApps, clientID)
+		}
+	}
+	m.oauthApps[arg.ClientID] = database.OauthApp(arg)
+	return nil
+}
+
+func (m *Memory) CreateOAuthCode(ctx context.Context, arg database.CreateOAuthCodeParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.oauthApps[arg.ClientID]; !ok {
+		return fmt.Errorf("oauth app %s does not exist", arg.ClientID)
+	}
+	if _, ok := m.oauthCodes[arg.CodeHash]; ok {
+		return &UniqueViolation{Constraint: "oauth_codes_pkey"}
+	}
+	m.oauthCodes[arg.CodeHash] = database.OauthCode{
+		CodeHash:    arg.CodeHash,
+		CreatedAt:   m.now(),
+		ExpiresAt:   arg.ExpiresAt,
+		UserID:      arg.UserID,
+		ClientID:    arg.ClientID,
+		RedirectUri: arg.RedirectUri,
+		Scopes:      arg.Scopes,
+	}
+	return nil
+}
+
+func (m *Memory) ConsumeOAuthCode(ctx context.Context, arg database.ConsumeOAuthCodeParams) (database.OauthCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code, ok := m.oauthCodes[arg.CodeHash]
+	if !ok || !code.ExpiresAt.After(arg.ExpiresAt) {
+		return database.OauthCode{}, sql.ErrNoRows
+	}
+	delete(m.oauthCodes, arg.CodeHash)
+	return code, nil
+}
+
+func (m *Memory) DeleteExpiredOAuthCodes(ctx context.Context, expiresAt time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var deleted int64
+	for hash, code := range m.oauthCodes {
+		if code.ExpiresAt.Before(expiresAt) {
+			delete(m.oauthCodes, hash)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *Memory) CreateOAuthToken(ctx context.Context, arg database.CreateOAuthTokenParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.oauthApps[arg.ClientID]; !ok {
+		return fmt.Errorf("oauth app %s does not exist", arg.ClientID)
+	}
+	if _, ok := m.oauthTokens[arg.TokenHash]; ok {
+		return &UniqueViolation{Constraint: "oauth_tokens_pkey"}
+	}
+	m.oauthTokens[arg.TokenHash] = database.OauthToken{
+		TokenHash: arg.TokenHash,
+		CreatedAt: m.now(),
+		UserID:    arg.UserID,
+		ClientID:  arg.ClientID,
+		Scopes:    arg.Scopes,
+	}
+	return nil
+}
+
+func (m *Memory) ListOAuthTokensAfter(ctx context.Context, arg database.ListOAuthTokensAfterParams) ([]database.OauthToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return pageAfterFunc(m.oauthTokens, strings.Compare, arg.TokenHash, arg.Limit), nil
+}
+
+func (m *Memory) RestoreOAuthToken(ctx context.Context, arg database.RestoreOAuthTokenParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.oauthApps[arg.ClientID]; !ok {
+		return fmt.Errorf("oauth app %s does not exist", arg.ClientID)
+	}
+	if _, ok := m.oauthTokens[arg.TokenHash]; ok {
+		return &UniqueViolation{Constraint: "oauth_tokens_pkey"}
+	}
+	m.oauthTokens[arg.TokenHash] = database.OauthToken(arg)
+	return nil
+}
+
+func (m *Memory) GetOAuthToken(ctx context.Context, tokenHash string) (database.OauthToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	token, ok := m.oauthTokens[tokenHash]
+	if !ok {
+		return database.OauthToken{}, sql.ErrNoRows
+	}
+	return token, nil
+}
+
+func (m *Memory) RevokeOAuthToken(ctx context.Context, arg database.RevokeOAuthTokenParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if token, ok := m.oauthTokens[arg.TokenHash]; ok && token.ClientID == arg.ClientID {
+		delete(m.oauthTokens, arg.TokenHash)
+	}
+	return nil
+}
+
+type sitemapPageKey struct {
+	tenantID uuid.UUID
+	kind     string
+	day      int64
+}
+
+func (m *Memory) ListSitemapPages(ctx context.Context, tenantID uuid.UUID) ([]database.SitemapPage, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var pages []database.SitemapPage
+	for _, page := range m.sitemapPages {
+		if page.TenantID == tenantID {
+			pages = append(pages, page)
+		}
+	}
+	slices.SortFunc(pages, func(a, b database.SitemapPage) int {
+		return cmp.Or(cmp.Compare(a.Kind, b.Kind), a.Day.Compare(b.Day))
+	})
+	return pages, nil
+}
+
+func (m *Memory) UpsertSitemapPage(ctx context.Context, arg database.UpsertSitemapPageParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sitemapPages[sitemapPageKey{arg.TenantID, arg.Kind, arg.Day.UnixNano()}] = database.SitemapPage{
+		TenantID:     arg.TenantID,
+		Kind:         arg.Kind,
+		Day:          arg.Day,
+		Urls:         arg.Urls,
+		LastModified: arg.LastModified,
+	}
+	return nil
+}
+
+func (m *Memory) DeleteSitemapPage(ctx context.Context, arg database.DeleteSitemapPageParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sitemapPages, sitemapPageKey{arg.TenantID, arg.Kind, arg.Day.UnixNano()})
+	return nil
+}
+
+func (m *Memory) ListSitemapChirps(ctx context.Context, arg database.ListSitemapChirpsParams) ([]database.ListSitemapChirpsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chirps := m.sortedChirps(func(c database.Chirp) bool {
+		return c.TenantID == arg.TenantID && !c.CreatedAt.Before(arg.Since) && c.CreatedAt.Before(arg.Until) && m.visibleTo(c, uuid.Nil)
+	})
+	var rows []database.ListSitemapChirpsRow
+	for _, chirp := range offsetPage(chirps, arg.RowOffset, arg.RowLimit) {
+		rows = append(rows, database.ListSitemapChirpsRow{ID: chirp.ID, UpdatedAt: chirp.UpdatedAt})
+	}
+	return rows, nil
+}
+
+func (m *Memory) ListSitemapUsers(ctx context.Context, arg database.ListSitemapUsersParams) ([]database.ListSitemapUsersRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var users []database.User
+	for _, user := range m.users {
+		_, banned := m.shadowbans[user.ID]
+		if user.TenantID == arg.TenantID && !user.CreatedAt.Before(arg.Since) && user.CreatedAt.Before(arg.Until) && !banned {
+			users = append(users, user)
+		}
+	}
+	slices.SortFunc(users, func(a, b database.User) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID.String(), b.ID.String()))
+	})
+	var rows []database.ListSitemapUsersRow
+	for _, user := range offsetPage(users, arg.RowOffset, arg.RowLimit) {
+		rows = append(rows, database.ListSitemapUsersRow{ID: user.ID, UpdatedAt: user.UpdatedAt})
+	}
+	return rows, nil
+}
+
+func (m *Memory) ListChirpsUpdatedSince(ctx context.Context, updatedAt time.Time) ([]database.ListChirpsUpdatedSinceRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rows []database.ListChirpsUpdatedSinceRow
+	for _, chirp := range m.chirps {
+		if !chirp.UpdatedAt.Before(updatedAt) {
+			rows = append(rows, database.ListChirpsUpdatedSinceRow{TenantID: chirp.TenantID, CreatedAt: chirp.CreatedAt})
+		}
+	}
+	return rows, nil
+}
+
+func (m *Memory) ListUsersUpdatedSince(ctx context.Context, updatedAt time.Time) ([]database.ListUsersUpdatedSinceRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rows []database.ListUsersUpdatedSinceRow
+	for _, user := range m.users {
+		if !user.UpdatedAt.Before(updatedAt) {
+			rows = append(rows, database.ListUsersUpdatedSinceRow{TenantID: user.TenantID, CreatedAt: user.CreatedAt})
+		}
+	}
+	return rows, nil
+}
+
+type importBatchKey struct {
+	jobID uuid.UUID
+	seq   int32
+}
+
+func (m *Memory) CreateTwitterImportBatch(ctx context.Context, arg database.CreateTwitterImportBatchParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.jobs[arg.JobID]; !ok {
+		return fmt.Errorf("job %s does not exist", arg.JobID)
+	}
+	key := importBatchKey{arg.JobID, arg.Seq}
+	if _, ok := m.importBatches[key]; ok {
+		return &UniqueViolation{Constraint: "twitter_import_batches_pkey"}
+	}
+	m.importBatches[key] = arg.Tweets
+	return nil
+}
+
+func (m *Memory) GetTwitterImportBatch(ctx context.Context, arg database.GetTwitterImportBatchParams) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	tweets, ok := m.importBatches[importBatchKey{arg.JobID, arg.Seq}]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return tweets, nil
+}
+
+func (m *Memory) DeleteTwitterImportBatches(ctx context.Context, jobID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	maps.DeleteFunc(m.importBatches, func(key importBatchKey, _ string) bool { return key.jobID == jobID })
+	return nil
+}
+
+func (m *Memory) CreateDigestSubscription(ctx context.Context, userID uuid.UUID) (database.DigestSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[userID]; !ok {
+		return database.DigestSubscription{}, fmt.Errorf("user %s does not exist", userID)
+	}
+	if sub, ok := m.digests[userID]; ok {
+		return sub, nil
+	}
+	now := m.now()
+	sub := database.DigestSubscription{UserID: userID, CreatedAt: now, LastSentAt: now}
+	m.digests[userID] = sub
+	return sub, nil
+}
+
+func (m *Memory) GetDigestSubscription(ctx context.Context, userID uuid.UUID) (database.DigestSubscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	sub, ok := m.digests[userID]
+	if !ok {
+		return database.DigestSubscription{}, sql.ErrNoRows
+	}
+	return sub, nil
+}
+
+func (m *Memory) DeleteDigestSubscription(ctx context.Context, userID uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.digests, userID)
+	return nil
+}
+
+func (m *Memory) ListDigestSubscriptionsAfter(ctx context.Context, arg database.ListDigestSubscriptionsAfterParams) ([]database.DigestSubscription, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return pageAfter(m.digests, arg.UserID, arg.Limit), nil
+}
+
+func (m *Memory) RestoreDigestSubscription(ctx context.Context, arg database.RestoreDigestSubscriptionParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[arg.UserID]; !ok {
+		return fmt.Errorf("user %s does not exist", arg.UserID)
+	}
+	if _, ok := m.digests[arg.UserID]; ok {
+		return &UniqueViolation{Constraint: "digest_subscriptions_pkey"}
+	}
+	m.digests[arg.UserID] = database.DigestSubscription(arg)
+	return nil
+}
+
+func (m *Memory) ListDueDigestSubscriptions(ctx context.Context, arg database.ListDueDigestSubscriptionsParams) ([]database.ListDueDigestSubscriptionsRow, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rows []database.ListDueDigestSubscriptionsRow
+	for _, sub := range m.digests {
+		user, ok := m.users[sub.UserID]
+		if ok && sub.LastSentAt.Before(arg.SentBefore) {
+			rows = append(rows, database.ListDueDigestSubscriptionsRow{UserID: sub.UserID, LastSentAt: sub.LastSentAt, Email: user.Email, TenantID: user.TenantID})
+		}
+	}
+	slices.SortFunc(rows, func(a, b database.ListDueDigestSubscriptionsRow) int {
+		return cmp.Compare(a.UserID.String(), b.UserID.String())
+	})
+	return rows[:min(len(rows), int(arg.MaxRows))], nil
+}
+
+func (m *Memory) MarkDigestSent(ctx context.Context, arg database.MarkDigestSentParams) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if sub, ok := m.digests[arg.UserID]; ok {
+		sub.LastSentAt = arg.LastSentAt
+		m.digests[arg.UserID] = sub
+	}
+	return nil
+}
+
+func (m *Memory) ListDigestChirps(ctx context.Context, arg database.ListDigestChirpsParams) ([]database.Chirp, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	chirps := m.sortedChirps(func(c database.Chirp) bool {
+		return c.TenantID == arg.TenantID && c.UserID != arg.UserID && !c.CreatedAt.Before(arg.Since) && c.CreatedAt.Before(arg.Until) && m.visibleTo(c, uuid.Nil)
+	})
+	slices.Reverse(chirps)
+	return chirps[:min(len(chirps), int(arg.MaxChirps))], nil
+}
+
+type memorySnapshot struct {
+	tenants       map[uuid.UUID]database.Tenant
+	users         map[uuid.UUID]database.User
+	chirps        map[uuid.UUID]database.Chirp
+	refreshTokens map[string]database.RefreshToken
+	featureFlags  map[string]database.FeatureFlag
+	visits        map[string]int64
+	auditLog      []database.AuditLog
+	jobs          map[uuid.UUID]database.Job
+	scheduledRuns map[scheduledRunKey]database.ScheduledRun
+	webhooks      map[uuid.UUID]database.Webhook
+	deliveries    []database.WebhookDelivery
+	outbox        []database.OutboxEvent
+	idempotency   map[idempotencyKey]database.IdempotencyKey
+	rateLimits    map[string]int64
+	quotaUsage    map[quotaUsageKey]int64
+	apiKeys       map[uuid.UUID]database.ApiKey
+	apiKeyUsage   map[apiKeyUsageKey]int64
+	reports       []database.ChirpReport
+	decisions     []database.ModerationDecision
+	wordFilters   map[uuid.UUID]database.WordFilter
+	shadowbans    map[uuid.UUID]database.Shadowban
+	ipBans        map[uuid.UUID]database.IpBan
+	automodRules  map[uuid.UUID]database.AutomodRule
+	ruleVersions  []database.AutomodRuleVersion
+	restrictions  map[uuid.UUID]database.AutomodRestriction
+	statsRollups  map[statsRollupKey]database.StatsRollup
+	runtimeState  map[string]database.RuntimeState
+	apKeys        map[uuid.UUID]database.ActivitypubKey
+	apFollowers   map[apFollowerKey]database.ActivitypubFollower
+	favourites    map[favouriteKey]database.ChirpFavourite
+	oauthApps     map[string]database.OauthApp
+	oauthCodes    map[string]database.OauthCode
+	oauthTokens   map[string]database.OauthToken
+	sitemapPages  map[sitemapPageKey]database.SitemapPage
+	importBatches map[importBatchKey]string
+	digests       map[uuid.UUID]database.DigestSubscription
+}
+
+func (m *Memory) snapshot() memorySnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return memorySnapshot{
+		tenants:       maps.Clone(m.tenants),
+		users:         maps.Clone(m.users),
+		chirps:        maps.Clone(m.chirps),
+		refreshTokens: maps.Clone(m.refreshTokens),
+		featureFlags:  maps.Clone(m.featureFlags),
+		visits:        maps.Clone(m.visits),
+		auditLog:      slices.Clone(m.auditLog),
+		jobs:          maps.Clone(m.jobs),
+		scheduledRuns: maps.Clone(m.scheduledRuns),
+		webhooks:      maps.Clone(m.webhooks),
+		deliveries:    slices.Clone(m.deliveries),
+		outbox:        slices.Clone(m.outbox),
+		idempotency:   maps.Clone(m.idempotency),
+		rateLimits:    maps.Clone(m.rateLimits),
+		quotaUsage:    maps.Clone(m.quotaUsage),
+		apiKeys:       maps.Clone(m.apiKeys),
+		apiKeyUsage:   maps.Clone(m.apiKeyUsage),
+		reports:       slices.Clone(m.reports),
+		decisions:     slices.Clone(m.decisions),
+		wordFilters:   maps.Clone(m.wordFilters),
+		shadowbans:    maps.Clone(m.shadowbans),
+		ipBans:        maps.Clone(m.ipBans),
+		automodRules:  maps.Clone(m.automodRules),
+		ruleVersions:  slices.Clone(m.ruleVersions),
+		restrictions:  maps.Clone(m.restrictions),
+		statsRollups:  maps.Clone(m.statsRollups),
+		runtimeState:  maps.Clone(m.runtimeState),
+		apKeys:        maps.Clone(m.apKeys),
+		apFollowers:   maps.Clone(m.apFollowers),
+		favourites:    maps.Clone(m.favourites),
+		oauthApps:     maps.Clone(m.oauthApps),
+		oauthCodes:    maps.Clone(m.oauthCodes),
+		oauthTokens:   maps.Clone(m.oauthTokens),
+		sitemapPages:  maps.Clone(m.sitemapPages),
+		importBatches: maps.Clone(m.importBatches),
+		digests:       maps.Clone(m.digests),
+	}
+}
+
+func (m *Memory) restore(s memorySnapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users, m.chirps, m.refreshTokens, m.featureFlags, m.visits = s.users, s.chirps, s.refreshTokens, s.featureFlags, s.visits
+	m.auditLog, m.jobs, m.scheduledRuns, m.webhooks, m.deliveries = s.auditLog, s.jobs, s.scheduledRuns, s.webhooks, s.deliveries
+	m.outbox, m.idempotency, m.tenants, m.rateLimits, m.runtimeState = s.outbox, s.idempotency, s.tenants, s.rateLimits, s.runtimeState
+	m.quotaUsage, m.reports, m.decisions, m.wordFilters, m.shadowbans = s.quotaUsage, s.reports, s.decisions, s.wordFilters, s.shadowbans
+	m.ipBans, m.automodRules, m.ruleVersions, m.restrictions, m.statsRollups = s.ipBans, s.automodRules, s.ruleVersions, s.restrictions, s.statsRollups
+	m.apKeys, m.apFollowers, m.favourites, m.oauthApps, m.oauthCodes = s.apKeys, s.apFollowers, s.favourites, s.oauthApps, s.oauthCodes
+	m.sitemapPages, m.apiKeys, m.apiKeyUsage, m.importBatches, m.oauthTokens = s.sitemapPages, s.apiKeys, s.apiKeyUsage, s.importBatches, s.oauthTokens
+	m.digests = s.digests
+}
+
+// sortedChirps returns matching chirps oldest first, like ORDER BY created_at ASC.
+// Callers must hold the lock.
+func (m *Memory) sortedChirps(match func(database.Chirp) bool) []database.Chirp {
+	var chirps []database.Chirp
+	for _, chirp := range m.chirps {
+		if match(chirp) {
+			chirps = append(chirps, chirp)
+		}
+	}
+	slices.SortFunc(chirps, func(a, b database.Chirp) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), cmp.Compare(a.ID.String(), b.ID.String()))
+	})
+	return chirps
+}
+
+// pageAfter returns up to limit rows keyed after the id after, lowest id first, like
+// WHERE id > $1 ORDER BY id ASC LIMIT $2. Callers must hold the lock.
+func pageAfter[T any](rows map[uuid.UUID]T, after uuid.UUID, limit int32) []T {
+	return pageAfterFunc(rows, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) }, after, limit)
+}
+
+// pageAfterFunc is pageAfter for rows keyed by something other than a uuid, such as the
+// columns of a composite primary key, ordered by compare
+func pageAfterFunc[K comparable, T any](rows map[K]T, compare func(a, b K) int, after K, limit int32) []T {
+	keys := slices.SortedFunc(maps.Keys(rows), compare)
+	start := 0
+	for start < len(keys) && compare(keys[start], after) <= 0 {
+		start++
+	}
+	keys = keys[start:min(len(keys), start+max(int(limit), 0))]
+	page := make([]T, len(keys))
+	for i, key := range keys {
+		page[i] = rows[key]
+	}
+	return page
+}
+
+// offsetPage returns up to limit rows after skipping offset, like LIMIT $1 OFFSET $2
+func offsetPage[T any](rows []T, offset, limit int32) []T {
+	start := min(max(int(offset), 0), len(rows))
+	return rows[start:min(len(rows), start+max(int(limit), 0))]
+}
+
+// countMatching counts the rows for which match is true. Callers must hold the lock.
+func countMatching[T any](rows iter.Seq[T], match func(T) bool) int64 {
+	var count int64
+	for row := range rows {
+		if match(row) {
+			count++
+		}
+	}
+	return count
+}
+
+// searchNewest returns up to limit matching rows, newest first like the search queries
+func searchNewest[T any](rows iter.Seq[T], limit int32, match func(T) bool, createdAt func(T) time.Time) []T {
+	var found []T
+	for row := range rows {
+		if match(row) {
+			found = append(found, row)
+		}
+	}
+	slices.SortFunc(found, func(a, b T) int { return createdAt(b).Compare(createdAt(a)) })
+	return found[:min(len(found), int(limit))]
+}
+
+// likeMatcher reports whether a string matches a LIKE pattern with ESCAPE '\': % is
+// any run of characters, _ any one character, and \ makes the next one literal
+func likeMatcher(pattern string) func(string) bool {
+	var expr strings.Builder
+	expr.WriteString("^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			expr.WriteString("(?s:.*)")
+		case r == '_':
+			expr.WriteString("(?s:.)")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String()).MatchString
+}
+
+// parseIDList reads the comma separated ID lists the batch queries take, skipping
+// entries that are not UUIDs just as the SQL comparison never matches them
+func parseIDList(ids string) map[uuid.UUID]bool {
+	set := make(map[uuid.UUID]bool)
+	for _, part := range strings.Split(ids, ",") {
+		if id, err := uuid.Parse(part); err == nil {
+			set[id] = true
+		}
+	}
+	return set
+}
+
+// emailTaken reports whether another user of the tenant already has email. Callers
+// must hold the lock.
+func (m *Memory) emailTaken(tenantID uuid.UUID, email string, except uuid.UUID) bool {
+	for id, user := range m.users {
+		if user.TenantID == tenantID && user.Email == email && id != except {
+			return true
+		}
+	}
+	return false
+}
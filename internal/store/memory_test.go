@@ -0,0 +1,107 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+)
+
+func TestMemory_WithTxRollsBackOnError(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	errFailed := errors.New("second step failed")
+
+	err := m.WithTx(ctx, func(tx Store) error {
+		_, err := tx.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+		if err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("Expected the callback error, got %v", err)
+	}
+	_, err = m.GetUserByEmail(ctx, database.GetUserByEmailParams{Email: "a@example.com"})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected user creation to be rolled back, got %v", err)
+	}
+}
+
+func TestMemory_WithTxCommits(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+
+	err := m.WithTx(ctx, func(tx Store) error {
+		user, err := tx.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+		if err != nil {
+			return err
+		}
+		_, err = tx.CreateChirp(ctx, database.CreateChirpParams{Body: "hello", UserID: user.ID})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	chirps, _ := m.GetChirps(ctx, database.GetChirpsParams{})
+	if len(chirps) != 1 {
+		t.Fatalf("Expected 1 committed chirp, got %d", len(chirps))
+	}
+}
+
+func TestMemory_DeleteUsersCascades(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	user, _ := m.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	m.CreateChirp(ctx, database.CreateChirpParams{Body: "hello", UserID: user.ID})
+
+	m.DeleteUsers(ctx)
+	chirps, _ := m.GetChirps(ctx, database.GetChirpsParams{})
+	if len(chirps) != 0 {
+		t.Fatalf("Expected chirps to be deleted with their users, got %d", len(chirps))
+	}
+}
+
+func TestMemory_TenantsAreIsolated(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	acme, err := m.CreateTenant(ctx, database.CreateTenantParams{Slug: "acme", Name: "Acme"})
+	if err != nil {
+		t.Fatalf("Expected no error creating tenant, got %v", err)
+	}
+	user, _ := m.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x"})
+	if _, err := m.CreateUser(ctx, database.CreateUserParams{Email: "a@example.com", HashedPassword: "x", TenantID: acme.ID}); err != nil {
+		t.Fatalf("Expected the same email to register in another tenant, got %v", err)
+	}
+	if _, err := m.CreateChirp(ctx, database.CreateChirpParams{Body: "hello", UserID: user.ID, TenantID: acme.ID}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected no chirp for an author outside the tenant, got %v", err)
+	}
+	chirp, _ := m.CreateChirp(ctx, database.CreateChirpParams{Body: "hello", UserID: user.ID})
+	if _, err := m.GetChirpById(ctx, database.GetChirpByIdParams{ID: chirp.ID, TenantID: acme.ID}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("Expected another tenant's chirp to be missing, got %v", err)
+	}
+	if chirps, _ := m.GetChirps(ctx, database.GetChirpsParams{TenantID: acme.ID}); len(chirps) != 0 {
+		t.Fatalf("Expected no chirps in the new tenant, got %d", len(chirps))
+	}
+}
+
+func TestMemory_SearchUsersMatchesLikePatterns(t *testing.T) {
+	m := NewMemory()
+	ctx := context.Background()
+	m.CreateUser(ctx, database.CreateUserParams{Email: "a_b@example.com", HashedPassword: "x"})
+	m.CreateUser(ctx, database.CreateUserParams{Email: "axb@example.com", HashedPassword: "x"})
+
+	cases := map[string]int{`%a\_b%`: 1, `%a_b%`: 2, `a%`: 2, `%example`: 0, `%`: 2}
+	for pattern, want := range cases {
+		users, _ := m.SearchUsers(ctx, database.SearchUsersParams{Pattern: pattern, MaxResults: 10})
+		if len(users) != want {
+			t.Errorf("Expected %s to match %d users, got %d", pattern, want, len(users))
+		}
+	}
+	users, _ := m.SearchUsers(ctx, database.SearchUsersParams{Pattern: "%", MaxResults: 1})
+	if len(users) != 1 || users[0].Email != "axb@example.com" {
+		t.Errorf("Expected only the newest user, got %+v", users)
+	}
+}
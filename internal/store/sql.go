@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+)
+
+// SQL is the Store backed by the sqlc queries, for both Postgres and SQLite
+type SQL struct {
+	*database.Queries
+	db   *sql.DB
+	wrap func(database.DBTX) database.DBTX
+	// set on the Store handed to a WithTx callback
+	conn *sql.Conn
+	tx   *sql.Tx
+}
+
+// NewSQL builds a Store on db. wrap, if non-nil, decorates every connection or
+// transaction handed to sqlc, e.g. with tracing.
+func NewSQL(db *sql.DB, wrap func(database.DBTX) database.DBTX) *SQL {
+	if wrap == nil {
+		wrap = func(conn database.DBTX) database.DBTX { return conn }
+	}
+	return &SQL{Queries: database.New(wrap(db)), db: db, wrap: wrap}
+}
+
+var _ Store = (*SQL)(nil)
+
+// WithTx runs fn against a Store bound to a single transaction, committing if fn
+// succeeds and rolling back if it returns an error or panics. It shadows the
+// lower-level sqlc Queries.WithTx.
+func (s *SQL) WithTx(ctx context.Context, fn func(Store) error) error {
+	// the transaction runs on a connection of its own so SendBatch can reach the driver
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	txStore := &SQL{Queries: database.New(s.wrap(tx)), db: s.db, wrap: s.wrap, conn: conn, tx: tx}
+	if err := fn(txStore); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Snapshot runs fn against a Store bound to a read-only transaction. Postgres runs it at
+// REPEATABLE READ, so every query sees the database as of the first one; a SQLite read
+// transaction does that already, and holds the only connection until fn returns.
+func (s *SQL) Snapshot(ctx context.Context, fn func(Store) error) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(&SQL{Queries: database.New(s.wrap(tx)), db: s.db, wrap: s.wrap, conn: conn, tx: tx})
+}
@@ -0,0 +1,380 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// ChirpStore persists chirps
+type ChirpStore interface {
+	CreateChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error)
+	GetChirps(ctx context.Context, arg database.GetChirpsParams) ([]database.Chirp, error)
+	GetChirpById(ctx context.Context, arg database.GetChirpByIdParams) (database.Chirp, error)
+	GetChirpByIdAnyTenant(ctx context.Context, id uuid.UUID) (database.Chirp, error)
+	GetChirpsById(ctx context.Context, arg database.GetChirpsByIdParams) ([]database.Chirp, error)
+	GetChirpsByUserIds(ctx context.Context, arg database.GetChirpsByUserIdsParams) ([]database.Chirp, error)
+	ImportChirp(ctx context.Context, arg database.ImportChirpParams) (int64, error)
+	DeleteChirpById(ctx context.Context, arg database.DeleteChirpByIdParams) error
+	CountChirpsMatching(ctx context.Context, arg database.CountChirpsMatchingParams) (int64, error)
+	DeleteChirpsMatching(ctx context.Context, arg database.DeleteChirpsMatchingParams) (int64, error)
+	ListChirpsAfter(ctx context.Context, arg database.ListChirpsAfterParams) ([]database.Chirp, error)
+	RestoreChirp(ctx context.Context, arg database.RestoreChirpParams) error
+	ListChirpsPage(ctx context.Context, arg database.ListChirpsPageParams) ([]database.Chirp, error)
+	ListChirpsPageDesc(ctx context.Context, arg database.ListChirpsPageDescParams) ([]database.Chirp, error)
+	CountChirpsPage(ctx context.Context, arg database.CountChirpsPageParams) (int64, error)
+	SearchChirps(ctx context.Context, arg database.SearchChirpsParams) ([]database.Chirp, error)
+	UpdateChirpBody(ctx context.Context, arg database.UpdateChirpBodyParams) (database.Chirp, error)
+}
+
+// UserStore persists user accounts
+type UserStore interface {
+	CountUsersByTenant(ctx context.Context, tenantID uuid.UUID) (int64, error)
+	CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error)
+	DeleteUsers(ctx context.Context) error
+	GetUserByEmail(ctx context.Context, arg database.GetUserByEmailParams) (database.User, error)
+	GetUsersByIds(ctx context.Context, arg database.GetUsersByIdsParams) ([]database.User, error)
+	ListUsersAfter(ctx context.Context, arg database.ListUsersAfterParams) ([]database.User, error)
+	PutNewUserData(ctx context.Context, arg database.PutNewUserDataParams) (database.User, error)
+	RestoreUser(ctx context.Context, arg database.RestoreUserParams) error
+	SearchUsers(ctx context.Context, arg database.SearchUsersParams) ([]database.User, error)
+	UpgradeUserById(ctx context.Context, arg database.UpgradeUserByIdParams) (database.User, error)
+}
+
+// TenantStore persists the tenants users and chirps are partitioned by
+type TenantStore interface {
+	CreateTenant(ctx context.Context, arg database.CreateTenantParams) (database.Tenant, error)
+	GetTenantBySlug(ctx context.Context, slug string) (database.Tenant, error)
+	ListTenants(ctx context.Context) ([]database.Tenant, error)
+	RestoreTenant(ctx context.Context, arg database.RestoreTenantParams) error
+	SetTenantAdminToken(ctx context.Context, arg database.SetTenantAdminTokenParams) (database.Tenant, error)
+	UpdateTenant(ctx context.Context, arg database.UpdateTenantParams) (database.Tenant, error)
+}
+
+// RefreshTokenStore persists refresh tokens issued at login
+type RefreshTokenStore interface {
+	CreateRefreshToken(ctx context.Context, arg database.CreateRefreshTokenParams) (database.RefreshToken, error)
+	GetRefreshToken(ctx context.Context, token string) (database.RefreshToken, error)
+	GetUserFromRefreshToken(ctx context.Context, token string) (database.User, error)
+	RevokeRefreshToken(ctx context.Context, token string) error
+	RevokeRefreshTokensForUser(ctx context.Context, userID uuid.UUID) error
+	CountStaleRefreshTokens(ctx context.Context, before time.Time) (int64, error)
+	DeleteStaleRefreshTokens(ctx context.Context, before time.Time) (int64, error)
+}
+
+// FeatureFlagStore persists feature flag definitions
+type FeatureFlagStore interface {
+	UpsertFeatureFlag(ctx context.Context, arg database.UpsertFeatureFlagParams) (database.FeatureFlag, error)
+	GetFeatureFlags(ctx context.Context) ([]database.FeatureFlag, error)
+	GetFeatureFlag(ctx context.Context, name string) (database.FeatureFlag, error)
+	DeleteFeatureFlag(ctx context.Context, name string) error
+}
+
+// VisitStore persists daily file server hit counts
+type VisitStore interface {
+	AddVisits(ctx context.Context, arg database.AddVisitsParams) error
+	GetTotalVisits(ctx context.Context) (int64, error)
+	GetDailyVisits(ctx context.Context, limit int32) ([]database.Visit, error)
+	DeleteVisits(ctx context.Context) error
+}
+
+// StatsStore answers aggregate questions for the admin dashboard and keeps the
+// daily and weekly rollups behind /admin/stats
+type StatsStore interface {
+	GetDashboardCounts(ctx context.Context, since time.Time) (database.GetDashboardCountsRow, error)
+	GetStatsRollupCounts(ctx context.Context, arg database.GetStatsRollupCountsParams) (database.GetStatsRollupCountsRow, error)
+	UpsertStatsRollup(ctx context.Context, arg database.UpsertStatsRollupParams) (database.StatsRollup, error)
+	ListStatsRollups(ctx context.Context, arg database.ListStatsRollupsParams) ([]database.StatsRollup, error)
+}
+
+// AuditStore appends to and reads the administrative audit log. There is
+// deliberately no way to change or delete entries.
+type AuditStore interface {
+	CreateAuditEntry(ctx context.Context, arg database.CreateAuditEntryParams) (database.AuditLog, error)
+	ListAuditEntries(ctx context.Context, arg database.ListAuditEntriesParams) ([]database.AuditLog, error)
+}
+
+// JobStore persists background jobs for internal/jobs
+type JobStore interface {
+	EnqueueJob(ctx context.Context, arg database.EnqueueJobParams) (database.Job, error)
+	// EnqueueJobs inserts several jobs at once, in one round trip where the driver allows
+	EnqueueJobs(ctx context.Context, args []database.EnqueueJobParams) error
+	ClaimJob(ctx context.Context, arg database.ClaimJobParams) (database.Job, error)
+	CompleteJob(ctx context.Context, id uuid.UUID) error
+	RetryJob(ctx context.Context, arg database.RetryJobParams) error
+	BuryJob(ctx context.Context, arg database.BuryJobParams) error
+	GetJob(ctx context.Context, id uuid.UUID) (database.Job, error)
+	ListJobsByStatus(ctx context.Context, arg database.ListJobsByStatusParams) ([]database.Job, error)
+	SetJobProgress(ctx context.Context, arg database.SetJobProgressParams) error
+	RequeueDeadJob(ctx context.Context, id uuid.UUID) (database.Job, error)
+	CountFinishedJobs(ctx context.Context, before time.Time) (int64, error)
+	DeleteFinishedJobs(ctx context.Context, before time.Time) (int64, error)
+	CountJobsByStatus(ctx context.Context) ([]database.CountJobsByStatusRow, error)
+	GetOldestDueJob(ctx context.Context, arg database.GetOldestDueJobParams) (time.Time, error)
+}
+
+// ScheduleStore records runs of recurring tasks for internal/schedule. Claiming a
+// run is also how instances agree on which of them runs it.
+type ScheduleStore interface {
+	ClaimScheduledRun(ctx context.Context, arg database.ClaimScheduledRunParams) (database.ScheduledRun, error)
+	FinishScheduledRun(ctx context.Context, arg database.FinishScheduledRunParams) error
+	ListScheduledRuns(ctx context.Context, arg database.ListScheduledRunsParams) ([]database.ScheduledRun, error)
+	CountScheduledRunsBefore(ctx context.Context, before time.Time) (int64, error)
+	DeleteScheduledRunsBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// WebhookStore persists webhook subscriptions and their delivery log for internal/webhooks
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, arg database.CreateWebhookParams) (database.Webhook, error)
+	GetWebhook(ctx context.Context, id uuid.UUID) (database.Webhook, error)
+	ListWebhooksByUser(ctx context.Context, userID uuid.UUID) ([]database.Webhook, error)
+	ListWebhooksForEvent(ctx context.Context, arg database.ListWebhooksForEventParams) ([]database.Webhook, error)
+	ListWebhooksAfter(ctx context.Context, arg database.ListWebhooksAfterParams) ([]database.Webhook, error)
+	UpdateWebhook(ctx context.Context, arg database.UpdateWebhookParams) (database.Webhook, error)
+	DeleteWebhook(ctx context.Context, arg database.DeleteWebhookParams) (int64, error)
+	RestoreWebhook(ctx context.Context, arg database.RestoreWebhookParams) error
+	CreateWebhookDelivery(ctx context.Context, arg database.CreateWebhookDeliveryParams) error
+	ListWebhookDeliveries(ctx context.Context, arg database.ListWebhookDeliveriesParams) ([]database.WebhookDelivery, error)
+	CountWebhookDeliveriesBefore(ctx context.Context, before time.Time) (int64, error)
+	DeleteWebhookDeliveriesBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// OutboxStore holds domain events between the transaction that records them and the
+// relay that publishes them
+type OutboxStore interface {
+	CreateOutboxEvent(ctx context.Context, arg database.CreateOutboxEventParams) (database.OutboxEvent, error)
+	ListUnpublishedOutboxEvents(ctx context.Context, limit int32) ([]database.OutboxEvent, error)
+	MarkOutboxEventPublished(ctx context.Context, id uuid.UUID) (int64, error)
+	CountUnpublishedOutboxEvents(ctx context.Context) (int64, error)
+	CountPublishedOutboxEventsBefore(ctx context.Context, before time.Time) (int64, error)
+	DeletePublishedOutboxEventsBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// IdempotencyStore remembers responses to requests sent with an Idempotency-Key
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(ctx context.Context, arg database.ClaimIdempotencyKeyParams) (database.IdempotencyKey, error)
+	GetIdempotencyKey(ctx context.Context, arg database.GetIdempotencyKeyParams) (database.IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, arg database.CompleteIdempotencyKeyParams) error
+	DeleteIdempotencyKey(ctx context.Context, arg database.DeleteIdempotencyKeyParams) error
+	CountExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+	DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+}
+
+// RateLimitStore holds GCRA state for the database-backed rate limiter in
+// internal/ratelimit. Times are microseconds since the epoch.
+type RateLimitStore interface {
+	TakeRateLimitToken(ctx context.Context, arg database.TakeRateLimitTokenParams) (int64, error)
+	GetRateLimit(ctx context.Context, bucket string) (int64, error)
+	CountExpiredRateLimits(ctx context.Context, tat int64) (int64, error)
+	DeleteExpiredRateLimits(ctx context.Context, tat int64) (int64, error)
+}
+
+// QuotaStore counts what each user used of their quotas, per quota and window
+type QuotaStore interface {
+	AddQuotaUsage(ctx context.Context, arg database.AddQuotaUsageParams) (int64, error)
+	GetQuotaUsage(ctx context.Context, arg database.GetQuotaUsageParams) (int64, error)
+	CountQuotaUsageBefore(ctx context.Context, before time.Time) (int64, error)
+	DeleteQuotaUsageBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// APIKeyStore holds the keys developers read the API with and the requests made with
+// each per day
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, arg database.CreateAPIKeyParams) (database.ApiKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (database.ApiKey, error)
+	ListAPIKeysByUser(ctx context.Context, userID uuid.UUID) ([]database.ApiKey, error)
+	DeleteAPIKey(ctx context.Context, arg database.DeleteAPIKeyParams) (int64, error)
+	TouchAPIKey(ctx context.Context, arg database.TouchAPIKeyParams) error
+	ListAPIKeysAfter(ctx context.Context, arg database.ListAPIKeysAfterParams) ([]database.ApiKey, error)
+	RestoreAPIKey(ctx context.Context, arg database.RestoreAPIKeyParams) error
+	AddAPIKeyUsage(ctx context.Context, arg database.AddAPIKeyUsageParams) (int64, error)
+	ListAPIKeyUsage(ctx context.Context, arg database.ListAPIKeyUsageParams) ([]database.ApiKeyUsage, error)
+	CountAPIKeyUsageBefore(ctx context.Context, before time.Time) (int64, error)
+	DeleteAPIKeyUsageBefore(ctx context.Context, before time.Time) (int64, error)
+}
+
+// ModerationStore holds reports of chirps and the decisions moderators made on them
+type ModerationStore interface {
+	CreateChirpReport(ctx context.Context, arg database.CreateChirpReportParams) (database.ChirpReport, error)
+	ListOpenChirpReports(ctx context.Context) ([]database.ListOpenChirpReportsRow, error)
+	GetOpenChirpReports(ctx context.Context, chirpID uuid.UUID) ([]database.ChirpReport, error)
+	CreateModerationDecision(ctx context.Context, arg database.CreateModerationDecisionParams) (database.ModerationDecision, error)
+	ResolveChirpReports(ctx context.Context, arg database.ResolveChirpReportsParams) (int64, error)
+	EscalateChirpReports(ctx context.Context, chirpID uuid.UUID) (int64, error)
+	CountChirpReportsByDay(ctx context.Context, arg database.CountChirpReportsByDayParams) ([]database.CountChirpReportsByDayRow, error)
+	CountChirpReportsByReason(ctx context.Context, arg database.CountChirpReportsByReasonParams) ([]database.CountChirpReportsByReasonRow, error)
+	ListMostReportedUsers(ctx context.Context, arg database.ListMostReportedUsersParams) ([]database.ListMostReportedUsersRow, error)
+	ListMostReportedChirps(ctx context.Context, arg database.ListMostReportedChirpsParams) ([]database.ListMostReportedChirpsRow, error)
+	ListReportResponseTimes(ctx context.Context, arg database.ListReportResponseTimesParams) ([]database.ListReportResponseTimesRow, error)
+	ListAutomodHoldsAfter(ctx context.Context, arg database.ListAutomodHoldsAfterParams) ([]database.ChirpReport, error)
+	RestoreChirpReport(ctx context.Context, arg database.RestoreChirpReportParams) error
+}
+
+// WordFilterStore persists the word-filter rules admins manage
+type WordFilterStore interface {
+	CreateWordFilter(ctx context.Context, arg database.CreateWordFilterParams) (database.WordFilter, error)
+	GetWordFilters(ctx context.Context) ([]database.WordFilter, error)
+	UpdateWordFilter(ctx context.Context, arg database.UpdateWordFilterParams) (database.WordFilter, error)
+	DeleteWordFilter(ctx context.Context, id uuid.UUID) (int64, error)
+	RestoreWordFilter(ctx context.Context, arg database.RestoreWordFilterParams) error
+}
+
+// ShadowbanStore persists the users whose chirps only they can see
+type ShadowbanStore interface {
+	SetShadowban(ctx context.Context, arg database.SetShadowbanParams) (database.Shadowban, error)
+	ListShadowbans(ctx context.Context) ([]database.Shadowban, error)
+	DeleteShadowban(ctx context.Context, userID uuid.UUID) (database.Shadowban, error)
+	RestoreShadowban(ctx context.Context, arg database.RestoreShadowbanParams) error
+}
+
+// IPBanStore persists the sources turned away from every endpoint
+type IPBanStore interface {
+	CreateIPBan(ctx context.Context, arg database.CreateIPBanParams) (database.IpBan, error)
+	ListActiveIPBans(ctx context.Context) ([]database.IpBan, error)
+	DeleteIPBan(ctx context.Context, id uuid.UUID) (database.IpBan, error)
+	RestoreIPBan(ctx context.Context, arg database.RestoreIPBanParams) error
+	CountExpiredIPBans(ctx context.Context, expiresAt sql.NullTime) (int64, error)
+	DeleteExpiredIPBans(ctx context.Context, expiresAt sql.NullTime) (int64, error)
+}
+
+// AutomodStore persists the auto-moderation rules, every version they have had, and the
+// posting limits they put on users
+type AutomodStore interface {
+	CreateAutomodRule(ctx context.Context, arg database.CreateAutomodRuleParams) (database.AutomodRule, error)
+	ListAutomodRules(ctx context.Context) ([]database.AutomodRule, error)
+	GetAutomodRule(ctx context.Context, id uuid.UUID) (database.AutomodRule, error)
+	UpdateAutomodRule(ctx context.Context, arg database.UpdateAutomodRuleParams) (database.AutomodRule, error)
+	DeleteAutomodRule(ctx context.Context, id uuid.UUID) (int64, error)
+	CreateAutomodRuleVersion(ctx context.Context, arg database.CreateAutomodRuleVersionParams) (database.AutomodRuleVersion, error)
+	ListAutomodRuleVersions(ctx context.Context, ruleID uuid.UUID) ([]database.AutomodRuleVersion, error)
+	GetAutomodRuleVersion(ctx context.Context, arg database.GetAutomodRuleVersionParams) (database.AutomodRuleVersion, error)
+	SetAutomodRestriction(ctx context.Context, arg database.SetAutomodRestrictionParams) (database.AutomodRestriction, error)
+	GetAutomodRestriction(ctx context.Context, arg database.GetAutomodRestrictionParams) (database.AutomodRestriction, error)
+	ListAutomodRestrictionsAfter(ctx context.Context, arg database.ListAutomodRestrictionsAfterParams) ([]database.AutomodRestriction, error)
+	RestoreAutomodRule(ctx context.Context, arg database.RestoreAutomodRuleParams) error
+	RestoreAutomodRuleVersion(ctx context.Context, arg database.RestoreAutomodRuleVersionParams) error
+	RestoreAutomodRestriction(ctx context.Context, arg database.RestoreAutomodRestrictionParams) error
+}
+
+// RuntimeStateStore holds named JSON values every instance must agree on, such as maintenance mode
+type RuntimeStateStore interface {
+	SetRuntimeState(ctx context.Context, arg database.SetRuntimeStateParams) (database.RuntimeState, error)
+	GetRuntimeState(ctx context.Context, name string) (database.RuntimeState, error)
+	DeleteRuntimeState(ctx context.Context, name string) (int64, error)
+}
+
+// ActivityPubStore persists the keys users sign ActivityPub deliveries with and the
+// remote actors following them, for internal/activitypub
+type ActivityPubStore interface {
+	CreateActivityPubKey(ctx context.Context, arg database.CreateActivityPubKeyParams) (database.ActivitypubKey, error)
+	GetActivityPubKey(ctx context.Context, userID uuid.UUID) (database.ActivitypubKey, error)
+	AddActivityPubFollower(ctx context.Context, arg database.AddActivityPubFollowerParams) (database.ActivitypubFollower, error)
+	DeleteActivityPubFollower(ctx context.Context, arg database.DeleteActivityPubFollowerParams) (int64, error)
+	CountActivityPubFollowers(ctx context.Context, userID uuid.UUID) (int64, error)
+	ListActivityPubInboxes(ctx context.Context, userID uuid.UUID) ([]string, error)
+	ListActivityPubKeysAfter(ctx context.Context, arg database.ListActivityPubKeysAfterParams) ([]database.ActivitypubKey, error)
+	RestoreActivityPubKey(ctx context.Context, arg database.RestoreActivityPubKeyParams) error
+	ListActivityPubFollowersAfter(ctx context.Context, arg database.ListActivityPubFollowersAfterParams) ([]database.ActivitypubFollower, error)
+	RestoreActivityPubFollower(ctx context.Context, arg database.RestoreActivityPubFollowerParams) error
+}
+
+// FavouriteStore persists the chirps users favourite through the Mastodon client API
+type FavouriteStore interface {
+	FavouriteChirp(ctx context.Context, arg database.FavouriteChirpParams) (int64, error)
+	UnfavouriteChirp(ctx context.Context, arg database.UnfavouriteChirpParams) (int64, error)
+	CountChirpFavourites(ctx context.Context, ids string) ([]database.CountChirpFavouritesRow, error)
+	ListFavouritedChirpIds(ctx context.Context, arg database.ListFavouritedChirpIdsParams) ([]uuid.UUID, error)
+	ListFavouriteChirps(ctx context.Context, arg database.ListFavouriteChirpsParams) ([]database.ListFavouriteChirpsRow, error)
+	ListChirpFavouritesAfter(ctx context.Context, arg database.ListChirpFavouritesAfterParams) ([]database.ChirpFavourite, error)
+	RestoreChirpFavourite(ctx context.Context, arg database.RestoreChirpFavouriteParams) error
+}
+
+// OAuthStore persists the client applications, authorization codes and access tokens
+// of the OAuth flow Mastodon clients sign in with
+type OAuthStore interface {
+	CreateOAuthApp(ctx context.Context, arg database.CreateOAuthAppParams) (database.OauthApp, error)
+	GetOAuthApp(ctx context.Context, clientID string) (database.OauthApp, error)
+	CreateOAuthCode(ctx context.Context, arg database.CreateOAuthCodeParams) error
+	ConsumeOAuthCode(ctx context.Context, arg database.ConsumeOAuthCodeParams) (database.OauthCode, error)
+	DeleteExpiredOAuthCodes(ctx context.Context, expiresAt time.Time) (int64, error)
+	CreateOAuthToken(ctx context.Context, arg database.CreateOAuthTokenParams) error
+	GetOAuthToken(ctx context.Context, tokenHash string) (database.OauthToken, error)
+	RevokeOAuthToken(ctx context.Context, arg database.RevokeOAuthTokenParams) error
+	ListOAuthAppsAfter(ctx context.Context, arg database.ListOAuthAppsAfterParams) ([]database.OauthApp, error)
+	RestoreOAuthApp(ctx context.Context, arg database.RestoreOAuthAppParams) error
+	ListOAuthTokensAfter(ctx context.Context, arg database.ListOAuthTokensAfterParams) ([]database.OauthToken, error)
+	RestoreOAuthToken(ctx context.Context, arg database.RestoreOAuthTokenParams) error
+}
+
+// DigestStore persists who gets the digest email and reads the chirps it lists
+type DigestStore interface {
+	CreateDigestSubscription(ctx context.Context, userID uuid.UUID) (database.DigestSubscription, error)
+	GetDigestSubscription(ctx context.Context, userID uuid.UUID) (database.DigestSubscription, error)
+	DeleteDigestSubscription(ctx context.Context, userID uuid.UUID) error
+	ListDueDigestSubscriptions(ctx context.Context, arg database.ListDueDigestSubscriptionsParams) ([]database.ListDueDigestSubscriptionsRow, error)
+	MarkDigestSent(ctx context.Context, arg database.MarkDigestSentParams) error
+	ListDigestChirps(ctx context.Context, arg database.ListDigestChirpsParams) ([]database.Chirp, error)
+	ListDigestSubscriptionsAfter(ctx context.Context, arg database.ListDigestSubscriptionsAfterParams) ([]database.DigestSubscription, error)
+	RestoreDigestSubscription(ctx context.Context, arg database.RestoreDigestSubscriptionParams) error
+}
+
+// SitemapStore persists the days sitemaps are split into and reads the public chirps
+// and users they list
+type SitemapStore interface {
+	ListSitemapPages(ctx context.Context, tenantID uuid.UUID) ([]database.SitemapPage, error)
+	UpsertSitemapPage(ctx context.Context, arg database.UpsertSitemapPageParams) error
+	DeleteSitemapPage(ctx context.Context, arg database.DeleteSitemapPageParams) error
+	ListSitemapChirps(ctx context.Context, arg database.ListSitemapChirpsParams) ([]database.ListSitemapChirpsRow, error)
+	ListSitemapUsers(ctx context.Context, arg database.ListSitemapUsersParams) ([]database.ListSitemapUsersRow, error)
+	ListChirpsUpdatedSince(ctx context.Context, updatedAt time.Time) ([]database.ListChirpsUpdatedSinceRow, error)
+	ListUsersUpdatedSince(ctx context.Context, updatedAt time.Time) ([]database.ListUsersUpdatedSinceRow, error)
+}
+
+// TwitterImportStore persists the tweets of Twitter/X imports, a batch per row
+type TwitterImportStore interface {
+	CreateTwitterImportBatch(ctx context.Context, arg database.CreateTwitterImportBatchParams) error
+	GetTwitterImportBatch(ctx context.Context, arg database.GetTwitterImportBatchParams) (string, error)
+	DeleteTwitterImportBatches(ctx context.Context, jobID uuid.UUID) error
+}
+
+// Store is everything the handlers need from the persistence layer. Not-found
+// lookups return sql.ErrNoRows regardless of the backend.
+type Store interface {
+	ChirpStore
+	UserStore
+	TenantStore
+	RefreshTokenStore
+	FeatureFlagStore
+	VisitStore
+	StatsStore
+	AuditStore
+	JobStore
+	ScheduleStore
+	WebhookStore
+	OutboxStore
+	IdempotencyStore
+	RateLimitStore
+	QuotaStore
+	APIKeyStore
+	ModerationStore
+	WordFilterStore
+	ShadowbanStore
+	IPBanStore
+	AutomodStore
+	RuntimeStateStore
+	ActivityPubStore
+	FavouriteStore
+	OAuthStore
+	SitemapStore
+	TwitterImportStore
+	DigestStore
+	// WithTx runs fn with a Store whose writes are applied atomically: all of them
+	// if fn returns nil, none of them if it returns an error. Calls must not be nested.
+	WithTx(ctx context.Context, fn func(Store) error) error
+	// Snapshot runs fn with a Store whose reads all see the data as of one moment, for
+	// a series of reads that must agree with each other such as a backup. fn must not write.
+	Snapshot(ctx context.Context, fn func(Store) error) error
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/tracing"
+)
+
+// ErrQueryTimeout is returned, wrapped with the query name, when a query runs past its timeout
+var ErrQueryTimeout = errors.New("database query timed out")
+
+// TimeoutDB wraps the connection handed to sqlc so no query runs longer than its
+// timeout, however long the caller's context would allow. Timeouts are looked up by
+// sqlc query name in overrides, falling back to the default; zero means no limit.
+type TimeoutDB struct {
+	db        database.DBTX
+	timeout   time.Duration
+	overrides map[string]time.Duration
+}
+
+func WrapTimeout(db database.DBTX, timeout time.Duration, overrides map[string]time.Duration) *TimeoutDB {
+	return &TimeoutDB{db: db, timeout: timeout, overrides: overrides}
+}
+
+func (d *TimeoutDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, cancel := d.withTimeout(ctx, query)
+	defer cancel()
+	res, err := d.db.ExecContext(ctx, query, args...)
+	return res, d.checkTimeout(ctx, query, err)
+}
+
+func (d *TimeoutDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, cancel := d.withTimeout(ctx, query)
+	defer cancel()
+	stmt, err := d.db.PrepareContext(ctx, query)
+	return stmt, d.checkTimeout(ctx, query, err)
+}
+
+// The rows outlive this call, so the timeout cannot be cancelled on return. Its timer
+// is released by the deadline or by the caller's context ending, whichever is first.
+func (d *TimeoutDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	queryCtx, _ := d.withTimeout(ctx, query)
+	rows, err := d.db.QueryContext(queryCtx, query, args...)
+	return rows, d.checkTimeout(queryCtx, query, err)
+}
+
+// Like QueryContext the row is read after return. sql.Row reports its error from Scan,
+// so a timeout here surfaces as the driver's cancellation error or
+// context.DeadlineExceeded instead of ErrQueryTimeout.
+func (d *TimeoutDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	queryCtx, _ := d.withTimeout(ctx, query)
+	return d.db.QueryRowContext(queryCtx, query, args...)
+}
+
+func (d *TimeoutDB) withTimeout(ctx context.Context, query string) (context.Context, context.CancelFunc) {
+	timeout, ok := d.overrides[tracing.QueryName(query)]
+	if !ok {
+		timeout = d.timeout
+	}
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Replaces whatever error the driver reports for a cancelled query with ErrQueryTimeout
+// when the query's own deadline, not the caller's, is what stopped it
+func (d *TimeoutDB) checkTimeout(ctx context.Context, query string, err error) error {
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%s: %w", tracing.QueryName(query), ErrQueryTimeout)
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Connection whose statements only return once their context is done
+type hangingDB struct{}
+
+func (hangingDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	<-ctx.Done()
+	return nil, errors.New("driver: statement cancelled")
+}
+
+func (hangingDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hangingDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (hangingDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+func TestTimeoutDB_CutsOffSlowQueries(t *testing.T) {
+	db := WrapTimeout(hangingDB{}, 10*time.Millisecond, map[string]time.Duration{"Slow": 50 * time.Millisecond})
+
+	start := time.Now()
+	_, err := db.ExecContext(context.Background(), "-- name: DeleteUsers :exec\nDELETE FROM users")
+	if !errors.Is(err, ErrQueryTimeout) {
+		t.Fatalf("Expected ErrQueryTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the default timeout to apply, took %v", elapsed)
+	}
+
+	start = time.Now()
+	_, err = db.QueryContext(context.Background(), "-- name: Slow :many\nSELECT 1")
+	if !errors.Is(err, ErrQueryTimeout) || time.Since(start) < 50*time.Millisecond {
+		t.Fatalf("Expected the override to time out after 50ms, got %v after %v", err, time.Since(start))
+	}
+}
+
+func TestTimeoutDB_CallerCancellationIsNotATimeout(t *testing.T) {
+	db := WrapTimeout(hangingDB{}, time.Minute, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := db.ExecContext(ctx, "-- name: DeleteUsers :exec\nDELETE FROM users")
+	if err == nil || errors.Is(err, ErrQueryTimeout) {
+		t.Fatalf("Expected the driver error for a cancelled caller, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DB wraps the connection handed to sqlc so every generated query gets a child span
+type DB struct {
+	db     database.DBTX
+	tracer trace.Tracer
+}
+
+func WrapDB(db database.DBTX) *DB {
+	return &DB{db: db, tracer: otel.Tracer(tracerName)}
+}
+
+func (d *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := d.start(ctx, query)
+	defer span.End()
+	res, err := d.db.ExecContext(ctx, query, args...)
+	recordError(span, err)
+	return res, err
+}
+
+func (d *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, span := d.start(ctx, query)
+	defer span.End()
+	stmt, err := d.db.PrepareContext(ctx, query)
+	recordError(span, err)
+	return stmt, err
+}
+
+func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := d.start(ctx, query)
+	defer span.End()
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	recordError(span, err)
+	return rows, err
+}
+
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := d.start(ctx, query)
+	defer span.End()
+	row := d.db.QueryRowContext(ctx, query, args...)
+	recordError(span, row.Err())
+	return row
+}
+
+func (d *DB) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	return d.tracer.Start(ctx, "db "+QueryName(query),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", query),
+		),
+	)
+}
+
+// QueryName pulls the sqlc query name out of the "-- name: GetChirps :many" header
+func QueryName(query string) string {
+	line, _, _ := strings.Cut(query, "\n")
+	name, ok := strings.CutPrefix(strings.TrimSpace(line), "-- name: ")
+	if !ok {
+		return "query"
+	}
+	name, _, _ = strings.Cut(name, " ")
+	return name
+}
+
+func recordError(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
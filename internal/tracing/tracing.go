@@ -0,0 +1,51 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const tracerName = "github.com/diamondoughnut/httpChirpy"
+
+// RouteResolver reports the registered pattern that serves a request; *http.ServeMux satisfies it
+type RouteResolver interface {
+	Handler(r *http.Request) (http.Handler, string)
+}
+
+// Setup installs an OTLP/HTTP exporter as the global tracer provider and the W3C
+// traceparent propagator. The exporter reads the standard OTEL_EXPORTER_OTLP_*
+// environment variables for its endpoint and headers. The returned function
+// flushes pending spans and should be called on shutdown.
+func Setup(ctx context.Context, serviceName string) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for every request, continuing any incoming
+// traceparent. Spans are named after the route pattern so IDs stay out of span names.
+func Middleware(routes RouteResolver, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.request", otelhttp.WithSpanNameFormatter(func(operation string, r *http.Request) string {
+		_, pattern := routes.Handler(r)
+		if pattern == "" {
+			return r.Method + " unmatched"
+		}
+		return pattern
+	}))
+}
@@ -0,0 +1,207 @@
+// Package twitter reads the tweets of the data archive Twitter (now X) lets users
+// download from their account settings
+package twitter
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNoTweets means a zip has none of the files an archive keeps its tweets in
+var ErrNoTweets = errors.New("the archive has no data/tweets.js")
+
+// ErrTooLarge means the tweets files of a zip expand past maxArchiveBytes
+var ErrTooLarge = errors.New("the archive's tweets are too large")
+
+// Most bytes read from the tweets files of one archive, all parts together. It is well
+// past the few hundred megabytes of the busiest accounts, so a crafted zip of many
+// parts cannot expand without bound. A variable so tests can lower it.
+var maxArchiveBytes int64 = 1 << 30
+
+// longest script prefix before the tweets, such as "window.YTD.tweets.part12 ="
+const maxPrefixBytes = 256
+
+// The files tweets are in: tweets.js, split into tweets-part1.js and on for large
+// accounts, and tweet.js in archives from before 2022
+var tweetsFile = regexp.MustCompile(`^data/tweets?(-part\d+)?\.js$`)
+
+// Tweet is one tweet of an archive, with its links expanded and its text unescaped
+type Tweet struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Text      string    `json:"text"`
+	// a reply to another tweet, including the user's own in a thread
+	Reply bool `json:"reply,omitempty"`
+}
+
+// Archive is what ReadArchive found
+type Archive struct {
+	// oldest first
+	Tweets []Tweet
+	// retweets are not the user's own, so they are counted rather than returned
+	Retweets int
+}
+
+// The parts of a tweet the archive stores that a Tweet is made from
+type archivedTweet struct {
+	IDStr             string `json:"id_str"`
+	FullText          string `json:"full_text"`
+	CreatedAt         string `json:"created_at"`
+	InReplyToStatusID string `json:"in_reply_to_status_id_str"`
+	Entities          struct {
+		URLs  []archivedURL `json:"urls"`
+		Media []archivedURL `json:"media"`
+	} `json:"entities"`
+}
+
+type archivedURL struct {
+	URL         string `json:"url"`
+	ExpandedURL string `json:"expanded_url"`
+}
+
+// ReadArchive reads the tweets of an archive zip of size bytes
+func ReadArchive(r io.ReaderAt, size int64) (Archive, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return Archive{}, fmt.Errorf("the archive is not a zip file: %w", err)
+	}
+	var archive Archive
+	found := false
+	budget := maxArchiveBytes
+	for _, file := range zr.File {
+		if !tweetsFile.MatchString(path.Clean(file.Name)) {
+			continue
+		}
+		found = true
+		// the sizes in the zip are only claims, budgetReader holds reads to them
+		if file.UncompressedSize64 > uint64(budget) {
+			return Archive{}, ErrTooLarge
+		}
+		err := archive.readFile(file, &budget)
+		if errors.Is(err, ErrTooLarge) {
+			return Archive{}, ErrTooLarge
+		}
+		if err != nil {
+			return Archive{}, fmt.Errorf("%s: %w", file.Name, err)
+		}
+	}
+	if !found {
+		return Archive{}, ErrNoTweets
+	}
+	slices.SortFunc(archive.Tweets, func(a, b Tweet) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return archive, nil
+}
+
+// budgetReader counts the bytes read against the budget of an archive, failing with
+// ErrTooLarge once they go past it
+type budgetReader struct {
+	r      io.Reader
+	budget *int64
+}
+
+func (b *budgetReader) Read(p []byte) (int, error) {
+	// one byte past the budget is enough to tell a file that ends on it from a larger one
+	if int64(len(p)) > *b.budget+1 {
+		p = p[:*b.budget+1]
+	}
+	n, err := b.r.Read(p)
+	*b.budget -= int64(n)
+	if *b.budget < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// Reads the tweets of one file a tweet at a time, taking the bytes read from budget
+func (a *Archive) readFile(file *zip.File, budget *int64) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	br := bufio.NewReader(&budgetReader{r: rc, budget: budget})
+	// the file is a script assigning the tweets to window.YTD.tweets.part0
+	for i := 0; ; i++ {
+		c, err := br.ReadByte()
+		if errors.Is(err, ErrTooLarge) {
+			return err
+		}
+		if err != nil || i == maxPrefixBytes {
+			return errors.New("file is not a tweets script")
+		}
+		if c == '=' {
+			break
+		}
+	}
+	dec := json.NewDecoder(br)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return fmt.Errorf("file has no list of tweets: %w", listError(err))
+	}
+	for dec.More() {
+		var entry json.RawMessage
+		err := dec.Decode(&entry)
+		if err != nil {
+			return fmt.Errorf("file has no list of tweets: %w", listError(err))
+		}
+		// tweets are wrapped as {"tweet": {...}} since 2020 and bare before
+		var wrapped struct {
+			Tweet *archivedTweet `json:"tweet"`
+		}
+		err = json.Unmarshal(entry, &wrapped)
+		if err != nil {
+			return err
+		}
+		if wrapped.Tweet == nil {
+			wrapped.Tweet = &archivedTweet{}
+			err = json.Unmarshal(entry, wrapped.Tweet)
+			if err != nil {
+				return err
+			}
+		}
+		tweet := wrapped.Tweet
+		if strings.HasPrefix(tweet.FullText, "RT @") {
+			a.Retweets++
+			continue
+		}
+		createdAt, err := time.Parse(time.RubyDate, tweet.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("tweet %s has an invalid created_at: %w", tweet.IDStr, err)
+		}
+		text := tweet.FullText
+		for _, link := range slices.Concat(tweet.Entities.URLs, tweet.Entities.Media) {
+			if link.URL != "" && link.ExpandedURL != "" {
+				text = strings.ReplaceAll(text, link.URL, link.ExpandedURL)
+			}
+		}
+		a.Tweets = append(a.Tweets, Tweet{
+			ID:        tweet.IDStr,
+			CreatedAt: createdAt.UTC(),
+			Text:      html.UnescapeString(text),
+			Reply:     tweet.InReplyToStatusID != "",
+		})
+	}
+	_, err = dec.Token()
+	if err != nil {
+		return fmt.Errorf("file has no list of tweets: %w", listError(err))
+	}
+	return nil
+}
+
+// Helper function to keep ErrTooLarge recognisable, and name the problem when the
+// decoder stopped without an error of its own
+func listError(err error) error {
+	if err == nil {
+		return errors.New("expected a JSON array")
+	}
+	return err
+}
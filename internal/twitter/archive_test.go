@@ -0,0 +1,111 @@
+package twitter
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func zipOf(t *testing.T, files map[string]string) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	err := zw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestReadArchive(t *testing.T) {
+	r := zipOf(t, map[string]string{
+		"data/tweets.js": `window.YTD.tweets.part0 = [
+			{"tweet": {"id_str": "2", "created_at": "Tue Mar 05 10:00:00 +0000 2019", "full_text": "see https://t.co/abc &amp; more",
+				"entities": {"urls": [{"url": "https://t.co/abc", "expanded_url": "https://example.com/post"}]}}},
+			{"tweet": {"id_str": "3", "created_at": "Wed Mar 06 10:00:00 +0000 2019", "full_text": "RT @walt: not mine"}}
+		]`,
+		"data/tweets-part1.js": `window.YTD.tweets.part1 = [
+			{"tweet": {"id_str": "1", "created_at": "Mon Mar 04 09:30:00 +0100 2019", "full_text": "@walt hi", "in_reply_to_status_id_str": "9"}}
+		]`,
+		"data/like.js": `window.YTD.like.part0 = [{"like": {"tweetId": "7"}}]`,
+	})
+	archive, err := ReadArchive(r, r.Size())
+	if err != nil {
+		t.Fatalf("Expected the archive to be read, got %v", err)
+	}
+	if archive.Retweets != 1 || len(archive.Tweets) != 2 {
+		t.Fatalf("Expected 2 tweets and 1 retweet, got %+v", archive)
+	}
+	first, second := archive.Tweets[0], archive.Tweets[1]
+	if first.ID != "1" || !first.Reply || !first.CreatedAt.Equal(time.Date(2019, 3, 4, 8, 30, 0, 0, time.UTC)) {
+		t.Errorf("Expected the oldest tweet first as a reply, got %+v", first)
+	}
+	if second.Text != "see https://example.com/post & more" || second.Reply {
+		t.Errorf("Expected links expanded and text unescaped, got %+v", second)
+	}
+}
+
+func TestReadArchive_Unwrapped(t *testing.T) {
+	r := zipOf(t, map[string]string{
+		"data/tweet.js": `window.YTD.tweet.part0 = [{"id_str": "5", "created_at": "Fri Jan 01 00:00:00 +0000 2021", "full_text": "old format"}]`,
+	})
+	archive, err := ReadArchive(r, r.Size())
+	if err != nil {
+		t.Fatalf("Expected the archive to be read, got %v", err)
+	}
+	if len(archive.Tweets) != 1 || archive.Tweets[0].Text != "old format" {
+		t.Fatalf("Expected the bare tweet to be read, got %+v", archive)
+	}
+}
+
+func TestReadArchive_TooLarge(t *testing.T) {
+	defer func(limit int64) { maxArchiveBytes = limit }(maxArchiveBytes)
+	part := `window.YTD.tweets.part0 = [{"tweet": {"id_str": "1", "created_at": "Fri Jan 01 00:00:00 +0000 2021", "full_text": "` + strings.Repeat("a", 400) + `"}}]`
+	maxArchiveBytes = int64(len(part)) * 2
+	r := zipOf(t, map[string]string{"data/tweets.js": part, "data/tweets-part1.js": part})
+	archive, err := ReadArchive(r, r.Size())
+	if err != nil || len(archive.Tweets) != 2 {
+		t.Fatalf("Expected parts filling the budget exactly to be read, got %d tweets, %v", len(archive.Tweets), err)
+	}
+	// the parts fit on their own but not together
+	r = zipOf(t, map[string]string{"data/tweets.js": part, "data/tweets-part1.js": part, "data/tweets-part2.js": part})
+	_, err = ReadArchive(r, r.Size())
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Expected ErrTooLarge, got %v", err)
+	}
+	// a zip claiming less than it holds is stopped while reading
+	budget := int64(10)
+	br := &budgetReader{r: strings.NewReader(part), budget: &budget}
+	_, err = io.ReadAll(br)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Expected reads past the budget to fail with ErrTooLarge, got %v", err)
+	}
+}
+
+func TestReadArchive_Invalid(t *testing.T) {
+	_, err := ReadArchive(bytes.NewReader([]byte("not a zip")), 9)
+	if err == nil {
+		t.Error("Expected a non-zip to be rejected")
+	}
+	r := zipOf(t, map[string]string{"data/account.js": `window.YTD.account.part0 = []`})
+	_, err = ReadArchive(r, r.Size())
+	if !errors.Is(err, ErrNoTweets) {
+		t.Errorf("Expected ErrNoTweets, got %v", err)
+	}
+	r = zipOf(t, map[string]string{"data/tweets.js": `window.YTD.tweets.part0 = [{"tweet": {"id_str": "1", "created_at": "yesterday"}}]`})
+	_, err = ReadArchive(r, r.Size())
+	if err == nil {
+		t.Error("Expected an invalid created_at to be rejected")
+	}
+}
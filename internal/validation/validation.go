@@ -0,0 +1,181 @@
+// Package validation checks request values against rules declared in struct tags:
+//
+//	Email string `json:"email" validate:"required,email"`
+//
+// Rules are comma separated. Apart from required, rules skip empty strings and nil
+// pointers, so optional fields only need checking when they are set.
+//
+//	required    non-blank string, non-nil pointer, non-empty slice or map
+//	email       a bare address such as user@example.com
+//	password    at least 6 characters and at most 72 bytes, the bcrypt limit
+//	uuid        a UUID
+//	slug        lowercase letters, digits and inner hyphens, at most 63 characters, like a DNS label
+//	oneof=a b   one of the space separated values
+//	min=N       at least N: the value of a number, the characters of a string or the length of a list
+//	max=N       at most N, measured like min
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// One failing field. Field is the name the client used: the json or query tag,
+// dotted for nested structs.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Struct checks the validate tags of v, a struct or a pointer to one, and returns
+// every failing field in declaration order
+func Struct(v any) []FieldError {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	if value.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validation: %T is not a struct", v))
+	}
+	return checkStruct(nil, "", value)
+}
+
+// FieldName returns the name clients know field by: its json tag, then its query
+// tag, then the Go name
+func FieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "query"} {
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+func checkStruct(errs []FieldError, prefix string, value reflect.Value) []FieldError {
+	t := value.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := prefix + FieldName(field)
+		fieldValue := value.Field(i)
+		if tag := field.Tag.Get("validate"); tag != "" {
+			if message := checkField(fieldValue, tag); message != "" {
+				errs = append(errs, FieldError{Field: name, Message: message})
+				continue
+			}
+		}
+		// descend into the request's own structs, not into types like time.Time
+		nested := reflect.Indirect(fieldValue)
+		if nested.Kind() == reflect.Struct && (nested.Type().PkgPath() == "" || nested.Type().PkgPath() == t.PkgPath()) {
+			errs = checkStruct(errs, name+".", nested)
+		}
+	}
+	return errs
+}
+
+// Returns the message for the first rule value breaks, or "" when it passes them all
+func checkField(value reflect.Value, tag string) string {
+	rules := strings.Split(tag, ",")
+	if isEmpty(value) {
+		if rules[0] == "required" {
+			return "is required"
+		}
+		return ""
+	}
+	value = reflect.Indirect(value)
+	for _, rule := range rules {
+		name, arg, _ := strings.Cut(rule, "=")
+		var message string
+		switch name {
+		case "required":
+		case "email":
+			message = checkEmail(value.String())
+		case "password":
+			password := value.String()
+			if utf8.RuneCountInString(password) < 6 {
+				message = "must be at least 6 characters"
+			} else if len(password) > 72 {
+				message = "must be at most 72 bytes"
+			}
+		case "uuid":
+			if _, err := uuid.Parse(value.String()); err != nil {
+				message = "must be a UUID"
+			}
+		case "slug":
+			if !slugPattern.MatchString(value.String()) {
+				message = "must be lowercase letters, digits and hyphens"
+			}
+		case "oneof":
+			allowed := strings.Fields(arg)
+			if !slices.Contains(allowed, value.String()) {
+				message = "must be one of " + strings.Join(allowed, ", ")
+			}
+		case "min", "max":
+			message = checkBound(value, name, arg)
+		default:
+			panic(fmt.Sprintf("validation: unknown rule %q", rule))
+		}
+		if message != "" {
+			return message
+		}
+	}
+	return ""
+}
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return false
+}
+
+func checkEmail(value string) string {
+	addr, err := mail.ParseAddress(value)
+	if err != nil || addr.Address != value {
+		return "must be an email address"
+	}
+	return ""
+}
+
+func checkBound(value reflect.Value, rule, arg string) string {
+	bound, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validation: %s needs an integer, got %q", rule, arg))
+	}
+	var n int64
+	unit := ""
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = int64(value.Uint())
+	case reflect.String:
+		n, unit = int64(utf8.RuneCountInString(value.String())), " characters"
+	case reflect.Slice, reflect.Map:
+		n, unit = int64(value.Len()), " entries"
+	default:
+		panic(fmt.Sprintf("validation: %s does not apply to %s", rule, value.Type()))
+	}
+	if rule == "min" && n < bound {
+		return fmt.Sprintf("must be at least %d%s", bound, unit)
+	}
+	if rule == "max" && n > bound {
+		return fmt.Sprintf("must be at most %d%s", bound, unit)
+	}
+	return ""
+}
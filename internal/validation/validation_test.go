@@ -0,0 +1,67 @@
+package validation
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+type address struct {
+	Country string `json:"country" validate:"required,min=2,max=2"`
+}
+
+type signup struct {
+	Email    string   `json:"email" validate:"required,email"`
+	Password string   `json:"password" validate:"required,password"`
+	Referrer string   `json:"referrer" validate:"uuid"`
+	Handle   string   `json:"handle" validate:"slug"`
+	Sort     string   `query:"sort" validate:"oneof=asc desc"`
+	Limit    int      `query:"limit" validate:"min=1,max=500"`
+	Tags     []string `json:"tags" validate:"max=2"`
+	Age      *int32   `json:"age" validate:"min=13"`
+	Address  address  `json:"address"`
+}
+
+func TestStruct_ReportsEveryFailingField(t *testing.T) {
+	age := int32(9)
+	got := Struct(signup{
+		Email:    "Someone <someone@example.com>",
+		Password: "abc",
+		Referrer: "nope",
+		Handle:   "-Bad-",
+		Sort:     "sideways",
+		Limit:    0,
+		Tags:     []string{"a", "b", "c"},
+		Age:      &age,
+	})
+	want := []FieldError{
+		{"email", "must be an email address"},
+		{"password", "must be at least 6 characters"},
+		{"referrer", "must be a UUID"},
+		{"handle", "must be lowercase letters, digits and hyphens"},
+		{"sort", "must be one of asc, desc"},
+		{"limit", "must be at least 1"},
+		{"tags", "must be at most 2 entries"},
+		{"age", "must be at least 13"},
+		{"address.country", "is required"},
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("Expected\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestStruct_OptionalFieldsSkipRulesWhenEmpty(t *testing.T) {
+	got := Struct(&signup{
+		Email:    "someone@example.com",
+		Password: strings.Repeat("p", 72),
+		Limit:    500,
+		Address:  address{Country: "NZ"},
+	})
+	if len(got) != 0 {
+		t.Fatalf("Expected no errors, got %v", got)
+	}
+	got = Struct(signup{Email: "someone@example.com", Password: strings.Repeat("p", 73), Limit: 1, Address: address{Country: "NZ"}})
+	if !slices.Equal(got, []FieldError{{"password", "must be at most 72 bytes"}}) {
+		t.Fatalf("Expected the bcrypt limit to apply, got %v", got)
+	}
+}
@@ -0,0 +1,370 @@
+// Package webhooks delivers events to URLs registered by integrators. Publishing an
+// event enqueues one background job per subscribed webhook, so each delivery is
+// retried with the job queue's backoff independently of the others. Every request
+// is signed with the webhook's secret and every attempt is written to the delivery log.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+	"github.com/diamondoughnut/httpChirpy/internal/netguard"
+	"github.com/google/uuid"
+)
+
+// Event types that can be subscribed to
+const (
+	ChirpCreated  = "chirp.created"
+	ChirpDeleted  = "chirp.deleted"
+	ChirpReported = "chirp.reported"
+	UserUpgraded  = "user.upgraded"
+)
+
+// Events lists every event type that is published. There is no user.followed, as users
+// cannot follow each other.
+var Events = []string{ChirpCreated, ChirpDeleted, ChirpReported, UserUpgraded}
+
+// ValidEvent reports whether name is a known event type
+func ValidEvent(name string) bool {
+	return slices.Contains(Events, name)
+}
+
+// TestEvent is sent by SendTest only, it cannot be subscribed to
+const TestEvent = "webhook.test"
+
+// EventType documents an event type for the event catalog
+type EventType struct {
+	Name        string
+	Description string
+	// a zero value of the type of the event's data
+	Data any
+}
+
+// Catalog describes every type in Events, in the same order
+var Catalog = []EventType{
+	{Name: ChirpCreated, Description: "A chirp was posted, or a chirp held for review was approved", Data: ChirpCreatedData{}},
+	{Name: ChirpDeleted, Description: "A chirp was deleted by its author or removed by a moderator", Data: ChirpDeletedData{}},
+	{Name: ChirpReported, Description: "A user reported a chirp to the moderators", Data: ChirpReportedData{}},
+	{Name: UserUpgraded, Description: "A user was upgraded to Chirpy Red", Data: UserUpgradedData{}},
+}
+
+// ChirpCreatedData is the data of chirp.created events
+type ChirpCreatedData struct {
+	ID        uuid.UUID `json:"id"`
+	Body      string    `json:"body"`
+	UserID    uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ChirpDeletedData is the data of chirp.deleted events
+type ChirpDeletedData struct {
+	ID     uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	// set when a moderator replaced the chirp's body with a tombstone instead of deleting it
+	Tombstone bool `json:"tombstone,omitempty"`
+}
+
+// ChirpReportedData is the data of chirp.reported events. The reporter and the details
+// they gave are left out, as every subscriber of the tenant receives the event.
+type ChirpReportedData struct {
+	ReportID uuid.UUID `json:"report_id"`
+	ChirpID  uuid.UUID `json:"chirp_id"`
+	// the chirp's author
+	UserID    uuid.UUID `json:"user_id"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserUpgradedData is the data of user.upgraded events
+type UserUpgradedData struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// TestData is the data of webhook.test events
+type TestData struct {
+	WebhookID uuid.UUID `json:"webhook_id"`
+	Message   string    `json:"message"`
+}
+
+// JobKind is the background job kind used for deliveries
+const JobKind = "webhook.deliver"
+
+// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">"
+const SignatureHeader = "X-Chirpy-Signature"
+
+// the delivery log keeps this much of each request body. Response bodies are not kept,
+// so a webhook cannot be used to read what a URL returns.
+const maxLoggedBody = 4096
+
+// Store is the persistence deliveries need; store.Store satisfies it
+type Store interface {
+	jobs.Store
+	GetWebhook(ctx context.Context, id uuid.UUID) (database.Webhook, error)
+	ListWebhooksForEvent(ctx context.Context, arg database.ListWebhooksForEventParams) ([]database.Webhook, error)
+	EnqueueJobs(ctx context.Context, args []database.EnqueueJobParams) error
+	CreateWebhookDelivery(ctx context.Context, arg database.CreateWebhookDeliveryParams) error
+}
+
+// Event is the JSON body POSTed to subscribers
+type Event struct {
+	ID        uuid.UUID `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+	// the user whose action caused the event, if any. When they are shadowbanned only
+	// their own webhooks receive it.
+	ActorID uuid.UUID `json:"-"`
+}
+
+// delivery is the payload of a delivery job
+type delivery struct {
+	WebhookID uuid.UUID       `json:"webhook_id"`
+	EventID   uuid.UUID       `json:"event_id"`
+	Event     string          `json:"event"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// Dispatcher publishes events and delivers them
+type Dispatcher struct {
+	store       Store
+	client      *http.Client
+	maxAttempts int
+	now         func() time.Time
+}
+
+// Options configures a Dispatcher
+type Options struct {
+	// Timeout bounds each HTTP request to a subscriber
+	Timeout time.Duration
+	// MaxAttempts is how many times a delivery is tried before its job is dead
+	MaxAttempts int
+	// AllowPrivate lets deliveries reach loopback and private addresses, which are
+	// refused otherwise so a webhook cannot point us at internal services
+	AllowPrivate bool
+}
+
+// New builds a Dispatcher and registers its delivery handler on queue
+func New(store Store, queue *jobs.Queue, opts Options) *Dispatcher {
+	d := &Dispatcher{
+		store:       store,
+		maxAttempts: max(opts.MaxAttempts, 1),
+		client: &http.Client{
+			Transport: netguard.Transport(opts.Timeout, opts.AllowPrivate),
+			Timeout:   opts.Timeout,
+			// a redirect could send the signed body somewhere the owner did not register
+			CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		now: func() time.Time { return time.Now().UTC() },
+	}
+	queue.Register(JobKind, d.deliver)
+	return d
+}
+
+// Publish enqueues a delivery of the event to every webhook subscribed to eventType
+// whose owner belongs to the tenant the event happened in
+func (d *Dispatcher) Publish(ctx context.Context, tenantID uuid.UUID, eventType string, data any) error {
+	return d.PublishWith(ctx, d.store, tenantID, eventType, data)
+}
+
+// PublishWith enqueues deliveries through store directly, so they can be part of a
+// transaction alongside the write that caused the event
+func (d *Dispatcher) PublishWith(ctx context.Context, store Store, tenantID uuid.UUID, eventType string, data any) error {
+	return d.PublishEvent(ctx, store, tenantID, Event{ID: uuid.New(), Type: eventType, CreatedAt: d.now(), Data: data})
+}
+
+// PublishEvent enqueues deliveries through store of an event that already has its ID
+// and time, such as one relayed from an outbox. Every delivery of an event carries its
+// ID, so receivers can drop an event published more than once.
+func (d *Dispatcher) PublishEvent(ctx context.Context, store Store, tenantID uuid.UUID, event Event) error {
+	eventType := event.Type
+	webhooks, err := store.ListWebhooksForEvent(ctx, database.ListWebhooksForEventParams{TenantID: tenantID, Event: eventType, ActorID: event.ActorID})
+	if err != nil {
+		return fmt.Errorf("listing webhooks for %s: %w", eventType, err)
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("encoding %s event: %w", eventType, err)
+	}
+	deliveries := make([]database.EnqueueJobParams, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		params, err := jobs.JobParams(JobKind, delivery{WebhookID: webhook.ID, EventID: event.ID, Event: eventType, Body: body}, d.now(), d.maxAttempts)
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, params)
+	}
+	// one round trip however many subscribers there are, on drivers that batch
+	err = store.EnqueueJobs(ctx, deliveries)
+	if err != nil {
+		return fmt.Errorf("enqueueing %s deliveries: %w", eventType, err)
+	}
+	return nil
+}
+
+// deliver is the job handler for one delivery attempt
+func (d *Dispatcher) deliver(ctx context.Context, payload json.RawMessage) error {
+	var job delivery
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return jobs.Permanent(fmt.Errorf("decoding delivery: %w", err))
+	}
+	webhook, err := d.store.GetWebhook(ctx, job.WebhookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the webhook was deleted after the event was published
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !webhook.Active {
+		// or paused, which drops its pending deliveries too
+		return nil
+	}
+	record, err := d.post(ctx, webhook, job)
+	if record.StatusCode == http.StatusGone {
+		// the receiver says the endpoint is gone for good
+		return jobs.Permanent(err)
+	}
+	return err
+}
+
+// SendTest delivers a webhook.test event to webhook right away, whether or not it is
+// active, and returns the delivery as recorded in the log. It is tried once.
+func (d *Dispatcher) SendTest(ctx context.Context, webhook database.Webhook) (database.CreateWebhookDeliveryParams, error) {
+	event := Event{
+		ID:        uuid.New(),
+		Type:      TestEvent,
+		CreatedAt: d.now(),
+		Data:      TestData{WebhookID: webhook.ID, Message: "This is a test delivery from Chirpy"},
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return database.CreateWebhookDeliveryParams{}, fmt.Errorf("encoding %s event: %w", TestEvent, err)
+	}
+	return d.post(ctx, webhook, delivery{WebhookID: webhook.ID, EventID: event.ID, Event: TestEvent, Body: body})
+}
+
+// post sends one signed request and records it in the delivery log
+func (d *Dispatcher) post(ctx context.Context, webhook database.Webhook, job delivery) (database.CreateWebhookDeliveryParams, error) {
+	start := time.Now()
+	status, sendErr := d.send(ctx, webhook, job)
+	record := database.CreateWebhookDeliveryParams{
+		WebhookID:   webhook.ID,
+		EventID:     job.EventID,
+		Event:       job.Event,
+		StatusCode:  int32(status),
+		DurationMs:  time.Since(start).Milliseconds(),
+		RequestBody: truncate(string(job.Body)),
+	}
+	if sendErr != nil {
+		record.Error = sendErr.Error()
+	}
+	err := d.store.CreateWebhookDelivery(context.WithoutCancel(ctx), record)
+	if err != nil {
+		log.Printf("Error recording webhook delivery: %s", err.Error())
+	}
+	return record, sendErr
+}
+
+func (d *Dispatcher) send(ctx context.Context, webhook database.Webhook, job delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(job.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Chirpy-Webhooks/1.0")
+	req.Header.Set("X-Chirpy-Event", job.Event)
+	req.Header.Set("X-Chirpy-Delivery", job.EventID.String())
+	// retries of the same event reuse the key so receivers can drop duplicates
+	req.Header.Set("Idempotency-Key", job.EventID.String())
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, d.now(), job.Body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// drain a little so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxLoggedBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign builds the signature header value for body sent at timestamp
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac(secret, t, body))
+}
+
+// Verify checks a signature header against body, rejecting timestamps further than
+// tolerance from now so captured requests cannot be replayed later
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	var t, v1 string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			v1 = value
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid signature timestamp")
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("signature timestamp is outside the tolerance")
+	}
+	got, err := hex.DecodeString(v1)
+	if err != nil || !hmac.Equal(got, mac(secret, t, body)) {
+		return fmt.Errorf("signature does not match")
+	}
+	return nil
+}
+
+func mac(secret, t string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(t))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// NewSecret generates a random signing secret
+func NewSecret() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(key), nil
+}
+
+func truncate(s string) string {
+	if len(s) > maxLoggedBody {
+		return s[:maxLoggedBody]
+	}
+	return s
+}
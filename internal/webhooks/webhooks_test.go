@@ -0,0 +1,209 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/google/uuid"
+)
+
+// setup registers a webhook for chirp.created pointing at handler
+func setup(t *testing.T, handler http.HandlerFunc) (*store.Memory, *jobs.Queue, *Dispatcher, database.Webhook) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	s := store.NewMemory()
+	ctx := context.Background()
+	user, _ := s.CreateUser(ctx, database.CreateUserParams{Email: "dev@example.com", HashedPassword: "x"})
+	webhook, err := s.CreateWebhook(ctx, database.CreateWebhookParams{UserID: user.ID, Url: server.URL, Secret: "shh", Events: "chirp.created,user.upgraded", Active: true})
+	if err != nil {
+		t.Fatalf("Expected no error creating webhook, got %v", err)
+	}
+	q := jobs.New(s, jobs.Options{})
+	return s, q, New(s, q, Options{Timeout: time.Second, MaxAttempts: 3, AllowPrivate: true}), webhook
+}
+
+func TestDispatcher_DeliversSignedEvent(t *testing.T) {
+	var gotBody []byte
+	var gotSignature, gotEvent string
+	s, q, d, webhook := setup(t, func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSignature = r.Header.Get(SignatureHeader)
+		gotEvent = r.Header.Get("X-Chirpy-Event")
+		w.Write([]byte("thanks"))
+	})
+	ctx := context.Background()
+	if err := d.Publish(ctx, uuid.Nil, ChirpCreated, map[string]string{"body": "hello"}); err != nil {
+		t.Fatalf("Expected no error publishing, got %v", err)
+	}
+	ran, err := q.RunOnce(ctx)
+	if !ran || err != nil {
+		t.Fatalf("Expected the delivery to run, got ran=%v err=%v", ran, err)
+	}
+	if gotEvent != ChirpCreated {
+		t.Fatalf("Expected event header %s, got %q", ChirpCreated, gotEvent)
+	}
+	if err := Verify("shh", gotSignature, gotBody, time.Now(), time.Minute); err != nil {
+		t.Fatalf("Expected a valid signature, got %v", err)
+	}
+	log, _ := s.ListWebhookDeliveries(ctx, database.ListWebhookDeliveriesParams{WebhookID: webhook.ID, Limit: 10})
+	if len(log) != 1 || log[0].StatusCode != 200 || log[0].ResponseBody != "" || log[0].Error != "" {
+		t.Fatalf("Expected one successful delivery in the log, without the response body, got %+v", log)
+	}
+}
+
+func TestDispatcher_SkipsUnsubscribedEvents(t *testing.T) {
+	_, q, d, _ := setup(t, func(w http.ResponseWriter, r *http.Request) {})
+	ctx := context.Background()
+	d.Publish(ctx, uuid.Nil, ChirpDeleted, nil)
+	if ran, _ := q.RunOnce(ctx); ran {
+		t.Fatalf("Expected no delivery for an event the webhook did not subscribe to")
+	}
+}
+
+func TestDispatcher_SkipsOtherTenants(t *testing.T) {
+	s, q, d, _ := setup(t, func(w http.ResponseWriter, r *http.Request) {})
+	ctx := context.Background()
+	tenant, _ := s.CreateTenant(ctx, database.CreateTenantParams{Slug: "acme", Name: "Acme"})
+	d.Publish(ctx, tenant.ID, ChirpCreated, nil)
+	if ran, _ := q.RunOnce(ctx); ran {
+		t.Fatalf("Expected no delivery for an event in another tenant")
+	}
+}
+
+func TestDispatcher_RetriesFailures(t *testing.T) {
+	s, q, d, webhook := setup(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	})
+	ctx := context.Background()
+	d.Publish(ctx, uuid.Nil, UserUpgraded, nil)
+	ran, err := q.RunOnce(ctx)
+	if !ran || err != nil {
+		t.Fatalf("Expected the delivery to run, got ran=%v err=%v", ran, err)
+	}
+	pending, _ := s.ListJobsByStatus(ctx, database.ListJobsByStatusParams{Status: "pending", Limit: 10})
+	if len(pending) != 1 || pending[0].LastError == "" || !pending[0].RunAt.After(time.Now()) {
+		t.Fatalf("Expected the delivery to be scheduled for a retry, got %+v", pending)
+	}
+	log, _ := s.ListWebhookDeliveries(ctx, database.ListWebhookDeliveriesParams{WebhookID: webhook.ID, Limit: 10})
+	if len(log) != 1 || log[0].StatusCode != 503 || log[0].Error == "" {
+		t.Fatalf("Expected the failed attempt in the log, got %+v", log)
+	}
+}
+
+func TestDispatcher_GoneIsPermanent(t *testing.T) {
+	s, q, d, _ := setup(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	ctx := context.Background()
+	d.Publish(ctx, uuid.Nil, ChirpCreated, nil)
+	q.RunOnce(ctx)
+	dead, _ := s.ListJobsByStatus(ctx, database.ListJobsByStatusParams{Status: "dead", Limit: 10})
+	if len(dead) != 1 {
+		t.Fatalf("Expected a 410 to kill the delivery, got %d dead jobs", len(dead))
+	}
+}
+
+func TestDispatcher_RefusesPrivateAddresses(t *testing.T) {
+	called := false
+	s, q, _, webhook := setup(t, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	d := New(s, q, Options{Timeout: time.Second, MaxAttempts: 3})
+	ctx := context.Background()
+	d.Publish(ctx, uuid.Nil, ChirpCreated, nil)
+	q.RunOnce(ctx)
+	if called {
+		t.Fatalf("Expected no request to reach the loopback receiver")
+	}
+	log, _ := s.ListWebhookDeliveries(ctx, database.ListWebhookDeliveriesParams{WebhookID: webhook.ID, Limit: 10})
+	if len(log) != 1 || log[0].StatusCode != 0 || !strings.Contains(log[0].Error, "non-public address 127.0.0.1") {
+		t.Fatalf("Expected the delivery to 127.0.0.1 to be refused, got %+v", log)
+	}
+}
+
+func TestDispatcher_SkipsPausedWebhooks(t *testing.T) {
+	called := false
+	s, q, d, webhook := setup(t, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+	ctx := context.Background()
+	d.Publish(ctx, uuid.Nil, ChirpCreated, nil)
+	pause := database.UpdateWebhookParams{ID: webhook.ID, UserID: webhook.UserID, Url: webhook.Url, Secret: webhook.Secret, Events: webhook.Events}
+	if _, err := s.UpdateWebhook(ctx, pause); err != nil {
+		t.Fatalf("Expected no error pausing the webhook, got %v", err)
+	}
+	ran, err := q.RunOnce(ctx)
+	if !ran || err != nil || called {
+		t.Fatalf("Expected the pending delivery to be dropped, got ran=%v err=%v called=%v", ran, err, called)
+	}
+	d.Publish(ctx, uuid.Nil, ChirpCreated, nil)
+	if ran, _ := q.RunOnce(ctx); ran {
+		t.Fatalf("Expected no delivery to a paused webhook")
+	}
+}
+
+func TestDispatcher_SendTest(t *testing.T) {
+	var gotEvent Event
+	var gotBody []byte
+	var gotSignature string
+	s, _, d, webhook := setup(t, func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		gotSignature = r.Header.Get(SignatureHeader)
+		json.Unmarshal(gotBody, &gotEvent)
+		w.WriteHeader(202)
+	})
+	ctx := context.Background()
+	record, err := d.SendTest(ctx, webhook)
+	if err != nil || record.StatusCode != 202 {
+		t.Fatalf("Expected the test event to be accepted, got %+v, %v", record, err)
+	}
+	if gotEvent.Type != TestEvent || gotEvent.ID != record.EventID {
+		t.Fatalf("Expected a %s event with ID %s, got %+v", TestEvent, record.EventID, gotEvent)
+	}
+	if err := Verify("shh", gotSignature, gotBody, time.Now(), time.Minute); err != nil {
+		t.Fatalf("Expected a valid signature, got %v", err)
+	}
+	log, _ := s.ListWebhookDeliveries(ctx, database.ListWebhookDeliveriesParams{WebhookID: webhook.ID, Limit: 10})
+	if len(log) != 1 || log[0].Event != TestEvent {
+		t.Fatalf("Expected the test delivery in the log, got %+v", log)
+	}
+}
+
+func TestCatalogDescribesEveryEvent(t *testing.T) {
+	if len(Catalog) != len(Events) {
+		t.Fatalf("Expected %d catalog entries, got %d", len(Events), len(Catalog))
+	}
+	for i, eventType := range Catalog {
+		if eventType.Name != Events[i] || eventType.Description == "" || eventType.Data == nil {
+			t.Errorf("Expected catalog entry %d to describe %s, got %+v", i, Events[i], eventType)
+		}
+	}
+}
+
+func TestVerify_RejectsTamperingAndOldTimestamps(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	now := time.Now()
+	header := Sign("shh", now, body)
+	if err := Verify("shh", header, body, now, time.Minute); err != nil {
+		t.Fatalf("Expected a valid signature, got %v", err)
+	}
+	if err := Verify("shh", header, []byte(`{"id":"2"}`), now, time.Minute); err == nil {
+		t.Fatalf("Expected a changed body to fail verification")
+	}
+	if err := Verify("other", header, body, now, time.Minute); err == nil {
+		t.Fatalf("Expected the wrong secret to fail verification")
+	}
+	if err := Verify("shh", header, body, now.Add(time.Hour), time.Minute); err == nil {
+		t.Fatalf("Expected an old signature to fail verification")
+	}
+}
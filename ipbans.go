@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/ipfilter"
+	"github.com/google/uuid"
+)
+
+// Audit log actor of the bans the rate limiter sets
+const rateLimitBanActor = "rate_limiter"
+
+// A range that already has a ban in force
+var errIPBanExists = &apiError{Code: "already_exists", Message: "this range is already banned", Details: map[string]any{"field": "cidr"}}
+
+// Request body of banning a range. duration is how long the ban lasts, for good when empty.
+type ipBanRequest struct {
+	CIDR     string `json:"cidr" validate:"required,max=64"`
+	Reason   string `json:"reason" validate:"max=500"`
+	Duration string `json:"duration" validate:"max=32"`
+}
+
+type ipBanResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	CIDR      string     `json:"cidr"`
+	Reason    string     `json:"reason"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func newIPBanResponse(ban database.IpBan) ipBanResponse {
+	resp := ipBanResponse{
+		ID:        ban.ID,
+		CIDR:      ban.Cidr,
+		Reason:    ban.Reason,
+		CreatedBy: ban.CreatedBy,
+		CreatedAt: ban.CreatedAt,
+	}
+	if ban.ExpiresAt.Valid {
+		resp.ExpiresAt = &ban.ExpiresAt.Time
+	}
+	return resp
+}
+
+// Reads RATE_LIMIT_BAN_AFTER, RATE_LIMIT_BAN_WINDOW and RATE_LIMIT_BAN_DURATION
+func loadRateLimitBanPolicy() (rateLimitBanPolicy, error) {
+	after, err := strconv.Atoi(getEnvDefault("RATE_LIMIT_BAN_AFTER", "0"))
+	if err != nil || after < 0 {
+		return rateLimitBanPolicy{}, fmt.Errorf("invalid RATE_LIMIT_BAN_AFTER: must be a whole number of at least 0")
+	}
+	policy := rateLimitBanPolicy{After: after}
+	for _, setting := range []struct {
+		env      string
+		fallback string
+		value    *time.Duration
+	}{
+		{"RATE_LIMIT_BAN_WINDOW", "1m", &policy.Window},
+		{"RATE_LIMIT_BAN_DURATION", "15m", &policy.Duration},
+	} {
+		d, err := time.ParseDuration(getEnvDefault(setting.env, setting.fallback))
+		if err != nil || d <= 0 {
+			return rateLimitBanPolicy{}, fmt.Errorf("invalid %s: must be a duration greater than 0", setting.env)
+		}
+		*setting.value = d
+	}
+	return policy, nil
+}
+
+func (p rateLimitBanPolicy) String() string {
+	if p.After == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d in %s for %s", p.After, p.Window, p.Duration)
+}
+
+// The bans in force, parsed for the middleware. Never modified once built.
+type ipBanList struct {
+	bans []parsedIPBan
+}
+
+type parsedIPBan struct {
+	database.IpBan
+	ranges *ipfilter.List
+}
+
+// Returns the ban in force on ip at now, nil when there is none
+func (l *ipBanList) find(ip string, now time.Time) *database.IpBan {
+	if l == nil {
+		return nil
+	}
+	for i := range l.bans {
+		ban := &l.bans[i]
+		if (!ban.ExpiresAt.Valid || ban.ExpiresAt.Time.After(now)) && ban.ranges.ContainsString(ip) {
+			return &ban.IpBan
+		}
+	}
+	return nil
+}
+
+// Returns the ban in force on ip, nil when there is none
+func (cfg *apiConfig) ipBanned(ip string) *database.IpBan {
+	return cfg.ipBans.Load().find(ip, time.Now())
+}
+
+// Answers a request from a banned source with 403, with Retry-After when the ban expires
+func writeIPBanned(w http.ResponseWriter, ban *database.IpBan) {
+	details := map[string]any{"expires_at": nil}
+	if ban.ExpiresAt.Valid {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(ban.ExpiresAt.Time).Seconds())+1))
+		details["expires_at"] = ban.ExpiresAt.Time
+	}
+	marshallError(w, &apiError{Code: "ip_banned", Message: "requests from your address are banned", Details: details}, 403)
+}
+
+// Loads the bans in force into cfg.ipBans and returns them
+func (cfg *apiConfig) syncIPBans(ctx context.Context) ([]database.IpBan, error) {
+	bans, err := cfg.store.ListActiveIPBans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := &ipBanList{bans: make([]parsedIPBan, 0, len(bans))}
+	for _, ban := range bans {
+		ranges, err := ipfilter.Parse([]string{ban.Cidr})
+		if err != nil {
+			// stored bans are normalized on the way in, so this is a hand-edited row
+			log.Printf("Skipping IP ban %s: %s", ban.ID, err.Error())
+			continue
+		}
+		list.bans = append(list.bans, parsedIPBan{IpBan: ban, ranges: ranges})
+	}
+	cfg.ipBans.Store(list)
+	return bans, nil
+}
+
+// Picks up bans set on other instances every interval until ctx is done. A failed read
+// keeps the last known bans; expired ones stop applying either way.
+func (cfg *apiConfig) syncIPBansEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := cfg.syncIPBans(ctx)
+			if err != nil {
+				log.Printf("Error reading IP bans: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Counts the requests the rate limiter refused from each address in the current window
+type rateLimitStrikes struct {
+	mu      sync.Mutex
+	windows map[string]strikeWindow
+}
+
+type strikeWindow struct {
+	start time.Time
+	count int
+}
+
+// Records a refused request from ip and reports whether it is the after-th within window,
+// which starts the count over
+func (s *rateLimitStrikes) add(ip string, now time.Time, policy rateLimitBanPolicy) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.windows == nil {
+		s.windows = make(map[string]strikeWindow)
+	}
+	if len(s.windows) >= 10000 {
+		// a flood from many addresses must not grow the map forever
+		for key, window := range s.windows {
+			if now.Sub(window.start) >= policy.Window {
+				delete(s.windows, key)
+			}
+		}
+	}
+	window := s.windows[ip]
+	if now.Sub(window.start) >= policy.Window {
+		window = strikeWindow{start: now}
+	}
+	window.count++
+	if window.count >= policy.After {
+		delete(s.windows, ip)
+		return true
+	}
+	s.windows[ip] = window
+	return false
+}
+
+// Bans ip for a while once the rate limiter has refused it often enough, see
+// RATE_LIMIT_BAN_AFTER. Counts are per instance, and the ban applies on every instance.
+func (cfg *apiConfig) rateLimitRefused(ctx context.Context, ip string) {
+	policy := cfg.settings.Load().rateLimitBan
+	if policy.After == 0 || !cfg.rateLimitStrikes.add(ip, time.Now(), policy) {
+		return
+	}
+	ranges, err := ipfilter.Parse([]string{ip})
+	if err != nil || ranges.Len() == 0 {
+		// a unix socket client has no address to ban
+		return
+	}
+	ban, err := cfg.store.CreateIPBan(ctx, database.CreateIPBanParams{
+		Cidr:      ranges.Strings()[0],
+		Reason:    fmt.Sprintf("rate limit exceeded %d times within %s", policy.After, policy.Window),
+		ExpiresAt: sql.NullTime{Time: time.Now().UTC().Add(policy.Duration), Valid: true},
+		CreatedBy: rateLimitBanActor,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// another instance banned it first
+		return
+	}
+	if err != nil {
+		log.Printf("Error banning %s: %s", ip, err.Error())
+		return
+	}
+	cfg.ipBansChanged(ctx)
+	writeAudit(ctx, cfg.store, database.CreateAuditEntryParams{
+		Actor:     rateLimitBanActor,
+		Action:    "ip_ban.create",
+		Target:    ban.ID.String(),
+		RequestID: requestID(ctx),
+		Ip:        ip,
+	}, newIPBanResponse(ban))
+	log.Printf("Banned %s for %s after %d rate limited requests", ban.Cidr, policy.Duration, policy.After)
+}
+
+// Lists the bans in force, oldest first
+func (cfg *apiConfig) handlerListIPBans(w http.ResponseWriter, r *http.Request) {
+	bans, err := cfg.syncIPBans(r.Context())
+	if err != nil {
+		log.Printf("Error reading IP bans: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]ipBanResponse, 0, len(bans))
+	for _, ban := range bans {
+		resp = append(resp, newIPBanResponse(ban))
+	}
+	render(w, r, 200, resp)
+}
+
+// Bans a range or single address. A ban that would cover the admin making it is
+// refused, so the admin endpoints stay reachable to lift it.
+func (cfg *apiConfig) handlerCreateIPBan(w http.ResponseWriter, r *http.Request) {
+	params := ipBanRequest{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	ranges, err := ipfilter.Parse([]string{params.CIDR})
+	if err != nil || ranges.Len() == 0 {
+		marshallError(w, &apiError{Code: "invalid_ip_range", Message: fmt.Sprintf("invalid cidr %q: want an IP or a CIDR range", params.CIDR)}, 400)
+		return
+	}
+	expiresAt := sql.NullTime{}
+	if params.Duration != "" {
+		d, err := time.ParseDuration(params.Duration)
+		if err != nil || d <= 0 {
+			marshallError(w, invalidInputError{fmt.Sprintf("invalid duration %q: want a duration such as 24h", params.Duration)}, 400)
+			return
+		}
+		expiresAt = sql.NullTime{Time: time.Now().UTC().Add(d), Valid: true}
+	}
+	if ranges.ContainsString(clientIP(r)) {
+		marshallError(w, &apiError{
+			Code:    "ip_lockout",
+			Message: fmt.Sprintf("this ban would block your own address %s", clientIP(r)),
+		}, 400)
+		return
+	}
+	ban, err := cfg.store.CreateIPBan(r.Context(), database.CreateIPBanParams{
+		Cidr:      ranges.Strings()[0],
+		Reason:    params.Reason,
+		ExpiresAt: expiresAt,
+		CreatedBy: auditActor(r),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, errIPBanExists, 409)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating IP ban: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.ipBansChanged(r.Context())
+	cfg.recordAudit(r, "ip_ban.create", ban.ID.String(), newIPBanResponse(ban))
+	log.Printf("IP ban %s created for %s", ban.ID, ban.Cidr)
+	render(w, r, 201, newIPBanResponse(ban))
+}
+
+// Lifts the ban with the id in the path
+func (cfg *apiConfig) handlerDeleteIPBan(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("banID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid ban id"), 400)
+		return
+	}
+	ban, err := cfg.store.DeleteIPBan(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("IP ban %s not found", id), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting IP ban: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.ipBansChanged(r.Context())
+	cfg.recordAudit(r, "ip_ban.delete", id.String(), newIPBanResponse(ban))
+	log.Printf("IP ban %s of %s lifted", id, ban.Cidr)
+	w.WriteHeader(204)
+}
+
+// Applies a change on this instance right away; others pick it up with syncIPBansEvery
+func (cfg *apiConfig) ipBansChanged(ctx context.Context) {
+	_, err := cfg.syncIPBans(ctx)
+	if err != nil {
+		log.Printf("Error reading IP bans: %s", err.Error())
+	}
+}
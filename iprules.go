@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/ipfilter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// CIDR ranges admins added through the admin endpoints, on top of IP_DENYLIST and
+// ADMIN_IP_ALLOWLIST. They are stored in the database like maintenance mode, so every
+// instance blocks the same sources.
+type ipRules struct {
+	ipRuleLists
+	UpdatedAt time.Time `json:"updated_at"`
+	// parsed from the lists when loaded
+	denylist       *ipfilter.List
+	adminAllowlist *ipfilter.List
+}
+
+type ipRuleLists struct {
+	Denylist       []string `json:"denylist"`
+	AdminAllowlist []string `json:"admin_allowlist"`
+}
+
+// Name of the runtime_state row holding the admin-managed IP rules
+const ipRulesStateName = "ip_rules"
+
+// Parses the lists, normalizing their entries
+func newIPRules(lists ipRuleLists, updatedAt time.Time) (*ipRules, error) {
+	denylist, err := ipfilter.Parse(lists.Denylist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denylist: %w", err)
+	}
+	adminAllowlist, err := ipfilter.Parse(lists.AdminAllowlist)
+	if err != nil {
+		return nil, fmt.Errorf("invalid admin_allowlist: %w", err)
+	}
+	return &ipRules{
+		ipRuleLists:    ipRuleLists{Denylist: denylist.Strings(), AdminAllowlist: adminAllowlist.Strings()},
+		UpdatedAt:      updatedAt,
+		denylist:       denylist,
+		adminAllowlist: adminAllowlist,
+	}, nil
+}
+
+// Reports whether requests from ip are turned away, admin ones for /admin/
+func (cfg *apiConfig) ipBlocked(ip string, admin bool) bool {
+	return ipBlockedBy(cfg.settings.Load(), cfg.ipRules.Load(), ip, admin)
+}
+
+// Denied sources are turned away everywhere. Once either allowlist has an entry, only
+// the sources in one of them get to /admin/. Clients on a unix socket have no address
+// and are local, so they are never blocked.
+func ipBlockedBy(settings *runtimeSettings, rules *ipRules, ip string, admin bool) bool {
+	if _, err := netip.ParseAddr(ip); err != nil {
+		return false
+	}
+	if settings.ipDenylist.ContainsString(ip) || (rules != nil && rules.denylist.ContainsString(ip)) {
+		return true
+	}
+	if !admin || settings.adminIPAllowlist.Len()+rules.adminAllowlistLen() == 0 {
+		return false
+	}
+	return !settings.adminIPAllowlist.ContainsString(ip) && (rules == nil || !rules.adminAllowlist.ContainsString(ip))
+}
+
+func (r *ipRules) adminAllowlistLen() int {
+	if r == nil {
+		return 0
+	}
+	return r.adminAllowlist.Len()
+}
+
+// Middleware that answers 403 for blocked and banned sources before anything else looks
+// at the request, so they never reach the login or admin token checks. Bans leave
+// /admin/ alone, so one the rate limiter sets cannot lock admins out of lifting it.
+func (cfg *apiConfig) middlewareIPFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ban := cfg.ipBanned(clientIP(r)); ban != nil && !isAdminPath(r.URL.Path) {
+			cfg.ipBlockedCount.Add(1)
+			writeIPBanned(w, ban)
+			return
+		}
+		if !cfg.ipBlocked(clientIP(r), isAdminPath(r.URL.Path)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cfg.ipBlockedCount.Add(1)
+		marshallError(w, &apiError{Code: "ip_blocked", Message: "requests from your address are not allowed"}, 403)
+	})
+}
+
+// Turns gRPC calls from denied and banned sources away with PermissionDenied. The gRPC API has no
+// admin methods, so only the denylists apply.
+func (cfg *apiConfig) grpcIPFilterInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		if cfg.ipBlocked(host, false) {
+			cfg.ipBlockedCount.Add(1)
+			return nil, status.Error(codes.PermissionDenied, "requests from your address are not allowed")
+		}
+		if cfg.ipBanned(host) != nil {
+			cfg.ipBlockedCount.Add(1)
+			return nil, status.Error(codes.PermissionDenied, "requests from your address are banned")
+		}
+	}
+	return handler(ctx, req)
+}
+
+// Loads the admin-managed IP rules into cfg.ipRules and returns them, nil when there
+// are none
+func (cfg *apiConfig) syncIPRules(ctx context.Context) (*ipRules, error) {
+	row, err := cfg.store.GetRuntimeState(ctx, ipRulesStateName)
+	if errors.Is(err, sql.ErrNoRows) {
+		cfg.ipRules.Store(nil)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	stored := &ipRules{}
+	err = json.Unmarshal([]byte(row.Value), stored)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding IP rules: %w", err)
+	}
+	rules, err := newIPRules(stored.ipRuleLists, stored.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ipRules.Store(rules)
+	return rules, nil
+}
+
+// Picks up IP rules changed on other instances every interval until ctx is done. A
+// failed read keeps the last known rules.
+func (cfg *apiConfig) syncIPRulesEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := cfg.syncIPRules(ctx)
+			if err != nil {
+				log.Printf("Error reading IP rules: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Shows the IP rules from the environment and those added by admins; managed is null
+// when there are none
+func (cfg *apiConfig) handlerGetIPRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := cfg.syncIPRules(r.Context())
+	if err != nil {
+		log.Printf("Error reading IP rules: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	settings := cfg.settings.Load()
+	render(w, r, 200, struct {
+		Config  ipRuleLists `json:"config"`
+		Managed *ipRules    `json:"managed"`
+	}{
+		Config:  ipRuleLists{Denylist: settings.ipDenylist.Strings(), AdminAllowlist: settings.adminIPAllowlist.Strings()},
+		Managed: rules,
+	})
+}
+
+// Replaces the admin-managed IP rules. Rules that would block the admin making the
+// change are refused, so the admin endpoints stay reachable to undo a mistake.
+func (cfg *apiConfig) handlerPutIPRules(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Denylist       []string `json:"denylist" validate:"max=1000"`
+		AdminAllowlist []string `json:"admin_allowlist" validate:"max=1000"`
+	}
+	params := parameters{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	rules, err := newIPRules(ipRuleLists{Denylist: params.Denylist, AdminAllowlist: params.AdminAllowlist}, time.Now().UTC())
+	if err != nil {
+		marshallError(w, &apiError{Code: "invalid_ip_range", Message: err.Error()}, 400)
+		return
+	}
+	if ipBlockedBy(cfg.settings.Load(), rules, clientIP(r), true) {
+		marshallError(w, &apiError{
+			Code:    "ip_lockout",
+			Message: fmt.Sprintf("these rules would block your own address %s from the admin endpoints", clientIP(r)),
+		}, 400)
+		return
+	}
+	value, err := json.Marshal(rules)
+	if err != nil {
+		log.Printf("Error encoding IP rules: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	_, err = cfg.store.SetRuntimeState(r.Context(), database.SetRuntimeStateParams{Name: ipRulesStateName, Value: string(value)})
+	if err != nil {
+		log.Printf("Error saving IP rules: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.ipRules.Store(rules)
+	log.Printf("IP rules updated: denylist=%d admin_allowlist=%d", len(rules.Denylist), len(rules.AdminAllowlist))
+	cfg.recordAudit(r, "ip_rules.update", "", rules)
+	render(w, r, 200, rules)
+}
+
+// Removes the admin-managed IP rules, leaving those from the environment
+func (cfg *apiConfig) handlerDeleteIPRules(w http.ResponseWriter, r *http.Request) {
+	n, err := cfg.store.DeleteRuntimeState(r.Context(), ipRulesStateName)
+	if err != nil {
+		log.Printf("Error clearing IP rules: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if n == 0 {
+		marshallError(w, fmt.Errorf("there are no IP rules to remove"), 404)
+		return
+	}
+	cfg.ipRules.Store(nil)
+	log.Printf("IP rules removed")
+	cfg.recordAudit(r, "ip_rules.delete", "", nil)
+	w.WriteHeader(204)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Prefix of listen addresses that name a Unix domain socket, e.g. unix:/run/chirpy.sock
+const unixAddrPrefix = "unix:"
+
+// Opens the listener for the HTTP server. addr is a TCP address such as :8080, or
+// unix:<path> for a socket that a local reverse proxy connects to. The socket file is
+// created with mode and, when group is set, handed to that group; closing the listener
+// removes it again.
+func listen(addr string, mode os.FileMode, group string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	if path == "" {
+		return nil, fmt.Errorf("missing socket path in %q", addr)
+	}
+	err := removeStaleSocket(path)
+	if err != nil {
+		return nil, err
+	}
+	// nobody else may connect in the moment between creating the socket and the chmod below
+	oldMask := syscall.Umask(0o177)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(oldMask)
+	if err != nil {
+		return nil, err
+	}
+	err = setSocketPermissions(path, mode, group)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Helper function to remove a socket left behind by a process that did not shut down
+// cleanly. Regular files and sockets something still answers on are left alone.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+// Helper function to apply LISTEN_SOCKET_MODE and LISTEN_SOCKET_GROUP to a new socket
+func setSocketPermissions(path string, mode os.FileMode, group string) error {
+	if group != "" {
+		gid, err := lookupGroupID(group)
+		if err != nil {
+			return err
+		}
+		err = os.Chown(path, -1, gid)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Chmod(path, mode)
+}
+
+// Helper function to resolve a group name, or a numeric ID, to its ID
+func lookupGroupID(group string) (int, error) {
+	gid, err := strconv.Atoi(group)
+	if err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+// First file descriptor systemd passes to a socket activated service, see sd_listen_fds(3)
+const systemdFirstFD = 3
+
+// Returns the listeners passed by systemd socket activation, or by the process this one
+// replaces in an upgrade (see upgrade.go): the one named grpc (FileDescriptorName=grpc)
+// serves the gRPC API, the one named admin the admin endpoints (ADMIN_ADDR), and the
+// other one HTTP. All are nil when nothing was passed.
+// systemd keeps the sockets open across restarts, so connections queue up instead of
+// being refused while the service starts again.
+func inheritedListeners() (httpListener, grpcListener, adminListener net.Listener, err error) {
+	return listenersFrom(systemdFirstFD)
+}
+
+// Helper function doing the work of inheritedListeners with the descriptors starting at first
+func listenersFrom(first int) (httpListener, grpcListener, adminListener net.Listener, err error) {
+	pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	count, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if (pid != os.Getpid() && !upgrading()) || count <= 0 {
+		return nil, nil, nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// the sockets are ours alone, not for processes this one starts
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	for i := range count {
+		fd := first + i
+		syscall.CloseOnExec(fd)
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("inherited socket %d (%s): %w", fd, name, err)
+		}
+		switch {
+		case name == "grpc" && grpcListener == nil:
+			grpcListener = listener
+		case name == "admin" && adminListener == nil:
+			adminListener = listener
+		case httpListener == nil:
+			httpListener = listener
+		default:
+			listener.Close()
+			return nil, nil, nil, fmt.Errorf("systemd passed %d sockets, expected one for HTTP and at most one each named grpc and admin", count)
+		}
+	}
+	return httpListener, grpcListener, adminListener, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/diamondoughnut/httpChirpy/internal/loadshed"
+	"github.com/diamondoughnut/httpChirpy/internal/metrics"
+)
+
+// Routes that can wait when the server is saturated: listings that are expensive to
+// build and cheap for clients to retry. Keyed like apiDocs, without the /api prefix.
+// Authentication, writes, health probes and admin routes are never shed.
+var lowPriorityRoutes = map[string]bool{
+	"GET /chirps":   true,
+	"POST /graphql": true,
+}
+
+var apiVersionPrefix = regexp.MustCompile(`^/api(/v\d+)?`)
+
+// Middleware that counts /api requests towards the in-flight and latency figures, and
+// answers low priority ones with 503 while the server is overloaded. Other paths, such
+// as long-running pprof profiles, would only skew the figures.
+func (cfg *apiConfig) middlewareLoadShed(routes metrics.RouteResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cfg.shedder.Overloaded() && isLowPriority(routes, r) {
+			w.Header().Set("Retry-After", "1")
+			marshallError(w, &apiError{Code: "overloaded", Message: "the server is overloaded, try again shortly"}, 503)
+			return
+		}
+		done := cfg.shedder.Start()
+		defer done()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware that runs at most MAX_CONCURRENT_REQUESTS /api requests at once. Others wait
+// up to CONCURRENCY_QUEUE_TIMEOUT for a slot, then get 429, as do any beyond
+// MAX_QUEUED_REQUESTS. Health probes always pass, so a busy instance is not restarted.
+func (cfg *apiConfig) middlewareConcurrencyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.limiter == nil || !strings.HasPrefix(r.URL.Path, "/api/") || isHealthProbe(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		release, err := cfg.limiter.Acquire(r.Context())
+		if errors.Is(err, loadshed.ErrLimited) {
+			w.Header().Set("Retry-After", "1")
+			marshallError(w, &apiError{Code: "too_many_concurrent_requests", Message: "the server is busy with other requests, try again shortly"}, 429)
+			return
+		}
+		if err != nil {
+			// the client went away while queued
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Reports whether path is /api/healthz or /api/readyz, in any API version
+func isHealthProbe(path string) bool {
+	path = apiVersionPrefix.ReplaceAllString(path, "")
+	return path == "/healthz" || path == "/readyz"
+}
+
+// Reports whether the route r matches is one of lowPriorityRoutes, in any API version
+func isLowPriority(routes metrics.RouteResolver, r *http.Request) bool {
+	return lowPriorityRoutes[apiRoute(routes, r)]
+}
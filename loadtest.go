@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// The requests `chirpy loadtest` can send, in report order
+var loadTestOps = []string{"register", "login", "post", "read"}
+
+const loadTestPassword = "loadtest-password"
+
+type loadTestOptions struct {
+	target      string
+	concurrency int
+	duration    time.Duration
+	// relative weights of loadTestOps, in the same order
+	mix     []int
+	timeout time.Duration
+}
+
+// Latencies and failures of one kind of request
+type loadTestResult struct {
+	latencies []time.Duration
+	errors    int
+	// the first few distinct failures, to tell a rate limit from a crash
+	samples []string
+}
+
+type loadTestReport struct {
+	elapsed time.Duration
+	results map[string]*loadTestResult
+}
+
+// Implements `chirpy loadtest`, driving a mix of register, login, post and read requests
+// against a running server and printing latency percentiles and error rates per request.
+// It fails when the error rate or p99 latency exceeds a limit, so a deploy pipeline can
+// run it against a staging instance.
+func runLoadTestCommand(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	target := flags.String("target", "http://localhost:8080", "base URL of the server under test")
+	concurrency := flags.Int("concurrency", 10, "number of simulated clients")
+	duration := flags.Duration("duration", 30*time.Second, "how long to send requests")
+	mix := flags.String("mix", "register=1,login=2,post=3,read=14", "relative weights of register, login, post and read requests")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of a single request")
+	maxErrorRate := flags.Float64("max-error-rate", 0.01, "fail when more than this fraction of requests fail, negative to never fail")
+	maxP99 := flags.Duration("max-p99", 0, "fail when the p99 latency of any request exceeds this, 0 to never fail")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *concurrency < 1 || *duration <= 0 {
+		return errors.New("-concurrency and -duration must be positive")
+	}
+	weights, err := parseLoadTestMix(*mix)
+	if err != nil {
+		return err
+	}
+	// Ctrl-C ends the run early but still prints what was measured
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	report, err := runLoadTest(ctx, loadTestOptions{
+		target:      strings.TrimSuffix(*target, "/"),
+		concurrency: *concurrency,
+		duration:    *duration,
+		mix:         weights,
+		timeout:     *timeout,
+	})
+	if err != nil {
+		return err
+	}
+	report.write(out)
+	total, failed := report.totals()
+	if *maxErrorRate >= 0 && total > 0 && float64(failed)/float64(total) > *maxErrorRate {
+		return fmt.Errorf("error rate %.2f%% is above -max-error-rate %.2f%%", 100*float64(failed)/float64(total), 100**maxErrorRate)
+	}
+	if *maxP99 > 0 {
+		for _, op := range loadTestOps {
+			if p99 := report.results[op].percentile(99); p99 > *maxP99 {
+				return fmt.Errorf("%s p99 latency %s is above -max-p99 %s", op, p99.Round(time.Microsecond), *maxP99)
+			}
+		}
+	}
+	return nil
+}
+
+// Parses "register=1,login=2,post=3,read=14" into weights in loadTestOps order. Requests
+// left out get weight 0 and are not sent.
+func parseLoadTestMix(mix string) ([]int, error) {
+	weights := make([]int, len(loadTestOps))
+	total := 0
+	for _, part := range strings.Split(mix, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		i := slices.Index(loadTestOps, name)
+		if !ok || i < 0 {
+			return nil, fmt.Errorf("invalid -mix entry %q, expected one of %s with =weight", part, strings.Join(loadTestOps, ", "))
+		}
+		weight, err := strconv.Atoi(value)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight in -mix entry %q", part)
+		}
+		weights[i] = weight
+		total += weight
+	}
+	if total == 0 {
+		return nil, errors.New("-mix needs at least one positive weight")
+	}
+	return weights, nil
+}
+
+// Runs the load test. Every client first registers and logs in its own account, which is
+// not measured; a failure there means the target is not usable and ends the run.
+func runLoadTest(ctx context.Context, opts loadTestOptions) (loadTestReport, error) {
+	client := &http.Client{
+		Timeout: opts.timeout,
+		// the default keeps only two idle connections per host, far fewer than the clients
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConnsPerHost: opts.concurrency},
+	}
+	defer client.CloseIdleConnections()
+	// emails carry the start time so reruns against the same database do not collide
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	clients := make([]*loadTestClient, opts.concurrency)
+	for i := range clients {
+		clients[i] = &loadTestClient{
+			http:    client,
+			target:  opts.target,
+			email:   fmt.Sprintf("lt-%s-%d@loadtest.chirpy.test", run, i),
+			rng:     rand.New(rand.NewPCG(uint64(i), uint64(time.Now().UnixNano()))),
+			results: map[string]*loadTestResult{},
+		}
+	}
+	var wg sync.WaitGroup
+	setupErrs := make([]error, len(clients))
+	for i, c := range clients {
+		wg.Go(func() { setupErrs[i] = c.setup(ctx) })
+	}
+	wg.Wait()
+	if err := errors.Join(setupErrs...); err != nil {
+		return loadTestReport{}, fmt.Errorf("setting up load test accounts: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+	start := time.Now()
+	for _, c := range clients {
+		wg.Go(func() {
+			for ctx.Err() == nil {
+				c.do(ctx, pickLoadTestOp(c.rng, opts.mix))
+			}
+		})
+	}
+	wg.Wait()
+
+	report := loadTestReport{elapsed: time.Since(start), results: map[string]*loadTestResult{}}
+	for _, op := range loadTestOps {
+		merged := &loadTestResult{}
+		for _, c := range clients {
+			if result, ok := c.results[op]; ok {
+				merged.latencies = append(merged.latencies, result.latencies...)
+				merged.errors += result.errors
+				for _, sample := range result.samples {
+					merged.addSample(sample)
+				}
+			}
+		}
+		slices.Sort(merged.latencies)
+		report.results[op] = merged
+	}
+	return report, nil
+}
+
+func pickLoadTestOp(rng *rand.Rand, weights []int) string {
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	n := rng.IntN(total)
+	for i, weight := range weights {
+		if n < weight {
+			return loadTestOps[i]
+		}
+		n -= weight
+	}
+	return loadTestOps[len(loadTestOps)-1]
+}
+
+// One simulated user. Its results are only touched by its own goroutine and merged
+// once the run is over.
+type loadTestClient struct {
+	http       *http.Client
+	target     string
+	email      string
+	token      string
+	registered int
+	rng        *rand.Rand
+	results    map[string]*loadTestResult
+}
+
+func (c *loadTestClient) setup(ctx context.Context) error {
+	_, err := c.request(ctx, "POST", "/api/users", map[string]string{"email": c.email, "password": loadTestPassword})
+	if err != nil {
+		return err
+	}
+	return c.login(ctx)
+}
+
+func (c *loadTestClient) login(ctx context.Context) error {
+	dat, err := c.request(ctx, "POST", "/api/login", map[string]string{"email": c.email, "password": loadTestPassword})
+	if err != nil {
+		return err
+	}
+	var user User
+	err = json.Unmarshal(dat, &user)
+	if err != nil {
+		return err
+	}
+	c.token = user.Token
+	return nil
+}
+
+// Sends one request of kind op and records how it went. Requests cut short by the end
+// of the run are not counted.
+func (c *loadTestClient) do(ctx context.Context, op string) {
+	start := time.Now()
+	var err error
+	switch op {
+	case "register":
+		c.registered++
+		email := strings.Replace(c.email, "@", fmt.Sprintf("+%d@", c.registered), 1)
+		_, err = c.request(ctx, "POST", "/api/users", map[string]string{"email": email, "password": loadTestPassword})
+	case "login":
+		err = c.login(ctx)
+	case "post":
+		_, err = c.request(ctx, "POST", "/api/chirps", map[string]string{"body": fakeChirp(c.rng)})
+	case "read":
+		_, err = c.request(ctx, "GET", "/api/chirps?sort=desc&per_page=20", nil)
+	}
+	elapsed := time.Since(start)
+	if ctx.Err() != nil {
+		return
+	}
+	result := c.results[op]
+	if result == nil {
+		result = &loadTestResult{}
+		c.results[op] = result
+	}
+	result.latencies = append(result.latencies, elapsed)
+	if err != nil {
+		result.errors++
+		result.addSample(err.Error())
+	}
+}
+
+// Helper function to send a JSON request and read the whole response. Anything but a
+// 2xx answer is an error.
+func (c *loadTestClient) request(ctx context.Context, method, path string, body any) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		dat, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(dat)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.target+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	dat, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%s %s: %s", method, strings.SplitN(path, "?", 2)[0], resp.Status)
+	}
+	return dat, nil
+}
+
+func (r *loadTestResult) addSample(sample string) {
+	if len(r.samples) < 3 && !slices.Contains(r.samples, sample) {
+		r.samples = append(r.samples, sample)
+	}
+}
+
+// Nearest-rank percentile of the sorted latencies, 0 when there are none
+func (r *loadTestResult) percentile(p float64) time.Duration {
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	rank := int(float64(len(r.latencies))*p/100+0.5) - 1
+	return r.latencies[min(max(rank, 0), len(r.latencies)-1)]
+}
+
+func (report loadTestReport) totals() (total, failed int) {
+	for _, result := range report.results {
+		total += len(result.latencies)
+		failed += result.errors
+	}
+	return total, failed
+}
+
+func (report loadTestReport) write(out io.Writer) {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "request\trequests\treq/s\terrors\tp50\tp90\tp99\tmax\t")
+	row := func(name string, r *loadTestResult) {
+		errorRate := 0.0
+		if len(r.latencies) > 0 {
+			errorRate = 100 * float64(r.errors) / float64(len(r.latencies))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.2f%%\t%s\t%s\t%s\t%s\t\n", name, len(r.latencies),
+			float64(len(r.latencies))/report.elapsed.Seconds(), errorRate,
+			r.percentile(50).Round(time.Microsecond), r.percentile(90).Round(time.Microsecond),
+			r.percentile(99).Round(time.Microsecond), r.percentile(100).Round(time.Microsecond))
+	}
+	all := &loadTestResult{}
+	for _, op := range loadTestOps {
+		result := report.results[op]
+		row(op, result)
+		all.latencies = append(all.latencies, result.latencies...)
+		all.errors += result.errors
+	}
+	slices.Sort(all.latencies)
+	row("total", all)
+	tw.Flush()
+	for _, op := range loadTestOps {
+		for _, sample := range report.results[op].samples {
+			fmt.Fprintf(out, "%s error: %s\n", op, sample)
+		}
+	}
+}
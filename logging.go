@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/metrics"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Sends the log to LOG_FILE instead of stderr when it is set, starting a new file once
+// it reaches LOG_MAX_SIZE_MB. Rotated files are kept up to LOG_MAX_BACKUPS of them and
+// for up to LOG_MAX_AGE, gzipped unless LOG_COMPRESS=false. The returned func closes
+// the file.
+func setupLogFile() func() {
+	path := os.Getenv("LOG_FILE")
+	if path == "" {
+		return func() {}
+	}
+	maxAge := getEnvDuration("LOG_MAX_AGE", 14*24*time.Hour)
+	file := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    getEnvInt("LOG_MAX_SIZE_MB", 100),
+		MaxBackups: getEnvInt("LOG_MAX_BACKUPS", 10),
+		// lumberjack counts whole days, 0 keeps files of any age
+		MaxAge:   int(math.Ceil(maxAge.Hours() / 24)),
+		Compress: os.Getenv("LOG_COMPRESS") != "false",
+		// the log already carries the local time, so backups are named in the same zone
+		LocalTime: true,
+	}
+	log.Printf("Logging to %s", path)
+	log.SetOutput(file)
+	return func() {
+		log.SetOutput(os.Stderr)
+		file.Close()
+	}
+}
+
+// One line per request with LOG_ACCESS=true. Busy servers can log only a sample of the
+// successful ones; server errors and slow requests are always logged.
+type accessLog struct {
+	// share of other requests logged, from 0 to 1
+	sampleRate float64
+	slow       time.Duration
+	// returns a number in [0, 1), replaced in tests
+	random func() float64
+}
+
+// Reads the access log settings, nil when it is off
+func newAccessLog() *accessLog {
+	if os.Getenv("LOG_ACCESS") != "true" {
+		return nil
+	}
+	rate, err := strconv.ParseFloat(getEnvDefault("LOG_ACCESS_SAMPLE_RATE", "1"), 64)
+	if err != nil || rate < 0 || rate > 1 {
+		log.Fatalf("Invalid LOG_ACCESS_SAMPLE_RATE: must be from 0 to 1")
+	}
+	return &accessLog{
+		sampleRate: rate,
+		slow:       getEnvDuration("LOG_ACCESS_SLOW", time.Second),
+		random:     rand.Float64,
+	}
+}
+
+// Reports whether a request that got status and took elapsed is logged
+func (a *accessLog) sampled(status int, elapsed time.Duration) bool {
+	if status >= 500 || (a.slow > 0 && elapsed >= a.slow) {
+		return true
+	}
+	return a.sampleRate > 0 && a.random() < a.sampleRate
+}
+
+// Middleware that writes the access log: method, path, route, status, duration, client
+// and request ID
+func (cfg *apiConfig) middlewareAccessLog(routes metrics.RouteResolver, next http.Handler) http.Handler {
+	if cfg.accessLog == nil {
+		return next
+	}
+	a := cfg.accessLog
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: 200}
+		next.ServeHTTP(rec, r)
+		elapsed := time.Since(start)
+		if !a.sampled(rec.status, elapsed) {
+			return
+		}
+		_, route := routes.Handler(r)
+		log.Printf("%s %s %d %s route=%q ip=%s request_id=%s", r.Method, r.URL.Path, rec.status, elapsed.Round(time.Microsecond), route, clientIP(r), w.Header().Get("X-Request-ID"))
+	})
+}
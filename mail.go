@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/email"
+)
+
+// emailProviders builds the Sender named by EMAIL_PROVIDER; add an entry here to support another provider
+var emailProviders = map[string]func() (email.Sender, error){
+	"log":  func() (email.Sender, error) { return email.LogSender{}, nil },
+	"smtp": newSMTPSender,
+}
+
+// Picks the email provider from EMAIL_PROVIDER, defaulting to SMTP when SMTP_HOST is
+// set and to logging emails otherwise, so development never sends real mail
+func newEmailSender() (email.Sender, error) {
+	provider := os.Getenv("EMAIL_PROVIDER")
+	if provider == "" {
+		provider = "log"
+		if os.Getenv("SMTP_HOST") != "" {
+			provider = "smtp"
+		}
+	}
+	build, ok := emailProviders[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown EMAIL_PROVIDER %q", provider)
+	}
+	return build()
+}
+
+func newSMTPSender() (email.Sender, error) {
+	sender := &email.SMTPSender{
+		Host: os.Getenv("SMTP_HOST"),
+		Port: getEnvInt("SMTP_PORT", 587),
+		// either may name a secret, fetched again every SECRETS_REFRESH
+		Credentials: func() (string, string) {
+			return getSecretEnv("SMTP_USERNAME"), getSecretEnv("SMTP_PASSWORD")
+		},
+		From: getEnvDefault("EMAIL_FROM", "Chirpy <noreply@localhost>"),
+		TLS:  getEnvDefault("SMTP_TLS", email.TLSStartTLS),
+	}
+	if sender.Host == "" {
+		return nil, fmt.Errorf("SMTP_HOST is required when EMAIL_PROVIDER=smtp")
+	}
+	if !slices.Contains([]string{email.TLSStartTLS, email.TLSImplicit, email.TLSNone}, sender.TLS) {
+		return nil, fmt.Errorf("SMTP_TLS must be starttls, tls or none")
+	}
+	return sender, nil
+}
+
+// Queues the test template to an address so the email configuration can be checked end to end
+func (cfg *apiConfig) handlerTestEmail(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		To string `json:"to" validate:"required,email"`
+	}
+	params := parameters{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	err = cfg.mailer.Send(r.Context(), params.To, email.Test, email.TestData{SentAt: time.Now().UTC()})
+	if err != nil {
+		log.Printf("Error queueing test email: %s", err.Error())
+		marshallError(w, err, 400)
+		return
+	}
+	cfg.recordAudit(r, "email.test", params.To, nil)
+	w.WriteHeader(202)
+}
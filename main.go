@@ -1,32 +1,128 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net"
 	"os"
-	"slices"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/diamondoughnut/httpChirpy/internal/activitypub"
 	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/automod"
+	"github.com/diamondoughnut/httpChirpy/internal/cache"
+	"github.com/diamondoughnut/httpChirpy/internal/compress"
 	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/email"
+	"github.com/diamondoughnut/httpChirpy/internal/flags"
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+	"github.com/diamondoughnut/httpChirpy/internal/loadshed"
+	"github.com/diamondoughnut/httpChirpy/internal/profanity"
+	"github.com/diamondoughnut/httpChirpy/internal/schedule"
+	"github.com/diamondoughnut/httpChirpy/internal/webhooks"
+	"github.com/diamondoughnut/httpChirpy/internal/metrics"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/diamondoughnut/httpChirpy/internal/validation"
+	"github.com/getsentry/sentry-go"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
+	"github.com/pressly/goose/v3"
+	"golang.org/x/sync/singleflight"
 	_ "github.com/lib/pq"
 )
 
 // Configuration struct holding application state and database connection
 type apiConfig struct {
-	fileserverHits atomic.Int32
-	databaseQueries *database.Queries
+	// hits not yet written to the visits table, which holds the totals for every instance
+	pendingHits atomic.Int64
+	store store.Store
 	platform string
-	secretKey string
-	userId uuid.UUID
+	// signs and checks access tokens, see setJWTSecret
+	jwtKeys atomic.Pointer[jwtKeys]
 	polkaKey string
+	settings atomic.Pointer[runtimeSettings]
+	maxJSONBodyBytes int64
+	maxMediaBodyBytes int64
+	maxRestoreBodyBytes int64
+	maxImportBodyBytes int64
+	metrics *metrics.Metrics
+	adminToken string
+	db *sql.DB
+	readinessTimeout time.Duration
+	chirpCache *cache.LRU[uuid.UUID, database.Chirp]
+	chirpListCache *cache.LRU[string, []database.Chirp]
+	// concurrent cache misses for the same chirp or timeline share one query
+	chirpLoads singleflight.Group
+	sharedCache cache.Cache
+	timelineCacheTTL time.Duration
+	flags *flags.Evaluator
+	jobs *jobs.Queue
+	scheduler *schedule.Scheduler
+	retentionRules []retentionRule
+	webhooks *webhooks.Dispatcher
+	// nil unless ACTIVITYPUB_URL is set
+	federation *activitypub.Federation
+	// signalled after a transaction records an outbox event, see relayOutboxEvery
+	outboxWake chan struct{}
+	mailer *email.Mailer
+	idempotencyTTL time.Duration
+	// in-flight and latency tracking for middlewareLoadShed
+	shedder *loadshed.Shedder
+	// nil when MAX_CONCURRENT_REQUESTS is 0
+	limiter *loadshed.Limiter
+	// nil unless FAULT_INJECTION is on
+	faults *faultInjector
+	// nil unless LOG_ACCESS is on
+	accessLog *accessLog
+	// sends panics and server errors to SENTRY_DSN, nil when unset
+	errorReporter *sentry.Client
+	// Brotli encodings of the embedded frontend, nil when Brotli is off
+	staticBrotli *compress.Precompressed
+	// DEBUG_BODY_LOGGING lets admins turn on debugBodies, the routes whose bodies are logged
+	debugBodiesAllowed bool
+	debugBodies atomic.Pointer[debugBodyState]
+	// CAs of the client certificates /admin/ requires, nil when ADMIN_CLIENT_CA_FILE is unset
+	adminClientCAs *x509.CertPool
+	// CIDR ranges admins added to IP_DENYLIST and ADMIN_IP_ALLOWLIST, nil when none
+	ipRules atomic.Pointer[ipRules]
+	// requests and gRPC calls turned away by the IP rules
+	ipBlockedCount atomic.Int64
+	// the word-filter rules admins manage, nil until they are first loaded
+	wordFilter atomic.Pointer[profanity.Filter]
+	// the users whose chirps only they can see, nil until they are first loaded
+	shadowbanned atomic.Pointer[map[uuid.UUID]bool]
+	// the IP bans in force, nil until they are first loaded
+	ipBans atomic.Pointer[ipBanList]
+	// the enabled auto-moderation rules, nil until they are first loaded
+	automod atomic.Pointer[automod.Engine]
+	// how often the rate limiter refused each address, see RATE_LIMIT_BAN_AFTER
+	rateLimitStrikes rateLimitStrikes
+	// who is emailed about moderation decisions, see MODERATION_NOTIFY
+	moderationNotify []string
+	// nil unless an admin turned maintenance mode on
+	maintenance atomic.Pointer[maintenanceState]
+	// tenants are named by subdomains of this domain, besides the X-Tenant header
+	tenantDomain string
+	tenantCache *cache.LRU[string, database.Tenant]
+	// deadline of routes not in routeTimeouts, and overrides by route, see routeTimeout
+	requestTimeout time.Duration
+	requestTimeouts map[string]time.Duration
+	// patterns registered with handleAPI, for the OpenAPI document
+	apiRoutes []string
+	// the origin sitemaps link to for the default tenant, the request's host when unset
+	siteURL string
+	// URLs listed per sitemap file, see sitemapFileSize
+	sitemapURLsPerFile int
+	// robots.txt without its Sitemap line, defaultRobotsRules when empty
+	robotsRules string
 }
 
 type User struct {
@@ -39,277 +135,546 @@ type User struct {
 	IsChirpyRed bool 	`json:"is_chirpy_red"`
 }
 
+type Chirp struct {
+	ID     uuid.UUID `json:"id"`
+	Body   string    `json:"body"`
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// Request body of register, login and user updates
+type credentials struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+// Login only requires the fields, so accounts created before a rule changed can still log in
+type loginRequest struct {
+	Email    string `json:"email" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// Request body of chirp creation
+type createChirpRequest struct {
+	Body string `json:"body" validate:"required,max=140"`
+}
+
 func main() {
-	// Load environment variables and establish database connection
+	// Load environment variables before dispatching to a subcommand
 	godotenv.Load()
-	dbURL := os.Getenv("DB_URL")
-	platform := os.Getenv("PLATFORM")
-	secretKey := os.Getenv("JWT_SECRET_KEY")
-	polkaKey := os.Getenv("POLKA_KEY")
-	db, err := sql.Open("postgres", dbURL)
+	err := run(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
-	dbQueries := database.New(db)
-	// Initialize application configuration with database queries
-	apiCfg := &apiConfig{databaseQueries: dbQueries, platform: platform, secretKey: secretKey, polkaKey: polkaKey}
-	// Set up HTTP router and register route handlers
+}
+
+// Registers every route handler on a new router
+func (cfg *apiConfig) routes() *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.Handle("/app/", http.StripPrefix("/app", apiCfg.middlewareMetricsInc(http.FileServer(http.Dir(".")))))
-	mux.HandleFunc("GET /api/healthz", handlerHealthz)
-	mux.HandleFunc("POST /api/chirps", apiCfg.handlerCreateChirp)
-	mux.HandleFunc("GET /api/chirps", apiCfg.handlerGetChirps)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", apiCfg.handlerGetChirpById)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.handlerDeleteChirp)
-	mux.HandleFunc("GET /admin/metrics", apiCfg.handlerMetrics)
-	mux.HandleFunc("POST /admin/reset", apiCfg.handlerReset)
-	mux.HandleFunc("POST /api/users", apiCfg.handlerRegister)
-	mux.HandleFunc("POST /api/login", apiCfg.handlerLogin)
-	mux.HandleFunc("PUT /api/users", apiCfg.handlerPutUsers)
-	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.handlerPolkaWebhook)
-	mux.HandleFunc("POST /api/refresh", apiCfg.handlerRefresh)
-	mux.HandleFunc("POST /api/revoke", apiCfg.handlerRevoke)
-	// Configure and start HTTP server
-	srv := http.Server{
-		Addr: ":8080",
-		Handler: mux,
-	}
-	log.Fatal(srv.ListenAndServe())
-	
-}
-
-// Health check endpoint returning 200 OK status
+	mux.Handle("/app/", withCachePolicy(staticCachePolicy.header(), http.StripPrefix("/app", cfg.middlewareMetricsInc(staticHandler(cfg.staticBrotli)))))
+	cfg.handleSitemap(mux, "GET /robots.txt", cfg.handlerRobotsTxt)
+	cfg.handleSitemap(mux, "GET /sitemap.xml", cfg.handlerSitemapIndex)
+	cfg.handleSitemap(mux, "GET /sitemaps/{file}", cfg.handlerSitemap)
+	cfg.handleFederation(mux, "GET /.well-known/webfinger", cfg.handlerWebFinger)
+	cfg.handleFederation(mux, "GET /ap/users/{userID}", cfg.handlerActivityPubActor)
+	cfg.handleFederation(mux, "POST /ap/users/{userID}/inbox", cfg.handlerActivityPubInbox)
+	cfg.handleFederation(mux, "GET /ap/users/{userID}/outbox", cfg.handlerActivityPubOutbox)
+	cfg.handleFederation(mux, "GET /ap/users/{userID}/followers", cfg.handlerActivityPubFollowers)
+	cfg.handleFederation(mux, "GET /ap/chirps/{chirpID}", cfg.handlerActivityPubNote)
+	cfg.handleMastodon(mux, "GET /api/v1/instance", http.HandlerFunc(cfg.handlerMastodonInstance))
+	cfg.handleMastodon(mux, "POST /api/v1/apps", http.HandlerFunc(cfg.handlerMastodonCreateApp))
+	cfg.handleMastodon(mux, "GET /oauth/authorize", http.HandlerFunc(cfg.handlerOAuthAuthorizeForm))
+	cfg.handleMastodon(mux, "POST /oauth/authorize", http.HandlerFunc(cfg.handlerOAuthAuthorize))
+	cfg.handleMastodon(mux, "POST /oauth/token", http.HandlerFunc(cfg.handlerOAuthToken))
+	cfg.handleMastodon(mux, "POST /oauth/revoke", http.HandlerFunc(cfg.handlerOAuthRevoke))
+	cfg.handleMastodon(mux, "GET /api/v1/accounts/verify_credentials", cfg.middlewareMastodonAuth("read:accounts", true, http.HandlerFunc(cfg.handlerMastodonVerifyCredentials)))
+	cfg.handleMastodon(mux, "GET /api/v1/accounts/{id}", cfg.middlewareMastodonAuth("read:accounts", false, http.HandlerFunc(cfg.handlerMastodonGetAccount)))
+	cfg.handleMastodon(mux, "GET /api/v1/accounts/{id}/statuses", cfg.middlewareMastodonAuth("read:statuses", false, http.HandlerFunc(cfg.handlerMastodonAccountStatuses)))
+	cfg.handleMastodon(mux, "GET /api/v1/timelines/home", cfg.middlewareMastodonAuth("read:statuses", true, http.HandlerFunc(cfg.handlerMastodonTimeline)))
+	cfg.handleMastodon(mux, "GET /api/v1/timelines/public", cfg.middlewareMastodonAuth("read:statuses", false, http.HandlerFunc(cfg.handlerMastodonTimeline)))
+	cfg.handleMastodon(mux, "POST /api/v1/statuses", cfg.middlewareMastodonAuth("write:statuses", true, cfg.middlewareIdempotency(http.HandlerFunc(cfg.handlerMastodonCreateStatus))))
+	cfg.handleMastodon(mux, "GET /api/v1/statuses/{id}", cfg.middlewareMastodonAuth("read:statuses", false, http.HandlerFunc(cfg.handlerMastodonGetStatus)))
+	cfg.handleMastodon(mux, "DELETE /api/v1/statuses/{id}", cfg.middlewareMastodonAuth("write:statuses", true, http.HandlerFunc(cfg.handlerMastodonDeleteStatus)))
+	cfg.handleMastodon(mux, "POST /api/v1/statuses/{id}/favourite", cfg.middlewareMastodonAuth("write:favourites", true, cfg.handlerMastodonFavourite(true)))
+	cfg.handleMastodon(mux, "POST /api/v1/statuses/{id}/unfavourite", cfg.middlewareMastodonAuth("write:favourites", true, cfg.handlerMastodonFavourite(false)))
+	cfg.handleMastodon(mux, "GET /api/v1/favourites", cfg.middlewareMastodonAuth("read:favourites", true, http.HandlerFunc(cfg.handlerMastodonFavourites)))
+	cfg.handleAPI(mux, "GET /healthz", http.HandlerFunc(handlerHealthz))
+	cfg.handleAPI(mux, "GET /readyz", http.HandlerFunc(cfg.handlerReadyz))
+	cfg.handleAPI(mux, "POST /chirps", cfg.middlewareIdempotency(http.HandlerFunc(cfg.handlerCreateChirp)))
+	cfg.handleAPI(mux, "GET /chirps", cfg.middlewareViewer(http.HandlerFunc(cfg.handlerGetChirps)))
+	cfg.handleAPI(mux, "GET /chirps/{chirpID}", cfg.middlewareViewer(http.HandlerFunc(cfg.handlerGetChirpById)))
+	cfg.handleAPI(mux, "DELETE /chirps/{chirpID}", http.HandlerFunc(cfg.handlerDeleteChirp))
+	cfg.handleAPI(mux, "POST /chirps/{chirpID}/reports", http.HandlerFunc(cfg.handlerReportChirp))
+	mux.Handle("GET /metrics", withCachePolicy("no-store", cfg.metrics.Handler()))
+	// Everything under /admin/ requires the admin token, including paths that do not exist
+	mux.Handle("/admin/", withCachePolicy("no-store", cfg.middlewareAdminAuth(http.NotFoundHandler())))
+	cfg.handleAdmin(mux, "GET /admin/metrics", cfg.handlerMetrics)
+	cfg.handleAdmin(mux, "GET /admin/api/stats", cfg.handlerAdminStats)
+	cfg.handleAdmin(mux, "GET /admin/stats", cfg.handlerPlatformStats)
+	cfg.handleAdmin(mux, "GET /admin/db-stats", cfg.handlerAdminDBStats)
+	cfg.handleAdmin(mux, "GET /admin/status", cfg.handlerStatus)
+	cfg.handleAdmin(mux, "POST /admin/reset", cfg.handlerReset)
+	cfg.handleAdmin(mux, "POST /admin/backup", cfg.handlerBackup)
+	cfg.handleAdmin(mux, "POST /admin/restore", cfg.handlerRestore)
+	cfg.handleAdmin(mux, "POST /admin/chirps/bulk-delete", cfg.handlerBulkDeleteChirps)
+	cfg.handleAdmin(mux, "GET /admin/users", cfg.handlerAdminUsers)
+	cfg.handleAdmin(mux, "GET /admin/search", cfg.handlerAdminSearch)
+	cfg.handleAdmin(mux, "DELETE /admin/users/{userID}/chirps", cfg.handlerDeleteUserChirps)
+	cfg.handleAdmin(mux, "POST /admin/reload", cfg.handlerReload)
+	cfg.handleAdmin(mux, "GET /admin/flags", cfg.handlerListFeatureFlags)
+	cfg.handleAdmin(mux, "PUT /admin/flags/{name}", cfg.handlerPutFeatureFlag)
+	cfg.handleAdmin(mux, "DELETE /admin/flags/{name}", cfg.handlerDeleteFeatureFlag)
+	cfg.handleAdmin(mux, "GET /admin/audit-log", cfg.handlerAuditLog)
+	cfg.handleAdmin(mux, "GET /admin/jobs", cfg.handlerListJobs)
+	cfg.handleAdmin(mux, "GET /admin/jobs/{jobID}", cfg.handlerGetJob)
+	cfg.handleAdmin(mux, "POST /admin/jobs/{jobID}/retry", cfg.handlerRetryJob)
+	cfg.handleAdmin(mux, "GET /admin/schedule", cfg.handlerSchedule)
+	cfg.handleAdmin(mux, "GET /admin/retention", cfg.handlerListRetention)
+	cfg.handleAdmin(mux, "POST /admin/retention/{rule}/run", cfg.handlerRunRetention)
+	cfg.handleAdmin(mux, "POST /admin/email/test", cfg.handlerTestEmail)
+	cfg.handleAdmin(mux, "GET /admin/maintenance", cfg.handlerGetMaintenance)
+	cfg.handleAdmin(mux, "PUT /admin/maintenance", cfg.handlerPutMaintenance)
+	cfg.handleAdmin(mux, "DELETE /admin/maintenance", cfg.handlerDeleteMaintenance)
+	cfg.handleAdmin(mux, "GET /admin/debug/bodies", cfg.handlerGetDebugBodies)
+	cfg.handleAdmin(mux, "PUT /admin/debug/bodies", cfg.handlerPutDebugBodies)
+	cfg.handleAdmin(mux, "DELETE /admin/debug/bodies", cfg.handlerDeleteDebugBodies)
+	cfg.handleAdmin(mux, "GET /admin/ip-rules", cfg.handlerGetIPRules)
+	cfg.handleAdmin(mux, "PUT /admin/ip-rules", cfg.handlerPutIPRules)
+	cfg.handleAdmin(mux, "DELETE /admin/ip-rules", cfg.handlerDeleteIPRules)
+	cfg.handleAdmin(mux, "GET /admin/ip-bans", cfg.handlerListIPBans)
+	cfg.handleAdmin(mux, "POST /admin/ip-bans", cfg.handlerCreateIPBan)
+	cfg.handleAdmin(mux, "DELETE /admin/ip-bans/{banID}", cfg.handlerDeleteIPBan)
+	cfg.handleAdmin(mux, "GET /admin/word-filters", cfg.handlerListWordFilters)
+	cfg.handleAdmin(mux, "POST /admin/word-filters", cfg.handlerCreateWordFilter)
+	cfg.handleAdmin(mux, "PUT /admin/word-filters/{filterID}", cfg.handlerUpdateWordFilter)
+	cfg.handleAdmin(mux, "DELETE /admin/word-filters/{filterID}", cfg.handlerDeleteWordFilter)
+	cfg.handleAdmin(mux, "GET /admin/automod/rules", cfg.handlerListAutomodRules)
+	cfg.handleAdmin(mux, "POST /admin/automod/rules", cfg.handlerCreateAutomodRule)
+	cfg.handleAdmin(mux, "PUT /admin/automod/rules/{ruleID}", cfg.handlerUpdateAutomodRule)
+	cfg.handleAdmin(mux, "DELETE /admin/automod/rules/{ruleID}", cfg.handlerDeleteAutomodRule)
+	cfg.handleAdmin(mux, "GET /admin/automod/rules/{ruleID}/versions", cfg.handlerListAutomodRuleVersions)
+	cfg.handleAdmin(mux, "POST /admin/automod/rules/{ruleID}/versions/{version}/restore", cfg.handlerRestoreAutomodRule)
+	cfg.handleAdmin(mux, "POST /admin/automod/dry-run", cfg.handlerAutomodDryRun)
+	cfg.handleAdmin(mux, "GET /admin/shadowbans", cfg.handlerListShadowbans)
+	cfg.handleAdmin(mux, "PUT /admin/users/{userID}/shadowban", cfg.handlerPutShadowban)
+	cfg.handleAdmin(mux, "DELETE /admin/users/{userID}/shadowban", cfg.handlerDeleteShadowban)
+	cfg.handleAdmin(mux, "GET /admin/moderation", cfg.handlerModerationQueue)
+	cfg.handleAdmin(mux, "GET /admin/reports/analytics", cfg.handlerReportAnalytics)
+	cfg.handleAdmin(mux, "DELETE /admin/chirps/{chirpID}", cfg.handlerAdminDeleteChirp)
+	cfg.handleAdmin(mux, "POST /admin/moderation/{chirpID}/approve", cfg.handlerModerationDecision(moderationApprove))
+	cfg.handleAdmin(mux, "POST /admin/moderation/{chirpID}/remove", cfg.handlerModerationDecision(moderationRemove))
+	cfg.handleAdmin(mux, "POST /admin/moderation/{chirpID}/escalate", cfg.handlerModerationDecision(moderationEscalate))
+	cfg.handleAdmin(mux, "GET /admin/tenants", cfg.handlerListTenants)
+	cfg.handleAdmin(mux, "POST /admin/tenants", cfg.handlerCreateTenant)
+	cfg.handleAdmin(mux, "PUT /admin/tenants/{slug}", cfg.handlerUpdateTenant)
+	cfg.handleAdmin(mux, "POST /admin/tenants/{slug}/admin-token", cfg.handlerRotateTenantAdminToken)
+	// The admins of a tenant manage it with their own token rather than ADMIN_TOKEN
+	cfg.handleTenantAdmin(mux, "GET /admin/tenant", cfg.handlerGetOwnTenant)
+	cfg.handleAPI(mux, "GET /flags", http.HandlerFunc(cfg.handlerGetFeatureFlags))
+	cfg.handleAPI(mux, "POST /users", cfg.middlewareIdempotency(http.HandlerFunc(cfg.handlerRegister)))
+	cfg.handleAPI(mux, "POST /login", http.HandlerFunc(cfg.handlerLogin))
+	cfg.handleAPI(mux, "PUT /users", http.HandlerFunc(cfg.handlerPutUsers))
+	cfg.handleAPI(mux, "GET /users/me/quota", http.HandlerFunc(cfg.handlerGetQuota))
+	cfg.handleAPI(mux, "GET /users/me/digest", http.HandlerFunc(cfg.handlerGetDigest))
+	cfg.handleAPI(mux, "PUT /users/me/digest", http.HandlerFunc(cfg.handlerSubscribeDigest))
+	cfg.handleAPI(mux, "DELETE /users/me/digest", http.HandlerFunc(cfg.handlerUnsubscribeDigest))
+	cfg.handleAPI(mux, "POST /import/twitter", http.HandlerFunc(cfg.handlerImportTwitter))
+	cfg.handleAPI(mux, "GET /import/twitter/{jobID}", http.HandlerFunc(cfg.handlerGetTwitterImport))
+	cfg.handleAPI(mux, "POST /polka/webhooks", cfg.middlewareIdempotency(http.HandlerFunc(cfg.handlerPolkaWebhook)))
+	cfg.handleAPI(mux, "POST /api-keys", http.HandlerFunc(cfg.handlerCreateAPIKey))
+	cfg.handleAPI(mux, "GET /api-keys", http.HandlerFunc(cfg.handlerListAPIKeys))
+	cfg.handleAPI(mux, "DELETE /api-keys/{keyID}", http.HandlerFunc(cfg.handlerDeleteAPIKey))
+	cfg.handleAPI(mux, "GET /usage", http.HandlerFunc(cfg.handlerGetAPIUsage))
+	cfg.handleAPI(mux, "POST /webhooks", http.HandlerFunc(cfg.handlerCreateWebhook))
+	cfg.handleAPI(mux, "GET /webhooks", http.HandlerFunc(cfg.handlerListWebhooks))
+	cfg.handleAPI(mux, "GET /webhooks/events", http.HandlerFunc(cfg.handlerListWebhookEvents))
+	cfg.handleAPI(mux, "GET /webhooks/{webhookID}", http.HandlerFunc(cfg.handlerGetWebhook))
+	cfg.handleAPI(mux, "PUT /webhooks/{webhookID}", http.HandlerFunc(cfg.handlerUpdateWebhook))
+	cfg.handleAPI(mux, "DELETE /webhooks/{webhookID}", http.HandlerFunc(cfg.handlerDeleteWebhook))
+	cfg.handleAPI(mux, "GET /webhooks/{webhookID}/deliveries", http.HandlerFunc(cfg.handlerListWebhookDeliveries))
+	cfg.handleAPI(mux, "POST /webhooks/{webhookID}/test", http.HandlerFunc(cfg.handlerTestWebhook))
+	cfg.handleAPI(mux, "POST /refresh", http.HandlerFunc(cfg.handlerRefresh))
+	cfg.handleAPI(mux, "POST /revoke", http.HandlerFunc(cfg.handlerRevoke))
+	cfg.handleAPI(mux, "POST /graphql", cfg.newGraphQLHandler())
+	cfg.handleAPI(mux, "GET /openapi.json", http.HandlerFunc(cfg.handlerOpenAPI))
+	cfg.handleAPI(mux, "GET /docs", http.HandlerFunc(handlerSwaggerUI))
+	// Unknown /api paths and methods get JSON errors rather than the router's plain text
+	mux.Handle(apiFallbackPattern, handlerAPIFallback(mux))
+	return mux
+}
+
+// Liveness endpoint returning 200 OK status without touching any dependencies
 func handlerHealthz(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(200)
 	w.Write([]byte("OK"))
 }
 
-// Admin metrics page displaying current hit count in HTML format
-func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("Content-Type", "text/html; charset=utf-8")
-	w.WriteHeader(200)
-	w.Write([]byte(fmt.Sprintf("<html><body><h1>Welcome, Chirpy Admin</h1><p>Chirpy has been visited %d times!</p></body></html>", cfg.fileserverHits.Load())))
+// Pings the database until it answers, waiting twice as long after every failure
+// (capped at 5s). Gives up with the last error once timeout has passed, so a
+// database that never comes up ends startup with a clear message.
+func waitForDatabase(ctx context.Context, ping func(context.Context) error, timeout, delay time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		err := ping(pingCtx)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Database reachable after %d attempts", attempt)
+			}
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("database not reachable after %d attempts in %s: %w", attempt, timeout, err)
+		}
+		log.Printf("Database not reachable (attempt %d), retrying in %s: %s", attempt, delay, err.Error())
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, 5*time.Second)
+	}
 }
 
-// Admin endpoint to reset hit counter to zero
+// Applies migrations and pool limits to a freshly opened database
+func prepareDatabase(db *sql.DB, dialect goose.Dialect) {
+	// Apply embedded migrations unless the environment migrates out-of-band
+	if os.Getenv("AUTO_MIGRATE") != "false" {
+		err := runMigrations(context.Background(), db, dialect)
+		if err != nil {
+			log.Fatalf("Error running migrations: %s", err.Error())
+		}
+	}
+	// Connection pool limits, zero values keep database/sql's defaults. SQLite keeps its single connection.
+	if dialect == goose.DialectPostgres {
+		maxOpenConns := getEnvInt("DB_MAX_OPEN_CONNS", 25)
+		maxIdleConns := getEnvInt("DB_MAX_IDLE_CONNS", 25)
+		connMaxLifetime := getEnvDuration("DB_CONN_MAX_LIFETIME", 30*time.Minute)
+		connMaxIdleTime := getEnvDuration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute)
+		db.SetMaxOpenConns(maxOpenConns)
+		db.SetMaxIdleConns(maxIdleConns)
+		db.SetConnMaxLifetime(connMaxLifetime)
+		db.SetConnMaxIdleTime(connMaxIdleTime)
+		log.Printf("Database pool: max_open=%d max_idle=%d max_lifetime=%s max_idle_time=%s", maxOpenConns, maxIdleConns, connMaxLifetime, connMaxIdleTime)
+	}
+}
+
+// Readiness probe that pings each dependency and reports per-component status as JSON
+func (cfg *apiConfig) handlerReadyz(w http.ResponseWriter, r *http.Request) {
+	type componentStatus struct {
+		Status    string `json:"status"`
+		LatencyMs int64  `json:"latency_ms"`
+		Error     string `json:"error,omitempty"`
+	}
+	type response struct {
+		Status     string                     `json:"status"`
+		Components map[string]componentStatus `json:"components"`
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), cfg.readinessTimeout)
+	defer cancel()
+	resp := response{Status: "ok", Components: map[string]componentStatus{}}
+	code := 200
+	if cfg.db != nil {
+		start := time.Now()
+		err := cfg.db.PingContext(ctx)
+		database := componentStatus{Status: "ok", LatencyMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			log.Printf("Readiness check failed for database: %s", err.Error())
+			database.Status = "unavailable"
+			database.Error = err.Error()
+			resp.Status = "unavailable"
+			code = 503
+		}
+		resp.Components["database"] = database
+	} else {
+		// in-memory storage in demo mode is always available
+		resp.Components["database"] = componentStatus{Status: "ok"}
+	}
+	render(w, r, code, resp)
+}
+
+// Admin endpoint that deletes every user and resets the hit counter to zero.
+// Requires ?confirm=true so a stray request cannot wipe the database.
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
-	err := cfg.databaseQueries.DeleteUsers(r.Context())
+	if r.URL.Query().Get("confirm") != "true" {
+		marshallError(w, fmt.Errorf("reset deletes all users and chirps, repeat the request with ?confirm=true"), 400)
+		return
+	}
+	err := cfg.store.DeleteUsers(r.Context())
 	if err != nil {
 		log.Printf("Error deleting users: %s", err.Error())
 		marshallError(w, err, 500)
 		return
 	}
+	err = cfg.store.DeleteVisits(r.Context())
+	if err != nil {
+		log.Printf("Error deleting visits: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
 	w.Header().Add("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(200)
-	cfg.fileserverHits.Store(0)
+	cfg.pendingHits.Store(0)
+	cfg.purgeChirpCache(r.Context())
+	cfg.recordAudit(r, "reset", "", nil)
 	w.Write([]byte("Hits reset to 0"))
 }
 
 // Middleware that increments hit counter for each request before passing to next handler
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits.Add(1)
+		cfg.pendingHits.Add(1)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Middleware that rejects /api requests from clients that exceeded their rate limit
+func (cfg *apiConfig) middlewareRateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		res, err := cfg.settings.Load().rateLimiter.Allow(r.Context(), clientIP(r))
+		if err != nil {
+			// fail open so a limiter outage does not take the API down with it
+			log.Printf("Error checking rate limit: %s", err.Error())
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		if !res.Allowed {
+			cfg.rateLimitRefused(r.Context(), clientIP(r))
+			w.Header().Set("Retry-After", strconv.Itoa(int(res.RetryAfter.Seconds())+1))
+			marshallError(w, &apiError{
+				Message: "rate limit exceeded",
+				Details: map[string]any{"retry_after_seconds": int(res.RetryAfter.Seconds()) + 1},
+			}, 429)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
+// Middleware that caps request body size, using the restore limit for backups loaded by
+// POST /admin/restore, the import limit for archives sent to POST /api/import/twitter,
+// the media limit for other uploads and the JSON limit otherwise
+func (cfg *apiConfig) middlewareBodyLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := cfg.maxJSONBodyBytes
+		if r.URL.Path == "/admin/restore" {
+			limit = cfg.maxRestoreBodyBytes
+		} else if strings.HasPrefix(r.URL.Path, "/api/") && strings.HasSuffix(r.URL.Path, "/import/twitter") {
+			limit = cfg.maxImportBodyBytes
+		} else if isMediaContentType(r.Header.Get("Content-Type")) {
+			limit = cfg.maxMediaBodyBytes
+		}
+		if r.ContentLength > limit {
+			marshallError(w, fmt.Errorf("request body too large"), 413)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
 
-func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
-	// decode JSON body
-	decoder := json.NewDecoder(r.Body)
-	params := database.CreateChirpParams{}
-	err := decoder.Decode(&params)
-	if err != nil {
-		log.Printf("Error decoding parameters: %s", err.Error())
-		marshallError(w, err, 500)
-		return
-	}
-	bearerToken, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		log.Printf("Error getting bearer token: %s", err.Error())
-		marshallError(w, err, 401)
-		return
-	}
-	userId, err := auth.ValidateJWT(bearerToken, cfg.secretKey)
-	if err != nil {
-		log.Printf("Error validating bearer token: %s", err.Error())
-		marshallError(w, err, 401)
-		return
-	}
-	// Validate chirp length (140 character limit)
-	respBody, err := validate(params)
-	if err != nil {
-		log.Printf("Error validating chirp: %s", err.Error())
-		marshallError(w, err, 400)
-		return
-	}
-	// Create chirp in database
-	chirp, err := cfg.databaseQueries.CreateChirp(r.Context(), database.CreateChirpParams{Body: respBody, UserID: userId})
-	if err != nil {
-		log.Printf("Error creating chirp: %s", err.Error())
-		marshallError(w, err, 500)
-		return
-	}
-	type response struct {
-		ID uuid.UUID `json:"id"`
-		Body string `json:"body"`
-		UserID uuid.UUID `json:"user_id"`
-	}
-	resp := response{
-		ID: chirp.ID,
-		Body: respBody,
-		UserID: userId,
-	}
-	// Marshal response to JSON
-	dat, err := json.Marshal(resp)
+// Helper function to classify upload content types that get the larger media body limit
+func isMediaContentType(contentType string) bool {
+	return strings.HasPrefix(contentType, "multipart/form-data") ||
+		strings.HasPrefix(contentType, "image/") ||
+		strings.HasPrefix(contentType, "video/") ||
+		strings.HasPrefix(contentType, "audio/")
+}
+
+// Helper function to get the client address without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		log.Printf("Error marshalling response body: %s", err.Error())
-		marshallError(w, err, 500)
-		return
+		return r.RemoteAddr
 	}
-	// Send successful JSON response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(201)
-	w.Write(dat)
+	return host
+}
+
+// Dev-only endpoints are also available in demo mode, which has no persistent data to protect
+func (cfg *apiConfig) isDevPlatform() bool {
+	return cfg.platform == "dev" || cfg.platform == "demo"
 }
 
-// helper functio nto validate and clean chirp messages, rejecting those over 140 characters
-func validate(params database.CreateChirpParams) (string, error) {
-	if len(params.Body) > 140 {
-		err := fmt.Errorf("chirp is too long")
-		return "", err
+// Helper function to read an environment variable with a fallback value
+func getEnvDefault(key, fallback string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
 	}
-	// Build response string with cleaned chirp content
-	
-	respBody := cleanString(params.Body)
-	
-	return respBody, nil
+	return value
 }
 
-// Helper function to marshal and send error responses with specified status code
-func marshallError(w http.ResponseWriter, err error, code int) {
-	type errorResponse struct {
-		Error string `json:"error"`
+// Helper function to read a duration (e.g. "15s") from the environment, exiting on invalid values
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
 	}
-	w.WriteHeader(code)
-	dat, err := json.Marshal(errorResponse{
-		Error: err.Error(),
-	})
+	d, err := time.ParseDuration(value)
 	if err != nil {
-		log.Printf("Error marshalling error response: %s", err.Error())
-		return
+		log.Fatalf("Invalid %s: %s", key, err.Error())
 	}
-	w.Write(dat)
+	return d
 }
 
-// Replaces profane words with asterisks and returns cleaned string
-func cleanString(s string) string {
-	var result string
-	words := strings.Split(s, " ")
-	for _, word := range words {
-		if strings.ToLower(word) == "kerfuffle" || strings.ToLower(word) == "sharbert" || strings.ToLower(word) == "fornax" {
-			word = "****"
+// Helper function to read a comma separated list of name=duration pairs (e.g.
+// "GetDashboardCounts=30s,ListAuditEntries=10s") from the environment, exiting on invalid values
+func getEnvDurations(key string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if !ok || err != nil {
+			log.Fatalf("Invalid %s: %q is not name=duration", key, pair)
 		}
-		result += word + " "
+		durations[strings.TrimSpace(name)] = d
 	}
-	result = strings.TrimRight(result, " ")
-	return result
+	return durations
 }
 
-func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Email string `json:"email"`
-		Password string `json:"password"`
+// Helper function to read a comma separated list of name=rate pairs (e.g. "*=0.01,POST
+// /chirps=0.2") from the environment, exiting on rates outside 0 to 1
+func getEnvRates(key string) map[string]float64 {
+	rates := make(map[string]float64)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if !ok || err != nil || rate < 0 || rate > 1 {
+			log.Fatalf("Invalid %s: %q is not name=rate with a rate from 0 to 1", key, pair)
+		}
+		rates[strings.TrimSpace(name)] = rate
 	}
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err := decoder.Decode(&params)
+	return rates
+}
+
+// Helper function to read an integer from the environment, exiting on invalid values
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err.Error())
-		marshallError(w, err, 500)
-		return
+		log.Fatalf("Invalid %s: %s", key, err.Error())
 	}
-	// Validate user credentials
-	user, err := cfg.databaseQueries.GetUserByEmail(r.Context(), params.Email)
+	return n
+}
+
+func (cfg *apiConfig) handlerCreateChirp(w http.ResponseWriter, r *http.Request) {
+	// decode JSON body
+	params := createChirpRequest{}
+	err := decodeJSON(r, &params)
 	if err != nil {
-		log.Printf("Error getting user: %s", err.Error())
-		marshallError(w, err, 404)
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
 		return
 	}
-	err = auth.CheckHashPassword(params.Password, user.HashedPassword)
+	userId, err := cfg.userFromHeader(r.Header)
 	if err != nil {
-		log.Printf("Error checking password: %s", err.Error())
+		log.Printf("Error validating bearer token: %s", err.Error())
 		marshallError(w, err, 401)
 		return
 	}
-	token, err := auth.MakeJWT(user.ID, cfg.secretKey, time.Duration(1 * int(time.Hour)))
-	if err != nil {
-		log.Printf("Error making new JWT token: %s", err.Error())
-		marshallError(w, err, 500)
+	chirp, err := cfg.createChirp(r.Context(), userId, params.Body)
+	var invalid invalidInputError
+	if errors.As(err, &invalid) {
+		log.Printf("Error validating chirp: %s", err.Error())
+		marshallError(w, err, 400)
+		return
+	}
+	var exceeded *quotaExceededError
+	if errors.Is(err, errOtherTenant) || errors.As(err, &exceeded) {
+		marshallError(w, err, 403)
 		return
 	}
-	refreshTokenString, err := auth.MakeRefreshToken()
 	if err != nil {
-		log.Printf("Error making refresh token: %s", err.Error())
+		log.Printf("Error creating chirp: %s", err.Error())
 		marshallError(w, err, 500)
 		return
 	}
-	refreshTokenExp := time.Now().Add(time.Hour * 24 * 60).UTC()
-	refreshToken := database.CreateRefreshTokenParams{UserID: user.ID, Token: refreshTokenString, ExpiresAt: refreshTokenExp}
-	_, err = cfg.databaseQueries.CreateRefreshToken(r.Context(), refreshToken)
+	resp := Chirp{
+		ID: chirp.ID,
+		Body: chirp.Body,
+		UserID: chirp.UserID,
+	}
+	render(w, r, 201, resp)
+}
+
+// helper functio nto validate and clean chirp messages, rejecting those over 140 characters.
+// The word-filter rules run before PROFANITY_WORDS is masked; the hold rules that
+// matched are returned so the chirp can be flagged for review.
+func validate(params createChirpRequest, matcher *profanity.Matcher, filter *profanity.Filter) (string, []profanity.Rule, error) {
+	if fields := validation.Struct(params); len(fields) > 0 {
+		err := fmt.Errorf("%s %s", fields[0].Field, fields[0].Message)
+		return "", nil, err
+	}
+	res := filter.Apply(params.Body)
+	if res.Rejected != nil {
+		log.Printf("Chirp rejected by word filter %s", res.Rejected.ID)
+		return "", nil, errChirpRejected
+	}
+	return matcher.Clean(res.Text), res.Held, nil
+}
+
+func (cfg *apiConfig) handlerLogin(w http.ResponseWriter, r *http.Request) {
+	params := loginRequest{}
+	err := decodeJSON(r, &params)
 	if err != nil {
-		log.Printf("Error creating refresh token: %s", err.Error())
-		marshallError(w, err, 500)
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
 		return
 	}
-	response := User{
-		ID: user.ID,
-		Email: user.Email,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-		Token: token,
-		RefreshToken: refreshTokenString,
-		IsChirpyRed: user.IsChirpyRed,
+	// Validate user credentials and issue tokens
+	response, err := cfg.login(r.Context(), params.Email, params.Password)
+	if errors.Is(err, errInvalidPassword) {
+		log.Printf("Error checking password: %s", err.Error())
+		marshallError(w, &apiError{Code: "invalid_credentials", Message: "incorrect email or password"}, 401)
+		return
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting user: %s", err.Error())
+		marshallError(w, err, 404)
+		return
 	}
-	cfg.userId = user.ID
-	// Marshal response to JSON
-	dat, err := json.Marshal(response)
 	if err != nil {
-		log.Printf("Error marshalling response body: %s", err.Error())
+		log.Printf("Error logging in: %s", err.Error())
 		marshallError(w, err, 500)
 		return
 	}
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(200)
-	w.Write(dat)
+	render(w, r, 200, response)
 }
 
 func (cfg *apiConfig) handlerRegister(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Email string `json:"email"`
-		Password string `json:"password"`
-	}
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err := decoder.Decode(&params)
+	params := credentials{}
+	err := decodeJSON(r, &params)
 	if err != nil {
 		log.Printf("Error decoding parameters: %s", err.Error())
-		marshallError(w, err, 500)
+		marshallError(w, err, decodeErrorStatus(err))
 		return
 	}
-	if cfg.platform != "dev" {
+	if !cfg.isDevPlatform() {
 		log.Printf("Error: register endpoint only available in dev mode")
-		marshallError(w, err, 403)
+		marshallError(w, fmt.Errorf("registration is only available in dev mode"), 403)
+		return
 	}
-	hashedPassword, err := auth.HashPassword(params.Password)
-	if err != nil {
-		log.Printf("Error hashing password: %s", err.Error())
-		marshallError(w, err, 500)
+	user, err := cfg.registerUser(r.Context(), params.Email, params.Password)
+	if errors.Is(err, errTenantUserLimit) {
+		log.Printf("Error creating user: %s", err.Error())
+		marshallError(w, err, 403)
 		return
 	}
-	user, err := cfg.databaseQueries.CreateUser(r.Context(), database.CreateUserParams{Email: params.Email, HashedPassword: hashedPassword})
 	if err != nil {
 		log.Printf("Error creating user: %s", err.Error())
 		marshallError(w, err, 500)
@@ -322,77 +687,68 @@ func (cfg *apiConfig) handlerRegister(w http.ResponseWriter, r *http.Request) {
 		Email:     user.Email,
 		IsChirpyRed: user.IsChirpyRed,
 	}
-	cfg.userId = user.ID
-	newUser, err := json.Marshal(data)
-	if err != nil {
-		log.Printf("Error marshalling response body: %s", err.Error())
-		marshallError(w, err, 500)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(201)
-	w.Write(newUser)
+	render(w, r, 201, data)
 }
 
 func (cfg *apiConfig) handlerGetChirps(w http.ResponseWriter, r *http.Request) {
-	authorIdQuery := r.URL.Query().Get("author_id")
+	type query struct {
+		AuthorID string `query:"author_id" validate:"uuid"`
+		Sort     string `query:"sort" validate:"oneof=asc desc"`
+		// the whole list is returned unless one of these is given
+		Page    *int `query:"page" validate:"min=1"`
+		PerPage *int `query:"per_page" validate:"min=1,max=100"`
+	}
+	params := query{Sort: "asc"}
+	err := decodeQuery(r, &params)
+	if err != nil {
+		log.Printf("Error validating query: %s", err.Error())
+		marshallError(w, err, 400)
+		return
+	}
+	// validation leaves only an empty author_id unparsable, which lists every author
+	authorId, _ := uuid.Parse(params.AuthorID)
 	var chirps []database.Chirp
-	var err error
-	if authorIdQuery != "" {
-		authorId, err := uuid.Parse(authorIdQuery)
+	if params.Page != nil || params.PerPage != nil {
+		page, perPage := 1, defaultPerPage
+		if params.Page != nil {
+			page = *params.Page
+		}
+		if params.PerPage != nil {
+			perPage = *params.PerPage
+		}
+		// only the page is read, with LIMIT and OFFSET, and counted for the headers
+		var total int
+		chirps, total, err = cfg.listChirpsPage(r.Context(), authorId, params.Sort == "desc", page, perPage)
 		if err != nil {
-			log.Printf("Query for author id invalid - omitting query")
-			chirps, err = cfg.databaseQueries.GetChirps(r.Context())
-			if err != nil {
-				log.Printf("Error getting chirps: %s", err.Error())
-				marshallError(w, err, 404)
-				return
-			}
-			
-		} else {
-			chirps, err = cfg.databaseQueries.GetChirpsById(r.Context(), authorId)
-			if err != nil {
-				log.Printf("Error getting chirps from database: %s", err.Error())
-				marshallError(w, err, 404)
-			}
+			log.Printf("Error getting chirps: %s", err.Error())
+			marshallError(w, err, 404)
+			return
+		}
+		setPageHeaders(w, r, total, page, perPage)
+		if checkNotModified(w, r, chirpsPageETag(chirps, total)) {
+			return
 		}
 	} else {
-		chirps, err = cfg.databaseQueries.GetChirps(r.Context())
+		chirps, err = cfg.listChirps(r.Context(), authorId, params.Sort == "desc")
 		if err != nil {
 			log.Printf("Error getting chirps: %s", err.Error())
 			marshallError(w, err, 404)
 			return
 		}
+		if checkNotModified(w, r, chirpsETag(chirps)) {
+			return
+		}
 	}
-	sortQuery := r.URL.Query().Get("sort")
-	if sortQuery == "desc" {
-		slices.Reverse(chirps)
-	}
-	type responseItem struct {
-		ID uuid.UUID `json:"id"`
-		Body string `json:"body"`
-		UserId uuid.UUID `json:"user_id"`
-	}
-	var responseItems []responseItem
+	var responseItems []Chirp
 	for _, chirp := range chirps {
-		item := responseItem{
+		item := Chirp{
 			ID: chirp.ID,
 			Body: chirp.Body,
-			UserId: chirp.UserID,
+			UserID: chirp.UserID,
 		}
 		responseItems = append(responseItems, item)
 	}
-	// Marshal response to JSON
-	dat, err := json.Marshal(responseItems)
-	if err != nil {
-		log.Printf("Error marshalling response body: %s", err.Error())
-		marshallError(w, err, 500)
-		return
-	}
-	// Send successful JSON response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	w.Write(dat)
+	render(w, r, 200, responseItems)
 }
 
 func (cfg *apiConfig) handlerGetChirpById(w http.ResponseWriter, r *http.Request) {
@@ -403,33 +759,21 @@ func (cfg *apiConfig) handlerGetChirpById(w http.ResponseWriter, r *http.Request
 		marshallError(w, err, 400)
 		return
 	}
-	chirp, err := cfg.databaseQueries.GetChirpById(r.Context(), path)
+	chirp, err := cfg.getChirpById(r.Context(), path)
 	if err != nil {
 		log.Printf("Error getting chirp: %s", err.Error())
 		marshallError(w, err, 404)
 		return
 	}
-	type response struct {
-		ID uuid.UUID `json:"id"`
-		Body string `json:"body"`
-		UserId uuid.UUID `json:"user_id"`
+	if checkNotModified(w, r, chirpETag(chirp)) {
+		return
 	}
-	resp := response{
+	resp := Chirp{
 		ID: chirp.ID,
 		Body: chirp.Body,
-		UserId: chirp.UserID,
-	}
-	// Marshal response to JSON
-	dat, err := json.Marshal(resp)
-	if err != nil {
-		log.Printf("Error marshalling response body: %s", err.Error())
-		marshallError(w, err, 500)
-		return
+		UserID: chirp.UserID,
 	}
-	// Send successful JSON response
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	w.Write(dat)
+	render(w, r, 200, resp)
 }
 
 func (cfg *apiConfig) handlerRefresh (w http.ResponseWriter, r *http.Request) {
@@ -439,12 +783,12 @@ func (cfg *apiConfig) handlerRefresh (w http.ResponseWriter, r *http.Request) {
 		marshallError(w, err, 401)
 		return
 	}
-	token, err := cfg.databaseQueries.GetRefreshToken(r.Context(), reqToken)
+	token, err := cfg.store.GetRefreshToken(r.Context(), reqToken)
 	if err != nil{
 		marshallError(w, err, 401)
 		return
 	}
-	newJWT, err := auth.MakeJWT(token.UserID, cfg.secretKey, time.Hour)
+	newJWT, err := cfg.makeJWT(token.UserID)
 	if err != nil {
 		marshallError(w, err, 500)
 		return
@@ -455,14 +799,7 @@ func (cfg *apiConfig) handlerRefresh (w http.ResponseWriter, r *http.Request) {
 	resp := response{
 		Token: newJWT,
 	}
-	res, err := json.Marshal(resp)
-	if err != nil {
-		marshallError(w, err, 500)
-		return
-	}
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(200)
-	w.Write(res)
+	render(w, r, 200, resp)
 }
 
 func (cfg *apiConfig) handlerRevoke (w http.ResponseWriter, r *http.Request) {
@@ -472,7 +809,7 @@ func (cfg *apiConfig) handlerRevoke (w http.ResponseWriter, r *http.Request) {
 		marshallError(w, err, 401)
 		return
 	}
-	err = cfg.databaseQueries.RevokeRefreshToken(r.Context(), reqToken)
+	err = cfg.store.RevokeRefreshToken(r.Context(), reqToken)
 	if err != nil {
 		marshallError(w, err, 500)
 		return
@@ -487,31 +824,23 @@ func (cfg *apiConfig) handlerPutUsers (w http.ResponseWriter, r *http.Request) {
 		marshallError(w, err, 401)
 		return
 	}
-	userId, err := auth.ValidateJWT(reqToken, cfg.secretKey)
+	userId, err := cfg.validateJWT(reqToken)
 	if err != nil {
 		log.Printf("Error validating JWT token: %s", err.Error())
 		marshallError(w, err, 401)
 		return
 	}
-	if userId != cfg.userId {
-		marshallError(w, fmt.Errorf("token does not match current user"), 401)
-		return
-	}
-	type parameters struct {
-		Email string `json:"email"`
-		Password string `json:"password"`
-	}
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err = decoder.Decode(&params)
+	params := credentials{}
+	err = decodeJSON(r, &params)
 	if err != nil {
 		log.Printf("Error decoding parameters: %s", err.Error())
-		marshallError(w, err, 500)
+		marshallError(w, err, decodeErrorStatus(err))
 		return
 	}
-	if cfg.platform != "dev" {
+	if !cfg.isDevPlatform() {
 		log.Printf("Error: update endpoint only available in dev mode")
-		marshallError(w, err, 403)
+		marshallError(w, fmt.Errorf("updating users is only available in dev mode"), 403)
+		return
 	}
 	hashedPassword, err := auth.HashPassword(params.Password)
 	if err != nil {
@@ -519,7 +848,11 @@ func (cfg *apiConfig) handlerPutUsers (w http.ResponseWriter, r *http.Request) {
 		marshallError(w, err, 500)
 		return
 	}
-	user, err := cfg.databaseQueries.PutNewUserData(r.Context(), database.PutNewUserDataParams{Email: params.Email, HashedPassword: hashedPassword, ID: userId})
+	user, err := cfg.store.PutNewUserData(r.Context(), database.PutNewUserDataParams{Email: params.Email, HashedPassword: hashedPassword, ID: userId, TenantID: tenantID(r.Context())})
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, errOtherTenant, 403)
+		return
+	}
 	if err != nil {
 		log.Printf("Error updating user: %s", err.Error())
 		marshallError(w, err, 500)
@@ -531,15 +864,7 @@ func (cfg *apiConfig) handlerPutUsers (w http.ResponseWriter, r *http.Request) {
 		UpdatedAt: user.UpdatedAt,
 		Email:     user.Email,
 	}
-	newUser, err := json.Marshal(data)
-	if err != nil {
-		log.Printf("Error marshalling response body: %s", err.Error())
-		marshallError(w, err, 500)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(200)
-	w.Write(newUser)
+	render(w, r, 200, data)
 }
 
 func (cfg *apiConfig) handlerDeleteChirp (w http.ResponseWriter, r *http.Request) {
@@ -549,13 +874,12 @@ func (cfg *apiConfig) handlerDeleteChirp (w http.ResponseWriter, r *http.Request
 		marshallError(w, err, 401)
 		return
 	}
-	userId, err := auth.ValidateJWT(reqToken, cfg.secretKey)
+	userId, err := cfg.validateJWT(reqToken)
 	if err != nil {
 		log.Printf("Error validating JWT token: %s", err.Error())
 		marshallError(w, err, 401)
 		return
 	}
-	cfg.userId = userId
 	pathValue := r.PathValue("chirpID")
 	path, err := uuid.Parse(pathValue)
 	if err != nil {
@@ -563,18 +887,17 @@ func (cfg *apiConfig) handlerDeleteChirp (w http.ResponseWriter, r *http.Request
 		marshallError(w, err, 400)
 		return
 	}
-	chirp, err := cfg.databaseQueries.GetChirpById(r.Context(), path)
-	if err != nil {
+	_, err = cfg.deleteChirp(r.Context(), userId, path)
+	if errors.Is(err, sql.ErrNoRows) {
 		log.Printf("Error finding chirp for deletion: %s", err.Error())
 		marshallError(w, err, 404)
 		return
 	}
-	if cfg.userId != chirp.UserID{
+	if errors.Is(err, errNotChirpAuthor) {
 		log.Printf("Not Authorized to delete chirp")
-		marshallError(w, fmt.Errorf("no authorization to delete chirp"), 403)
+		marshallError(w, err, 403)
 		return
 	}
-	err = cfg.databaseQueries.DeleteChirpById(r.Context(), database.DeleteChirpByIdParams{ID: path, UserID: userId})
 	if err != nil {
 		log.Printf("Error deleting chirp: %s", err.Error())
 		marshallError(w, err, 500)
@@ -592,21 +915,20 @@ func (cfg *apiConfig) handlerPolkaWebhook (w http.ResponseWriter, r *http.Reques
 	}
 	if apiKey != cfg.polkaKey {
 		log.Printf("invalid api key received from webhook")
-		marshallError(w, nil, 401)
+		marshallError(w, fmt.Errorf("invalid api key"), 401)
 		return
 	}
 	type parameters struct{
-		Event string `json:"event"`
+		Event string `json:"event" validate:"required"`
 		Data struct{
-			UserId string `json:"user_id"`
+			UserId string `json:"user_id" validate:"uuid"`
 		} `json:"data"`
 	}
-	decoder := json.NewDecoder(r.Body)
 	req := parameters{}
-	err = decoder.Decode(&req)
+	err = decodeJSON(r, &req)
 	if err != nil {
 		log.Printf("Error decoding webhook parameters: %s", err.Error())
-		marshallError(w, err, 500)
+		marshallError(w, err, decodeErrorStatus(err))
 		return
 	}
 	if req.Event != "user.upgraded" {
@@ -617,10 +939,13 @@ func (cfg *apiConfig) handlerPolkaWebhook (w http.ResponseWriter, r *http.Reques
 	userId, err := uuid.Parse(req.Data.UserId)
 	if err != nil {
 		log.Printf("Invalid user_id in webhook request: %s", err.Error())
-		marshallError(w, err, 500)
+		marshallError(w, validationError(validation.FieldError{Field: "data.user_id", Message: "is required"}), 400)
 		return
 	}
-	_, err = cfg.databaseQueries.UpgradeUserById(r.Context(), userId)
+	err = cfg.changeWithEvent(r.Context(), webhooks.UserUpgraded, func(tx store.Store) (any, error) {
+		_, err := tx.UpgradeUserById(r.Context(), database.UpgradeUserByIdParams{ID: userId, TenantID: tenantID(r.Context())})
+		return webhooks.UserUpgradedData{UserID: userId}, err
+	})
 	if err != nil {
 		log.Printf("Error updating user in webhook request: %s", err.Error())
 		marshallError(w, err, 404)
@@ -0,0 +1,4190 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/activitypub"
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/automod"
+	"github.com/diamondoughnut/httpChirpy/internal/cache"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/email"
+	"github.com/diamondoughnut/httpChirpy/internal/flags"
+	"github.com/diamondoughnut/httpChirpy/internal/grpcapi/chirpyv1"
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+	"github.com/diamondoughnut/httpChirpy/internal/loadshed"
+	"github.com/diamondoughnut/httpChirpy/internal/metrics"
+	"github.com/diamondoughnut/httpChirpy/internal/ratelimit"
+	"github.com/diamondoughnut/httpChirpy/internal/secrets"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/diamondoughnut/httpChirpy/internal/validation"
+	"github.com/diamondoughnut/httpChirpy/internal/webhooks"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestConfig() *apiConfig {
+	appStore := store.NewMemory()
+	cfg := &apiConfig{
+		store:            appStore,
+		platform:         "demo",
+		polkaKey:         "test-polka-key",
+		readinessTimeout: time.Second,
+		chirpCache:       cache.NewLRU[uuid.UUID, database.Chirp](10, time.Minute),
+		chirpListCache:   cache.NewLRU[string, []database.Chirp](10, time.Minute),
+		tenantCache:      cache.NewLRU[string, database.Tenant](10, time.Minute),
+		sharedCache:      cache.Noop{},
+		metrics:          metrics.New(nil),
+		adminToken:       "test-admin-token",
+		idempotencyTTL:   time.Hour,
+		flags:            flags.New(appStore, "demo", time.Minute),
+		jobs:             jobs.New(appStore, jobs.Options{}),
+	}
+	cfg.setJWTSecret("test-secret")
+	cfg.webhooks = webhooks.New(appStore, cfg.jobs, webhooks.Options{Timeout: time.Second, MaxAttempts: 3, AllowPrivate: true})
+	cfg.mailer = email.NewMailer(email.LogSender{}, cfg.jobs)
+	cfg.jobs.Register(bulkDeleteJobKind, cfg.runBulkDeleteChirps)
+	cfg.jobs.Register(twitterImportJobKind, cfg.runTwitterImport)
+	settings, err := loadRuntimeSettings(nil, appStore)
+	if err != nil {
+		panic(err)
+	}
+	cfg.settings.Store(settings)
+	cfg.scheduler, err = cfg.newScheduler()
+	if err != nil {
+		panic(err)
+	}
+	return cfg
+}
+
+func doRequest(t *testing.T, handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func registerAndLogin(t *testing.T, handler http.Handler, email string) User {
+	t.Helper()
+	creds := `{"email":"` + email + `","password":"hunter2"}`
+	rec := doRequest(t, handler, "POST", "/api/users", "", creds)
+	if rec.Code != 201 {
+		t.Fatalf("Expected 201 registering user, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "POST", "/api/login", "", creds)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 logging in, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var user User
+	if err := json.Unmarshal(rec.Body.Bytes(), &user); err != nil {
+		t.Fatalf("Failed to decode login response: %v", err)
+	}
+	return user
+}
+
+func TestChirpLifecycle(t *testing.T) {
+	handler := newTestConfig().routes()
+	user := registerAndLogin(t, handler, "walt@example.com")
+
+	rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"what a kerfuffle"}`)
+	if rec.Code != 201 {
+		t.Fatalf("Expected 201 creating chirp, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created struct {
+		ID   uuid.UUID `json:"id"`
+		Body string    `json:"body"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.Body != "what a ****" {
+		t.Fatalf("Expected profanity to be cleaned, got %q", created.Body)
+	}
+
+	rec = doRequest(t, handler, "GET", "/api/chirps/"+created.ID.String(), "", "")
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 fetching chirp, got %d", rec.Code)
+	}
+
+	rec = doRequest(t, handler, "DELETE", "/api/chirps/"+created.ID.String(), user.Token, "")
+	if rec.Code != 204 {
+		t.Fatalf("Expected 204 deleting chirp, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/api/chirps/"+created.ID.String(), "", "")
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404 after delete, got %d", rec.Code)
+	}
+}
+
+func TestChirpListPagination(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "pages@example.com")
+	for i := range 5 {
+		doRequest(t, handler, "POST", "/api/chirps", user.Token, fmt.Sprintf(`{"body":"chirp %d"}`, i))
+	}
+
+	rec := doRequest(t, handler, "GET", "/api/chirps?sort=desc&per_page=2&page=2", "", "")
+	var page []Chirp
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != 200 || len(page) != 2 || page[0].Body != "chirp 2" {
+		t.Fatalf("Expected the second page of two chirps, got %d %s", rec.Code, rec.Body.String())
+	}
+	want := `</api/chirps?page=1&per_page=2&sort=desc>; rel="first", </api/chirps?page=1&per_page=2&sort=desc>; rel="prev", ` +
+		`</api/chirps?page=3&per_page=2&sort=desc>; rel="next", </api/chirps?page=3&per_page=2&sort=desc>; rel="last"`
+	if got := rec.Header().Get("Link"); got != want {
+		t.Fatalf("Expected Link header\n%s\ngot\n%s", want, got)
+	}
+	if rec.Header().Get("X-Total-Count") != "5" {
+		t.Fatalf("Expected X-Total-Count: 5, got %q", rec.Header().Get("X-Total-Count"))
+	}
+
+	rec = doRequest(t, handler, "GET", "/api/chirps?page=3&per_page=2", "", "")
+	if strings.Contains(rec.Header().Get("Link"), `rel="next"`) {
+		t.Fatalf("Expected no next link on the last page, got %s", rec.Header().Get("Link"))
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps", "", ""); rec.Header().Get("Link") != "" {
+		t.Fatalf("Expected no Link header without pagination, got %s", rec.Header().Get("Link"))
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps?per_page=500", "", ""); rec.Code != 400 {
+		t.Fatalf("Expected 400 for an oversized page, got %d", rec.Code)
+	}
+}
+
+func TestChirpListPagesAreReadFromTheStore(t *testing.T) {
+	cfg := newTestConfig()
+	counting := &pageCountingStore{Store: cfg.store}
+	cfg.store = counting
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "paged@example.com")
+	other := registerAndLogin(t, handler, "unpaged@example.com")
+	for i := range 3 {
+		doRequest(t, handler, "POST", "/api/chirps", user.Token, fmt.Sprintf(`{"body":"chirp %d"}`, i))
+		doRequest(t, handler, "POST", "/api/chirps", other.Token, fmt.Sprintf(`{"body":"other %d"}`, i))
+	}
+
+	rec := doRequest(t, handler, "GET", "/api/chirps?author_id="+user.ID.String()+"&per_page=2&page=2", "", "")
+	var page []Chirp
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != 200 || len(page) != 1 || page[0].Body != "chirp 2" {
+		t.Fatalf("Expected the last chirp of the author on the second page, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Total-Count") != "3" {
+		t.Fatalf("Expected X-Total-Count: 3, got %q", rec.Header().Get("X-Total-Count"))
+	}
+	rec = doRequest(t, handler, "GET", "/api/chirps?page=9&per_page=2", "", "")
+	page = nil
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil || rec.Code != 200 || len(page) != 0 || rec.Header().Get("X-Total-Count") != "6" {
+		t.Fatalf("Expected an empty page past the end, got %d %s", rec.Code, rec.Body.String())
+	}
+	if counting.pageReads.Load() != 2 || counting.listReads.Load() != 0 {
+		t.Fatalf("Expected 2 page reads and no whole list reads, got %d and %d", counting.pageReads.Load(), counting.listReads.Load())
+	}
+}
+
+func TestWaitForDatabaseRetries(t *testing.T) {
+	down := errors.New("connection refused")
+	attempts := 0
+	err := waitForDatabase(context.Background(), func(context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return down
+		}
+		return nil
+	}, time.Second, time.Millisecond)
+	if err != nil || attempts != 3 {
+		t.Fatalf("Expected success on the third attempt, got %v after %d attempts", err, attempts)
+	}
+
+	err = waitForDatabase(context.Background(), func(context.Context) error { return down }, 20*time.Millisecond, time.Millisecond)
+	if !errors.Is(err, down) || !strings.Contains(err.Error(), "not reachable") {
+		t.Fatalf("Expected to give up with the last error, got %v", err)
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirpy.sock")
+	listener, err := listen("unix:"+path, 0o660, "")
+	if err != nil {
+		t.Fatalf("Expected to listen on the socket, got %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o660 {
+		t.Fatalf("Expected a socket with mode 0660, got %v (%v)", info, err)
+	}
+	_, err = listen("unix:"+path, 0o660, "")
+	if err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Fatalf("Expected a live socket to be refused, got %v", err)
+	}
+	listener.Close()
+	_, err = os.Stat(path)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected closing the listener to remove the socket, got %v", err)
+	}
+
+	// a socket left behind by a crash is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	listener, err = listen("unix:"+path, 0o600, "")
+	if err != nil {
+		t.Fatalf("Expected a stale socket to be replaced, got %v", err)
+	}
+	listener.Close()
+}
+
+func TestInheritedListeners(t *testing.T) {
+	httpListener, grpcListener, adminListener, err := listenersFrom(systemdFirstFD)
+	if httpListener != nil || grpcListener != nil || adminListener != nil || err != nil {
+		t.Fatalf("Expected no listeners without LISTEN_FDS, got %v %v %v %v", httpListener, grpcListener, adminListener, err)
+	}
+
+	original, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+	file, err := original.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "chirpy.socket")
+	httpListener, grpcListener, adminListener, err = listenersFrom(int(file.Fd()))
+	if err != nil || httpListener == nil || grpcListener != nil || adminListener != nil {
+		t.Fatalf("Expected the socket to serve HTTP, got %v %v %v", httpListener, grpcListener, err)
+	}
+	defer httpListener.Close()
+	if httpListener.Addr().String() != original.Addr().String() {
+		t.Fatalf("Expected the inherited socket on %s, got %s", original.Addr(), httpListener.Addr())
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Fatal("Expected LISTEN_FDS to be cleared so child processes do not inherit it")
+	}
+}
+
+func TestDrainAndShutdownAnswersAcceptedConnections(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain := newDrainListener(listener)
+	conns := &connCounter{}
+	srv := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(204) }),
+		ConnState: conns.track,
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(drain) }()
+
+	// connected before the shutdown but sends its request only afterwards
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for conns.open.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- drainAndShutdown(context.Background(), srv, drain, conns) }()
+	time.Sleep(50 * time.Millisecond)
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: chirpy\r\n\r\n")
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil || resp.StatusCode != 204 || !resp.Close {
+		t.Fatalf("Expected the request to be answered and the connection closed, got %v (%v)", resp, err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Expected a clean shutdown, got %v", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("Expected Serve to report ErrServerClosed, got %v", err)
+	}
+}
+
+func TestCreateChirp_TooLong(t *testing.T) {
+	handler := newTestConfig().routes()
+	user := registerAndLogin(t, handler, "long@example.com")
+
+	body := `{"body":"` + strings.Repeat("a", 141) + `"}`
+	rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, body)
+	if rec.Code != 400 {
+		t.Fatalf("Expected 400 for chirp over 140 characters, got %d", rec.Code)
+	}
+}
+
+func TestCreateChirp_Unauthenticated(t *testing.T) {
+	handler := newTestConfig().routes()
+
+	rec := doRequest(t, handler, "POST", "/api/chirps", "", `{"body":"hello"}`)
+	if rec.Code != 401 {
+		t.Fatalf("Expected 401 without a token, got %d", rec.Code)
+	}
+}
+
+func TestReadyz_InMemory(t *testing.T) {
+	handler := newTestConfig().routes()
+
+	rec := doRequest(t, handler, "GET", "/api/readyz", "", "")
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 from readiness probe, got %d", rec.Code)
+	}
+}
+
+func TestAdminStatusReportsLaggingQueues(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	status := func() statusReport {
+		t.Helper()
+		rec := doRequest(t, handler, "GET", "/admin/status", cfg.adminToken, "")
+		var report statusReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || rec.Code != 200 {
+			t.Fatalf("Expected 200 with a status report, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return report
+	}
+
+	report := status()
+	if report.Status != statusOK || len(report.Components) != 5 {
+		t.Fatalf("Expected five ok components, got %+v", report)
+	}
+	// an email due an hour ago that no worker picked up
+	cfg.store.EnqueueJob(context.Background(), database.EnqueueJobParams{Kind: email.JobKind, Payload: "{}", MaxAttempts: 1, RunAt: time.Now().UTC().Add(-time.Hour)})
+	report = status()
+	if report.Status != statusDegraded {
+		t.Fatalf("Expected a lagging queue to degrade the status, got %+v", report)
+	}
+	for _, component := range report.Components {
+		lagging := component.Name == "job_queue" || component.Name == "mailer"
+		if lagging != (component.Status == statusDegraded) {
+			t.Fatalf("Expected only the job queue and mailer to be degraded, got %+v", report.Components)
+		}
+	}
+}
+
+func TestCacheControlPolicies(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "cached@example.com")
+	rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"cache me"}`)
+	var chirp Chirp
+	json.Unmarshal(rec.Body.Bytes(), &chirp)
+
+	for _, tc := range []struct {
+		path, token, want string
+	}{
+		{"/api/chirps", "", "public, max-age=0, s-maxage=10"},
+		{"/api/v1/chirps/" + chirp.ID.String(), "", "public, max-age=60, s-maxage=300"},
+		{"/api/chirps/" + uuid.NewString(), "", "no-store"},
+		{"/api/chirps", user.Token, "no-store"},
+		{"/api/webhooks", user.Token, "no-store"},
+		{"/api/healthz", "", "no-store"},
+		{"/app/assets/logo.png", "", "public, max-age=3600, s-maxage=3600"},
+		{"/app/some/client/route", "", "no-cache"},
+		{"/admin/api/stats", cfg.adminToken, "no-store"},
+	} {
+		rec := doRequest(t, handler, "GET", tc.path, tc.token, "")
+		if got := rec.Header().Get("Cache-Control"); got != tc.want {
+			t.Fatalf("Expected Cache-Control %q for %s, got %q (status %d)", tc.want, tc.path, got, rec.Code)
+		}
+	}
+	// public responses differ by tenant
+	rec = doRequest(t, handler, "GET", "/api/chirps", "", "")
+	if !slices.Contains(rec.Header().Values("Vary"), "X-Tenant") {
+		t.Fatalf("Expected Vary to include X-Tenant, got %v", rec.Header().Values("Vary"))
+	}
+}
+
+func TestStaticFilesServeRanges(t *testing.T) {
+	handler := newTestConfig().routes()
+	logo, err := staticFiles.ReadFile("assets/logo.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/app/assets/logo.png", nil)
+	req.Header.Set("Range", "bytes=4-11")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 206 || rec.Body.String() != string(logo[4:12]) {
+		t.Fatalf("Expected bytes 4-11 with 206, got %d (%d bytes)", rec.Code, rec.Body.Len())
+	}
+	if want := fmt.Sprintf("bytes 4-11/%d", len(logo)); rec.Header().Get("Content-Range") != want {
+		t.Fatalf("Expected Content-Range %q, got %q", want, rec.Header().Get("Content-Range"))
+	}
+	if rec.Header().Get("Cache-Control") != staticCachePolicy.header() {
+		t.Fatalf("Expected the static cache policy, got %q", rec.Header().Get("Cache-Control"))
+	}
+
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(logo)+10))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 416 {
+		t.Fatalf("Expected 416 for a range past the end, got %d", rec.Code)
+	}
+}
+
+func TestStaticFilesConditionalRequests(t *testing.T) {
+	handler := newTestConfig().routes()
+	rec := doRequest(t, handler, "GET", "/app/assets/logo.png", "", "")
+	etag, lastModified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if rec.Code != 200 || !strings.HasPrefix(etag, `"`) || lastModified == "" {
+		t.Fatalf("Expected a strong ETag and Last-Modified, got %d %v", rec.Code, rec.Header())
+	}
+
+	for _, header := range []struct{ name, value string }{
+		{"If-None-Match", etag},
+		{"If-None-Match", "W/" + etag},
+		{"If-Modified-Since", lastModified},
+	} {
+		req := httptest.NewRequest("GET", "/app/assets/logo.png", nil)
+		req.Header.Set(header.name, header.value)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != 304 || rec.Body.Len() != 0 {
+			t.Fatalf("Expected 304 for %s: %s, got %d", header.name, header.value, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/app/assets/logo.png", nil)
+	req.Header.Set("If-None-Match", `"stale"`)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 for a changed file, got %d", rec.Code)
+	}
+	// client-side routes revalidate the page they fall back to
+	rec = doRequest(t, handler, "GET", "/app/users/123", "", "")
+	if rec.Code != 200 || rec.Header().Get("ETag") == "" || rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("Expected index.html with an ETag, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestRequestTimeouts(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.requestTimeout = 15 * time.Second
+	cfg.requestTimeouts = map[string]time.Duration{"GET /chirps": 2 * time.Second}
+	for pattern, want := range map[string]time.Duration{
+		"GET /chirps":        2 * time.Second,
+		"POST /login":        5 * time.Second,
+		"POST /admin/backup": 0,
+		"POST /chirps":       15 * time.Second,
+	} {
+		if got := cfg.routeTimeout(pattern); got != want {
+			t.Fatalf("Expected %s to get %v, got %v", pattern, want, got)
+		}
+	}
+
+	slow := withTimeout(10*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.WriteHeader(200)
+	}))
+	rec := httptest.NewRecorder()
+	slow.ServeHTTP(rec, httptest.NewRequest("GET", "/api/chirps", nil))
+	var body apiErrorResponse
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != 503 || body.Error.Code != "request_timeout" {
+		t.Fatalf("Expected 503 request_timeout, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	fast := withTimeout(time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/api/chirps/1")
+		w.WriteHeader(201)
+		w.Write([]byte("created"))
+	}))
+	rec = httptest.NewRecorder()
+	fast.ServeHTTP(rec, httptest.NewRequest("POST", "/api/chirps", nil))
+	if rec.Code != 201 || rec.Header().Get("Location") != "/api/chirps/1" || rec.Body.String() != "created" {
+		t.Fatalf("Expected the handler's response, got %d %v %q", rec.Code, rec.Header(), rec.Body.String())
+	}
+}
+
+// Keeps the events a sentry.Client would send
+type capturedEvents struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (c *capturedEvents) Configure(sentry.ClientOptions)            {}
+func (c *capturedEvents) Flush(time.Duration) bool                  { return true }
+func (c *capturedEvents) FlushWithContext(ctx context.Context) bool { return true }
+func (c *capturedEvents) Close()                                    {}
+func (c *capturedEvents) SendEvent(event *sentry.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, event)
+}
+
+func TestErrorReportingScrubsAndCaptures(t *testing.T) {
+	cfg := newTestConfig()
+	transport := &capturedEvents{}
+	client, err := sentry.NewClient(sentry.ClientOptions{Dsn: "https://key@sentry.example.com/1", Transport: transport, BeforeSend: scrubEvent})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.errorReporter = client
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		marshallError(w, errors.New(`update failed for {"password":"hunter2"} with Bearer abc.def`), 500)
+	})
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {})
+	handler := middlewareRequestID(cfg.middlewareErrorReporting(mux, mux))
+
+	req := httptest.NewRequest("GET", "/fail?token=secret&page=2", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Expected the panic to reach the server")
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ok", nil))
+
+	if len(transport.events) != 2 {
+		t.Fatalf("Expected the 500 and the panic to be reported, got %d events", len(transport.events))
+	}
+	event := transport.events[0]
+	if len(event.Exception) != 1 || event.Exception[0].Stacktrace == nil || event.Tags["route"] != "GET /fail" || event.Tags["request_id"] == "" {
+		t.Fatalf("Expected the error with a stack trace, route and request ID, got %+v", event)
+	}
+	sent := event.Exception[0].Value + event.Request.QueryString + event.Request.Headers["Authorization"]
+	for _, secret := range []string{"hunter2", "abc.def", "secret"} {
+		if strings.Contains(sent, secret) {
+			t.Fatalf("Expected %q to be scrubbed, got %q", secret, sent)
+		}
+	}
+	if !strings.Contains(event.Request.QueryString, "page=2") {
+		t.Fatalf("Expected other query parameters to be kept, got %q", event.Request.QueryString)
+	}
+}
+
+func TestFeatureFlagAdminLifecycle(t *testing.T) {
+	cfg := newTestConfig()
+	mux := cfg.routes()
+
+	rec := doRequest(t, mux, "PUT", "/admin/flags/polls", "", `{"enabled": true}`)
+	if rec.Code != 401 {
+		t.Fatalf("Expected 401 without admin token, got %d", rec.Code)
+	}
+	rec = doRequest(t, mux, "PUT", "/admin/flags/polls", cfg.adminToken, `{"enabled": true, "rollout_percentage": 101}`)
+	if rec.Code != 400 {
+		t.Fatalf("Expected 400 for out of range rollout, got %d", rec.Code)
+	}
+	rec = doRequest(t, mux, "PUT", "/admin/flags/polls", cfg.adminToken, `{"enabled": true, "environments": ["demo"]}`)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 saving flag, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// evaluation sees the change immediately because saving invalidates the cache
+	rec = doRequest(t, mux, "GET", "/api/flags", "", "")
+	var evaluated map[string]bool
+	json.Unmarshal(rec.Body.Bytes(), &evaluated)
+	if !evaluated["polls"] {
+		t.Fatalf("Expected polls to be on, got %s", rec.Body.String())
+	}
+	rec = doRequest(t, mux, "DELETE", "/admin/flags/polls", cfg.adminToken, "")
+	if rec.Code != 204 {
+		t.Fatalf("Expected 204 deleting flag, got %d", rec.Code)
+	}
+	if cfg.flags.Enabled(context.Background(), "polls", uuid.Nil) {
+		t.Errorf("Expected deleted flag to be off")
+	}
+	rec = doRequest(t, mux, "DELETE", "/admin/flags/polls", cfg.adminToken, "")
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404 deleting missing flag, got %d", rec.Code)
+	}
+}
+
+func TestReloadProfanityList(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "reload@example.com")
+
+	t.Setenv("PROFANITY_WORDS", "gosh")
+	rec := doRequest(t, handler, "POST", "/admin/reload", cfg.adminToken, "")
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 reloading settings, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"gosh what a kerfuffle"}`)
+	var created struct {
+		Body string `json:"body"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.Body != "**** what a kerfuffle" {
+		t.Fatalf("Expected the reloaded word list to apply, got %q", created.Body)
+	}
+
+	// invalid values are rejected and the running settings stay in place
+	t.Setenv("RATE_LIMIT_RPS", "fast")
+	rec = doRequest(t, handler, "POST", "/admin/reload", cfg.adminToken, "")
+	if rec.Code != 400 {
+		t.Fatalf("Expected 400 for invalid settings, got %d", rec.Code)
+	}
+	if !cfg.settings.Load().profanity.Contains("gosh") {
+		t.Errorf("Expected previous settings to survive a failed reload")
+	}
+}
+
+func TestStaticFilesAreEmbedded(t *testing.T) {
+	handler := newTestConfig().routes()
+
+	rec := doRequest(t, handler, "GET", "/app/", "", "")
+	if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected index.html at /app/, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	rec = doRequest(t, handler, "GET", "/app/assets/logo.png", "", "")
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("Expected logo.png served as image/png, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, path := range []string{"/app/.env", "/app/main.go", "/app/go.mod"} {
+		rec = doRequest(t, handler, "GET", path, "", "")
+		if rec.Code != 404 {
+			t.Errorf("Expected 404 for %s, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestStaticSPAFallback(t *testing.T) {
+	handler := newTestConfig().routes()
+
+	for _, path := range []string{"/app/chirps", "/app/users/123/settings"} {
+		rec := doRequest(t, handler, "GET", path, "", "")
+		if rec.Code != 200 || !strings.Contains(rec.Body.String(), "Welcome to Chirpy") {
+			t.Errorf("Expected index.html for client route %s, got %d", path, rec.Code)
+		}
+	}
+	for _, path := range []string{"/app/assets/missing.js", "/app/favicon.ico"} {
+		rec := doRequest(t, handler, "GET", path, "", "")
+		if rec.Code != 404 {
+			t.Errorf("Expected 404 for missing asset %s, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestVisitsArePersisted(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+
+	for i := 0; i < 3; i++ {
+		doRequest(t, handler, "GET", "/app/", "", "")
+	}
+	err := cfg.flushVisits(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error flushing visits, got %v", err)
+	}
+	doRequest(t, handler, "GET", "/app/", "", "")
+
+	// a restarted process shares the store but starts with empty counters
+	restarted := newTestConfig()
+	restarted.store = cfg.store
+	rec := doRequest(t, restarted.routes(), "GET", "/admin/metrics", cfg.adminToken, "")
+	if !strings.Contains(rec.Body.String(), "visited 3 times") {
+		t.Fatalf("Expected flushed visits to survive a restart, got %s", rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/admin/metrics", cfg.adminToken, "")
+	if !strings.Contains(rec.Body.String(), "visited 4 times") {
+		t.Fatalf("Expected unflushed visits to be included, got %s", rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), time.Now().UTC().Format(time.DateOnly)) {
+		t.Errorf("Expected a row for today, got %s", rec.Body.String())
+	}
+}
+
+func TestAdminRoutesRequireToken(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+
+	for _, route := range [][2]string{{"GET", "/admin/metrics"}, {"POST", "/admin/reset?confirm=true"}, {"POST", "/admin/reload"}, {"GET", "/admin/flags"}, {"GET", "/admin/unknown"}} {
+		rec := doRequest(t, handler, route[0], route[1], "", "")
+		if rec.Code != 401 {
+			t.Errorf("Expected 401 for %s %s without a token, got %d", route[0], route[1], rec.Code)
+		}
+		rec = doRequest(t, handler, route[0], route[1], "wrong-token", "")
+		if rec.Code != 401 {
+			t.Errorf("Expected 401 for %s %s with a wrong token, got %d", route[0], route[1], rec.Code)
+		}
+	}
+	rec := doRequest(t, handler, "GET", "/admin/unknown", cfg.adminToken, "")
+	if rec.Code != 404 {
+		t.Errorf("Expected 404 for unknown admin path with a token, got %d", rec.Code)
+	}
+
+	cfg.adminToken = ""
+	rec = doRequest(t, handler, "GET", "/admin/metrics", "anything", "")
+	if rec.Code != 403 {
+		t.Errorf("Expected 403 when no admin token is configured, got %d", rec.Code)
+	}
+}
+
+func TestResetRequiresConfirmation(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	registerAndLogin(t, handler, "keep@example.com")
+
+	rec := doRequest(t, handler, "POST", "/admin/reset", cfg.adminToken, "")
+	if rec.Code != 400 {
+		t.Fatalf("Expected 400 without confirmation, got %d", rec.Code)
+	}
+	if _, err := cfg.store.GetUserByEmail(context.Background(), database.GetUserByEmailParams{Email: "keep@example.com"}); err != nil {
+		t.Fatalf("Expected users to survive an unconfirmed reset, got %v", err)
+	}
+	rec = doRequest(t, handler, "POST", "/admin/reset?confirm=true", cfg.adminToken, "")
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 with confirmation, got %d", rec.Code)
+	}
+	if _, err := cfg.store.GetUserByEmail(context.Background(), database.GetUserByEmailParams{Email: "keep@example.com"}); err == nil {
+		t.Fatalf("Expected users to be deleted after a confirmed reset")
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.metrics.Middleware(cfg.routes(), cfg.routes())
+	user := registerAndLogin(t, handler, "stats@example.com")
+	doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"hello"}`)
+
+	rec := doRequest(t, handler, "GET", "/admin/api/stats", cfg.adminToken, "")
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 getting stats, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var stats struct {
+		Users          int64 `json:"users"`
+		NewChirps24h   int64 `json:"new_chirps_24h"`
+		ActiveSessions int64 `json:"active_sessions"`
+		Routes         []struct {
+			Route    string `json:"route"`
+			Requests uint64 `json:"requests"`
+		} `json:"routes"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &stats)
+	if stats.Users != 1 || stats.NewChirps24h != 1 || stats.ActiveSessions != 1 {
+		t.Fatalf("Expected 1 user, 1 new chirp and 1 session, got %+v", stats)
+	}
+	found := false
+	for _, route := range stats.Routes {
+		found = found || (route.Route == "/api/chirps" && route.Requests == 1)
+	}
+	if !found {
+		t.Errorf("Expected a route entry for /api/chirps, got %+v", stats.Routes)
+	}
+
+	rec = doRequest(t, handler, "GET", "/admin/metrics", cfg.adminToken, "")
+	if !strings.Contains(rec.Body.String(), "Chirpy has been visited 0 times!") || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("Expected an uncached dashboard with the visit count, got %s", rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), cfg.adminToken) {
+		t.Errorf("Expected the dashboard not to contain the admin token")
+	}
+}
+
+func TestPlatformStatsRollup(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	ctx := context.Background()
+	alice := registerAndLogin(t, handler, "alice@example.com")
+	registerAndLogin(t, handler, "bob@example.com")
+	doRequest(t, handler, "POST", "/api/chirps", alice.Token, `{"body":"kept"}`)
+	rec := doRequest(t, handler, "POST", "/api/chirps", alice.Token, `{"body":"deleted"}`)
+	var chirp struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &chirp)
+	doRequest(t, handler, "DELETE", "/api/chirps/"+chirp.ID, alice.Token, "")
+	req := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(`{"event":"user.upgraded","data":{"user_id":"`+alice.ID.String()+`"}}`))
+	req.Header.Set("Authorization", "ApiKey "+cfg.polkaKey)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// the endpoint only reads rollups, so nothing shows before the task runs
+	rec = doRequest(t, handler, "GET", "/admin/stats", cfg.adminToken, "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"rollups":[]`) {
+		t.Fatalf("Expected no rollups yet, got %d: %s", rec.Code, rec.Body.String())
+	}
+	ran, err := cfg.scheduler.RunSlot(ctx, "rollup_stats", time.Now().UTC().Truncate(15*time.Minute))
+	if !ran || err != nil {
+		t.Fatalf("Expected rollup_stats to run, got ran=%v err=%v", ran, err)
+	}
+
+	type rollup struct {
+		StartsAt       time.Time `json:"starts_at"`
+		NewUsers       int64     `json:"new_users"`
+		ActiveUsers    int64     `json:"active_users"`
+		ChirpsPosted   int64     `json:"chirps_posted"`
+		ChirpsDeleted  int64     `json:"chirps_deleted"`
+		RedConversions int64     `json:"red_conversions"`
+		Final          bool      `json:"final"`
+	}
+	var resp struct {
+		Period  string   `json:"period"`
+		Rollups []rollup `json:"rollups"`
+	}
+	rec = doRequest(t, handler, "GET", "/admin/stats", cfg.adminToken, "")
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	// the backfill covers the past week, and only today has activity
+	if resp.Period != "day" || len(resp.Rollups) != 8 {
+		t.Fatalf("Expected 8 daily rollups, got %s", rec.Body.String())
+	}
+	want := rollup{NewUsers: 2, ActiveUsers: 2, ChirpsPosted: 2, ChirpsDeleted: 1, RedConversions: 1}
+	today := resp.Rollups[7]
+	want.StartsAt = today.StartsAt
+	if today != want || !today.StartsAt.Equal(time.Now().UTC().Truncate(24*time.Hour)) {
+		t.Errorf("Expected today's rollup to be %+v, got %+v", want, today)
+	}
+	if past := resp.Rollups[0]; !past.Final || past.NewUsers != 0 {
+		t.Errorf("Expected an empty final rollup a week ago, got %+v", past)
+	}
+
+	rec = doRequest(t, handler, "GET", "/admin/stats?period=week", cfg.adminToken, "")
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	week := resp.Rollups[len(resp.Rollups)-1]
+	if resp.Period != "week" || week.StartsAt.Weekday() != time.Monday || week.ChirpsPosted != 2 || week.Final {
+		t.Errorf("Expected this week's rollup to start on Monday with 2 chirps, got %s", rec.Body.String())
+	}
+
+	for _, query := range []string{"period=month", "since=yesterday", "since=2025-02-01T00:00:00Z&until=2025-01-01T00:00:00Z"} {
+		if rec := doRequest(t, handler, "GET", "/admin/stats?"+query, cfg.adminToken, ""); rec.Code != 400 {
+			t.Errorf("Expected 400 for %s, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestAdminCSVExports(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	registerAndLogin(t, handler, "alice@example.com")
+	registerAndLogin(t, handler, "=cmd@example.com")
+	doRequest(t, handler, "PUT", "/admin/flags/polls", cfg.adminToken, `{"enabled": true}`)
+	cfg.scheduler.RunSlot(context.Background(), "rollup_stats", time.Now().UTC().Truncate(15*time.Minute))
+
+	readCSV := func(rec *httptest.ResponseRecorder) [][]string {
+		t.Helper()
+		if rec.Code != 200 || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") || !strings.Contains(rec.Header().Get("Content-Disposition"), ".csv") {
+			t.Fatalf("Expected a CSV download, got %d %v: %s", rec.Code, rec.Header(), rec.Body.String())
+		}
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil {
+			t.Fatalf("Expected valid CSV, got %v", err)
+		}
+		return rows
+	}
+
+	users := readCSV(doRequest(t, handler, "GET", "/admin/users?format=csv", cfg.adminToken, ""))
+	if len(users) != 3 || users[0][3] != "email" {
+		t.Fatalf("Expected a header and 2 users, got %v", users)
+	}
+	emails := []string{users[1][3], users[2][3]}
+	slices.Sort(emails)
+	// a leading = would run as a formula in a spreadsheet
+	if emails[0] != "'=cmd@example.com" || emails[1] != "alice@example.com" {
+		t.Errorf("Expected both emails with the formula escaped, got %v", emails)
+	}
+
+	req := httptest.NewRequest("GET", "/admin/audit-log?action=feature_flag.set", nil)
+	req.Header.Set("Authorization", "Bearer "+cfg.adminToken)
+	req.Header.Set("Accept", "text/csv, application/json;q=0.5")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	entries := readCSV(rec)
+	if len(entries) != 2 || entries[0][7] != "details" || entries[1][4] != "polls" {
+		t.Errorf("Expected the flag update as CSV, got %v", entries)
+	}
+
+	stats := readCSV(doRequest(t, handler, "GET", "/admin/stats?format=csv&period=week", cfg.adminToken, ""))
+	if stats[0][0] != "starts_at" || stats[len(stats)-1][2] != "2" {
+		t.Errorf("Expected this week's 2 new users as CSV, got %v", stats)
+	}
+	// an empty listing is still a header
+	if empty := readCSV(doRequest(t, handler, "GET", "/admin/audit-log?format=csv&actor=nobody", cfg.adminToken, "")); len(empty) != 1 {
+		t.Errorf("Expected only the header, got %v", empty)
+	}
+
+	// JSON stays the default, and pages through users by id
+	rec = doRequest(t, handler, "GET", "/admin/users?limit=1", cfg.adminToken, "")
+	var page []struct {
+		ID uuid.UUID `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if rec.Code != 200 || len(page) != 1 || !strings.Contains(rec.Header().Get("Link"), "after="+page[0].ID.String()) {
+		t.Fatalf("Expected one user and a link to the next page, got %d %s", rec.Code, rec.Body.String())
+	}
+	first := page[0].ID
+	rec = doRequest(t, handler, "GET", "/admin/users?limit=1&after="+first.String(), cfg.adminToken, "")
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if len(page) != 1 || page[0].ID == first {
+		t.Errorf("Expected the second user, got %s", rec.Body.String())
+	}
+}
+
+func TestAdminSearch(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	alice := registerAndLogin(t, handler, "alice@example.com")
+	registerAndLogin(t, handler, "bob@example.com")
+	rec := doRequest(t, handler, "POST", "/api/chirps", alice.Token, `{"body":"Alice says 100% hello"}`)
+	var chirp struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &chirp)
+	doRequest(t, handler, "POST", "/api/chirps", alice.Token, `{"body":"1000 goodbyes"}`)
+
+	type response struct {
+		Results []struct {
+			Type string `json:"type"`
+			User *struct {
+				Email string `json:"email"`
+			} `json:"user"`
+			Chirp *struct {
+				ID          string `json:"id"`
+				AuthorEmail string `json:"author_email"`
+			} `json:"chirp"`
+			Links map[string]string `json:"links"`
+		} `json:"results"`
+	}
+	search := func(query string) response {
+		t.Helper()
+		rec := doRequest(t, handler, "GET", "/admin/search?"+query, cfg.adminToken, "")
+		if rec.Code != 200 {
+			t.Fatalf("Expected 200 searching %s, got %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var resp response
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp
+	}
+
+	// users by email and chirps by body, case-insensitively and users first
+	resp := search("q=ALICE")
+	if len(resp.Results) != 2 || resp.Results[0].Type != "user" || resp.Results[0].User.Email != "alice@example.com" ||
+		resp.Results[1].Type != "chirp" || resp.Results[1].Chirp.AuthorEmail != "alice@example.com" {
+		t.Fatalf("Expected alice and her chirp, got %+v", resp)
+	}
+	if links := resp.Results[1].Links; links["self"] != "/api/chirps/"+chirp.ID || links["author"] != "/admin/search?q="+alice.ID.String()+"&type=user" {
+		t.Errorf("Expected deep links to the chirp and its author, got %v", links)
+	}
+	// % is matched literally rather than as a wildcard
+	if resp := search("q=" + url.QueryEscape("0%")); len(resp.Results) != 1 || resp.Results[0].Chirp.ID != chirp.ID {
+		t.Errorf("Expected only the chirp containing 0%%, got %+v", resp)
+	}
+	if resp := search("q=" + chirp.ID); len(resp.Results) != 1 || resp.Results[0].Chirp.ID != chirp.ID {
+		t.Errorf("Expected the chirp found by its id, got %+v", resp)
+	}
+	if resp := search("q=" + alice.ID.String() + "&type=user"); len(resp.Results) != 1 || resp.Results[0].User.Email != "alice@example.com" {
+		t.Errorf("Expected alice found by her id, got %+v", resp)
+	}
+	if resp := search("q=example.com&type=user&limit=1"); len(resp.Results) != 1 || resp.Results[0].User.Email != "bob@example.com" {
+		t.Errorf("Expected only the newest user, got %+v", resp)
+	}
+
+	for _, query := range []string{"", "q=%20", "q=a&type=webhook", "q=a&limit=0"} {
+		if rec := doRequest(t, handler, "GET", "/admin/search?"+query, cfg.adminToken, ""); rec.Code != 400 {
+			t.Errorf("Expected 400 for %q, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestAuditLogRecordsAdminActions(t *testing.T) {
+	cfg := newTestConfig()
+	handler := middlewareRequestID(cfg.routes())
+
+	doRequest(t, handler, "PUT", "/admin/flags/polls", cfg.adminToken, `{"enabled": true}`)
+	req := httptest.NewRequest("POST", "/admin/reset?confirm=true", nil)
+	req.Header.Set("Authorization", "Bearer "+cfg.adminToken)
+	req.Header.Set("X-Request-ID", "reset-123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := doRequest(t, handler, "GET", "/admin/audit-log", cfg.adminToken, "")
+	var entries []struct {
+		Actor     string `json:"actor"`
+		Action    string `json:"action"`
+		Target    string `json:"target"`
+		RequestID string `json:"request_id"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &entries)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 audit entries, got %s", rec.Body.String())
+	}
+	if entries[0].Action != "reset" || entries[0].RequestID != "reset-123" || entries[0].Actor != adminTokenActor {
+		t.Errorf("Expected the reset first with its request ID, got %+v", entries[0])
+	}
+	if entries[1].Action != "feature_flag.set" || entries[1].Target != "polls" || entries[1].RequestID == "" {
+		t.Errorf("Expected the flag change with a generated request ID, got %+v", entries[1])
+	}
+
+	rec = doRequest(t, handler, "GET", "/admin/audit-log?action=feature_flag.set&limit=10", cfg.adminToken, "")
+	json.Unmarshal(rec.Body.Bytes(), &entries)
+	if len(entries) != 1 || entries[0].Target != "polls" {
+		t.Errorf("Expected the action filter to match only the flag change, got %s", rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/admin/audit-log?since=yesterday", cfg.adminToken, "")
+	if rec.Code != 400 {
+		t.Errorf("Expected 400 for an invalid since, got %d", rec.Code)
+	}
+}
+
+func TestAdminJobsRetryDeadJob(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	ctx := context.Background()
+	job, _ := cfg.jobs.Enqueue(ctx, "unregistered", map[string]string{"to": "x"})
+	cfg.jobs.RunOnce(ctx)
+
+	rec := doRequest(t, handler, "GET", "/admin/jobs", cfg.adminToken, "")
+	var dead []struct {
+		ID        uuid.UUID `json:"id"`
+		LastError string    `json:"last_error"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &dead)
+	if len(dead) != 1 || dead[0].ID != job.ID || dead[0].LastError == "" {
+		t.Fatalf("Expected the dead job with its error, got %s", rec.Body.String())
+	}
+	rec = doRequest(t, handler, "POST", "/admin/jobs/"+job.ID.String()+"/retry", cfg.adminToken, "")
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 retrying job, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "POST", "/admin/jobs/"+job.ID.String()+"/retry", cfg.adminToken, "")
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404 retrying a job that is no longer dead, got %d", rec.Code)
+	}
+}
+
+func TestAdminScheduleRunsPruneSessions(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	ctx := context.Background()
+	user, _ := cfg.store.CreateUser(ctx, database.CreateUserParams{Email: "old@example.com", HashedPassword: "x"})
+	cfg.store.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "expired", UserID: user.ID, ExpiresAt: time.Now().UTC().Add(-time.Hour)})
+	cfg.store.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "live", UserID: user.ID, ExpiresAt: time.Now().UTC().Add(time.Hour)})
+
+	ran, err := cfg.scheduler.RunSlot(ctx, "prune_sessions", time.Now().UTC().Truncate(time.Hour))
+	if !ran || err != nil {
+		t.Fatalf("Expected prune_sessions to run, got ran=%v err=%v", ran, err)
+	}
+	if _, err := cfg.store.GetRefreshToken(ctx, "live"); err != nil {
+		t.Fatalf("Expected the live token to survive pruning, got %v", err)
+	}
+
+	rec := doRequest(t, handler, "GET", "/admin/schedule?task=prune_sessions", cfg.adminToken, "")
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Tasks []struct {
+			Name string `json:"name"`
+		} `json:"tasks"`
+		Runs []struct {
+			Task   string `json:"task"`
+			Status string `json:"status"`
+		} `json:"runs"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Tasks) != 13 {
+		t.Fatalf("Expected 13 scheduled tasks, got %s", rec.Body.String())
+	}
+	if len(resp.Runs) != 1 || resp.Runs[0].Task != "prune_sessions" || resp.Runs[0].Status != "succeeded" {
+		t.Fatalf("Expected one succeeded prune_sessions run, got %s", rec.Body.String())
+	}
+}
+
+func TestRetentionRuleDryRunAndReport(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	ctx := context.Background()
+	user, _ := cfg.store.CreateUser(ctx, database.CreateUserParams{Email: "old@example.com", HashedPassword: "x"})
+	cfg.store.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "revoked", UserID: user.ID, ExpiresAt: time.Now().UTC().Add(time.Hour)})
+	cfg.store.RevokeRefreshToken(ctx, "revoked")
+	cfg.store.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{Token: "live", UserID: user.ID, ExpiresAt: time.Now().UTC().Add(time.Hour)})
+
+	if rec := doRequest(t, handler, "POST", "/admin/retention/prune_everything/run", cfg.adminToken, ""); rec.Code != 404 {
+		t.Fatalf("Expected 404 for an unknown rule, got %d", rec.Code)
+	}
+	rec := doRequest(t, handler, "POST", "/admin/retention/prune_sessions/run?dry_run=true", cfg.adminToken, "")
+	var report retentionReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || rec.Code != 200 || !report.DryRun || report.Rows != 1 {
+		t.Fatalf("Expected a dry run to count the revoked token, got %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := cfg.store.GetRefreshToken(ctx, "live"); err != nil {
+		t.Fatalf("Expected the live token to survive, got %v", err)
+	}
+	count, _ := cfg.store.CountStaleRefreshTokens(ctx, time.Now().UTC())
+	if count != 1 {
+		t.Fatalf("Expected a dry run to delete nothing, %d stale tokens left", count)
+	}
+	rec = doRequest(t, handler, "POST", "/admin/retention/prune_sessions/run", cfg.adminToken, "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil || rec.Code != 200 || report.DryRun || report.Rows != 1 {
+		t.Fatalf("Expected the run to delete the revoked token, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "GET", "/admin/retention", cfg.adminToken, "")
+	var resp struct {
+		Rules []struct {
+			Name       string           `json:"name"`
+			Schedule   string           `json:"schedule"`
+			LastRun    *retentionReport `json:"last_run"`
+			LastDryRun *retentionReport `json:"last_dry_run"`
+		} `json:"rules"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.Rules) != 10 || resp.Rules[0].Name != "prune_sessions" || resp.Rules[0].Schedule != "@hourly" {
+		t.Fatalf("Expected the 10 rules with their schedules, got %s", rec.Body.String())
+	}
+	sessions := resp.Rules[0]
+	if sessions.LastRun == nil || sessions.LastRun.Rows != 1 || sessions.LastDryRun == nil || !sessions.LastDryRun.DryRun {
+		t.Fatalf("Expected the last run and dry run of prune_sessions, got %s", rec.Body.String())
+	}
+	if resp.Rules[1].LastRun != nil {
+		t.Fatalf("Expected no report for a rule that never ran, got %s", rec.Body.String())
+	}
+}
+
+func TestWebhookDeliveredOnChirpCreated(t *testing.T) {
+	received := make(chan string, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Chirpy-Event")
+	}))
+	defer receiver.Close()
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "hooks@example.com")
+	other := registerAndLogin(t, handler, "nosy@example.com")
+
+	rec := doRequest(t, handler, "POST", "/api/webhooks", user.Token, `{"url":"`+receiver.URL+`","events":["user.followed"]}`)
+	if rec.Code != 400 {
+		t.Fatalf("Expected 400 for an unknown event, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "POST", "/api/webhooks", user.Token, `{"url":"`+receiver.URL+`","events":["chirp.created"]}`)
+	if rec.Code != 201 {
+		t.Fatalf("Expected 201 creating webhook, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created struct {
+		ID     uuid.UUID `json:"id"`
+		Secret string    `json:"secret"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if created.Secret == "" {
+		t.Fatalf("Expected the secret in the create response, got %s", rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/api/webhooks", user.Token, "")
+	if strings.Contains(rec.Body.String(), created.Secret) {
+		t.Fatalf("Expected the secret not to be listed again, got %s", rec.Body.String())
+	}
+
+	doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"hello hooks"}`)
+	if published, err := cfg.relayOutbox(context.Background()); published != 1 || err != nil {
+		t.Fatalf("Expected the chirp.created event to be relayed, got %d, %v", published, err)
+	}
+	ran, err := cfg.jobs.RunOnce(context.Background())
+	if !ran || err != nil {
+		t.Fatalf("Expected the delivery job to run, got ran=%v err=%v", ran, err)
+	}
+	if event := <-received; event != webhooks.ChirpCreated {
+		t.Fatalf("Expected a chirp.created delivery, got %q", event)
+	}
+	rec = doRequest(t, handler, "GET", "/api/webhooks/"+created.ID.String()+"/deliveries", user.Token, "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"status_code":200`) {
+		t.Fatalf("Expected the successful delivery in the log, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/api/webhooks/"+created.ID.String()+"/deliveries", other.Token, "")
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404 reading another user's deliveries, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "DELETE", "/api/webhooks/"+created.ID.String(), other.Token, "")
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404 deleting another user's webhook, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "DELETE", "/api/webhooks/"+created.ID.String(), user.Token, "")
+	if rec.Code != 204 {
+		t.Fatalf("Expected 204 deleting webhook, got %d", rec.Code)
+	}
+}
+
+func TestWebhookSubscriptionManagement(t *testing.T) {
+	var signatures []string
+	var body []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signatures = append(signatures, r.Header.Get(webhooks.SignatureHeader))
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(500)
+		w.Write([]byte("internal secret page"))
+	}))
+	defer receiver.Close()
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "manage@example.com")
+	other := registerAndLogin(t, handler, "snoop@example.com")
+
+	rec := doRequest(t, handler, "GET", "/api/webhooks/events", "", "")
+	var catalog []webhookEventTypeResponse
+	json.Unmarshal(rec.Body.Bytes(), &catalog)
+	if rec.Code != 200 || len(catalog) != len(webhooks.Events) || catalog[0].Name != webhooks.ChirpCreated {
+		t.Fatalf("Expected the event catalog, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"body":{"type":"string"}`) {
+		t.Fatalf("Expected the chirp.created schema to describe its data, got %s", rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "POST", "/api/webhooks", user.Token, `{"url":"`+receiver.URL+`","events":["chirp.created"],"secret":"short"}`)
+	if rec.Code != 400 {
+		t.Fatalf("Expected 400 for a short secret, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "POST", "/api/webhooks", user.Token, `{"url":"`+receiver.URL+`","events":["chirp.created"],"secret":"my-own-secret-value","active":false}`)
+	var created webhookResponse
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if rec.Code != 201 || created.Active || created.Secret != "my-own-secret-value" {
+		t.Fatalf("Expected a paused webhook with the given secret, got %d: %s", rec.Code, rec.Body.String())
+	}
+	path := "/api/webhooks/" + created.ID.String()
+	rec = doRequest(t, handler, "GET", path, other.Token, "")
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404 reading another user's webhook, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "PUT", path, other.Token, `{"url":"`+receiver.URL+`","events":["chirp.created"]}`)
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404 updating another user's webhook, got %d", rec.Code)
+	}
+
+	// paused webhooks get no events
+	doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"nobody hears this"}`)
+	cfg.relayOutbox(context.Background())
+	if ran, _ := cfg.jobs.RunOnce(context.Background()); ran {
+		t.Fatalf("Expected no delivery to a paused webhook")
+	}
+
+	// but can still be tested
+	rec = doRequest(t, handler, "POST", path+"/test", user.Token, "")
+	var tested webhookTestResponse
+	json.Unmarshal(rec.Body.Bytes(), &tested)
+	if rec.Code != 200 || tested.Delivered || tested.StatusCode != 500 || tested.Error == "" {
+		t.Fatalf("Expected the failed test delivery to be reported, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "response_body") {
+		t.Fatalf("Expected the receiver's response body to be left out, got %s", rec.Body.String())
+	}
+	if len(signatures) != 1 || webhooks.Verify("my-own-secret-value", signatures[0], body, time.Now(), time.Minute) != nil {
+		t.Fatalf("Expected one signed test request, got %v", signatures)
+	}
+	rec = doRequest(t, handler, "GET", path+"/deliveries", user.Token, "")
+	if !strings.Contains(rec.Body.String(), `"event":"webhook.test"`) {
+		t.Fatalf("Expected the test delivery in the log, got %s", rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "internal secret page") || strings.Contains(rec.Body.String(), "response_body") {
+		t.Fatalf("Expected the delivery log to leave out the receiver's response body, got %s", rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "PUT", path, user.Token, `{"url":"`+receiver.URL+`","events":["user.upgraded","chirp.deleted"]}`)
+	var updated webhookResponse
+	json.Unmarshal(rec.Body.Bytes(), &updated)
+	if rec.Code != 200 || !updated.Active || updated.Secret != "" || strings.Join(updated.Events, ",") != "chirp.deleted,user.upgraded" {
+		t.Fatalf("Expected the webhook resumed with new events and its secret kept, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "PUT", path, user.Token, `{"url":"`+receiver.URL+`","events":["user.upgraded"],"rotate_secret":true}`)
+	json.Unmarshal(rec.Body.Bytes(), &updated)
+	if rec.Code != 200 || !strings.HasPrefix(updated.Secret, "whsec_") {
+		t.Fatalf("Expected a rotated secret, got %d: %s", rec.Code, rec.Body.String())
+	}
+	webhook, _ := cfg.store.GetWebhook(context.Background(), created.ID)
+	if webhook.Secret != updated.Secret {
+		t.Fatalf("Expected the rotated secret to be stored")
+	}
+	rec = doRequest(t, handler, "GET", path, user.Token, "")
+	if rec.Code != 200 || strings.Contains(rec.Body.String(), updated.Secret) {
+		t.Fatalf("Expected the webhook without its secret, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestOutboxRecordsCommittedChangesOnly(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	ctx := context.Background()
+	user := registerAndLogin(t, handler, "outbox@example.com")
+	doRequest(t, handler, "POST", "/api/webhooks", user.Token, `{"url":"https://hooks.example.com","events":["chirp.created","user.upgraded"]}`)
+
+	rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"recorded with its event"}`)
+	if rec.Code != 201 {
+		t.Fatalf("Expected 201 creating chirp, got %d", rec.Code)
+	}
+	// upgrading a user that does not exist changes nothing, so there is no event either
+	req := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(`{"event":"user.upgraded","data":{"user_id":"`+uuid.NewString()+`"}}`))
+	req.Header.Set("Authorization", "ApiKey "+cfg.polkaKey)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404 upgrading an unknown user, got %d", rec.Code)
+	}
+	events, _ := cfg.store.ListUnpublishedOutboxEvents(ctx, 10)
+	if len(events) != 1 || events[0].Event != webhooks.ChirpCreated {
+		t.Fatalf("Expected only the chirp.created event in the outbox, got %+v", events)
+	}
+
+	published, err := cfg.relayOutbox(ctx)
+	if published != 1 || err != nil {
+		t.Fatalf("Expected one event relayed, got %d, %v", published, err)
+	}
+	published, err = cfg.relayOutbox(ctx)
+	if published != 0 || err != nil {
+		t.Fatalf("Expected a second relay to publish nothing, got %d, %v", published, err)
+	}
+	pending, _ := cfg.store.ListJobsByStatus(ctx, database.ListJobsByStatusParams{Status: "pending", Limit: 10})
+	if len(pending) != 1 {
+		t.Fatalf("Expected one delivery job, got %d", len(pending))
+	}
+	// deliveries carry the outbox event's id, so a receiver can tell a repeat from a new event
+	if !strings.Contains(pending[0].Payload, events[0].ID.String()) {
+		t.Fatalf("Expected the delivery to carry event id %s, got %s", events[0].ID, pending[0].Payload)
+	}
+	if count, _ := cfg.store.CountUnpublishedOutboxEvents(ctx); count != 0 {
+		t.Fatalf("Expected no unpublished events, got %d", count)
+	}
+}
+
+func TestQuotasLimitChirpsAndRequests(t *testing.T) {
+	t.Setenv("QUOTA_CHIRPS_PER_DAY", "2")
+	t.Setenv("QUOTA_API_REQUESTS_PER_HOUR", "20")
+	t.Setenv("QUOTA_RED_MULTIPLIER", "2")
+	cfg := newTestConfig()
+	handler := cfg.middlewareQuota(cfg.routes())
+	ctx := context.Background()
+	user := registerAndLogin(t, handler, "quota@example.com")
+
+	for i := range 2 {
+		if rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"within quota"}`); rec.Code != 201 {
+			t.Fatalf("Expected 201 for chirp %d, got %d: %s", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"over quota"}`)
+	if rec.Code != 403 || !strings.Contains(rec.Body.String(), `"quota_exceeded"`) {
+		t.Fatalf("Expected 403 quota_exceeded for the third chirp, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// the refused chirp is not counted, nor stored
+	chirps, _ := cfg.store.GetChirps(ctx, database.GetChirpsParams{TenantID: tenantID(ctx)})
+	if len(chirps) != 2 {
+		t.Fatalf("Expected 2 stored chirps, got %d", len(chirps))
+	}
+
+	cfg.store.UpgradeUserById(ctx, database.UpgradeUserByIdParams{ID: user.ID, TenantID: tenantID(ctx)})
+	if rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"red quota"}`); rec.Code != 201 {
+		t.Fatalf("Expected 201 after upgrading to Chirpy Red, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "GET", "/api/users/me/quota", user.Token, "")
+	var resp quotaResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 200 || !resp.IsChirpyRed || len(resp.Quotas) != 2 {
+		t.Fatalf("Expected 200 with both quotas, got %d: %s", rec.Code, rec.Body.String())
+	}
+	chirpQuota := resp.Quotas[0]
+	if chirpQuota.Name != quotaChirps || chirpQuota.Limit != 4 || chirpQuota.Used != 3 || *chirpQuota.Remaining != 1 {
+		t.Fatalf("Expected 3 of 4 chirps used, got %+v", chirpQuota)
+	}
+
+	// Chirpy Red raised the request quota to 40 too
+	var remaining string
+	for range 50 {
+		rec = doRequest(t, handler, "GET", "/api/users/me/quota", user.Token, "")
+		if rec.Code != 200 {
+			break
+		}
+		remaining = rec.Header().Get("X-Quota-Remaining")
+	}
+	if rec.Code != 429 || rec.Header().Get("Retry-After") == "" || remaining != "0" {
+		t.Fatalf("Expected 429 with Retry-After once the request quota is used up, got %d: %s", rec.Code, rec.Body.String())
+	}
+	// requests without a token are left to the per-IP rate limit
+	if rec := doRequest(t, handler, "GET", "/api/chirps", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected 200 for an anonymous request, got %d", rec.Code)
+	}
+}
+
+func TestAdminTestEmailQueuesMessage(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	rec := doRequest(t, handler, "POST", "/admin/email/test", cfg.adminToken, `{"to":"nope"}`)
+	if rec.Code != 400 {
+		t.Fatalf("Expected 400 for an invalid address, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "POST", "/admin/email/test", cfg.adminToken, `{"to":"ops@example.com"}`)
+	if rec.Code != 202 {
+		t.Fatalf("Expected 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+	ran, err := cfg.jobs.RunOnce(context.Background())
+	if !ran || err != nil {
+		t.Fatalf("Expected the email job to run, got ran=%v err=%v", ran, err)
+	}
+}
+
+func TestIdempotencyKeyReplaysChirpCreate(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "retry@example.com")
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+user.Token)
+		req.Header.Set("Idempotency-Key", key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	first := post("abc", `{"body":"only once"}`)
+	if first.Code != 201 {
+		t.Fatalf("Expected 201 creating chirp, got %d: %s", first.Code, first.Body.String())
+	}
+	second := post("abc", `{"body":"only once"}`)
+	if second.Code != 201 || second.Body.String() != first.Body.String() || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("Expected the first response replayed, got %d: %s", second.Code, second.Body.String())
+	}
+	rec := post("abc", `{"body":"something else"}`)
+	if rec.Code != 422 {
+		t.Fatalf("Expected 422 reusing a key for a different body, got %d", rec.Code)
+	}
+	chirps, _ := cfg.store.GetChirpsById(context.Background(), database.GetChirpsByIdParams{UserID: user.ID})
+	if len(chirps) != 1 {
+		t.Fatalf("Expected one chirp after retrying, got %d", len(chirps))
+	}
+
+	// another user's key is their own, even when it is the same string
+	other := registerAndLogin(t, handler, "other-retry@example.com")
+	req := httptest.NewRequest("POST", "/api/chirps", strings.NewReader(`{"body":"something else"}`))
+	req.Header.Set("Authorization", "Bearer "+other.Token)
+	req.Header.Set("Idempotency-Key", "abc")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 201 || rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("Expected another user's key to be separate, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestIdempotencyKeyReplaysHeaders(t *testing.T) {
+	cfg := newTestConfig()
+	runs := 0
+	handler := cfg.middlewareIdempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs++
+		w.Header().Set("Location", "/api/jobs/1")
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(10-runs))
+		w.WriteHeader(202)
+	}))
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/import", strings.NewReader(`{}`))
+		req.Header.Set("Idempotency-Key", "job")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	post()
+	rec := post()
+	if runs != 1 || rec.Code != 202 || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("Expected the first response replayed, got %d after %d runs", rec.Code, runs)
+	}
+	if rec.Header().Get("Location") != "/api/jobs/1" {
+		t.Fatalf("Expected the Location header replayed, got %q", rec.Header().Get("Location"))
+	}
+	if rec.Header().Get("X-RateLimit-Remaining") != "" {
+		t.Fatalf("Expected per-request headers not to be replayed, got %q", rec.Header().Get("X-RateLimit-Remaining"))
+	}
+}
+
+func TestVersionedAPIRoutes(t *testing.T) {
+	handler := newTestConfig().routes()
+	user := registerAndLogin(t, handler, "versions@example.com")
+
+	rec := doRequest(t, handler, "POST", "/api/v1/chirps", user.Token, `{"body":"versioned"}`)
+	if rec.Code != 201 || rec.Header().Get("API-Version") != "1" {
+		t.Fatalf("Expected 201 with API-Version 1, got %d %q: %s", rec.Code, rec.Header().Get("API-Version"), rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/api/chirps", "", "")
+	if rec.Code != 200 || rec.Header().Get("API-Version") != "1" {
+		t.Fatalf("Expected the unversioned alias to default to version 1, got %d %q", rec.Code, rec.Header().Get("API-Version"))
+	}
+	req := httptest.NewRequest("GET", "/api/chirps", nil)
+	req.Header.Set("API-Version", "9")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 400 {
+		t.Fatalf("Expected 400 for an unsupported API-Version, got %d", rec.Code)
+	}
+}
+
+func TestByAPIVersionPicksNewestNotAfterRequested(t *testing.T) {
+	handlers := byAPIVersion{
+		1: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("v1")) }),
+		3: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("v3")) }),
+	}
+	for version, want := range map[int]string{1: "v1", 2: "v1", 3: "v3", 4: "v3"} {
+		rec := httptest.NewRecorder()
+		withAPIVersion(version, handlers).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Body.String() != want {
+			t.Fatalf("Expected %s for version %d, got %s", want, version, rec.Body.String())
+		}
+	}
+}
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	for _, pattern := range cfg.apiRoutes {
+		if _, ok := apiDocs[pattern]; !ok {
+			t.Errorf("Route %s has no entry in apiDocs", pattern)
+		}
+	}
+	rec := doRequest(t, handler, "GET", "/api/openapi.json", "", "")
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var spec struct {
+		Paths      map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &spec)
+	if _, ok := spec.Paths["/chirps/{chirpID}"]["delete"]; !ok {
+		t.Fatalf("Expected DELETE /chirps/{chirpID} in the document, got %v", spec.Paths)
+	}
+	if _, ok := spec.Components.Schemas["Chirp"].Properties["user_id"]; !ok {
+		t.Fatalf("Expected the Chirp schema to have user_id, got %v", spec.Components.Schemas)
+	}
+	rec = doRequest(t, handler, "GET", "/api/docs", "", "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "/api/openapi.json") {
+		t.Fatalf("Expected the Swagger UI page, got %d", rec.Code)
+	}
+}
+
+func TestContentNegotiation(t *testing.T) {
+	handler := newTestConfig().routes()
+	user := registerAndLogin(t, handler, "formats@example.com")
+	rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"many formats"}`)
+	var created Chirp
+	json.Unmarshal(rec.Body.Bytes(), &created)
+
+	get := func(accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/chirps/"+created.ID.String(), nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	rec = get("application/xml")
+	if rec.Header().Get("Content-Type") != "application/xml; charset=utf-8" || !strings.Contains(rec.Body.String(), "<body>many formats</body>") {
+		t.Fatalf("Expected an XML chirp, got %q: %s", rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+	rec = get("application/msgpack;q=0.9, application/json;q=0.5")
+	var decoded map[string]any
+	if err := msgpack.Unmarshal(rec.Body.Bytes(), &decoded); err != nil || decoded["body"] != "many formats" {
+		t.Fatalf("Expected a MessagePack chirp, got %q err=%v", rec.Header().Get("Content-Type"), err)
+	}
+	rec = get("text/html, */*;q=0.1")
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected JSON for a wildcard, got %q", rec.Header().Get("Content-Type"))
+	}
+	rec = get("text/csv")
+	if rec.Code != 406 {
+		t.Fatalf("Expected 406 for an unsupported type, got %d", rec.Code)
+	}
+}
+
+func TestSparseFieldsets(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "sparse@example.com")
+	rec := doRequest(t, handler, "POST", "/api/chirps?fields=id", user.Token, `{"body":"lean"}`)
+	if rec.Code != 201 || strings.Contains(rec.Body.String(), "lean") || !strings.Contains(rec.Body.String(), `"id"`) {
+		t.Fatalf("Expected only the id of the created chirp, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "GET", "/api/chirps?fields=body,%20missing", "", "")
+	if rec.Body.String() != `[{"body":"lean"}]` {
+		t.Fatalf("Expected only chirp bodies, got %s", rec.Body.String())
+	}
+	rec = doRequest(t, handler, "POST", "/api/login?fields=email,is_chirpy_red", "", `{"email":"sparse@example.com","password":"hunter2"}`)
+	if rec.Body.String() != `{"email":"sparse@example.com","is_chirpy_red":false}` {
+		t.Fatalf("Expected only the selected user fields, got %s", rec.Body.String())
+	}
+
+	dat, err := selectFields([]byte(`{"id":1,"author":{"handle":"walt","email":"w@example.com"},"tags":[{"name":"a","n":2}]}`), "id,author.handle,tags.name")
+	if err != nil || string(dat) != `{"author":{"handle":"walt"},"id":1,"tags":[{"name":"a"}]}` {
+		t.Fatalf("Expected nested fields to be selected, got %s (%v)", dat, err)
+	}
+}
+
+func TestRenderWritesPooledBodyWithContentLength(t *testing.T) {
+	long := strings.Repeat("x", maxPooledBuffer)
+	for _, v := range []any{map[string]string{"body": long}, map[string]string{"body": "<short> & sweet"}} {
+		rec := httptest.NewRecorder()
+		render(rec, httptest.NewRequest("GET", "/", nil), 200, v)
+		want, _ := json.Marshal(v)
+		if rec.Body.String() != string(want) {
+			t.Fatalf("Expected the json.Marshal encoding, got %.80s", rec.Body.String())
+		}
+		if rec.Header().Get("Content-Length") != strconv.Itoa(len(want)) {
+			t.Fatalf("Expected Content-Length %d, got %q", len(want), rec.Header().Get("Content-Length"))
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	marshallError(rec, errors.New("nope"), 400)
+	if rec.Header().Get("Content-Length") != strconv.Itoa(rec.Body.Len()) {
+		t.Fatalf("Expected error bodies to carry their length, got %q for %d bytes", rec.Header().Get("Content-Length"), rec.Body.Len())
+	}
+
+	buf := getBuffer()
+	buf.Grow(2 * maxPooledBuffer)
+	putBuffer(buf)
+	if got := getBuffer(); got == buf {
+		t.Fatal("Expected an oversized buffer to be dropped instead of pooled")
+	}
+}
+
+func BenchmarkRender(b *testing.B) {
+	chirps := make([]Chirp, 50)
+	for i := range chirps {
+		chirps[i] = Chirp{ID: uuid.New(), Body: "Well, that was a chirp worth reading twice.", UserID: uuid.New()}
+	}
+	req := httptest.NewRequest("GET", "/api/chirps", nil)
+	b.ReportAllocs()
+	for b.Loop() {
+		render(httptest.NewRecorder(), req, 200, chirps)
+	}
+}
+
+func TestLoadTestMeasuresEveryRequest(t *testing.T) {
+	server := httptest.NewServer(newTestConfig().routes())
+	defer server.Close()
+	report, err := runLoadTest(context.Background(), loadTestOptions{
+		target:      server.URL,
+		concurrency: 2,
+		duration:    time.Second,
+		mix:         []int{1, 1, 1, 1},
+		timeout:     5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range loadTestOps {
+		result := report.results[op]
+		if len(result.latencies) == 0 || result.errors != 0 {
+			t.Fatalf("Expected successful %s requests, got %d with %d errors %v", op, len(result.latencies), result.errors, result.samples)
+		}
+	}
+	var out strings.Builder
+	report.write(&out)
+	if !strings.Contains(out.String(), "p99") || !strings.Contains(out.String(), "total") {
+		t.Fatalf("Expected a percentile table, got\n%s", out.String())
+	}
+
+	if _, err := runLoadTest(context.Background(), loadTestOptions{target: "http://127.0.0.1:1", concurrency: 1, duration: time.Second, mix: []int{0, 0, 0, 1}, timeout: time.Second}); err == nil {
+		t.Fatal("Expected an unreachable target to fail before the run")
+	}
+	if _, err := parseLoadTestMix("read=3,browse=1"); err == nil {
+		t.Fatal("Expected an unknown request in -mix to be rejected")
+	}
+	result := &loadTestResult{latencies: []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+	if result.percentile(50) != 5 || result.percentile(99) != 10 {
+		t.Fatalf("Expected nearest-rank percentiles, got p50=%d p99=%d", result.percentile(50), result.percentile(99))
+	}
+}
+
+func TestBulkDeleteChirps(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	spammer := registerAndLogin(t, handler, "spammer@example.com")
+	bystander := registerAndLogin(t, handler, "bystander@example.com")
+	for i := 0; i < 5; i++ {
+		doRequest(t, handler, "POST", "/api/chirps", spammer.Token, `{"body":"buy now"}`)
+	}
+	doRequest(t, handler, "POST", "/api/chirps", bystander.Token, `{"body":"hello"}`)
+
+	rec := doRequest(t, handler, "POST", "/admin/chirps/bulk-delete", "test-admin-token", `{}`)
+	if rec.Code != 400 {
+		t.Fatalf("Expected a bulk delete without filters to be refused, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "POST", "/admin/chirps/bulk-delete", "test-admin-token", `{"user_id":"`+spammer.ID.String()+`","dry_run":true}`)
+	if rec.Code != 200 || rec.Body.String() != `{"matched":5}` {
+		t.Fatalf("Expected a dry run to count 5 chirps, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "POST", "/admin/chirps/bulk-delete", "test-admin-token", `{"user_id":"`+spammer.ID.String()+`","batch_size":2}`)
+	if rec.Code != 202 || !strings.HasPrefix(rec.Header().Get("Location"), "/admin/jobs/") {
+		t.Fatalf("Expected 202 with the job location, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	location := rec.Header().Get("Location")
+	if ran, err := cfg.jobs.RunOnce(context.Background()); !ran || err != nil {
+		t.Fatalf("Expected the bulk delete job to run, got ran=%v err=%v", ran, err)
+	}
+
+	rec = doRequest(t, handler, "GET", location, "test-admin-token", "")
+	var job struct {
+		Status   string             `json:"status"`
+		Progress bulkDeleteProgress `json:"progress"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &job)
+	if job.Status != "done" || job.Progress != (bulkDeleteProgress{Matched: 5, Deleted: 5, Batches: 3}) {
+		t.Fatalf("Expected a finished job that deleted 5 chirps in 3 batches, got %s", rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/api/chirps", "", "")
+	if !strings.Contains(rec.Body.String(), "hello") || strings.Contains(rec.Body.String(), "buy now") {
+		t.Fatalf("Expected only the bystander's chirp to remain, got %s", rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "DELETE", "/admin/users/"+bystander.ID.String()+"/chirps", "test-admin-token", "")
+	if rec.Code != 202 {
+		t.Fatalf("Expected 202 deleting a user's chirps, got %d", rec.Code)
+	}
+	cfg.jobs.RunOnce(context.Background())
+	rec = doRequest(t, handler, "GET", "/api/chirps", "", "")
+	if strings.Contains(rec.Body.String(), "hello") {
+		t.Fatalf("Expected no chirps left, got %s", rec.Body.String())
+	}
+}
+
+func TestBackupRestore(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.middlewareTenant(cfg.routes())
+	doRequest(t, handler, "POST", "/admin/tenants", cfg.adminToken, `{"slug":"acme","name":"Acme"}`)
+	walt := registerAndLogin(t, handler, "walt@example.com")
+	acmeJesse := registerAndLogin(t, inTenant(handler, "acme"), "jesse@example.com")
+	rec := doRequest(t, handler, "POST", "/api/chirps", walt.Token, `{"body":"say my name"}`)
+	var named Chirp
+	json.Unmarshal(rec.Body.Bytes(), &named)
+	doRequest(t, inTenant(handler, "acme"), "POST", "/api/chirps", acmeJesse.Token, `{"body":"yeah science"}`)
+	cfg.store.CreateWebhook(context.Background(), database.CreateWebhookParams{UserID: walt.ID, Url: "https://example.com/hook", Secret: "s", Events: "chirp.created", Active: true})
+	rec = doRequest(t, handler, "POST", "/api/chirps", walt.Token, `{"body":"held for review"}`)
+	var held Chirp
+	json.Unmarshal(rec.Body.Bytes(), &held)
+	cfg.store.CreateChirpReport(context.Background(), database.CreateChirpReportParams{ChirpID: held.ID, AuthorID: walt.ID, TenantID: uuid.Nil, Reason: automodHoldReason})
+	skyler := registerAndLogin(t, handler, "skyler@example.com")
+	doRequest(t, handler, "POST", "/api/chirps", skyler.Token, `{"body":"shadowed"}`)
+	cfg.store.SetShadowban(context.Background(), database.SetShadowbanParams{UserID: skyler.ID, Reason: "spam", BannedBy: "admin"})
+	rule, _ := cfg.store.CreateAutomodRule(context.Background(), database.CreateAutomodRuleParams{Name: "slow down", Enabled: true, Definition: "{}"})
+	cfg.store.CreateAutomodRuleVersion(context.Background(), database.CreateAutomodRuleVersionParams{RuleID: rule.ID, Version: 1, Name: rule.Name, Enabled: true, Definition: "{}", CreatedBy: "admin"})
+	cfg.store.SetAutomodRestriction(context.Background(), database.SetAutomodRestrictionParams{UserID: walt.ID, ExpiresAt: time.Now().Add(time.Hour), MaxChirps: 1, WindowSeconds: 60, RuleID: rule.ID})
+	cfg.store.FavouriteChirp(context.Background(), database.FavouriteChirpParams{UserID: skyler.ID, ChirpID: named.ID})
+	cfg.store.CreateActivityPubKey(context.Background(), database.CreateActivityPubKeyParams{UserID: walt.ID, PublicKeyPem: "public", PrivateKeyPem: "private"})
+	cfg.store.AddActivityPubFollower(context.Background(), database.AddActivityPubFollowerParams{UserID: walt.ID, ActorID: "https://remote.example/users/gus", Inbox: "https://remote.example/users/gus/inbox", FollowID: "https://remote.example/follows/1"})
+	cfg.store.CreateWordFilter(context.Background(), database.CreateWordFilterParams{Pattern: "heisenberg", Kind: "word", Action: "reject"})
+	cfg.store.CreateIPBan(context.Background(), database.CreateIPBanParams{Cidr: "203.0.113.0/24", Reason: "abuse", ExpiresAt: sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}, CreatedBy: "admin"})
+	cfg.store.CreateAPIKey(context.Background(), database.CreateAPIKeyParams{UserID: walt.ID, TenantID: uuid.Nil, Name: "ci", KeyHash: "api-key-hash", Prefix: "chirpy_ab"})
+	cfg.store.CreateOAuthApp(context.Background(), database.CreateOAuthAppParams{ClientID: "client", ClientSecret: "secret", Name: "Tusky", RedirectUris: "urn:ietf:wg:oauth:2.0:oob", Scopes: "read"})
+	cfg.store.CreateOAuthToken(context.Background(), database.CreateOAuthTokenParams{TokenHash: "oauth-token-hash", UserID: walt.ID, ClientID: "client", Scopes: "read"})
+	cfg.store.CreateDigestSubscription(context.Background(), walt.ID)
+
+	rec = doRequest(t, handler, "POST", "/admin/backup", cfg.adminToken, "")
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Expected 200 with an NDJSON backup, got %d %s", rec.Code, rec.Body.String())
+	}
+	backup := rec.Body.String()
+	lines := strings.Split(strings.TrimSpace(backup), "\n")
+	if want := `{"table":"end","counts":{"activitypub_followers":1,"activitypub_keys":1,"api_keys":1,"automod_holds":1,"automod_restrictions":1,"automod_rule_versions":1,"automod_rules":1,"chirp_favourites":1,"chirps":4,"digest_subscriptions":1,"ip_bans":1,"oauth_apps":1,"oauth_tokens":1,"shadowbans":1,"tenants":2,"users":3,"webhooks":1,"word_filters":1}}`; lines[len(lines)-1] != want {
+		t.Fatalf("Expected the backup to end with %s, got %s", want, lines[len(lines)-1])
+	}
+	rec = doRequest(t, handler, "POST", "/admin/restore", cfg.adminToken, backup)
+	if rec.Code != 409 || !strings.Contains(rec.Body.String(), "database_not_empty") {
+		t.Fatalf("Expected restoring into a database with users to be refused, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	restored := newTestConfig()
+	restoredHandler := restored.middlewareTenant(restored.routes())
+	truncated := strings.Join(lines[:len(lines)-2], "\n")
+	rec = doRequest(t, restoredHandler, "POST", "/admin/restore", restored.adminToken, truncated)
+	if rec.Code != 400 || !strings.Contains(rec.Body.String(), "invalid_backup") {
+		t.Fatalf("Expected a truncated backup to be refused, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, restoredHandler, "GET", "/api/chirps", "", ""); strings.Contains(rec.Body.String(), "say my name") {
+		t.Fatalf("Expected a refused restore to leave nothing behind, got %s", rec.Body.String())
+	}
+	rec = doRequest(t, restoredHandler, "POST", "/admin/restore", restored.adminToken, backup)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 restoring into an empty database, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, restoredHandler, "POST", "/api/login", "", `{"email":"walt@example.com","password":"hunter2"}`)
+	if rec.Code != 200 {
+		t.Fatalf("Expected a restored user to log in with their password, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, inTenant(restoredHandler, "acme"), "GET", "/api/chirps", "", "")
+	if !strings.Contains(rec.Body.String(), "yeah science") || strings.Contains(rec.Body.String(), "say my name") {
+		t.Fatalf("Expected chirps restored into their tenants, got %s", rec.Body.String())
+	}
+	webhooks, _ := restored.store.ListWebhooksByUser(context.Background(), walt.ID)
+	if len(webhooks) != 1 {
+		t.Fatalf("Expected the webhook to be restored, got %d", len(webhooks))
+	}
+	rec = doRequest(t, restoredHandler, "GET", "/api/chirps", "", "")
+	if !strings.Contains(rec.Body.String(), "say my name") || strings.Contains(rec.Body.String(), "held for review") || strings.Contains(rec.Body.String(), "shadowed") {
+		t.Fatalf("Expected held and shadowbanned chirps to stay hidden after the restore, got %s", rec.Body.String())
+	}
+	versions, _ := restored.store.ListAutomodRuleVersions(context.Background(), rule.ID)
+	restriction, err := restored.store.GetAutomodRestriction(context.Background(), database.GetAutomodRestrictionParams{UserID: walt.ID, ExpiresAt: time.Now()})
+	if len(versions) != 1 || err != nil || restriction.RuleID != rule.ID {
+		t.Fatalf("Expected the automod rule and restriction to be restored, got %v %+v %v", versions, restriction, err)
+	}
+	favourites, _ := restored.store.CountChirpFavourites(context.Background(), named.ID.String())
+	followers, _ := restored.store.CountActivityPubFollowers(context.Background(), walt.ID)
+	if _, err := restored.store.GetActivityPubKey(context.Background(), walt.ID); err != nil || len(favourites) != 1 || followers != 1 {
+		t.Fatalf("Expected the favourite and ActivityPub key and follower to be restored, got %v %d %v", favourites, followers, err)
+	}
+	hank := registerAndLogin(t, restoredHandler, "hank@example.com")
+	if rec = doRequest(t, restoredHandler, "POST", "/api/chirps", hank.Token, `{"body":"i am heisenberg"}`); rec.Code != 400 {
+		t.Fatalf("Expected the restored word filter to apply right away, got %d %s", rec.Code, rec.Body.String())
+	}
+	bans, _ := restored.store.ListActiveIPBans(context.Background())
+	if len(bans) != 1 || bans[0].Cidr != "203.0.113.0/24" || !bans[0].ExpiresAt.Valid {
+		t.Fatalf("Expected the IP ban to be restored with its expiry, got %+v", bans)
+	}
+	apiKey, err := restored.store.GetAPIKeyByHash(context.Background(), "api-key-hash")
+	if err != nil || apiKey.UserID != walt.ID || apiKey.LastUsedAt.Valid {
+		t.Fatalf("Expected the API key to be restored, got %+v %v", apiKey, err)
+	}
+	token, err := restored.store.GetOAuthToken(context.Background(), "oauth-token-hash")
+	if err != nil || token.ClientID != "client" {
+		t.Fatalf("Expected the OAuth app and token to be restored, got %+v %v", token, err)
+	}
+	if _, err := restored.store.GetDigestSubscription(context.Background(), walt.ID); err != nil {
+		t.Fatalf("Expected the digest subscription to be restored, got %v", err)
+	}
+
+	// backups taken before shadowbans, automod and the rest were backed up do not count
+	// their tables
+	var old []string
+	for _, line := range lines[:len(lines)-1] {
+		var parsed backupLine
+		json.Unmarshal([]byte(line), &parsed)
+		if parsed.Table == "" || slices.Contains([]string{"tenants", "users", "chirps", "webhooks"}, parsed.Table) {
+			old = append(old, line)
+		}
+	}
+	old = append(old, `{"table":"end","counts":{"chirps":4,"tenants":2,"users":3,"webhooks":1}}`)
+	fresh := newTestConfig()
+	rec = doRequest(t, fresh.middlewareTenant(fresh.routes()), "POST", "/admin/restore", fresh.adminToken, strings.Join(old, "\n"))
+	if rec.Code != 200 {
+		t.Fatalf("Expected an older backup to restore, got %d %s", rec.Code, rec.Body.String())
+	}
+	entries, _ := restored.store.ListAuditEntries(context.Background(), database.ListAuditEntriesParams{Action: "backup.restore", Until: time.Now().Add(time.Minute), MaxEntries: 10})
+	if len(entries) != 1 {
+		t.Fatalf("Expected the restore to be audited, got %d entries", len(entries))
+	}
+}
+
+func TestGRPCChirpLifecycle(t *testing.T) {
+	cfg := newTestConfig()
+	listener := bufconn.Listen(1 << 20)
+	srv := cfg.newGRPCServer()
+	go srv.Serve(listener)
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	client := chirpyv1.NewChirpyServiceClient(conn)
+	ctx := context.Background()
+
+	_, err = client.Register(ctx, &chirpyv1.RegisterRequest{Email: "grpc@example.com", Password: "hunter2"})
+	if err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	_, err = client.Login(ctx, &chirpyv1.LoginRequest{Email: "grpc@example.com", Password: "wrong"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected Unauthenticated for a wrong password, got %v", err)
+	}
+	login, err := client.Login(ctx, &chirpyv1.LoginRequest{Email: "grpc@example.com", Password: "hunter2"})
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	_, err = client.CreateChirp(ctx, &chirpyv1.CreateChirpRequest{Body: "no token"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("Expected Unauthenticated without a token, got %v", err)
+	}
+	authed := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+login.Token)
+	_, err = client.CreateChirp(authed, &chirpyv1.CreateChirpRequest{Body: strings.Repeat("a", 141)})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument for a long chirp, got %v", err)
+	}
+	chirp, err := client.CreateChirp(authed, &chirpyv1.CreateChirpRequest{Body: "hello kerfuffle"})
+	if err != nil || chirp.Body != "hello ****" {
+		t.Fatalf("Expected a cleaned chirp, got %v err=%v", chirp, err)
+	}
+	got, err := client.GetChirp(ctx, &chirpyv1.GetChirpRequest{Id: chirp.Id})
+	if err != nil || got.UserId != login.User.Id {
+		t.Fatalf("Expected to read the chirp back, got %v err=%v", got, err)
+	}
+	list, err := client.ListChirps(ctx, &chirpyv1.ListChirpsRequest{AuthorId: login.User.Id})
+	if err != nil || len(list.Chirps) != 1 {
+		t.Fatalf("Expected one chirp by the author, got %v err=%v", list, err)
+	}
+	// the HTTP API sees the same data
+	rec := doRequest(t, cfg.routes(), "GET", "/api/chirps/"+chirp.Id, "", "")
+	if rec.Code != 200 {
+		t.Fatalf("Expected the chirp over HTTP, got %d", rec.Code)
+	}
+}
+
+// Counts the batch lookups made by the GraphQL loaders
+type batchCountingStore struct {
+	store.Store
+	userBatches           atomic.Int32
+	chirpBatches          atomic.Int32
+	favouriteCountBatches atomic.Int32
+	favouritedBatches     atomic.Int32
+}
+
+func (s *batchCountingStore) GetUsersByIds(ctx context.Context, arg database.GetUsersByIdsParams) ([]database.User, error) {
+	s.userBatches.Add(1)
+	return s.Store.GetUsersByIds(ctx, arg)
+}
+
+func (s *batchCountingStore) GetChirpsByUserIds(ctx context.Context, arg database.GetChirpsByUserIdsParams) ([]database.Chirp, error) {
+	s.chirpBatches.Add(1)
+	return s.Store.GetChirpsByUserIds(ctx, arg)
+}
+
+func (s *batchCountingStore) CountChirpFavourites(ctx context.Context, ids string) ([]database.CountChirpFavouritesRow, error) {
+	s.favouriteCountBatches.Add(1)
+	return s.Store.CountChirpFavourites(ctx, ids)
+}
+
+func (s *batchCountingStore) ListFavouritedChirpIds(ctx context.Context, arg database.ListFavouritedChirpIdsParams) ([]uuid.UUID, error) {
+	s.favouritedBatches.Add(1)
+	return s.Store.ListFavouritedChirpIds(ctx, arg)
+}
+
+// Counts reads of whole chirp lists and of single pages of them
+type pageCountingStore struct {
+	store.Store
+	listReads atomic.Int32
+	pageReads atomic.Int32
+}
+
+func (s *pageCountingStore) GetChirps(ctx context.Context, arg database.GetChirpsParams) ([]database.Chirp, error) {
+	s.listReads.Add(1)
+	return s.Store.GetChirps(ctx, arg)
+}
+
+func (s *pageCountingStore) GetChirpsById(ctx context.Context, arg database.GetChirpsByIdParams) ([]database.Chirp, error) {
+	s.listReads.Add(1)
+	return s.Store.GetChirpsById(ctx, arg)
+}
+
+func (s *pageCountingStore) ListChirpsPage(ctx context.Context, arg database.ListChirpsPageParams) ([]database.Chirp, error) {
+	s.pageReads.Add(1)
+	return s.Store.ListChirpsPage(ctx, arg)
+}
+
+func (s *pageCountingStore) ListChirpsPageDesc(ctx context.Context, arg database.ListChirpsPageDescParams) ([]database.Chirp, error) {
+	s.pageReads.Add(1)
+	return s.Store.ListChirpsPageDesc(ctx, arg)
+}
+
+// Holds chirp reads until release is closed, counting how many reached the store
+type slowReadStore struct {
+	store.Store
+	release    chan struct{}
+	chirpReads atomic.Int32
+	listReads  atomic.Int32
+}
+
+func (s *slowReadStore) GetChirpById(ctx context.Context, arg database.GetChirpByIdParams) (database.Chirp, error) {
+	s.chirpReads.Add(1)
+	<-s.release
+	return s.Store.GetChirpById(ctx, arg)
+}
+
+func (s *slowReadStore) GetChirps(ctx context.Context, arg database.GetChirpsParams) ([]database.Chirp, error) {
+	s.listReads.Add(1)
+	<-s.release
+	return s.Store.GetChirps(ctx, arg)
+}
+
+// Answers every chirp list read as if the query ran past its timeout
+type timingOutStore struct {
+	store.Store
+}
+
+func (timingOutStore) GetChirps(ctx context.Context, arg database.GetChirpsParams) ([]database.Chirp, error) {
+	return nil, fmt.Errorf("GetChirps: %w", store.ErrQueryTimeout)
+}
+
+func TestQueryTimeoutAnswers504(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.store = timingOutStore{cfg.store}
+	rec := doRequest(t, cfg.routes(), "GET", "/api/chirps", "", "")
+	var resp apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 504 || resp.Error.Code != "timeout" {
+		t.Fatalf("Expected a 504 timeout error, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDuplicateEmailAnswers409(t *testing.T) {
+	handler := newTestConfig().routes()
+	registerAndLogin(t, handler, "taken@example.com")
+	rec := doRequest(t, handler, "POST", "/api/users", "", `{"email":"taken@example.com","password":"secret1"}`)
+	var resp apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 409 || resp.Error.Code != "already_exists" || resp.Error.Message != "email is already taken" {
+		t.Fatalf("Expected a 409 already_exists error, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestUniqueViolationFromDrivers(t *testing.T) {
+	for _, err := range []error{
+		&pq.Error{Code: "23505", Constraint: "users_tenant_id_email_idx"},
+		fmt.Errorf("creating user: %w", &pgconn.PgError{Code: "23505", ConstraintName: "users_tenant_id_email_idx"}),
+	} {
+		constraint, ok := uniqueViolation(err)
+		if !ok || constraint != "users_tenant_id_email_idx" {
+			t.Fatalf("Expected %v to be a unique violation, got %q %t", err, constraint, ok)
+		}
+	}
+	if _, ok := uniqueViolation(&pgconn.PgError{Code: "23503"}); ok {
+		t.Fatal("Expected a foreign key violation not to count")
+	}
+}
+
+func TestConcurrentReadsAreCoalesced(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "viral@example.com")
+	rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"going viral"}`)
+	var chirp Chirp
+	json.Unmarshal(rec.Body.Bytes(), &chirp)
+	cfg.purgeChirpCache(context.Background())
+	slow := &slowReadStore{Store: cfg.store, release: make(chan struct{})}
+	cfg.store = slow
+
+	var wg sync.WaitGroup
+	codes := make([]int, 40)
+	for i := range codes {
+		path := "/api/chirps"
+		if i%2 == 0 {
+			path += "/" + chirp.ID.String()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = doRequest(t, handler, "GET", path, "", "").Code
+		}()
+	}
+	// give every request time to join the reads already waiting on the store
+	time.Sleep(100 * time.Millisecond)
+	close(slow.release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != 200 {
+			t.Fatalf("Expected 200 for request %d, got %d", i, code)
+		}
+	}
+	if slow.chirpReads.Load() != 1 || slow.listReads.Load() != 1 {
+		t.Fatalf("Expected one query per distinct read, got %d chirp and %d list queries", slow.chirpReads.Load(), slow.listReads.Load())
+	}
+}
+
+func TestGraphQLNestedQueryIsBatched(t *testing.T) {
+	cfg := newTestConfig()
+	counting := &batchCountingStore{Store: cfg.store}
+	cfg.store = counting
+	handler := cfg.routes()
+	var users []User
+	for _, email := range []string{"gql1@example.com", "gql2@example.com", "gql3@example.com"} {
+		user := registerAndLogin(t, handler, email)
+		users = append(users, user)
+		for i := range 2 {
+			rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, fmt.Sprintf(`{"body":"chirp %d"}`, i))
+			if rec.Code != 201 {
+				t.Fatalf("Expected 201 creating chirp, got %d", rec.Code)
+			}
+		}
+	}
+
+	query := `{"query":"query($after: String) { me { email } chirps(first: 4, after: $after) { edges { node { body author { id email chirps(first: 1) { edges { node { id } } } } } } pageInfo { hasNextPage endCursor } } }"}`
+	rec := doRequest(t, handler, "POST", "/api/graphql", users[0].Token, query)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	type chirpNode struct {
+		Body   string `json:"body"`
+		Author struct {
+			ID     string  `json:"id"`
+			Email  *string `json:"email"`
+			Chirps struct {
+				Edges []struct{} `json:"edges"`
+			} `json:"chirps"`
+		} `json:"author"`
+	}
+	var resp struct {
+		Errors []any `json:"errors"`
+		Data   struct {
+			Me struct {
+				Email string `json:"email"`
+			} `json:"me"`
+			Chirps struct {
+				Edges []struct {
+					Node chirpNode `json:"node"`
+				} `json:"edges"`
+				PageInfo struct {
+					HasNextPage bool   `json:"hasNextPage"`
+					EndCursor   string `json:"endCursor"`
+				} `json:"pageInfo"`
+			} `json:"chirps"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Errors) > 0 {
+		t.Fatalf("Unexpected response %s: %v", rec.Body.String(), err)
+	}
+	if resp.Data.Me.Email != "gql1@example.com" {
+		t.Fatalf("Expected me to be the token's user, got %q", resp.Data.Me.Email)
+	}
+	edges := resp.Data.Chirps.Edges
+	if len(edges) != 4 || !resp.Data.Chirps.PageInfo.HasNextPage {
+		t.Fatalf("Expected a first page of 4 with more to come, got %d %v", len(edges), resp.Data.Chirps.PageInfo.HasNextPage)
+	}
+	for _, edge := range edges {
+		author := edge.Node.Author
+		if len(author.Chirps.Edges) != 1 {
+			t.Fatalf("Expected one nested chirp per author, got %d", len(author.Chirps.Edges))
+		}
+		if (author.Email != nil) != (author.ID == users[0].ID.String()) {
+			t.Fatalf("Expected email only for the viewer, got %v for %s", author.Email, author.ID)
+		}
+	}
+	if counting.userBatches.Load() != 1 || counting.chirpBatches.Load() != 1 {
+		t.Fatalf("Expected one batch each, got %d user and %d chirp batches", counting.userBatches.Load(), counting.chirpBatches.Load())
+	}
+
+	next := strings.Replace(query, `"}`, `","variables":{"after":"`+resp.Data.Chirps.PageInfo.EndCursor+`"}}`, 1)
+	rec = doRequest(t, handler, "POST", "/api/graphql", "", next)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Data.Chirps.Edges) != 2 || resp.Data.Chirps.PageInfo.HasNextPage {
+		t.Fatalf("Expected the last 2 chirps on the second page, got %s", rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "POST", "/api/graphql", "not-a-token", query)
+	if rec.Code != 401 {
+		t.Fatalf("Expected 401 for an invalid token, got %d", rec.Code)
+	}
+}
+
+func TestGraphQLFavouritesAreBatched(t *testing.T) {
+	cfg := newTestConfig()
+	counting := &batchCountingStore{Store: cfg.store}
+	cfg.store = counting
+	handler := cfg.routes()
+	fan := registerAndLogin(t, handler, "fan@example.com")
+	other := registerAndLogin(t, handler, "other@example.com")
+	var chirps []Chirp
+	for i := range 4 {
+		rec := doRequest(t, handler, "POST", "/api/chirps", other.Token, fmt.Sprintf(`{"body":"chirp %d"}`, i))
+		var chirp Chirp
+		json.Unmarshal(rec.Body.Bytes(), &chirp)
+		chirps = append(chirps, chirp)
+	}
+	for _, chirp := range chirps[:2] {
+		cfg.store.FavouriteChirp(context.Background(), database.FavouriteChirpParams{UserID: fan.ID, ChirpID: chirp.ID})
+	}
+	cfg.store.FavouriteChirp(context.Background(), database.FavouriteChirpParams{UserID: other.ID, ChirpID: chirps[0].ID})
+
+	type chirpNode struct {
+		Body            string `json:"body"`
+		FavouritesCount int    `json:"favouritesCount"`
+		Favourited      bool   `json:"favourited"`
+	}
+	var resp struct {
+		Errors []any `json:"errors"`
+		Data   struct {
+			Chirps struct {
+				Edges []struct {
+					Node chirpNode `json:"node"`
+				} `json:"edges"`
+			} `json:"chirps"`
+		} `json:"data"`
+	}
+	query := `{"query":"{ chirps { edges { node { body favouritesCount favourited } } } }"}`
+	rec := doRequest(t, handler, "POST", "/api/graphql", fan.Token, query)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || len(resp.Errors) > 0 || len(resp.Data.Chirps.Edges) != 4 {
+		t.Fatalf("Unexpected response %s: %v", rec.Body.String(), err)
+	}
+	want := []chirpNode{{"chirp 0", 2, true}, {"chirp 1", 1, true}, {"chirp 2", 0, false}, {"chirp 3", 0, false}}
+	for i, edge := range resp.Data.Chirps.Edges {
+		if edge.Node != want[i] {
+			t.Fatalf("Expected %+v, got %+v", want[i], edge.Node)
+		}
+	}
+	if counting.favouriteCountBatches.Load() != 1 || counting.favouritedBatches.Load() != 1 {
+		t.Fatalf("Expected one batch each, got %d count and %d favourited batches", counting.favouriteCountBatches.Load(), counting.favouritedBatches.Load())
+	}
+
+	// without a token nothing is favourited, and nobody is asked
+	rec = doRequest(t, handler, "POST", "/api/graphql", "", query)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Data.Chirps.Edges[0].Node.Favourited {
+		t.Fatalf("Expected nothing favourited without a token, got %s", rec.Body.String())
+	}
+	if counting.favouritedBatches.Load() != 1 {
+		t.Fatalf("Expected no favourited batch without a viewer, got %d", counting.favouritedBatches.Load())
+	}
+
+	query = fmt.Sprintf(`{"query":"{ me { favourites { edges { node { body favouritesCount } } } } user(id: \"%s\") { favourites { edges { node { body } } } } }"}`, other.ID)
+	rec = doRequest(t, handler, "POST", "/api/graphql", fan.Token, query)
+	var mine struct {
+		Errors []any `json:"errors"`
+		Data   struct {
+			Me struct {
+				Favourites struct {
+					Edges []struct {
+						Node chirpNode `json:"node"`
+					} `json:"edges"`
+				} `json:"favourites"`
+			} `json:"me"`
+			User struct {
+				Favourites *struct{} `json:"favourites"`
+			} `json:"user"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &mine); err != nil || len(mine.Errors) > 0 {
+		t.Fatalf("Unexpected response %s: %v", rec.Body.String(), err)
+	}
+	if edges := mine.Data.Me.Favourites.Edges; len(edges) != 2 || edges[0].Node.FavouritesCount+edges[1].Node.FavouritesCount != 3 {
+		t.Fatalf("Expected the viewer's 2 favourites, got %s", rec.Body.String())
+	}
+	if mine.Data.User.Favourites != nil {
+		t.Fatalf("Expected another user's favourites to be hidden, got %s", rec.Body.String())
+	}
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	cfg := newTestConfig()
+	handler := middlewareRequestID(cfg.routes())
+	registerAndLogin(t, handler, "envelope@example.com")
+
+	req := httptest.NewRequest("POST", "/api/login", strings.NewReader(`{"email":"envelope@example.com","password":"wrong"}`))
+	req.Header.Set("X-Request-ID", "login-401")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var resp apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode error body %s: %v", rec.Body.String(), err)
+	}
+	if rec.Code != 401 || resp.Error.Code != "invalid_credentials" || resp.Error.RequestID != "login-401" || resp.Error.Message == "" {
+		t.Fatalf("Unexpected error response %d %+v", rec.Code, resp.Error)
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected a JSON error, got %q", rec.Header().Get("Content-Type"))
+	}
+
+	cfg.platform = "prod"
+	rec = doRequest(t, handler, "POST", "/api/users", "", `{"email":"prod@example.com","password":"hunter2"}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 403 || resp.Error.Code != "forbidden" {
+		t.Fatalf("Expected a single 403 envelope, got %d %s", rec.Code, rec.Body.String())
+	}
+	if _, err := cfg.store.GetUserByEmail(context.Background(), database.GetUserByEmailParams{Email: "prod@example.com"}); err == nil {
+		t.Fatal("Expected registration to stop after the 403")
+	}
+}
+
+func TestStrictJSONDecoding(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	cases := []struct {
+		name   string
+		body   string
+		code   string
+		fields []validation.FieldError
+	}{
+		{"malformed", `{"email":`, "malformed_json", nil},
+		{"empty", ``, "malformed_json", nil},
+		{"trailing data", `{"email":"a@example.com","password":"x"} {}`, "malformed_json", nil},
+		{"unknown and mistyped fields", `{"email":5,"password":"x","pasword":"y","remember":true}`, "validation_failed", []validation.FieldError{
+			{Field: "email", Message: "must be a string"},
+			{Field: "pasword", Message: "unknown field"},
+			{Field: "remember", Message: "unknown field"},
+		}},
+	}
+	for _, tc := range cases {
+		rec := doRequest(t, handler, "POST", "/api/login", "", tc.body)
+		var resp struct {
+			Error struct {
+				Code    string `json:"code"`
+				Details struct {
+					Fields []validation.FieldError `json:"fields"`
+				} `json:"details"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to decode %s: %v", tc.name, rec.Body.String(), err)
+		}
+		if rec.Code != 400 || resp.Error.Code != tc.code {
+			t.Fatalf("%s: expected 400 %s, got %d %s", tc.name, tc.code, rec.Code, rec.Body.String())
+		}
+		if tc.fields != nil && !slices.Equal(resp.Error.Details.Fields, tc.fields) {
+			t.Fatalf("%s: expected fields %v, got %v", tc.name, tc.fields, resp.Error.Details.Fields)
+		}
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+	handler := newTestConfig().routes()
+	cases := []struct {
+		method, path, body string
+		fields             []validation.FieldError
+	}{
+		{"POST", "/api/users", `{"email":"not-an-email","password":"abc"}`, []validation.FieldError{
+			{Field: "email", Message: "must be an email address"},
+			{Field: "password", Message: "must be at least 6 characters"},
+		}},
+		{"POST", "/api/login", `{"email":"","password":""}`, []validation.FieldError{
+			{Field: "email", Message: "is required"},
+			{Field: "password", Message: "is required"},
+		}},
+		{"GET", "/api/chirps?sort=sideways&author_id=42", "", []validation.FieldError{
+			{Field: "author_id", Message: "must be a UUID"},
+			{Field: "sort", Message: "must be one of asc, desc"},
+		}},
+	}
+	for _, tc := range cases {
+		rec := doRequest(t, handler, tc.method, tc.path, "", tc.body)
+		var resp struct {
+			Error struct {
+				Code    string `json:"code"`
+				Details struct {
+					Fields []validation.FieldError `json:"fields"`
+				} `json:"details"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 400 || resp.Error.Code != "validation_failed" {
+			t.Fatalf("%s %s: expected a 400 validation error, got %d %s", tc.method, tc.path, rec.Code, rec.Body.String())
+		}
+		if !slices.Equal(resp.Error.Details.Fields, tc.fields) {
+			t.Fatalf("%s %s: expected fields %v, got %v", tc.method, tc.path, tc.fields, resp.Error.Details.Fields)
+		}
+	}
+}
+
+func TestUnknownAPIRoutesAnswerJSON(t *testing.T) {
+	handler := newTestConfig().routes()
+
+	rec := doRequest(t, handler, "GET", "/api/nope", "", "")
+	var resp apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 404 || resp.Error.Code != "not_found" {
+		t.Fatalf("Expected a JSON 404, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	for _, path := range []string{"/api/login", "/api/v1/login"} {
+		rec = doRequest(t, handler, "DELETE", path, "", "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 405 || resp.Error.Code != "method_not_allowed" {
+			t.Fatalf("Expected a JSON 405 for %s, got %d %s", path, rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("Allow") != "POST" {
+			t.Fatalf("Expected Allow: POST for %s, got %q", path, rec.Header().Get("Allow"))
+		}
+	}
+
+	rec = doRequest(t, handler, "PATCH", "/api/chirps/"+uuid.NewString(), "", "")
+	if rec.Code != 405 || rec.Header().Get("Allow") != "GET, HEAD, DELETE" {
+		t.Fatalf("Expected 405 with Allow: GET, HEAD, DELETE, got %d %q", rec.Code, rec.Header().Get("Allow"))
+	}
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.middlewareMaintenance(cfg.routes())
+	user := registerAndLogin(t, handler, "maintenance@example.com")
+
+	rec := doRequest(t, handler, "PUT", "/admin/maintenance", cfg.adminToken, `{"read_only": true, "message": "Migrating", "retry_after_seconds": 60}`)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 enabling maintenance, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"paused"}`)
+	var resp apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 503 || resp.Error.Code != "maintenance" || resp.Error.Message != "Migrating" {
+		t.Fatalf("Expected a 503 maintenance error for a write, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Retry-After") != "60" {
+		t.Fatalf("Expected Retry-After: 60, got %q", rec.Header().Get("Retry-After"))
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected reads to work in read-only mode, got %d", rec.Code)
+	}
+
+	doRequest(t, handler, "PUT", "/admin/maintenance", cfg.adminToken, `{}`)
+	if rec = doRequest(t, handler, "GET", "/api/chirps", "", ""); rec.Code != 503 {
+		t.Fatalf("Expected reads to be paused too, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "GET", "/api/healthz", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected the liveness probe to keep working, got %d", rec.Code)
+	}
+	req := httptest.NewRequest("GET", "/app/", nil)
+	req.Header.Set("Accept", "text/html")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 503 || !strings.Contains(rec.Body.String(), "Down for maintenance") {
+		t.Fatalf("Expected the HTML maintenance page, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec = doRequest(t, handler, "DELETE", "/admin/maintenance", cfg.adminToken, ""); rec.Code != 204 {
+		t.Fatalf("Expected 204 disabling maintenance, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"back"}`); rec.Code != 201 {
+		t.Fatalf("Expected writes to work again, got %d", rec.Code)
+	}
+}
+
+func TestLoadSheddingSparesAuthAndHealth(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.shedder = loadshed.New(loadshed.Options{MaxInFlight: 1})
+	mux := cfg.routes()
+	handler := cfg.middlewareLoadShed(mux, mux)
+	registerAndLogin(t, handler, "busy@example.com")
+
+	// one slow request keeps the server at its limit
+	done := cfg.shedder.Start()
+	defer done()
+	for _, path := range []string{"/api/chirps", "/api/v1/chirps"} {
+		rec := doRequest(t, handler, "GET", path, "", "")
+		var resp apiErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 503 || resp.Error.Code != "overloaded" {
+			t.Fatalf("Expected %s to be shed, got %d %s", path, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := doRequest(t, handler, "POST", "/api/login", "", `{"email":"busy@example.com","password":"hunter2"}`); rec.Code != 200 {
+		t.Fatalf("Expected login to be served while overloaded, got %d", rec.Code)
+	}
+	if rec := doRequest(t, handler, "GET", "/api/healthz", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected the health probe to be served while overloaded, got %d", rec.Code)
+	}
+}
+
+func TestConcurrencyLimitRejectsWithTooManyRunning(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.limiter = loadshed.NewLimiter(loadshed.LimiterOptions{MaxConcurrent: 1, QueueTimeout: 10 * time.Millisecond})
+	handler := cfg.middlewareConcurrencyLimit(cfg.routes())
+
+	if rec := doRequest(t, handler, "GET", "/api/chirps", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected 200 with a free slot, got %d", rec.Code)
+	}
+	// one slow request holds the only slot
+	release, _ := cfg.limiter.Acquire(context.Background())
+	defer release()
+	rec := doRequest(t, handler, "GET", "/api/chirps", "", "")
+	var resp apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 429 || resp.Error.Code != "too_many_concurrent_requests" {
+		t.Fatalf("Expected 429 with every slot taken, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(t, handler, "GET", "/api/v1/readyz", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected the readiness probe to skip the limit, got %d", rec.Code)
+	}
+	if cfg.limiter.Rejected() != 1 {
+		t.Fatalf("Expected one rejection, got %d", cfg.limiter.Rejected())
+	}
+}
+
+func TestFaultInjectionPerRoute(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.faults = &faultInjector{
+		maxLatency:  time.Millisecond,
+		latencyRate: map[string]float64{"*": 1},
+		errorRate:   map[string]float64{"GET /chirps": 1},
+		dropRate:    map[string]float64{"POST /login": 1},
+		random:      func() float64 { return 0.5 },
+	}
+	mux := cfg.routes()
+	handler := cfg.middlewareFaults(mux, mux)
+
+	for _, path := range []string{"/api/chirps", "/api/v1/chirps"} {
+		rec := doRequest(t, handler, "GET", path, "", "")
+		var resp apiErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 500 || resp.Error.Code != "injected_fault" {
+			t.Fatalf("Expected an injected 500 for %s, got %d %s", path, rec.Code, rec.Body.String())
+		}
+	}
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Fatalf("Expected the login connection to be dropped, got %v", p)
+			}
+		}()
+		doRequest(t, handler, "POST", "/api/login", "", `{}`)
+	}()
+	// routes without a rate and "*" are only slowed down
+	if rec := doRequest(t, handler, "GET", "/api/healthz", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected the health probe to be served, got %d", rec.Code)
+	}
+	cfg.faults.errorRate["*"] = 0.25
+	if rec := doRequest(t, handler, "GET", "/api/healthz", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected a roll above the rate to be served, got %d", rec.Code)
+	}
+}
+
+func TestAccessLogSampling(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	cfg := newTestConfig()
+	roll := 0.5
+	cfg.accessLog = &accessLog{sampleRate: 0.1, slow: time.Second, random: func() float64 { return roll }}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ok/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(503) })
+	handler := cfg.middlewareAccessLog(mux, mux)
+
+	doRequest(t, handler, "GET", "/ok/1", "", "")
+	if out.Len() != 0 {
+		t.Fatalf("Expected a request rolled above the rate to be left out, got %q", out.String())
+	}
+	doRequest(t, handler, "GET", "/fail", "", "")
+	if !strings.Contains(out.String(), "GET /fail 503") {
+		t.Fatalf("Expected server errors to always be logged, got %q", out.String())
+	}
+	out.Reset()
+	roll = 0.05
+	doRequest(t, handler, "GET", "/ok/2", "", "")
+	if !strings.Contains(out.String(), `GET /ok/2 200`) || !strings.Contains(out.String(), `route="GET /ok/{id}"`) {
+		t.Fatalf("Expected a sampled request to be logged with its route, got %q", out.String())
+	}
+}
+
+func TestLogFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chirpy.log")
+	t.Setenv("LOG_FILE", path)
+	closeLogFile := setupLogFile()
+	log.Printf("written to the file")
+	closeLogFile()
+
+	dat, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(dat), "written to the file") {
+		t.Fatalf("Expected the log in %s, got %q (%v)", path, dat, err)
+	}
+}
+
+func TestDebugBodyLogging(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	cfg := newTestConfig()
+	mux := cfg.routes()
+	handler := cfg.middlewareDebugBodies(mux, mux)
+	if rec := doRequest(t, handler, "PUT", "/admin/debug/bodies", cfg.adminToken, `{"routes":["POST /login"]}`); rec.Code != 404 {
+		t.Fatalf("Expected 404 without DEBUG_BODY_LOGGING, got %d", rec.Code)
+	}
+
+	cfg.debugBodiesAllowed = true
+	handler = cfg.middlewareDebugBodies(mux, mux)
+	if rec := doRequest(t, handler, "PUT", "/admin/debug/bodies", cfg.adminToken, `{"routes":["POST /nowhere"]}`); rec.Code != 400 {
+		t.Fatalf("Expected 400 for an unknown route, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := doRequest(t, handler, "PUT", "/admin/debug/bodies", cfg.adminToken, `{"routes":["POST /login"],"duration_seconds":60}`); rec.Code != 200 {
+		t.Fatalf("Expected 200 enabling debug body logging, got %d: %s", rec.Code, rec.Body.String())
+	}
+	out.Reset()
+	user := registerAndLogin(t, handler, "debug@example.com")
+	logged := out.String()
+	if !strings.Contains(logged, "Debug POST /login") || !strings.Contains(logged, `"email":"debug@example.com"`) {
+		t.Fatalf("Expected the login request and response to be logged, got %q", logged)
+	}
+	if strings.Contains(logged, "Debug POST /users") {
+		t.Fatalf("Expected only the enabled route to be logged, got %q", logged)
+	}
+	if strings.Contains(logged, "hunter2") || strings.Contains(logged, user.Token) || strings.Contains(logged, user.RefreshToken) {
+		t.Fatalf("Expected the password and tokens to be redacted, got %q", logged)
+	}
+
+	if rec := doRequest(t, handler, "DELETE", "/admin/debug/bodies", cfg.adminToken, ""); rec.Code != 204 {
+		t.Fatalf("Expected 204 disabling debug body logging, got %d", rec.Code)
+	}
+	out.Reset()
+	doRequest(t, handler, "POST", "/api/login", "", `{"email":"debug@example.com","password":"hunter2"}`)
+	if strings.Contains(out.String(), "Debug") {
+		t.Fatalf("Expected nothing logged once disabled, got %q", out.String())
+	}
+}
+
+func TestIPRules(t *testing.T) {
+	t.Setenv("IP_DENYLIST", "198.51.100.0/24")
+	cfg := newTestConfig()
+	handler := cfg.middlewareIPFilter(cfg.routes())
+	from := func(ip, method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = net.JoinHostPort(ip, "40000")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := from("198.51.100.7", "POST", "/api/login", "", `{"email":"a@example.com","password":"hunter2"}`)
+	var resp apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 403 || resp.Error.Code != "ip_blocked" {
+		t.Fatalf("Expected a denied source to get 403 before logging in, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = from("203.0.113.5", "GET", "/admin/ip-rules", cfg.adminToken, ""); rec.Code != 200 || !strings.Contains(rec.Body.String(), `"denylist":["198.51.100.0/24"]`) {
+		t.Fatalf("Expected the configured rules, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = from("192.0.2.1", "PUT", "/admin/ip-rules", cfg.adminToken, `{"admin_allowlist":["203.0.113.0/24"]}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 400 || resp.Error.Code != "ip_lockout" {
+		t.Fatalf("Expected rules locking out the admin making them to be refused, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = from("192.0.2.1", "PUT", "/admin/ip-rules", cfg.adminToken, `{"denylist":["not-an-ip"]}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 400 || resp.Error.Code != "invalid_ip_range" {
+		t.Fatalf("Expected 400 for an invalid range, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = from("192.0.2.1", "PUT", "/admin/ip-rules", cfg.adminToken, `{"denylist":["2001:db8::/32"],"admin_allowlist":["192.0.2.1"]}`); rec.Code != 200 {
+		t.Fatalf("Expected 200 saving IP rules, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = from("203.0.113.5", "GET", "/admin/ip-rules", cfg.adminToken, ""); rec.Code != 403 {
+		t.Fatalf("Expected a source outside the admin allowlist to get 403, got %d", rec.Code)
+	}
+	if rec = from("203.0.113.5", "GET", "/api/chirps", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected the admin allowlist to leave other routes alone, got %d", rec.Code)
+	}
+	if rec = from("2001:db8::1", "GET", "/api/chirps", "", ""); rec.Code != 403 {
+		t.Fatalf("Expected an address on the managed denylist to get 403, got %d", rec.Code)
+	}
+
+	if rec = from("192.0.2.1", "DELETE", "/admin/ip-rules", cfg.adminToken, ""); rec.Code != 204 {
+		t.Fatalf("Expected 204 removing the IP rules, got %d", rec.Code)
+	}
+	if rec = from("203.0.113.5", "GET", "/admin/ip-rules", cfg.adminToken, ""); rec.Code != 200 || !strings.Contains(rec.Body.String(), `"managed":null`) {
+		t.Fatalf("Expected the admin endpoints open again, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAdminClientCertificates(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.adminClientCAs = x509.NewCertPool()
+	handler := cfg.middlewareAdminClientCert(cfg.routes())
+	withCert := func(req *http.Request) *http.Request {
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "alice"}}}}}
+		return req
+	}
+
+	rec := doRequest(t, handler, "GET", "/admin/maintenance", cfg.adminToken, "")
+	var resp apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 403 || resp.Error.Code != "client_certificate_required" {
+		t.Fatalf("Expected 403 for an admin request without a certificate, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "GET", "/api/healthz", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected public routes to need no certificate, got %d", rec.Code)
+	}
+	req := withCert(httptest.NewRequest("GET", "/admin/maintenance", nil))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 401 {
+		t.Fatalf("Expected a certificate alone to still need the admin token, got %d", rec.Code)
+	}
+	req = withCert(httptest.NewRequest("PUT", "/admin/maintenance", strings.NewReader(`{"read_only":true}`)))
+	req.Header.Set("Authorization", "Bearer "+cfg.adminToken)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 200 {
+		t.Fatalf("Expected a certificate and the admin token to be let in, got %d %s", rec.Code, rec.Body.String())
+	}
+	entries, err := cfg.store.ListAuditEntries(context.Background(), database.ListAuditEntriesParams{Action: "maintenance.enable", Until: time.Now().Add(time.Minute), MaxEntries: 1})
+	if err != nil || len(entries) != 1 || entries[0].Actor != "admin-cert:alice" {
+		t.Fatalf("Expected the audit log to name the certificate, got %+v (%v)", entries, err)
+	}
+
+	split := withoutAdmin(handler)
+	if rec = doRequest(t, split, "GET", "/admin/maintenance", cfg.adminToken, ""); rec.Code != 404 {
+		t.Fatalf("Expected the public listener to leave out admin routes, got %d", rec.Code)
+	}
+	if rec = doRequest(t, adminOnly(handler), "GET", "/api/healthz", "", ""); rec.Code != 404 {
+		t.Fatalf("Expected the admin listener to serve admin routes only, got %d", rec.Code)
+	}
+}
+
+// Secret store for tests, holding one field per path
+type fakeSecretStore struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (f *fakeSecretStore) Fetch(ctx context.Context, path string) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	value, ok := f.values[path]
+	if !ok {
+		return nil, secrets.ErrNotFound
+	}
+	return map[string]string{"value": value}, nil
+}
+
+func TestSecretReferences(t *testing.T) {
+	fake := &fakeSecretStore{values: map[string]string{"chirpy/jwt": "first-secret"}}
+	secretEnv.resolver.Register("vault", fake)
+	t.Cleanup(func() {
+		secretEnv.resolver = secrets.NewResolver()
+		secretEnv.values = map[string]string{}
+	})
+	t.Setenv("JWT_SECRET_KEY", "vault:chirpy/jwt")
+	t.Setenv("SMTP_USERNAME", "plain-user")
+	if err := loadSecrets(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := getSecretEnv("JWT_SECRET_KEY"); got != "first-secret" {
+		t.Fatalf("Expected the referenced secret, got %q", got)
+	}
+	if got := getSecretEnv("SMTP_USERNAME"); got != "plain-user" {
+		t.Fatalf("Expected plain values to be used as they are, got %q", got)
+	}
+
+	cfg := newTestConfig()
+	cfg.setJWTSecret(getSecretEnv("JWT_SECRET_KEY"))
+	userID := uuid.New()
+	oldToken, err := cfg.makeJWT(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	fake.values["chirpy/jwt"] = "second-secret"
+	fake.mu.Unlock()
+	changed, err := fetchSecrets(context.Background())
+	if err != nil || !slices.Equal(changed, []string{"JWT_SECRET_KEY"}) {
+		t.Fatalf("Expected the rotation to be noticed, got %v (%v)", changed, err)
+	}
+	cfg.setJWTSecret(getSecretEnv("JWT_SECRET_KEY"))
+	if got, err := cfg.validateJWT(oldToken); err != nil || got != userID {
+		t.Fatalf("Expected tokens signed before the rotation to keep working, got %v (%v)", got, err)
+	}
+	newToken, _ := cfg.makeJWT(userID)
+	if _, err := auth.ValidateJWT(newToken, "second-secret"); err != nil {
+		t.Fatalf("Expected new tokens to be signed with the new secret, got %v", err)
+	}
+	cfg.jwtKeys.Load().previousUntil = time.Now()
+	if _, err := cfg.validateJWT(oldToken); err == nil {
+		t.Fatal("Expected the previous secret to stop working once its tokens expired")
+	}
+
+	fake.mu.Lock()
+	delete(fake.values, "chirpy/jwt")
+	fake.mu.Unlock()
+	if _, err := fetchSecrets(context.Background()); !errors.Is(err, secrets.ErrNotFound) {
+		t.Fatalf("Expected a failed fetch to be reported, got %v", err)
+	}
+	if got := getSecretEnv("JWT_SECRET_KEY"); got != "second-secret" {
+		t.Fatalf("Expected a failed fetch to keep the last secret, got %q", got)
+	}
+}
+
+func TestModerationQueue(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.moderationNotify = []string{notifyReporter, notifyAuthor}
+	handler := cfg.routes()
+	author := registerAndLogin(t, handler, "author@example.com")
+	alice := registerAndLogin(t, handler, "alice@example.com")
+	bob := registerAndLogin(t, handler, "bob@example.com")
+	var spam, joke Chirp
+	rec := doRequest(t, handler, "POST", "/api/chirps", author.Token, `{"body":"buy now"}`)
+	json.Unmarshal(rec.Body.Bytes(), &spam)
+	rec = doRequest(t, handler, "POST", "/api/chirps", author.Token, `{"body":"a joke"}`)
+	json.Unmarshal(rec.Body.Bytes(), &joke)
+
+	reportPath := "/api/chirps/" + spam.ID.String() + "/reports"
+	if rec = doRequest(t, handler, "POST", reportPath, author.Token, `{"reason":"spam"}`); rec.Code != 400 {
+		t.Fatalf("Expected reporting your own chirp to be refused, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "POST", reportPath, alice.Token, `{"reason":"boring"}`); rec.Code != 400 {
+		t.Fatalf("Expected an unknown reason to be refused, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "POST", reportPath, alice.Token, `{"reason":"spam","details":"link farm"}`); rec.Code != 201 {
+		t.Fatalf("Expected 201 reporting a chirp, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "POST", reportPath, alice.Token, `{"reason":"spam"}`); rec.Code != 409 {
+		t.Fatalf("Expected a second report by the same user to conflict, got %d", rec.Code)
+	}
+	doRequest(t, handler, "POST", reportPath, bob.Token, `{"reason":"harassment"}`)
+	doRequest(t, handler, "POST", "/api/chirps/"+joke.ID.String()+"/reports", bob.Token, `{"reason":"other"}`)
+	if rec = doRequest(t, handler, "POST", "/api/chirps/"+uuid.NewString()+"/reports", bob.Token, `{"reason":"other"}`); rec.Code != 404 {
+		t.Fatalf("Expected reporting a missing chirp to answer 404, got %d", rec.Code)
+	}
+	events, _ := cfg.store.ListUnpublishedOutboxEvents(context.Background(), 100)
+	var reported []string
+	for _, event := range events {
+		if event.Event == webhooks.ChirpReported {
+			reported = append(reported, event.Payload)
+		}
+	}
+	if len(reported) != 3 || !strings.Contains(reported[0], `"reason":"spam"`) || strings.Contains(reported[0], "link farm") || strings.Contains(reported[0], alice.ID.String()) {
+		t.Fatalf("Expected a chirp.reported event per report, without the reporter, got %v", reported)
+	}
+
+	var queue []moderationItem
+	rec = doRequest(t, handler, "GET", "/admin/moderation", "test-admin-token", "")
+	json.Unmarshal(rec.Body.Bytes(), &queue)
+	if len(queue) != 2 || queue[0].Chirp.ID != spam.ID || queue[0].ReportCount != 2 || queue[0].Reasons["harassment"] != 1 || queue[0].Flagged {
+		t.Fatalf("Expected both reported chirps, the spam first with 2 reports, got %s", rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "POST", "/admin/moderation/"+joke.ID.String()+"/escalate", "test-admin-token", `{"note":"ask legal"}`)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 escalating, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/admin/moderation?status=escalated", "test-admin-token", "")
+	json.Unmarshal(rec.Body.Bytes(), &queue)
+	if len(queue) != 1 || queue[0].Chirp.ID != joke.ID || !queue[0].Escalated {
+		t.Fatalf("Expected only the escalated chirp, got %s", rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "POST", "/admin/moderation/"+joke.ID.String()+"/approve", "test-admin-token", ""); rec.Code != 200 {
+		t.Fatalf("Expected 200 approving without a note, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "POST", "/admin/moderation/"+joke.ID.String()+"/approve", "test-admin-token", ""); rec.Code != 404 {
+		t.Fatalf("Expected a decided chirp to have left the queue, got %d", rec.Code)
+	}
+
+	rec = doRequest(t, handler, "POST", "/admin/moderation/"+spam.ID.String()+"/remove", "test-admin-token", `{"note":"obvious spam"}`)
+	var decision moderationDecisionResponse
+	json.Unmarshal(rec.Body.Bytes(), &decision)
+	if rec.Code != 200 || decision.Action != moderationRemove || decision.Reports != 2 || decision.Moderator != adminTokenActor {
+		t.Fatalf("Expected the removal to be recorded, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps/"+spam.ID.String(), "", ""); rec.Code != 404 {
+		t.Fatalf("Expected the removed chirp to be gone, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "GET", "/admin/moderation", "test-admin-token", "")
+	if rec.Body.String() != "[]" {
+		t.Fatalf("Expected an empty queue, got %s", rec.Body.String())
+	}
+	// bob hears about the approval, alice and bob about the removal, and the author about the removal
+	jobs, _ := cfg.store.ListJobsByStatus(context.Background(), database.ListJobsByStatusParams{Status: "pending", Limit: 100})
+	var subjects []string
+	for _, job := range jobs {
+		var msg email.Message
+		if json.Unmarshal([]byte(job.Payload), &msg) == nil && job.Kind == email.JobKind {
+			subjects = append(subjects, msg.To+": "+msg.Subject)
+		}
+	}
+	slices.Sort(subjects)
+	want := []string{
+		"alice@example.com: Your report was reviewed",
+		"author@example.com: One of your chirps was removed",
+		"bob@example.com: Your report was reviewed",
+		"bob@example.com: Your report was reviewed",
+	}
+	if !slices.Equal(subjects, want) {
+		t.Fatalf("Expected %v to be emailed, got %v", want, subjects)
+	}
+	entries, _ := cfg.store.ListAuditEntries(context.Background(), database.ListAuditEntriesParams{Action: "moderation.remove", Since: time.Unix(0, 0), Until: time.Now().Add(time.Minute), MaxEntries: 10})
+	if len(entries) != 1 || entries[0].Target != spam.ID.String() {
+		t.Fatalf("Expected the removal in the audit log, got %+v", entries)
+	}
+}
+
+func TestReportAnalytics(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	author := registerAndLogin(t, handler, "author@example.com")
+	alice := registerAndLogin(t, handler, "alice@example.com")
+	bob := registerAndLogin(t, handler, "bob@example.com")
+	var spam, joke, reply Chirp
+	rec := doRequest(t, handler, "POST", "/api/chirps", author.Token, `{"body":"buy now"}`)
+	json.Unmarshal(rec.Body.Bytes(), &spam)
+	rec = doRequest(t, handler, "POST", "/api/chirps", author.Token, `{"body":"a joke"}`)
+	json.Unmarshal(rec.Body.Bytes(), &joke)
+	rec = doRequest(t, handler, "POST", "/api/chirps", alice.Token, `{"body":"no u"}`)
+	json.Unmarshal(rec.Body.Bytes(), &reply)
+	doRequest(t, handler, "POST", "/api/chirps/"+spam.ID.String()+"/reports", alice.Token, `{"reason":"spam"}`)
+	doRequest(t, handler, "POST", "/api/chirps/"+spam.ID.String()+"/reports", bob.Token, `{"reason":"spam"}`)
+	doRequest(t, handler, "POST", "/api/chirps/"+joke.ID.String()+"/reports", bob.Token, `{"reason":"harassment"}`)
+	doRequest(t, handler, "POST", "/api/chirps/"+reply.ID.String()+"/reports", bob.Token, `{"reason":"harassment"}`)
+	if rec = doRequest(t, handler, "POST", "/admin/moderation/"+spam.ID.String()+"/remove", "test-admin-token", ""); rec.Code != 200 {
+		t.Fatalf("Expected 200 removing, got %d: %s", rec.Code, rec.Body.String())
+	}
+	doRequest(t, handler, "POST", "/admin/moderation/"+joke.ID.String()+"/approve", "test-admin-token", "")
+
+	rec = doRequest(t, handler, "GET", "/admin/reports/analytics?limit=1", "test-admin-token", "")
+	var resp reportAnalyticsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 200 {
+		t.Fatalf("Expected 200 with the analytics, got %d: %s", rec.Code, rec.Body.String())
+	}
+	want := reportReasonCounts{Reports: 4, Open: 1, Approved: 1, Removed: 2}
+	if resp.Totals != want || len(resp.ByReason) != 2 || resp.ByReason[0].Reason != "harassment" || resp.ByReason[1].Removed != 2 {
+		t.Fatalf("Expected the reports counted by reason, got %s", rec.Body.String())
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	if len(resp.Timeline) != 1 || resp.Timeline[0].Day != today || resp.Timeline[0].Reports != 4 || resp.Timeline[0].ByReason["spam"] != 2 {
+		t.Fatalf("Expected today's reports in the timeline, got %+v", resp.Timeline)
+	}
+	if len(resp.MostReportedUsers) != 1 || resp.MostReportedUsers[0].Email != "author@example.com" || resp.MostReportedUsers[0].Chirps != 2 || resp.MostReportedUsers[0].RemovedChirps != 1 {
+		t.Fatalf("Expected the author as the most reported user, got %+v", resp.MostReportedUsers)
+	}
+	if len(resp.MostReportedChirps) != 1 || resp.MostReportedChirps[0].ChirpID != spam.ID || resp.MostReportedChirps[0].Body != nil {
+		t.Fatalf("Expected the removed spam as the most reported chirp, got %+v", resp.MostReportedChirps)
+	}
+	if resp.ResponseTimes.Decided != 3 || len(resp.ByModerator) != 1 || resp.ByModerator[0].Moderator != "admin-token" || resp.ByModerator[0].Removed != 2 {
+		t.Fatalf("Expected the three decided reports, got %+v %+v", resp.ResponseTimes, resp.ByModerator)
+	}
+
+	if rec = doRequest(t, handler, "GET", "/admin/reports/analytics?since="+time.Now().Add(time.Hour).Format(time.RFC3339), "test-admin-token", ""); rec.Code != 400 {
+		t.Fatalf("Expected 400 for a window that ends before it starts, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "GET", "/admin/reports/analytics?until="+time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)+"&since=2020-01-01T00:00:00Z", "test-admin-token", "")
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != 200 || resp.Totals.Reports != 0 || len(resp.Timeline) != 0 {
+		t.Fatalf("Expected no reports before they were made, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestWordFilters(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "author@example.com")
+	create := func(body string) wordFilterResponse {
+		t.Helper()
+		rec := doRequest(t, handler, "POST", "/admin/word-filters", "test-admin-token", body)
+		if rec.Code != 201 {
+			t.Fatalf("Expected 201 creating %s, got %d: %s", body, rec.Code, rec.Body.String())
+		}
+		var filter wordFilterResponse
+		json.Unmarshal(rec.Body.Bytes(), &filter)
+		return filter
+	}
+	create(`{"pattern":"sharb*","kind":"wildcard","action":"mask"}`)
+	hold := create(`{"pattern":" bargain ","action":"hold","note":"often spam"}`)
+	reject := create(`{"pattern":"buy\\s+now","kind":"regex","action":"reject"}`)
+	if hold.Kind != "word" || hold.Pattern != "bargain" {
+		t.Fatalf("Expected a trimmed word rule by default, got %+v", hold)
+	}
+	for _, body := range []string{
+		`{"pattern":"(unclosed","kind":"regex","action":"mask"}`,
+		`{"pattern":"two words","action":"mask"}`,
+		`{"pattern":"kerfuffle","action":"ban"}`,
+	} {
+		if rec := doRequest(t, handler, "POST", "/admin/word-filters", "test-admin-token", body); rec.Code != 400 {
+			t.Fatalf("Expected %s to be refused, got %d", body, rec.Code)
+		}
+	}
+	if rec := doRequest(t, handler, "POST", "/admin/word-filters", "test-admin-token", `{"pattern":"bargain","action":"mask"}`); rec.Code != 409 {
+		t.Fatalf("Expected a duplicate pattern to conflict, got %d", rec.Code)
+	}
+
+	var chirp Chirp
+	rec := doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"Sharbert and kerfuffle"}`)
+	json.Unmarshal(rec.Body.Bytes(), &chirp)
+	if rec.Code != 201 || chirp.Body != "**** and ****" {
+		t.Fatalf("Expected the rules and PROFANITY_WORDS both masked, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"BUY   now!"}`); rec.Code != 400 {
+		t.Fatalf("Expected a chirp matching a reject rule to be refused, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"what a bargain"}`)
+	json.Unmarshal(rec.Body.Bytes(), &chirp)
+	if rec.Code != 201 {
+		t.Fatalf("Expected a held chirp to be posted, got %d", rec.Code)
+	}
+	var queue []moderationItem
+	rec = doRequest(t, handler, "GET", "/admin/moderation?reason=word_filter", "test-admin-token", "")
+	json.Unmarshal(rec.Body.Bytes(), &queue)
+	if len(queue) != 1 || queue[0].Chirp.ID != chirp.ID || !queue[0].Flagged || queue[0].Reports[0].Details != `matched word "bargain"` {
+		t.Fatalf("Expected the held chirp flagged in the moderation queue, got %s", rec.Body.String())
+	}
+	// a held chirp is only announced and shown to others once a moderator approves it
+	reader := registerAndLogin(t, handler, "reader@example.com")
+	announced := func() bool {
+		events, _ := cfg.store.ListUnpublishedOutboxEvents(context.Background(), 100)
+		return slices.ContainsFunc(events, func(event database.OutboxEvent) bool {
+			return event.Event == webhooks.ChirpCreated && strings.Contains(event.Payload, chirp.ID.String())
+		})
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps/"+chirp.ID.String(), reader.Token, ""); rec.Code != 404 || announced() {
+		t.Fatalf("Expected the held chirp hidden and unannounced, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps", reader.Token, ""); strings.Contains(rec.Body.String(), "bargain") {
+		t.Fatalf("Expected the held chirp left out of the timeline, got %s", rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "POST", "/admin/moderation/"+chirp.ID.String()+"/approve", "test-admin-token", ""); rec.Code != 200 {
+		t.Fatalf("Expected 200 approving the held chirp, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps/"+chirp.ID.String(), reader.Token, ""); rec.Code != 200 || !announced() {
+		t.Fatalf("Expected the approved chirp visible and announced, got %d", rec.Code)
+	}
+
+	rec = doRequest(t, handler, "PUT", "/admin/word-filters/"+reject.ID.String(), "test-admin-token", `{"pattern":"buy\\s+now","kind":"regex","action":"mask"}`)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 updating a rule, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"buy now"}`)
+	if rec.Code != 201 || !strings.Contains(rec.Body.String(), `"body":"****"`) {
+		t.Fatalf("Expected the updated rule to mask, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "DELETE", "/admin/word-filters/"+reject.ID.String(), "test-admin-token", ""); rec.Code != 204 {
+		t.Fatalf("Expected 204 deleting a rule, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "DELETE", "/admin/word-filters/"+reject.ID.String(), "test-admin-token", ""); rec.Code != 404 {
+		t.Fatalf("Expected 404 deleting a missing rule, got %d", rec.Code)
+	}
+	var filters []wordFilterResponse
+	rec = doRequest(t, handler, "GET", "/admin/word-filters", "test-admin-token", "")
+	json.Unmarshal(rec.Body.Bytes(), &filters)
+	if len(filters) != 2 || filters[1].ID != hold.ID {
+		t.Fatalf("Expected the two remaining rules oldest first, got %s", rec.Body.String())
+	}
+}
+
+func TestAutomod(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	ctx := context.Background()
+	author := registerAndLogin(t, handler, "author@example.com")
+	reader := registerAndLogin(t, handler, "reader@example.com")
+	create := func(body string) automodRuleResponse {
+		t.Helper()
+		rec := doRequest(t, handler, "POST", "/admin/automod/rules", "test-admin-token", body)
+		if rec.Code != 201 {
+			t.Fatalf("Expected 201 creating %s, got %d: %s", body, rec.Code, rec.Body.String())
+		}
+		var rule automodRuleResponse
+		json.Unmarshal(rec.Body.Bytes(), &rule)
+		return rule
+	}
+	post := func(body string) (*httptest.ResponseRecorder, Chirp) {
+		t.Helper()
+		var chirp Chirp
+		rec := doRequest(t, handler, "POST", "/api/chirps", author.Token, `{"body":"`+body+`"}`)
+		json.Unmarshal(rec.Body.Bytes(), &chirp)
+		return rec, chirp
+	}
+	hold := create(`{"name":"new accounts posting links","conditions":[{"field":"links","op":"gte","value":1},{"field":"account_age","op":"lt","value":"24h"}],"action":"hold"}`)
+	reject := create(`{"name":"casino spam","conditions":[{"field":"link_domain","op":"in","value":["casino.example"]}],"action":"reject"}`)
+	create(`{"name":"shouting","conditions":[{"field":"content","op":"matches","value":"^[A-Z !]+$"}],"action":"flag"}`)
+	if hold.Version != 1 || !hold.Enabled {
+		t.Fatalf("Expected an enabled version 1, got %+v", hold)
+	}
+	for _, body := range []string{
+		`{"name":"no conditions","conditions":[],"action":"flag"}`,
+		`{"name":"bad regex","conditions":[{"field":"content","op":"matches","value":"(unclosed"}],"action":"flag"}`,
+		`{"name":"bad action","conditions":[{"field":"length","op":"gt","value":1}],"action":"ban"}`,
+		`{"name":"no limit","conditions":[{"field":"length","op":"gt","value":1}],"action":"tighten_rate_limit"}`,
+	} {
+		if rec := doRequest(t, handler, "POST", "/admin/automod/rules", "test-admin-token", body); rec.Code != 400 {
+			t.Fatalf("Expected %s to be refused, got %d", body, rec.Code)
+		}
+	}
+
+	if rec, _ := post("win big at https://www.casino.example/now"); rec.Code != 400 {
+		t.Fatalf("Expected a chirp matching a reject rule to be refused, got %d", rec.Code)
+	}
+	rec, shouted := post("STOP")
+	if rec.Code != 201 {
+		t.Fatalf("Expected a flagged chirp to be posted, got %d", rec.Code)
+	}
+	rec, held := post("look at https://example.com")
+	if rec.Code != 201 {
+		t.Fatalf("Expected a held chirp to be posted, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "GET", "/api/chirps", reader.Token, "")
+	if !strings.Contains(rec.Body.String(), shouted.ID.String()) || strings.Contains(rec.Body.String(), held.ID.String()) {
+		t.Fatalf("Expected the flagged chirp listed and the held one hidden, got %s", rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps/"+held.ID.String(), "", ""); rec.Code != 404 {
+		t.Fatalf("Expected the held chirp hidden, got %d", rec.Code)
+	}
+	var queue []moderationItem
+	rec = doRequest(t, handler, "GET", "/admin/moderation?reason=automod_hold", "test-admin-token", "")
+	json.Unmarshal(rec.Body.Bytes(), &queue)
+	if len(queue) != 1 || queue[0].Chirp.ID != held.ID || !strings.Contains(queue[0].Reports[0].Details, `"new accounts posting links" v1`) {
+		t.Fatalf("Expected the held chirp in the moderation queue, got %s", rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/admin/moderation?reason=automod", "test-admin-token", "")
+	json.Unmarshal(rec.Body.Bytes(), &queue)
+	if len(queue) != 1 || queue[0].Chirp.ID != shouted.ID {
+		t.Fatalf("Expected the flagged chirp in the moderation queue, got %s", rec.Body.String())
+	}
+	if events, _ := cfg.store.ListUnpublishedOutboxEvents(ctx, 10); len(events) != 1 {
+		t.Fatalf("Expected no chirp.created event for the held chirp, got %+v", events)
+	}
+	if rec = doRequest(t, handler, "POST", "/admin/moderation/"+held.ID.String()+"/approve", "test-admin-token", ""); rec.Code != 200 {
+		t.Fatalf("Expected 200 approving the held chirp, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps/"+held.ID.String(), "", ""); rec.Code != 200 {
+		t.Fatalf("Expected the approved chirp visible, got %d", rec.Code)
+	}
+	if events, _ := cfg.store.ListUnpublishedOutboxEvents(ctx, 10); len(events) != 2 || events[1].Event != "chirp.created" {
+		t.Fatalf("Expected the approval to publish a chirp.created event, got %+v", events)
+	}
+
+	// editing makes a new version and restoring an old one another
+	path := "/admin/automod/rules/" + reject.ID.String()
+	rec = doRequest(t, handler, "PUT", path, "test-admin-token", `{"name":"casino spam","enabled":false,"conditions":[{"field":"link_domain","op":"in","value":["casino.example"]}],"action":"reject"}`)
+	var rule automodRuleResponse
+	json.Unmarshal(rec.Body.Bytes(), &rule)
+	if rec.Code != 200 || rule.Version != 2 || rule.Enabled {
+		t.Fatalf("Expected version 2 disabled, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec, _ := post("https://casino.example"); rec.Code != 201 {
+		t.Fatalf("Expected a disabled rule to be skipped, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "POST", path+"/versions/1/restore", "test-admin-token", "")
+	json.Unmarshal(rec.Body.Bytes(), &rule)
+	if rec.Code != 200 || rule.Version != 3 || !rule.Enabled {
+		t.Fatalf("Expected version 1 restored as version 3, got %d %s", rec.Code, rec.Body.String())
+	}
+	var versions []automodRuleVersionResponse
+	rec = doRequest(t, handler, "GET", path+"/versions", "test-admin-token", "")
+	json.Unmarshal(rec.Body.Bytes(), &versions)
+	if len(versions) != 3 || versions[0].Version != 3 || versions[1].Enabled || versions[2].CreatedBy != "admin-token" {
+		t.Fatalf("Expected three versions newest first, got %s", rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "POST", path+"/versions/9/restore", "test-admin-token", ""); rec.Code != 404 {
+		t.Fatalf("Expected 404 restoring a missing version, got %d", rec.Code)
+	}
+
+	// a dry run tries rules without saving or posting anything
+	var dry automodDryRunResponse
+	rec = doRequest(t, handler, "POST", "/admin/automod/dry-run", "test-admin-token", `{"body":"https://a.casino.example","user_id":"`+author.ID.String()+`"}`)
+	json.Unmarshal(rec.Body.Bytes(), &dry)
+	if rec.Code != 200 || dry.Action == nil || *dry.Action != automod.ActionReject || len(dry.Matches) != 2 {
+		t.Fatalf("Expected the saved rules to reject, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "POST", "/admin/automod/dry-run", "test-admin-token", `{"body":"hi","user_id":"`+author.ID.String()+`","rules":[{"name":"burst","conditions":[{"field":"velocity","op":"gte","value":3,"window":"1m"}],"action":"tighten_rate_limit","tighten":{"max_chirps":1,"window":"1h","duration":"1h"}}]}`)
+	json.Unmarshal(rec.Body.Bytes(), &dry)
+	if rec.Code != 200 || dry.Action != nil || dry.Limit == nil || dry.Velocity["1m0s"] != 3 {
+		t.Fatalf("Expected the unsaved rule to limit the author, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "POST", "/admin/automod/dry-run", "test-admin-token", `{"body":"hi","rules":[{"conditions":[],"action":"flag"}]}`); rec.Code != 400 {
+		t.Fatalf("Expected an invalid dry-run rule to be refused, got %d", rec.Code)
+	}
+	if rules, _ := cfg.store.ListAutomodRules(ctx); len(rules) != 3 {
+		t.Fatalf("Expected the dry run to save nothing, got %d rules", len(rules))
+	}
+
+	// a tighten_rate_limit rule lets the chirp through, then limits its author
+	create(`{"name":"burst","conditions":[{"field":"velocity","op":"gte","value":3,"window":"1m"}],"action":"tighten_rate_limit","tighten":{"max_chirps":1,"window":"1h","duration":"1h"}}`)
+	if rec, _ = post("one more"); rec.Code != 201 {
+		t.Fatalf("Expected the chirp that set the limit to be posted, got %d", rec.Code)
+	}
+	rec, _ = post("and another")
+	if rec.Code != 403 || !strings.Contains(rec.Body.String(), `"name":"automod_limit"`) {
+		t.Fatalf("Expected the limit to refuse the next chirp, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "POST", "/api/chirps", reader.Token, `{"body":"unaffected"}`); rec.Code != 201 {
+		t.Fatalf("Expected other users to post as before, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "DELETE", path, "test-admin-token", ""); rec.Code != 204 {
+		t.Fatalf("Expected 204 deleting a rule, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "GET", path+"/versions", "test-admin-token", ""); rec.Code != 404 {
+		t.Fatalf("Expected the versions deleted with the rule, got %d", rec.Code)
+	}
+}
+
+func TestAdminDeleteChirp(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.moderationNotify = []string{notifyAuthor}
+	handler := cfg.routes()
+	author := registerAndLogin(t, handler, "author@example.com")
+	reporter := registerAndLogin(t, handler, "reporter@example.com")
+	var kept, gone Chirp
+	rec := doRequest(t, handler, "POST", "/api/chirps", author.Token, `{"body":"first"}`)
+	json.Unmarshal(rec.Body.Bytes(), &kept)
+	rec = doRequest(t, handler, "POST", "/api/chirps", author.Token, `{"body":"second"}`)
+	json.Unmarshal(rec.Body.Bytes(), &gone)
+	doRequest(t, handler, "POST", "/api/chirps/"+kept.ID.String()+"/reports", reporter.Token, `{"reason":"spam"}`)
+
+	path := "/admin/chirps/" + kept.ID.String()
+	if rec = doRequest(t, handler, "DELETE", path, author.Token, `{"reason":"spam"}`); rec.Code != 401 {
+		t.Fatalf("Expected a user token to be refused, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "DELETE", path, "test-admin-token", `{}`); rec.Code != 400 {
+		t.Fatalf("Expected a removal without a reason to be refused, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "DELETE", path, "test-admin-token", `{"reason":"spam","tombstone":true}`); rec.Code != 204 {
+		t.Fatalf("Expected 204 replacing a chirp with a tombstone, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/api/chirps/"+kept.ID.String(), "", "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"body":"[removed by moderators]"`) {
+		t.Fatalf("Expected the tombstone in place of the chirp, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "GET", "/admin/moderation", "test-admin-token", ""); rec.Body.String() != "[]" {
+		t.Fatalf("Expected the removal to close the open report, got %s", rec.Body.String())
+	}
+
+	if rec = doRequest(t, handler, "DELETE", "/admin/chirps/"+gone.ID.String(), "test-admin-token", `{"reason":"off topic"}`); rec.Code != 204 {
+		t.Fatalf("Expected 204 deleting someone's chirp, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps/"+gone.ID.String(), "", ""); rec.Code != 404 {
+		t.Fatalf("Expected the chirp to be deleted, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "DELETE", "/admin/chirps/"+gone.ID.String(), "test-admin-token", `{"reason":"off topic"}`); rec.Code != 404 {
+		t.Fatalf("Expected 404 removing a missing chirp, got %d", rec.Code)
+	}
+	entries, _ := cfg.store.ListAuditEntries(context.Background(), database.ListAuditEntriesParams{Action: "chirp.remove", Since: time.Unix(0, 0), Until: time.Now().Add(time.Minute), MaxEntries: 10})
+	if len(entries) != 2 || !strings.Contains(entries[0].Details+entries[1].Details, `"reason":"off topic"`) {
+		t.Fatalf("Expected both removals and their reasons in the audit log, got %+v", entries)
+	}
+	// only the chirp that was reported went through a decision, so only its author is emailed
+	jobs, _ := cfg.store.ListJobsByStatus(context.Background(), database.ListJobsByStatusParams{Status: "pending", Limit: 100})
+	emails := 0
+	for _, job := range jobs {
+		if job.Kind == email.JobKind {
+			emails++
+		}
+	}
+	if emails != 1 {
+		t.Fatalf("Expected the author of the reported chirp to be emailed once, got %d emails", emails)
+	}
+}
+
+func TestShadowban(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	troll := registerAndLogin(t, handler, "troll@example.com")
+	other := registerAndLogin(t, handler, "other@example.com")
+	var hidden Chirp
+	rec := doRequest(t, handler, "POST", "/api/chirps", troll.Token, `{"body":"hidden"}`)
+	json.Unmarshal(rec.Body.Bytes(), &hidden)
+	doRequest(t, handler, "POST", "/api/chirps", other.Token, `{"body":"visible"}`)
+	// warm the caches so the shadowban has to drop the chirp from them
+	doRequest(t, handler, "GET", "/api/chirps", "", "")
+	doRequest(t, handler, "GET", "/api/chirps/"+hidden.ID.String(), "", "")
+
+	path := "/admin/users/" + troll.ID.String() + "/shadowban"
+	if rec = doRequest(t, handler, "PUT", "/admin/users/"+uuid.NewString()+"/shadowban", "test-admin-token", `{}`); rec.Code != 404 {
+		t.Fatalf("Expected 404 shadowbanning a missing user, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "PUT", path, "test-admin-token", `{"reason":"spam"}`); rec.Code != 200 {
+		t.Fatalf("Expected 200 shadowbanning a user, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "GET", "/admin/shadowbans", "test-admin-token", ""); !strings.Contains(rec.Body.String(), `"reason":"spam"`) {
+		t.Fatalf("Expected the shadowban in the list, got %s", rec.Body.String())
+	}
+
+	for _, token := range []string{"", other.Token} {
+		if rec = doRequest(t, handler, "GET", "/api/chirps", token, ""); strings.Contains(rec.Body.String(), "hidden") || !strings.Contains(rec.Body.String(), "visible") {
+			t.Fatalf("Expected the timeline without the shadowbanned chirp, got %s", rec.Body.String())
+		}
+		if rec = doRequest(t, handler, "GET", "/api/chirps?author_id="+troll.ID.String(), token, ""); strings.Contains(rec.Body.String(), "hidden") {
+			t.Fatalf("Expected the author timeline without the shadowbanned chirp, got %s", rec.Body.String())
+		}
+		if rec = doRequest(t, handler, "GET", "/api/chirps/"+hidden.ID.String(), token, ""); rec.Code != 404 {
+			t.Fatalf("Expected 404 reading the shadowbanned chirp, got %d", rec.Code)
+		}
+	}
+	if rec = doRequest(t, handler, "POST", "/api/graphql", other.Token, `{"query":"{ chirps { edges { node { body } } } }"}`); strings.Contains(rec.Body.String(), "hidden") {
+		t.Fatalf("Expected GraphQL without the shadowbanned chirp, got %s", rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps", troll.Token, ""); !strings.Contains(rec.Body.String(), "hidden") {
+		t.Fatalf("Expected the shadowbanned user to still see their chirp, got %s", rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps/"+hidden.ID.String(), troll.Token, ""); rec.Code != 200 {
+		t.Fatalf("Expected the shadowbanned user to read their chirp, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps", "not-a-token", ""); rec.Code != 401 {
+		t.Fatalf("Expected an invalid token to be refused, got %d", rec.Code)
+	}
+
+	// their events only reach their own webhooks
+	for _, user := range []User{troll, other} {
+		doRequest(t, handler, "POST", "/api/webhooks", user.Token, `{"url":"https://example.com/hook","events":["chirp.created"]}`)
+	}
+	webhooks, _ := cfg.store.ListWebhooksForEvent(context.Background(), database.ListWebhooksForEventParams{Event: "chirp.created", ActorID: troll.ID})
+	if len(webhooks) != 1 || webhooks[0].UserID != troll.ID {
+		t.Fatalf("Expected only the shadowbanned user's webhook, got %+v", webhooks)
+	}
+	if webhooks, _ = cfg.store.ListWebhooksForEvent(context.Background(), database.ListWebhooksForEventParams{Event: "chirp.created", ActorID: other.ID}); len(webhooks) != 2 {
+		t.Fatalf("Expected everyone's webhooks for other users' events, got %+v", webhooks)
+	}
+
+	if rec = doRequest(t, handler, "DELETE", path, "test-admin-token", ""); rec.Code != 204 {
+		t.Fatalf("Expected 204 lifting a shadowban, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "DELETE", path, "test-admin-token", ""); rec.Code != 404 {
+		t.Fatalf("Expected 404 lifting a missing shadowban, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "GET", "/api/chirps", "", ""); !strings.Contains(rec.Body.String(), "hidden") {
+		t.Fatalf("Expected the chirp back once the shadowban is lifted, got %s", rec.Body.String())
+	}
+	entries, _ := cfg.store.ListAuditEntries(context.Background(), database.ListAuditEntriesParams{Action: "shadowban.set", Since: time.Unix(0, 0), Until: time.Now().Add(time.Minute), MaxEntries: 10})
+	if len(entries) != 1 {
+		t.Fatalf("Expected the shadowban in the audit log, got %+v", entries)
+	}
+}
+
+func TestIPBans(t *testing.T) {
+	t.Setenv("RATE_LIMIT_RPS", "0.001")
+	t.Setenv("RATE_LIMIT_BURST", "1")
+	t.Setenv("RATE_LIMIT_BAN_AFTER", "2")
+	cfg := newTestConfig()
+	settings, err := loadRuntimeSettings(nil, cfg.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.settings.Store(settings)
+	handler := cfg.middlewareIPFilter(cfg.middlewareRateLimit(cfg.routes()))
+	from := func(ip, method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.RemoteAddr = net.JoinHostPort(ip, "40000")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var resp apiErrorResponse
+	rec := from("192.0.2.1", "POST", "/admin/ip-bans", cfg.adminToken, `{"cidr":"192.0.2.0/24"}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 400 || resp.Error.Code != "ip_lockout" {
+		t.Fatalf("Expected a ban covering the admin making it to be refused, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = from("192.0.2.1", "POST", "/admin/ip-bans", cfg.adminToken, `{"cidr":"not-an-ip"}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 400 || resp.Error.Code != "invalid_ip_range" {
+		t.Fatalf("Expected 400 for an invalid range, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = from("192.0.2.1", "POST", "/admin/ip-bans", cfg.adminToken, `{"cidr":"198.51.100.0/24","duration":"-1h"}`); rec.Code != 400 {
+		t.Fatalf("Expected 400 for a negative duration, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = from("192.0.2.1", "POST", "/admin/ip-bans", cfg.adminToken, `{"cidr":"198.51.100.9/24","reason":"spam","duration":"1h"}`)
+	var ban ipBanResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &ban); err != nil || rec.Code != 201 || ban.CIDR != "198.51.100.0/24" || ban.ExpiresAt == nil {
+		t.Fatalf("Expected the normalized range banned for an hour, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = from("192.0.2.1", "POST", "/admin/ip-bans", cfg.adminToken, `{"cidr":"198.51.100.0/24"}`); rec.Code != 409 {
+		t.Fatalf("Expected 409 banning a banned range again, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = from("198.51.100.7", "GET", "/api/chirps", "", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != 403 || resp.Error.Code != "ip_banned" {
+		t.Fatalf("Expected a banned source to get 403 with Retry-After, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = from("192.0.2.1", "DELETE", "/admin/ip-bans/"+ban.ID.String(), cfg.adminToken, ""); rec.Code != 204 {
+		t.Fatalf("Expected 204 lifting the ban, got %d", rec.Code)
+	}
+	if rec = from("198.51.100.7", "GET", "/api/chirps", "", ""); rec.Code != 200 {
+		t.Fatalf("Expected the source let back in, got %d", rec.Code)
+	}
+	if rec = from("192.0.2.1", "DELETE", "/admin/ip-bans/"+ban.ID.String(), cfg.adminToken, ""); rec.Code != 404 {
+		t.Fatalf("Expected 404 lifting a lifted ban, got %d", rec.Code)
+	}
+
+	// the burst is used up, the next two requests are refused and the second bans
+	for i, want := range []int{200, 429, 429, 403} {
+		if rec = from("203.0.113.5", "GET", "/api/chirps", "", ""); rec.Code != want {
+			t.Fatalf("Expected request %d to get %d, got %d %s", i, want, rec.Code, rec.Body.String())
+		}
+	}
+	// a banned admin can still lift the ban
+	rec = from("203.0.113.5", "GET", "/admin/ip-bans", cfg.adminToken, "")
+	var bans []ipBanResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &bans); err != nil || len(bans) != 1 || bans[0].CIDR != "203.0.113.5/32" || bans[0].CreatedBy != rateLimitBanActor {
+		t.Fatalf("Expected the rate limiter's ban listed, got %d %s", rec.Code, rec.Body.String())
+	}
+	entries, _ := cfg.store.ListAuditEntries(context.Background(), database.ListAuditEntriesParams{Action: "ip_ban.create", Since: time.Unix(0, 0), Until: time.Now().Add(time.Minute), MaxEntries: 10})
+	if len(entries) != 2 {
+		t.Fatalf("Expected both bans in the audit log, got %+v", entries)
+	}
+}
+
+func TestReplicasShareState(t *testing.T) {
+	t.Setenv("RATE_LIMIT_BACKEND", "database")
+	t.Setenv("RATE_LIMIT_RPS", "1")
+	t.Setenv("RATE_LIMIT_BURST", "2")
+	first, second := newTestConfig(), newTestConfig()
+	second.store = first.store
+	for _, cfg := range []*apiConfig{first, second} {
+		settings, err := loadRuntimeSettings(nil, first.store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.settings.Store(settings)
+	}
+	firstHandler := first.middlewareMaintenance(first.routes())
+	secondHandler := second.middlewareMaintenance(second.routes())
+
+	// a token from one replica works on the other, which never saw the login
+	user := registerAndLogin(t, firstHandler, "replica@example.com")
+	rec := doRequest(t, secondHandler, "PUT", "/api/users", user.Token, `{"email":"replica2@example.com","password":"hunter2"}`)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 updating the user on another replica, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	doRequest(t, firstHandler, "PUT", "/admin/maintenance", first.adminToken, `{}`)
+	if _, err := second.syncMaintenance(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if rec = doRequest(t, secondHandler, "GET", "/api/chirps", "", ""); rec.Code != 503 {
+		t.Fatalf("Expected maintenance mode on the other replica, got %d", rec.Code)
+	}
+	doRequest(t, secondHandler, "DELETE", "/admin/maintenance", second.adminToken, "")
+	if _, err := first.syncMaintenance(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if first.maintenance.Load() != nil {
+		t.Fatal("Expected maintenance mode to be off on both replicas")
+	}
+
+	limited := []http.Handler{first.middlewareRateLimit(firstHandler), second.middlewareRateLimit(secondHandler)}
+	doRequest(t, limited[0], "GET", "/api/chirps", "", "")
+	doRequest(t, limited[1], "GET", "/api/chirps", "", "")
+	if rec = doRequest(t, limited[0], "GET", "/api/chirps", "", ""); rec.Code != 429 {
+		t.Fatalf("Expected the burst to be shared between replicas, got %d", rec.Code)
+	}
+}
+
+// Sends every request to handler as if it named the tenant slug
+func inTenant(handler http.Handler, slug string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set(tenantHeader, slug)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func TestTenantsAreIsolated(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.middlewareTenant(cfg.routes())
+	rec := doRequest(t, handler, "POST", "/admin/tenants", cfg.adminToken, `{"slug":"acme","name":"Acme","max_users":1}`)
+	var tenant tenantResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &tenant); err != nil || rec.Code != 201 || tenant.AdminToken == "" {
+		t.Fatalf("Expected 201 with an admin token creating a tenant, got %d %s", rec.Code, rec.Body.String())
+	}
+	acme := inTenant(handler, "acme")
+
+	walt := registerAndLogin(t, handler, "walt@example.com")
+	acmeWalt := registerAndLogin(t, acme, "walt@example.com")
+	rec = doRequest(t, acme, "POST", "/api/users", "", `{"email":"jesse@example.com","password":"hunter2"}`)
+	var errResp apiErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || rec.Code != 403 || errResp.Error.Code != "tenant_user_limit" {
+		t.Fatalf("Expected max_users to stop a second registration, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "POST", "/api/chirps", walt.Token, `{"body":"default tenant"}`)
+	var chirp Chirp
+	if err := json.Unmarshal(rec.Body.Bytes(), &chirp); err != nil || rec.Code != 201 {
+		t.Fatalf("Expected 201 creating chirp, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, acme, "GET", "/api/chirps", "", "")
+	var chirps []Chirp
+	if err := json.Unmarshal(rec.Body.Bytes(), &chirps); err != nil || len(chirps) != 0 {
+		t.Fatalf("Expected no chirps in another tenant, got %s", rec.Body.String())
+	}
+	if rec = doRequest(t, acme, "GET", "/api/chirps/"+chirp.ID.String(), "", ""); rec.Code != 404 {
+		t.Fatalf("Expected another tenant's chirp to be missing, got %d", rec.Code)
+	}
+	if rec = doRequest(t, acme, "POST", "/api/chirps", walt.Token, `{"body":"wrong tenant"}`); rec.Code != 403 {
+		t.Fatalf("Expected 403 chirping in another tenant, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, acme, "POST", "/api/chirps", acmeWalt.Token, `{"body":"acme tenant"}`); rec.Code != 201 {
+		t.Fatalf("Expected 201 chirping in the user's tenant, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(t, acme, "GET", "/admin/tenant", tenant.AdminToken, "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"users":1`) {
+		t.Fatalf("Expected the tenant admin to see usage, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "GET", "/admin/tenant", tenant.AdminToken, ""); rec.Code != 401 {
+		t.Fatalf("Expected a tenant admin token to be rejected in another tenant, got %d", rec.Code)
+	}
+	if rec = doRequest(t, handler, "GET", "/admin/tenants", tenant.AdminToken, ""); rec.Code != 401 {
+		t.Fatalf("Expected a tenant admin token to be rejected on operator routes, got %d", rec.Code)
+	}
+	rec = doRequest(t, inTenant(handler, "nope"), "GET", "/api/chirps", "", "")
+	if err := json.Unmarshal(rec.Body.Bytes(), &errResp); err != nil || rec.Code != 404 || errResp.Error.Code != "unknown_tenant" {
+		t.Fatalf("Expected 404 unknown_tenant, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+// A Mastodon-like server follows a user, receives their chirps as Notes and unfollows
+func TestActivityPub(t *testing.T) {
+	publicPEM, privatePEM, err := activitypub.GenerateKey()
+	if err != nil {
+		t.Fatalf("Expected no error generating the remote key, got %v", err)
+	}
+	block, _ := pem.Decode([]byte(privatePEM))
+	parsed, _ := x509.ParsePKCS8PrivateKey(block.Bytes)
+	remoteKey := parsed.(*rsa.PrivateKey)
+	delivered := make(chan activitypub.Incoming, 10)
+	var remote *httptest.Server
+	remote = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/alice":
+			json.NewEncoder(w).Encode(activitypub.Actor{
+				ID:        remote.URL + "/users/alice",
+				Type:      "Person",
+				Inbox:     remote.URL + "/users/alice/inbox",
+				PublicKey: activitypub.PublicKey{ID: remote.URL + "/users/alice#main-key", Owner: remote.URL + "/users/alice", PublicKeyPem: publicPEM},
+			})
+		case "/users/alice/inbox":
+			if r.Header.Get("Signature") == "" {
+				t.Error("Expected deliveries to be signed")
+			}
+			var activity activitypub.Incoming
+			json.NewDecoder(r.Body).Decode(&activity)
+			delivered <- activity
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer remote.Close()
+
+	cfg := newTestConfig()
+	cfg.federation, err = activitypub.New(cfg.store, cfg.jobs, activitypub.Options{BaseURL: "https://chirpy.example", Timeout: time.Second, MaxAttempts: 3, AllowPrivate: true})
+	if err != nil {
+		t.Fatalf("Expected no error configuring federation, got %v", err)
+	}
+	handler := cfg.routes()
+	user := registerAndLogin(t, handler, "fediverse@example.com")
+	actorID := "https://chirpy.example/ap/users/" + user.ID.String()
+	ctx := context.Background()
+	// sends an activity from alice, signed with her key unless unsigned
+	send := func(activity string, signed bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", actorID+"/inbox", strings.NewReader(activity))
+		req.Header.Set("Content-Type", activitypub.ContentType)
+		if signed {
+			err := activitypub.SignRequest(req, []byte(activity), remote.URL+"/users/alice#main-key", remoteKey, time.Now())
+			if err != nil {
+				t.Fatalf("Expected no error signing, got %v", err)
+			}
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	runDelivery := func() activitypub.Incoming {
+		t.Helper()
+		ran, err := cfg.jobs.RunOnce(ctx)
+		if !ran || err != nil {
+			t.Fatalf("Expected a delivery job to run, got ran=%v err=%v", ran, err)
+		}
+		select {
+		case activity := <-delivered:
+			return activity
+		default:
+			t.Fatal("Expected an activity in alice's inbox")
+			return activitypub.Incoming{}
+		}
+	}
+
+	rec := doRequest(t, handler, "GET", "/.well-known/webfinger?resource=acct:"+user.ID.String()+"@chirpy.example", "", "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"href":"`+actorID+`"`) {
+		t.Fatalf("Expected WebFinger to point at the actor, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/.well-known/webfinger?resource=acct:"+uuid.NewString()+"@chirpy.example", "", "")
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404 for an unknown account, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "GET", "/ap/users/"+user.ID.String(), "", "")
+	var actor activitypub.Actor
+	json.Unmarshal(rec.Body.Bytes(), &actor)
+	if rec.Code != 200 || rec.Header().Get("Content-Type") != activitypub.ContentType || actor.ID != actorID || actor.PublicKey.PublicKeyPem == "" {
+		t.Fatalf("Expected the actor with its key, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	follow := `{"@context":"https://www.w3.org/ns/activitystreams","id":"` + remote.URL + `/follows/1","type":"Follow","actor":"` + remote.URL + `/users/alice","object":"` + actorID + `"}`
+	if rec := send(follow, false); rec.Code != 401 {
+		t.Fatalf("Expected 401 for an unsigned Follow, got %d", rec.Code)
+	}
+	spoofed := strings.Replace(follow, remote.URL+"/users/alice", remote.URL+"/users/bob", 1)
+	if rec := send(spoofed, true); rec.Code != 401 {
+		t.Fatalf("Expected 401 for an activity of someone else than the signer, got %d", rec.Code)
+	}
+	if rec := send(follow, true); rec.Code != 202 {
+		t.Fatalf("Expected 202 for a signed Follow, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if accept := runDelivery(); accept.Type != "Accept" || accept.ObjectID() != remote.URL+"/follows/1" {
+		t.Fatalf("Expected the Follow to be accepted, got %+v", accept)
+	}
+	rec = doRequest(t, handler, "GET", "/ap/users/"+user.ID.String()+"/followers", "", "")
+	if !strings.Contains(rec.Body.String(), `"totalItems":1`) {
+		t.Fatalf("Expected one follower, got %s", rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "POST", "/api/chirps", user.Token, `{"body":"hello <fediverse>"}`)
+	var chirp Chirp
+	json.Unmarshal(rec.Body.Bytes(), &chirp)
+	if _, err := cfg.relayOutbox(ctx); err != nil {
+		t.Fatalf("Expected the chirp.created event to be relayed, got %v", err)
+	}
+	create := runDelivery()
+	var note activitypub.Note
+	json.Unmarshal(create.Object, &note)
+	if create.Type != "Create" || note.ID != "https://chirpy.example/ap/chirps/"+chirp.ID.String() || note.Content != "<p>hello &lt;fediverse&gt;</p>" {
+		t.Fatalf("Expected the chirp delivered as a Note, got %+v", create)
+	}
+	rec = doRequest(t, handler, "GET", "/ap/chirps/"+chirp.ID.String(), "", "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"attributedTo":"`+actorID+`"`) {
+		t.Fatalf("Expected the Note, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/ap/users/"+user.ID.String()+"/outbox", "", "")
+	if !strings.Contains(rec.Body.String(), `"totalItems":1`) || !strings.Contains(rec.Body.String(), note.ID) {
+		t.Fatalf("Expected the chirp in the outbox, got %s", rec.Body.String())
+	}
+
+	doRequest(t, handler, "DELETE", "/api/chirps/"+chirp.ID.String(), user.Token, "")
+	if _, err := cfg.relayOutbox(ctx); err != nil {
+		t.Fatalf("Expected the chirp.deleted event to be relayed, got %v", err)
+	}
+	if deleted := runDelivery(); deleted.Type != "Delete" || deleted.ObjectID() != note.ID {
+		t.Fatalf("Expected the Note to be deleted, got %+v", deleted)
+	}
+
+	undo := `{"id":"` + remote.URL + `/follows/1/undo","type":"Undo","actor":"` + remote.URL + `/users/alice","object":` + follow + `}`
+	if rec := send(undo, true); rec.Code != 202 {
+		t.Fatalf("Expected 202 for a signed Undo, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/ap/users/"+user.ID.String()+"/followers", "", "")
+	if !strings.Contains(rec.Body.String(), `"totalItems":0`) {
+		t.Fatalf("Expected no followers after the Undo, got %s", rec.Body.String())
+	}
+
+	rec = doRequest(t, newTestConfig().routes(), "GET", "/ap/users/"+user.ID.String(), "", "")
+	if rec.Code != 404 {
+		t.Fatalf("Expected 404 without ACTIVITYPUB_URL, got %d", rec.Code)
+	}
+}
+
+func TestMastodonAPI(t *testing.T) {
+	handler := newTestConfig().routes()
+	user := registerAndLogin(t, handler, "mastodon@example.com")
+	form := func(method, path, token string, values url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(values.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := form("POST", "/api/v1/apps", "", url.Values{"client_name": {"Tooter"}, "redirect_uris": {"tooter://callback"}, "scopes": {"read write"}})
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 registering the app, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var app mastodonApp
+	json.Unmarshal(rec.Body.Bytes(), &app)
+	if app.ClientID == "" || app.ClientSecret == "" {
+		t.Fatalf("Expected client credentials, got %+v", app)
+	}
+	rec = form("POST", "/api/v1/apps", "", url.Values{"client_name": {"Evil"}, "redirect_uris": {"javascript:alert(1)"}})
+	if rec.Code != 422 {
+		t.Errorf("Expected 422 for a javascript: redirect URI, got %d", rec.Code)
+	}
+
+	authorize := url.Values{"response_type": {"code"}, "client_id": {app.ClientID}, "redirect_uri": {"tooter://callback"}, "scope": {"read write"}, "state": {"xyz"}}
+	rec = doRequest(t, handler, "GET", "/oauth/authorize?"+authorize.Encode(), "", "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `name="password"`) {
+		t.Fatalf("Expected the sign-in form, got %d: %s", rec.Code, rec.Body.String())
+	}
+	authorize.Set("redirect_uri", "https://attacker.example/")
+	rec = doRequest(t, handler, "GET", "/oauth/authorize?"+authorize.Encode(), "", "")
+	if rec.Code != 400 {
+		t.Errorf("Expected 400 for an unregistered redirect URI, got %d", rec.Code)
+	}
+	authorize.Set("redirect_uri", "tooter://callback")
+	authorize.Set("email", "mastodon@example.com")
+	authorize.Set("password", "wrong")
+	rec = form("POST", "/oauth/authorize", "", authorize)
+	if rec.Code != 401 {
+		t.Errorf("Expected 401 for a wrong password, got %d", rec.Code)
+	}
+	authorize.Set("password", "hunter2")
+	rec = form("POST", "/oauth/authorize", "", authorize)
+	if rec.Code != 302 {
+		t.Fatalf("Expected a redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location, _ := url.Parse(rec.Header().Get("Location"))
+	if location.Scheme != "tooter" || location.Query().Get("state") != "xyz" {
+		t.Errorf("Expected a redirect to the client with its state, got %s", location)
+	}
+
+	exchange := url.Values{"grant_type": {"authorization_code"}, "client_id": {app.ClientID}, "client_secret": {app.ClientSecret}, "redirect_uri": {"tooter://callback"}, "code": {location.Query().Get("code")}}
+	rec = form("POST", "/oauth/token", "", exchange)
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 exchanging the code, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var tokenResp struct {
+		AccessToken string `json:"access_token"`
+		Scope       string `json:"scope"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &tokenResp)
+	if tokenResp.AccessToken == "" || tokenResp.Scope != "read write" {
+		t.Errorf("Expected an access token with the read and write scopes, got %+v", tokenResp)
+	}
+	rec = form("POST", "/oauth/token", "", exchange)
+	if rec.Code != 400 {
+		t.Errorf("Expected a code to work only once, got %d", rec.Code)
+	}
+	token := tokenResp.AccessToken
+	rec = doRequest(t, handler, "POST", "/api/refresh", token, "")
+	if rec.Code != 401 {
+		t.Errorf("Expected an OAuth token not to work as a refresh token, got %d", rec.Code)
+	}
+
+	rec = form("POST", "/oauth/token", "", url.Values{"grant_type": {"password"}, "client_id": {app.ClientID}, "client_secret": {app.ClientSecret}, "username": {"mastodon@example.com"}, "password": {"hunter2"}, "scope": {"read:accounts"}})
+	var readOnly struct {
+		AccessToken string `json:"access_token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &readOnly)
+	rec = doRequest(t, handler, "GET", "/api/v1/accounts/verify_credentials", readOnly.AccessToken, "")
+	if rec.Code != 200 {
+		t.Errorf("Expected a read:accounts token to read the account, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/api/v1/timelines/home", readOnly.AccessToken, "")
+	if rec.Code != 403 {
+		t.Errorf("Expected 403 reading statuses with a read:accounts token, got %d", rec.Code)
+	}
+	rec = form("POST", "/api/v1/statuses", readOnly.AccessToken, url.Values{"status": {"not allowed"}})
+	if rec.Code != 403 {
+		t.Errorf("Expected 403 posting with a read-only token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = doRequest(t, handler, "GET", "/api/v1/accounts/verify_credentials", token, "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"id":"`+user.ID.String()+`"`) {
+		t.Fatalf("Expected the account, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/api/v1/accounts/verify_credentials", "", "")
+	if rec.Code != 401 {
+		t.Errorf("Expected 401 without a token, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/statuses", strings.NewReader(`{"status":"fish & <chips>","visibility":"private"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 422 || !strings.Contains(rec.Body.String(), "only public statuses") {
+		t.Errorf("Expected 422 for a private status, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var statuses []mastodonStatus
+	for _, body := range []string{"first", "second", "fish & <chips>"} {
+		rec = form("POST", "/api/v1/statuses", token, url.Values{"status": {body}})
+		if rec.Code != 200 {
+			t.Fatalf("Expected 200 posting a status, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var status mastodonStatus
+		json.Unmarshal(rec.Body.Bytes(), &status)
+		statuses = append(statuses, status)
+	}
+	if statuses[2].Content != "<p>fish &amp; &lt;chips&gt;</p>" {
+		t.Errorf("Expected escaped content, got %q", statuses[2].Content)
+	}
+
+	rec = doRequest(t, handler, "GET", "/api/v1/timelines/public?limit=2", "", "")
+	var page []mastodonStatus
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if len(page) != 2 || page[0].ID != statuses[2].ID || !strings.Contains(rec.Header().Get("Link"), "max_id="+page[1].ID) {
+		t.Fatalf("Expected the newest two statuses and a next link, got %d %v %s", len(page), page, rec.Header().Get("Link"))
+	}
+	rec = doRequest(t, handler, "GET", "/api/v1/timelines/public?limit=2&max_id="+page[1].ID, "", "")
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if len(page) != 1 || page[0].ID != statuses[0].ID {
+		t.Errorf("Expected the oldest status on the next page, got %v", page)
+	}
+	rec = doRequest(t, handler, "GET", "/api/v1/timelines/home?min_id="+statuses[0].ID, token, "")
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if len(page) != 2 || page[0].ID != statuses[2].ID {
+		t.Errorf("Expected the statuses newer than the first, got %v", page)
+	}
+
+	rec = doRequest(t, handler, "POST", "/api/v1/statuses/"+statuses[0].ID+"/favourite", token, "")
+	var status mastodonStatus
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if rec.Code != 200 || !status.Favourited || status.FavouritesCount != 1 {
+		t.Fatalf("Expected the status to be favourited, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/api/v1/favourites", token, "")
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if len(page) != 1 || page[0].ID != statuses[0].ID {
+		t.Errorf("Expected the favourited status, got %v", page)
+	}
+	rec = doRequest(t, handler, "GET", "/api/v1/statuses/"+statuses[0].ID, "", "")
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if status.Favourited || status.FavouritesCount != 1 {
+		t.Errorf("Expected an anonymous read to see the count only, got %+v", status)
+	}
+	doRequest(t, handler, "POST", "/api/v1/statuses/"+statuses[0].ID+"/unfavourite", token, "")
+	rec = doRequest(t, handler, "GET", "/api/v1/favourites", token, "")
+	if strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("Expected no favourites after unfavouriting, got %s", rec.Body.String())
+	}
+
+	other := registerAndLogin(t, handler, "other@example.com")
+	rec = doRequest(t, handler, "DELETE", "/api/v1/statuses/"+statuses[1].ID, other.Token, "")
+	if rec.Code != 403 {
+		t.Errorf("Expected 403 deleting another user's status, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "DELETE", "/api/v1/statuses/"+statuses[1].ID, token, "")
+	json.Unmarshal(rec.Body.Bytes(), &status)
+	if rec.Code != 200 || status.Text == nil || *status.Text != "second" {
+		t.Errorf("Expected the deleted status with its text, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "GET", "/api/v1/statuses/"+statuses[1].ID, "", "")
+	if rec.Code != 404 {
+		t.Errorf("Expected 404 for a deleted status, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "GET", "/api/v1/accounts/"+user.ID.String()+"/statuses", "", "")
+	json.Unmarshal(rec.Body.Bytes(), &page)
+	if len(page) != 2 || page[0].Account.StatusesCount != 2 {
+		t.Errorf("Expected the user's two remaining statuses, got %v", page)
+	}
+
+	rec = form("POST", "/oauth/revoke", "", url.Values{"client_id": {app.ClientID}, "client_secret": {app.ClientSecret}, "token": {token}})
+	if rec.Code != 200 {
+		t.Fatalf("Expected 200 revoking the token, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "GET", "/api/v1/accounts/verify_credentials", token, "")
+	if rec.Code != 401 {
+		t.Errorf("Expected a revoked token to be refused, got %d", rec.Code)
+	}
+}
+
+func TestTwitterImport(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	walt := registerAndLogin(t, handler, "walt@example.com")
+	jesse := registerAndLogin(t, handler, "jesse@example.com")
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	f, _ := zw.Create("data/tweets.js")
+	f.Write([]byte(`window.YTD.tweets.part0 = [
+		{"tweet": {"id_str": "1", "created_at": "Mon Mar 04 09:30:00 +0000 2019", "full_text": "first tweet https://t.co/x",
+			"entities": {"urls": [{"url": "https://t.co/x", "expanded_url": "https://example.com"}]}}},
+		{"tweet": {"id_str": "2", "created_at": "Tue Mar 05 09:30:00 +0000 2019", "full_text": "@jesse a reply", "in_reply_to_status_id_str": "7"}},
+		{"tweet": {"id_str": "3", "created_at": "Wed Mar 06 09:30:00 +0000 2019", "full_text": "RT @jesse: someone else's"}},
+		{"tweet": {"id_str": "4", "created_at": "Thu Mar 07 09:30:00 +0000 2019", "full_text": "` + strings.Repeat("long ", 40) + `"}}
+	]`))
+	zw.Close()
+
+	rec := doRequest(t, handler, "POST", "/api/import/twitter", walt.Token, "not a zip")
+	if rec.Code != 400 {
+		t.Fatalf("Expected a body that is not a zip to be refused, got %d %s", rec.Code, rec.Body.String())
+	}
+	rec = doRequest(t, handler, "POST", "/api/import/twitter", walt.Token, archive.String())
+	if rec.Code != 202 || !strings.HasPrefix(rec.Header().Get("Location"), "/api/import/twitter/") {
+		t.Fatalf("Expected 202 with the import location, got %d %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "first tweet") {
+		t.Errorf("Expected the tweets to be left out of the job, got %s", rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	jobID := uuid.MustParse(path.Base(location))
+	stored, _ := cfg.store.GetJob(context.Background(), jobID)
+	if strings.Contains(stored.Payload, "first tweet") {
+		t.Errorf("Expected the tweets to be stored apart from the job payload, got %s", stored.Payload)
+	}
+	if ran, err := cfg.jobs.RunOnce(context.Background()); !ran || err != nil {
+		t.Fatalf("Expected the import job to run, got ran=%v err=%v", ran, err)
+	}
+	_, err := cfg.store.GetTwitterImportBatch(context.Background(), database.GetTwitterImportBatchParams{JobID: jobID, Seq: 0})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the batches to be deleted once the import is done, got %v", err)
+	}
+	var job struct {
+		Status   string                `json:"status"`
+		Progress twitterImportProgress `json:"progress"`
+	}
+	rec = doRequest(t, handler, "GET", location, walt.Token, "")
+	json.Unmarshal(rec.Body.Bytes(), &job)
+	if job.Status != "done" || job.Progress != (twitterImportProgress{Total: 2, Imported: 1, Skipped: 1, Next: 2}) {
+		t.Fatalf("Expected one tweet imported and the long one skipped, got %s", rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "GET", location, jesse.Token, ""); rec.Code != 404 {
+		t.Errorf("Expected another user's import to be hidden, got %d", rec.Code)
+	}
+	chirps, _ := cfg.store.GetChirpsByUserIds(context.Background(), database.GetChirpsByUserIdsParams{TenantID: uuid.Nil, Ids: walt.ID.String()})
+	if len(chirps) != 1 || chirps[0].Body != "first tweet https://example.com" || !chirps[0].CreatedAt.Equal(time.Date(2019, 3, 4, 9, 30, 0, 0, time.UTC)) {
+		t.Fatalf("Expected the tweet as a chirp with its original timestamp, got %+v", chirps)
+	}
+
+	// importing again, now as a form and with replies, adds only the reply
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	part, _ := mw.CreateFormFile("archive", "twitter.zip")
+	part.Write(archive.Bytes())
+	mw.Close()
+	req := httptest.NewRequest("POST", "/api/import/twitter?include_replies=true", &form)
+	req.Header.Set("Authorization", "Bearer "+walt.Token)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != 202 {
+		t.Fatalf("Expected 202 importing a form, got %d %s", rec.Code, rec.Body.String())
+	}
+	cfg.jobs.RunOnce(context.Background())
+	rec = doRequest(t, handler, "GET", rec.Header().Get("Location"), walt.Token, "")
+	json.Unmarshal(rec.Body.Bytes(), &job)
+	if job.Progress != (twitterImportProgress{Total: 3, Imported: 1, Duplicates: 1, Skipped: 1, Next: 3}) {
+		t.Fatalf("Expected the earlier tweet to be a duplicate, got %s", rec.Body.String())
+	}
+
+	// an archive of several batches is imported a batch at a time
+	var tweets []string
+	for i := range twitterImportBatchSize*2 + 50 {
+		tweets = append(tweets, fmt.Sprintf(`{"tweet": {"id_str": "%d", "created_at": "Fri Jan 01 00:00:00 +0000 2021", "full_text": "tweet %d"}}`, 100+i, i))
+	}
+	archive.Reset()
+	zw = zip.NewWriter(&archive)
+	f, _ = zw.Create("data/tweets.js")
+	f.Write([]byte("window.YTD.tweets.part0 = [" + strings.Join(tweets, ",") + "]"))
+	zw.Close()
+	rec = doRequest(t, handler, "POST", "/api/import/twitter", jesse.Token, archive.String())
+	location = rec.Header().Get("Location")
+	cfg.jobs.RunOnce(context.Background())
+	rec = doRequest(t, handler, "GET", location, jesse.Token, "")
+	json.Unmarshal(rec.Body.Bytes(), &job)
+	if job.Progress != (twitterImportProgress{Total: len(tweets), Imported: len(tweets), Next: len(tweets)}) {
+		t.Fatalf("Expected every batch to be imported, got %s", rec.Body.String())
+	}
+}
+
+func TestSitemaps(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	walt := registerAndLogin(t, handler, "walt@example.com")
+	troll := registerAndLogin(t, handler, "troll@example.com")
+	var chirps [2]Chirp
+	for i := range chirps {
+		rec := doRequest(t, handler, "POST", "/api/chirps", walt.Token, fmt.Sprintf(`{"body":"public %d"}`, i))
+		json.Unmarshal(rec.Body.Bytes(), &chirps[i])
+	}
+	var hidden Chirp
+	rec := doRequest(t, handler, "POST", "/api/chirps", troll.Token, `{"body":"hidden"}`)
+	json.Unmarshal(rec.Body.Bytes(), &hidden)
+	doRequest(t, handler, "PUT", "/admin/users/"+troll.ID.String()+"/shadowban", "test-admin-token", `{}`)
+
+	rec = doRequest(t, handler, "GET", "/sitemap.xml", "", "")
+	if rec.Code != 200 || strings.Contains(rec.Body.String(), "/sitemaps/") {
+		t.Fatalf("Expected an empty index before the first refresh, got %d %s", rec.Code, rec.Body.String())
+	}
+	// refreshes look back a little past the previous one, so run this one later
+	refreshedAt := time.Now().Add(2 * sitemapRefreshOverlap)
+	n, err := cfg.refreshSitemaps(context.Background(), refreshedAt)
+	if err != nil || n != 2 {
+		t.Fatalf("Expected the chirps and users days to be refreshed, got %d %v", n, err)
+	}
+	if n, _ = cfg.refreshSitemaps(context.Background(), refreshedAt); n != 0 {
+		t.Errorf("Expected nothing to refresh when nothing changed, got %d", n)
+	}
+
+	day := time.Now().UTC().Format(time.DateOnly)
+	cfg.sitemapURLsPerFile = 1
+	rec = doRequest(t, handler, "GET", "/sitemap.xml", "", "")
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/xml") {
+		t.Errorf("Expected an XML sitemap index, got %q", ct)
+	}
+	for _, file := range []string{"chirps-" + day + ".xml", "chirps-" + day + "-2.xml", "users-" + day + ".xml"} {
+		if !strings.Contains(rec.Body.String(), "http://example.com/sitemaps/"+file) {
+			t.Errorf("Expected %s in the index, got %s", file, rec.Body.String())
+		}
+	}
+	if strings.Contains(rec.Body.String(), "chirps-"+day+"-3.xml") {
+		t.Errorf("Expected the shadowbanned user's chirp not to be counted, got %s", rec.Body.String())
+	}
+
+	cfg.sitemapURLsPerFile = 0
+	rec = doRequest(t, handler, "GET", "/sitemaps/chirps-"+day+".xml", "", "")
+	body := rec.Body.String()
+	if rec.Code != 200 || !strings.Contains(body, "<loc>http://example.com/app/chirps/"+chirps[0].ID.String()+"</loc>") || !strings.Contains(body, chirps[1].ID.String()) {
+		t.Fatalf("Expected the day's chirps in its sitemap, got %d %s", rec.Code, body)
+	}
+	if strings.Contains(body, hidden.ID.String()) {
+		t.Errorf("Expected the shadowbanned user's chirp to be left out, got %s", body)
+	}
+	rec = doRequest(t, handler, "GET", "/sitemaps/users-"+day+".xml", "", "")
+	if !strings.Contains(rec.Body.String(), "/app/users/"+walt.ID.String()) || strings.Contains(rec.Body.String(), troll.ID.String()) {
+		t.Errorf("Expected only the visible user in the users sitemap, got %s", rec.Body.String())
+	}
+	for _, path := range []string{"/sitemaps/chirps-" + day + "-2.xml", "/sitemaps/chirps-1999-01-01.xml", "/sitemaps/posts-" + day + ".xml"} {
+		if rec = doRequest(t, handler, "GET", path, "", ""); rec.Code != 404 {
+			t.Errorf("Expected 404 for %s, got %d", path, rec.Code)
+		}
+	}
+
+	cfg.siteURL = "https://chirpy.example.com"
+	rec = doRequest(t, handler, "GET", "/robots.txt", "", "")
+	if rec.Body.String() != defaultRobotsRules+"\nSitemap: https://chirpy.example.com/sitemap.xml\n" {
+		t.Errorf("Expected the default rules and the sitemap in robots.txt, got %q", rec.Body.String())
+	}
+	cfg.robotsRules = "User-agent: *\nDisallow:"
+	rec = doRequest(t, handler, "GET", "/robots.txt", "", "")
+	if !strings.HasPrefix(rec.Body.String(), "User-agent: *\nDisallow:\n\nSitemap: ") {
+		t.Errorf("Expected the configured rules in robots.txt, got %q", rec.Body.String())
+	}
+}
+
+func TestDigests(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.routes()
+	walt := registerAndLogin(t, handler, "walt@example.com")
+	jesse := registerAndLogin(t, handler, "jesse@example.com")
+	troll := registerAndLogin(t, handler, "troll@example.com")
+	doRequest(t, handler, "PUT", "/admin/users/"+troll.ID.String()+"/shadowban", "test-admin-token", `{}`)
+
+	rec := doRequest(t, handler, "GET", "/api/users/me/digest", walt.Token, "")
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"subscribed":false`) {
+		t.Fatalf("Expected walt not to be subscribed yet, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "PUT", "/api/users/me/digest", "", ""); rec.Code != 401 {
+		t.Fatalf("Expected 401 subscribing without a token, got %d", rec.Code)
+	}
+	rec = doRequest(t, handler, "PUT", "/api/users/me/digest", walt.Token, "")
+	var sub digestResponse
+	json.Unmarshal(rec.Body.Bytes(), &sub)
+	if rec.Code != 200 || !sub.Subscribed || sub.Since == nil {
+		t.Fatalf("Expected walt to be subscribed, got %d %s", rec.Code, rec.Body.String())
+	}
+	doRequest(t, handler, "PUT", "/api/users/me/digest", jesse.Token, "")
+	doRequest(t, handler, "DELETE", "/api/users/me/digest", jesse.Token, "")
+
+	doRequest(t, handler, "POST", "/api/chirps", jesse.Token, `{"body":"new from jesse"}`)
+	doRequest(t, handler, "POST", "/api/chirps", walt.Token, `{"body":"new from walt"}`)
+	doRequest(t, handler, "POST", "/api/chirps", troll.Token, `{"body":"new from troll"}`)
+
+	ctx := context.Background()
+	now := time.Now().UTC().Add(time.Second)
+	n, err := cfg.sendDigests(ctx, now)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected one digest, to walt, got %d %v", n, err)
+	}
+	if n, _ = cfg.sendDigests(ctx, now); n != 0 {
+		t.Errorf("Expected no digest twice for the same window, got %d", n)
+	}
+	jobs, _ := cfg.store.ListJobsByStatus(ctx, database.ListJobsByStatusParams{Status: "pending", Limit: 100})
+	var digests []email.Message
+	for _, job := range jobs {
+		var msg email.Message
+		if job.Kind == email.JobKind && json.Unmarshal([]byte(job.Payload), &msg) == nil {
+			digests = append(digests, msg)
+		}
+	}
+	if len(digests) != 1 || digests[0].To != "walt@example.com" || !strings.Contains(digests[0].Text, "new from jesse") {
+		t.Fatalf("Expected walt's digest to list jesse's chirp, got %+v", digests)
+	}
+	if text := digests[0].Text; strings.Contains(text, "new from walt") || strings.Contains(text, "new from troll") || strings.Contains(text, "jesse@example.com") {
+		t.Errorf("Expected the digest to leave out walt's own and hidden chirps and authors' addresses, got %s", text)
+	}
+	rec = doRequest(t, handler, "GET", "/api/users/me/digest", walt.Token, "")
+	json.Unmarshal(rec.Body.Bytes(), &sub)
+	if sub.Since == nil || !sub.Since.Equal(now) {
+		t.Errorf("Expected the next digest to start when this one went out, got %s", rec.Body.String())
+	}
+}
+
+func TestAPIKeys(t *testing.T) {
+	cfg := newTestConfig()
+	handler := cfg.middlewareAPIKey(cfg.routes())
+	walt := registerAndLogin(t, handler, "walt@example.com")
+	doRequest(t, handler, "POST", "/api/chirps", walt.Token, `{"body":"say my name"}`)
+	withKey := func(method, path, key string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"body":"from a key"}`))
+		req.Header.Set("Authorization", "ApiKey "+key)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := doRequest(t, handler, "POST", "/api/api-keys", "", `{"name":"dashboard"}`); rec.Code != 401 {
+		t.Fatalf("Expected 401 issuing a key without signing in, got %d", rec.Code)
+	}
+	rec := doRequest(t, handler, "POST", "/api/api-keys", walt.Token, `{"name":"dashboard"}`)
+	var issued apiKeyResponse
+	json.Unmarshal(rec.Body.Bytes(), &issued)
+	if rec.Code != 201 || !strings.HasPrefix(issued.Key, apiKeyPrefix) || !strings.HasPrefix(issued.Key, issued.Prefix) || issued.LastUsedAt != nil {
+		t.Fatalf("Expected a new key, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = withKey("GET", "/api/chirps", issued.Key)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), "say my name") {
+		t.Fatalf("Expected the key to read chirps, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("X-Quota-Remaining") != "9999" || rec.Header().Get("X-RateLimit-Remaining") == "" {
+		t.Errorf("Expected the key's limits in the headers, got %v", rec.Header())
+	}
+	if rec = withKey("POST", "/api/chirps", issued.Key); rec.Code != 403 || !strings.Contains(rec.Body.String(), "api_key_read_only") {
+		t.Errorf("Expected a key to be refused for writes, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = withKey("GET", "/api/api-keys", issued.Key); rec.Code != 401 {
+		t.Errorf("Expected a key not to act as its owner, got %d", rec.Code)
+	}
+	if rec = withKey("GET", "/api/chirps", apiKeyPrefix+"wrong"); rec.Code != 401 || !strings.Contains(rec.Body.String(), "invalid_api_key") {
+		t.Errorf("Expected 401 for an unknown key, got %d %s", rec.Code, rec.Body.String())
+	}
+	// the Polka key is sent the same way but is not a developer key
+	req := httptest.NewRequest("POST", "/api/polka/webhooks", strings.NewReader(`{"event":"user.upgraded","data":{"user_id":"`+walt.ID.String()+`"}}`))
+	req.Header.Set("Authorization", "ApiKey "+cfg.polkaKey)
+	polka := httptest.NewRecorder()
+	handler.ServeHTTP(polka, req)
+	if polka.Code != 204 {
+		t.Errorf("Expected the Polka webhook to still be accepted, got %d %s", polka.Code, polka.Body.String())
+	}
+
+	var usage apiUsageResponse
+	rec = withKey("GET", "/api/usage", issued.Key)
+	json.Unmarshal(rec.Body.Bytes(), &usage)
+	if rec.Code != 200 || len(usage.Keys) != 1 || usage.Keys[0].Quota.Used != 3 || len(usage.Keys[0].Days) != 1 || usage.Keys[0].Key.LastUsedAt == nil {
+		t.Fatalf("Expected the key's 3 counted requests, got %d %s", rec.Code, rec.Body.String())
+	}
+	if usage.RateLimitRPS != 5 || usage.Keys[0].Quota.Limit != 10000 {
+		t.Errorf("Expected the default limits, got %+v", usage)
+	}
+
+	settings := *cfg.settings.Load()
+	settings.quotas.APIKeyRequestsPerDay = 3
+	cfg.settings.Store(&settings)
+	if rec = withKey("GET", "/api/chirps", issued.Key); rec.Code != 429 || !strings.Contains(rec.Body.String(), "quota_exceeded") || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected the daily quota to be enforced, got %d %s", rec.Code, rec.Body.String())
+	}
+	settings.quotas.APIKeyRequestsPerDay = 0
+	settings.apiKeyRateLimiter = ratelimit.NewMemoryLimiter(0.001, 1)
+	cfg.settings.Store(&settings)
+	withKey("GET", "/api/chirps", issued.Key)
+	if rec = withKey("GET", "/api/chirps", issued.Key); rec.Code != 429 || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected the key's rate limit to be enforced, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	// requests refused for the quota are counted, ones the rate limit refused are not
+	rec = doRequest(t, handler, "GET", "/api/usage", walt.Token, "")
+	json.Unmarshal(rec.Body.Bytes(), &usage)
+	if rec.Code != 200 || len(usage.Keys) != 1 || usage.Keys[0].Quota.Used != 5 || usage.Keys[0].Quota.Remaining != nil {
+		t.Errorf("Expected the owner to see the key's usage, got %d %s", rec.Code, rec.Body.String())
+	}
+	if rec = doRequest(t, handler, "DELETE", "/api/api-keys/"+issued.ID.String(), walt.Token, ""); rec.Code != 204 {
+		t.Fatalf("Expected 204 revoking the key, got %d", rec.Code)
+	}
+	if rec = withKey("GET", "/api/chirps", issued.Key); rec.Code != 401 {
+		t.Errorf("Expected a revoked key to be refused, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Maintenance mode as set by the admin endpoints. It is stored in the database and
+// every instance picks it up within MAINTENANCE_REFRESH.
+type maintenanceState struct {
+	// ReadOnly keeps GET and HEAD requests working and only pauses writes
+	ReadOnly          bool      `json:"read_only"`
+	Message           string    `json:"message"`
+	RetryAfterSeconds int       `json:"retry_after_seconds"`
+	Since             time.Time `json:"since"`
+}
+
+const defaultMaintenanceMessage = "Chirpy is down for maintenance and will be back shortly."
+
+// Name of the runtime_state row holding the maintenance state while it is on
+const maintenanceStateName = "maintenance"
+
+// Paths that keep working during maintenance besides /admin/: probes, so orchestrators
+// do not restart an instance that is paused on purpose, and the metrics scrape
+var maintenanceExemptPaths = map[string]bool{
+	"/metrics":        true,
+	"/api/healthz":    true,
+	"/api/v1/healthz": true,
+	"/api/readyz":     true,
+	"/api/v1/readyz":  true,
+}
+
+// Reports whether maintenance mode turns away a request with this method
+func (s *maintenanceState) blocks(method string) bool {
+	return s != nil && !(s.ReadOnly && (method == "GET" || method == "HEAD"))
+}
+
+// Middleware that answers 503 for every non-admin route while maintenance mode is on.
+// Browsers asking for HTML get a page, everything else the JSON error envelope.
+func (cfg *apiConfig) middlewareMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := cfg.maintenance.Load()
+		if !state.blocks(r.Method) || strings.HasPrefix(r.URL.Path, "/admin/") || maintenanceExemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfterSeconds))
+		if !strings.HasPrefix(r.URL.Path, "/api/") && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(503)
+			err := maintenancePage.Execute(w, state)
+			if err != nil {
+				log.Printf("Error rendering maintenance page: %s", err.Error())
+			}
+			return
+		}
+		marshallError(w, &apiError{
+			Code:    "maintenance",
+			Message: state.Message,
+			Details: map[string]any{"read_only": state.ReadOnly, "retry_after_seconds": state.RetryAfterSeconds, "since": state.Since},
+		}, 503)
+	})
+}
+
+var maintenancePage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Down for maintenance - Chirpy</title>
+</head>
+<body>
+  <h1>Down for maintenance</h1>
+  <p>{{.Message}}</p>
+  <p>Please try again in {{.RetryAfterSeconds}} seconds.</p>
+</body>
+</html>
+`))
+
+// Turns gRPC calls away with Unavailable while maintenance mode blocks writes. Only
+// the read methods keep working in read-only mode.
+func (cfg *apiConfig) grpcMaintenanceInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	state := cfg.maintenance.Load()
+	method := "POST"
+	if grpcReadMethods[info.FullMethod] {
+		method = "GET"
+	}
+	if state.blocks(method) {
+		grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(state.RetryAfterSeconds)))
+		return nil, status.Error(codes.Unavailable, state.Message)
+	}
+	return handler(ctx, req)
+}
+
+// Loads the shared maintenance state into cfg.maintenance and returns it, nil when it is off
+func (cfg *apiConfig) syncMaintenance(ctx context.Context) (*maintenanceState, error) {
+	row, err := cfg.store.GetRuntimeState(ctx, maintenanceStateName)
+	if errors.Is(err, sql.ErrNoRows) {
+		cfg.maintenance.Store(nil)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &maintenanceState{}
+	err = json.Unmarshal([]byte(row.Value), state)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding maintenance state: %w", err)
+	}
+	cfg.maintenance.Store(state)
+	return state, nil
+}
+
+// Picks up maintenance mode switched on other instances every interval until ctx is done.
+// A failed read keeps the last known state.
+func (cfg *apiConfig) syncMaintenanceEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := cfg.syncMaintenance(ctx)
+			if err != nil {
+				log.Printf("Error reading maintenance state: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Shows whether maintenance mode is on; the body is null when it is off
+func (cfg *apiConfig) handlerGetMaintenance(w http.ResponseWriter, r *http.Request) {
+	state, err := cfg.syncMaintenance(r.Context())
+	if err != nil {
+		log.Printf("Error reading maintenance state: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	render(w, r, 200, state)
+}
+
+// Turns maintenance mode on, or changes its message and retry hint while it is on
+func (cfg *apiConfig) handlerPutMaintenance(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		ReadOnly          bool   `json:"read_only"`
+		Message           string `json:"message" validate:"max=500"`
+		RetryAfterSeconds *int   `json:"retry_after_seconds" validate:"min=1,max=86400"`
+	}
+	params := parameters{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	state := &maintenanceState{
+		ReadOnly:          params.ReadOnly,
+		Message:           params.Message,
+		RetryAfterSeconds: 300,
+		Since:             time.Now().UTC(),
+	}
+	if state.Message == "" {
+		state.Message = defaultMaintenanceMessage
+	}
+	if params.RetryAfterSeconds != nil {
+		state.RetryAfterSeconds = *params.RetryAfterSeconds
+	}
+	prev, err := cfg.syncMaintenance(r.Context())
+	if err != nil {
+		log.Printf("Error reading maintenance state: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if prev != nil {
+		state.Since = prev.Since
+	}
+	value, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("Error encoding maintenance state: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	_, err = cfg.store.SetRuntimeState(r.Context(), database.SetRuntimeStateParams{Name: maintenanceStateName, Value: string(value)})
+	if err != nil {
+		log.Printf("Error saving maintenance state: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.maintenance.Store(state)
+	log.Printf("Maintenance mode on: read_only=%t retry_after=%ds", state.ReadOnly, state.RetryAfterSeconds)
+	cfg.recordAudit(r, "maintenance.enable", "", state)
+	render(w, r, 200, state)
+}
+
+// Turns maintenance mode off
+func (cfg *apiConfig) handlerDeleteMaintenance(w http.ResponseWriter, r *http.Request) {
+	prev, err := cfg.syncMaintenance(r.Context())
+	if err != nil {
+		log.Printf("Error reading maintenance state: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if prev == nil {
+		marshallError(w, fmt.Errorf("maintenance mode is not on"), 404)
+		return
+	}
+	_, err = cfg.store.DeleteRuntimeState(r.Context(), maintenanceStateName)
+	if err != nil {
+		log.Printf("Error clearing maintenance state: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.maintenance.Store(nil)
+	log.Printf("Maintenance mode off after %s", time.Since(prev.Since).Round(time.Second))
+	cfg.recordAudit(r, "maintenance.disable", "", nil)
+	w.WriteHeader(204)
+}
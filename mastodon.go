@@ -0,0 +1,716 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// The subset of the Mastodon client API the common mobile clients need: signing in,
+// the account, timelines, posting and deleting statuses, and favourites. Statuses are
+// chirps and accounts are users, named by their id since Chirpy users have no handle.
+// Chirpy has no follows, so the home timeline is the tenant's whole timeline like the
+// public one.
+
+// Page sizes of Mastodon timelines, which clients pick with limit
+const (
+	mastodonDefaultLimit = 20
+	mastodonMaxLimit     = 40
+)
+
+// The version clients see, which they use to decide what the server supports
+const mastodonVersion = "4.0.0 (compatible; Chirpy)"
+
+// Registers a route of the Mastodon client API. Clients expect these at Mastodon's own
+// paths, so they sit outside Chirpy's versioned API and its OpenAPI document.
+func (cfg *apiConfig) handleMastodon(mux *http.ServeMux, pattern string, handler http.Handler) {
+	mux.Handle(pattern, withCachePolicy("no-store", withTimeout(cfg.routeTimeout(pattern), handler)))
+}
+
+// Writes v as the JSON body of a Mastodon response
+func writeMastodon(w http.ResponseWriter, code int, v any) {
+	dat, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshalling Mastodon response: %s", err.Error())
+		code, dat = 500, []byte(`{"error":"Internal Server Error"}`)
+	}
+	writeBody(w, code, "application/json; charset=utf-8", dat)
+}
+
+// Writes an error the way Mastodon does, which clients show to the user
+func mastodonError(w http.ResponseWriter, code int, message string) {
+	writeMastodon(w, code, map[string]string{"error": message})
+}
+
+// Reads the parameters of a Mastodon client's request, which come in the query and as
+// a form, a multipart form or JSON. Array parameters are keyed without their [] suffix.
+// A body that cannot be read is answered with 400, or 413 when it is too large.
+func readMastodonParams(w http.ResponseWriter, r *http.Request) (url.Values, bool) {
+	params, err := mastodonParams(r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		mastodonError(w, 413, "The request body is too large")
+		return nil, false
+	}
+	if err != nil {
+		mastodonError(w, 400, err.Error())
+		return nil, false
+	}
+	return params, true
+}
+
+func mastodonParams(r *http.Request) (url.Values, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	params := url.Values{}
+	switch mediaType {
+	case "application/json":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		for key, values := range r.URL.Query() {
+			params[strings.TrimSuffix(key, "[]")] = values
+		}
+		if len(bytes.TrimSpace(body)) == 0 {
+			return params, nil
+		}
+		var fields map[string]any
+		err = json.Unmarshal(body, &fields)
+		if err != nil {
+			return nil, errors.New("the request body is not a JSON object")
+		}
+		for key, value := range fields {
+			key = strings.TrimSuffix(key, "[]")
+			values, ok := value.([]any)
+			if !ok {
+				values = []any{value}
+			}
+			for _, value := range values {
+				switch value := value.(type) {
+				case nil:
+				case string:
+					params.Add(key, value)
+				default:
+					dat, _ := json.Marshal(value)
+					params.Add(key, string(dat))
+				}
+			}
+		}
+		return params, nil
+	case "multipart/form-data":
+		err := r.ParseMultipartForm(1 << 20)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		err := r.ParseForm()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for key, values := range r.Form {
+		key = strings.TrimSuffix(key, "[]")
+		params[key] = append(params[key], values...)
+	}
+	return params, nil
+}
+
+// Reads the bearer token of a Mastodon client, either a Chirpy access token or the
+// token /oauth/token issued, as the viewer of the request. Routes that need a
+// signed-in user answer 401 without one; a token that is sent must always be valid.
+// A token from /oauth/token must also have been granted scope, while a Chirpy access
+// token has the same access as the user's own login.
+func (cfg *apiConfig) middlewareMastodonAuth(scope string, required bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" && !required {
+			next.ServeHTTP(w, r)
+			return
+		}
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			mastodonError(w, 401, "The access token is invalid")
+			return
+		}
+		userID, err := cfg.validateJWT(token)
+		if err != nil {
+			oauthToken, tokenErr := cfg.store.GetOAuthToken(r.Context(), hashOAuthSecret(token))
+			if errors.Is(tokenErr, sql.ErrNoRows) {
+				mastodonError(w, 401, "The access token is invalid")
+				return
+			}
+			if tokenErr != nil {
+				log.Printf("Error getting access token: %s", tokenErr.Error())
+				mastodonError(w, 500, tokenErr.Error())
+				return
+			}
+			if !hasOAuthScope(oauthToken.Scopes, scope) {
+				mastodonError(w, 403, "This action is outside the authorized scopes")
+				return
+			}
+			userID = oauthToken.UserID
+		}
+		next.ServeHTTP(w, r.WithContext(withViewer(r.Context(), userID)))
+	})
+}
+
+type mastodonAccount struct {
+	ID             string          `json:"id"`
+	Username       string          `json:"username"`
+	Acct           string          `json:"acct"`
+	DisplayName    string          `json:"display_name"`
+	Locked         bool            `json:"locked"`
+	Bot            bool            `json:"bot"`
+	Discoverable   bool            `json:"discoverable"`
+	Group          bool            `json:"group"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Note           string          `json:"note"`
+	URL            string          `json:"url"`
+	Avatar         string          `json:"avatar"`
+	AvatarStatic   string          `json:"avatar_static"`
+	Header         string          `json:"header"`
+	HeaderStatic   string          `json:"header_static"`
+	FollowersCount int64           `json:"followers_count"`
+	FollowingCount int64           `json:"following_count"`
+	StatusesCount  int64           `json:"statuses_count"`
+	LastStatusAt   *string         `json:"last_status_at"`
+	Emojis         []any           `json:"emojis"`
+	Fields         []any           `json:"fields"`
+	Source         *mastodonSource `json:"source,omitempty"`
+}
+
+// The account settings verify_credentials adds for the signed-in user
+type mastodonSource struct {
+	Privacy   string `json:"privacy"`
+	Sensitive bool   `json:"sensitive"`
+	Language  string `json:"language"`
+	Note      string `json:"note"`
+	Fields    []any  `json:"fields"`
+}
+
+type mastodonStatus struct {
+	ID                 string          `json:"id"`
+	URI                string          `json:"uri"`
+	URL                string          `json:"url"`
+	CreatedAt          time.Time       `json:"created_at"`
+	Account            mastodonAccount `json:"account"`
+	Content            string          `json:"content"`
+	Text               *string         `json:"text,omitempty"`
+	Visibility         string          `json:"visibility"`
+	Sensitive          bool            `json:"sensitive"`
+	SpoilerText        string          `json:"spoiler_text"`
+	MediaAttachments   []any           `json:"media_attachments"`
+	Mentions           []any           `json:"mentions"`
+	Tags               []any           `json:"tags"`
+	Emojis             []any           `json:"emojis"`
+	ReblogsCount       int64           `json:"reblogs_count"`
+	FavouritesCount    int64           `json:"favourites_count"`
+	RepliesCount       int64           `json:"replies_count"`
+	InReplyToID        *string         `json:"in_reply_to_id"`
+	InReplyToAccountID *string         `json:"in_reply_to_account_id"`
+	Reblog             *mastodonStatus `json:"reblog"`
+	Poll               any             `json:"poll"`
+	Card               any             `json:"card"`
+	Language           *string         `json:"language"`
+	EditedAt           *string         `json:"edited_at"`
+	Favourited         bool            `json:"favourited"`
+	Reblogged          bool            `json:"reblogged"`
+	Muted              bool            `json:"muted"`
+	Bookmarked         bool            `json:"bookmarked"`
+	Pinned             bool            `json:"pinned"`
+}
+
+// Links to a user and a chirp: their ActivityPub ids when the tenant federates, else
+// the Chirpy API paths that list them
+func (cfg *apiConfig) mastodonAccountURL(ctx context.Context, userID uuid.UUID) string {
+	if cfg.federation != nil && tenantID(ctx) == uuid.Nil {
+		return cfg.federation.ActorID(userID)
+	}
+	return "/api/v1/chirps?author_id=" + userID.String()
+}
+
+func (cfg *apiConfig) mastodonStatusURL(ctx context.Context, chirpID uuid.UUID) string {
+	if cfg.federation != nil && tenantID(ctx) == uuid.Nil {
+		return cfg.federation.NoteID(chirpID)
+	}
+	return "/api/v1/chirps/" + chirpID.String()
+}
+
+// Builds the accounts of the users of the caller's tenant with these ids. Users that
+// do not exist are left out.
+func (cfg *apiConfig) mastodonAccounts(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]mastodonAccount, error) {
+	list := make([]string, len(ids))
+	for i, id := range ids {
+		list[i] = id.String()
+	}
+	users, err := cfg.store.GetUsersByIds(ctx, database.GetUsersByIdsParams{TenantID: tenantID(ctx), Ids: strings.Join(list, ",")})
+	if err != nil {
+		return nil, err
+	}
+	accounts := make(map[uuid.UUID]mastodonAccount, len(users))
+	for _, user := range users {
+		// the author timelines are cached, so counting them is cheap
+		chirps, err := cfg.listChirps(ctx, user.ID, true)
+		if err != nil {
+			return nil, err
+		}
+		account := mastodonAccount{
+			ID:            user.ID.String(),
+			Username:      user.ID.String(),
+			Acct:          user.ID.String(),
+			CreatedAt:     user.CreatedAt,
+			URL:           cfg.mastodonAccountURL(ctx, user.ID),
+			StatusesCount: int64(len(chirps)),
+			Emojis:        []any{},
+			Fields:        []any{},
+		}
+		if len(chirps) > 0 {
+			day := chirps[0].CreatedAt.Format(time.DateOnly)
+			account.LastStatusAt = &day
+		}
+		accounts[user.ID] = account
+	}
+	return accounts, nil
+}
+
+// Reads the account of the user a route's {id} names, answering 404 when there is none
+func (cfg *apiConfig) mastodonAccount(w http.ResponseWriter, r *http.Request, id string) (mastodonAccount, bool) {
+	userID, err := uuid.Parse(id)
+	if err != nil {
+		mastodonError(w, 404, "Record not found")
+		return mastodonAccount{}, false
+	}
+	accounts, err := cfg.mastodonAccounts(r.Context(), []uuid.UUID{userID})
+	if err != nil {
+		log.Printf("Error getting account: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return mastodonAccount{}, false
+	}
+	account, ok := accounts[userID]
+	if !ok {
+		mastodonError(w, 404, "Record not found")
+		return mastodonAccount{}, false
+	}
+	return account, true
+}
+
+// Builds the statuses of chirps, with their favourite counts and whether the viewer
+// favourited them. Chirps whose author is gone are left out.
+func (cfg *apiConfig) mastodonStatuses(ctx context.Context, chirps []database.Chirp) ([]mastodonStatus, error) {
+	statuses := make([]mastodonStatus, 0, len(chirps))
+	if len(chirps) == 0 {
+		return statuses, nil
+	}
+	ids := make([]string, len(chirps))
+	var authors []uuid.UUID
+	for i, chirp := range chirps {
+		ids[i] = chirp.ID.String()
+		if !slices.Contains(authors, chirp.UserID) {
+			authors = append(authors, chirp.UserID)
+		}
+	}
+	accounts, err := cfg.mastodonAccounts(ctx, authors)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := cfg.store.CountChirpFavourites(ctx, strings.Join(ids, ","))
+	if err != nil {
+		return nil, err
+	}
+	favourites := make(map[uuid.UUID]int64, len(counts))
+	for _, count := range counts {
+		favourites[count.ChirpID] = count.Favourites
+	}
+	var favourited []uuid.UUID
+	if viewer := viewerID(ctx); viewer != uuid.Nil {
+		favourited, err = cfg.store.ListFavouritedChirpIds(ctx, database.ListFavouritedChirpIdsParams{UserID: viewer, Ids: strings.Join(ids, ",")})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, chirp := range chirps {
+		account, ok := accounts[chirp.UserID]
+		if !ok {
+			continue
+		}
+		link := cfg.mastodonStatusURL(ctx, chirp.ID)
+		statuses = append(statuses, mastodonStatus{
+			ID:               chirp.ID.String(),
+			URI:              link,
+			URL:              link,
+			CreatedAt:        chirp.CreatedAt,
+			Account:          account,
+			Content:          "<p>" + strings.ReplaceAll(html.EscapeString(chirp.Body), "\n", "<br>") + "</p>",
+			Visibility:       "public",
+			MediaAttachments: []any{},
+			Mentions:         []any{},
+			Tags:             []any{},
+			Emojis:           []any{},
+			FavouritesCount:  favourites[chirp.ID],
+			Favourited:       slices.Contains(favourited, chirp.ID),
+		})
+	}
+	return statuses, nil
+}
+
+// Writes the status of a single chirp
+func (cfg *apiConfig) writeMastodonStatus(w http.ResponseWriter, r *http.Request, chirp database.Chirp) {
+	statuses, err := cfg.mastodonStatuses(r.Context(), []database.Chirp{chirp})
+	if err != nil {
+		log.Printf("Error building status: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	if len(statuses) == 0 {
+		mastodonError(w, 404, "Record not found")
+		return
+	}
+	writeMastodon(w, 200, statuses[0])
+}
+
+// Cuts a page out of chirps, newest first, the way Mastodon pages timelines: max_id
+// returns chirps older than that one, since_id the newest chirps newer than that one,
+// and min_id the chirps just after it. The neighbouring pages are linked in a Link
+// header. An unknown max_id, such as a chirp deleted since, ends the timeline.
+func mastodonPage(w http.ResponseWriter, r *http.Request, chirps []database.Chirp) []database.Chirp {
+	query := r.URL.Query()
+	limit := mastodonDefaultLimit
+	if n, err := strconv.Atoi(query.Get("limit")); err == nil && n > 0 {
+		limit = min(n, mastodonMaxLimit)
+	}
+	index := func(id string) int {
+		return slices.IndexFunc(chirps, func(chirp database.Chirp) bool { return chirp.ID.String() == id })
+	}
+	start, end := 0, len(chirps)
+	if id := query.Get("max_id"); id != "" {
+		start = len(chirps)
+		if i := index(id); i >= 0 {
+			start = i + 1
+		}
+	}
+	if id := query.Get("since_id"); id != "" {
+		if i := index(id); i >= 0 {
+			end = i
+		}
+	}
+	var page []database.Chirp
+	if id := query.Get("min_id"); id != "" {
+		if i := index(id); i >= 0 {
+			end = min(end, i)
+		}
+		page = chirps[min(max(start, end-limit), end):end]
+	} else {
+		page = chirps[start:max(start, min(end, start+limit))]
+	}
+	if len(page) > 0 {
+		w.Header().Set("Link", strings.Join([]string{
+			mastodonPageLink(r, "max_id", page[len(page)-1].ID.String(), "next"),
+			mastodonPageLink(r, "min_id", page[0].ID.String(), "prev"),
+		}, ", "))
+	}
+	return page
+}
+
+// Helper function to build one Link entry pointing at the request's own path, with the
+// paging parameters replaced by param
+func mastodonPageLink(r *http.Request, param, id, rel string) string {
+	query := r.URL.Query()
+	query.Del("max_id")
+	query.Del("since_id")
+	query.Del("min_id")
+	query.Set(param, id)
+	return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel)
+}
+
+// Writes a page of chirps as statuses
+func (cfg *apiConfig) writeMastodonTimeline(w http.ResponseWriter, r *http.Request, chirps []database.Chirp) {
+	statuses, err := cfg.mastodonStatuses(r.Context(), mastodonPage(w, r, chirps))
+	if err != nil {
+		log.Printf("Error building statuses: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	writeMastodon(w, 200, statuses)
+}
+
+// Describes the server to clients, which read its limits from configuration
+func (cfg *apiConfig) handlerMastodonInstance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	users, err := cfg.store.CountUsersByTenant(ctx, tenantID(ctx))
+	if err != nil {
+		log.Printf("Error counting users: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	chirps, err := cfg.listChirps(ctx, uuid.Nil, false)
+	if err != nil {
+		log.Printf("Error listing chirps: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	writeMastodon(w, 200, map[string]any{
+		"uri":               r.Host,
+		"title":             "Chirpy",
+		"short_description": "",
+		"description":       "",
+		"email":             "",
+		"version":           mastodonVersion,
+		"urls":              map[string]any{},
+		"stats":             map[string]any{"user_count": users, "status_count": len(chirps), "domain_count": 0},
+		"thumbnail":         nil,
+		"languages":         []string{},
+		"registrations":     false,
+		"approval_required": false,
+		"invites_enabled":   false,
+		"configuration": map[string]any{
+			"statuses":          map[string]any{"max_characters": 140, "max_media_attachments": 0, "characters_reserved_per_url": 0},
+			"media_attachments": map[string]any{"supported_mime_types": []string{}},
+			"polls":             map[string]any{"max_options": 0},
+		},
+		"contact_account": nil,
+		"rules":           []any{},
+	})
+}
+
+// The signed-in user's account, with their settings
+func (cfg *apiConfig) handlerMastodonVerifyCredentials(w http.ResponseWriter, r *http.Request) {
+	account, ok := cfg.mastodonAccount(w, r, viewerID(r.Context()).String())
+	if !ok {
+		return
+	}
+	account.Source = &mastodonSource{Privacy: "public", Fields: []any{}}
+	writeMastodon(w, 200, account)
+}
+
+func (cfg *apiConfig) handlerMastodonGetAccount(w http.ResponseWriter, r *http.Request) {
+	account, ok := cfg.mastodonAccount(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	writeMastodon(w, 200, account)
+}
+
+// A user's chirps, newest first. Chirpy has no pinned chirps or media, so asking for
+// only those lists nothing.
+func (cfg *apiConfig) handlerMastodonAccountStatuses(w http.ResponseWriter, r *http.Request) {
+	account, ok := cfg.mastodonAccount(w, r, r.PathValue("id"))
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	if query.Get("pinned") == "true" || query.Get("only_media") == "true" {
+		writeMastodon(w, 200, []mastodonStatus{})
+		return
+	}
+	chirps, err := cfg.listChirps(r.Context(), uuid.MustParse(account.ID), true)
+	if err != nil {
+		log.Printf("Error listing chirps: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	cfg.writeMastodonTimeline(w, r, chirps)
+}
+
+// The home and public timelines, which are both every chirp of the tenant
+func (cfg *apiConfig) handlerMastodonTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("only_media") == "true" || r.URL.Query().Get("remote") == "true" {
+		writeMastodon(w, 200, []mastodonStatus{})
+		return
+	}
+	chirps, err := cfg.listChirps(r.Context(), uuid.Nil, true)
+	if err != nil {
+		log.Printf("Error listing chirps: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	cfg.writeMastodonTimeline(w, r, chirps)
+}
+
+// Reads the chirp a route's {id} names as the viewer sees it, answering 404 when
+// there is none
+func (cfg *apiConfig) mastodonChirp(w http.ResponseWriter, r *http.Request) (database.Chirp, bool) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		mastodonError(w, 404, "Record not found")
+		return database.Chirp{}, false
+	}
+	chirp, err := cfg.getChirpById(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		mastodonError(w, 404, "Record not found")
+		return database.Chirp{}, false
+	}
+	if err != nil {
+		log.Printf("Error getting chirp: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return database.Chirp{}, false
+	}
+	return chirp, true
+}
+
+func (cfg *apiConfig) handlerMastodonGetStatus(w http.ResponseWriter, r *http.Request) {
+	chirp, ok := cfg.mastodonChirp(w, r)
+	if !ok {
+		return
+	}
+	cfg.writeMastodonStatus(w, r, chirp)
+}
+
+// Posts a chirp. Chirps are always public and plain text, so statuses that need
+// anything else are refused rather than posted without it.
+func (cfg *apiConfig) handlerMastodonCreateStatus(w http.ResponseWriter, r *http.Request) {
+	params, ok := readMastodonParams(w, r)
+	if !ok {
+		return
+	}
+	var unsupported string
+	switch {
+	case len(params["media_ids"]) > 0:
+		unsupported = "media attachments are not supported"
+	case params.Get("poll[options]") != "" || params.Get("poll") != "":
+		unsupported = "polls are not supported"
+	case params.Get("in_reply_to_id") != "":
+		unsupported = "replies are not supported"
+	case params.Get("spoiler_text") != "":
+		unsupported = "content warnings are not supported"
+	case params.Get("scheduled_at") != "":
+		unsupported = "scheduled statuses are not supported"
+	case params.Get("visibility") != "" && params.Get("visibility") != "public":
+		unsupported = "only public statuses are supported"
+	}
+	if unsupported != "" {
+		mastodonError(w, 422, "Validation failed: "+unsupported)
+		return
+	}
+	chirp, err := cfg.createChirp(r.Context(), viewerID(r.Context()), params.Get("status"))
+	var invalid invalidInputError
+	if errors.As(err, &invalid) {
+		mastodonError(w, 422, "Validation failed: "+invalid.Error())
+		return
+	}
+	var exceeded *quotaExceededError
+	if errors.As(err, &exceeded) {
+		mastodonError(w, 429, err.Error())
+		return
+	}
+	if errors.Is(err, errOtherTenant) {
+		mastodonError(w, 403, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating chirp: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	cfg.writeMastodonStatus(w, r, chirp)
+}
+
+// Deletes one of the user's chirps, returning it with its text so clients can offer
+// to post it again
+func (cfg *apiConfig) handlerMastodonDeleteStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		mastodonError(w, 404, "Record not found")
+		return
+	}
+	chirp, err := cfg.deleteChirp(r.Context(), viewerID(r.Context()), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		mastodonError(w, 404, "Record not found")
+		return
+	}
+	if errors.Is(err, errNotChirpAuthor) {
+		mastodonError(w, 403, "This action is not allowed")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting chirp: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	statuses, err := cfg.mastodonStatuses(r.Context(), []database.Chirp{chirp})
+	if err != nil || len(statuses) == 0 {
+		// the chirp is gone either way, only the copy to post again is missing
+		writeMastodon(w, 200, map[string]string{"id": chirp.ID.String()})
+		return
+	}
+	statuses[0].Text = &chirp.Body
+	writeMastodon(w, 200, statuses[0])
+}
+
+// Favourites or unfavourites a chirp the user can see, returning its status
+func (cfg *apiConfig) handlerMastodonFavourite(favourite bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chirp, ok := cfg.mastodonChirp(w, r)
+		if !ok {
+			return
+		}
+		ctx := r.Context()
+		var err error
+		if favourite {
+			_, err = cfg.store.FavouriteChirp(ctx, database.FavouriteChirpParams{UserID: viewerID(ctx), ChirpID: chirp.ID})
+		} else {
+			_, err = cfg.store.UnfavouriteChirp(ctx, database.UnfavouriteChirpParams{UserID: viewerID(ctx), ChirpID: chirp.ID})
+		}
+		if err != nil {
+			log.Printf("Error updating favourite: %s", err.Error())
+			mastodonError(w, 500, err.Error())
+			return
+		}
+		cfg.writeMastodonStatus(w, r, chirp)
+	}
+}
+
+// The chirps the user favourited, most recently favourited first. Pages are linked by
+// when the last chirp was favourited, which clients only read from the Link header.
+func (cfg *apiConfig) handlerMastodonFavourites(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	limit := mastodonDefaultLimit
+	if n, err := strconv.Atoi(query.Get("limit")); err == nil && n > 0 {
+		limit = min(n, mastodonMaxLimit)
+	}
+	before := time.Now().UTC().Add(time.Minute)
+	if nanos, err := strconv.ParseInt(query.Get("max_id"), 10, 64); err == nil {
+		before = time.Unix(0, nanos).UTC()
+	}
+	rows, err := cfg.store.ListFavouriteChirps(ctx, database.ListFavouriteChirpsParams{
+		UserID:     viewerID(ctx),
+		TenantID:   tenantID(ctx),
+		Before:     before,
+		MaxResults: int32(limit),
+	})
+	if err != nil {
+		log.Printf("Error listing favourites: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	chirps := make([]database.Chirp, len(rows))
+	for i, row := range rows {
+		chirps[i] = database.Chirp{ID: row.ID, CreatedAt: row.CreatedAt, UpdatedAt: row.UpdatedAt, Body: row.Body, UserID: row.UserID, TenantID: row.TenantID}
+	}
+	if len(rows) == limit {
+		w.Header().Set("Link", mastodonPageLink(r, "max_id", strconv.FormatInt(rows[len(rows)-1].FavouritedAt.UnixNano(), 10), "next"))
+	}
+	statuses, err := cfg.mastodonStatuses(ctx, chirps)
+	if err != nil {
+		log.Printf("Error building statuses: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	writeMastodon(w, 200, statuses)
+}
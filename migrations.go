@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// The goose migrations for each backend are compiled into the binary
+//
+//go:embed sql/schema/*.sql sql/schema_sqlite/*.sql
+var migrationsFS embed.FS
+
+// On Postgres, migrations run while holding a session advisory lock, so when several
+// instances start at once one applies them and the others wait up to
+// MIGRATION_LOCK_TIMEOUT, then find nothing left to apply. SQLite has a single writer.
+func newMigrationProvider(db *sql.DB, dialect goose.Dialect) (*goose.Provider, error) {
+	dir := "sql/schema"
+	if dialect == goose.DialectSQLite3 {
+		dir = "sql/schema_sqlite"
+	}
+	schema, err := fs.Sub(migrationsFS, dir)
+	if err != nil {
+		return nil, err
+	}
+	var options []goose.ProviderOption
+	if dialect == goose.DialectPostgres {
+		timeout := getEnvDuration("MIGRATION_LOCK_TIMEOUT", 5*time.Minute)
+		locker, err := lock.NewPostgresSessionLocker(lock.WithLockTimeout(1, uint64(max(timeout/time.Second, 1))))
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, goose.WithSessionLocker(locker))
+	}
+	return goose.NewProvider(dialect, db, schema, options...)
+}
+
+// Applies every pending migration, logging each one that runs
+func runMigrations(ctx context.Context, db *sql.DB, dialect goose.Dialect) error {
+	provider, err := newMigrationProvider(db, dialect)
+	if err != nil {
+		return err
+	}
+	results, err := provider.Up(ctx)
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		log.Printf("Applied migration %s in %s", result.Source.Path, result.Duration)
+	}
+	return nil
+}
+
+func migrateUsage() error {
+	return fmt.Errorf("usage: chirpy migrate up [version] | down [version] | status | force <version>")
+}
+
+// Implements the `chirpy migrate` subcommand against the configured database
+func runMigrateCommand(ctx context.Context, db *sql.DB, dialect goose.Dialect, args []string) error {
+	if len(args) == 0 {
+		return migrateUsage()
+	}
+	provider, err := newMigrationProvider(db, dialect)
+	if err != nil {
+		return err
+	}
+	var version int64
+	if len(args) > 1 {
+		version, err = strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid migration version %q", args[1])
+		}
+	}
+	switch args[0] {
+	case "up":
+		var results []*goose.MigrationResult
+		if len(args) > 1 {
+			results, err = provider.UpTo(ctx, version)
+		} else {
+			results, err = provider.Up(ctx)
+		}
+		printMigrationResults(results)
+		return err
+	case "down":
+		if len(args) > 1 {
+			results, err := provider.DownTo(ctx, version)
+			printMigrationResults(results)
+			return err
+		}
+		result, err := provider.Down(ctx)
+		if result != nil {
+			printMigrationResults([]*goose.MigrationResult{result})
+		}
+		return err
+	case "status":
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "Pending"
+			if status.State == goose.StateApplied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%-25s %s\n", appliedAt, status.Source.Path)
+		}
+		return nil
+	case "force":
+		if len(args) < 2 {
+			return migrateUsage()
+		}
+		return forceMigrationVersion(ctx, db, provider, version)
+	default:
+		return migrateUsage()
+	}
+}
+
+func printMigrationResults(results []*goose.MigrationResult) {
+	for _, result := range results {
+		direction := "Applied"
+		if result.Direction == "down" {
+			direction = "Rolled back"
+		}
+		fmt.Printf("%s %s in %s\n", direction, result.Source.Path, result.Duration)
+	}
+}
+
+// Rewrites goose's version table so that exactly the migrations up to version are
+// recorded as applied, without running any SQL. This is the recovery path after a
+// migration was applied or rolled back by hand.
+func forceMigrationVersion(ctx context.Context, db *sql.DB, provider *goose.Provider, version int64) error {
+	// Status also creates the version table on a fresh database
+	statuses, err := provider.Status(ctx)
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, "DELETE FROM goose_db_version WHERE version_id > $1", version)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if status.Source.Version > version || status.State == goose.StateApplied {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO goose_db_version (version_id, is_applied) VALUES ($1, TRUE)", status.Source.Version)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Forced schema version to %d\n", version)
+	return nil
+}
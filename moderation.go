@@ -0,0 +1,552 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/email"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/diamondoughnut/httpChirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+// What moderators can decide about a chirp in the moderation queue. Approving keeps the
+// chirp and closes its reports, removing deletes it and closes them, and escalating
+// marks it for a senior moderator and leaves the reports open.
+const (
+	moderationApprove  = "approve"
+	moderationRemove   = "remove"
+	moderationEscalate = "escalate"
+)
+
+// Who MODERATION_NOTIFY can have emailed about decisions: reporters when a chirp they
+// reported is approved or removed, authors when their chirp is removed
+const (
+	notifyReporter = "reporter"
+	notifyAuthor   = "author"
+)
+
+// Request body of reporting a chirp
+type reportChirpRequest struct {
+	Reason  string `json:"reason" validate:"required,oneof=spam harassment hate violence sexual misinformation other"`
+	Details string `json:"details" validate:"max=1000"`
+}
+
+type chirpReportResponse struct {
+	ID        uuid.UUID `json:"id"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	Reason    string    `json:"reason"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A user reporting their own chirp
+var errOwnChirp = &apiError{Code: "own_chirp", Message: "you cannot report your own chirp"}
+
+// A user reporting a chirp again before moderators decided on their first report
+var errAlreadyReported = &apiError{Code: "already_reported", Message: "you already reported this chirp"}
+
+// A decision on a chirp with no open reports, or that no longer exists
+var errNotInQueue = &apiError{Code: "not_in_queue", Message: "the chirp is not in the moderation queue"}
+
+// Reports a chirp to the moderators, along with a chirp.reported event. Users cannot
+// report their own chirps, and report a chirp once until moderators decide on it.
+func (cfg *apiConfig) handlerReportChirp(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid chirp id"), 400)
+		return
+	}
+	params := reportChirpRequest{}
+	err = decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	chirp, err := cfg.store.GetChirpById(r.Context(), database.GetChirpByIdParams{ID: chirpID, TenantID: tenantID(r.Context()), ViewerID: userID})
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("chirp not found"), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting chirp: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if chirp.UserID == userID {
+		marshallError(w, errOwnChirp, 400)
+		return
+	}
+	reporters, err := cfg.store.GetUsersByIds(r.Context(), database.GetUsersByIdsParams{TenantID: chirp.TenantID, Ids: userID.String()})
+	if err != nil {
+		log.Printf("Error getting reporter: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if len(reporters) == 0 {
+		marshallError(w, errOtherTenant, 403)
+		return
+	}
+	var report database.ChirpReport
+	err = cfg.changeWithEvent(r.Context(), webhooks.ChirpReported, func(tx store.Store) (any, error) {
+		var err error
+		report, err = tx.CreateChirpReport(r.Context(), database.CreateChirpReportParams{
+			ChirpID:    chirp.ID,
+			AuthorID:   chirp.UserID,
+			TenantID:   chirp.TenantID,
+			ReporterID: uuid.NullUUID{UUID: userID, Valid: true},
+			Reason:     params.Reason,
+			Details:    params.Details,
+		})
+		return webhooks.ChirpReportedData{
+			ReportID:  report.ID,
+			ChirpID:   report.ChirpID,
+			UserID:    report.AuthorID,
+			Reason:    report.Reason,
+			CreatedAt: report.CreatedAt,
+		}, err
+	})
+	if _, ok := uniqueViolation(err); ok {
+		marshallError(w, errAlreadyReported, 409)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating report: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	render(w, r, 201, chirpReportResponse{
+		ID:        report.ID,
+		ChirpID:   report.ChirpID,
+		Reason:    report.Reason,
+		Details:   report.Details,
+		CreatedAt: report.CreatedAt,
+	})
+}
+
+type moderationReportResponse struct {
+	ID uuid.UUID `json:"id"`
+	// null when the chirp was flagged automatically
+	ReporterID *uuid.UUID `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Escalated  bool       `json:"escalated"`
+}
+
+type moderationChirpResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Body      string    `json:"body"`
+	UserID    uuid.UUID `json:"user_id"`
+	TenantID  uuid.UUID `json:"tenant_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A chirp waiting for a moderator, with every open report of it
+type moderationItem struct {
+	Chirp       moderationChirpResponse `json:"chirp"`
+	ReportCount int                     `json:"report_count"`
+	// open reports by reason
+	Reasons map[string]int `json:"reasons"`
+	// some reports were filed automatically rather than by users
+	Flagged         bool                       `json:"flagged"`
+	Escalated       bool                       `json:"escalated"`
+	FirstReportedAt time.Time                  `json:"first_reported_at"`
+	LastReportedAt  time.Time                  `json:"last_reported_at"`
+	Reports         []moderationReportResponse `json:"reports"`
+}
+
+// Lists the chirps with open reports, the one waiting longest first. ?status=pending
+// leaves out escalated chirps and ?status=escalated lists only those; ?reason= lists
+// chirps with an open report for that reason. ?page= and ?per_page= paginate.
+func (cfg *apiConfig) handlerModerationQueue(w http.ResponseWriter, r *http.Request) {
+	type query struct {
+		Status  string `query:"status" validate:"oneof=all pending escalated"`
+		Reason  string `query:"reason" validate:"oneof=spam harassment hate violence sexual misinformation other word_filter automod automod_hold"`
+		Page    int    `query:"page" validate:"min=1"`
+		PerPage int    `query:"per_page" validate:"min=1,max=100"`
+	}
+	q := query{Status: "all", Page: 1, PerPage: defaultPerPage}
+	err := decodeQuery(r, &q)
+	if err != nil {
+		marshallError(w, err, 400)
+		return
+	}
+	reports, err := cfg.store.ListOpenChirpReports(r.Context())
+	if err != nil {
+		log.Printf("Error listing reports: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	items := moderationQueue(reports)
+	items = slices.DeleteFunc(items, func(item *moderationItem) bool {
+		return (q.Status == "pending" && item.Escalated) || (q.Status == "escalated" && !item.Escalated) ||
+			(q.Reason != "" && item.Reasons[q.Reason] == 0)
+	})
+	render(w, r, 200, paginate(w, r, items, q.Page, q.PerPage))
+}
+
+// Groups open reports, oldest first, into one queue item per chirp in the order of
+// their first report
+func moderationQueue(reports []database.ListOpenChirpReportsRow) []*moderationItem {
+	items := []*moderationItem{}
+	byChirp := map[uuid.UUID]*moderationItem{}
+	for _, report := range reports {
+		item, ok := byChirp[report.ChirpID]
+		if !ok {
+			item = &moderationItem{
+				Chirp: moderationChirpResponse{
+					ID:        report.ChirpID,
+					Body:      report.ChirpBody,
+					UserID:    report.AuthorID,
+					TenantID:  report.TenantID,
+					CreatedAt: report.ChirpCreatedAt,
+				},
+				Reasons:         map[string]int{},
+				FirstReportedAt: report.CreatedAt,
+			}
+			byChirp[report.ChirpID] = item
+			items = append(items, item)
+		}
+		resp := moderationReportResponse{
+			ID:        report.ID,
+			Reason:    report.Reason,
+			Details:   report.Details,
+			CreatedAt: report.CreatedAt,
+			Escalated: report.EscalatedAt.Valid,
+		}
+		if report.ReporterID.Valid {
+			resp.ReporterID = &report.ReporterID.UUID
+		}
+		item.Reports = append(item.Reports, resp)
+		item.ReportCount++
+		item.Reasons[report.Reason]++
+		item.Flagged = item.Flagged || !report.ReporterID.Valid
+		item.Escalated = item.Escalated || report.EscalatedAt.Valid
+		item.LastReportedAt = report.CreatedAt
+	}
+	return items
+}
+
+type moderationDecisionResponse struct {
+	ID        uuid.UUID `json:"id"`
+	ChirpID   uuid.UUID `json:"chirp_id"`
+	AuthorID  uuid.UUID `json:"author_id"`
+	Action    string    `json:"action"`
+	Moderator string    `json:"moderator"`
+	Note      string    `json:"note,omitempty"`
+	Reports   int32     `json:"reports"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Returns the handler deciding action about a chirp in the moderation queue. The
+// decision is recorded with an optional note, and reporters and the author are emailed
+// as MODERATION_NOTIFY says.
+func (cfg *apiConfig) handlerModerationDecision(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+		if err != nil {
+			marshallError(w, fmt.Errorf("invalid chirp id"), 400)
+			return
+		}
+		type parameters struct {
+			Note string `json:"note" validate:"max=1000"`
+		}
+		params := parameters{}
+		// the note is optional, so is the body
+		if r.ContentLength != 0 {
+			err = decodeJSON(r, &params)
+			if err != nil {
+				log.Printf("Error decoding parameters: %s", err.Error())
+				marshallError(w, err, decodeErrorStatus(err))
+				return
+			}
+		}
+		decision, err := cfg.moderateChirp(r.Context(), chirpID, action, auditActor(r), params.Note)
+		if errors.Is(err, errNotInQueue) {
+			marshallError(w, err, 404)
+			return
+		}
+		if err != nil {
+			log.Printf("Error deciding on chirp %s: %s", chirpID, err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		cfg.recordAudit(r, "moderation."+action, chirpID.String(), map[string]any{
+			"decision_id": decision.ID,
+			"note":        decision.Note,
+			"reports":     decision.Reports,
+		})
+		render(w, r, 200, moderationDecisionResponse{
+			ID:        decision.ID,
+			ChirpID:   decision.ChirpID,
+			AuthorID:  decision.AuthorID,
+			Action:    decision.Action,
+			Moderator: decision.Moderator,
+			Note:      decision.Note,
+			Reports:   decision.Reports,
+			CreatedAt: decision.CreatedAt,
+		})
+	}
+}
+
+// Records a moderator's decision about a chirp with open reports and carries it out,
+// returning errNotInQueue when there is nothing to decide on. A removed chirp is
+// deleted along with its chirp.deleted event, and an approved chirp auto-moderation
+// held is published with its chirp.created event.
+func (cfg *apiConfig) moderateChirp(ctx context.Context, chirpID uuid.UUID, action, moderator, note string) (database.ModerationDecision, error) {
+	reports, err := cfg.store.GetOpenChirpReports(ctx, chirpID)
+	if err != nil {
+		return database.ModerationDecision{}, err
+	}
+	if len(reports) == 0 {
+		return database.ModerationDecision{}, errNotInQueue
+	}
+	// the chirp.deleted event belongs to the chirp's tenant rather than the admin request's
+	ctx = context.WithValue(ctx, tenantKey{}, database.Tenant{ID: reports[0].TenantID})
+	var decision database.ModerationDecision
+	var chirp database.Chirp
+	decide := func(tx store.Store) (any, error) {
+		var err error
+		reports, err = tx.GetOpenChirpReports(ctx, chirpID)
+		if err != nil {
+			return nil, err
+		}
+		if len(reports) == 0 {
+			return nil, errNotInQueue
+		}
+		// read as its author, who sees it even when they are shadowbanned
+		chirp, err = tx.GetChirpById(ctx, database.GetChirpByIdParams{ID: chirpID, TenantID: reports[0].TenantID, ViewerID: reports[0].AuthorID})
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errNotInQueue
+		}
+		if err != nil {
+			return nil, err
+		}
+		decision, err = tx.CreateModerationDecision(ctx, database.CreateModerationDecisionParams{
+			ChirpID:   chirp.ID,
+			AuthorID:  chirp.UserID,
+			TenantID:  chirp.TenantID,
+			Moderator: moderator,
+			Action:    action,
+			Note:      note,
+			Reports:   int32(len(reports)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch action {
+		case moderationEscalate:
+			_, err = tx.EscalateChirpReports(ctx, chirp.ID)
+			return nil, err
+		case moderationRemove:
+			err = tx.DeleteChirpById(ctx, database.DeleteChirpByIdParams{ID: chirp.ID, UserID: chirp.UserID, TenantID: chirp.TenantID})
+			if err != nil {
+				return nil, err
+			}
+		}
+		_, err = tx.ResolveChirpReports(ctx, database.ResolveChirpReportsParams{ChirpID: chirp.ID, DecisionID: uuid.NullUUID{UUID: decision.ID, Valid: true}})
+		if action == moderationApprove {
+			return chirpCreatedPayload(chirp), err
+		}
+		return webhooks.ChirpDeletedData{ID: chirp.ID, UserID: chirp.UserID}, err
+	}
+	// a chirp auto-moderation or a word filter held was never announced, approving it
+	// publishes it
+	publish := action == moderationApprove && slices.ContainsFunc(reports, func(report database.ChirpReport) bool {
+		return report.Reason == automodHoldReason || report.Reason == wordFilterReason
+	})
+	switch {
+	case action == moderationRemove:
+		err = cfg.changeWithEvent(ctx, webhooks.ChirpDeleted, decide)
+	case publish:
+		err = cfg.changeWithEvent(ctx, webhooks.ChirpCreated, decide)
+	default:
+		err = cfg.store.WithTx(ctx, func(tx store.Store) error {
+			_, err := decide(tx)
+			return err
+		})
+	}
+	if err != nil {
+		return database.ModerationDecision{}, err
+	}
+	if action == moderationRemove {
+		cfg.invalidateChirp(ctx, chirp)
+	}
+	if publish {
+		cfg.cacheCreatedChirp(ctx, chirp)
+	}
+	cfg.notifyModeration(ctx, decision, chirp, reports)
+	return decision, nil
+}
+
+// Body a chirp removed by moderators keeps when it is replaced by a tombstone
+const chirpTombstone = "[removed by moderators]"
+
+// Removes any chirp, whoever wrote it and whatever its tenant. The reason is required
+// and recorded in the audit log. With tombstone the chirp stays where it was, with its
+// body replaced by chirpTombstone, rather than being deleted.
+func (cfg *apiConfig) handlerAdminDeleteChirp(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirpID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid chirp id"), 400)
+		return
+	}
+	type parameters struct {
+		Reason    string `json:"reason" validate:"required,max=1000"`
+		Tombstone bool   `json:"tombstone"`
+	}
+	params := parameters{}
+	err = decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	chirp, decision, err := cfg.removeChirp(r.Context(), chirpID, auditActor(r), params.Reason, params.Tombstone)
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("chirp %s not found", chirpID), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error removing chirp %s: %s", chirpID, err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	details := map[string]any{
+		"reason":    params.Reason,
+		"tombstone": params.Tombstone,
+		"user_id":   chirp.UserID,
+		"tenant_id": chirp.TenantID,
+		"body":      chirp.Body,
+	}
+	if decision != nil {
+		details["decision_id"] = decision.ID
+	}
+	cfg.recordAudit(r, "chirp.remove", chirpID.String(), details)
+	log.Printf("Chirp %s removed by %s (tombstone=%t)", chirpID, auditActor(r), params.Tombstone)
+	w.WriteHeader(204)
+}
+
+// Deletes a chirp or replaces it with a tombstone, along with its chirp.deleted event,
+// and returns it as it was. Open reports of it are closed with a remove decision, which
+// is returned and announced as MODERATION_NOTIFY says; it is nil when there were none.
+func (cfg *apiConfig) removeChirp(ctx context.Context, chirpID uuid.UUID, moderator, reason string, tombstone bool) (database.Chirp, *database.ModerationDecision, error) {
+	chirp, err := cfg.store.GetChirpByIdAnyTenant(ctx, chirpID)
+	if err != nil {
+		return database.Chirp{}, nil, err
+	}
+	// the chirp.deleted event belongs to the chirp's tenant rather than the admin request's
+	ctx = context.WithValue(ctx, tenantKey{}, database.Tenant{ID: chirp.TenantID})
+	var reports []database.ChirpReport
+	var decision *database.ModerationDecision
+	err = cfg.changeWithEvent(ctx, webhooks.ChirpDeleted, func(tx store.Store) (any, error) {
+		var err error
+		if tombstone {
+			_, err = tx.UpdateChirpBody(ctx, database.UpdateChirpBodyParams{ID: chirp.ID, Body: chirpTombstone, TenantID: chirp.TenantID})
+		} else {
+			err = tx.DeleteChirpById(ctx, database.DeleteChirpByIdParams{ID: chirp.ID, UserID: chirp.UserID, TenantID: chirp.TenantID})
+		}
+		if err != nil {
+			return nil, err
+		}
+		reports, err = tx.GetOpenChirpReports(ctx, chirp.ID)
+		if err != nil || len(reports) == 0 {
+			return webhooks.ChirpDeletedData{ID: chirp.ID, UserID: chirp.UserID, Tombstone: tombstone}, err
+		}
+		created, err := tx.CreateModerationDecision(ctx, database.CreateModerationDecisionParams{
+			ChirpID:   chirp.ID,
+			AuthorID:  chirp.UserID,
+			TenantID:  chirp.TenantID,
+			Moderator: moderator,
+			Action:    moderationRemove,
+			Note:      reason,
+			Reports:   int32(len(reports)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		decision = &created
+		_, err = tx.ResolveChirpReports(ctx, database.ResolveChirpReportsParams{ChirpID: chirp.ID, DecisionID: uuid.NullUUID{UUID: created.ID, Valid: true}})
+		return webhooks.ChirpDeletedData{ID: chirp.ID, UserID: chirp.UserID, Tombstone: tombstone}, err
+	})
+	if err != nil {
+		return database.Chirp{}, nil, err
+	}
+	cfg.invalidateChirp(ctx, chirp)
+	if decision != nil {
+		cfg.notifyModeration(ctx, *decision, chirp, reports)
+	}
+	return chirp, decision, nil
+}
+
+// Emails the reporters and the author about a decision, as MODERATION_NOTIFY says.
+// Failures are logged, the decision stands either way.
+func (cfg *apiConfig) notifyModeration(ctx context.Context, decision database.ModerationDecision, chirp database.Chirp, reports []database.ChirpReport) {
+	if decision.Action == moderationEscalate || len(cfg.moderationNotify) == 0 {
+		return
+	}
+	removed := decision.Action == moderationRemove
+	var ids []string
+	var reasons []string
+	for _, report := range reports {
+		if report.ReporterID.Valid && slices.Contains(cfg.moderationNotify, notifyReporter) {
+			ids = append(ids, report.ReporterID.UUID.String())
+		}
+		if !slices.Contains(reasons, report.Reason) {
+			reasons = append(reasons, report.Reason)
+		}
+	}
+	if removed && slices.Contains(cfg.moderationNotify, notifyAuthor) {
+		ids = append(ids, chirp.UserID.String())
+	}
+	if len(ids) == 0 {
+		return
+	}
+	users, err := cfg.store.GetUsersByIds(ctx, database.GetUsersByIdsParams{TenantID: chirp.TenantID, Ids: strings.Join(ids, ",")})
+	if err != nil {
+		log.Printf("Error getting users to notify of moderation decision %s: %s", decision.ID, err.Error())
+		return
+	}
+	for _, user := range users {
+		if user.ID == chirp.UserID {
+			err = cfg.mailer.Send(ctx, user.Email, email.ChirpRemoved, email.ChirpRemovedData{Email: user.Email, Chirp: chirp.Body, Reasons: strings.Join(reasons, ", ")})
+		} else {
+			err = cfg.mailer.Send(ctx, user.Email, email.ReportResolved, email.ReportResolvedData{Email: user.Email, Chirp: chirp.Body, Removed: removed})
+		}
+		if err != nil {
+			log.Printf("Error queueing moderation email to %s: %s", user.Email, err.Error())
+		}
+	}
+}
+
+// Reads MODERATION_NOTIFY, a comma separated list of who is emailed about moderation
+// decisions: reporter, author, both, or neither when empty
+func moderationNotifyFromEnv() ([]string, error) {
+	var notify []string
+	for _, who := range strings.Split(os.Getenv("MODERATION_NOTIFY"), ",") {
+		who = strings.TrimSpace(who)
+		if who == "" {
+			continue
+		}
+		if who != notifyReporter && who != notifyAuthor {
+			return nil, fmt.Errorf("unknown MODERATION_NOTIFY entry %q, expected reporter or author", who)
+		}
+		notify = append(notify, who)
+	}
+	return notify, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const apiFallbackPattern = "/api/"
+
+// Methods probed when a path exists but not for the request's method
+var routeMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+// Answers /api requests that no route matched with the JSON error envelope instead of
+// the router's plain text: 405 with an Allow header when the path exists for other
+// methods, 404 otherwise
+func handlerAPIFallback(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed := allowedMethods(mux, r)
+		if len(allowed) == 0 {
+			marshallError(w, fmt.Errorf("no API route matches %s", r.URL.Path), 404)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		marshallError(w, &apiError{
+			Message: fmt.Sprintf("%s is not allowed on %s", r.Method, r.URL.Path),
+			Details: map[string]any{"allowed_methods": allowed},
+		}, 405)
+	})
+}
+
+// Lists the methods some route other than the fallback serves for the request's path
+func allowedMethods(mux *http.ServeMux, r *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		probe := &http.Request{Method: method, URL: r.URL, Host: r.Host, Header: http.Header{}}
+		if _, pattern := mux.Handler(probe); pattern != apiFallbackPattern && pattern != "" {
+			allowed = append(allowed, method)
+			// GET routes answer HEAD too
+			if method == "GET" {
+				allowed = append(allowed, "HEAD")
+			}
+		}
+	}
+	return allowed
+}
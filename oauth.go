@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// The OAuth 2 flow Mastodon clients sign in with. A client registers itself through
+// POST /api/v1/apps, sends the user to /oauth/authorize to sign in, and exchanges the
+// code it gets back at /oauth/token. The access token it receives is stored with the
+// scopes the user granted, and each Mastodon route checks the scope it needs, so a token
+// granted read cannot post. Access tokens are only accepted by the Mastodon routes.
+
+// How long an authorization code may wait to be exchanged
+const oauthCodeTTL = 10 * time.Minute
+
+// The redirect URI of clients that cannot receive a redirect, which are shown the code
+// to paste into the client instead
+const oauthOutOfBand = "urn:ietf:wg:oauth:2.0:oob"
+
+// Writes an OAuth error response, such as invalid_grant
+func oauthError(w http.ResponseWriter, code int, name, description string) {
+	writeMastodon(w, code, map[string]string{"error": name, "error_description": description})
+}
+
+// Helper function to hash an authorization code or access token, which are only stored
+// hashed
+func hashOAuthSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the scopes a client asks for, all the scopes of its app when it asks for
+// none, or false when it asks for one the app was not registered with. An app
+// registered with read may ask for read:statuses.
+func oauthScope(app database.OauthApp, requested string) (string, bool) {
+	allowed := strings.Fields(app.Scopes)
+	scopes := strings.Fields(requested)
+	if len(scopes) == 0 {
+		return app.Scopes, true
+	}
+	for _, scope := range scopes {
+		parent, _, _ := strings.Cut(scope, ":")
+		if !slices.Contains(allowed, scope) && !slices.Contains(allowed, parent) {
+			return "", false
+		}
+	}
+	return strings.Join(scopes, " "), true
+}
+
+// Reports whether the scopes a token was granted include scope, either by name or
+// through its parent, as read covers read:statuses
+func hasOAuthScope(granted, scope string) bool {
+	parent, _, _ := strings.Cut(scope, ":")
+	return slices.ContainsFunc(strings.Fields(granted), func(s string) bool {
+		return s == scope || s == parent
+	})
+}
+
+// Checks the redirect URIs of a new app, which must be absolute URLs or the
+// out-of-band URI
+func validRedirectURIs(uris []string) error {
+	if len(uris) == 0 {
+		return invalidInputError{"redirect_uris can't be blank"}
+	}
+	for _, uri := range uris {
+		if uri == oauthOutOfBand {
+			continue
+		}
+		parsed, err := url.Parse(uri)
+		if err != nil || parsed.Scheme == "" || parsed.Fragment != "" {
+			return invalidInputError{"redirect_uris must be absolute URLs without a fragment"}
+		}
+		switch strings.ToLower(parsed.Scheme) {
+		case "javascript", "data", "vbscript":
+			return invalidInputError{"redirect_uris must not use the " + parsed.Scheme + " scheme"}
+		}
+	}
+	return nil
+}
+
+type mastodonApp struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	Website      string `json:"website"`
+	RedirectURI  string `json:"redirect_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	VapidKey     string `json:"vapid_key"`
+}
+
+// Registers a client application. Anyone may register one; the client ID and secret
+// only let it ask users to sign in.
+func (cfg *apiConfig) handlerMastodonCreateApp(w http.ResponseWriter, r *http.Request) {
+	params, ok := readMastodonParams(w, r)
+	if !ok {
+		return
+	}
+	name := strings.TrimSpace(params.Get("client_name"))
+	if name == "" {
+		mastodonError(w, 422, "Validation failed: client_name can't be blank")
+		return
+	}
+	// several may be given as an array or one per line
+	redirectURIs := strings.Fields(strings.Join(params["redirect_uris"], "\n"))
+	err := validRedirectURIs(redirectURIs)
+	if err != nil {
+		mastodonError(w, 422, "Validation failed: "+err.Error())
+		return
+	}
+	scopes := strings.Join(strings.Fields(params.Get("scopes")), " ")
+	if scopes == "" {
+		scopes = "read"
+	}
+	clientID, err := auth.MakeRefreshToken()
+	if err != nil {
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	clientSecret, err := auth.MakeRefreshToken()
+	if err != nil {
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	app, err := cfg.store.CreateOAuthApp(r.Context(), database.CreateOAuthAppParams{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Name:         name,
+		Website:      params.Get("website"),
+		RedirectUris: strings.Join(redirectURIs, "\n"),
+		Scopes:       scopes,
+	})
+	if err != nil {
+		log.Printf("Error creating OAuth app: %s", err.Error())
+		mastodonError(w, 500, err.Error())
+		return
+	}
+	writeMastodon(w, 200, mastodonApp{
+		ID:           app.ID.String(),
+		Name:         app.Name,
+		Website:      app.Website,
+		RedirectURI:  app.RedirectUris,
+		ClientID:     app.ClientID,
+		ClientSecret: app.ClientSecret,
+	})
+}
+
+// An authorization request, read from the query of GET /oauth/authorize or the form
+// the page posts back
+type oauthAuthorization struct {
+	app         database.OauthApp
+	redirectURI string
+	scope       string
+	state       string
+}
+
+// Checks an authorization request against the app it names. Its errors are shown to
+// the user rather than sent to redirect_uri, which may not belong to the app.
+func (cfg *apiConfig) readAuthorization(r *http.Request, params url.Values) (oauthAuthorization, error) {
+	if responseType := params.Get("response_type"); responseType != "" && responseType != "code" {
+		return oauthAuthorization{}, invalidInputError{"Only the code response type is supported."}
+	}
+	app, err := cfg.store.GetOAuthApp(r.Context(), params.Get("client_id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return oauthAuthorization{}, invalidInputError{"The client is not registered."}
+	}
+	if err != nil {
+		return oauthAuthorization{}, err
+	}
+	redirectURI := params.Get("redirect_uri")
+	if !slices.Contains(strings.Split(app.RedirectUris, "\n"), redirectURI) {
+		return oauthAuthorization{}, invalidInputError{"The redirect URI is not registered for this client."}
+	}
+	scope, ok := oauthScope(app, params.Get("scope"))
+	if !ok {
+		return oauthAuthorization{}, invalidInputError{"The client asked for scopes it was not registered with."}
+	}
+	return oauthAuthorization{app: app, redirectURI: redirectURI, scope: scope, state: params.Get("state")}, nil
+}
+
+type authorizePage struct {
+	App         string
+	ClientID    string
+	RedirectURI string
+	Scope       string
+	State       string
+	Error       string
+	Code        string
+}
+
+var oauthAuthorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Sign in - Chirpy</title>
+</head>
+<body>
+  <h1>Sign in to Chirpy</h1>
+  {{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+  {{if .Code}}
+  <p>Copy this code into {{.App}} to finish signing in:</p>
+  <p><code>{{.Code}}</code></p>
+  {{else if .ClientID}}
+  <p><strong>{{.App}}</strong> wants to use your account ({{.Scope}}).</p>
+  <form method="post" action="/oauth/authorize">
+    <input type="hidden" name="client_id" value="{{.ClientID}}">
+    <input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+    <input type="hidden" name="scope" value="{{.Scope}}">
+    <input type="hidden" name="state" value="{{.State}}">
+    <p><label>Email <input type="email" name="email" autocomplete="username" required></label></p>
+    <p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+    <p><button type="submit">Authorize</button></p>
+  </form>
+  {{end}}
+</body>
+</html>
+`))
+
+func writeAuthorizePage(w http.ResponseWriter, code int, page authorizePage) {
+	var buf bytes.Buffer
+	err := oauthAuthorizePage.Execute(&buf, page)
+	if err != nil {
+		log.Printf("Error rendering the authorize page: %s", err.Error())
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	// the password form must not be framed by another site
+	w.Header().Set("X-Frame-Options", "DENY")
+	writeBody(w, code, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func (a oauthAuthorization) page() authorizePage {
+	return authorizePage{App: a.app.Name, ClientID: a.app.ClientID, RedirectURI: a.redirectURI, Scope: a.scope, State: a.state}
+}
+
+// Shows the sign-in form for an authorization request
+func (cfg *apiConfig) handlerOAuthAuthorizeForm(w http.ResponseWriter, r *http.Request) {
+	authorization, err := cfg.readAuthorization(r, r.URL.Query())
+	var invalid invalidInputError
+	if errors.As(err, &invalid) {
+		writeAuthorizePage(w, 400, authorizePage{Error: invalid.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading authorization request: %s", err.Error())
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	writeAuthorizePage(w, 200, authorization.page())
+}
+
+// Signs the user in and sends them back to the client with an authorization code
+func (cfg *apiConfig) handlerOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	params, ok := readMastodonParams(w, r)
+	if !ok {
+		return
+	}
+	authorization, err := cfg.readAuthorization(r, params)
+	var invalid invalidInputError
+	if errors.As(err, &invalid) {
+		writeAuthorizePage(w, 400, authorizePage{Error: invalid.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading authorization request: %s", err.Error())
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	user, err := cfg.checkCredentials(ctx, params.Get("email"), params.Get("password"))
+	if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidPassword) {
+		page := authorization.page()
+		page.Error = "Incorrect email or password."
+		writeAuthorizePage(w, 401, page)
+		return
+	}
+	if err != nil {
+		log.Printf("Error checking credentials: %s", err.Error())
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	now := time.Now().UTC()
+	// codes that were never exchanged are cleaned up as new ones are handed out
+	_, err = cfg.store.DeleteExpiredOAuthCodes(ctx, now)
+	if err != nil {
+		log.Printf("Error deleting expired OAuth codes: %s", err.Error())
+	}
+	err = cfg.store.CreateOAuthCode(ctx, database.CreateOAuthCodeParams{
+		CodeHash:    hashOAuthSecret(code),
+		ExpiresAt:   now.Add(oauthCodeTTL),
+		UserID:      user.ID,
+		ClientID:    authorization.app.ClientID,
+		RedirectUri: authorization.redirectURI,
+		Scopes:      authorization.scope,
+	})
+	if err != nil {
+		log.Printf("Error creating OAuth code: %s", err.Error())
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	if authorization.redirectURI == oauthOutOfBand {
+		page := authorization.page()
+		page.Code = code
+		writeAuthorizePage(w, 200, page)
+		return
+	}
+	target, err := url.Parse(authorization.redirectURI)
+	if err != nil {
+		http.Error(w, "Internal Server Error", 500)
+		return
+	}
+	query := target.Query()
+	query.Set("code", code)
+	if authorization.state != "" {
+		query.Set("state", authorization.state)
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// Reads the client of a token request from client_id and client_secret, writing a 401
+// if they do not match a registered app
+func (cfg *apiConfig) oauthClient(w http.ResponseWriter, r *http.Request, params url.Values) (database.OauthApp, bool) {
+	app, err := cfg.store.GetOAuthApp(r.Context(), params.Get("client_id"))
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && subtle.ConstantTimeCompare([]byte(app.ClientSecret), []byte(params.Get("client_secret"))) != 1) {
+		oauthError(w, 401, "invalid_client", "Client authentication failed.")
+		return database.OauthApp{}, false
+	}
+	if err != nil {
+		log.Printf("Error getting OAuth app: %s", err.Error())
+		oauthError(w, 500, "server_error", err.Error())
+		return database.OauthApp{}, false
+	}
+	return app, true
+}
+
+// Issues an access token, either for an authorization code or directly for the
+// user's email and password
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	params, ok := readMastodonParams(w, r)
+	if !ok {
+		return
+	}
+	app, ok := cfg.oauthClient(w, r, params)
+	if !ok {
+		return
+	}
+	var userID uuid.UUID
+	var scope string
+	switch params.Get("grant_type") {
+	case "authorization_code":
+		// the code is used up even when the rest of the request is wrong
+		code, err := cfg.store.ConsumeOAuthCode(ctx, database.ConsumeOAuthCodeParams{CodeHash: hashOAuthSecret(params.Get("code")), ExpiresAt: time.Now().UTC()})
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && (code.ClientID != app.ClientID || code.RedirectUri != params.Get("redirect_uri"))) {
+			oauthError(w, 400, "invalid_grant", "The authorization code is invalid, expired or was issued to another client.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error exchanging OAuth code: %s", err.Error())
+			oauthError(w, 500, "server_error", err.Error())
+			return
+		}
+		userID, scope = code.UserID, code.Scopes
+	case "password":
+		scope, ok = oauthScope(app, params.Get("scope"))
+		if !ok {
+			oauthError(w, 400, "invalid_scope", "The client asked for scopes it was not registered with.")
+			return
+		}
+		user, err := cfg.checkCredentials(ctx, params.Get("username"), params.Get("password"))
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errInvalidPassword) {
+			oauthError(w, 400, "invalid_grant", "Incorrect email or password.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking credentials: %s", err.Error())
+			oauthError(w, 500, "server_error", err.Error())
+			return
+		}
+		userID = user.ID
+	default:
+		oauthError(w, 400, "unsupported_grant_type", "Only the authorization_code and password grants are supported.")
+		return
+	}
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		oauthError(w, 500, "server_error", err.Error())
+		return
+	}
+	err = cfg.store.CreateOAuthToken(ctx, database.CreateOAuthTokenParams{
+		TokenHash: hashOAuthSecret(token),
+		UserID:    userID,
+		ClientID:  app.ClientID,
+		Scopes:    scope,
+	})
+	if err != nil {
+		log.Printf("Error issuing OAuth token: %s", err.Error())
+		oauthError(w, 500, "server_error", err.Error())
+		return
+	}
+	writeMastodon(w, 200, map[string]any{
+		"access_token": token,
+		"token_type":   "Bearer",
+		"scope":        scope,
+		"created_at":   time.Now().Unix(),
+	})
+}
+
+// Revokes an access token the client was issued
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	params, ok := readMastodonParams(w, r)
+	if !ok {
+		return
+	}
+	app, ok := cfg.oauthClient(w, r, params)
+	if !ok {
+		return
+	}
+	err := cfg.store.RevokeOAuthToken(r.Context(), database.RevokeOAuthTokenParams{TokenHash: hashOAuthSecret(params.Get("token")), ClientID: app.ClientID})
+	if err != nil {
+		log.Printf("Error revoking OAuth token: %s", err.Error())
+		oauthError(w, 500, "server_error", err.Error())
+		return
+	}
+	writeMastodon(w, 200, map[string]any{})
+}
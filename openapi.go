@@ -0,0 +1,354 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Documentation for an /api route, keyed in apiDocs by the pattern given to handleAPI.
+// Request and response values are only used for their types; the schemas are derived
+// from their json tags.
+type apiOperation struct {
+	Summary   string
+	Auth      string // "", "bearer" (access or refresh token), "polka" or "bearer,apiKey" for either
+	Query     []apiParam
+	Request   any
+	Responses map[int]any // nil value means no body
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+}
+
+var apiDocs = map[string]apiOperation{
+	"GET /healthz": {Summary: "Liveness probe", Responses: map[int]any{200: ""}},
+	"GET /readyz":  {Summary: "Readiness probe with per-component status", Responses: map[int]any{200: map[string]any{}, 503: map[string]any{}}},
+	"POST /chirps": {
+		Summary:   "Post a chirp of at most 140 characters",
+		Auth:      "bearer",
+		Request:   createChirpRequest{},
+		Responses: map[int]any{201: Chirp{}, 400: apiErrorResponse{}, 401: apiErrorResponse{}, 403: apiErrorResponse{}},
+	},
+	"GET /chirps": {
+		Summary: "List chirps, oldest first",
+		Query: []apiParam{
+			{"author_id", "Only chirps by this user"},
+			{"sort", "asc (default) or desc"},
+			{"page", "Return only this page, numbered from 1. Link headers point to the first, previous, next and last pages"},
+			{"per_page", "Chirps per page, 1 to 100 (default 20)"},
+		},
+		Responses: map[int]any{200: []Chirp{}, 304: nil, 401: apiErrorResponse{}},
+	},
+	"GET /chirps/{chirpID}":    {Summary: "Get one chirp", Responses: map[int]any{200: Chirp{}, 304: nil, 401: apiErrorResponse{}, 404: apiErrorResponse{}}},
+	"DELETE /chirps/{chirpID}": {Summary: "Delete one of your chirps", Auth: "bearer", Responses: map[int]any{204: nil, 403: apiErrorResponse{}, 404: apiErrorResponse{}}},
+	"POST /chirps/{chirpID}/reports": {
+		Summary:   "Report someone else's chirp to the moderators, once until they decide on it",
+		Auth:      "bearer",
+		Request:   reportChirpRequest{},
+		Responses: map[int]any{201: chirpReportResponse{}, 400: apiErrorResponse{}, 401: apiErrorResponse{}, 404: apiErrorResponse{}, 409: apiErrorResponse{}},
+	},
+	"GET /flags":  {Summary: "Feature flags that are on for the caller", Responses: map[int]any{200: map[string]bool{}}},
+	"POST /users": {Summary: "Register an account", Request: credentials{}, Responses: map[int]any{201: User{}}},
+	"POST /login": {Summary: "Log in and receive an access and refresh token", Request: loginRequest{}, Responses: map[int]any{200: User{}, 401: apiErrorResponse{}}},
+	"PUT /users":  {Summary: "Change your email and password", Auth: "bearer", Request: credentials{}, Responses: map[int]any{200: User{}, 401: apiErrorResponse{}}},
+	"GET /users/me/quota": {
+		Summary:   "Your limit and usage of each quota in the current window, raised for Chirpy Red",
+		Auth:      "bearer",
+		Responses: map[int]any{200: quotaResponse{}, 401: apiErrorResponse{}},
+	},
+	"GET /users/me/digest": {
+		Summary:   "Whether you get the weekly digest email, and when the chirps the next one lists start",
+		Auth:      "bearer",
+		Responses: map[int]any{200: digestResponse{}, 401: apiErrorResponse{}},
+	},
+	"PUT /users/me/digest": {
+		Summary:   "Get the weekly digest email of new chirps. Subscribing again changes nothing.",
+		Auth:      "bearer",
+		Responses: map[int]any{200: digestResponse{}, 401: apiErrorResponse{}},
+	},
+	"DELETE /users/me/digest": {
+		Summary:   "Stop the weekly digest email",
+		Auth:      "bearer",
+		Responses: map[int]any{204: nil, 401: apiErrorResponse{}},
+	},
+	"POST /import/twitter": {
+		Summary:   "Import the tweets of a Twitter/X archive zip, sent as the body or the archive field of a multipart form, as chirps in the background. Replies are left out unless include_replies is true.",
+		Auth:      "bearer",
+		Query:     []apiParam{{"include_replies", "Import replies too (default false)"}},
+		Responses: map[int]any{202: jobResponse{}, 400: apiErrorResponse{}, 401: apiErrorResponse{}, 403: apiErrorResponse{}, 413: apiErrorResponse{}},
+	},
+	"GET /import/twitter/{jobID}": {
+		Summary:   "Progress of one of your Twitter/X imports",
+		Auth:      "bearer",
+		Responses: map[int]any{200: jobResponse{}, 401: apiErrorResponse{}, 404: apiErrorResponse{}},
+	},
+	"POST /polka/webhooks": {
+		Summary: "Payment provider callback that upgrades a user to Chirpy Red",
+		Auth:    "polka",
+		Request: struct {
+			Event string `json:"event"`
+			Data  struct {
+				UserID string `json:"user_id"`
+			} `json:"data"`
+		}{},
+		Responses: map[int]any{204: nil, 401: apiErrorResponse{}, 404: apiErrorResponse{}},
+	},
+	"POST /api-keys": {
+		Summary: "Issue yourself a key for reading the API without signing in. The key is only shown in this response.",
+		Auth:    "bearer",
+		Request: struct {
+			Name string `json:"name"`
+		}{},
+		Responses: map[int]any{201: apiKeyResponse{}, 400: apiErrorResponse{}, 401: apiErrorResponse{}, 403: apiErrorResponse{}, 409: apiErrorResponse{}},
+	},
+	"GET /api-keys":            {Summary: "List your API keys", Auth: "bearer", Responses: map[int]any{200: []apiKeyResponse{}, 401: apiErrorResponse{}}},
+	"DELETE /api-keys/{keyID}": {Summary: "Revoke one of your API keys", Auth: "bearer", Responses: map[int]any{204: nil, 401: apiErrorResponse{}, 404: apiErrorResponse{}}},
+	"GET /usage": {
+		Summary:   "Limits and daily usage of the API key the request is made with, or of all your keys with an access token",
+		Auth:      "bearer,apiKey",
+		Responses: map[int]any{200: apiUsageResponse{}, 401: apiErrorResponse{}},
+	},
+	"POST /webhooks": {
+		Summary:   "Subscribe a URL to events, with a generated signing secret unless one is given",
+		Auth:      "bearer",
+		Request:   webhookRequest{},
+		Responses: map[int]any{201: webhookResponse{}, 400: apiErrorResponse{}, 409: apiErrorResponse{}},
+	},
+	"GET /webhooks":             {Summary: "List your webhooks", Auth: "bearer", Responses: map[int]any{200: []webhookResponse{}}},
+	"GET /webhooks/events":      {Summary: "The event types webhooks can subscribe to, with the schema of each", Responses: map[int]any{200: []webhookEventTypeResponse{}}},
+	"GET /webhooks/{webhookID}": {Summary: "Get one of your webhooks", Auth: "bearer", Responses: map[int]any{200: webhookResponse{}, 404: apiErrorResponse{}}},
+	"PUT /webhooks/{webhookID}": {
+		Summary:   "Replace the URL, events and active flag of one of your webhooks, and optionally its secret",
+		Auth:      "bearer",
+		Request:   webhookRequest{},
+		Responses: map[int]any{200: webhookResponse{}, 400: apiErrorResponse{}, 404: apiErrorResponse{}},
+	},
+	"DELETE /webhooks/{webhookID}": {Summary: "Delete one of your webhooks", Auth: "bearer", Responses: map[int]any{204: nil, 404: apiErrorResponse{}}},
+	"POST /webhooks/{webhookID}/test": {
+		Summary:   "Send a webhook.test event to one of your webhooks now and report the response",
+		Auth:      "bearer",
+		Responses: map[int]any{200: webhookTestResponse{}, 404: apiErrorResponse{}},
+	},
+	"GET /webhooks/{webhookID}/deliveries": {
+		Summary:   "Recent delivery attempts for one of your webhooks, newest first",
+		Auth:      "bearer",
+		Query:     []apiParam{{"limit", "At most this many deliveries, 1 to 500 (default 50)"}},
+		Responses: map[int]any{200: []webhookDeliveryResponse{}, 404: apiErrorResponse{}},
+	},
+	"POST /refresh": {Summary: "Exchange a refresh token for a new access token", Auth: "bearer", Responses: map[int]any{200: struct {
+		Token string `json:"token"`
+	}{}, 401: apiErrorResponse{}}},
+	"POST /revoke": {Summary: "Revoke a refresh token", Auth: "bearer", Responses: map[int]any{204: nil, 401: apiErrorResponse{}}},
+	"POST /graphql": {
+		Summary:   "Run a GraphQL query over users and chirps. The access token is optional and only identifies \"me\".",
+		Auth:      "bearer",
+		Request:   graphqlRequest{},
+		Responses: map[int]any{200: map[string]any{}, 401: apiErrorResponse{}},
+	},
+	"GET /openapi.json": {Summary: "This document", Responses: map[int]any{200: map[string]any{}}},
+	"GET /docs":         {Summary: "Interactive documentation", Responses: map[int]any{200: ""}},
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}]+)\}`)
+
+// Builds the OpenAPI 3 document for every route registered with handleAPI. Paths are
+// relative to the /api/v1 server; the unversioned aliases are not listed separately.
+func (cfg *apiConfig) openAPISpec() map[string]any {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+	for _, pattern := range cfg.apiRoutes {
+		method, path, _ := strings.Cut(pattern, " ")
+		doc, ok := apiDocs[pattern]
+		if !ok {
+			log.Printf("No OpenAPI documentation for %s", pattern)
+		}
+		op := map[string]any{"summary": doc.Summary}
+		var params []map[string]any
+		for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+			params = append(params, map[string]any{
+				"name": match[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": "string", "format": "uuid"},
+			})
+		}
+		for _, q := range doc.Query {
+			params = append(params, map[string]any{
+				"name": q.Name, "in": "query", "description": q.Description,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		switch doc.Auth {
+		case "bearer":
+			op["security"] = []map[string][]string{{"bearerAuth": {}}}
+		case "polka":
+			op["security"] = []map[string][]string{{"polkaKey": {}}}
+		case "bearer,apiKey":
+			op["security"] = []map[string][]string{{"bearerAuth": {}}, {"apiKey": {}}}
+		}
+		if doc.Request != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{"application/json": map[string]any{"schema": schemaFor(reflect.TypeOf(doc.Request), schemas)}},
+			}
+		}
+		responses := map[string]any{}
+		for code, body := range doc.Responses {
+			resp := map[string]any{"description": http.StatusText(code)}
+			if body != nil {
+				contentType := "application/json"
+				if _, isText := body.(string); isText {
+					contentType = "text/plain"
+				}
+				resp["content"] = map[string]any{contentType: map[string]any{"schema": schemaFor(reflect.TypeOf(body), schemas)}}
+			}
+			responses[strconv.Itoa(code)] = resp
+		}
+		if len(responses) == 0 {
+			responses["default"] = map[string]any{"description": "Response"}
+		}
+		op["responses"] = responses
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(method)] = op
+	}
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Chirpy API",
+			"version": strconv.Itoa(apiVersions[len(apiVersions)-1]),
+		},
+		"servers": []map[string]string{{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+				"polkaKey":   map[string]string{"type": "apiKey", "in": "header", "name": "Authorization", "description": "ApiKey <key>"},
+				"apiKey":     map[string]string{"type": "apiKey", "in": "header", "name": "Authorization", "description": "ApiKey chirpy_<key>, for GET requests only"},
+			},
+		},
+	}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	uuidType = reflect.TypeOf(uuid.UUID{})
+	rawType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// Returns the JSON schema for t. Named structs are added to schemas once and referenced,
+// anonymous ones are inlined.
+func schemaFor(t reflect.Type, schemas map[string]any) map[string]any {
+	switch t {
+	case timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]any{"type": "string", "format": "uuid"}
+	case rawType:
+		return map[string]any{"type": "object"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := schemaFor(t.Elem(), schemas)
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem(), schemas)}
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.Struct:
+		if t.Name() != "" {
+			if _, ok := schemas[t.Name()]; !ok {
+				// reserve the name first so self-referencing types terminate
+				schemas[t.Name()] = map[string]any{}
+				schemas[t.Name()] = structSchema(t, schemas)
+			}
+			return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		}
+		return structSchema(t, schemas)
+	}
+	return map[string]any{}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		schema := schemaFor(field.Type, schemas)
+		if strings.Contains(opts, "omitempty") {
+			schema["description"] = "omitted when empty"
+		}
+		properties[name] = schema
+	}
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// Serves the generated OpenAPI document
+func (cfg *apiConfig) handlerOpenAPI(w http.ResponseWriter, r *http.Request) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	dat, err := encodeJSON(buf, cfg.openAPISpec())
+	if err != nil {
+		log.Printf("Error marshalling response body: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	writeBody(w, 200, "application/json", dat)
+}
+
+// Swagger UI is loaded from a CDN so its bundle does not have to ship in the binary
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Chirpy API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/api/openapi.json", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`
+
+// Serves the interactive documentation page for the OpenAPI document
+func handlerSwaggerUI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(200)
+	w.Write([]byte(swaggerUIPage))
+}
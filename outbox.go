@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/diamondoughnut/httpChirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+// outbox events read per query by the relay
+const outboxBatchSize = 100
+
+// Runs change in a transaction together with recording its event in the outbox, so the
+// event exists exactly when the change committed. change returns the event's data.
+func (cfg *apiConfig) changeWithEvent(ctx context.Context, event string, change func(tx store.Store) (any, error)) error {
+	err := cfg.store.WithTx(ctx, func(tx store.Store) error {
+		data, err := change(tx)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("encoding %s event: %w", event, err)
+		}
+		_, err = tx.CreateOutboxEvent(ctx, database.CreateOutboxEventParams{TenantID: tenantID(ctx), Event: event, Payload: string(payload)})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// the relay only sees the event once the transaction has committed
+	select {
+	case cfg.outboxWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Publishes unpublished outbox events to webhooks and ActivityPub followers, oldest first, and returns how many
+// it published. Each event is marked published in the transaction that enqueues its
+// deliveries, so an event is never lost or published twice, even with a relay running
+// on every instance. It stops at the first event that fails, to keep events in order.
+func (cfg *apiConfig) relayOutbox(ctx context.Context) (int, error) {
+	if cfg.webhooks == nil {
+		return 0, nil
+	}
+	published := 0
+	for {
+		events, err := cfg.store.ListUnpublishedOutboxEvents(ctx, outboxBatchSize)
+		if err != nil {
+			return published, err
+		}
+		for _, event := range events {
+			claimed := false
+			err := cfg.store.WithTx(ctx, func(tx store.Store) error {
+				marked, err := tx.MarkOutboxEventPublished(ctx, event.ID)
+				if err != nil || marked == 0 {
+					// another instance published it since it was listed
+					return err
+				}
+				claimed = true
+				err = cfg.webhooks.PublishEvent(ctx, tx, event.TenantID, webhooks.Event{
+					ID:        event.ID,
+					Type:      event.Event,
+					CreatedAt: event.CreatedAt,
+					Data:      json.RawMessage(event.Payload),
+					ActorID:   eventActor(event.Payload),
+				})
+				if err != nil {
+					return err
+				}
+				return cfg.federateEvent(ctx, tx, event)
+			})
+			if err != nil {
+				return published, fmt.Errorf("relaying %s event %s: %w", event.Event, event.ID, err)
+			}
+			if claimed {
+				published++
+			}
+		}
+		if len(events) < outboxBatchSize {
+			return published, nil
+		}
+	}
+}
+
+// Relays outbox events as soon as this instance commits one, and every interval to pick
+// up events committed by other instances or left behind by a failed relay
+func (cfg *apiConfig) relayOutboxEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-cfg.outboxWake:
+		}
+		_, err := cfg.relayOutbox(ctx)
+		if err != nil {
+			log.Printf("Error relaying outbox events: %s", err.Error())
+		}
+	}
+}
+
+// Reads the user_id every chirp and user event carries, uuid.Nil when there is none
+func eventActor(payload string) uuid.UUID {
+	var data struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	_ = json.Unmarshal([]byte(payload), &data)
+	return data.UserID
+}
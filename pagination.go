@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Page size of paginated lists when only page is given
+const defaultPerPage = 20
+
+// Cuts page (numbered from 1) out of items and sets the headers of setPageHeaders.
+// Pages past the end are empty.
+func paginate[T any](w http.ResponseWriter, r *http.Request, items []T, page, perPage int) []T {
+	setPageHeaders(w, r, len(items), page, perPage)
+	start := pageStart(len(items), page, perPage)
+	return items[start:min(start+perPage, len(items))]
+}
+
+// Describes the pages around page of a list of total items in an RFC 8288 Link header,
+// so generic clients can walk the collection. The total is also sent as X-Total-Count.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, total, page, perPage int) {
+	last := lastPage(total, perPage)
+	links := []string{pageLink(r, 1, perPage, "first")}
+	if page > 1 {
+		links = append(links, pageLink(r, min(page-1, last), perPage, "prev"))
+	}
+	if page < last {
+		links = append(links, pageLink(r, page+1, perPage, "next"))
+	}
+	links = append(links, pageLink(r, last, perPage, "last"))
+	w.Header().Set("Link", strings.Join(links, ", "))
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+}
+
+// Offset of the first item of page in a list of total items, which is total for pages
+// past the end
+func pageStart(total, page, perPage int) int {
+	return min(min(page-1, lastPage(total, perPage))*perPage, total)
+}
+
+func lastPage(total, perPage int) int {
+	return max(1, (total+perPage-1)/perPage)
+}
+
+// Helper function to build one Link entry pointing at the request's own path with the
+// page parameters replaced. The reference is relative, so it holds behind proxies too.
+func pageLink(r *http.Request, page, perPage int, rel string) string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(page))
+	query.Set("per_page", strconv.Itoa(perPage))
+	return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, query.Encode(), rel)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+)
+
+const (
+	statsPeriodDay  = "day"
+	statsPeriodWeek = "week"
+	// how far back the rollup recomputes periods that were not final yet. Deletions and
+	// Chirpy Red upgrades are counted from the outbox, which keeps a week by default.
+	statsRollupBackfill = 7 * 24 * time.Hour
+)
+
+type statsRollupResponse struct {
+	StartsAt       time.Time `json:"starts_at"`
+	EndsAt         time.Time `json:"ends_at"`
+	NewUsers       int64     `json:"new_users"`
+	ActiveUsers    int64     `json:"active_users"`
+	ChirpsPosted   int64     `json:"chirps_posted"`
+	ChirpsDeleted  int64     `json:"chirps_deleted"`
+	RedConversions int64     `json:"red_conversions"`
+	Final          bool      `json:"final"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func newStatsRollupResponse(rollup database.StatsRollup) statsRollupResponse {
+	return statsRollupResponse{
+		StartsAt:       rollup.StartsAt,
+		EndsAt:         rollup.EndsAt,
+		NewUsers:       rollup.NewUsers,
+		ActiveUsers:    rollup.ActiveUsers,
+		ChirpsPosted:   rollup.ChirpsPosted,
+		ChirpsDeleted:  rollup.ChirpsDeleted,
+		RedConversions: rollup.RedConversions,
+		Final:          statsRollupFinal(rollup),
+		UpdatedAt:      rollup.UpdatedAt,
+	}
+}
+
+// A rollup computed after its period ended never changes again
+func statsRollupFinal(rollup database.StatsRollup) bool {
+	return !rollup.UpdatedAt.Before(rollup.EndsAt)
+}
+
+// Returns the start of the day or ISO week (starting Monday) containing t, in UTC
+func statsPeriodStart(period string, t time.Time) time.Time {
+	day := t.UTC().Truncate(24 * time.Hour)
+	if period == statsPeriodWeek {
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return day
+}
+
+func statsPeriodNext(period string, start time.Time) time.Time {
+	if period == statsPeriodWeek {
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Computes the daily and weekly rollups of the backfill window that are missing or
+// were computed before their period ended, up to the current day and week
+func (cfg *apiConfig) rollupStats(ctx context.Context, now time.Time) (int, error) {
+	updated := 0
+	for _, period := range []string{statsPeriodDay, statsPeriodWeek} {
+		from := statsPeriodStart(period, now.Add(-statsRollupBackfill))
+		until := statsPeriodNext(period, statsPeriodStart(period, now))
+		existing, err := cfg.store.ListStatsRollups(ctx, database.ListStatsRollupsParams{Period: period, Since: from, Until: until})
+		if err != nil {
+			return updated, err
+		}
+		final := make(map[time.Time]bool, len(existing))
+		for _, rollup := range existing {
+			final[rollup.StartsAt.UTC()] = statsRollupFinal(rollup)
+		}
+		for start := from; start.Before(until); start = statsPeriodNext(period, start) {
+			if final[start] {
+				continue
+			}
+			end := statsPeriodNext(period, start)
+			counts, err := cfg.store.GetStatsRollupCounts(ctx, database.GetStatsRollupCountsParams{Since: start, Until: end})
+			if err != nil {
+				return updated, err
+			}
+			_, err = cfg.store.UpsertStatsRollup(ctx, database.UpsertStatsRollupParams{
+				Period:         period,
+				StartsAt:       start,
+				EndsAt:         end,
+				NewUsers:       counts.NewUsers,
+				ActiveUsers:    counts.ActiveUsers,
+				ChirpsPosted:   counts.ChirpsPosted,
+				ChirpsDeleted:  counts.ChirpsDeleted,
+				RedConversions: counts.RedConversions,
+			})
+			if err != nil {
+				return updated, err
+			}
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+// Daily or weekly platform stats, read from the rollups so the request never
+// aggregates the underlying tables. Also answers as CSV.
+func (cfg *apiConfig) handlerPlatformStats(w http.ResponseWriter, r *http.Request) {
+	type query struct {
+		Period string    `query:"period" validate:"oneof=day week"`
+		Since  time.Time `query:"since"`
+		Until  time.Time `query:"until"`
+	}
+	params := query{Period: statsPeriodDay}
+	err := decodeQuery(r, &params)
+	if err != nil {
+		marshallError(w, err, 400)
+		return
+	}
+	now := time.Now().UTC()
+	if params.Until.IsZero() {
+		params.Until = now
+	}
+	if params.Since.IsZero() {
+		// the last 30 days or 12 weeks, including the current one
+		if params.Period == statsPeriodWeek {
+			params.Since = statsPeriodStart(statsPeriodWeek, params.Until).AddDate(0, 0, -7*11)
+		} else {
+			params.Since = statsPeriodStart(statsPeriodDay, params.Until).AddDate(0, 0, -29)
+		}
+	}
+	if !params.Since.Before(params.Until) {
+		marshallError(w, invalidInputError{"since must be before until"}, 400)
+		return
+	}
+	rollups, err := cfg.store.ListStatsRollups(r.Context(), database.ListStatsRollupsParams{
+		Period: params.Period,
+		Since:  params.Since.UTC(),
+		Until:  params.Until.UTC(),
+	})
+	if err != nil {
+		log.Printf("Error listing stats rollups: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if wantsCSV(r) {
+		export := newCSVExport(w, "chirpy-stats-"+params.Period, "starts_at", "ends_at", "new_users", "active_users", "chirps_posted", "chirps_deleted", "red_conversions", "final", "updated_at")
+		for _, rollup := range rollups {
+			export.Write(
+				csvTime(rollup.StartsAt),
+				csvTime(rollup.EndsAt),
+				strconv.FormatInt(rollup.NewUsers, 10),
+				strconv.FormatInt(rollup.ActiveUsers, 10),
+				strconv.FormatInt(rollup.ChirpsPosted, 10),
+				strconv.FormatInt(rollup.ChirpsDeleted, 10),
+				strconv.FormatInt(rollup.RedConversions, 10),
+				strconv.FormatBool(statsRollupFinal(rollup)),
+				csvTime(rollup.UpdatedAt),
+			)
+		}
+		err = export.Close()
+		if err != nil {
+			log.Printf("Error writing stats CSV: %s", err.Error())
+		}
+		return
+	}
+	resp := struct {
+		Period  string                `json:"period"`
+		Since   time.Time             `json:"since"`
+		Until   time.Time             `json:"until"`
+		Rollups []statsRollupResponse `json:"rollups"`
+	}{Period: params.Period, Since: params.Since, Until: params.Until, Rollups: make([]statsRollupResponse, 0, len(rollups))}
+	for _, rollup := range rollups {
+		resp.Rollups = append(resp.Rollups, newStatsRollupResponse(rollup))
+	}
+	render(w, r, 200, resp)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/google/uuid"
+)
+
+// Quotas, by the name they are counted and reported under
+const (
+	quotaChirps         = "chirps_per_day"
+	quotaAPIRequests    = "api_requests_per_hour"
+	quotaAPIKeyRequests = "api_key_requests_per_day"
+)
+
+// How long each quota's window is. Windows start at whole hours and days in UTC.
+var quotaWindows = map[string]time.Duration{
+	quotaChirps:         24 * time.Hour,
+	quotaAPIRequests:    time.Hour,
+	quotaAPIKeyRequests: 24 * time.Hour,
+}
+
+// Per-user limits for one window of each quota, 0 for no limit. Chirpy Red members get
+// RedMultiplier times as much. APIKeyRequestsPerDay limits each API key instead, and is
+// not raised for Chirpy Red.
+type quotaLimits struct {
+	ChirpsPerDay         int64 `json:"chirps_per_day"`
+	APIRequestsPerHour   int64 `json:"api_requests_per_hour"`
+	APIKeyRequestsPerDay int64 `json:"api_key_requests_per_day"`
+	RedMultiplier        int64 `json:"red_multiplier"`
+}
+
+// Reads the quota limits from the environment for loadRuntimeSettings
+func loadQuotaLimits() (quotaLimits, error) {
+	var limits quotaLimits
+	for _, setting := range []struct {
+		env      string
+		fallback string
+		value    *int64
+	}{
+		{"QUOTA_CHIRPS_PER_DAY", "1000", &limits.ChirpsPerDay},
+		{"QUOTA_API_REQUESTS_PER_HOUR", "0", &limits.APIRequestsPerHour},
+		{"QUOTA_API_KEY_REQUESTS_PER_DAY", "10000", &limits.APIKeyRequestsPerDay},
+		{"QUOTA_RED_MULTIPLIER", "10", &limits.RedMultiplier},
+	} {
+		n, err := strconv.ParseInt(getEnvDefault(setting.env, setting.fallback), 10, 64)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("invalid %s: must be a whole number of at least 0", setting.env)
+		}
+		*setting.value = n
+	}
+	limits.RedMultiplier = max(limits.RedMultiplier, 1)
+	return limits, nil
+}
+
+// The limit of a quota for a member or non-member of Chirpy Red
+func (l quotaLimits) limit(quota string, red bool) int64 {
+	limit := l.ChirpsPerDay
+	switch quota {
+	case quotaAPIRequests:
+		limit = l.APIRequestsPerHour
+	case quotaAPIKeyRequests:
+		limit = l.APIKeyRequestsPerDay
+	}
+	if red {
+		limit *= l.RedMultiplier
+	}
+	return limit
+}
+
+// A user's use of one quota in the current window, as GET /api/users/me/quota shows it
+type quotaUsage struct {
+	Name string `json:"name"`
+	// 0 when there is no limit
+	Limit int64 `json:"limit"`
+	Used  int64 `json:"used"`
+	// null when there is no limit
+	Remaining *int64    `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+func newQuotaUsage(quota string, limit, used int64, windowStart time.Time) quotaUsage {
+	usage := quotaUsage{Name: quota, Limit: limit, Used: used, ResetsAt: windowStart.Add(quotaWindows[quota])}
+	if limit > 0 {
+		remaining := max(limit-used, 0)
+		usage.Remaining = &remaining
+	}
+	return usage
+}
+
+// Response of GET /api/users/me/quota
+type quotaResponse struct {
+	IsChirpyRed bool         `json:"is_chirpy_red"`
+	Quotas      []quotaUsage `json:"quotas"`
+}
+
+// Returned when a user has used up a quota for the current window
+type quotaExceededError struct {
+	usage quotaUsage
+}
+
+func (e *quotaExceededError) Error() string {
+	return fmt.Sprintf("%s quota of %d is used up until %s", e.usage.Name, e.usage.Limit, e.usage.ResetsAt.Format(time.RFC3339))
+}
+
+// Unwraps to the *apiError marshallError reports, with the usage as details
+func (e *quotaExceededError) Unwrap() error {
+	return &apiError{Code: "quota_exceeded", Message: e.Error(), Details: e.usage}
+}
+
+// Seconds until the quota resets, for Retry-After
+func (e *quotaExceededError) retryAfter() string {
+	return strconv.Itoa(int(time.Until(e.usage.ResetsAt).Seconds()) + 1)
+}
+
+// Counts one use of a quota through st, so it can share a transaction with what it
+// limits. Past the limit it returns a *quotaExceededError, which rolls that transaction
+// back with the use it counted. Whether the user has Chirpy Red is only looked up once
+// they are past the standard limit.
+func (cfg *apiConfig) takeQuota(ctx context.Context, st store.Store, userID uuid.UUID, quota string) (quotaUsage, error) {
+	limits := cfg.settings.Load().quotas
+	windowStart := time.Now().UTC().Truncate(quotaWindows[quota])
+	limit := limits.limit(quota, false)
+	if limit == 0 {
+		// nothing to enforce, so nothing is counted either
+		return newQuotaUsage(quota, 0, 0, windowStart), nil
+	}
+	used, err := st.AddQuotaUsage(ctx, database.AddQuotaUsageParams{UserID: userID, Quota: quota, WindowStart: windowStart, Used: 1})
+	if err != nil {
+		return quotaUsage{}, err
+	}
+	if used > limit {
+		red, err := cfg.isChirpyRed(ctx, st, userID)
+		if err != nil {
+			return quotaUsage{}, err
+		}
+		limit = limits.limit(quota, red)
+	}
+	usage := newQuotaUsage(quota, limit, used, windowStart)
+	if used > limit {
+		return usage, &quotaExceededError{usage}
+	}
+	return usage, nil
+}
+
+// Helper function to look up whether a user of the caller's tenant has Chirpy Red
+func (cfg *apiConfig) isChirpyRed(ctx context.Context, st store.Store, userID uuid.UUID) (bool, error) {
+	users, err := st.GetUsersByIds(ctx, database.GetUsersByIdsParams{TenantID: tenantID(ctx), Ids: userID.String()})
+	if err != nil || len(users) == 0 {
+		return false, err
+	}
+	return users[0].IsChirpyRed, nil
+}
+
+// Middleware that counts API requests made with an access token against the user's
+// hourly quota. Requests without a valid token are only limited per IP address.
+func (cfg *apiConfig) middlewareQuota(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") || cfg.settings.Load().quotas.APIRequestsPerHour == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userID, err := cfg.userFromHeader(r.Header)
+		if err != nil {
+			// the handler answers for a missing or invalid token
+			next.ServeHTTP(w, r)
+			return
+		}
+		usage, err := cfg.takeQuota(r.Context(), cfg.store, userID, quotaAPIRequests)
+		var exceeded *quotaExceededError
+		if errors.As(err, &exceeded) {
+			w.Header().Set("Retry-After", exceeded.retryAfter())
+			marshallError(w, err, 429)
+			return
+		}
+		if err != nil {
+			// fail open, like the rate limiter
+			log.Printf("Error counting API request quota: %s", err.Error())
+		} else {
+			w.Header().Set("X-Quota-Remaining", strconv.FormatInt(*usage.Remaining, 10))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Shows the caller's limit and usage of each quota in the current window
+func (cfg *apiConfig) handlerGetQuota(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	users, err := cfg.store.GetUsersByIds(r.Context(), database.GetUsersByIdsParams{TenantID: tenantID(r.Context()), Ids: userID.String()})
+	if err != nil {
+		log.Printf("Error getting user: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if len(users) == 0 {
+		marshallError(w, errOtherTenant, 403)
+		return
+	}
+	red := users[0].IsChirpyRed
+	limits := cfg.settings.Load().quotas
+	resp := quotaResponse{IsChirpyRed: red}
+	now := time.Now().UTC()
+	for _, quota := range []string{quotaChirps, quotaAPIRequests} {
+		windowStart := now.Truncate(quotaWindows[quota])
+		used, err := cfg.store.GetQuotaUsage(r.Context(), database.GetQuotaUsageParams{UserID: userID, Quota: quota, WindowStart: windowStart})
+		if err != nil {
+			log.Printf("Error getting quota usage: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		resp.Quotas = append(resp.Quotas, newQuotaUsage(quota, limits.limit(quota, red), used, windowStart))
+	}
+	render(w, r, 200, resp)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/ipfilter"
+	"github.com/diamondoughnut/httpChirpy/internal/profanity"
+	"github.com/diamondoughnut/httpChirpy/internal/ratelimit"
+	"github.com/joho/godotenv"
+)
+
+// Settings that can change while the server runs. Anything structural (database,
+// listen address, caches) still needs a restart.
+type runtimeSettings struct {
+	rateLimitBackend string
+	rateLimitRPS     float64
+	rateLimitBurst   int
+	rateLimiter      ratelimit.Limiter
+	rateLimitBan     rateLimitBanPolicy
+	profanity        *profanity.Matcher
+	quotas           quotaLimits
+	// sources turned away everywhere, and the only ones let into /admin/ when not empty
+	ipDenylist       *ipfilter.List
+	adminIPAllowlist *ipfilter.List
+	// each API key has a bucket of its own, on the rate limiter's backend
+	apiKeyRateLimitRPS   float64
+	apiKeyRateLimitBurst int
+	apiKeyRateLimiter    ratelimit.Limiter
+}
+
+// An address the rate limiter refuses After times within Window is banned for Duration,
+// never when After is 0
+type rateLimitBanPolicy struct {
+	After    int
+	Window   time.Duration
+	Duration time.Duration
+}
+
+type settingsSummary struct {
+	RateLimitRPS         float64     `json:"rate_limit_rps"`
+	RateLimitBurst       int         `json:"rate_limit_burst"`
+	RateLimitBan         string      `json:"rate_limit_ban"`
+	APIKeyRateLimitRPS   float64     `json:"api_key_rate_limit_rps"`
+	APIKeyRateLimitBurst int         `json:"api_key_rate_limit_burst"`
+	ProfanityWords       []string    `json:"profanity_words"`
+	Quotas               quotaLimits `json:"quotas"`
+	IPDenylist           []string    `json:"ip_denylist"`
+	AdminIPAllowlist     []string    `json:"admin_ip_allowlist"`
+}
+
+// The externally visible form of the settings, for responses and the audit log
+func (s *runtimeSettings) summary() settingsSummary {
+	return settingsSummary{
+		RateLimitRPS:         s.rateLimitRPS,
+		RateLimitBurst:       s.rateLimitBurst,
+		RateLimitBan:         s.rateLimitBan.String(),
+		APIKeyRateLimitRPS:   s.apiKeyRateLimitRPS,
+		APIKeyRateLimitBurst: s.apiKeyRateLimitBurst,
+		ProfanityWords:       s.profanity.Words(),
+		Quotas:               s.quotas,
+		IPDenylist:           s.ipDenylist.Strings(),
+		AdminIPAllowlist:     s.adminIPAllowlist.Strings(),
+	}
+}
+
+// Reads the reloadable settings from the environment. prev is the currently active
+// set, whose rate limiter is reused when the limits did not change so clients keep
+// their accumulated state. st backs RATE_LIMIT_BACKEND=database.
+func loadRuntimeSettings(prev *runtimeSettings, st ratelimit.Store) (*runtimeSettings, error) {
+	rps, err := strconv.ParseFloat(getEnvDefault("RATE_LIMIT_RPS", "10"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_RPS: %w", err)
+	}
+	burst, err := strconv.Atoi(getEnvDefault("RATE_LIMIT_BURST", "20"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_BURST: %w", err)
+	}
+	keyRPS, err := strconv.ParseFloat(getEnvDefault("API_KEY_RATE_LIMIT_RPS", "5"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_KEY_RATE_LIMIT_RPS: %w", err)
+	}
+	keyBurst, err := strconv.Atoi(getEnvDefault("API_KEY_RATE_LIMIT_BURST", "10"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API_KEY_RATE_LIMIT_BURST: %w", err)
+	}
+	ban, err := loadRateLimitBanPolicy()
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := loadQuotaLimits()
+	if err != nil {
+		return nil, err
+	}
+	ipDenylist, err := ipfilter.Parse(strings.Split(os.Getenv("IP_DENYLIST"), ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP_DENYLIST: %w", err)
+	}
+	adminIPAllowlist, err := ipfilter.Parse(strings.Split(os.Getenv("ADMIN_IP_ALLOWLIST"), ","))
+	if err != nil {
+		return nil, fmt.Errorf("invalid ADMIN_IP_ALLOWLIST: %w", err)
+	}
+	settings := &runtimeSettings{
+		rateLimitBackend:     os.Getenv("RATE_LIMIT_BACKEND"),
+		rateLimitRPS:         rps,
+		rateLimitBurst:       burst,
+		rateLimitBan:         ban,
+		apiKeyRateLimitRPS:   keyRPS,
+		apiKeyRateLimitBurst: keyBurst,
+		// built once here rather than per chirp
+		profanity:        profanity.New(strings.Split(getEnvDefault("PROFANITY_WORDS", "kerfuffle,sharbert,fornax"), ",")),
+		quotas:           quotas,
+		ipDenylist:       ipDenylist,
+		adminIPAllowlist: adminIPAllowlist,
+	}
+	sameBackend := prev != nil && prev.rateLimitBackend == settings.rateLimitBackend
+	if sameBackend && prev.rateLimitRPS == rps && prev.rateLimitBurst == burst {
+		settings.rateLimiter = prev.rateLimiter
+	} else {
+		// Rate limiting defaults to an in-memory limiter; set RATE_LIMIT_BACKEND=redis or
+		// database so that multiple instances share one limit per client
+		settings.rateLimiter, err = ratelimit.New(settings.rateLimitBackend, rps, burst, os.Getenv("REDIS_URL"), st)
+		if err != nil {
+			return nil, fmt.Errorf("error creating rate limiter: %w", err)
+		}
+	}
+	if sameBackend && prev.apiKeyRateLimitRPS == keyRPS && prev.apiKeyRateLimitBurst == keyBurst {
+		settings.apiKeyRateLimiter = prev.apiKeyRateLimiter
+	} else {
+		settings.apiKeyRateLimiter, err = ratelimit.New(settings.rateLimitBackend, keyRPS, keyBurst, os.Getenv("REDIS_URL"), st)
+		if err != nil {
+			return nil, fmt.Errorf("error creating API key rate limiter: %w", err)
+		}
+	}
+	return settings, nil
+}
+
+// Re-reads .env over the current environment and swaps in the new settings.
+// Invalid values leave the running settings untouched.
+func (cfg *apiConfig) reloadSettings() (*runtimeSettings, error) {
+	err := godotenv.Overload()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error reading .env: %w", err)
+	}
+	prev := cfg.settings.Load()
+	next, err := loadRuntimeSettings(prev, cfg.store)
+	if err != nil {
+		return nil, err
+	}
+	cfg.settings.Store(next)
+	// requests still holding an old limiter fail open once it is closed
+	if prev != nil && prev.rateLimiter != next.rateLimiter {
+		if closer, ok := prev.rateLimiter.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	if prev != nil && prev.apiKeyRateLimiter != next.apiKeyRateLimiter {
+		if closer, ok := prev.apiKeyRateLimiter.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	log.Printf("Reloaded settings: rate_limit_rps=%g rate_limit_burst=%d profanity_words=%d", next.rateLimitRPS, next.rateLimitBurst, next.profanity.Len())
+	return next, nil
+}
+
+// Reloads settings every time the process receives SIGHUP
+func (cfg *apiConfig) reloadOnSIGHUP() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			settings, err := cfg.reloadSettings()
+			if err != nil {
+				log.Printf("Error reloading settings, keeping current ones: %s", err.Error())
+				continue
+			}
+			writeAudit(context.Background(), cfg.store, database.CreateAuditEntryParams{Actor: "sighup", Action: "settings.reload"}, settings.summary())
+		}
+	}()
+}
+
+// Admin endpoint equivalent of sending SIGHUP, responds with the settings now in effect
+func (cfg *apiConfig) handlerReload(w http.ResponseWriter, r *http.Request) {
+	settings, err := cfg.reloadSettings()
+	if err != nil {
+		log.Printf("Error reloading settings: %s", err.Error())
+		marshallError(w, err, 400)
+		return
+	}
+	cfg.recordAudit(r, "settings.reload", "", settings.summary())
+	render(w, r, 200, settings.summary())
+}
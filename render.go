@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// A response encoding the API can produce. Every format is derived from the JSON
+// encoding, so the json tags on response types define the field names everywhere.
+type renderFormat struct {
+	mediaTypes  []string
+	contentType string
+	// encode converts the JSON encoding of a response, nil for JSON itself
+	encode func(dat []byte) ([]byte, error)
+}
+
+// The first format is the default for clients that send no Accept header or */*
+var renderFormats = []renderFormat{
+	{mediaTypes: []string{"application/json"}, contentType: "application/json"},
+	{mediaTypes: []string{"application/xml", "text/xml"}, contentType: "application/xml; charset=utf-8", encode: jsonToXML},
+	{mediaTypes: []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}, contentType: "application/msgpack", encode: jsonToMsgpack},
+}
+
+// Writes v with the status code in the format the client asked for in its Accept header.
+// This is the one place handlers turn response values into bytes.
+func render(w http.ResponseWriter, r *http.Request, code int, v any) {
+	w.Header().Add("Vary", "Accept")
+	format, ok := negotiateFormat(r.Header.Get("Accept"), renderFormats)
+	if !ok {
+		marshallError(w, &apiError{
+			Message: "none of the requested media types are available",
+			Details: map[string]any{"available": []string{"application/json", "application/xml", "application/msgpack"}},
+		}, 406)
+		return
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	dat, err := encodeJSON(buf, v)
+	if err == nil && r.URL.Query().Has("fields") {
+		dat, err = selectFields(dat, r.URL.Query().Get("fields"))
+	}
+	if err == nil && format.encode != nil {
+		dat, err = format.encode(dat)
+	}
+	if err != nil {
+		log.Printf("Error marshalling response body: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	writeBody(w, code, format.contentType, dat)
+}
+
+// Response bodies are encoded into pooled buffers so a JSON response costs no fresh
+// byte slice. Buffers that grew past maxPooledBuffer are left to the garbage collector
+// so one large listing does not keep its memory around.
+var bufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+const maxPooledBuffer = 64 << 10
+
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	buf.Reset()
+	bufferPool.Put(buf)
+}
+
+// Encodes v into buf, byte for byte what json.Marshal returns. The result points into
+// buf and is only valid until buf goes back to the pool.
+func encodeJSON(buf *bytes.Buffer, v any) ([]byte, error) {
+	err := json.NewEncoder(buf).Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	// Encode ends the value with a newline Marshal does not add
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Writes a complete body with its Content-Length in a single Write, so the server
+// does not have to chunk it
+func writeBody(w http.ResponseWriter, code int, contentType string, dat []byte) {
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.Itoa(len(dat)))
+	w.WriteHeader(code)
+	w.Write(dat)
+}
+
+// Picks the format with the highest q-value in an Accept header. Ties go to the
+// earlier entry in formats, so JSON wins whenever it is acceptable.
+func negotiateFormat(accept string, formats []renderFormat) (renderFormat, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return formats[0], true
+	}
+	best, bestQ := -1, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+		}
+		for i, format := range formats {
+			if !acceptsMediaType(mediaType, format.mediaTypes) {
+				continue
+			}
+			if q > bestQ || (q == bestQ && q > 0 && i < best) {
+				best, bestQ = i, q
+			}
+		}
+	}
+	if best < 0 || bestQ == 0 {
+		return renderFormat{}, false
+	}
+	return formats[best], true
+}
+
+func acceptsMediaType(accepted string, mediaTypes []string) bool {
+	if accepted == "*/*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(accepted, "/*"); ok {
+		return slices.ContainsFunc(mediaTypes, func(t string) bool { return strings.HasPrefix(t, prefix+"/") })
+	}
+	return slices.Contains(mediaTypes, accepted)
+}
+
+// Sparse fieldsets: the dotted paths of a ?fields= list (e.g. id,body,author.handle)
+// as a tree. A name with no children keeps the whole value under it.
+type fieldSelection map[string]fieldSelection
+
+func parseFieldSelection(fields string) fieldSelection {
+	selection := fieldSelection{}
+	for _, path := range strings.Split(fields, ",") {
+		node := selection
+		for _, name := range strings.Split(strings.TrimSpace(path), ".") {
+			if name == "" {
+				break
+			}
+			if node[name] == nil {
+				node[name] = fieldSelection{}
+			}
+			node = node[name]
+		}
+	}
+	return selection
+}
+
+// Drops every object key not in the selection. Arrays apply it to each element and
+// other values pass through, so one list works for single items and collections.
+func (s fieldSelection) apply(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		kept := make(map[string]any, len(s))
+		for name, children := range s {
+			child, ok := v[name]
+			if !ok {
+				continue
+			}
+			if len(children) > 0 {
+				child = children.apply(child)
+			}
+			kept[name] = child
+		}
+		return kept
+	case []any:
+		for i, item := range v {
+			v[i] = s.apply(item)
+		}
+		return v
+	}
+	return value
+}
+
+// Keeps only the requested fields of a JSON response. Unknown names are ignored and
+// an empty list leaves the response untouched.
+func selectFields(dat []byte, fields string) ([]byte, error) {
+	selection := parseFieldSelection(fields)
+	if len(selection) == 0 {
+		return dat, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(dat))
+	decoder.UseNumber()
+	var value any
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(selection.apply(value))
+}
+
+func jsonToMsgpack(dat []byte) ([]byte, error) {
+	var value any
+	err := json.Unmarshal(dat, &value)
+	if err != nil {
+		return nil, err
+	}
+	return msgpack.Marshal(value)
+}
+
+// Element names must be valid XML names; JSON keys that are not become <entry key="...">
+var xmlNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// Converts a JSON document to XML under a <response> root. Object keys become child
+// elements, array elements become <item> elements, and null becomes an empty element.
+func jsonToXML(dat []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(dat))
+	decoder.UseNumber()
+	var value any
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	err = encodeXMLValue(enc, xml.StartElement{Name: xml.Name{Local: "response"}}, value)
+	if err != nil {
+		return nil, err
+	}
+	err = enc.Flush()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeXMLValue(enc *xml.Encoder, start xml.StartElement, value any) error {
+	err := enc.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			child := xml.StartElement{Name: xml.Name{Local: key}}
+			if !xmlNamePattern.MatchString(key) || strings.HasPrefix(strings.ToLower(key), "xml") {
+				child = xml.StartElement{Name: xml.Name{Local: "entry"}, Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}}}
+			}
+			err = encodeXMLValue(enc, child, v[key])
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range v {
+			err = encodeXMLValue(enc, xml.StartElement{Name: xml.Name{Local: "item"}}, item)
+			if err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		err = enc.EncodeToken(xml.CharData(fmt.Sprint(v)))
+		if err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Window GET /admin/reports/analytics covers without ?since=
+const reportAnalyticsWindow = 30 * 24 * time.Hour
+
+type reportAnalyticsResponse struct {
+	Since              time.Time                  `json:"since"`
+	Until              time.Time                  `json:"until"`
+	Totals             reportReasonCounts         `json:"totals"`
+	ByReason           []reportReasonCounts       `json:"by_reason"`
+	Timeline           []reportDay                `json:"timeline"`
+	MostReportedUsers  []reportedUser             `json:"most_reported_users"`
+	MostReportedChirps []reportedChirp            `json:"most_reported_chirps"`
+	ResponseTimes      moderatorResponseTimes     `json:"response_times"`
+	ByModerator        []moderatorResponseSummary `json:"by_moderator"`
+}
+
+// Reports made in the window and what became of them. Reason is empty in the totals.
+type reportReasonCounts struct {
+	Reason    string `json:"reason,omitempty"`
+	Reports   int64  `json:"reports"`
+	Open      int64  `json:"open"`
+	Escalated int64  `json:"escalated"`
+	Approved  int64  `json:"approved"`
+	Removed   int64  `json:"removed"`
+}
+
+// Reports made on a UTC day. Days without reports are left out.
+type reportDay struct {
+	Day      string           `json:"day"`
+	Reports  int64            `json:"reports"`
+	ByReason map[string]int64 `json:"by_reason"`
+}
+
+type reportedUser struct {
+	UserID        uuid.UUID `json:"user_id"`
+	Email         string    `json:"email"`
+	Reports       int64     `json:"reports"`
+	Chirps        int64     `json:"chirps"`
+	RemovedChirps int64     `json:"removed_chirps"`
+}
+
+type reportedChirp struct {
+	ChirpID  uuid.UUID `json:"chirp_id"`
+	AuthorID uuid.UUID `json:"author_id"`
+	// nil once the chirp is deleted
+	Body    *string `json:"body"`
+	Reports int64   `json:"reports"`
+	Open    int64   `json:"open"`
+}
+
+// How long reports waited for a decision, and for escalated ones until they were
+// escalated. Percentiles are nearest-rank, in seconds.
+type moderatorResponseTimes struct {
+	Decided               int     `json:"decided"`
+	AverageSeconds        float64 `json:"average_seconds"`
+	MedianSeconds         float64 `json:"median_seconds"`
+	P90Seconds            float64 `json:"p90_seconds"`
+	Escalated             int     `json:"escalated"`
+	MedianEscalateSeconds float64 `json:"median_escalate_seconds"`
+}
+
+type moderatorResponseSummary struct {
+	Moderator string `json:"moderator"`
+	Approved  int    `json:"approved"`
+	Removed   int    `json:"removed"`
+	moderatorResponseTimes
+}
+
+// Collects the response times of reports as they are added
+type responseTimeSamples struct {
+	decided   []time.Duration
+	escalated []time.Duration
+}
+
+func (s *responseTimeSamples) add(row database.ListReportResponseTimesRow) {
+	s.decided = append(s.decided, row.DecidedAt.Sub(row.ReportedAt))
+	if row.EscalatedAt.Valid {
+		s.escalated = append(s.escalated, row.EscalatedAt.Time.Sub(row.ReportedAt))
+	}
+}
+
+func (s *responseTimeSamples) summary() moderatorResponseTimes {
+	slices.Sort(s.decided)
+	slices.Sort(s.escalated)
+	times := moderatorResponseTimes{
+		Decided:               len(s.decided),
+		MedianSeconds:         durationPercentile(s.decided, 50).Seconds(),
+		P90Seconds:            durationPercentile(s.decided, 90).Seconds(),
+		Escalated:             len(s.escalated),
+		MedianEscalateSeconds: durationPercentile(s.escalated, 50).Seconds(),
+	}
+	if len(s.decided) > 0 {
+		var total time.Duration
+		for _, d := range s.decided {
+			total += d
+		}
+		times.AverageSeconds = (total / time.Duration(len(s.decided))).Seconds()
+	}
+	return times
+}
+
+// Nearest-rank percentile of sorted durations, 0 when there are none
+func durationPercentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(float64(len(sorted))*p/100+0.5) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// Summarizes the chirp reports made between ?since= and ?until= (RFC 3339, default the
+// last 30 days): counts by reason and UTC day, the most reported users and chirps, capped
+// by ?limit= (default 10, max 100), and how quickly moderators decided on reports in the
+// window, overall and per moderator
+func (cfg *apiConfig) handlerReportAnalytics(w http.ResponseWriter, r *http.Request) {
+	type query struct {
+		Since time.Time `query:"since"`
+		Until time.Time `query:"until"`
+		Limit int32     `query:"limit" validate:"min=1,max=100"`
+	}
+	now := time.Now().UTC()
+	q := query{
+		Since: now.Add(-reportAnalyticsWindow),
+		Until: now,
+		Limit: 10,
+	}
+	err := decodeQuery(r, &q)
+	if err != nil {
+		marshallError(w, err, 400)
+		return
+	}
+	if !q.Until.After(q.Since) {
+		marshallError(w, invalidInputError{"until must be after since"}, 400)
+		return
+	}
+	resp, err := cfg.reportAnalytics(r.Context(), q.Since, q.Until, q.Limit)
+	if err != nil {
+		log.Printf("Error collecting report analytics: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	render(w, r, 200, resp)
+}
+
+func (cfg *apiConfig) reportAnalytics(ctx context.Context, since, until time.Time, limit int32) (reportAnalyticsResponse, error) {
+	resp := reportAnalyticsResponse{
+		Since:              since,
+		Until:              until,
+		ByReason:           []reportReasonCounts{},
+		Timeline:           []reportDay{},
+		MostReportedUsers:  []reportedUser{},
+		MostReportedChirps: []reportedChirp{},
+		ByModerator:        []moderatorResponseSummary{},
+	}
+
+	reasons, err := cfg.store.CountChirpReportsByReason(ctx, database.CountChirpReportsByReasonParams{Since: since, Until: until})
+	if err != nil {
+		return resp, err
+	}
+	for _, row := range reasons {
+		counts := reportReasonCounts{
+			Reason:    row.Reason,
+			Reports:   row.Reports,
+			Open:      row.OpenReports,
+			Escalated: row.Escalated,
+			Approved:  row.Approved,
+			Removed:   row.Removed,
+		}
+		resp.ByReason = append(resp.ByReason, counts)
+		resp.Totals.Reports += counts.Reports
+		resp.Totals.Open += counts.Open
+		resp.Totals.Escalated += counts.Escalated
+		resp.Totals.Approved += counts.Approved
+		resp.Totals.Removed += counts.Removed
+	}
+
+	days, err := cfg.store.CountChirpReportsByDay(ctx, database.CountChirpReportsByDayParams{Since: since, Until: until})
+	if err != nil {
+		return resp, err
+	}
+	// rows come ordered by day, so each day's rows are together
+	for _, row := range days {
+		if len(resp.Timeline) == 0 || resp.Timeline[len(resp.Timeline)-1].Day != row.Day {
+			resp.Timeline = append(resp.Timeline, reportDay{Day: row.Day, ByReason: map[string]int64{}})
+		}
+		day := &resp.Timeline[len(resp.Timeline)-1]
+		day.Reports += row.Reports
+		day.ByReason[row.Reason] = row.Reports
+	}
+
+	users, err := cfg.store.ListMostReportedUsers(ctx, database.ListMostReportedUsersParams{Since: since, Until: until, MaxRows: limit})
+	if err != nil {
+		return resp, err
+	}
+	for _, row := range users {
+		resp.MostReportedUsers = append(resp.MostReportedUsers, reportedUser{
+			UserID:        row.AuthorID,
+			Email:         row.Email,
+			Reports:       row.Reports,
+			Chirps:        row.Chirps,
+			RemovedChirps: row.RemovedChirps,
+		})
+	}
+
+	chirps, err := cfg.store.ListMostReportedChirps(ctx, database.ListMostReportedChirpsParams{Since: since, Until: until, MaxRows: limit})
+	if err != nil {
+		return resp, err
+	}
+	for _, row := range chirps {
+		chirp := reportedChirp{ChirpID: row.ChirpID, AuthorID: row.AuthorID, Reports: row.Reports, Open: row.OpenReports}
+		if row.ChirpBody.Valid {
+			chirp.Body = &row.ChirpBody.String
+		}
+		resp.MostReportedChirps = append(resp.MostReportedChirps, chirp)
+	}
+
+	responses, err := cfg.store.ListReportResponseTimes(ctx, database.ListReportResponseTimesParams{Since: since, Until: until})
+	if err != nil {
+		return resp, err
+	}
+	var all responseTimeSamples
+	samples := make(map[string]*responseTimeSamples)
+	summaries := make(map[string]*moderatorResponseSummary)
+	for _, row := range responses {
+		all.add(row)
+		if samples[row.Moderator] == nil {
+			samples[row.Moderator] = &responseTimeSamples{}
+			summaries[row.Moderator] = &moderatorResponseSummary{Moderator: row.Moderator}
+		}
+		samples[row.Moderator].add(row)
+		switch row.Action {
+		case moderationApprove:
+			summaries[row.Moderator].Approved++
+		case moderationRemove:
+			summaries[row.Moderator].Removed++
+		}
+	}
+	resp.ResponseTimes = all.summary()
+	for moderator, summary := range summaries {
+		summary.moderatorResponseTimes = samples[moderator].summary()
+		resp.ByModerator = append(resp.ByModerator, *summary)
+	}
+	slices.SortFunc(resp.ByModerator, func(a, b moderatorResponseSummary) int {
+		return cmp.Or(cmp.Compare(b.Decided, a.Decided), cmp.Compare(a.Moderator, b.Moderator))
+	})
+	return resp, nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/schedule"
+)
+
+// A retention rule deletes one kind of row once it is older than the rule's retention.
+// Each rule runs as the scheduled task of the same name.
+type retentionRule struct {
+	name        string
+	description string
+	schedule    string
+	// environment variable the retention is read from, empty when rows carry their own expiry
+	retentionEnv string
+	retention    time.Duration
+	// scheduled runs only count what they would delete, see RETENTION_DRY_RUN
+	dryRun bool
+	count  func(ctx context.Context, before time.Time) (int64, error)
+	delete func(ctx context.Context, before time.Time) (int64, error)
+}
+
+// What one run of a retention rule did. Reports are kept in runtime_state, so every
+// instance shows the last run wherever the scheduler ran it.
+type retentionReport struct {
+	Rule  string    `json:"rule"`
+	RanAt time.Time `json:"ran_at"`
+	// rows older than this were deleted, or only counted by a dry run
+	Before     time.Time `json:"before"`
+	DryRun     bool      `json:"dry_run"`
+	Rows       int64     `json:"rows"`
+	DurationMs float64   `json:"duration_ms"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Builds the retention rules. RETENTION_DRY_RUN lists rules, or "all", whose scheduled
+// runs only report what they would delete, to check a new retention before it applies.
+func (cfg *apiConfig) newRetentionRules() []retentionRule {
+	rules := []retentionRule{
+		{
+			name:         "prune_sessions",
+			description:  "Deletes refresh tokens once they have been expired or revoked for longer than the retention",
+			schedule:     "@hourly",
+			retentionEnv: "REFRESH_TOKEN_RETENTION",
+			count:        cfg.store.CountStaleRefreshTokens,
+			delete:       cfg.store.DeleteStaleRefreshTokens,
+		},
+		{
+			name:        "prune_idempotency_keys",
+			description: "Deletes stored responses once IDEMPOTENCY_KEY_TTL has passed",
+			schedule:    "15 * * * *",
+			count:       cfg.store.CountExpiredIdempotencyKeys,
+			delete:      cfg.store.DeleteExpiredIdempotencyKeys,
+		},
+		{
+			name:        "prune_rate_limits",
+			description: "Deletes rate limit state that has fully decayed",
+			schedule:    "*/10 * * * *",
+			// buckets whose theoretical arrival time has passed are back to a full burst
+			count: func(ctx context.Context, before time.Time) (int64, error) {
+				return cfg.store.CountExpiredRateLimits(ctx, before.UnixMicro())
+			},
+			delete: func(ctx context.Context, before time.Time) (int64, error) {
+				return cfg.store.DeleteExpiredRateLimits(ctx, before.UnixMicro())
+			},
+		},
+		{
+			name:         "prune_jobs",
+			description:  "Deletes finished background jobs",
+			schedule:     "30 3 * * *",
+			retentionEnv: "JOB_RETENTION",
+			retention:    7 * 24 * time.Hour,
+			count:        cfg.store.CountFinishedJobs,
+			delete:       cfg.store.DeleteFinishedJobs,
+		},
+		{
+			name:         "prune_schedule_history",
+			description:  "Deletes scheduled task run records",
+			schedule:     "45 3 * * *",
+			retentionEnv: "SCHEDULE_HISTORY_RETENTION",
+			retention:    30 * 24 * time.Hour,
+			count:        cfg.store.CountScheduledRunsBefore,
+			delete:       cfg.store.DeleteScheduledRunsBefore,
+		},
+		{
+			name:         "prune_webhook_deliveries",
+			description:  "Deletes webhook delivery records",
+			schedule:     "50 3 * * *",
+			retentionEnv: "WEBHOOK_DELIVERY_RETENTION",
+			retention:    30 * 24 * time.Hour,
+			count:        cfg.store.CountWebhookDeliveriesBefore,
+			delete:       cfg.store.DeleteWebhookDeliveriesBefore,
+		},
+		{
+			name:         "prune_outbox",
+			description:  "Deletes outbox events once they have been relayed for longer than the retention",
+			schedule:     "55 3 * * *",
+			retentionEnv: "OUTBOX_RETENTION",
+			retention:    7 * 24 * time.Hour,
+			count:        cfg.store.CountPublishedOutboxEventsBefore,
+			delete:       cfg.store.DeletePublishedOutboxEventsBefore,
+		},
+		{
+			name:        "prune_quota_usage",
+			description: "Deletes quota counters of windows that ended over a day ago",
+			schedule:    "5 4 * * *",
+			// the longest window is a day, so older counters are never read again
+			retention: 48 * time.Hour,
+			count:     cfg.store.CountQuotaUsageBefore,
+			delete:    cfg.store.DeleteQuotaUsageBefore,
+		},
+		{
+			name:         "prune_api_key_usage",
+			description:  "Deletes the daily request counts of API keys",
+			schedule:     "10 4 * * *",
+			retentionEnv: "API_KEY_USAGE_RETENTION",
+			// GET /api/usage shows the last 30 days
+			retention: 90 * 24 * time.Hour,
+			count:     cfg.store.CountAPIKeyUsageBefore,
+			delete:    cfg.store.DeleteAPIKeyUsageBefore,
+		},
+		{
+			name:        "prune_ip_bans",
+			description: "Deletes IP bans that have expired",
+			schedule:    "20 * * * *",
+			// expired bans no longer apply, the audit log keeps the record of them
+			count: func(ctx context.Context, before time.Time) (int64, error) {
+				return cfg.store.CountExpiredIPBans(ctx, sql.NullTime{Time: before, Valid: true})
+			},
+			delete: func(ctx context.Context, before time.Time) (int64, error) {
+				return cfg.store.DeleteExpiredIPBans(ctx, sql.NullTime{Time: before, Valid: true})
+			},
+		},
+	}
+	dryRun := strings.Split(os.Getenv("RETENTION_DRY_RUN"), ",")
+	for i := range rules {
+		if rules[i].retentionEnv != "" {
+			rules[i].retention = getEnvDuration(rules[i].retentionEnv, rules[i].retention)
+		}
+		rules[i].dryRun = slices.Contains(dryRun, "all") || slices.Contains(dryRun, rules[i].name)
+	}
+	return rules
+}
+
+// Name of the runtime_state row holding the last report of a rule, kept apart for dry
+// runs so checking a rule does not hide what it last deleted
+func retentionReportName(rule string, dryRun bool) string {
+	if dryRun {
+		return "retention:" + rule + ":dry_run"
+	}
+	return "retention:" + rule
+}
+
+// Runs a rule, or with dryRun only counts the rows it would delete, and saves the report
+func (cfg *apiConfig) runRetentionRule(ctx context.Context, rule retentionRule, dryRun bool) (retentionReport, error) {
+	start := time.Now().UTC()
+	report := retentionReport{Rule: rule.name, RanAt: start, Before: start.Add(-rule.retention), DryRun: dryRun}
+	run := rule.delete
+	if dryRun {
+		run = rule.count
+	}
+	rows, err := run(ctx, report.Before)
+	report.Rows = rows
+	report.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+	switch {
+	case err != nil:
+		report.Error = err.Error()
+	case dryRun:
+		log.Printf("Retention rule %s would delete %d rows", rule.name, rows)
+	case rows > 0:
+		log.Printf("Retention rule %s deleted %d rows", rule.name, rows)
+	}
+	dat, marshalErr := json.Marshal(report)
+	if marshalErr == nil {
+		_, marshalErr = cfg.store.SetRuntimeState(context.WithoutCancel(ctx), database.SetRuntimeStateParams{
+			Name:  retentionReportName(rule.name, dryRun),
+			Value: string(dat),
+		})
+	}
+	if marshalErr != nil {
+		log.Printf("Error saving retention report: %s", marshalErr.Error())
+	}
+	return report, err
+}
+
+// Helper function to read the last report of a rule, nil when it never ran
+func (cfg *apiConfig) lastRetentionReport(ctx context.Context, rule string, dryRun bool) (*retentionReport, error) {
+	state, err := cfg.store.GetRuntimeState(ctx, retentionReportName(rule, dryRun))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var report retentionReport
+	err = json.Unmarshal([]byte(state.Value), &report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Lists the retention rules with their schedule and the last run and dry run of each
+func (cfg *apiConfig) handlerListRetention(w http.ResponseWriter, r *http.Request) {
+	type ruleResponse struct {
+		Name             string           `json:"name"`
+		Description      string           `json:"description"`
+		RetentionSeconds int64            `json:"retention_seconds"`
+		RetentionEnv     string           `json:"retention_env,omitempty"`
+		Schedule         string           `json:"schedule"`
+		NextRun          *time.Time       `json:"next_run"`
+		DryRun           bool             `json:"dry_run"`
+		LastRun          *retentionReport `json:"last_run"`
+		LastDryRun       *retentionReport `json:"last_dry_run"`
+	}
+	resp := struct {
+		Rules []ruleResponse `json:"rules"`
+	}{Rules: make([]ruleResponse, 0, len(cfg.retentionRules))}
+	tasks := cfg.scheduler.Tasks()
+	for _, rule := range cfg.retentionRules {
+		item := ruleResponse{
+			Name:             rule.name,
+			Description:      rule.description,
+			RetentionSeconds: int64(rule.retention.Seconds()),
+			RetentionEnv:     rule.retentionEnv,
+			// a rule turned off with SCHEDULE_<RULE>=off still runs on demand
+			Schedule: "off",
+			DryRun:   rule.dryRun,
+		}
+		if i := slices.IndexFunc(tasks, func(task schedule.TaskInfo) bool { return task.Name == rule.name }); i >= 0 {
+			item.Schedule = tasks[i].Schedule
+			item.NextRun = &tasks[i].Next
+		}
+		var err error
+		item.LastRun, err = cfg.lastRetentionReport(r.Context(), rule.name, false)
+		if err == nil {
+			item.LastDryRun, err = cfg.lastRetentionReport(r.Context(), rule.name, true)
+		}
+		if err != nil {
+			log.Printf("Error reading retention report: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		resp.Rules = append(resp.Rules, item)
+	}
+	render(w, r, 200, resp)
+}
+
+// Runs a retention rule now. With ?dry_run=true the rows it would delete are only counted.
+func (cfg *apiConfig) handlerRunRetention(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("rule")
+	i := slices.IndexFunc(cfg.retentionRules, func(rule retentionRule) bool { return rule.name == name })
+	if i < 0 {
+		marshallError(w, &apiError{Code: "unknown_retention_rule", Message: fmt.Sprintf("there is no retention rule named %q", name)}, 404)
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	report, err := cfg.runRetentionRule(r.Context(), cfg.retentionRules[i], dryRun)
+	if err != nil {
+		log.Printf("Error running retention rule %s: %s", name, err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if !dryRun {
+		cfg.recordAudit(r, "retention.run", name, report)
+	}
+	render(w, r, 200, report)
+}
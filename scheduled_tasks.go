@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/schedule"
+)
+
+type scheduledTaskResponse struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	NextRun  time.Time `json:"next_run"`
+}
+
+type scheduledRunResponse struct {
+	Task         string     `json:"task"`
+	ScheduledFor time.Time  `json:"scheduled_for"`
+	Instance     string     `json:"instance"`
+	Status       string     `json:"status"`
+	Error        string     `json:"error,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+}
+
+func newScheduledRunResponse(run database.ScheduledRun) scheduledRunResponse {
+	resp := scheduledRunResponse{
+		Task:         run.Task,
+		ScheduledFor: run.ScheduledFor,
+		Instance:     run.Instance,
+		Status:       run.Status,
+		Error:        run.Error,
+		StartedAt:    run.StartedAt,
+	}
+	if run.FinishedAt.Valid {
+		resp.FinishedAt = &run.FinishedAt.Time
+	}
+	return resp
+}
+
+// Builds the scheduler with every recurring task. Each task's schedule can be
+// overridden with SCHEDULE_<TASK> (e.g. SCHEDULE_PRUNE_SESSIONS="*/30 * * * *"), or
+// set to "off" to disable it on this instance.
+func (cfg *apiConfig) newScheduler() (*schedule.Scheduler, error) {
+	hostname, _ := os.Hostname()
+	instance := getEnvDefault("SCHEDULER_INSTANCE", fmt.Sprintf("%s:%d", hostname, os.Getpid()))
+	cfg.retentionRules = cfg.newRetentionRules()
+	scheduler := schedule.New(cfg.store, instance, getEnvDuration("SCHEDULE_TIMEOUT", 10*time.Minute))
+	add := func(name, defaultSpec string, run func(ctx context.Context) error) error {
+		spec := getEnvDefault("SCHEDULE_"+strings.ToUpper(name), defaultSpec)
+		if spec == "off" {
+			return nil
+		}
+		return scheduler.Add(name, spec, run)
+	}
+	for _, rule := range cfg.retentionRules {
+		err := add(rule.name, rule.schedule, func(ctx context.Context) error {
+			_, err := cfg.runRetentionRule(ctx, rule, rule.dryRun)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := add("rollup_stats", "*/15 * * * *", func(ctx context.Context) error {
+		_, err := cfg.rollupStats(ctx, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = add("refresh_sitemaps", "*/10 * * * *", func(ctx context.Context) error {
+		_, err := cfg.refreshSitemaps(ctx, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = add("send_digests", "0 8 * * 1", func(ctx context.Context) error {
+		_, err := cfg.sendDigests(ctx, time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scheduler, nil
+}
+
+// Lists the recurring tasks and their recent runs across all instances, newest first
+func (cfg *apiConfig) handlerSchedule(w http.ResponseWriter, r *http.Request) {
+	type query struct {
+		Task  string `query:"task"`
+		Limit int32  `query:"limit" validate:"min=1,max=1000"`
+	}
+	params := query{Limit: 50}
+	err := decodeQuery(r, &params)
+	if err != nil {
+		marshallError(w, err, 400)
+		return
+	}
+	rows, err := cfg.store.ListScheduledRuns(r.Context(), database.ListScheduledRunsParams{
+		Task:    params.Task,
+		MaxRuns: params.Limit,
+	})
+	if err != nil {
+		log.Printf("Error listing scheduled runs: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := struct {
+		Tasks []scheduledTaskResponse `json:"tasks"`
+		Runs  []scheduledRunResponse  `json:"runs"`
+	}{Tasks: []scheduledTaskResponse{}, Runs: make([]scheduledRunResponse, 0, len(rows))}
+	for _, task := range cfg.scheduler.Tasks() {
+		resp.Tasks = append(resp.Tasks, scheduledTaskResponse{Name: task.Name, Schedule: task.Schedule, NextRun: task.Next})
+	}
+	for _, run := range rows {
+		resp.Runs = append(resp.Runs, newScheduledRunResponse(run))
+	}
+	render(w, r, 200, resp)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	searchTypeUser  = "user"
+	searchTypeChirp = "chirp"
+)
+
+type searchChirpResponse struct {
+	ID          uuid.UUID `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Body        string    `json:"body"`
+	UserID      uuid.UUID `json:"user_id"`
+	AuthorEmail string    `json:"author_email"`
+	TenantID    uuid.UUID `json:"tenant_id"`
+}
+
+// One match of an admin search. Type says which of user and chirp is set, and links
+// points at the endpoints support staff go to next.
+type searchResult struct {
+	Type  string               `json:"type"`
+	User  *adminUserResponse   `json:"user,omitempty"`
+	Chirp *searchChirpResponse `json:"chirp,omitempty"`
+	Links map[string]string    `json:"links"`
+}
+
+// Turns a search into the LIKE pattern of the search queries, matching it anywhere
+// and taking % and _ literally
+func searchPattern(q string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(strings.ToLower(q))
+	return "%" + escaped + "%"
+}
+
+func userSearchResult(user database.User) searchResult {
+	id := user.ID.String()
+	return searchResult{
+		Type: searchTypeUser,
+		User: &adminUserResponse{
+			ID:          user.ID,
+			CreatedAt:   user.CreatedAt,
+			UpdatedAt:   user.UpdatedAt,
+			Email:       user.Email,
+			IsChirpyRed: user.IsChirpyRed,
+			TenantID:    user.TenantID,
+		},
+		Links: map[string]string{
+			"chirps":    "/api/chirps?author_id=" + id,
+			"audit_log": "/admin/audit-log?target=" + id,
+			"shadowban": "/admin/users/" + id + "/shadowban",
+		},
+	}
+}
+
+func chirpSearchResult(chirp database.Chirp, authorEmail string) searchResult {
+	id := chirp.ID.String()
+	return searchResult{
+		Type: searchTypeChirp,
+		Chirp: &searchChirpResponse{
+			ID:          chirp.ID,
+			CreatedAt:   chirp.CreatedAt,
+			UpdatedAt:   chirp.UpdatedAt,
+			Body:        chirp.Body,
+			UserID:      chirp.UserID,
+			AuthorEmail: authorEmail,
+			TenantID:    chirp.TenantID,
+		},
+		Links: map[string]string{
+			"self":      "/api/chirps/" + id,
+			"author":    "/admin/search?" + url.Values{"q": {chirp.UserID.String()}, "type": {searchTypeUser}}.Encode(),
+			"audit_log": "/admin/audit-log?target=" + id,
+			"remove":    "/admin/chirps/" + id,
+		},
+	}
+}
+
+// Searches users by email and chirps by body across every tenant, case-insensitively,
+// and both by exact id. Users come first, each type newest first and capped by ?limit=
+// (default 20, max 100). ?type= narrows the search to users or chirps.
+func (cfg *apiConfig) handlerAdminSearch(w http.ResponseWriter, r *http.Request) {
+	type query struct {
+		Q     string `query:"q" validate:"required,max=200"`
+		Type  string `query:"type" validate:"oneof=all user chirp"`
+		Limit int32  `query:"limit" validate:"min=1,max=100"`
+	}
+	q := query{Type: "all", Limit: 20}
+	err := decodeQuery(r, &q)
+	if err != nil {
+		marshallError(w, err, 400)
+		return
+	}
+	ctx := r.Context()
+	term := strings.TrimSpace(q.Q)
+	if term == "" {
+		marshallError(w, invalidInputError{"q must not be blank"}, 400)
+		return
+	}
+	pattern := searchPattern(term)
+	// a search for an id also finds the user or chirp it belongs to
+	exactID := ""
+	if id, err := uuid.Parse(term); err == nil {
+		exactID = id.String()
+	}
+	results := []searchResult{}
+	if q.Type != searchTypeChirp {
+		users, err := cfg.store.SearchUsers(ctx, database.SearchUsersParams{Pattern: pattern, ExactID: exactID, MaxResults: q.Limit})
+		if err != nil {
+			log.Printf("Error searching users: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		for _, user := range users {
+			results = append(results, userSearchResult(user))
+		}
+	}
+	if q.Type != searchTypeUser {
+		chirps, err := cfg.store.SearchChirps(ctx, database.SearchChirpsParams{Pattern: pattern, ExactID: exactID, MaxResults: q.Limit})
+		if err != nil {
+			log.Printf("Error searching chirps: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		// authors are looked up a tenant at a time, as users are stored per tenant
+		authorIDs := make(map[uuid.UUID][]string)
+		for _, chirp := range chirps {
+			authorIDs[chirp.TenantID] = append(authorIDs[chirp.TenantID], chirp.UserID.String())
+		}
+		emails := make(map[uuid.UUID]string)
+		for tenantID, ids := range authorIDs {
+			authors, err := cfg.store.GetUsersByIds(ctx, database.GetUsersByIdsParams{TenantID: tenantID, Ids: strings.Join(ids, ",")})
+			if err != nil {
+				log.Printf("Error looking up chirp authors: %s", err.Error())
+				marshallError(w, err, 500)
+				return
+			}
+			for _, author := range authors {
+				emails[author.ID] = author.Email
+			}
+		}
+		for _, chirp := range chirps {
+			results = append(results, chirpSearchResult(chirp, emails[chirp.UserID]))
+		}
+	}
+	render(w, r, 200, struct {
+		Query   string         `json:"query"`
+		Results []searchResult `json:"results"`
+	}{term, results})
+}
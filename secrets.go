@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/secrets"
+	"github.com/google/uuid"
+)
+
+// Environment variables that may name a secret in Vault or AWS Secrets Manager rather
+// than hold it, e.g. JWT_SECRET_KEY=vault:secret/data/chirpy#jwt_secret. See
+// internal/secrets for the reference format.
+var secretEnvVars = []string{"DB_URL", "JWT_SECRET_KEY", "SMTP_USERNAME", "SMTP_PASSWORD"}
+
+// The secrets the variables in secretEnvVars name, as last fetched. Variables holding
+// a plain value are not in values.
+var secretEnv = struct {
+	sync.RWMutex
+	resolver *secrets.Resolver
+	values   map[string]string
+}{resolver: secrets.NewResolver(), values: map[string]string{}}
+
+// Access tokens are valid for this long, so a rotated JWT secret is still accepted for
+// as long after it was replaced
+const accessTokenTTL = time.Hour
+
+// Returns the value of an environment variable in secretEnvVars: the secret it names
+// as last fetched by loadSecrets or refreshSecretsEvery, or the value itself
+func getSecretEnv(key string) string {
+	secretEnv.RLock()
+	defer secretEnv.RUnlock()
+	if value, ok := secretEnv.values[key]; ok {
+		return value
+	}
+	return os.Getenv(key)
+}
+
+// Returns DB_URL, fetched from a secret store when it names one
+func currentDBURL() string {
+	return getSecretEnv("DB_URL")
+}
+
+// Fetches the secrets the variables in secretEnvVars name. It does nothing when none of
+// them is a reference, so no secret store needs to be configured.
+func loadSecrets(ctx context.Context) error {
+	_, err := fetchSecrets(ctx)
+	return err
+}
+
+// Fetches every referenced secret and stores the new values, returning the names of
+// the variables whose secrets changed. Nothing is stored unless every fetch worked.
+func fetchSecrets(ctx context.Context) ([]string, error) {
+	fetched := map[string]string{}
+	for _, key := range secretEnvVars {
+		ref, ok := secretEnv.resolver.Parse(os.Getenv(key))
+		if !ok {
+			continue
+		}
+		value, err := secretEnv.resolver.Fetch(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", key, err)
+		}
+		fetched[key] = value
+	}
+	secretEnv.Lock()
+	defer secretEnv.Unlock()
+	var changed []string
+	for key, value := range fetched {
+		if prev, ok := secretEnv.values[key]; ok && prev != value {
+			changed = append(changed, key)
+		}
+		secretEnv.values[key] = value
+	}
+	return changed, nil
+}
+
+// Fetches the referenced secrets again every interval until ctx is done, so rotated
+// credentials are picked up without a restart, and calls rotated with the name of each
+// variable whose secret changed. A failed fetch keeps the values fetched before.
+func refreshSecretsEvery(ctx context.Context, interval time.Duration, rotated func(key string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := fetchSecrets(ctx)
+			if err != nil {
+				log.Printf("Error refreshing secrets, keeping the current ones: %s", err.Error())
+				continue
+			}
+			for _, key := range changed {
+				log.Printf("Secret %s rotated", key)
+				rotated(key)
+			}
+		}
+	}
+}
+
+// The secret access tokens are signed with, and the one it replaced when it was rotated
+type jwtKeys struct {
+	current  string
+	previous string
+	// tokens signed with previous are accepted until then
+	previousUntil time.Time
+}
+
+// Makes secret the one new access tokens are signed with. Tokens signed with the old
+// one keep working until they expire.
+func (cfg *apiConfig) setJWTSecret(secret string) {
+	keys := &jwtKeys{current: secret}
+	if prev := cfg.jwtKeys.Load(); prev != nil && prev.current != secret {
+		keys.previous = prev.current
+		keys.previousUntil = time.Now().Add(accessTokenTTL)
+	}
+	cfg.jwtKeys.Store(keys)
+}
+
+// Issues an access token for userID, valid for accessTokenTTL
+func (cfg *apiConfig) makeJWT(userID uuid.UUID) (string, error) {
+	return auth.MakeJWT(userID, cfg.jwtKeys.Load().current, accessTokenTTL)
+}
+
+// Returns the user an access token was issued to, accepting tokens signed with the
+// current secret or, for a while after a rotation, the previous one
+func (cfg *apiConfig) validateJWT(token string) (uuid.UUID, error) {
+	keys := cfg.jwtKeys.Load()
+	userID, err := auth.ValidateJWT(token, keys.current)
+	if err != nil && keys.previous != "" && time.Now().Before(keys.previousUntil) {
+		if prevUserID, prevErr := auth.ValidateJWT(token, keys.previous); prevErr == nil {
+			return prevUserID, nil
+		}
+	}
+	return userID, err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+)
+
+var seedWords = strings.Fields(`the a my your this that today tomorrow coffee code gopher chirp
+	server database deploy weekend sunrise rain cat dog pizza music coding tests build ship
+	bug fix feature release review merge lunch train park book movie great terrible lovely
+	quiet busy finally again never always just really maybe honestly`)
+
+// Implements `chirpy seed`, filling the database with deterministic fake users and
+// chirps. The same -seed value always produces the same emails and chirp bodies.
+// Every seeded user has the password "password".
+func runSeedCommand(ctx context.Context, appStore store.Store, args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ContinueOnError)
+	users := flags.Int("users", 50, "number of users to create")
+	chirpsPerUser := flags.Int("chirps-per-user", 20, "number of chirps to create for each user")
+	seed := flags.Uint64("seed", 1, "random seed for generated data")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	rng := rand.New(rand.NewPCG(*seed, *seed))
+	// bcrypt is deliberately slow, so every seeded user shares one hash
+	hashedPassword, err := auth.HashPassword("password")
+	if err != nil {
+		return err
+	}
+	for i := 0; i < *users; i++ {
+		// one transaction per user keeps a failed run from leaving half-seeded accounts
+		err = appStore.WithTx(ctx, func(tx store.Store) error {
+			user, err := tx.CreateUser(ctx, database.CreateUserParams{
+				Email:          fmt.Sprintf("user%04d.%d@seed.chirpy.test", i, *seed),
+				HashedPassword: hashedPassword,
+			})
+			if err != nil {
+				return err
+			}
+			for j := 0; j < *chirpsPerUser; j++ {
+				_, err = tx.CreateChirp(ctx, database.CreateChirpParams{Body: fakeChirp(rng), UserID: user.ID})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("seeding user %d: %w", i, err)
+		}
+	}
+	log.Printf("Seeded %d users with %d chirps each", *users, *chirpsPerUser)
+	return nil
+}
+
+// Builds a random chirp body that always fits the 140 character limit
+func fakeChirp(rng *rand.Rand) string {
+	var words []string
+	length := 0
+	target := 20 + rng.IntN(100)
+	for length < target {
+		word := seedWords[rng.IntN(len(seedWords))]
+		if length+len(word)+1 > 140 {
+			break
+		}
+		words = append(words, word)
+		length += len(word) + 1
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,546 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/activitypub"
+	"github.com/diamondoughnut/httpChirpy/internal/cache"
+	"github.com/diamondoughnut/httpChirpy/internal/compress"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/email"
+	"github.com/diamondoughnut/httpChirpy/internal/flags"
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+	"github.com/diamondoughnut/httpChirpy/internal/loadshed"
+	"github.com/diamondoughnut/httpChirpy/internal/metrics"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/diamondoughnut/httpChirpy/internal/tracing"
+	"github.com/diamondoughnut/httpChirpy/internal/webhooks"
+	"github.com/google/uuid"
+	"github.com/pressly/goose/v3"
+	"google.golang.org/grpc"
+)
+
+// Implements `chirpy serve`, the default command: runs the HTTP API until it fails
+func runServe(args []string) error {
+	cmdFlags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := cmdFlags.String("addr", getEnvDefault("LISTEN", ":8080"), "address to listen on, or unix:<path> for a Unix socket")
+	demo := cmdFlags.Bool("demo", false, "use in-memory storage, same as PLATFORM=demo")
+	err := cmdFlags.Parse(args)
+	if err != nil {
+		return err
+	}
+	closeLogFile := setupLogFile()
+	defer closeLogFile()
+	// DB_URL and JWT_SECRET_KEY may name secrets in Vault or AWS Secrets Manager
+	err = loadSecrets(context.Background())
+	if err != nil {
+		log.Fatalf("Error fetching secrets: %s", err.Error())
+	}
+	platform := os.Getenv("PLATFORM")
+	if *demo {
+		platform = "demo"
+	}
+	secretKey := getSecretEnv("JWT_SECRET_KEY")
+	polkaKey := os.Getenv("POLKA_KEY")
+	// Request body caps, JSON bodies are small while media uploads and restored backups get larger allowances
+	maxJSONBodyBytes, err := strconv.ParseInt(getEnvDefault("MAX_JSON_BODY_BYTES", "1048576"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid MAX_JSON_BODY_BYTES: %s", err.Error())
+	}
+	maxMediaBodyBytes, err := strconv.ParseInt(getEnvDefault("MAX_MEDIA_BODY_BYTES", "10485760"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid MAX_MEDIA_BODY_BYTES: %s", err.Error())
+	}
+	maxRestoreBodyBytes, err := strconv.ParseInt(getEnvDefault("MAX_RESTORE_BODY_BYTES", "1073741824"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid MAX_RESTORE_BODY_BYTES: %s", err.Error())
+	}
+	maxImportBodyBytes, err := strconv.ParseInt(getEnvDefault("MAX_IMPORT_BODY_BYTES", "1073741824"), 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid MAX_IMPORT_BODY_BYTES: %s", err.Error())
+	}
+	// Tracing is opt-in; the exporter endpoint comes from OTEL_EXPORTER_OTLP_ENDPOINT
+	tracingEnabled := os.Getenv("OTEL_ENABLED") == "true"
+	shutdownTracing := func(context.Context) error { return nil }
+	if tracingEnabled {
+		shutdownTracing, err = tracing.Setup(context.Background(), getEnvDefault("OTEL_SERVICE_NAME", "chirpy"))
+		if err != nil {
+			log.Fatalf("Error setting up tracing: %s", err.Error())
+		}
+	}
+	// PLATFORM=demo runs without any external database
+	var db *sql.DB
+	var appStore store.Store
+	var appMetrics *metrics.Metrics
+	if platform == "demo" {
+		log.Printf("PLATFORM=demo: using in-memory storage, all data is lost on restart")
+		appStore = store.NewMemory()
+		appMetrics = metrics.New(nil)
+	} else {
+		var dialect goose.Dialect
+		db, dialect, err = openDatabase(currentDBURL)
+		if err != nil {
+			return err
+		}
+		prepareDatabase(db, dialect)
+		appMetrics = metrics.New(db)
+		appMetrics.SetSlowQueryThreshold(getEnvDuration("DB_SLOW_QUERY_THRESHOLD", 500*time.Millisecond))
+		// Queries give up after DB_QUERY_TIMEOUT, or the duration DB_QUERY_TIMEOUTS names for them
+		queryTimeout := getEnvDuration("DB_QUERY_TIMEOUT", 5*time.Second)
+		queryTimeouts := getEnvDurations("DB_QUERY_TIMEOUTS")
+		wrap := func(conn database.DBTX) database.DBTX {
+			conn = store.WrapTimeout(conn, queryTimeout, queryTimeouts)
+			conn = appMetrics.WrapDB(conn)
+			if tracingEnabled {
+				conn = tracing.WrapDB(conn)
+			}
+			return conn
+		}
+		appStore = store.NewSQL(db, wrap)
+	}
+	// Labels such as route can be dropped when Prometheus struggles with the series count
+	err = appMetrics.DropLabels(strings.Fields(strings.ReplaceAll(os.Getenv("METRICS_DROP_LABELS"), ",", " "))...)
+	if err != nil {
+		log.Fatalf("Invalid METRICS_DROP_LABELS: %s", err.Error())
+	}
+	// Initialize application configuration with database queries
+	apiCfg := &apiConfig{store: appStore, platform: platform, polkaKey: polkaKey, maxJSONBodyBytes: maxJSONBodyBytes, maxMediaBodyBytes: maxMediaBodyBytes, maxRestoreBodyBytes: maxRestoreBodyBytes, maxImportBodyBytes: maxImportBodyBytes, metrics: appMetrics, adminToken: os.Getenv("ADMIN_TOKEN"), db: db, readinessTimeout: getEnvDuration("READINESS_TIMEOUT", 2*time.Second)}
+	apiCfg.setJWTSecret(secretKey)
+	// Rotated secrets are picked up every SECRETS_REFRESH: new database connections log in
+	// with the current DB_URL, and tokens signed with the previous JWT secret keep working
+	if refresh := getEnvDuration("SECRETS_REFRESH", 5*time.Minute); refresh > 0 {
+		go refreshSecretsEvery(context.Background(), refresh, func(key string) {
+			if key == "JWT_SECRET_KEY" {
+				apiCfg.setJWTSecret(getSecretEnv(key))
+			}
+		})
+	}
+	// Rate limits and the profanity list can be reloaded later with SIGHUP or POST /admin/reload
+	settings, err := loadRuntimeSettings(nil, appStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	apiCfg.settings.Store(settings)
+	// Responses to requests with an Idempotency-Key are replayed for IDEMPOTENCY_KEY_TTL
+	apiCfg.idempotencyTTL = getEnvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+	apiCfg.reloadOnSIGHUP()
+	// Feature flag definitions are cached in process and reloaded every FEATURE_FLAG_REFRESH
+	apiCfg.flags = flags.New(appStore, platform, getEnvDuration("FEATURE_FLAG_REFRESH", 30*time.Second))
+	// Hot chirp reads are served from a small LRU, CHIRP_CACHE_SIZE=0 turns it off
+	chirpCacheSize, err := strconv.Atoi(getEnvDefault("CHIRP_CACHE_SIZE", "1000"))
+	if err != nil {
+		log.Fatalf("Invalid CHIRP_CACHE_SIZE: %s", err.Error())
+	}
+	chirpCacheTTL := getEnvDuration("CHIRP_CACHE_TTL", 30*time.Second)
+	apiCfg.chirpCache = cache.NewLRU[uuid.UUID, database.Chirp](chirpCacheSize, chirpCacheTTL)
+	// one global timeline per tenant
+	apiCfg.chirpListCache = cache.NewLRU[string, []database.Chirp](min(chirpCacheSize, 100), chirpCacheTTL)
+	// Tenants are named by X-Tenant or a subdomain of TENANT_DOMAIN and cached for TENANT_CACHE_TTL
+	apiCfg.tenantDomain = strings.ToLower(os.Getenv("TENANT_DOMAIN"))
+	apiCfg.tenantCache = cache.NewLRU[string, database.Tenant](1000, getEnvDuration("TENANT_CACHE_TTL", 30*time.Second))
+	// Timelines can also be shared between instances through Redis
+	apiCfg.sharedCache = cache.Noop{}
+	apiCfg.timelineCacheTTL = getEnvDuration("TIMELINE_CACHE_TTL", time.Minute)
+	if os.Getenv("REDIS_CACHE_ENABLED") == "true" {
+		apiCfg.sharedCache, err = cache.NewRedis(os.Getenv("REDIS_URL"), "chirpy:cache:")
+		if err != nil {
+			log.Fatalf("Error creating Redis cache: %s", err.Error())
+		}
+	}
+	// Read from the visits table so every replica reports the same total, plus its own unflushed hits
+	apiCfg.metrics.RegisterGaugeFunc("fileserver_hits", "File server hits since the last reset, across all instances.", func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), apiCfg.readinessTimeout)
+		defer cancel()
+		total, err := apiCfg.store.GetTotalVisits(ctx)
+		if err != nil {
+			log.Printf("Error reading visit total: %s", err.Error())
+			return math.NaN()
+		}
+		return float64(total + apiCfg.pendingHits.Load())
+	})
+	// Hits are counted in memory and written to the visits table every VISIT_FLUSH_INTERVAL
+	go apiCfg.flushVisitsEvery(context.Background(), getEnvDuration("VISIT_FLUSH_INTERVAL", 10*time.Second))
+	// Maintenance mode is shared through the database and re-read every MAINTENANCE_REFRESH
+	runtimeStateRefresh := getEnvDuration("MAINTENANCE_REFRESH", 5*time.Second)
+	_, err = apiCfg.syncMaintenance(context.Background())
+	if err != nil {
+		log.Printf("Error reading maintenance state: %s", err.Error())
+	}
+	go apiCfg.syncMaintenanceEvery(context.Background(), runtimeStateRefresh)
+	// So are the routes admins turned debug body logging on for, when it is allowed at all
+	apiCfg.debugBodiesAllowed = os.Getenv("DEBUG_BODY_LOGGING") == "true"
+	if apiCfg.debugBodiesAllowed {
+		_, err = apiCfg.syncDebugBodies(context.Background())
+		if err != nil {
+			log.Printf("Error reading debug body logging state: %s", err.Error())
+		}
+		go apiCfg.syncDebugBodiesEvery(context.Background(), runtimeStateRefresh)
+	}
+	// and the IP rules admins added to those from the environment
+	_, err = apiCfg.syncIPRules(context.Background())
+	if err != nil {
+		log.Printf("Error reading IP rules: %s", err.Error())
+	}
+	go apiCfg.syncIPRulesEvery(context.Background(), runtimeStateRefresh)
+	// and the word-filter rules applied to new chirps
+	_, err = apiCfg.syncWordFilters(context.Background())
+	if err != nil {
+		log.Printf("Error reading word filters: %s", err.Error())
+	}
+	go apiCfg.syncWordFiltersEvery(context.Background(), runtimeStateRefresh)
+	// and the auto-moderation rules evaluated on them
+	_, err = apiCfg.syncAutomod(context.Background())
+	if err != nil {
+		log.Printf("Error reading automod rules: %s", err.Error())
+	}
+	go apiCfg.syncAutomodEvery(context.Background(), runtimeStateRefresh)
+	// and who is shadowbanned, which decides whose reads skip the chirp caches
+	_, err = apiCfg.syncShadowbans(context.Background())
+	if err != nil {
+		log.Printf("Error reading shadowbans: %s", err.Error())
+	}
+	go apiCfg.syncShadowbansEvery(context.Background(), runtimeStateRefresh)
+	// and the IP bans in force, which middlewareIPFilter enforces
+	_, err = apiCfg.syncIPBans(context.Background())
+	if err != nil {
+		log.Printf("Error reading IP bans: %s", err.Error())
+	}
+	go apiCfg.syncIPBansEvery(context.Background(), runtimeStateRefresh)
+	apiCfg.metrics.RegisterCounterFunc("ip_blocked_total", "Requests turned away with 403 by IP_DENYLIST, ADMIN_IP_ALLOWLIST, the admin IP rules or an IP ban.", func() float64 {
+		return float64(apiCfg.ipBlockedCount.Load())
+	})
+	// Background jobs share the jobs table between instances; JOB_WORKERS=0 only enqueues
+	apiCfg.jobs = jobs.New(appStore, jobs.Options{
+		Workers:      getEnvInt("JOB_WORKERS", 4),
+		PollInterval: getEnvDuration("JOB_POLL_INTERVAL", time.Second),
+		MaxAttempts:  getEnvInt("JOB_MAX_ATTEMPTS", 5),
+	})
+	// Webhook deliveries run as background jobs so failed ones are retried with backoff
+	apiCfg.webhooks = webhooks.New(appStore, apiCfg.jobs, webhooks.Options{
+		Timeout:      getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		MaxAttempts:  getEnvInt("WEBHOOK_MAX_ATTEMPTS", 10),
+		AllowPrivate: os.Getenv("WEBHOOK_ALLOW_PRIVATE") == "true",
+	})
+	// Sitemaps link to SITE_URL, the public URL of the default tenant, or else to the host
+	// each request was made to
+	apiCfg.siteURL = os.Getenv("SITE_URL")
+	apiCfg.sitemapURLsPerFile = getEnvInt("SITEMAP_URLS_PER_FILE", sitemapMaxURLs)
+	apiCfg.robotsRules, err = robotsRulesFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid ROBOTS_TXT_FILE: %s", err.Error())
+	}
+	// ActivityPub federation lets accounts be followed from Mastodon and other servers.
+	// ACTIVITYPUB_URL is the public URL the server is reached at; without it there is none.
+	if baseURL := os.Getenv("ACTIVITYPUB_URL"); baseURL != "" {
+		apiCfg.federation, err = activitypub.New(appStore, apiCfg.jobs, activitypub.Options{
+			BaseURL:      baseURL,
+			Timeout:      getEnvDuration("ACTIVITYPUB_TIMEOUT", 10*time.Second),
+			MaxAttempts:  getEnvInt("ACTIVITYPUB_MAX_ATTEMPTS", 10),
+			AllowPrivate: os.Getenv("ACTIVITYPUB_ALLOW_PRIVATE") == "true",
+		})
+		if err != nil {
+			log.Fatalf("Error configuring ActivityPub: %s", err.Error())
+		}
+	}
+	// Domain events are recorded in the outbox with the change they describe and relayed to
+	// webhooks as soon as it commits, or within OUTBOX_RELAY_INTERVAL for other instances' events
+	apiCfg.outboxWake = make(chan struct{}, 1)
+	go apiCfg.relayOutboxEvery(context.Background(), getEnvDuration("OUTBOX_RELAY_INTERVAL", time.Second))
+	apiCfg.metrics.RegisterGaugeFunc("outbox_pending_events", "Outbox events not yet relayed to webhooks.", func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), apiCfg.readinessTimeout)
+		defer cancel()
+		pending, err := apiCfg.store.CountUnpublishedOutboxEvents(ctx)
+		if err != nil {
+			log.Printf("Error counting outbox events: %s", err.Error())
+			return math.NaN()
+		}
+		return float64(pending)
+	})
+	// Emails are rendered from templates and sent by the job queue; EMAIL_PROVIDER=log only logs them
+	emailSender, err := newEmailSender()
+	if err != nil {
+		log.Fatalf("Error configuring email: %s", err.Error())
+	}
+	apiCfg.mailer = email.NewMailer(emailSender, apiCfg.jobs)
+	apiCfg.moderationNotify, err = moderationNotifyFromEnv()
+	if err != nil {
+		log.Fatalf("Error configuring moderation: %s", err.Error())
+	}
+	apiCfg.jobs.Register(bulkDeleteJobKind, apiCfg.runBulkDeleteChirps)
+	apiCfg.jobs.Register(twitterImportJobKind, apiCfg.runTwitterImport)
+	apiCfg.jobs.Start(context.Background())
+	// Recurring maintenance runs on every instance, each slot is claimed by exactly one of them
+	apiCfg.scheduler, err = apiCfg.newScheduler()
+	if err != nil {
+		log.Fatalf("Invalid schedule: %s", err.Error())
+	}
+	apiCfg.scheduler.Start(context.Background())
+	// Listings are turned away with 503 while more than LOAD_SHED_MAX_IN_FLIGHT requests are
+	// running or the p99 latency over LOAD_SHED_WINDOW exceeds LOAD_SHED_TARGET_P99; 0 disables either check
+	apiCfg.shedder = loadshed.New(loadshed.Options{
+		MaxInFlight: getEnvInt("LOAD_SHED_MAX_IN_FLIGHT", 500),
+		TargetP99:   getEnvDuration("LOAD_SHED_TARGET_P99", 2*time.Second),
+		Window:      getEnvDuration("LOAD_SHED_WINDOW", 10*time.Second),
+	})
+	apiCfg.metrics.RegisterGaugeFunc("in_flight_requests", "HTTP requests being served.", func() float64 {
+		return float64(apiCfg.shedder.InFlight())
+	})
+	apiCfg.metrics.RegisterGaugeFunc("request_latency_p99_seconds", "99th percentile HTTP request latency over LOAD_SHED_WINDOW.", func() float64 {
+		return apiCfg.shedder.P99().Seconds()
+	})
+	// At most MAX_CONCURRENT_REQUESTS requests run at once so bursts queue here rather than
+	// for a database connection; 0 disables the limit
+	if maxConcurrent := getEnvInt("MAX_CONCURRENT_REQUESTS", 0); maxConcurrent > 0 {
+		apiCfg.limiter = loadshed.NewLimiter(loadshed.LimiterOptions{
+			MaxConcurrent: maxConcurrent,
+			MaxQueued:     getEnvInt("MAX_QUEUED_REQUESTS", maxConcurrent),
+			QueueTimeout:  getEnvDuration("CONCURRENCY_QUEUE_TIMEOUT", 100*time.Millisecond),
+		})
+		apiCfg.metrics.RegisterGaugeFunc("concurrency_running_requests", "Requests holding a MAX_CONCURRENT_REQUESTS slot.", func() float64 {
+			return float64(apiCfg.limiter.Running())
+		})
+		apiCfg.metrics.RegisterGaugeFunc("concurrency_queue_depth", "Requests waiting for a MAX_CONCURRENT_REQUESTS slot.", func() float64 {
+			return float64(apiCfg.limiter.Queued())
+		})
+		apiCfg.metrics.RegisterCounterFunc("concurrency_rejected_total", "Requests turned away with 429 by the concurrency limit.", func() float64 {
+			return float64(apiCfg.limiter.Rejected())
+		})
+	}
+	// Dev and demo platforms can inject latency, errors and dropped connections for resilience tests
+	apiCfg.faults = apiCfg.newFaultInjector()
+	compressionEnabled := os.Getenv("COMPRESSION_ENABLED") != "false"
+	brotliEnabled := compressionEnabled && os.Getenv("COMPRESSION_BROTLI") != "false"
+	if brotliEnabled {
+		// the frontend bundle dominates bandwidth, so it is compressed once at full quality
+		apiCfg.staticBrotli, err = compress.Precompress(staticFiles)
+		if err != nil {
+			log.Fatalf("Failed to compress static files: %s", err.Error())
+		}
+	}
+	// HTTPS, and the client certificates the admin endpoints require with ADMIN_CLIENT_CA_FILE
+	serverTLS, err := loadServerTLS("")
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %s", err.Error())
+	}
+	adminTLS, err := loadServerTLS("ADMIN_")
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %s", err.Error())
+	}
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if adminTLS == nil {
+		adminTLS = serverTLS
+	}
+	apiCfg.adminClientCAs, err = loadAdminClientCAs()
+	if err != nil {
+		log.Fatalf("Invalid ADMIN_CLIENT_CA_FILE: %s", err.Error())
+	}
+	if apiCfg.adminClientCAs != nil && adminTLS == nil {
+		log.Fatalf("ADMIN_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE, client certificates are only sent over TLS")
+	}
+	apiCfg.errorReporter = newErrorReporter(platform)
+	apiCfg.accessLog = newAccessLog()
+	apiCfg.requestTimeout = getEnvDuration("REQUEST_TIMEOUT", 15*time.Second)
+	apiCfg.requestTimeouts = getEnvDurations("REQUEST_TIMEOUTS")
+	// Set up HTTP router and register route handlers
+	mux := apiCfg.routes()
+	if os.Getenv("PPROF_ENABLED") == "true" {
+		apiCfg.registerPprof(mux)
+	}
+	// Configure and start HTTP server
+	// Middleware is applied inside-out, so the last wrapper added runs first
+	var handler http.Handler = mux
+	handler = apiCfg.middlewareFaults(mux, handler)
+	// bodies are logged as the handler wrote them, before compression
+	handler = apiCfg.middlewareDebugBodies(mux, handler)
+	if compressionEnabled {
+		encodings := []string{"gzip"}
+		if brotliEnabled {
+			encodings = append([]string{"br"}, encodings...)
+		}
+		if os.Getenv("COMPRESSION_ZSTD") == "true" {
+			encodings = append([]string{"zstd"}, encodings...)
+		}
+		compressionMinSize, err := strconv.Atoi(getEnvDefault("COMPRESSION_MIN_SIZE", "1024"))
+		if err != nil {
+			log.Fatalf("Invalid COMPRESSION_MIN_SIZE: %s", err.Error())
+		}
+		handler = compress.New(compressionMinSize, encodings...).Middleware(handler)
+	}
+	handler = apiCfg.middlewareQuota(handler)
+	handler = apiCfg.middlewareBodyLimit(handler)
+	// API keys are held to their own limits on top of the per-address one
+	handler = apiCfg.middlewareAPIKey(handler)
+	handler = apiCfg.middlewareRateLimit(handler)
+	handler = apiCfg.middlewareMaintenance(handler)
+	handler = apiCfg.middlewareTenant(handler)
+	handler = apiCfg.middlewareConcurrencyLimit(handler)
+	handler = apiCfg.middlewareLoadShed(mux, handler)
+	handler = apiCfg.middlewareAdminClientCert(handler)
+	// blocked sources are turned away before they use up any limit or reach a login
+	handler = apiCfg.middlewareIPFilter(handler)
+	handler = apiCfg.metrics.Middleware(mux, handler)
+	handler = apiCfg.middlewareErrorReporting(mux, handler)
+	handler = apiCfg.middlewareAccessLog(mux, handler)
+	handler = middlewareRequestID(handler)
+	if tracingEnabled {
+		handler = tracing.Middleware(mux, handler)
+	}
+	// Under systemd socket activation or in an upgrade the listeners are inherited rather than opened here
+	listener, grpcListener, adminListener, err := inheritedListeners()
+	if err != nil {
+		log.Fatalf("Error using inherited sockets: %s", err.Error())
+	}
+	if upgrading() {
+		// the old process leaves the socket file behind, this one removes it when it stops
+		setUnlinkOnClose(listener, true)
+	}
+	// The gRPC API is opt-in and listens on its own port
+	grpcAddr := os.Getenv("GRPC_ADDR")
+	if grpcListener == nil && grpcAddr != "" {
+		grpcListener, err = net.Listen("tcp", grpcAddr)
+		if err != nil {
+			log.Fatalf("Error listening for gRPC on %s: %s", grpcAddr, err.Error())
+		}
+	}
+	var grpcServer *grpc.Server
+	if grpcListener != nil {
+		grpcServer = apiCfg.newGRPCServer()
+		go func() {
+			err := grpcServer.Serve(grpcListener)
+			if err != nil {
+				log.Printf("gRPC server stopped: %s", err.Error())
+			}
+		}()
+		log.Printf("Serving gRPC on %s", grpcListener.Addr())
+	}
+	// So are the admin endpoints when ADMIN_ADDR is set, the public listener then leaves them out
+	if adminListener == nil && adminAddr != "" {
+		adminListener, err = net.Listen("tcp", adminAddr)
+		if err != nil {
+			log.Fatalf("Error listening for admin requests on %s: %s", adminAddr, err.Error())
+		}
+	}
+	if listener == nil {
+		socketMode, err := strconv.ParseUint(getEnvDefault("LISTEN_SOCKET_MODE", "0660"), 8, 32)
+		if err != nil {
+			log.Fatalf("Invalid LISTEN_SOCKET_MODE: %s", err.Error())
+		}
+		listener, err = listen(*addr, os.FileMode(socketMode), os.Getenv("LISTEN_SOCKET_GROUP"))
+		if err != nil {
+			log.Fatalf("Error listening on %s: %s", *addr, err.Error())
+		}
+	}
+	srv := http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: getEnvDuration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       getEnvDuration("SERVER_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      getEnvDuration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       getEnvDuration("SERVER_IDLE_TIMEOUT", 120*time.Second),
+		TLSConfig:         serverTLS,
+	}
+	var adminSrv *http.Server
+	if adminListener != nil {
+		srv.Handler = withoutAdmin(handler)
+		adminSrv = &http.Server{
+			Handler:           adminOnly(handler),
+			ReadHeaderTimeout: srv.ReadHeaderTimeout,
+			ReadTimeout:       srv.ReadTimeout,
+			WriteTimeout:      srv.WriteTimeout,
+			IdleTimeout:       srv.IdleTimeout,
+			TLSConfig:         adminTLS,
+		}
+		if apiCfg.adminClientCAs != nil {
+			// the handshake itself fails without a certificate, before any request is read
+			adminSrv.TLSConfig = withClientCAs(adminTLS, apiCfg.adminClientCAs, true)
+		}
+		go func() {
+			err := serveHTTP(adminSrv, adminListener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Admin server stopped: %s", err.Error())
+			}
+		}()
+		log.Printf("Serving admin endpoints on %s", adminListener.Addr())
+	} else if apiCfg.adminClientCAs != nil {
+		// public clients have no certificate, middlewareAdminClientCert asks for one on /admin/
+		srv.TLSConfig = withClientCAs(serverTLS, apiCfg.adminClientCAs, false)
+	}
+	// SIGINT and SIGTERM drain open requests and close the listener, which also removes a Unix
+	// socket file this process created. Sockets inherited from systemd stay in place.
+	drain := newDrainListener(listener)
+	conns := &connCounter{}
+	srv.ConnState = conns.track
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		signalCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stopSignals()
+		<-signalCtx.Done()
+		log.Printf("Shutting down")
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), getEnvDuration("SHUTDOWN_TIMEOUT", 30*time.Second))
+		defer cancelShutdown()
+		shutdownErr := drainAndShutdown(shutdownCtx, &srv, drain, conns)
+		if shutdownErr != nil {
+			log.Printf("Error draining HTTP connections: %s", shutdownErr.Error())
+		}
+		if adminSrv != nil {
+			shutdownErr = adminSrv.Shutdown(shutdownCtx)
+			if shutdownErr != nil {
+				log.Printf("Error draining admin connections: %s", shutdownErr.Error())
+			}
+		}
+	}()
+	upgradeOnSIGUSR2(listener, grpcListener, adminListener)
+	if pidFile := os.Getenv("PID_FILE"); pidFile != "" {
+		err := writePIDFile(pidFile)
+		if err != nil {
+			log.Printf("Error writing PID_FILE: %s", err.Error())
+		}
+	}
+	finishUpgrade()
+	protocol := "HTTP"
+	if srv.TLSConfig != nil {
+		protocol = "HTTPS"
+	}
+	log.Printf("Serving %s on %s:%s", protocol, listener.Addr().Network(), listener.Addr())
+	err = serveHTTP(&srv, drain)
+	if errors.Is(err, http.ErrServerClosed) {
+		<-shutdownDone
+		err = nil
+	}
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	// let running jobs and scheduled tasks finish, then save counted hits and flush buffered spans before exiting
+	stopCtx, cancelStop := context.WithTimeout(context.Background(), getEnvDuration("JOB_SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancelStop()
+	stopErr := apiCfg.jobs.Stop(stopCtx)
+	if stopErr != nil {
+		log.Printf("Error stopping job workers: %s", stopErr.Error())
+	}
+	stopErr = apiCfg.scheduler.Stop(stopCtx)
+	if stopErr != nil {
+		log.Printf("Error stopping scheduled tasks: %s", stopErr.Error())
+	}
+	flushErr := apiCfg.flushVisits(context.Background())
+	if flushErr != nil {
+		log.Printf("Error saving visit counts: %s", flushErr.Error())
+	}
+	shutdownTracing(context.Background())
+	if apiCfg.errorReporter != nil {
+		apiCfg.errorReporter.Flush(5 * time.Second)
+	}
+	return err
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/automod"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/diamondoughnut/httpChirpy/internal/validation"
+	"github.com/diamondoughnut/httpChirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+// The operations shared by the HTTP handlers and the gRPC service. They know nothing
+// about the transport; each caller maps the errors to its own status codes.
+
+// An error caused by the request content, answered with 400 over HTTP and
+// InvalidArgument over gRPC
+type invalidInputError struct {
+	message string
+}
+
+func (e invalidInputError) Error() string {
+	return e.message
+}
+
+var errInvalidPassword = errors.New("incorrect password")
+
+// Deleting a chirp of another user
+var errNotChirpAuthor = errors.New("no authorization to delete chirp")
+
+// Registration in a tenant that has as many users as its max_users allows
+var errTenantUserLimit = &apiError{Code: "tenant_user_limit", Message: "this tenant has reached its user limit"}
+
+// An access token used in a tenant its user does not belong to
+var errOtherTenant = &apiError{Code: "wrong_tenant", Message: "the user does not belong to this tenant"}
+
+// Returns the user whose access token is in the Authorization header
+func (cfg *apiConfig) userFromHeader(header http.Header) (uuid.UUID, error) {
+	token, err := auth.GetBearerToken(header)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return cfg.validateJWT(token)
+}
+
+// Creates an account in the caller's tenant. Callers decide whether registration is
+// open on this platform.
+func (cfg *apiConfig) registerUser(ctx context.Context, email, password string) (database.User, error) {
+	if fields := validation.Struct(credentials{Email: email, Password: password}); len(fields) > 0 {
+		return database.User{}, invalidInputError{fmt.Sprintf("%s %s", fields[0].Field, fields[0].Message)}
+	}
+	tenant := tenantFrom(ctx)
+	if tenant.MaxUsers > 0 {
+		count, err := cfg.store.CountUsersByTenant(ctx, tenant.ID)
+		if err != nil {
+			return database.User{}, err
+		}
+		if count >= int64(tenant.MaxUsers) {
+			return database.User{}, errTenantUserLimit
+		}
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return database.User{}, err
+	}
+	return cfg.store.CreateUser(ctx, database.CreateUserParams{Email: email, HashedPassword: hashedPassword, TenantID: tenant.ID})
+}
+
+// Checks the credentials and issues an access token and a refresh token. An unknown
+// email returns sql.ErrNoRows and a wrong password errInvalidPassword.
+func (cfg *apiConfig) login(ctx context.Context, email, password string) (User, error) {
+	user, err := cfg.checkCredentials(ctx, email, password)
+	if err != nil {
+		return User{}, err
+	}
+	token, err := cfg.makeJWT(user.ID)
+	if err != nil {
+		return User{}, err
+	}
+	refreshToken, err := cfg.issueRefreshToken(ctx, user.ID)
+	if err != nil {
+		return User{}, err
+	}
+	return User{
+		ID:           user.ID,
+		Email:        user.Email,
+		CreatedAt:    user.CreatedAt,
+		UpdatedAt:    user.UpdatedAt,
+		Token:        token,
+		RefreshToken: refreshToken,
+		IsChirpyRed:  user.IsChirpyRed,
+	}, nil
+}
+
+// Returns the user of the caller's tenant with these credentials. An unknown email
+// returns sql.ErrNoRows and a wrong password errInvalidPassword.
+func (cfg *apiConfig) checkCredentials(ctx context.Context, email, password string) (database.User, error) {
+	user, err := cfg.store.GetUserByEmail(ctx, database.GetUserByEmailParams{TenantID: tenantID(ctx), Email: email})
+	if err != nil {
+		return database.User{}, err
+	}
+	err = auth.CheckHashPassword(password, user.HashedPassword)
+	if err != nil {
+		return database.User{}, fmt.Errorf("%w: %w", errInvalidPassword, err)
+	}
+	return user, nil
+}
+
+// Creates a refresh token for userID, valid for 60 days
+func (cfg *apiConfig) issueRefreshToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = cfg.store.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		UserID:    userID,
+		Token:     token,
+		ExpiresAt: time.Now().Add(time.Hour * 24 * 60).UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Validates and cleans a chirp and runs the auto-moderation rules on it, then stores it
+// along with its chirp.created event and adds it to the caches. A chirp a rule or word
+// filter holds for review is only announced and cached once a moderator approves it.
+func (cfg *apiConfig) createChirp(ctx context.Context, userID uuid.UUID, body string) (database.Chirp, error) {
+	cleaned, held, err := validate(createChirpRequest{Body: body}, cfg.settings.Load().profanity, cfg.wordFilter.Load())
+	if err != nil {
+		return database.Chirp{}, invalidInputError{err.Error()}
+	}
+	err = cfg.checkAutomodLimit(ctx, userID)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	engine := cfg.automod.Load()
+	in, err := cfg.automodInput(ctx, engine, userID, body)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	verdict := engine.Evaluate(in)
+	if verdict.Action == automod.ActionReject {
+		return database.Chirp{}, invalidInputError{errChirpRejectedAutomod.Error()}
+	}
+	hold := verdict.Action == automod.ActionHold || len(held) > 0
+	var chirp database.Chirp
+	var limit *database.AutomodRestriction
+	create := func(tx store.Store) (any, error) {
+		var err error
+		chirp, err = tx.CreateChirp(ctx, database.CreateChirpParams{Body: cleaned, UserID: userID, TenantID: tenantID(ctx)})
+		if err != nil {
+			return nil, err
+		}
+		if len(held) > 0 {
+			// flagged in the moderation queue rather than reported by a user
+			_, err = tx.CreateChirpReport(ctx, database.CreateChirpReportParams{
+				ChirpID:  chirp.ID,
+				AuthorID: userID,
+				TenantID: chirp.TenantID,
+				Reason:   wordFilterReason,
+				Details:  heldDetails(held),
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		limit, err = recordAutomodResult(ctx, tx, chirp, verdict)
+		if err != nil {
+			return nil, err
+		}
+		// counted after the chirp so a user of another tenant fails as before, and
+		// rolled back with it when the quota is used up
+		_, err = cfg.takeQuota(ctx, tx, userID, quotaChirps)
+		return chirpCreatedPayload(chirp), err
+	}
+	if hold {
+		err = cfg.store.WithTx(ctx, func(tx store.Store) error {
+			_, err := create(tx)
+			return err
+		})
+	} else {
+		err = cfg.changeWithEvent(ctx, webhooks.ChirpCreated, create)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.Chirp{}, errOtherTenant
+	}
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	if limit != nil {
+		cfg.auditAutomodLimit(ctx, *limit)
+	}
+	if !hold {
+		cfg.cacheCreatedChirp(ctx, chirp)
+	}
+	return chirp, nil
+}
+
+// Deletes one of userID's chirps along with its chirp.deleted event and drops it from
+// the caches. A chirp userID cannot see returns sql.ErrNoRows and another user's
+// errNotChirpAuthor.
+func (cfg *apiConfig) deleteChirp(ctx context.Context, userID, id uuid.UUID) (database.Chirp, error) {
+	chirp, err := cfg.store.GetChirpById(ctx, database.GetChirpByIdParams{ID: id, TenantID: tenantID(ctx), ViewerID: userID})
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	if userID != chirp.UserID {
+		return database.Chirp{}, errNotChirpAuthor
+	}
+	err = cfg.changeWithEvent(ctx, webhooks.ChirpDeleted, func(tx store.Store) (any, error) {
+		err := tx.DeleteChirpById(ctx, database.DeleteChirpByIdParams{ID: id, UserID: userID, TenantID: chirp.TenantID})
+		return webhooks.ChirpDeletedData{ID: chirp.ID, UserID: chirp.UserID}, err
+	})
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	cfg.invalidateChirp(ctx, chirp)
+	return chirp, nil
+}
+
+// Data of the chirp.created event
+func chirpCreatedPayload(chirp database.Chirp) webhooks.ChirpCreatedData {
+	return webhooks.ChirpCreatedData{ID: chirp.ID, Body: chirp.Body, UserID: chirp.UserID, CreatedAt: chirp.CreatedAt}
+}
+
+// Lists chirps oldest first, or newest first when descending. uuid.Nil lists every author.
+func (cfg *apiConfig) listChirps(ctx context.Context, authorID uuid.UUID, descending bool) ([]database.Chirp, error) {
+	var chirps []database.Chirp
+	var err error
+	if authorID == uuid.Nil {
+		chirps, err = cfg.getChirps(ctx)
+	} else {
+		chirps, err = cfg.getChirpsByAuthor(ctx, authorID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if descending {
+		chirps = slices.Clone(chirps)
+		slices.Reverse(chirps)
+	}
+	return chirps, nil
+}
+
+// Reads one page of what listChirps lists straight from the store, starting at offset,
+// and the number of chirps on every page together. Pages bypass the timeline cache, so
+// the viewer's own chirps are only shown to them when the cache would not be used either.
+func (cfg *apiConfig) listChirpsPage(ctx context.Context, authorID uuid.UUID, descending bool, page, perPage int) ([]database.Chirp, int, error) {
+	tenant, viewer := tenantID(ctx), viewerID(ctx)
+	if !cfg.isShadowbanned(viewer) {
+		viewer = uuid.Nil
+	}
+	total, err := cfg.store.CountChirpsPage(ctx, database.CountChirpsPageParams{
+		TenantID:   tenant,
+		AllAuthors: authorID == uuid.Nil,
+		AuthorID:   authorID,
+		ViewerID:   viewer,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	offset := pageStart(int(total), page, perPage)
+	var chirps []database.Chirp
+	if descending {
+		chirps, err = cfg.store.ListChirpsPageDesc(ctx, database.ListChirpsPageDescParams{
+			TenantID:   tenant,
+			AllAuthors: authorID == uuid.Nil,
+			AuthorID:   authorID,
+			ViewerID:   viewer,
+			RowLimit:   int32(perPage),
+			RowOffset:  int32(offset),
+		})
+	} else {
+		chirps, err = cfg.store.ListChirpsPage(ctx, database.ListChirpsPageParams{
+			TenantID:   tenant,
+			AllAuthors: authorID == uuid.Nil,
+			AuthorID:   authorID,
+			ViewerID:   viewer,
+			RowLimit:   int32(perPage),
+			RowOffset:  int32(offset),
+		})
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return chirps, int(total), nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// Request body of shadowbanning a user
+type shadowbanRequest struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+type shadowbanResponse struct {
+	UserID    uuid.UUID `json:"user_id"`
+	TenantID  uuid.UUID `json:"tenant_id"`
+	Reason    string    `json:"reason"`
+	BannedBy  string    `json:"banned_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newShadowbanResponse(ban database.Shadowban) shadowbanResponse {
+	return shadowbanResponse{
+		UserID:    ban.UserID,
+		TenantID:  ban.TenantID,
+		Reason:    ban.Reason,
+		BannedBy:  ban.BannedBy,
+		CreatedAt: ban.CreatedAt,
+	}
+}
+
+type viewerKey struct{}
+
+// Returns ctx reading chirps as the user viewer, who sees their own chirps even when
+// they are shadowbanned
+func withViewer(ctx context.Context, viewer uuid.UUID) context.Context {
+	return context.WithValue(ctx, viewerKey{}, viewer)
+}
+
+// Returns who is reading chirps, uuid.Nil for an anonymous reader
+func viewerID(ctx context.Context) uuid.UUID {
+	viewer, _ := ctx.Value(viewerKey{}).(uuid.UUID)
+	return viewer
+}
+
+// Reads the access token public chirp reads may send, so shadowbanned users see their
+// own chirps. Like /graphql, a token that does not validate is refused with 401 rather
+// than ignored.
+func (cfg *apiConfig) middlewareViewer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		viewer, err := cfg.userFromHeader(r.Header)
+		if err != nil {
+			log.Printf("Error validating token: %s", err.Error())
+			marshallError(w, err, 401)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withViewer(r.Context(), viewer)))
+	})
+}
+
+// Reports whether userID is shadowbanned as of the last sync. The read queries enforce
+// shadowbans themselves; this only decides whose reads bypass the caches, which hold
+// chirps as anyone else sees them.
+func (cfg *apiConfig) isShadowbanned(userID uuid.UUID) bool {
+	banned := cfg.shadowbanned.Load()
+	return banned != nil && (*banned)[userID]
+}
+
+// Loads the shadowbanned users into cfg.shadowbanned and returns their shadowbans
+func (cfg *apiConfig) syncShadowbans(ctx context.Context) ([]database.Shadowban, error) {
+	bans, err := cfg.store.ListShadowbans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	banned := make(map[uuid.UUID]bool, len(bans))
+	for _, ban := range bans {
+		banned[ban.UserID] = true
+	}
+	cfg.shadowbanned.Store(&banned)
+	return bans, nil
+}
+
+// Picks up shadowbans changed on other instances every interval until ctx is done. A
+// failed read keeps the last known set.
+func (cfg *apiConfig) syncShadowbansEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := cfg.syncShadowbans(ctx)
+			if err != nil {
+				log.Printf("Error reading shadowbans: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Lists the shadowbanned users of every tenant, oldest shadowban first
+func (cfg *apiConfig) handlerListShadowbans(w http.ResponseWriter, r *http.Request) {
+	bans, err := cfg.syncShadowbans(r.Context())
+	if err != nil {
+		log.Printf("Error reading shadowbans: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]shadowbanResponse, 0, len(bans))
+	for _, ban := range bans {
+		resp = append(resp, newShadowbanResponse(ban))
+	}
+	render(w, r, 200, resp)
+}
+
+// Shadowbans the user in the path, or replaces the reason they are shadowbanned for.
+// Their chirps stay visible to themselves and admins but disappear for everyone else.
+func (cfg *apiConfig) handlerPutShadowban(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid user id"), 400)
+		return
+	}
+	params := shadowbanRequest{}
+	err = decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	ban, err := cfg.store.SetShadowban(r.Context(), database.SetShadowbanParams{UserID: userID, Reason: params.Reason, BannedBy: auditActor(r)})
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("user %s not found", userID), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error shadowbanning user: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.shadowbansChanged(r.Context(), ban.TenantID, ban.UserID)
+	cfg.recordAudit(r, "shadowban.set", userID.String(), newShadowbanResponse(ban))
+	log.Printf("User %s shadowbanned", userID)
+	render(w, r, 200, newShadowbanResponse(ban))
+}
+
+// Lifts the shadowban of the user in the path
+func (cfg *apiConfig) handlerDeleteShadowban(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("userID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid user id"), 400)
+		return
+	}
+	ban, err := cfg.store.DeleteShadowban(r.Context(), userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("user %s is not shadowbanned", userID), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error lifting shadowban: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.shadowbansChanged(r.Context(), ban.TenantID, ban.UserID)
+	cfg.recordAudit(r, "shadowban.delete", userID.String(), nil)
+	log.Printf("Shadowban of user %s lifted", userID)
+	w.WriteHeader(204)
+}
+
+// Applies a change on this instance right away and drops the user's chirps from the
+// cached timelines. Other instances pick it up with syncShadowbansEvery, and their
+// in-process caches within CHIRP_CACHE_TTL.
+func (cfg *apiConfig) shadowbansChanged(ctx context.Context, tenantID, userID uuid.UUID) {
+	_, err := cfg.syncShadowbans(ctx)
+	if err != nil {
+		log.Printf("Error reading shadowbans: %s", err.Error())
+	}
+	cfg.invalidateTimelines(ctx, tenantID, userID)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Wraps the HTTP listener so shutdown can stop accepting connections before the server
+// closes the ones it holds. http.Server.Shutdown drops requests that arrive on connections
+// it accepted but had not read from yet, which happens to every client connecting just
+// before it is called, and during an upgrade that is a steady stream of them.
+type drainListener struct {
+	net.Listener
+	stopped   chan struct{}
+	idle      chan struct{}
+	closed    chan struct{}
+	stopOnce  sync.Once
+	idleOnce  sync.Once
+	closeOnce sync.Once
+}
+
+func newDrainListener(l net.Listener) *drainListener {
+	return &drainListener{
+		Listener: l,
+		stopped:  make(chan struct{}),
+		idle:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Once accepting has stopped, blocks until Close so the server does not mistake the
+// closed socket for a failure
+func (l *drainListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		return conn, nil
+	}
+	select {
+	case <-l.stopped:
+		l.idleOnce.Do(func() { close(l.idle) })
+		<-l.closed
+		return nil, net.ErrClosed
+	default:
+		return nil, err
+	}
+}
+
+func (l *drainListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		select {
+		case <-l.stopped:
+		default:
+			err = l.Listener.Close()
+		}
+	})
+	return err
+}
+
+// Closes the socket and waits until the server is no longer accepting from it, so every
+// connection it took is known to the server
+func (l *drainListener) stopAccepting(ctx context.Context) error {
+	var err error
+	l.stopOnce.Do(func() {
+		close(l.stopped)
+		err = l.Listener.Close()
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case <-l.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Counts the server's open connections, set as its ConnState hook
+type connCounter struct {
+	open atomic.Int64
+}
+
+func (c *connCounter) track(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		c.open.Add(1)
+	case http.StateClosed, http.StateHijacked:
+		c.open.Add(-1)
+	}
+}
+
+// Stops the HTTP server without dropping a request: it stops accepting, answers what the
+// open connections send and closes each of them after its response, then shuts down.
+// Connections still open when ctx is done are closed by Shutdown.
+func drainAndShutdown(ctx context.Context, srv *http.Server, listener *drainListener, conns *connCounter) error {
+	err := listener.stopAccepting(ctx)
+	if err != nil {
+		return err
+	}
+	// closes idle connections now and every other one once it has answered
+	srv.SetKeepAlivesEnabled(false)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for conns.open.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return srv.Shutdown(ctx)
+		case <-ticker.C:
+		}
+	}
+	return srv.Shutdown(ctx)
+}
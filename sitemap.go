@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	sitemapKindChirps = "chirps"
+	sitemapKindUsers  = "users"
+	// the most URLs the sitemap protocol allows in one file
+	sitemapMaxURLs   = 50000
+	sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	sitemapStateName = "sitemaps"
+	// how far before its last run the refresh looks again, for rows whose transaction
+	// committed after it read past them
+	sitemapRefreshOverlap = time.Minute
+)
+
+// What robots.txt says unless ROBOTS_DISALLOW or ROBOTS_TXT_FILE say otherwise
+const defaultRobotsRules = "User-agent: *\nDisallow: /api/\nDisallow: /admin/\n"
+
+// Crawlers come back for sitemaps on their own schedule, and a day changes at most once
+// per refresh
+var sitemapCachePolicy = cachePolicy{maxAge: time.Hour, sharedMaxAge: time.Hour}
+
+// chirps-2026-10-15.xml, and chirps-2026-10-15-2.xml and on for days over the URL limit
+var sitemapFilePattern = regexp.MustCompile(`^(chirps|users)-(\d{4}-\d{2}-\d{2})(?:-(\d+))?\.xml$`)
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// A chirp or user a sitemap lists
+type sitemapEntry struct {
+	ID        uuid.UUID
+	UpdatedAt time.Time
+}
+
+// When the refresh last ran, stored in runtime_state
+type sitemapState struct {
+	RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+// Registers a route crawlers expect at a fixed path at the root
+func (cfg *apiConfig) handleSitemap(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	mux.Handle(pattern, withCachePolicy(sitemapCachePolicy.header(), withTimeout(cfg.routeTimeout(pattern), handler)))
+}
+
+// Helper function to read the robots.txt rules: the file ROBOTS_TXT_FILE names, else a
+// Disallow line per path of ROBOTS_DISALLOW, else defaultRobotsRules
+func robotsRulesFromEnv() (string, error) {
+	if name := os.Getenv("ROBOTS_TXT_FILE"); name != "" {
+		dat, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		return string(dat), nil
+	}
+	paths, ok := os.LookupEnv("ROBOTS_DISALLOW")
+	if !ok {
+		return defaultRobotsRules, nil
+	}
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	// an empty Disallow allows everything
+	if strings.TrimSpace(paths) == "" {
+		b.WriteString("Disallow:\n")
+	}
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			fmt.Fprintf(&b, "Disallow: %s\n", path)
+		}
+	}
+	return b.String(), nil
+}
+
+// Returns the URLs per sitemap file, SITEMAP_URLS_PER_FILE up to the protocol's limit
+func (cfg *apiConfig) sitemapFileSize() int {
+	if cfg.sitemapURLsPerFile <= 0 || cfg.sitemapURLsPerFile > sitemapMaxURLs {
+		return sitemapMaxURLs
+	}
+	return cfg.sitemapURLsPerFile
+}
+
+// The origin sitemap links point to: SITE_URL for the default tenant, else the host the
+// request was made to, since other tenants are reached at their own subdomains
+func (cfg *apiConfig) siteOrigin(r *http.Request) string {
+	if cfg.siteURL != "" && tenantID(r.Context()) == uuid.Nil {
+		return strings.TrimSuffix(cfg.siteURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// Returns the start of the UTC day containing t, which names the sitemap t is listed in
+func sitemapDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+// Reads a page of the public chirps posted, or users who signed up, on a day, oldest first
+func (cfg *apiConfig) sitemapEntries(ctx context.Context, tenant uuid.UUID, kind string, day time.Time, offset, limit int) ([]sitemapEntry, error) {
+	var entries []sitemapEntry
+	if kind == sitemapKindUsers {
+		rows, err := cfg.store.ListSitemapUsers(ctx, database.ListSitemapUsersParams{
+			TenantID:  tenant,
+			Since:     day,
+			Until:     day.AddDate(0, 0, 1),
+			RowLimit:  int32(limit),
+			RowOffset: int32(offset),
+		})
+		for _, row := range rows {
+			entries = append(entries, sitemapEntry{ID: row.ID, UpdatedAt: row.UpdatedAt})
+		}
+		return entries, err
+	}
+	rows, err := cfg.store.ListSitemapChirps(ctx, database.ListSitemapChirpsParams{
+		TenantID:  tenant,
+		Since:     day,
+		Until:     day.AddDate(0, 0, 1),
+		RowLimit:  int32(limit),
+		RowOffset: int32(offset),
+	})
+	for _, row := range rows {
+		entries = append(entries, sitemapEntry{ID: row.ID, UpdatedAt: row.UpdatedAt})
+	}
+	return entries, err
+}
+
+// Recounts the days that chirps and users posted, edited or signed up since the last
+// refresh fall on, and returns how many it recounted. The first refresh counts every
+// day. A day's count only catches up with chirps deleted from it when it next changes;
+// its sitemap leaves them out straight away.
+func (cfg *apiConfig) refreshSitemaps(ctx context.Context, now time.Time) (int, error) {
+	var state sitemapState
+	row, err := cfg.store.GetRuntimeState(ctx, sitemapStateName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if err == nil {
+		json.Unmarshal([]byte(row.Value), &state)
+	}
+	since := state.RefreshedAt
+	if !since.IsZero() {
+		since = since.Add(-sitemapRefreshOverlap)
+	}
+	type pageKey struct {
+		tenantID uuid.UUID
+		kind     string
+		day      time.Time
+	}
+	changed := make(map[pageKey]bool)
+	chirps, err := cfg.store.ListChirpsUpdatedSince(ctx, since)
+	if err != nil {
+		return 0, err
+	}
+	for _, chirp := range chirps {
+		changed[pageKey{chirp.TenantID, sitemapKindChirps, sitemapDay(chirp.CreatedAt)}] = true
+	}
+	users, err := cfg.store.ListUsersUpdatedSince(ctx, since)
+	if err != nil {
+		return 0, err
+	}
+	for _, user := range users {
+		changed[pageKey{user.TenantID, sitemapKindUsers, sitemapDay(user.CreatedAt)}] = true
+	}
+	for key := range changed {
+		err = cfg.refreshSitemapPage(ctx, key.tenantID, key.kind, key.day)
+		if err != nil {
+			return 0, err
+		}
+	}
+	dat, err := json.Marshal(sitemapState{RefreshedAt: now})
+	if err != nil {
+		return 0, err
+	}
+	_, err = cfg.store.SetRuntimeState(ctx, database.SetRuntimeStateParams{Name: sitemapStateName, Value: string(dat)})
+	if err != nil {
+		return 0, err
+	}
+	return len(changed), nil
+}
+
+// Counts what a day's sitemap lists and when the newest of it changed, dropping the day
+// when nothing is left
+func (cfg *apiConfig) refreshSitemapPage(ctx context.Context, tenant uuid.UUID, kind string, day time.Time) error {
+	var urls int64
+	var lastModified time.Time
+	for offset := 0; ; offset += sitemapMaxURLs {
+		entries, err := cfg.sitemapEntries(ctx, tenant, kind, day, offset, sitemapMaxURLs)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			urls++
+			if entry.UpdatedAt.After(lastModified) {
+				lastModified = entry.UpdatedAt
+			}
+		}
+		if len(entries) < sitemapMaxURLs {
+			break
+		}
+	}
+	if urls == 0 {
+		return cfg.store.DeleteSitemapPage(ctx, database.DeleteSitemapPageParams{TenantID: tenant, Kind: kind, Day: day})
+	}
+	return cfg.store.UpsertSitemapPage(ctx, database.UpsertSitemapPageParams{
+		TenantID:     tenant,
+		Kind:         kind,
+		Day:          day,
+		Urls:         urls,
+		LastModified: lastModified.UTC(),
+	})
+}
+
+func writeSitemap(w http.ResponseWriter, v any) {
+	dat, err := xml.Marshal(v)
+	if err != nil {
+		log.Printf("Error marshalling sitemap: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	writeBody(w, 200, "application/xml; charset=utf-8", append([]byte(xml.Header), dat...))
+}
+
+// Lists the sitemaps of the tenant's days, each split into files of at most
+// SITEMAP_URLS_PER_FILE URLs
+func (cfg *apiConfig) handlerSitemapIndex(w http.ResponseWriter, r *http.Request) {
+	pages, err := cfg.store.ListSitemapPages(r.Context(), tenantID(r.Context()))
+	if err != nil {
+		log.Printf("Error listing sitemap pages: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	origin := cfg.siteOrigin(r)
+	size := int64(cfg.sitemapFileSize())
+	index := sitemapIndex{Xmlns: sitemapNamespace, Sitemaps: []sitemapURL{}}
+	for _, page := range pages {
+		name := page.Kind + "-" + page.Day.UTC().Format(time.DateOnly)
+		for part := int64(1); part == 1 || (part-1)*size < page.Urls; part++ {
+			file := name
+			if part > 1 {
+				file += "-" + strconv.FormatInt(part, 10)
+			}
+			index.Sitemaps = append(index.Sitemaps, sitemapURL{
+				Loc:     origin + "/sitemaps/" + file + ".xml",
+				LastMod: page.LastModified.UTC().Format(time.RFC3339),
+			})
+		}
+	}
+	writeSitemap(w, index)
+}
+
+// Lists the public chirps posted, or the users who signed up, on one day, linking to
+// their pages of the frontend
+func (cfg *apiConfig) handlerSitemap(w http.ResponseWriter, r *http.Request) {
+	match := sitemapFilePattern.FindStringSubmatch(r.PathValue("file"))
+	if match == nil {
+		marshallError(w, errors.New("sitemap not found"), 404)
+		return
+	}
+	kind := match[1]
+	day, err := time.Parse(time.DateOnly, match[2])
+	if err != nil {
+		marshallError(w, errors.New("sitemap not found"), 404)
+		return
+	}
+	part := 1
+	if match[3] != "" {
+		part, err = strconv.Atoi(match[3])
+		if err != nil || part < 2 {
+			marshallError(w, errors.New("sitemap not found"), 404)
+			return
+		}
+	}
+	size := cfg.sitemapFileSize()
+	entries, err := cfg.sitemapEntries(r.Context(), tenantID(r.Context()), kind, day, (part-1)*size, size)
+	if err != nil {
+		log.Printf("Error listing sitemap entries: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if len(entries) == 0 {
+		marshallError(w, errors.New("sitemap not found"), 404)
+		return
+	}
+	prefix := cfg.siteOrigin(r) + "/app/" + kind + "/"
+	set := sitemapURLSet{Xmlns: sitemapNamespace, URLs: make([]sitemapURL, 0, len(entries))}
+	for _, entry := range entries {
+		set.URLs = append(set.URLs, sitemapURL{Loc: prefix + entry.ID.String(), LastMod: entry.UpdatedAt.UTC().Format(time.RFC3339)})
+	}
+	writeSitemap(w, set)
+}
+
+// Serves the robots.txt rules, pointing crawlers to the sitemap
+func (cfg *apiConfig) handlerRobotsTxt(w http.ResponseWriter, r *http.Request) {
+	rules := cfg.robotsRules
+	if rules == "" {
+		rules = defaultRobotsRules
+	}
+	if !strings.HasSuffix(rules, "\n") {
+		rules += "\n"
+	}
+	body := fmt.Sprintf("%s\nSitemap: %s/sitemap.xml\n", rules, cfg.siteOrigin(r))
+	writeBody(w, 200, "text/plain; charset=utf-8", []byte(body))
+}
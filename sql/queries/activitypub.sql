@@ -0,0 +1,60 @@
+-- Stores a user's key pair, or leaves the one they already have. Either way it returns
+-- the key the user ends up with, so concurrent first requests agree on one.
+-- name: CreateActivityPubKey :one
+INSERT INTO activitypub_keys (user_id, created_at, public_key_pem, private_key_pem)
+VALUES ($1, NOW(), $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET user_id = excluded.user_id
+RETURNING *;
+
+-- name: GetActivityPubKey :one
+SELECT * FROM activitypub_keys
+WHERE user_id = $1;
+
+-- Adds a follower, or updates the inboxes and Follow of one that follows again
+-- name: AddActivityPubFollower :one
+INSERT INTO activitypub_followers (user_id, actor_id, created_at, inbox, shared_inbox, follow_id)
+VALUES ($1, $2, NOW(), $3, $4, $5)
+ON CONFLICT (user_id, actor_id) DO UPDATE
+SET inbox = excluded.inbox, shared_inbox = excluded.shared_inbox, follow_id = excluded.follow_id
+RETURNING *;
+
+-- name: DeleteActivityPubFollower :execrows
+DELETE FROM activitypub_followers
+WHERE user_id = $1 AND actor_id = $2;
+
+-- name: CountActivityPubFollowers :one
+SELECT COUNT(*) FROM activitypub_followers
+WHERE user_id = $1;
+
+-- The inboxes a user's posts are delivered to: each follower's server once when it has
+-- a shared inbox. None for a shadowbanned user, whose chirps only they can see.
+-- name: ListActivityPubInboxes :many
+SELECT DISTINCT CASE WHEN shared_inbox <> '' THEN shared_inbox ELSE inbox END AS inbox
+FROM activitypub_followers
+WHERE user_id = $1
+AND user_id NOT IN (SELECT user_id FROM shadowbans)
+ORDER BY inbox ASC;
+
+-- name: ListActivityPubKeysAfter :many
+SELECT * FROM activitypub_keys
+WHERE user_id > $1
+ORDER BY user_id ASC
+LIMIT $2;
+
+-- Inserts a user's key pair from a backup, so remote servers can still verify their deliveries
+-- name: RestoreActivityPubKey :exec
+INSERT INTO activitypub_keys (user_id, created_at, public_key_pem, private_key_pem)
+VALUES ($1, $2, $3, $4);
+
+-- Followers a page at a time for backups, after the follower actor_id of user_id
+-- name: ListActivityPubFollowersAfter :many
+SELECT * FROM activitypub_followers
+WHERE user_id > sqlc.arg(user_id) OR (user_id = sqlc.arg(user_id) AND actor_id > sqlc.arg(actor_id))
+ORDER BY user_id ASC, actor_id ASC
+LIMIT sqlc.arg(row_limit);
+
+-- Inserts a follower from a backup as it was
+-- name: RestoreActivityPubFollower :exec
+INSERT INTO activitypub_followers (user_id, actor_id, created_at, inbox, shared_inbox, follow_id)
+VALUES ($1, $2, $3, $4, $5, $6);
@@ -0,0 +1,56 @@
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (id, created_at, user_id, tenant_id, name, key_hash, prefix)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetAPIKeyByHash :one
+SELECT * FROM api_keys
+WHERE key_hash = $1;
+
+-- name: ListAPIKeysByUser :many
+SELECT * FROM api_keys
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: DeleteAPIKey :execrows
+DELETE FROM api_keys
+WHERE id = $1 AND user_id = $2;
+
+-- name: TouchAPIKey :exec
+UPDATE api_keys
+SET last_used_at = $2
+WHERE id = $1;
+
+-- Adds to a key's requests on one day and returns the new total
+-- name: AddAPIKeyUsage :one
+INSERT INTO api_key_usage (api_key_id, day, requests)
+VALUES ($1, $2, $3)
+ON CONFLICT (api_key_id, day) DO UPDATE
+SET requests = api_key_usage.requests + excluded.requests
+RETURNING requests;
+
+-- A key's requests per day since the given one, newest first
+-- name: ListAPIKeyUsage :many
+SELECT * FROM api_key_usage
+WHERE api_key_id = $1 AND day >= $2
+ORDER BY day DESC;
+
+-- Counts the rows DeleteAPIKeyUsageBefore removes, for retention dry runs
+-- name: CountAPIKeyUsageBefore :one
+SELECT COUNT(*) FROM api_key_usage
+WHERE day < $1;
+
+-- name: DeleteAPIKeyUsageBefore :execrows
+DELETE FROM api_key_usage
+WHERE day < $1;
+
+-- name: ListAPIKeysAfter :many
+SELECT * FROM api_keys
+WHERE id > $1
+ORDER BY id ASC
+LIMIT $2;
+
+-- Inserts a key from a backup as it was, id and timestamps included
+-- name: RestoreAPIKey :exec
+INSERT INTO api_keys (id, created_at, user_id, tenant_id, name, key_hash, prefix, last_used_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
@@ -0,0 +1,15 @@
+-- name: CreateAuditEntry :one
+INSERT INTO audit_log (id, created_at, actor, action, target, request_id, ip, details)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: ListAuditEntries :many
+-- Empty string filters match everything
+SELECT * FROM audit_log
+WHERE (sqlc.arg(actor) = '' OR actor = sqlc.arg(actor))
+  AND (sqlc.arg(action) = '' OR action = sqlc.arg(action))
+  AND (sqlc.arg(target) = '' OR target = sqlc.arg(target))
+  AND created_at >= sqlc.arg(since)
+  AND created_at < sqlc.arg(until)
+ORDER BY created_at DESC
+LIMIT sqlc.arg(max_entries);
@@ -0,0 +1,76 @@
+-- name: CreateAutomodRule :one
+INSERT INTO automod_rules (id, created_at, updated_at, name, version, enabled, definition)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, 1, $2, $3)
+RETURNING *;
+
+-- name: ListAutomodRules :many
+SELECT * FROM automod_rules
+ORDER BY created_at ASC, id ASC;
+
+-- name: GetAutomodRule :one
+SELECT * FROM automod_rules
+WHERE id = $1;
+
+-- Replaces a rule as its next version
+-- name: UpdateAutomodRule :one
+UPDATE automod_rules
+SET name = $2, enabled = $3, definition = $4, version = version + 1, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteAutomodRule :execrows
+DELETE FROM automod_rules
+WHERE id = $1;
+
+-- name: CreateAutomodRuleVersion :one
+INSERT INTO automod_rule_versions (rule_id, version, created_at, name, enabled, definition, created_by)
+VALUES ($1, $2, NOW(), $3, $4, $5, $6)
+RETURNING *;
+
+-- name: ListAutomodRuleVersions :many
+SELECT * FROM automod_rule_versions
+WHERE rule_id = $1
+ORDER BY version DESC;
+
+-- name: GetAutomodRuleVersion :one
+SELECT * FROM automod_rule_versions
+WHERE rule_id = $1 AND version = $2;
+
+-- Puts a posting limit on a user, replacing the one they have
+-- name: SetAutomodRestriction :one
+INSERT INTO automod_restrictions (user_id, created_at, expires_at, max_chirps, window_seconds, rule_id)
+VALUES ($1, NOW(), $2, $3, $4, $5)
+ON CONFLICT (user_id) DO UPDATE
+SET created_at = excluded.created_at, expires_at = excluded.expires_at, max_chirps = excluded.max_chirps,
+    window_seconds = excluded.window_seconds, rule_id = excluded.rule_id
+RETURNING *;
+
+-- Returns the posting limit a user has, unless it expired before now
+-- name: GetAutomodRestriction :one
+SELECT * FROM automod_restrictions
+WHERE user_id = $1 AND expires_at > $2;
+
+-- name: ListAutomodRestrictionsAfter :many
+SELECT * FROM automod_restrictions
+WHERE user_id > $1
+ORDER BY user_id ASC
+LIMIT $2;
+
+-- Inserts a rule from a backup as it was, replacing the rule with the same id, which
+-- a reset leaves in place
+-- name: RestoreAutomodRule :exec
+INSERT INTO automod_rules (id, created_at, updated_at, name, version, enabled, definition)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (id) DO UPDATE
+SET created_at = excluded.created_at, updated_at = excluded.updated_at, name = excluded.name,
+    version = excluded.version, enabled = excluded.enabled, definition = excluded.definition;
+
+-- Inserts a rule version from a backup, unless the rule already has it
+-- name: RestoreAutomodRuleVersion :exec
+INSERT INTO automod_rule_versions (rule_id, version, created_at, name, enabled, definition, created_by)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (rule_id, version) DO NOTHING;
+
+-- name: RestoreAutomodRestriction :exec
+INSERT INTO automod_restrictions (user_id, created_at, expires_at, max_chirps, window_seconds, rule_id)
+VALUES ($1, $2, $3, $4, $5, $6);
@@ -1,21 +1,134 @@
+-- Inserts nothing unless the author belongs to the tenant
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES (gen_random_uuid(), now(), now(), $1, $2)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, tenant_id)
+SELECT gen_random_uuid(), now(), now(), sqlc.arg(body), users.id, users.tenant_id
+FROM users
+WHERE users.id = sqlc.arg(user_id) AND users.tenant_id = sqlc.arg(tenant_id)
 RETURNING *;
 
+-- The chirps of shadowbanned users, and chirps auto-moderation or a word filter holds
+-- until a moderator approves them, are only read back by their author, the viewer.
+-- Pass uuid.Nil as the viewer to read them as anyone else.
 -- name: GetChirps :many
 SELECT * FROM chirps
+WHERE tenant_id = $1
+AND (user_id = $2 OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
 ORDER BY created_at ASC;
 
 -- name: GetChirpById :one
 SELECT * FROM chirps
+WHERE id = $1 AND tenant_id = $2
+AND (user_id = $3 OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)));
+
+-- Finds a chirp whatever its tenant, for operator admins
+-- name: GetChirpByIdAnyTenant :one
+SELECT * FROM chirps
 WHERE id = $1;
 
 -- name: DeleteChirpById :exec
 DELETE FROM chirps
-WHERE id = $1 AND user_id = $2;
+WHERE id = $1 AND user_id = $2 AND tenant_id = $3;
 
 -- name: GetChirpsById :many
 SELECT * FROM chirps
-WHERE user_id = $1
-ORDER BY created_at ASC;
\ No newline at end of file
+WHERE user_id = $1 AND tenant_id = $2
+AND (user_id = $3 OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
+ORDER BY created_at ASC;
+
+-- Lists the chirps of a batch of authors from a comma separated list of IDs. The list is
+-- matched against the tenant's users, which is far smaller than chirps, so each author's
+-- chirps come from chirps_user_id_created_at_idx rather than a scan of every chirp. A
+-- chirp's tenant is always its author's.
+-- name: GetChirpsByUserIds :many
+SELECT * FROM chirps
+WHERE user_id IN (
+    SELECT users.id FROM users
+    WHERE users.tenant_id = sqlc.arg(tenant_id)
+    AND (',' || CAST(sqlc.arg(ids) AS TEXT) || ',') LIKE ('%,' || CAST(users.id AS TEXT) || ',%')
+)
+AND (user_id = sqlc.arg(viewer_id) OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
+ORDER BY created_at ASC;
+
+-- Counts the chirps an admin bulk delete matches. all_users and all_tenants turn the
+-- user and tenant filters off.
+-- name: CountChirpsMatching :one
+SELECT COUNT(*) FROM chirps
+WHERE created_at >= sqlc.arg(created_after) AND created_at < sqlc.arg(created_before)
+AND (CAST(sqlc.arg(all_users) AS BOOLEAN) OR user_id = sqlc.arg(user_id))
+AND (CAST(sqlc.arg(all_tenants) AS BOOLEAN) OR tenant_id = sqlc.arg(tenant_id));
+
+-- Deletes up to row_limit of the chirps CountChirpsMatching counts, oldest first, so a
+-- bulk delete runs as a series of short statements instead of one long lock
+-- name: DeleteChirpsMatching :execrows
+DELETE FROM chirps
+WHERE id IN (
+    SELECT id FROM chirps
+    WHERE created_at >= sqlc.arg(created_after) AND created_at < sqlc.arg(created_before)
+    AND (CAST(sqlc.arg(all_users) AS BOOLEAN) OR user_id = sqlc.arg(user_id))
+    AND (CAST(sqlc.arg(all_tenants) AS BOOLEAN) OR tenant_id = sqlc.arg(tenant_id))
+    ORDER BY created_at ASC
+    LIMIT sqlc.arg(row_limit)
+);
+
+-- Pages through every chirp of every tenant by id, for backups
+-- name: ListChirpsAfter :many
+SELECT * FROM chirps
+WHERE id > $1
+ORDER BY id ASC
+LIMIT $2;
+
+-- Inserts a chirp from a backup as it was, id and timestamps included
+-- name: RestoreChirp :exec
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, tenant_id)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- Inserts a chirp posted elsewhere with the time it was first posted, and updated now so
+-- the sitemap refresh finds it. Inserts nothing if a chirp with the id exists, so
+-- importing twice adds nothing, or unless the author belongs to the tenant.
+-- name: ImportChirp :execrows
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, tenant_id)
+SELECT sqlc.arg(id), sqlc.arg(created_at), NOW(), sqlc.arg(body), users.id, users.tenant_id
+FROM users
+WHERE users.id = sqlc.arg(user_id) AND users.tenant_id = sqlc.arg(tenant_id)
+ON CONFLICT (id) DO NOTHING;
+
+-- Replaces the body of a chirp, e.g. with the tombstone of a chirp moderators removed
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $2, updated_at = now()
+WHERE id = $1 AND tenant_id = $3
+RETURNING *;
+
+-- Finds chirps of every tenant whose body contains a LIKE pattern, or whose id is
+-- exact_id, newest first, for admin search
+-- name: SearchChirps :many
+SELECT * FROM chirps
+WHERE LOWER(body) LIKE sqlc.arg(pattern) ESCAPE '\' OR CAST(id AS TEXT) = sqlc.arg(exact_id)
+ORDER BY created_at DESC
+LIMIT sqlc.arg(max_results);
+
+-- One page of the chirps GET /api/chirps?page= lists, oldest first. all_authors turns the
+-- author filter off, and viewer_id sees their own chirps whatever hides them.
+-- name: ListChirpsPage :many
+SELECT * FROM chirps
+WHERE tenant_id = sqlc.arg(tenant_id)
+AND (CAST(sqlc.arg(all_authors) AS BOOLEAN) OR user_id = sqlc.arg(author_id))
+AND (user_id = sqlc.arg(viewer_id) OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- ListChirpsPage newest first
+-- name: ListChirpsPageDesc :many
+SELECT * FROM chirps
+WHERE tenant_id = sqlc.arg(tenant_id)
+AND (CAST(sqlc.arg(all_authors) AS BOOLEAN) OR user_id = sqlc.arg(author_id))
+AND (user_id = sqlc.arg(viewer_id) OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- How many chirps ListChirpsPage pages through, for X-Total-Count
+-- name: CountChirpsPage :one
+SELECT COUNT(*) FROM chirps
+WHERE tenant_id = sqlc.arg(tenant_id)
+AND (CAST(sqlc.arg(all_authors) AS BOOLEAN) OR user_id = sqlc.arg(author_id))
+AND (user_id = sqlc.arg(viewer_id) OR (user_id NOT IN (SELECT user_id FROM shadowbans) AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)));
@@ -0,0 +1,52 @@
+-- Subscribes a user to the digest, starting now. Subscribing again keeps the
+-- subscription as it is.
+-- name: CreateDigestSubscription :one
+INSERT INTO digest_subscriptions (user_id, created_at, last_sent_at)
+VALUES ($1, NOW(), NOW())
+ON CONFLICT (user_id) DO UPDATE SET user_id = excluded.user_id
+RETURNING *;
+
+-- name: GetDigestSubscription :one
+SELECT * FROM digest_subscriptions
+WHERE user_id = $1;
+
+-- name: DeleteDigestSubscription :exec
+DELETE FROM digest_subscriptions
+WHERE user_id = $1;
+
+-- Subscriptions whose last digest went out before sent_before, with the address and
+-- tenant of their user, a page at a time
+-- name: ListDueDigestSubscriptions :many
+SELECT digest_subscriptions.user_id, digest_subscriptions.last_sent_at, users.email, users.tenant_id
+FROM digest_subscriptions
+JOIN users ON users.id = digest_subscriptions.user_id
+WHERE digest_subscriptions.last_sent_at < sqlc.arg(sent_before)
+ORDER BY digest_subscriptions.user_id ASC
+LIMIT sqlc.arg(max_rows);
+
+-- name: MarkDigestSent :exec
+UPDATE digest_subscriptions
+SET last_sent_at = $2
+WHERE user_id = $1;
+
+-- The newest chirps of a tenant posted in a window, for a user's digest. Their own
+-- chirps are left out, and so are chirps a shadowban or a hold hides.
+-- name: ListDigestChirps :many
+SELECT * FROM chirps
+WHERE tenant_id = sqlc.arg(tenant_id) AND user_id <> sqlc.arg(user_id)
+AND created_at >= sqlc.arg(since) AND created_at < sqlc.arg(until)
+AND user_id NOT IN (SELECT user_id FROM shadowbans)
+AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)
+ORDER BY created_at DESC
+LIMIT sqlc.arg(max_chirps);
+
+-- name: ListDigestSubscriptionsAfter :many
+SELECT * FROM digest_subscriptions
+WHERE user_id > $1
+ORDER BY user_id ASC
+LIMIT $2;
+
+-- Inserts a subscription from a backup as it was
+-- name: RestoreDigestSubscription :exec
+INSERT INTO digest_subscriptions (user_id, created_at, last_sent_at)
+VALUES ($1, $2, $3);
@@ -0,0 +1,47 @@
+-- Favourites a chirp. Favouriting it again changes nothing.
+-- name: FavouriteChirp :execrows
+INSERT INTO chirp_favourites (user_id, chirp_id, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (user_id, chirp_id) DO NOTHING;
+
+-- name: UnfavouriteChirp :execrows
+DELETE FROM chirp_favourites
+WHERE user_id = $1 AND chirp_id = $2;
+
+-- How many times each of a comma-separated list of chirps was favourited. Chirps
+-- nobody favourited are left out.
+-- name: CountChirpFavourites :many
+SELECT chirp_id, COUNT(*) AS favourites FROM chirp_favourites
+WHERE (',' || CAST(sqlc.arg(ids) AS TEXT) || ',') LIKE ('%,' || CAST(chirp_id AS TEXT) || ',%')
+GROUP BY chirp_id;
+
+-- Which of a comma-separated list of chirps the user favourited
+-- name: ListFavouritedChirpIds :many
+SELECT chirp_id FROM chirp_favourites
+WHERE user_id = sqlc.arg(user_id)
+AND (',' || CAST(sqlc.arg(ids) AS TEXT) || ',') LIKE ('%,' || CAST(chirp_id AS TEXT) || ',%');
+
+-- The chirps a user favourited before a time, most recently favourited first, as the
+-- user can see them
+-- name: ListFavouriteChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.tenant_id, chirp_favourites.created_at AS favourited_at
+FROM chirp_favourites
+JOIN chirps ON chirps.id = chirp_favourites.chirp_id
+WHERE chirp_favourites.user_id = sqlc.arg(user_id)
+AND chirps.tenant_id = sqlc.arg(tenant_id)
+AND chirp_favourites.created_at < sqlc.arg(before)
+AND (chirps.user_id = sqlc.arg(user_id) OR (chirps.user_id NOT IN (SELECT user_id FROM shadowbans) AND chirps.id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)))
+ORDER BY chirp_favourites.created_at DESC
+LIMIT sqlc.arg(max_results);
+
+-- Favourites a page at a time for backups, after the favourite of user_id and chirp_id
+-- name: ListChirpFavouritesAfter :many
+SELECT * FROM chirp_favourites
+WHERE user_id > sqlc.arg(user_id) OR (user_id = sqlc.arg(user_id) AND chirp_id > sqlc.arg(chirp_id))
+ORDER BY user_id ASC, chirp_id ASC
+LIMIT sqlc.arg(row_limit);
+
+-- Inserts a favourite from a backup as it was
+-- name: RestoreChirpFavourite :exec
+INSERT INTO chirp_favourites (user_id, chirp_id, created_at)
+VALUES ($1, $2, $3);
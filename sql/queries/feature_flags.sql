@@ -0,0 +1,22 @@
+-- name: UpsertFeatureFlag :one
+INSERT INTO feature_flags (name, description, enabled, rollout_percentage, environments)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (name) DO UPDATE
+SET description = excluded.description,
+    enabled = excluded.enabled,
+    rollout_percentage = excluded.rollout_percentage,
+    environments = excluded.environments,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: GetFeatureFlags :many
+SELECT * FROM feature_flags
+ORDER BY name ASC;
+
+-- name: GetFeatureFlag :one
+SELECT * FROM feature_flags
+WHERE name = $1;
+
+-- name: DeleteFeatureFlag :exec
+DELETE FROM feature_flags
+WHERE name = $1;
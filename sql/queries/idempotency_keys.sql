@@ -0,0 +1,28 @@
+-- name: ClaimIdempotencyKey :one
+-- Returns no rows when the key was already used in this scope
+INSERT INTO idempotency_keys (scope, idempotency_key, fingerprint, status, status_code, content_type, body, created_at, expires_at)
+VALUES ($1, $2, $3, 'processing', 0, '', '', NOW(), $4)
+ON CONFLICT (scope, idempotency_key) DO NOTHING
+RETURNING *;
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys
+WHERE scope = $1 AND idempotency_key = $2;
+
+-- name: CompleteIdempotencyKey :exec
+UPDATE idempotency_keys
+SET status = 'done', status_code = $3, content_type = $4, body = $5, headers = $6
+WHERE scope = $1 AND idempotency_key = $2;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE scope = $1 AND idempotency_key = $2;
+
+-- Counts the rows DeleteExpiredIdempotencyKeys removes, for retention dry runs
+-- name: CountExpiredIdempotencyKeys :one
+SELECT COUNT(*) FROM idempotency_keys
+WHERE expires_at < $1;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < $1;
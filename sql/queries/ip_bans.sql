@@ -0,0 +1,39 @@
+-- Bans a range, replacing an expired ban of the same range. Inserts nothing while the
+-- range has a ban that is still in force.
+-- name: CreateIPBan :one
+INSERT INTO ip_bans (id, created_at, cidr, reason, expires_at, created_by)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4)
+ON CONFLICT (cidr) DO UPDATE
+SET id = excluded.id, created_at = excluded.created_at, reason = excluded.reason,
+    expires_at = excluded.expires_at, created_by = excluded.created_by
+WHERE ip_bans.expires_at IS NOT NULL AND ip_bans.expires_at <= NOW()
+RETURNING *;
+
+-- Bans still in force, oldest first
+-- name: ListActiveIPBans :many
+SELECT * FROM ip_bans
+WHERE expires_at IS NULL OR expires_at > NOW()
+ORDER BY created_at ASC, id ASC;
+
+-- name: DeleteIPBan :one
+DELETE FROM ip_bans
+WHERE id = $1
+RETURNING *;
+
+-- Counts the rows DeleteExpiredIPBans removes, for retention dry runs
+-- name: CountExpiredIPBans :one
+SELECT COUNT(*) FROM ip_bans
+WHERE expires_at < $1;
+
+-- name: DeleteExpiredIPBans :execrows
+DELETE FROM ip_bans
+WHERE expires_at < $1;
+
+-- Inserts a ban from a backup as it was, replacing the ban with the same id, which a
+-- reset leaves in place
+-- name: RestoreIPBan :exec
+INSERT INTO ip_bans (id, created_at, cidr, reason, expires_at, created_by)
+VALUES ($1, $2, $3, $4, $5, $6)
+ON CONFLICT (id) DO UPDATE
+SET created_at = excluded.created_at, cidr = excluded.cidr, reason = excluded.reason,
+    expires_at = excluded.expires_at, created_by = excluded.created_by;
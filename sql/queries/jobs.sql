@@ -0,0 +1,77 @@
+-- name: EnqueueJob :one
+INSERT INTO jobs (id, created_at, updated_at, kind, payload, status, attempts, max_attempts, run_at)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, 'pending', 0, $3, $4)
+RETURNING *;
+
+-- name: ClaimJob :one
+-- Takes the oldest due job, or one whose worker's lease expired. The conditions are
+-- repeated on the outer UPDATE so two workers racing for the same row cannot both win.
+UPDATE jobs
+SET status = 'running', attempts = attempts + 1, locked_until = sqlc.arg(locked_until), updated_at = NOW()
+WHERE id = (
+    SELECT id FROM jobs
+    WHERE (status = 'pending' AND run_at <= sqlc.arg(now)) OR (status = 'running' AND locked_until < sqlc.arg(now))
+    ORDER BY run_at ASC
+    LIMIT 1
+)
+AND ((status = 'pending' AND run_at <= sqlc.arg(now)) OR (status = 'running' AND locked_until < sqlc.arg(now)))
+RETURNING *;
+
+-- name: CompleteJob :exec
+UPDATE jobs
+SET status = 'done', locked_until = NULL, last_error = '', updated_at = NOW()
+WHERE id = $1;
+
+-- name: RetryJob :exec
+UPDATE jobs
+SET status = 'pending', run_at = $2, locked_until = NULL, last_error = $3, updated_at = NOW()
+WHERE id = $1;
+
+-- name: BuryJob :exec
+UPDATE jobs
+SET status = 'dead', locked_until = NULL, last_error = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: ListJobsByStatus :many
+SELECT * FROM jobs
+WHERE status = $1
+ORDER BY updated_at DESC
+LIMIT $2;
+
+-- name: GetJob :one
+SELECT * FROM jobs
+WHERE id = $1;
+
+-- name: RequeueDeadJob :one
+UPDATE jobs
+SET status = 'pending', attempts = 0, run_at = NOW(), last_error = '', updated_at = NOW()
+WHERE id = $1 AND status = 'dead'
+RETURNING *;
+
+-- Counts the rows DeleteFinishedJobs removes, for retention dry runs
+-- name: CountFinishedJobs :one
+SELECT COUNT(*) FROM jobs
+WHERE status = 'done' AND updated_at < $1;
+
+-- name: DeleteFinishedJobs :execrows
+DELETE FROM jobs
+WHERE status = 'done' AND updated_at < $1;
+
+-- Jobs not yet done by kind and status, for GET /admin/status
+-- name: CountJobsByStatus :many
+SELECT kind, status, COUNT(*) AS count FROM jobs
+WHERE status <> 'done'
+GROUP BY kind, status
+ORDER BY kind, status;
+
+-- The pending job of a kind that has been due the longest, to tell how far its queue lags
+-- name: GetOldestDueJob :one
+SELECT run_at FROM jobs
+WHERE kind = $1 AND status = 'pending' AND run_at <= $2
+ORDER BY run_at ASC
+LIMIT 1;
+
+-- name: SetJobProgress :exec
+UPDATE jobs
+SET progress = $2, updated_at = NOW()
+WHERE id = $1;
@@ -0,0 +1,115 @@
+-- name: CreateChirpReport :one
+INSERT INTO chirp_reports (id, created_at, chirp_id, author_id, tenant_id, reporter_id, reason, details)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- Open reports of chirps that still exist, oldest first, for the moderation queue
+-- name: ListOpenChirpReports :many
+SELECT chirp_reports.*, chirps.body AS chirp_body, chirps.created_at AS chirp_created_at
+FROM chirp_reports
+JOIN chirps ON chirps.id = chirp_reports.chirp_id
+WHERE chirp_reports.resolved_at IS NULL
+ORDER BY chirp_reports.created_at ASC, chirp_reports.id ASC;
+
+-- name: GetOpenChirpReports :many
+SELECT * FROM chirp_reports
+WHERE chirp_id = $1 AND resolved_at IS NULL
+ORDER BY created_at ASC;
+
+-- name: CreateModerationDecision :one
+INSERT INTO moderation_decisions (id, created_at, chirp_id, author_id, tenant_id, moderator, action, note, reports)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- Closes the open reports of a chirp with the decision made on them
+-- name: ResolveChirpReports :execrows
+UPDATE chirp_reports
+SET resolved_at = NOW(), decision_id = $2
+WHERE chirp_id = $1 AND resolved_at IS NULL;
+
+-- name: EscalateChirpReports :execrows
+UPDATE chirp_reports
+SET escalated_at = NOW()
+WHERE chirp_id = $1 AND resolved_at IS NULL AND escalated_at IS NULL;
+
+-- Reports per UTC day and reason. The day is cut from the timestamp's text, which
+-- starts with the date on both Postgres and SQLite.
+-- name: CountChirpReportsByDay :many
+SELECT SUBSTR(CAST(created_at AS TEXT), 1, 10) AS day, reason, COUNT(*) AS reports
+FROM chirp_reports
+WHERE created_at >= sqlc.arg(since) AND created_at < sqlc.arg(until)
+GROUP BY day, reason
+ORDER BY day ASC, reason ASC;
+
+-- name: CountChirpReportsByReason :many
+SELECT
+    chirp_reports.reason,
+    COUNT(*) AS reports,
+    SUM(CASE WHEN chirp_reports.resolved_at IS NULL THEN 1 ELSE 0 END) AS open_reports,
+    SUM(CASE WHEN chirp_reports.escalated_at IS NOT NULL THEN 1 ELSE 0 END) AS escalated,
+    SUM(CASE WHEN moderation_decisions.action = 'approve' THEN 1 ELSE 0 END) AS approved,
+    SUM(CASE WHEN moderation_decisions.action = 'remove' THEN 1 ELSE 0 END) AS removed
+FROM chirp_reports
+LEFT JOIN moderation_decisions ON moderation_decisions.id = chirp_reports.decision_id
+WHERE chirp_reports.created_at >= sqlc.arg(since) AND chirp_reports.created_at < sqlc.arg(until)
+GROUP BY chirp_reports.reason
+ORDER BY reports DESC, chirp_reports.reason ASC;
+
+-- Authors whose chirps were reported the most, with how many of their chirps were
+-- reported and how many moderators removed
+-- name: ListMostReportedUsers :many
+SELECT
+    chirp_reports.author_id,
+    users.email,
+    COUNT(*) AS reports,
+    COUNT(DISTINCT chirp_reports.chirp_id) AS chirps,
+    COUNT(DISTINCT CASE WHEN moderation_decisions.action = 'remove' THEN chirp_reports.chirp_id END) AS removed_chirps
+FROM chirp_reports
+JOIN users ON users.id = chirp_reports.author_id
+LEFT JOIN moderation_decisions ON moderation_decisions.id = chirp_reports.decision_id
+WHERE chirp_reports.created_at >= sqlc.arg(since) AND chirp_reports.created_at < sqlc.arg(until)
+GROUP BY chirp_reports.author_id, users.email
+ORDER BY reports DESC, chirp_reports.author_id ASC
+LIMIT sqlc.arg(max_rows);
+
+-- Chirps reported the most. The body is NULL once the chirp is deleted.
+-- name: ListMostReportedChirps :many
+SELECT
+    chirp_reports.chirp_id,
+    chirp_reports.author_id,
+    chirps.body AS chirp_body,
+    COUNT(*) AS reports,
+    SUM(CASE WHEN chirp_reports.resolved_at IS NULL THEN 1 ELSE 0 END) AS open_reports
+FROM chirp_reports
+LEFT JOIN chirps ON chirps.id = chirp_reports.chirp_id
+WHERE chirp_reports.created_at >= sqlc.arg(since) AND chirp_reports.created_at < sqlc.arg(until)
+GROUP BY chirp_reports.chirp_id, chirp_reports.author_id, chirps.body
+ORDER BY reports DESC, chirp_reports.chirp_id ASC
+LIMIT sqlc.arg(max_rows);
+
+-- Reports resolved by a decision made in the window, with when each was made and
+-- decided on. The caller works out the response times, as Postgres and SQLite
+-- subtract timestamps differently.
+-- name: ListReportResponseTimes :many
+SELECT
+    chirp_reports.created_at AS reported_at,
+    chirp_reports.escalated_at,
+    moderation_decisions.created_at AS decided_at,
+    moderation_decisions.moderator,
+    moderation_decisions.action
+FROM chirp_reports
+JOIN moderation_decisions ON moderation_decisions.id = chirp_reports.decision_id
+WHERE moderation_decisions.created_at >= sqlc.arg(since) AND moderation_decisions.created_at < sqlc.arg(until)
+ORDER BY moderation_decisions.created_at ASC, chirp_reports.id ASC;
+
+-- Open holds auto-moderation or a word filter put on chirps, a page at a time for backups
+-- name: ListAutomodHoldsAfter :many
+SELECT * FROM chirp_reports
+WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL AND id > $1
+ORDER BY id ASC
+LIMIT $2;
+
+-- Inserts a report from a backup as it was, id and timestamps included
+-- name: RestoreChirpReport :exec
+INSERT INTO chirp_reports (id, created_at, chirp_id, author_id, tenant_id, reporter_id, reason, details, escalated_at, resolved_at, decision_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11);
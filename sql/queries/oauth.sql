@@ -0,0 +1,62 @@
+-- name: CreateOAuthApp :one
+INSERT INTO oauth_apps (id, created_at, client_id, client_secret, name, website, redirect_uris, scopes)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5, $6)
+RETURNING *;
+
+-- name: GetOAuthApp :one
+SELECT * FROM oauth_apps
+WHERE client_id = $1;
+
+-- name: CreateOAuthCode :exec
+INSERT INTO oauth_codes (code_hash, created_at, expires_at, user_id, client_id, redirect_uri, scopes)
+VALUES ($1, NOW(), $2, $3, $4, $5, $6);
+
+-- Deletes an unexpired code and returns it, so it can only be exchanged once
+-- name: ConsumeOAuthCode :one
+DELETE FROM oauth_codes
+WHERE code_hash = $1 AND expires_at > $2
+RETURNING *;
+
+-- Codes that were never exchanged, removed whenever a new one is created
+-- name: DeleteExpiredOAuthCodes :execrows
+DELETE FROM oauth_codes
+WHERE expires_at < $1;
+
+-- name: CreateOAuthToken :exec
+INSERT INTO oauth_tokens (token_hash, created_at, user_id, client_id, scopes)
+VALUES ($1, NOW(), $2, $3, $4);
+
+-- name: GetOAuthToken :one
+SELECT * FROM oauth_tokens
+WHERE token_hash = $1;
+
+-- Only the client a token was issued to may revoke it
+-- name: RevokeOAuthToken :exec
+DELETE FROM oauth_tokens
+WHERE token_hash = $1 AND client_id = $2;
+
+-- name: ListOAuthAppsAfter :many
+SELECT * FROM oauth_apps
+WHERE id > $1
+ORDER BY id ASC
+LIMIT $2;
+
+-- Inserts an app from a backup as it was, replacing the app with the same id, which a
+-- reset leaves in place
+-- name: RestoreOAuthApp :exec
+INSERT INTO oauth_apps (id, created_at, client_id, client_secret, name, website, redirect_uris, scopes)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (id) DO UPDATE
+SET created_at = excluded.created_at, client_id = excluded.client_id, client_secret = excluded.client_secret,
+    name = excluded.name, website = excluded.website, redirect_uris = excluded.redirect_uris, scopes = excluded.scopes;
+
+-- name: ListOAuthTokensAfter :many
+SELECT * FROM oauth_tokens
+WHERE token_hash > $1
+ORDER BY token_hash ASC
+LIMIT $2;
+
+-- Inserts a token from a backup as it was, so Mastodon apps stay signed in
+-- name: RestoreOAuthToken :exec
+INSERT INTO oauth_tokens (token_hash, created_at, user_id, client_id, scopes)
+VALUES ($1, $2, $3, $4, $5);
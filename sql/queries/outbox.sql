@@ -0,0 +1,30 @@
+-- name: CreateOutboxEvent :one
+INSERT INTO outbox_events (id, created_at, tenant_id, event, payload)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3)
+RETURNING *;
+
+-- name: ListUnpublishedOutboxEvents :many
+SELECT * FROM outbox_events
+WHERE published_at IS NULL
+ORDER BY created_at ASC, id ASC
+LIMIT $1;
+
+-- Claims an event for the relay. Only one of several relays racing for it updates the
+-- row; the others wait for its transaction and then find it already published.
+-- name: MarkOutboxEventPublished :execrows
+UPDATE outbox_events
+SET published_at = NOW()
+WHERE id = $1 AND published_at IS NULL;
+
+-- name: CountUnpublishedOutboxEvents :one
+SELECT COUNT(*) FROM outbox_events
+WHERE published_at IS NULL;
+
+-- Counts the rows DeletePublishedOutboxEventsBefore removes, for retention dry runs
+-- name: CountPublishedOutboxEventsBefore :one
+SELECT COUNT(*) FROM outbox_events
+WHERE published_at < $1;
+
+-- name: DeletePublishedOutboxEventsBefore :execrows
+DELETE FROM outbox_events
+WHERE published_at < $1;
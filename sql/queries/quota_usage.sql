@@ -0,0 +1,21 @@
+-- Adds to a user's usage of a quota in one window and returns the new total
+-- name: AddQuotaUsage :one
+INSERT INTO quota_usage (user_id, quota, window_start, used)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (user_id, quota, window_start) DO UPDATE
+SET used = quota_usage.used + excluded.used
+RETURNING used;
+
+-- Zero for a window with no usage yet
+-- name: GetQuotaUsage :one
+SELECT CAST(COALESCE(SUM(used), 0) AS BIGINT) FROM quota_usage
+WHERE user_id = $1 AND quota = $2 AND window_start = $3;
+
+-- Counts the rows DeleteQuotaUsageBefore removes, for retention dry runs
+-- name: CountQuotaUsageBefore :one
+SELECT COUNT(*) FROM quota_usage
+WHERE window_start < $1;
+
+-- name: DeleteQuotaUsageBefore :execrows
+DELETE FROM quota_usage
+WHERE window_start < $1;
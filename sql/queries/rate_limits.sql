@@ -0,0 +1,22 @@
+-- name: TakeRateLimitToken :one
+-- Returns no rows when the bucket has used up its burst, leaving it unchanged.
+-- All values are microseconds; window is burst times the emission interval.
+INSERT INTO rate_limits (bucket, tat)
+VALUES ($1, CAST($2 AS BIGINT) + CAST($3 AS BIGINT))
+ON CONFLICT (bucket) DO UPDATE
+SET tat = (CASE WHEN rate_limits.tat < CAST($2 AS BIGINT) THEN CAST($2 AS BIGINT) ELSE rate_limits.tat END) + CAST($3 AS BIGINT)
+WHERE (CASE WHEN rate_limits.tat < CAST($2 AS BIGINT) THEN CAST($2 AS BIGINT) ELSE rate_limits.tat END) + CAST($3 AS BIGINT) - CAST($4 AS BIGINT) <= CAST($2 AS BIGINT)
+RETURNING tat;
+
+-- name: GetRateLimit :one
+SELECT tat FROM rate_limits
+WHERE bucket = $1;
+
+-- Counts the rows DeleteExpiredRateLimits removes, for retention dry runs
+-- name: CountExpiredRateLimits :one
+SELECT COUNT(*) FROM rate_limits
+WHERE tat < $1;
+
+-- name: DeleteExpiredRateLimits :execrows
+DELETE FROM rate_limits
+WHERE tat < $1;
@@ -27,4 +27,14 @@ WHERE token = $1;
 -- name: RevokeRefreshTokensForUser :exec
 UPDATE refresh_tokens
 SET revoked_at = NOW()
-WHERE user_id = $1;
\ No newline at end of file
+WHERE user_id = $1;
+
+-- Counts the rows DeleteStaleRefreshTokens removes, for retention dry runs
+-- name: CountStaleRefreshTokens :one
+SELECT COUNT(*) FROM refresh_tokens
+WHERE expires_at < $1 OR revoked_at < $1;
+
+-- name: DeleteStaleRefreshTokens :execrows
+-- Expired or revoked tokens can never be used again
+DELETE FROM refresh_tokens
+WHERE expires_at < $1 OR revoked_at < $1;
@@ -0,0 +1,14 @@
+-- name: SetRuntimeState :one
+INSERT INTO runtime_state (name, value, updated_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (name) DO UPDATE
+SET value = excluded.value, updated_at = excluded.updated_at
+RETURNING *;
+
+-- name: GetRuntimeState :one
+SELECT * FROM runtime_state
+WHERE name = $1;
+
+-- name: DeleteRuntimeState :execrows
+DELETE FROM runtime_state
+WHERE name = $1;
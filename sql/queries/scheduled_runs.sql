@@ -0,0 +1,27 @@
+-- name: ClaimScheduledRun :one
+-- Returns no rows when another instance already claimed the slot
+INSERT INTO scheduled_runs (task, scheduled_for, instance, started_at, status, error)
+VALUES ($1, $2, $3, $4, 'running', '')
+ON CONFLICT (task, scheduled_for) DO NOTHING
+RETURNING *;
+
+-- name: FinishScheduledRun :exec
+UPDATE scheduled_runs
+SET finished_at = $3, status = $4, error = $5
+WHERE task = $1 AND scheduled_for = $2;
+
+-- name: ListScheduledRuns :many
+-- An empty task matches every task
+SELECT * FROM scheduled_runs
+WHERE (sqlc.arg(task) = '' OR task = sqlc.arg(task))
+ORDER BY scheduled_for DESC
+LIMIT sqlc.arg(max_runs);
+
+-- Counts the rows DeleteScheduledRunsBefore removes, for retention dry runs
+-- name: CountScheduledRunsBefore :one
+SELECT COUNT(*) FROM scheduled_runs
+WHERE scheduled_for < $1;
+
+-- name: DeleteScheduledRunsBefore :execrows
+DELETE FROM scheduled_runs
+WHERE scheduled_for < $1;
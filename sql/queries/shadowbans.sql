@@ -0,0 +1,24 @@
+-- Shadowbans a user, or replaces the reason of their shadowban. Inserts nothing when
+-- the user does not exist.
+-- name: SetShadowban :one
+INSERT INTO shadowbans (user_id, created_at, tenant_id, reason, banned_by)
+SELECT users.id, NOW(), users.tenant_id, sqlc.arg(reason), sqlc.arg(banned_by)
+FROM users
+WHERE users.id = sqlc.arg(user_id)
+ON CONFLICT (user_id) DO UPDATE
+SET reason = excluded.reason, banned_by = excluded.banned_by
+RETURNING *;
+
+-- name: ListShadowbans :many
+SELECT * FROM shadowbans
+ORDER BY created_at ASC, user_id ASC;
+
+-- name: DeleteShadowban :one
+DELETE FROM shadowbans
+WHERE user_id = $1
+RETURNING *;
+
+-- Inserts a shadowban from a backup as it was
+-- name: RestoreShadowban :exec
+INSERT INTO shadowbans (user_id, created_at, tenant_id, reason, banned_by)
+VALUES ($1, $2, $3, $4, $5);
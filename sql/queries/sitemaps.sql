@@ -0,0 +1,42 @@
+-- name: ListSitemapPages :many
+SELECT * FROM sitemap_pages
+WHERE tenant_id = $1
+ORDER BY kind ASC, day ASC;
+
+-- name: UpsertSitemapPage :exec
+INSERT INTO sitemap_pages (tenant_id, kind, day, urls, last_modified)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (tenant_id, kind, day) DO UPDATE
+SET urls = excluded.urls, last_modified = excluded.last_modified;
+
+-- name: DeleteSitemapPage :exec
+DELETE FROM sitemap_pages
+WHERE tenant_id = $1 AND kind = $2 AND day = $3;
+
+-- Pages through the chirps of a tenant posted between since and until that anyone may
+-- read, oldest first
+-- name: ListSitemapChirps :many
+SELECT id, updated_at FROM chirps
+WHERE tenant_id = sqlc.arg(tenant_id) AND created_at >= sqlc.arg(since) AND created_at < sqlc.arg(until)
+AND user_id NOT IN (SELECT user_id FROM shadowbans)
+AND id NOT IN (SELECT chirp_id FROM chirp_reports WHERE reason IN ('automod_hold', 'word_filter') AND resolved_at IS NULL)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- Pages through the users of a tenant who signed up between since and until and are
+-- not shadowbanned, oldest first
+-- name: ListSitemapUsers :many
+SELECT id, updated_at FROM users
+WHERE tenant_id = sqlc.arg(tenant_id) AND created_at >= sqlc.arg(since) AND created_at < sqlc.arg(until)
+AND id NOT IN (SELECT user_id FROM shadowbans)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg(row_limit) OFFSET sqlc.arg(row_offset);
+
+-- When the chirps posted or edited since a time were first posted, for the sitemap refresh
+-- name: ListChirpsUpdatedSince :many
+SELECT tenant_id, created_at FROM chirps
+WHERE updated_at >= $1;
+
+-- name: ListUsersUpdatedSince :many
+SELECT tenant_id, created_at FROM users
+WHERE updated_at >= $1;
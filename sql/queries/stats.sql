@@ -0,0 +1,38 @@
+-- name: GetDashboardCounts :one
+SELECT
+    (SELECT COUNT(*) FROM users) AS users,
+    (SELECT COUNT(*) FROM users WHERE created_at > sqlc.arg(since)) AS new_users,
+    (SELECT COUNT(*) FROM chirps) AS chirps,
+    (SELECT COUNT(*) FROM chirps WHERE created_at > sqlc.arg(since)) AS new_chirps,
+    (SELECT COUNT(*) FROM refresh_tokens WHERE revoked_at IS NULL AND expires_at > NOW()) AS active_sessions;
+
+-- Counts what happened on the platform between since and until, for a stats rollup.
+-- Chirps posted and deleted and Chirpy Red upgrades are counted from their outbox
+-- events, so a chirp deleted since still counts as posted. Active users posted a chirp
+-- or signed in.
+-- name: GetStatsRollupCounts :one
+SELECT
+    (SELECT COUNT(*) FROM users WHERE created_at >= sqlc.arg(since) AND created_at < sqlc.arg(until)) AS new_users,
+    (SELECT COUNT(*) FROM (
+        SELECT user_id FROM chirps WHERE created_at >= sqlc.arg(since) AND created_at < sqlc.arg(until)
+        UNION
+        SELECT user_id FROM refresh_tokens WHERE created_at >= sqlc.arg(since) AND created_at < sqlc.arg(until)
+    ) AS active) AS active_users,
+    (SELECT COUNT(*) FROM outbox_events WHERE event = 'chirp.created' AND created_at >= sqlc.arg(since) AND created_at < sqlc.arg(until)) AS chirps_posted,
+    (SELECT COUNT(*) FROM outbox_events WHERE event = 'chirp.deleted' AND created_at >= sqlc.arg(since) AND created_at < sqlc.arg(until)) AS chirps_deleted,
+    (SELECT COUNT(*) FROM outbox_events WHERE event = 'user.upgraded' AND created_at >= sqlc.arg(since) AND created_at < sqlc.arg(until)) AS red_conversions;
+
+-- name: UpsertStatsRollup :one
+INSERT INTO stats_rollups (period, starts_at, ends_at, new_users, active_users, chirps_posted, chirps_deleted, red_conversions, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+ON CONFLICT (period, starts_at) DO UPDATE
+SET ends_at = excluded.ends_at, new_users = excluded.new_users, active_users = excluded.active_users,
+    chirps_posted = excluded.chirps_posted, chirps_deleted = excluded.chirps_deleted,
+    red_conversions = excluded.red_conversions, updated_at = excluded.updated_at
+RETURNING *;
+
+-- Lists the rollups of a period starting between since and until, oldest first
+-- name: ListStatsRollups :many
+SELECT * FROM stats_rollups
+WHERE period = sqlc.arg(period) AND starts_at >= sqlc.arg(since) AND starts_at < sqlc.arg(until)
+ORDER BY starts_at ASC;
@@ -0,0 +1,32 @@
+-- name: CreateTenant :one
+INSERT INTO tenants (id, created_at, updated_at, slug, name, admin_token_hash, max_users)
+VALUES (gen_random_uuid(), now(), now(), $1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetTenantBySlug :one
+SELECT * FROM tenants WHERE slug = $1;
+
+-- name: ListTenants :many
+SELECT * FROM tenants
+ORDER BY slug ASC;
+
+-- name: SetTenantAdminToken :one
+UPDATE tenants
+SET admin_token_hash = $2, updated_at = NOW()
+WHERE slug = $1
+RETURNING *;
+
+-- name: UpdateTenant :one
+UPDATE tenants
+SET name = $2, max_users = $3, updated_at = NOW()
+WHERE slug = $1
+RETURNING *;
+
+-- Writes a tenant from a backup as it was. The default tenant every database starts
+-- with is overwritten rather than duplicated.
+-- name: RestoreTenant :exec
+INSERT INTO tenants (id, created_at, updated_at, slug, name, admin_token_hash, max_users)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (id) DO UPDATE
+SET created_at = excluded.created_at, updated_at = excluded.updated_at, slug = excluded.slug,
+    name = excluded.name, admin_token_hash = excluded.admin_token_hash, max_users = excluded.max_users;
@@ -0,0 +1,11 @@
+-- name: CreateTwitterImportBatch :exec
+INSERT INTO twitter_import_batches (job_id, seq, tweets)
+VALUES ($1, $2, $3);
+
+-- name: GetTwitterImportBatch :one
+SELECT tweets FROM twitter_import_batches
+WHERE job_id = $1 AND seq = $2;
+
+-- name: DeleteTwitterImportBatches :exec
+DELETE FROM twitter_import_batches
+WHERE job_id = $1;
@@ -1,22 +1,50 @@
+-- name: CountUsersByTenant :one
+SELECT COUNT(*) FROM users WHERE tenant_id = $1;
+
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
-VALUES (gen_random_uuid(), now(), now(), $1, $2)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, tenant_id)
+VALUES (gen_random_uuid(), now(), now(), $1, $2, $3)
 RETURNING *;
 
 -- name: DeleteUsers :exec
 DELETE FROM users;
 
 -- name: GetUserByEmail :one
-SELECT * FROM users WHERE email = $1;
+SELECT * FROM users WHERE tenant_id = $1 AND email = $2;
+
+-- Looks up a batch of users from a comma separated list of IDs
+-- name: GetUsersByIds :many
+SELECT * FROM users
+WHERE tenant_id = sqlc.arg(tenant_id)
+AND (',' || CAST(sqlc.arg(ids) AS TEXT) || ',') LIKE ('%,' || CAST(id AS TEXT) || ',%');
 
 -- name: PutNewUserData :one
 UPDATE users
 SET email = $1, hashed_password = $2, updated_at = NOW()
-WHERE id = $3
+WHERE id = $3 AND tenant_id = $4
 RETURNING *;
 
 -- name: UpgradeUserById :one
 UPDATE users
 SET is_chirpy_red = TRUE, updated_at = NOW()
-WHERE id = $1
-RETURNING *;
\ No newline at end of file
+WHERE id = $1 AND tenant_id = $2
+RETURNING *;
+-- Pages through every user of every tenant by id, for backups
+-- name: ListUsersAfter :many
+SELECT * FROM users
+WHERE id > $1
+ORDER BY id ASC
+LIMIT $2;
+
+-- Inserts a user from a backup as it was, id and timestamps included
+-- name: RestoreUser :exec
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, is_chirpy_red, tenant_id)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- Finds users of every tenant whose email contains a LIKE pattern, or whose id is
+-- exact_id, newest first, for admin search
+-- name: SearchUsers :many
+SELECT * FROM users
+WHERE LOWER(email) LIKE sqlc.arg(pattern) ESCAPE '\' OR CAST(id AS TEXT) = sqlc.arg(exact_id)
+ORDER BY created_at DESC
+LIMIT sqlc.arg(max_results);
@@ -0,0 +1,16 @@
+-- name: AddVisits :exec
+INSERT INTO visits (day, hits)
+VALUES ($1, $2)
+ON CONFLICT (day) DO UPDATE
+SET hits = visits.hits + excluded.hits;
+
+-- name: GetTotalVisits :one
+SELECT CAST(COALESCE(SUM(hits), 0) AS BIGINT) FROM visits;
+
+-- name: GetDailyVisits :many
+SELECT * FROM visits
+ORDER BY day DESC
+LIMIT $1;
+
+-- name: DeleteVisits :exec
+DELETE FROM visits;
@@ -0,0 +1,64 @@
+-- name: CreateWebhook :one
+INSERT INTO webhooks (id, created_at, updated_at, user_id, url, secret, events, active)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetWebhook :one
+SELECT * FROM webhooks
+WHERE id = $1;
+
+-- name: ListWebhooksByUser :many
+SELECT * FROM webhooks
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: ListWebhooksForEvent :many
+-- Matches whole entries of the comma separated events column, among the active webhooks
+-- of one tenant's users. The events of a shadowbanned actor only reach their own webhooks.
+SELECT webhooks.* FROM webhooks
+JOIN users ON users.id = webhooks.user_id
+WHERE users.tenant_id = sqlc.arg(tenant_id) AND webhooks.active
+AND (',' || webhooks.events || ',') LIKE ('%,' || CAST(sqlc.arg(event) AS TEXT) || ',%')
+AND (webhooks.user_id = sqlc.arg(actor_id) OR sqlc.arg(actor_id) NOT IN (SELECT user_id FROM shadowbans))
+ORDER BY webhooks.created_at ASC;
+
+-- name: UpdateWebhook :one
+UPDATE webhooks
+SET url = $3, secret = $4, events = $5, active = $6, updated_at = NOW()
+WHERE id = $1 AND user_id = $2
+RETURNING *;
+
+-- name: DeleteWebhook :execrows
+DELETE FROM webhooks
+WHERE id = $1 AND user_id = $2;
+
+-- name: CreateWebhookDelivery :exec
+INSERT INTO webhook_deliveries (id, created_at, webhook_id, event_id, event, status_code, error, duration_ms, request_body, response_body)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5, $6, $7, $8);
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE webhook_id = $1
+ORDER BY created_at DESC
+LIMIT $2;
+
+-- Counts the rows DeleteWebhookDeliveriesBefore removes, for retention dry runs
+-- name: CountWebhookDeliveriesBefore :one
+SELECT COUNT(*) FROM webhook_deliveries
+WHERE created_at < $1;
+
+-- name: DeleteWebhookDeliveriesBefore :execrows
+DELETE FROM webhook_deliveries
+WHERE created_at < $1;
+
+-- Pages through every webhook by id, for backups
+-- name: ListWebhooksAfter :many
+SELECT * FROM webhooks
+WHERE id > $1
+ORDER BY id ASC
+LIMIT $2;
+
+-- Inserts a webhook from a backup as it was, id and timestamps included
+-- name: RestoreWebhook :exec
+INSERT INTO webhooks (id, created_at, updated_at, user_id, url, secret, events, active)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8);
@@ -0,0 +1,27 @@
+-- name: CreateWordFilter :one
+INSERT INTO word_filters (id, created_at, updated_at, pattern, kind, action, note)
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4)
+RETURNING *;
+
+-- name: GetWordFilters :many
+SELECT * FROM word_filters
+ORDER BY created_at ASC, id ASC;
+
+-- name: UpdateWordFilter :one
+UPDATE word_filters
+SET pattern = $2, kind = $3, action = $4, note = $5, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteWordFilter :execrows
+DELETE FROM word_filters
+WHERE id = $1;
+
+-- Inserts a rule from a backup as it was, replacing the rule with the same id, which
+-- a reset leaves in place
+-- name: RestoreWordFilter :exec
+INSERT INTO word_filters (id, created_at, updated_at, pattern, kind, action, note)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (id) DO UPDATE
+SET created_at = excluded.created_at, updated_at = excluded.updated_at, pattern = excluded.pattern,
+    kind = excluded.kind, action = excluded.action, note = excluded.note;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- One row per run of a recurring task. The primary key doubles as the lock: the
+-- instance that inserts the row for a (task, scheduled_for) slot is the one that
+-- runs it, every other instance sees the conflict and skips the slot.
+CREATE TABLE IF NOT EXISTS scheduled_runs (
+    task TEXT NOT NULL,
+    scheduled_for TIMESTAMP NOT NULL,
+    instance TEXT NOT NULL,
+    started_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMP,
+    -- running, succeeded or failed
+    status TEXT NOT NULL DEFAULT 'running',
+    error TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (task, scheduled_for)
+);
+CREATE INDEX IF NOT EXISTS scheduled_runs_scheduled_for_idx ON scheduled_runs (scheduled_for);
+
+-- +goose Down
+DROP TABLE IF EXISTS scheduled_runs;
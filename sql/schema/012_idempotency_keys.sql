@@ -0,0 +1,21 @@
+-- +goose Up
+-- Responses to requests sent with an Idempotency-Key header, replayed when the
+-- same key is sent again. scope is the method and path the key was used on.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    scope TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    -- hash of the request body and credentials, a reused key must match it
+    fingerprint TEXT NOT NULL,
+    -- processing while the first request runs, then done
+    status TEXT NOT NULL DEFAULT 'processing',
+    status_code INTEGER NOT NULL DEFAULT 0,
+    content_type TEXT NOT NULL DEFAULT '',
+    body TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (scope, idempotency_key)
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS idempotency_keys;
@@ -0,0 +1,36 @@
+-- +goose Up
+-- Every user and chirp belongs to a tenant. Rows that existed before tenants were
+-- introduced, and requests that name no tenant, use the default tenant whose id is
+-- the nil UUID.
+CREATE TABLE IF NOT EXISTS tenants (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    -- matched against the X-Tenant header and the subdomain
+    slug TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    -- sha256 of the tenant admin token, empty until one is issued
+    admin_token_hash TEXT NOT NULL DEFAULT '',
+    -- 0 means unlimited
+    max_users INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO tenants (id, slug, name)
+VALUES ('00000000-0000-0000-0000-000000000000', 'default', 'Default');
+
+ALTER TABLE users
+ADD COLUMN tenant_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000' REFERENCES tenants(id) ON DELETE CASCADE;
+-- emails are unique per tenant rather than globally
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_id_email_idx ON users (tenant_id, email);
+
+ALTER TABLE chirps
+ADD COLUMN tenant_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000' REFERENCES tenants(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS chirps_tenant_id_created_at_idx ON chirps (tenant_id, created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS chirps_tenant_id_created_at_idx;
+ALTER TABLE chirps DROP COLUMN tenant_id;
+DROP INDEX IF EXISTS users_tenant_id_email_idx;
+ALTER TABLE users DROP COLUMN tenant_id;
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+DROP TABLE IF EXISTS tenants;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- GCRA state for RATE_LIMIT_BACKEND=database, shared by every instance on this
+-- database. tat is the theoretical arrival time in microseconds since the epoch.
+CREATE TABLE IF NOT EXISTS rate_limits (
+    bucket TEXT PRIMARY KEY,
+    tat BIGINT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS rate_limits_tat_idx ON rate_limits (tat);
+
+-- Small pieces of state every instance must agree on, such as maintenance mode.
+-- value is JSON.
+CREATE TABLE IF NOT EXISTS runtime_state (
+    name TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS runtime_state;
+DROP TABLE IF EXISTS rate_limits;
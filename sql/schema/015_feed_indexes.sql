@@ -0,0 +1,11 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- Per-author chirp lists and batched author lookups scanned the whole chirps table.
+-- CONCURRENTLY keeps chirps writable while the index is built on a large table.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS chirps_user_id_created_at_idx ON chirps (user_id, created_at);
+-- revoking every session of a user, and deleting a user, looked up tokens by user_id
+CREATE INDEX CONCURRENTLY IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- +goose Down
+DROP INDEX CONCURRENTLY IF EXISTS refresh_tokens_user_id_idx;
+DROP INDEX CONCURRENTLY IF EXISTS chirps_user_id_created_at_idx;
@@ -0,0 +1,6 @@
+-- +goose Up
+-- JSON a long-running job writes as it goes, e.g. how many rows a bulk delete removed so far
+ALTER TABLE jobs ADD COLUMN progress TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+ALTER TABLE jobs DROP COLUMN progress;
@@ -0,0 +1,45 @@
+-- +goose Up
+-- What moderators decided about a reported chirp. Rows outlive the chirp, so chirp_id
+-- has no foreign key.
+CREATE TABLE IF NOT EXISTS moderation_decisions (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    chirp_id UUID NOT NULL,
+    author_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL,
+    -- the audit log actor of the admin who decided
+    moderator TEXT NOT NULL,
+    -- approve, remove or escalate
+    action TEXT NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    -- open reports the decision was made on
+    reports INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS moderation_decisions_created_at_idx ON moderation_decisions (created_at);
+
+-- Reports of chirps by users, and chirps flagged automatically. Like decisions they
+-- are kept after the chirp is deleted, so they still count against its author.
+CREATE TABLE IF NOT EXISTS chirp_reports (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    chirp_id UUID NOT NULL,
+    author_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL,
+    -- NULL when the chirp was flagged automatically rather than reported by a user
+    reporter_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    -- set when a moderator escalated the chirp while the report was open
+    escalated_at TIMESTAMP,
+    -- set when a moderator approved or removed the chirp
+    resolved_at TIMESTAMP,
+    decision_id UUID REFERENCES moderation_decisions(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS chirp_reports_open_idx ON chirp_reports (chirp_id) WHERE resolved_at IS NULL;
+-- a user reports a chirp at most once until moderators decide on it
+CREATE UNIQUE INDEX IF NOT EXISTS chirp_reports_reporter_idx ON chirp_reports (chirp_id, reporter_id) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS chirp_reports_created_at_idx ON chirp_reports (created_at);
+
+-- +goose Down
+DROP TABLE IF EXISTS chirp_reports;
+DROP TABLE IF EXISTS moderation_decisions;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Word-filter rules admins manage at runtime, applied to every new chirp on top of
+-- PROFANITY_WORDS
+CREATE TABLE IF NOT EXISTS word_filters (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    pattern TEXT NOT NULL,
+    -- word, wildcard or regex
+    kind TEXT NOT NULL,
+    -- mask, hold or reject
+    action TEXT NOT NULL,
+    note TEXT NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS word_filters_kind_pattern_idx ON word_filters (kind, pattern);
+
+-- +goose Down
+DROP TABLE IF EXISTS word_filters;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Users whose chirps only they can see. The read queries of chirps leave them out for
+-- everyone else.
+CREATE TABLE IF NOT EXISTS shadowbans (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    tenant_id UUID NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    -- the audit log actor of the admin who set it
+    banned_by TEXT NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS shadowbans;
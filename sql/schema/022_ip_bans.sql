@@ -0,0 +1,18 @@
+-- +goose Up
+-- Sources turned away from every endpoint, by admins or for a while by the rate
+-- limiter. A range has one ban at a time; an expired one is replaced by the next.
+CREATE TABLE IF NOT EXISTS ip_bans (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    -- normalized CIDR range, a single address as /32 or /128
+    cidr TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    -- NULL for a ban that never expires
+    expires_at TIMESTAMP,
+    -- the audit log actor of the admin who set it, or rate_limiter
+    created_by TEXT NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ip_bans_cidr_idx ON ip_bans (cidr);
+
+-- +goose Down
+DROP TABLE IF EXISTS ip_bans;
@@ -0,0 +1,41 @@
+-- +goose Up
+-- Auto-moderation rules evaluated on every new chirp. definition is the JSON of the
+-- rule's conditions and action; every edit bumps version.
+CREATE TABLE IF NOT EXISTS automod_rules (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    name TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 1,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    definition TEXT NOT NULL
+);
+
+-- Every version a rule has had, the current one included, so an edit can be undone
+CREATE TABLE IF NOT EXISTS automod_rule_versions (
+    rule_id UUID NOT NULL REFERENCES automod_rules(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    name TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL,
+    definition TEXT NOT NULL,
+    -- the audit log actor of the admin who saved it
+    created_by TEXT NOT NULL,
+    PRIMARY KEY (rule_id, version)
+);
+
+-- Posting limits tighten_rate_limit rules put on users, one at a time per user. rule_id
+-- has no foreign key so a limit outlives the rule that set it.
+CREATE TABLE IF NOT EXISTS automod_restrictions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    max_chirps INTEGER NOT NULL,
+    window_seconds INTEGER NOT NULL,
+    rule_id UUID NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS automod_restrictions;
+DROP TABLE IF EXISTS automod_rule_versions;
+DROP TABLE IF EXISTS automod_rules;
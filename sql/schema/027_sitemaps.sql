@@ -0,0 +1,26 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- The UTC days with public chirps, or users who signed up, in each tenant, kept by the
+-- refresh_sitemaps task so GET /sitemap.xml only reads this table. The sitemap of a
+-- day lists what is there when it is read; urls and last_modified are as of the last
+-- refresh that saw the day change.
+CREATE TABLE IF NOT EXISTS sitemap_pages (
+    tenant_id UUID NOT NULL,
+    -- chirps or users
+    kind TEXT NOT NULL,
+    day TIMESTAMP NOT NULL,
+    urls BIGINT NOT NULL,
+    last_modified TIMESTAMP NOT NULL,
+    PRIMARY KEY (tenant_id, kind, day)
+);
+-- the refresh looks for rows changed since it last ran, and a day's sitemap reads the
+-- tenant's chirps of that day
+CREATE INDEX CONCURRENTLY IF NOT EXISTS chirps_updated_at_idx ON chirps (updated_at);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_updated_at_idx ON users (updated_at);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS chirps_tenant_id_created_at_idx ON chirps (tenant_id, created_at);
+
+-- +goose Down
+DROP INDEX CONCURRENTLY IF EXISTS chirps_tenant_id_created_at_idx;
+DROP INDEX CONCURRENTLY IF EXISTS users_updated_at_idx;
+DROP INDEX CONCURRENTLY IF EXISTS chirps_updated_at_idx;
+DROP TABLE IF EXISTS sitemap_pages;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- The tweets of a Twitter/X import, a batch per row, so the job's payload stays small.
+-- Rows are deleted when the import finishes, or along with their job.
+CREATE TABLE IF NOT EXISTS twitter_import_batches (
+    job_id UUID NOT NULL REFERENCES jobs(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    tweets TEXT NOT NULL,
+    PRIMARY KEY (job_id, seq)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS twitter_import_batches;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- Response headers replayed along with the body, such as the Location of a created
+-- chirp, as a JSON object of header names to values
+ALTER TABLE idempotency_keys
+ADD COLUMN headers TEXT NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE idempotency_keys
+DROP COLUMN headers;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- day is an ISO date (YYYY-MM-DD) in UTC, kept as text so SQLite compares it the same way
+CREATE TABLE IF NOT EXISTS visits (
+    day TEXT PRIMARY KEY,
+    hits BIGINT NOT NULL DEFAULT 0
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS visits;
\ No newline at end of file
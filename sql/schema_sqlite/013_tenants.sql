@@ -0,0 +1,64 @@
+-- +goose NO TRANSACTION
+-- +goose Up
+-- Every user and chirp belongs to a tenant. Rows that existed before tenants were
+-- introduced, and requests that name no tenant, use the default tenant whose id is
+-- the nil UUID.
+CREATE TABLE IF NOT EXISTS tenants (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT (now()),
+    updated_at TIMESTAMP NOT NULL DEFAULT (now()),
+    -- matched against the X-Tenant header and the subdomain
+    slug TEXT NOT NULL UNIQUE,
+    name TEXT NOT NULL,
+    -- sha256 of the tenant admin token, empty until one is issued
+    admin_token_hash TEXT NOT NULL DEFAULT '',
+    -- 0 means unlimited
+    max_users INTEGER NOT NULL DEFAULT 0
+);
+INSERT INTO tenants (id, slug, name)
+VALUES ('00000000-0000-0000-0000-000000000000', 'default', 'Default');
+
+-- SQLite cannot drop the column-level UNIQUE on email, so users is rebuilt with
+-- emails unique per tenant. Foreign keys are off while the table is swapped so the
+-- chirps, tokens and webhooks pointing at it are left alone.
+PRAGMA foreign_keys = OFF;
+CREATE TABLE users_new (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    email TEXT NOT NULL,
+    hashed_password TEXT NOT NULL DEFAULT 'unset',
+    is_chirpy_red BOOLEAN NOT NULL DEFAULT FALSE,
+    tenant_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000' REFERENCES tenants(id) ON DELETE CASCADE
+);
+INSERT INTO users_new (id, created_at, updated_at, email, hashed_password, is_chirpy_red)
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_id_email_idx ON users (tenant_id, email);
+PRAGMA foreign_keys = ON;
+
+-- ALTER TABLE cannot add a foreign key with a non-null default in SQLite; a chirp's
+-- tenant always matches its author's, which is checked when it is inserted
+ALTER TABLE chirps
+ADD COLUMN tenant_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+CREATE INDEX IF NOT EXISTS chirps_tenant_id_created_at_idx ON chirps (tenant_id, created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS chirps_tenant_id_created_at_idx;
+ALTER TABLE chirps DROP COLUMN tenant_id;
+PRAGMA foreign_keys = OFF;
+CREATE TABLE users_old (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    hashed_password TEXT NOT NULL DEFAULT 'unset',
+    is_chirpy_red BOOLEAN NOT NULL DEFAULT FALSE
+);
+INSERT INTO users_old (id, created_at, updated_at, email, hashed_password, is_chirpy_red)
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users;
+DROP TABLE users;
+ALTER TABLE users_old RENAME TO users;
+PRAGMA foreign_keys = ON;
+DROP TABLE IF EXISTS tenants;
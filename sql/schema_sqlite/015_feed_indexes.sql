@@ -0,0 +1,9 @@
+-- +goose Up
+-- Per-author chirp lists and batched author lookups scanned the whole chirps table.
+CREATE INDEX IF NOT EXISTS chirps_user_id_created_at_idx ON chirps (user_id, created_at);
+-- revoking every session of a user, and deleting a user, looked up tokens by user_id
+CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS refresh_tokens_user_id_idx;
+DROP INDEX IF EXISTS chirps_user_id_created_at_idx;
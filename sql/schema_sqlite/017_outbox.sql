@@ -0,0 +1,17 @@
+-- +goose Up
+-- Domain events, written in the same transaction as the change they describe and
+-- relayed to webhooks from here, so an event is published if and only if its change committed
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT (now()),
+    tenant_id UUID NOT NULL,
+    event TEXT NOT NULL,
+    -- JSON data of the event, as webhook deliveries carry it
+    payload TEXT NOT NULL,
+    -- NULL until the relay has handed the event to webhooks
+    published_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS outbox_events_unpublished_idx ON outbox_events (created_at) WHERE published_at IS NULL;
+
+-- +goose Down
+DROP TABLE IF EXISTS outbox_events;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- How much of each quota a user used, one row per quota and window, e.g. the chirps
+-- posted on one UTC day
+CREATE TABLE IF NOT EXISTS quota_usage (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    quota TEXT NOT NULL,
+    window_start TIMESTAMP NOT NULL,
+    used BIGINT NOT NULL,
+    PRIMARY KEY (user_id, quota, window_start)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS quota_usage;
@@ -0,0 +1,23 @@
+-- +goose Up
+-- Platform statistics per UTC day and per ISO week (starting Monday), rolled up by the
+-- rollup_stats task so GET /admin/stats only reads this table. A period is recomputed
+-- until a rollup runs after it ended; updated_at >= ends_at marks it final.
+CREATE TABLE IF NOT EXISTS stats_rollups (
+    -- day or week
+    period TEXT NOT NULL,
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL,
+    new_users BIGINT NOT NULL,
+    active_users BIGINT NOT NULL,
+    chirps_posted BIGINT NOT NULL,
+    chirps_deleted BIGINT NOT NULL,
+    red_conversions BIGINT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT (now()),
+    PRIMARY KEY (period, starts_at)
+);
+-- the rollup counts events of one kind over a period
+CREATE INDEX IF NOT EXISTS outbox_events_event_created_at_idx ON outbox_events (event, created_at);
+
+-- +goose Down
+DROP INDEX IF EXISTS outbox_events_event_created_at_idx;
+DROP TABLE IF EXISTS stats_rollups;
@@ -0,0 +1,27 @@
+-- +goose Up
+-- The RSA key pair each user signs ActivityPub deliveries with, created the first time
+-- their actor document is served or something is delivered for them
+CREATE TABLE IF NOT EXISTS activitypub_keys (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT (now()),
+    public_key_pem TEXT NOT NULL,
+    private_key_pem TEXT NOT NULL
+);
+
+-- Remote actors following a user from another server, such as a Mastodon account
+CREATE TABLE IF NOT EXISTS activitypub_followers (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- the id of the remote actor
+    actor_id TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (now()),
+    inbox TEXT NOT NULL,
+    -- the server-wide inbox of the actor, '' when it has none
+    shared_inbox TEXT NOT NULL DEFAULT '',
+    -- the id of the Follow activity, which the Accept refers to
+    follow_id TEXT NOT NULL,
+    PRIMARY KEY (user_id, actor_id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS activitypub_followers;
+DROP TABLE IF EXISTS activitypub_keys;
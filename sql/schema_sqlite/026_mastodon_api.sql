@@ -0,0 +1,41 @@
+-- +goose Up
+-- Chirps users marked as favourites through the Mastodon client API
+CREATE TABLE IF NOT EXISTS chirp_favourites (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT (now()),
+    PRIMARY KEY (user_id, chirp_id)
+);
+CREATE INDEX IF NOT EXISTS chirp_favourites_chirp_id_idx ON chirp_favourites (chirp_id);
+
+-- Client applications registered through POST /api/v1/apps, which Mastodon clients do
+-- before signing a user in
+CREATE TABLE IF NOT EXISTS oauth_apps (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT (now()),
+    client_id TEXT NOT NULL UNIQUE,
+    client_secret TEXT NOT NULL,
+    name TEXT NOT NULL,
+    website TEXT NOT NULL DEFAULT '',
+    -- newline-separated, as Mastodon takes them
+    redirect_uris TEXT NOT NULL,
+    scopes TEXT NOT NULL
+);
+
+-- Authorization codes waiting to be exchanged for a token. Only the SHA-256 of a code is
+-- stored, and exchanging it deletes it, so each code works once.
+CREATE TABLE IF NOT EXISTS oauth_codes (
+    code_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT (now()),
+    expires_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id TEXT NOT NULL REFERENCES oauth_apps(client_id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS oauth_codes;
+DROP TABLE IF EXISTS oauth_apps;
+DROP INDEX IF EXISTS chirp_favourites_chirp_id_idx;
+DROP TABLE IF EXISTS chirp_favourites;
@@ -0,0 +1,27 @@
+-- +goose Up
+-- Keys developers read the public API with. Only the SHA-256 of a key is stored; prefix
+-- is the start of the key, kept so its owner can tell their keys apart.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT (now()),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id UUID NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    prefix TEXT NOT NULL,
+    last_used_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);
+
+-- Requests made with each key, one row per key and UTC day
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    day TIMESTAMP NOT NULL,
+    requests BIGINT NOT NULL,
+    PRIMARY KEY (api_key_id, day)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS api_key_usage;
+DROP INDEX IF EXISTS api_keys_user_id_idx;
+DROP TABLE IF EXISTS api_keys;
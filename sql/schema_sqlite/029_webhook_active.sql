@@ -0,0 +1,8 @@
+-- +goose Up
+-- Paused webhooks keep their settings and delivery log but receive no events
+ALTER TABLE webhooks
+ADD COLUMN active BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- +goose Down
+ALTER TABLE webhooks
+DROP COLUMN active;
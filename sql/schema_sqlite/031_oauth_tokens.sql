@@ -0,0 +1,13 @@
+-- +goose Up
+-- Access tokens issued to Mastodon clients, with the scopes the user granted. Only the
+-- SHA-256 of a token is stored; revoking a token deletes it.
+CREATE TABLE IF NOT EXISTS oauth_tokens (
+    token_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL DEFAULT (now()),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id TEXT NOT NULL REFERENCES oauth_apps(client_id) ON DELETE CASCADE,
+    scopes TEXT NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS oauth_tokens;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- Users who asked for the digest email of new chirps in their tenant. last_sent_at is
+-- where the next digest starts, which is when they subscribed before the first one.
+CREATE TABLE IF NOT EXISTS digest_subscriptions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT (now()),
+    last_sent_at TIMESTAMP NOT NULL
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS digest_subscriptions;
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/pressly/goose/v3"
+	"modernc.org/sqlite"
+)
+
+// Timestamps are stored as text in the same layout the modernc driver uses for
+// time.Time arguments, always in UTC, so string comparisons order correctly.
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+// The sqlc queries are written for Postgres; registering its gen_random_uuid() and
+// now() functions lets the same generated code run unchanged against SQLite.
+func init() {
+	sqlite.MustRegisterScalarFunction("gen_random_uuid", 0, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return uuid.NewString(), nil
+	})
+	sqlite.MustRegisterScalarFunction("now", 0, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		return time.Now().UTC().Format(sqliteTimeFormat), nil
+	})
+}
+
+// Opens the database named by DB_URL. sqlite:// URLs (e.g. sqlite://chirpy.db) use the
+// embedded SQLite driver; anything else is treated as a Postgres connection string and
+// opened with lib/pq, or with pgx when DB_DRIVER=pgx. dbURL is called again for every
+// new Postgres connection, so credentials rotated in a secret store are picked up.
+func openDatabase(dbURL func() string) (*sql.DB, goose.Dialect, error) {
+	path, ok := strings.CutPrefix(dbURL(), "sqlite://")
+	if !ok {
+		driverName, err := postgresDriver(os.Getenv("DB_DRIVER"))
+		if err != nil {
+			return nil, "", err
+		}
+		// sql.Open only looks the driver up, the connector asks for the URL per connection
+		probe, err := sql.Open(driverName, "")
+		if err != nil {
+			return nil, "", err
+		}
+		db := sql.OpenDB(dsnConnector{driver: probe.Driver(), dsn: dbURL})
+		probe.Close()
+		// sql.Open does not connect, so make sure Postgres is there before anything uses it
+		err = waitForDatabase(context.Background(), db.PingContext, getEnvDuration("DB_CONNECT_TIMEOUT", 30*time.Second), 250*time.Millisecond)
+		if err != nil {
+			db.Close()
+			return nil, "", err
+		}
+		return db, goose.DialectPostgres, nil
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	dsn := "file:" + path + separator + "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, "", err
+	}
+	// SQLite allows a single writer, so serialize access through one connection
+	db.SetMaxOpenConns(1)
+	return db, goose.DialectSQLite3, nil
+}
+
+// Opens connections with the connection string current at the time
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    func() string
+}
+
+func (c dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	if d, ok := c.driver.(driver.DriverContext); ok {
+		connector, err := d.OpenConnector(c.dsn())
+		if err != nil {
+			return nil, err
+		}
+		return connector.Connect(ctx)
+	}
+	return c.driver.Open(c.dsn())
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// Maps DB_DRIVER to the database/sql driver name for Postgres. lib/pq stays the
+// default; pgx speaks the binary protocol and lets store.SendBatch pipeline writes.
+func postgresDriver(name string) (string, error) {
+	switch name {
+	case "", "pq":
+		return "postgres", nil
+	case "pgx":
+		return "pgx", nil
+	}
+	return "", fmt.Errorf("unknown DB_DRIVER %q, expected pq or pgx", name)
+}
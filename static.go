@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/compress"
+)
+
+// Frontend files served under /app/. Only what is listed here ships in the binary,
+// so nothing else in the working directory (.env, sources, the binary) is reachable.
+//
+//go:embed index.html assets
+var staticFiles embed.FS
+
+// Serves the embedded frontend, content types come from the file extensions.
+// Paths that match no file and have no extension are client-side routes and get
+// index.html; missing files with an extension are still 404s so broken asset links
+// do not silently load the page instead.
+// A Brotli encoding from brotli is served instead of the file when the client accepts
+// it; brotli may be nil.
+func staticHandler(brotli *compress.Precompressed) http.Handler {
+	files := http.FileServerFS(staticFiles)
+	etags := staticETags()
+	modTime := staticModTime()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if name == "" || name == "." {
+			name = "index.html"
+		} else if name == "index.html" {
+			// FileServer redirects /index.html to the directory
+			files.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := etags[name]; !ok {
+			if path.Ext(name) != "" || isStaticDir(name) {
+				// 404s and the redirects of directories
+				files.ServeHTTP(w, r)
+				return
+			}
+			// the fallback page must be revalidated, it stands in for every route
+			w.Header().Set("Cache-Control", "no-cache")
+			name = "index.html"
+		}
+		w.Header().Set("ETag", etags[name])
+		if brotli != nil && brotli.ServeFile(w, r, name, modTime) {
+			return
+		}
+		data, err := staticFiles.ReadFile(name)
+		if err != nil {
+			marshallError(w, err, 500)
+			return
+		}
+		// answers If-None-Match and If-Modified-Since with 304, and Range with 206
+		http.ServeContent(w, r, name, modTime, bytes.NewReader(data))
+	})
+}
+
+// Strong ETags of the embedded files by name, from their content hashes, so they stay
+// the same across builds and instances until a file changes
+func staticETags() map[string]string {
+	etags := make(map[string]string)
+	err := fs.WalkDir(staticFiles, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := staticFiles.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		etags[name] = fmt.Sprintf(`"%x"`, sum[:8])
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("Failed to read static files: %s", err.Error())
+	}
+	return etags
+}
+
+// Embedded files carry no modification time, so they are served as last modified when
+// the binary was, or when the server started if that is unknown
+func staticModTime() time.Time {
+	if executable, err := os.Executable(); err == nil {
+		if info, err := os.Stat(executable); err == nil {
+			return info.ModTime()
+		}
+	}
+	return time.Now()
+}
+
+func isStaticDir(name string) bool {
+	info, err := fs.Stat(staticFiles, name)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/cache"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/email"
+	"github.com/diamondoughnut/httpChirpy/internal/webhooks"
+)
+
+// Component states, from best to worst
+const (
+	statusOK        = "ok"
+	statusDegraded  = "degraded"
+	statusUnhealthy = "unhealthy"
+)
+
+var statusRank = map[string]int{statusOK: 0, statusDegraded: 1, statusUnhealthy: 2}
+
+// Work waiting longer than this makes its component degraded
+const statusMaxLag = time.Minute
+
+// Health of one subsystem. Message says what is wrong when it is not ok.
+type componentStatus struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	Message   string `json:"message,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+	Stats     any    `json:"stats"`
+}
+
+// Response of GET /admin/status
+type statusReport struct {
+	Status     string            `json:"status"`
+	CheckedAt  time.Time         `json:"checked_at"`
+	Components []componentStatus `json:"components"`
+}
+
+// How much work of one job kind is waiting, running or dead, and how long the oldest
+// due job has waited
+type queueStats struct {
+	Pending    int64   `json:"pending"`
+	Running    int64   `json:"running"`
+	Dead       int64   `json:"dead"`
+	LagSeconds float64 `json:"lag_seconds"`
+}
+
+// Marks the component degraded or unhealthy with a message, unless it is already worse
+func (c *componentStatus) worsen(status, message string) {
+	if statusRank[status] > statusRank[c.Status] {
+		c.Status = status
+		c.Message = message
+	}
+}
+
+// Admin endpoint for status pages: the health and key figures of the database, caches,
+// job queue, mailer and webhook relay. Answers 503 when any of them is unhealthy.
+func (cfg *apiConfig) handlerStatus(w http.ResponseWriter, r *http.Request) {
+	report := statusReport{Status: statusOK, CheckedAt: time.Now().UTC()}
+	queues, queuesErr := cfg.jobQueueStats(r.Context())
+	for _, check := range []struct {
+		name string
+		run  func(ctx context.Context, c *componentStatus)
+	}{
+		{"database", cfg.checkDatabase},
+		{"cache", cfg.checkCache},
+		{"job_queue", func(ctx context.Context, c *componentStatus) { checkQueues(c, queues, queuesErr) }},
+		{"mailer", func(ctx context.Context, c *componentStatus) { checkQueue(c, queues, queuesErr, email.JobKind) }},
+		{"webhook_relay", func(ctx context.Context, c *componentStatus) { cfg.checkWebhookRelay(ctx, c, queues, queuesErr) }},
+	} {
+		component := componentStatus{Name: check.name, Status: statusOK}
+		ctx, cancel := context.WithTimeout(r.Context(), cfg.readinessTimeout)
+		start := time.Now()
+		check.run(ctx, &component)
+		component.LatencyMs = time.Since(start).Milliseconds()
+		cancel()
+		if component.Status != statusOK {
+			log.Printf("Status check %s is %s: %s", component.Name, component.Status, component.Message)
+		}
+		if statusRank[component.Status] > statusRank[report.Status] {
+			report.Status = component.Status
+		}
+		report.Components = append(report.Components, component)
+	}
+	code := 200
+	if report.Status == statusUnhealthy {
+		code = 503
+	}
+	render(w, r, code, report)
+}
+
+// The database answers, and has a free connection in its pool
+func (cfg *apiConfig) checkDatabase(ctx context.Context, c *componentStatus) {
+	if cfg.db == nil {
+		c.Stats = map[string]any{"backend": "memory"}
+		return
+	}
+	err := cfg.db.PingContext(ctx)
+	pool := cfg.db.Stats()
+	c.Stats = map[string]any{
+		"max_open_connections": pool.MaxOpenConnections,
+		"open_connections":     pool.OpenConnections,
+		"in_use":               pool.InUse,
+		"wait_count":           pool.WaitCount,
+		"wait_duration_ms":     pool.WaitDuration.Milliseconds(),
+	}
+	if err != nil {
+		c.worsen(statusUnhealthy, "the database does not answer: "+err.Error())
+		return
+	}
+	if pool.MaxOpenConnections > 0 && pool.InUse >= pool.MaxOpenConnections {
+		c.worsen(statusDegraded, "every connection in the pool is in use, queries are waiting for one")
+	}
+}
+
+// The shared cache answers. Requests fall back to the database without it, so it is
+// never worse than degraded.
+func (cfg *apiConfig) checkCache(ctx context.Context, c *componentStatus) {
+	stats := map[string]any{
+		"shared":         "none",
+		"chirp_entries":  cfg.chirpCache.Len(),
+		"chirp_lists":    cfg.chirpListCache.Len(),
+		"tenant_entries": cfg.tenantCache.Len(),
+	}
+	c.Stats = stats
+	if _, ok := cfg.sharedCache.(*cache.Redis); !ok {
+		return
+	}
+	stats["shared"] = "redis"
+	_, _, err := cfg.sharedCache.Get(ctx, "status-check")
+	if err != nil {
+		c.worsen(statusDegraded, "the shared cache does not answer, reads go to the database: "+err.Error())
+	}
+}
+
+// Counts jobs that are not done by kind, and how long the oldest due job of each kind
+// with pending jobs has waited
+func (cfg *apiConfig) jobQueueStats(ctx context.Context) (map[string]*queueStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.readinessTimeout)
+	defer cancel()
+	rows, err := cfg.store.CountJobsByStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	queues := make(map[string]*queueStats)
+	for _, row := range rows {
+		queue, ok := queues[row.Kind]
+		if !ok {
+			queue = &queueStats{}
+			queues[row.Kind] = queue
+		}
+		switch row.Status {
+		case "pending":
+			queue.Pending = row.Count
+		case "running":
+			queue.Running = row.Count
+		case "dead":
+			queue.Dead = row.Count
+		}
+	}
+	for kind, queue := range queues {
+		if queue.Pending == 0 {
+			continue
+		}
+		due, err := cfg.store.GetOldestDueJob(ctx, database.GetOldestDueJobParams{Kind: kind, RunAt: now})
+		if errors.Is(err, sql.ErrNoRows) {
+			// every pending job is waiting for a retry later on
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		queue.LagSeconds = now.Sub(due).Seconds()
+	}
+	return queues, nil
+}
+
+// Every job kind, degraded when any of them lags
+func checkQueues(c *componentStatus, queues map[string]*queueStats, err error) {
+	if err != nil {
+		c.worsen(statusUnhealthy, "the job queue cannot be read: "+err.Error())
+		return
+	}
+	c.Stats = queues
+	for kind, queue := range queues {
+		if queue.LagSeconds > statusMaxLag.Seconds() {
+			c.worsen(statusDegraded, kind+" jobs are waiting longer than "+statusMaxLag.String()+" to run")
+		}
+	}
+}
+
+// One job kind, degraded when it lags
+func checkQueue(c *componentStatus, queues map[string]*queueStats, err error, kind string) {
+	if err != nil {
+		c.worsen(statusUnhealthy, "the job queue cannot be read: "+err.Error())
+		return
+	}
+	queue := queues[kind]
+	if queue == nil {
+		queue = &queueStats{}
+	}
+	c.Stats = queue
+	if queue.LagSeconds > statusMaxLag.Seconds() {
+		c.worsen(statusDegraded, kind+" jobs are waiting longer than "+statusMaxLag.String()+" to run")
+	}
+}
+
+// Outbox events are relayed, and their deliveries do not lag
+func (cfg *apiConfig) checkWebhookRelay(ctx context.Context, c *componentStatus, queues map[string]*queueStats, queuesErr error) {
+	checkQueue(c, queues, queuesErr, webhooks.JobKind)
+	deliveries := c.Stats
+	pending, err := cfg.store.CountUnpublishedOutboxEvents(ctx)
+	if err == nil {
+		var oldest []database.OutboxEvent
+		oldest, err = cfg.store.ListUnpublishedOutboxEvents(ctx, 1)
+		stats := map[string]any{"outbox_pending": pending, "outbox_lag_seconds": 0.0, "deliveries": deliveries}
+		if err == nil && len(oldest) > 0 {
+			lag := time.Since(oldest[0].CreatedAt)
+			stats["outbox_lag_seconds"] = lag.Seconds()
+			if lag > statusMaxLag {
+				c.worsen(statusDegraded, "outbox events are waiting longer than "+statusMaxLag.String()+" to be relayed")
+			}
+		}
+		c.Stats = stats
+	}
+	if err != nil {
+		c.worsen(statusUnhealthy, "the outbox cannot be read: "+err.Error())
+	}
+}
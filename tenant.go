@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/auth"
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Tenants partition users and chirps. Each request is served in exactly one tenant,
+// named by the X-Tenant header or the subdomain of TENANT_DOMAIN, and every user and
+// chirp query is filtered by it. Requests that name no tenant, and the CLI, use the
+// default tenant, which owns everything created before tenants existed.
+
+const defaultTenantSlug = "default"
+
+// Header naming the tenant of a request, ahead of the subdomain
+const tenantHeader = "X-Tenant"
+
+type tenantKey struct{}
+
+// Returns the tenant middlewareTenant resolved, or the default tenant outside of it
+func tenantFrom(ctx context.Context) database.Tenant {
+	tenant, ok := ctx.Value(tenantKey{}).(database.Tenant)
+	if !ok {
+		return database.Tenant{ID: uuid.Nil, Slug: defaultTenantSlug}
+	}
+	return tenant
+}
+
+// Returns the ID every user and chirp query of the request is filtered by
+func tenantID(ctx context.Context) uuid.UUID {
+	return tenantFrom(ctx).ID
+}
+
+type tenantResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Slug      string    `json:"slug"`
+	Name      string    `json:"name"`
+	MaxUsers  int32     `json:"max_users"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// only returned when the token is issued
+	AdminToken string `json:"admin_token,omitempty"`
+}
+
+func newTenantResponse(tenant database.Tenant) tenantResponse {
+	return tenantResponse{
+		ID:        tenant.ID,
+		Slug:      tenant.Slug,
+		Name:      tenant.Name,
+		MaxUsers:  tenant.MaxUsers,
+		CreatedAt: tenant.CreatedAt,
+		UpdatedAt: tenant.UpdatedAt,
+	}
+}
+
+// Picks the slug of the request's tenant: the X-Tenant header, then the subdomain of
+// TENANT_DOMAIN, then the default tenant
+func (cfg *apiConfig) tenantSlug(r *http.Request) string {
+	if slug := r.Header.Get(tenantHeader); slug != "" {
+		return slug
+	}
+	if cfg.tenantDomain != "" {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		sub, ok := strings.CutSuffix(strings.ToLower(host), "."+cfg.tenantDomain)
+		if ok && !strings.Contains(sub, ".") {
+			return sub
+		}
+	}
+	return defaultTenantSlug
+}
+
+// Reads a tenant through the in-process cache. Changes made on another instance show
+// up here after TENANT_CACHE_TTL.
+func (cfg *apiConfig) lookupTenant(ctx context.Context, slug string) (database.Tenant, error) {
+	if tenant, ok := cfg.tenantCache.Get(slug); ok {
+		return tenant, nil
+	}
+	tenant, err := cfg.store.GetTenantBySlug(ctx, slug)
+	if err != nil {
+		return database.Tenant{}, err
+	}
+	cfg.tenantCache.Set(slug, tenant)
+	return tenant, nil
+}
+
+func unknownTenantError(slug string) *apiError {
+	return &apiError{Code: "unknown_tenant", Message: fmt.Sprintf("there is no tenant named %q", slug)}
+}
+
+// Middleware that resolves the tenant of every request and puts it in the context.
+// Naming a tenant that does not exist is a 404 for every route.
+func (cfg *apiConfig) middlewareTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug := cfg.tenantSlug(r)
+		tenant, err := cfg.lookupTenant(r.Context(), slug)
+		if errors.Is(err, sql.ErrNoRows) {
+			marshallError(w, unknownTenantError(slug), 404)
+			return
+		}
+		if err != nil {
+			log.Printf("Error resolving tenant %q: %s", slug, err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey{}, tenant)))
+	})
+}
+
+// Resolves the tenant of a gRPC call from the "x-tenant" metadata, the default tenant
+// when it is absent
+func (cfg *apiConfig) grpcTenantInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	slug := defaultTenantSlug
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(strings.ToLower(tenantHeader)); len(values) > 0 && values[0] != "" {
+		slug = values[0]
+	}
+	tenant, err := cfg.lookupTenant(ctx, slug)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, unknownTenantError(slug).Message)
+	}
+	if err != nil {
+		log.Printf("Error resolving tenant %q over gRPC: %s", slug, err.Error())
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return handler(context.WithValue(ctx, tenantKey{}, tenant), req)
+}
+
+// Middleware for a tenant's own admin routes. It accepts the admin token of the
+// request's tenant, or the operator's ADMIN_TOKEN.
+func (cfg *apiConfig) middlewareTenantAdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			marshallError(w, err, 401)
+			return
+		}
+		tenant := tenantFrom(r.Context())
+		operator := cfg.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.adminToken)) == 1
+		tenantAdmin := tenant.AdminTokenHash != "" && subtle.ConstantTimeCompare([]byte(hashTenantAdminToken(token)), []byte(tenant.AdminTokenHash)) == 1
+		if !operator && !tenantAdmin {
+			log.Printf("Rejected tenant admin request for %s with invalid token from %s", tenant.Slug, clientIP(r))
+			marshallError(w, fmt.Errorf("invalid tenant admin token"), 401)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Registers a route for the admins of the request's tenant
+func (cfg *apiConfig) handleTenantAdmin(mux *http.ServeMux, pattern string, handler http.HandlerFunc) {
+	mux.Handle(pattern, cfg.middlewareTenantAdminAuth(withTimeout(cfg.routeTimeout(pattern), handler)))
+}
+
+// Returns a new tenant admin token and the hash that is stored in its place
+func newTenantAdminToken() (string, string, error) {
+	dat := make([]byte, 32)
+	_, err := rand.Read(dat)
+	if err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(dat)
+	return token, hashTenantAdminToken(token), nil
+}
+
+func hashTenantAdminToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Lists every tenant
+func (cfg *apiConfig) handlerListTenants(w http.ResponseWriter, r *http.Request) {
+	tenants, err := cfg.store.ListTenants(r.Context())
+	if err != nil {
+		log.Printf("Error listing tenants: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]tenantResponse, 0, len(tenants))
+	for _, tenant := range tenants {
+		resp = append(resp, newTenantResponse(tenant))
+	}
+	render(w, r, 200, resp)
+}
+
+// Creates a tenant along with its admin token, which is shown only once
+func (cfg *apiConfig) handlerCreateTenant(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Slug     string `json:"slug" validate:"required,slug"`
+		Name     string `json:"name" validate:"required,max=100"`
+		MaxUsers int32  `json:"max_users" validate:"min=0"`
+	}
+	params := parameters{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	if _, err := cfg.store.GetTenantBySlug(r.Context(), params.Slug); err == nil {
+		marshallError(w, &apiError{Code: "tenant_exists", Message: fmt.Sprintf("a tenant named %q already exists", params.Slug)}, 409)
+		return
+	}
+	token, hash, err := newTenantAdminToken()
+	if err != nil {
+		log.Printf("Error generating tenant admin token: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	tenant, err := cfg.store.CreateTenant(r.Context(), database.CreateTenantParams{
+		Slug:           params.Slug,
+		Name:           params.Name,
+		AdminTokenHash: hash,
+		MaxUsers:       params.MaxUsers,
+	})
+	if err != nil {
+		log.Printf("Error creating tenant: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.recordAudit(r, "tenant.create", tenant.Slug, map[string]any{"name": tenant.Name, "max_users": tenant.MaxUsers})
+	resp := newTenantResponse(tenant)
+	resp.AdminToken = token
+	render(w, r, 201, resp)
+}
+
+// Changes a tenant's name and limits
+func (cfg *apiConfig) handlerUpdateTenant(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Name     string `json:"name" validate:"required,max=100"`
+		MaxUsers int32  `json:"max_users" validate:"min=0"`
+	}
+	params := parameters{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return
+	}
+	slug := r.PathValue("slug")
+	tenant, err := cfg.store.UpdateTenant(r.Context(), database.UpdateTenantParams{Slug: slug, Name: params.Name, MaxUsers: params.MaxUsers})
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, unknownTenantError(slug), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating tenant: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.tenantCache.Delete(slug)
+	cfg.recordAudit(r, "tenant.update", slug, map[string]any{"name": tenant.Name, "max_users": tenant.MaxUsers})
+	render(w, r, 200, newTenantResponse(tenant))
+}
+
+// Issues a new admin token for a tenant, revoking the previous one
+func (cfg *apiConfig) handlerRotateTenantAdminToken(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	token, hash, err := newTenantAdminToken()
+	if err != nil {
+		log.Printf("Error generating tenant admin token: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	tenant, err := cfg.store.SetTenantAdminToken(r.Context(), database.SetTenantAdminTokenParams{Slug: slug, AdminTokenHash: hash})
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, unknownTenantError(slug), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error rotating tenant admin token: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.tenantCache.Delete(slug)
+	cfg.recordAudit(r, "tenant.rotate_admin_token", slug, nil)
+	resp := newTenantResponse(tenant)
+	resp.AdminToken = token
+	render(w, r, 200, resp)
+}
+
+// Shows the request's tenant with its usage against its limits, for the tenant's admins
+func (cfg *apiConfig) handlerGetOwnTenant(w http.ResponseWriter, r *http.Request) {
+	tenant := tenantFrom(r.Context())
+	users, err := cfg.store.CountUsersByTenant(r.Context(), tenant.ID)
+	if err != nil {
+		log.Printf("Error counting tenant users: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	type response struct {
+		tenantResponse
+		Users int64 `json:"users"`
+	}
+	render(w, r, 200, response{tenantResponse: newTenantResponse(tenant), Users: users})
+}
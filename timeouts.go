@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Deadlines of routes that should not get REQUEST_TIMEOUT, keyed like apiDocs for /api
+// routes and by their full pattern for admin routes. 0 means no deadline.
+var routeTimeouts = map[string]time.Duration{
+	// probes answer quickly or not at all, readiness waits READINESS_TIMEOUT itself
+	"GET /healthz": time.Second,
+	"GET /readyz":  5 * time.Second,
+	// sign-in only hashes a password and touches a row or two
+	"POST /users":   5 * time.Second,
+	"PUT /users":    5 * time.Second,
+	"POST /login":   5 * time.Second,
+	"POST /refresh": 5 * time.Second,
+	"POST /revoke":  5 * time.Second,
+	// and so do Mastodon client sign-ins
+	"POST /oauth/authorize": 5 * time.Second,
+	"POST /oauth/token":     5 * time.Second,
+	// queries and admin reports that may scan a lot of rows
+	"POST /graphql":                       30 * time.Second,
+	"GET /admin/api/stats":                time.Minute,
+	"GET /admin/db-stats":                 time.Minute,
+	"GET /admin/audit-log":                time.Minute,
+	"GET /admin/search":                   time.Minute,
+	"POST /admin/chirps/bulk-delete":      2 * time.Minute,
+	"DELETE /admin/users/{userID}/chirps": 2 * time.Minute,
+	"POST /admin/retention/{rule}/run":    5 * time.Minute,
+	// streamed as they are read, they lift the write deadline themselves
+	"POST /admin/backup":  0,
+	"POST /admin/restore": 0,
+	"GET /admin/users":    0,
+	// an archive is read in full before its job is queued, however long the upload takes
+	"POST /import/twitter": 0,
+}
+
+// Returns the deadline of a route: its REQUEST_TIMEOUTS entry, else its routeTimeouts
+// entry, else REQUEST_TIMEOUT
+func (cfg *apiConfig) routeTimeout(pattern string) time.Duration {
+	if d, ok := cfg.requestTimeouts[pattern]; ok {
+		return d
+	}
+	if d, ok := routeTimeouts[pattern]; ok {
+		return d
+	}
+	return cfg.requestTimeout
+}
+
+// Like http.TimeoutHandler: runs next with a deadline on its context and buffers its
+// response, answering 503 with a JSON error if it is not done in time. The handler's
+// late writes are discarded. The write deadline of the connection moves along, so
+// routes may take longer than SERVER_WRITE_TIMEOUT.
+func withTimeout(timeout time.Duration, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// leave time to send the response once the handler is done or given up on
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		// handlers read headers set by earlier middleware, such as X-Request-ID
+		tw := &timeoutWriter{header: w.Header().Clone(), code: 200}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			next.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for key, values := range tw.header {
+				w.Header()[key] = values
+			}
+			w.WriteHeader(tw.code)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			if r.Context().Err() != nil {
+				// the client went away, there is no one to answer
+				return
+			}
+			marshallError(w, &apiError{Code: "request_timeout", Message: "the request took longer than " + timeout.String()}, 503)
+		}
+	})
+}
+
+type timeoutWriter struct {
+	mu          sync.Mutex
+	header      http.Header
+	buf         bytes.Buffer
+	code        int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	tw.code = code
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.wroteHeader = true
+	return tw.buf.Write(b)
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/jobs"
+	"github.com/diamondoughnut/httpChirpy/internal/store"
+	"github.com/diamondoughnut/httpChirpy/internal/twitter"
+	"github.com/google/uuid"
+)
+
+const twitterImportJobKind = "import.twitter"
+
+// tweets stored per transaction
+const twitterImportBatchSize = 100
+
+// Chirps made from tweets get IDs derived from the user and tweet, so importing an
+// archive twice, or retrying a job that stopped midway, does not duplicate them
+var twitterImportNamespace = uuid.MustParse("0b4a1c53-6f0e-4d7a-9a43-1f2d6c8e5b71")
+
+func importedChirpID(userID uuid.UUID, tweetID string) uuid.UUID {
+	return uuid.NewSHA1(twitterImportNamespace, []byte(userID.String()+":"+tweetID))
+}
+
+// The job payload of an import: whose chirps the tweets become and how many there are.
+// The tweets themselves are in twitter_import_batches, BatchSize to a row.
+type twitterImport struct {
+	UserID    uuid.UUID `json:"user_id"`
+	TenantID  uuid.UUID `json:"tenant_id"`
+	Total     int       `json:"total"`
+	BatchSize int       `json:"batch_size"`
+}
+
+// Progress of an import job. Next is the index of the first tweet not yet stored.
+type twitterImportProgress struct {
+	Total      int `json:"total"`
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	// tweets that are not valid chirps, such as ones over 140 characters
+	Skipped int `json:"skipped"`
+	Next    int `json:"next"`
+}
+
+// Starts importing the tweets of a Twitter/X archive as the user's chirps. The archive
+// zip is the body, or the archive field of a multipart form. Retweets are left out, and
+// replies too unless include_replies is set.
+func (cfg *apiConfig) handlerImportTwitter(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	includeReplies, _ := strconv.ParseBool(r.URL.Query().Get("include_replies"))
+	ctx := r.Context()
+	users, err := cfg.store.GetUsersByIds(ctx, database.GetUsersByIdsParams{TenantID: tenantID(ctx), Ids: userID.String()})
+	if err != nil {
+		log.Printf("Error getting user: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if len(users) == 0 {
+		marshallError(w, errOtherTenant, 403)
+		return
+	}
+	// archives run to hundreds of megabytes, more than the read deadline allows for
+	http.NewResponseController(w).SetReadDeadline(time.Time{})
+	archive, err := readTwitterArchive(r)
+	if errors.As(err, new(*http.MaxBytesError)) {
+		marshallError(w, fmt.Errorf("request body too large"), 413)
+		return
+	}
+	if errors.Is(err, twitter.ErrTooLarge) {
+		marshallError(w, err, 413)
+		return
+	}
+	if err != nil {
+		marshallError(w, invalidInputError{err.Error()}, 400)
+		return
+	}
+	tweets := slices.DeleteFunc(archive.Tweets, func(tweet twitter.Tweet) bool { return tweet.Reply && !includeReplies })
+	if len(tweets) == 0 {
+		marshallError(w, invalidInputError{"the archive has no tweets to import"}, 400)
+		return
+	}
+	payload := twitterImport{UserID: userID, TenantID: tenantID(ctx), Total: len(tweets), BatchSize: twitterImportBatchSize}
+	var job database.Job
+	// the job only becomes visible to workers together with its tweets
+	err = cfg.store.WithTx(ctx, func(tx store.Store) error {
+		job, err = cfg.jobs.EnqueueTx(ctx, tx, twitterImportJobKind, payload)
+		if err != nil {
+			return err
+		}
+		for seq := 0; seq*twitterImportBatchSize < len(tweets); seq++ {
+			dat, err := json.Marshal(tweets[seq*twitterImportBatchSize : min((seq+1)*twitterImportBatchSize, len(tweets))])
+			if err != nil {
+				return err
+			}
+			err = tx.CreateTwitterImportBatch(ctx, database.CreateTwitterImportBatchParams{JobID: job.ID, Seq: int32(seq), Tweets: string(dat)})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error enqueueing twitter import: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.recordAudit(r, "import.twitter", job.ID.String(), map[string]any{
+		"user_id":  userID,
+		"tweets":   len(tweets),
+		"retweets": archive.Retweets,
+	})
+	w.Header().Set("Location", "/api/import/twitter/"+job.ID.String())
+	render(w, r, 202, twitterImportResponse(job))
+}
+
+// Helper function to spool the uploaded archive to a temporary file, since a zip is
+// read from its end, and parse it
+func readTwitterArchive(r *http.Request) (twitter.Archive, error) {
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return twitter.Archive{}, err
+		}
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				return twitter.Archive{}, errors.New("the form has no archive field")
+			}
+			if err != nil {
+				return twitter.Archive{}, err
+			}
+			if part.FormName() == "archive" {
+				body = part
+				break
+			}
+		}
+	}
+	f, err := os.CreateTemp("", "chirpy-twitter-*.zip")
+	if err != nil {
+		return twitter.Archive{}, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	size, err := io.Copy(f, body)
+	if err != nil {
+		return twitter.Archive{}, err
+	}
+	return twitter.ReadArchive(f, size)
+}
+
+// The job of an import, without the tweets it was given
+func twitterImportResponse(job database.Job) jobResponse {
+	resp := newJobResponse(job)
+	resp.Payload = nil
+	return resp
+}
+
+// Shows how an import is going. Only the user who started it can see it.
+func (cfg *apiConfig) handlerGetTwitterImport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid job id"), 400)
+		return
+	}
+	job, err := cfg.store.GetJob(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting job: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	var payload struct {
+		UserID uuid.UUID `json:"user_id"`
+	}
+	if err == nil && job.Kind == twitterImportJobKind {
+		json.Unmarshal([]byte(job.Payload), &payload)
+	}
+	if payload.UserID != userID {
+		marshallError(w, fmt.Errorf("no import with id %s", id), 404)
+		return
+	}
+	render(w, r, 200, twitterImportResponse(job))
+}
+
+// Job handler storing the tweets of a twitterImport as chirps a batch at a time, saving
+// progress after each. Chirps keep their tweet's timestamp and are not announced as new:
+// no webhooks, events or deliveries go out, and they do not count towards the quota.
+func (cfg *apiConfig) runTwitterImport(ctx context.Context, payload json.RawMessage) error {
+	var in twitterImport
+	err := json.Unmarshal(payload, &in)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	jobID, ok := jobs.ID(ctx)
+	if !ok || in.BatchSize <= 0 {
+		return jobs.Permanent(errors.New("the import has no stored tweets"))
+	}
+	progress := twitterImportProgress{Total: in.Total}
+	job, err := cfg.store.GetJob(ctx, jobID)
+	if err == nil && job.Progress != "" {
+		json.Unmarshal([]byte(job.Progress), &progress)
+	}
+	users, err := cfg.store.GetUsersByIds(ctx, database.GetUsersByIdsParams{TenantID: in.TenantID, Ids: in.UserID.String()})
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return jobs.Permanent(fmt.Errorf("user %s no longer exists", in.UserID))
+	}
+	defer cfg.purgeChirpCache(context.WithoutCancel(ctx))
+	for progress.Next < in.Total {
+		seq := progress.Next / in.BatchSize
+		dat, err := cfg.store.GetTwitterImportBatch(ctx, database.GetTwitterImportBatchParams{JobID: jobID, Seq: int32(seq)})
+		if errors.Is(err, sql.ErrNoRows) {
+			return jobs.Permanent(fmt.Errorf("batch %d of the import is missing", seq))
+		}
+		if err != nil {
+			return err
+		}
+		var batch []twitter.Tweet
+		err = json.Unmarshal([]byte(dat), &batch)
+		if err != nil {
+			return jobs.Permanent(err)
+		}
+		// a batch is stored whole, so a retry may resume partway into one
+		batch = batch[min(progress.Next-seq*in.BatchSize, len(batch)):]
+		next := progress
+		err = cfg.store.WithTx(ctx, func(tx store.Store) error {
+			for _, tweet := range batch {
+				next.Next++
+				cleaned, held, err := validate(createChirpRequest{Body: tweet.Text}, cfg.settings.Load().profanity, cfg.wordFilter.Load())
+				if err != nil {
+					next.Skipped++
+					continue
+				}
+				id := importedChirpID(in.UserID, tweet.ID)
+				n, err := tx.ImportChirp(ctx, database.ImportChirpParams{
+					ID:        id,
+					CreatedAt: tweet.CreatedAt.UTC(),
+					Body:      cleaned,
+					UserID:    in.UserID,
+					TenantID:  in.TenantID,
+				})
+				if err != nil {
+					return err
+				}
+				if n == 0 {
+					next.Duplicates++
+					continue
+				}
+				next.Imported++
+				if len(held) > 0 {
+					_, err = tx.CreateChirpReport(ctx, database.CreateChirpReportParams{
+						ChirpID:  id,
+						AuthorID: in.UserID,
+						TenantID: in.TenantID,
+						Reason:   wordFilterReason,
+						Details:  heldDetails(held),
+					})
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		progress = next
+		err = cfg.jobs.SetProgress(ctx, progress)
+		if err != nil {
+			log.Printf("Error saving twitter import progress: %s", err.Error())
+		}
+	}
+	err = cfg.store.DeleteTwitterImportBatches(ctx, jobID)
+	if err != nil {
+		log.Printf("Error deleting twitter import batches: %s", err.Error())
+	}
+	log.Printf("Twitter import for %s stored %d of %d tweets (%d duplicates, %d skipped)", in.UserID, progress.Imported, progress.Total, progress.Duplicates, progress.Skipped)
+	return nil
+}
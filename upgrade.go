@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Set for a process started by an upgrade to the PID of the process it replaces
+const upgradeParentEnv = "CHIRPY_UPGRADE_FROM"
+
+// Reports whether this process was started by an upgrade and its parent is still waiting
+// for it to take over
+func upgrading() bool {
+	parent, err := strconv.Atoi(os.Getenv(upgradeParentEnv))
+	return err == nil && parent == os.Getppid()
+}
+
+// Starts the binary again, possibly a new build of it, every time the process receives
+// SIGUSR2 and hands it the listening sockets. The new process tells this one to shut down
+// once it serves, so the sockets stay open throughout and no connection is refused. If it
+// exits before that, this process keeps serving and the upgrade can be tried again.
+func upgradeOnSIGUSR2(httpListener, grpcListener, adminListener net.Listener) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR2)
+	go func() {
+		for range signals {
+			cmd, err := startUpgrade(httpListener, grpcListener, adminListener)
+			if err != nil {
+				log.Printf("Error starting upgraded process: %s", err.Error())
+				continue
+			}
+			log.Printf("Started upgraded process %d, waiting for it to take over", cmd.Process.Pid)
+			err = cmd.Wait()
+			// still running here, so the new process failed before it took over
+			setUnlinkOnClose(httpListener, true)
+			log.Printf("Upgraded process exited before taking over, still serving: %v", err)
+		}
+	}()
+}
+
+// Helper function to start the current executable with the same arguments and the
+// listeners passed the same way systemd passes them
+func startUpgrade(httpListener, grpcListener, adminListener net.Listener) (*exec.Cmd, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	for _, l := range []struct {
+		name     string
+		listener net.Listener
+	}{{"http", httpListener}, {"grpc", grpcListener}, {"admin", adminListener}} {
+		if l.listener == nil {
+			continue
+		}
+		filer, ok := l.listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("cannot pass on a %s listener", l.listener.Addr().Network())
+		}
+		file, err := filer.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+		names = append(names, l.name)
+	}
+	env := slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		return strings.HasPrefix(kv, "LISTEN_PID=") || strings.HasPrefix(kv, "LISTEN_FDS=") ||
+			strings.HasPrefix(kv, "LISTEN_FDNAMES=") || strings.HasPrefix(kv, upgradeParentEnv+"=")
+	})
+	env = append(env,
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		upgradeParentEnv+"="+strconv.Itoa(os.Getpid()),
+	)
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = files
+	// the socket file now belongs to both processes, this one must not remove it on the way out
+	setUnlinkOnClose(httpListener, false)
+	err = cmd.Start()
+	if err != nil {
+		setUnlinkOnClose(httpListener, true)
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// Helper function to control whether closing a Unix socket listener removes the socket file
+func setUnlinkOnClose(listener net.Listener, unlink bool) {
+	if unixListener, ok := listener.(*net.UnixListener); ok {
+		unixListener.SetUnlinkOnClose(unlink)
+	}
+}
+
+// Called once this process serves: if it was started by an upgrade, tells the old process
+// to drain its requests and exit
+func finishUpgrade() {
+	if !upgrading() {
+		return
+	}
+	parent := os.Getppid()
+	os.Unsetenv(upgradeParentEnv)
+	err := syscall.Kill(parent, syscall.SIGTERM)
+	if err != nil {
+		log.Printf("Error stopping process %d after upgrade: %s", parent, err.Error())
+		return
+	}
+	log.Printf("Took over from process %d", parent)
+}
+
+// Helper function to write the PID of the serving process to PID_FILE for supervisors
+// that follow it across upgrades. The file is replaced in one step.
+func writePIDFile(path string) error {
+	tmp := path + ".tmp"
+	err := os.WriteFile(tmp, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
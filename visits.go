@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+)
+
+// Writes hits counted since the last flush to today's row. Hits are attributed to
+// the day of the flush, so a few can land on the next day around midnight.
+func (cfg *apiConfig) flushVisits(ctx context.Context) error {
+	hits := cfg.pendingHits.Swap(0)
+	if hits == 0 {
+		return nil
+	}
+	err := cfg.store.AddVisits(ctx, database.AddVisitsParams{Day: time.Now().UTC().Format(time.DateOnly), Hits: hits})
+	if err != nil {
+		// put them back so the next flush retries
+		cfg.pendingHits.Add(hits)
+		return err
+	}
+	return nil
+}
+
+// Flushes pending hits to the store every interval until ctx is done
+func (cfg *apiConfig) flushVisitsEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := cfg.flushVisits(ctx)
+			if err != nil {
+				log.Printf("Error saving visit counts: %s", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/validation"
+	"github.com/diamondoughnut/httpChirpy/internal/webhooks"
+	"github.com/google/uuid"
+)
+
+// keeps one account from fanning every event out to an unbounded number of URLs
+const maxWebhooksPerUser = 10
+
+// Request body of creating or replacing a webhook. active defaults to true. Without a
+// secret one is generated on create, and the current one is kept on replace unless
+// rotate_secret is set.
+type webhookRequest struct {
+	URL          string   `json:"url" validate:"required"`
+	Events       []string `json:"events" validate:"required"`
+	Active       *bool    `json:"active"`
+	Secret       string   `json:"secret" validate:"min=16,max=200"`
+	RotateSecret bool     `json:"rotate_secret"`
+}
+
+type webhookResponse struct {
+	ID        uuid.UUID `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// only returned when the webhook is created or its secret changes
+	Secret string `json:"secret,omitempty"`
+}
+
+func newWebhookResponse(webhook database.Webhook) webhookResponse {
+	return webhookResponse{
+		ID:        webhook.ID,
+		URL:       webhook.Url,
+		Events:    strings.Split(webhook.Events, ","),
+		Active:    webhook.Active,
+		CreatedAt: webhook.CreatedAt,
+		UpdatedAt: webhook.UpdatedAt,
+	}
+}
+
+type webhookEventTypeResponse struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// JSON schema of the body POSTed to subscribers
+	Schema map[string]any `json:"schema"`
+}
+
+// The receiver's response body is left out, so the endpoint cannot be used to read
+// what a URL returns
+type webhookTestResponse struct {
+	EventID    uuid.UUID `json:"event_id"`
+	Delivered  bool      `json:"delivered"`
+	StatusCode int32     `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// Like a test delivery, it leaves out the receiver's response body
+type webhookDeliveryResponse struct {
+	ID          uuid.UUID `json:"id"`
+	EventID     uuid.UUID `json:"event_id"`
+	Event       string    `json:"event"`
+	StatusCode  int32     `json:"status_code"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	RequestBody string    `json:"request_body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Registers a URL to receive events. The signing secret is shown only once, in the response.
+func (cfg *apiConfig) handlerCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	params, ok := cfg.decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	existing, err := cfg.store.ListWebhooksByUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error listing webhooks: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if len(existing) >= maxWebhooksPerUser {
+		marshallError(w, &apiError{Code: "webhook_limit_reached", Message: fmt.Sprintf("at most %d webhooks are allowed per user", maxWebhooksPerUser)}, 409)
+		return
+	}
+	secret := params.Secret
+	if secret == "" {
+		secret, err = webhooks.NewSecret()
+		if err != nil {
+			log.Printf("Error generating webhook secret: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+	}
+	webhook, err := cfg.store.CreateWebhook(r.Context(), database.CreateWebhookParams{
+		UserID: userID,
+		Url:    params.URL,
+		Secret: secret,
+		Events: strings.Join(params.Events, ","),
+		Active: *params.Active,
+	})
+	if err != nil {
+		log.Printf("Error creating webhook: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := newWebhookResponse(webhook)
+	resp.Secret = webhook.Secret
+	render(w, r, 201, resp)
+}
+
+// Lists the caller's webhooks
+func (cfg *apiConfig) handlerListWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	rows, err := cfg.store.ListWebhooksByUser(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error listing webhooks: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]webhookResponse, 0, len(rows))
+	for _, webhook := range rows {
+		resp = append(resp, newWebhookResponse(webhook))
+	}
+	render(w, r, 200, resp)
+}
+
+// Shows one of the caller's webhooks
+func (cfg *apiConfig) handlerGetWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	webhook, ok := cfg.ownWebhook(w, r, userID)
+	if !ok {
+		return
+	}
+	render(w, r, 200, newWebhookResponse(webhook))
+}
+
+// Replaces the URL, events and active flag of one of the caller's webhooks, and its
+// secret when one is given or rotate_secret is set. Pausing a webhook drops the
+// deliveries still waiting to be retried.
+func (cfg *apiConfig) handlerUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	webhook, ok := cfg.ownWebhook(w, r, userID)
+	if !ok {
+		return
+	}
+	params, ok := cfg.decodeWebhookRequest(w, r)
+	if !ok {
+		return
+	}
+	var err error
+	secret := params.Secret
+	if secret == "" && params.RotateSecret {
+		secret, err = webhooks.NewSecret()
+		if err != nil {
+			log.Printf("Error generating webhook secret: %s", err.Error())
+			marshallError(w, err, 500)
+			return
+		}
+	}
+	if secret == "" {
+		secret = webhook.Secret
+	}
+	updated, err := cfg.store.UpdateWebhook(r.Context(), database.UpdateWebhookParams{
+		ID:     webhook.ID,
+		UserID: userID,
+		Url:    params.URL,
+		Secret: secret,
+		Events: strings.Join(params.Events, ","),
+		Active: *params.Active,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// deleted since it was read
+		marshallError(w, fmt.Errorf("no webhook with id %s", webhook.ID), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating webhook: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := newWebhookResponse(updated)
+	if updated.Secret != webhook.Secret {
+		resp.Secret = updated.Secret
+	}
+	render(w, r, 200, resp)
+}
+
+// Removes one of the caller's webhooks along with its delivery log
+func (cfg *apiConfig) handlerDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	id, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid webhook id"), 400)
+		return
+	}
+	deleted, err := cfg.store.DeleteWebhook(r.Context(), database.DeleteWebhookParams{ID: id, UserID: userID})
+	if err != nil {
+		log.Printf("Error deleting webhook: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if deleted == 0 {
+		marshallError(w, fmt.Errorf("no webhook with id %s", id), 404)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// Shows recent delivery attempts for one of the caller's webhooks, newest first
+func (cfg *apiConfig) handlerListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	type query struct {
+		Limit int32 `query:"limit" validate:"min=1,max=500"`
+	}
+	params := query{Limit: 50}
+	err := decodeQuery(r, &params)
+	if err != nil {
+		marshallError(w, err, 400)
+		return
+	}
+	webhook, ok := cfg.ownWebhook(w, r, userID)
+	if !ok {
+		return
+	}
+	rows, err := cfg.store.ListWebhookDeliveries(r.Context(), database.ListWebhookDeliveriesParams{WebhookID: webhook.ID, Limit: params.Limit})
+	if err != nil {
+		log.Printf("Error listing webhook deliveries: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]webhookDeliveryResponse, 0, len(rows))
+	for _, d := range rows {
+		resp = append(resp, webhookDeliveryResponse{
+			ID:          d.ID,
+			EventID:     d.EventID,
+			Event:       d.Event,
+			StatusCode:  d.StatusCode,
+			Error:       d.Error,
+			DurationMs:  d.DurationMs,
+			RequestBody: d.RequestBody,
+			CreatedAt:   d.CreatedAt,
+		})
+	}
+	render(w, r, 200, resp)
+}
+
+// Sends a webhook.test event to one of the caller's webhooks straight away, paused or not,
+// and answers with how the receiver responded. The attempt also goes in the delivery log.
+func (cfg *apiConfig) handlerTestWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := cfg.authenticateUser(w, r)
+	if !ok {
+		return
+	}
+	webhook, ok := cfg.ownWebhook(w, r, userID)
+	if !ok {
+		return
+	}
+	record, err := cfg.webhooks.SendTest(r.Context(), webhook)
+	if record.EventID == uuid.Nil {
+		log.Printf("Error sending test webhook: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	render(w, r, 200, webhookTestResponse{
+		EventID:    record.EventID,
+		Delivered:  err == nil,
+		StatusCode: record.StatusCode,
+		Error:      record.Error,
+		DurationMs: record.DurationMs,
+	})
+}
+
+// Lists the event types webhooks can subscribe to, each with the schema of its body
+func (cfg *apiConfig) handlerListWebhookEvents(w http.ResponseWriter, r *http.Request) {
+	resp := make([]webhookEventTypeResponse, 0, len(webhooks.Catalog))
+	for _, eventType := range webhooks.Catalog {
+		// inlined rather than referenced, so each schema stands on its own
+		schema := structSchema(reflect.TypeOf(webhooks.Event{}), map[string]any{})
+		schema["properties"].(map[string]any)["data"] = structSchema(reflect.TypeOf(eventType.Data), map[string]any{})
+		resp = append(resp, webhookEventTypeResponse{Name: eventType.Name, Description: eventType.Description, Schema: schema})
+	}
+	render(w, r, 200, resp)
+}
+
+// Helper function to decode and check the body of creating or replacing a webhook, writing
+// a 400 if it is invalid. Events come back sorted without duplicates.
+func (cfg *apiConfig) decodeWebhookRequest(w http.ResponseWriter, r *http.Request) (webhookRequest, bool) {
+	params := webhookRequest{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return params, false
+	}
+	err = cfg.validateWebhookURL(params.URL)
+	if err != nil {
+		marshallError(w, err, 400)
+		return params, false
+	}
+	// the event names are not known to the validate tags, so they are checked here
+	var unknown []validation.FieldError
+	for i, event := range params.Events {
+		if !webhooks.ValidEvent(event) {
+			unknown = append(unknown, validation.FieldError{Field: fmt.Sprintf("events[%d]", i), Message: "must be one of " + strings.Join(webhooks.Events, ", ")})
+		}
+	}
+	if len(unknown) > 0 {
+		marshallError(w, validationError(unknown...), 400)
+		return params, false
+	}
+	slices.Sort(params.Events)
+	params.Events = slices.Compact(params.Events)
+	if params.Active == nil {
+		active := true
+		params.Active = &active
+	}
+	return params, true
+}
+
+// Helper function to find the caller's webhook named in the path, writing a 404 if it is
+// missing or someone else's
+func (cfg *apiConfig) ownWebhook(w http.ResponseWriter, r *http.Request, userID uuid.UUID) (database.Webhook, bool) {
+	id, err := uuid.Parse(r.PathValue("webhookID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid webhook id"), 400)
+		return database.Webhook{}, false
+	}
+	webhook, err := cfg.store.GetWebhook(r.Context(), id)
+	// someone else's webhook looks the same as a missing one
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && webhook.UserID != userID) {
+		marshallError(w, fmt.Errorf("no webhook with id %s", id), 404)
+		return database.Webhook{}, false
+	}
+	if err != nil {
+		log.Printf("Error finding webhook: %s", err.Error())
+		marshallError(w, err, 500)
+		return database.Webhook{}, false
+	}
+	return webhook, true
+}
+
+// Helper function to require https webhook URLs, plain http is allowed on dev and demo platforms
+func (cfg *apiConfig) validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http(s) URL")
+	}
+	if u.Scheme == "https" || (u.Scheme == "http" && (cfg.platform == "dev" || cfg.platform == "demo")) {
+		return nil
+	}
+	return fmt.Errorf("url must use https")
+}
+
+// Helper function to read the caller's user ID from their access token, writing a 401 if it is missing or invalid
+func (cfg *apiConfig) authenticateUser(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, err := cfg.userFromHeader(r.Header)
+	if err != nil {
+		marshallError(w, err, 401)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/diamondoughnut/httpChirpy/internal/database"
+	"github.com/diamondoughnut/httpChirpy/internal/profanity"
+	"github.com/google/uuid"
+)
+
+// A chirp a reject rule matched
+var errChirpRejected = errors.New("chirp contains words that are not allowed")
+
+// Reason of the report filed automatically for a chirp a hold rule matched, listed in
+// the moderation queue as flagged. Like an automod_hold, it hides the chirp from everyone
+// but its author until a moderator approves it.
+const wordFilterReason = "word_filter"
+
+// Request body of creating or replacing a word-filter rule. kind defaults to word.
+type wordFilterRequest struct {
+	Pattern string `json:"pattern" validate:"required,max=200"`
+	Kind    string `json:"kind" validate:"oneof=word wildcard regex"`
+	Action  string `json:"action" validate:"required,oneof=mask hold reject"`
+	Note    string `json:"note" validate:"max=500"`
+}
+
+type wordFilterResponse struct {
+	ID        uuid.UUID `json:"id"`
+	Pattern   string    `json:"pattern"`
+	Kind      string    `json:"kind"`
+	Action    string    `json:"action"`
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newWordFilterResponse(filter database.WordFilter) wordFilterResponse {
+	return wordFilterResponse{
+		ID:        filter.ID,
+		Pattern:   filter.Pattern,
+		Kind:      filter.Kind,
+		Action:    filter.Action,
+		Note:      filter.Note,
+		CreatedAt: filter.CreatedAt,
+		UpdatedAt: filter.UpdatedAt,
+	}
+}
+
+func wordFilterRule(filter database.WordFilter) profanity.Rule {
+	return profanity.Rule{
+		ID:      filter.ID.String(),
+		Pattern: filter.Pattern,
+		Kind:    profanity.Kind(filter.Kind),
+		Action:  profanity.Action(filter.Action),
+	}
+}
+
+// Decodes and checks the rule in the request body, answering 400 when it is not
+// valid. The pattern is trimmed.
+func decodeWordFilter(w http.ResponseWriter, r *http.Request) (wordFilterRequest, bool) {
+	params := wordFilterRequest{}
+	err := decodeJSON(r, &params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err.Error())
+		marshallError(w, err, decodeErrorStatus(err))
+		return params, false
+	}
+	params.Pattern = strings.TrimSpace(params.Pattern)
+	if params.Kind == "" {
+		params.Kind = string(profanity.KindWord)
+	}
+	err = profanity.Compile(profanity.Rule{Pattern: params.Pattern, Kind: profanity.Kind(params.Kind), Action: profanity.Action(params.Action)})
+	if err != nil {
+		marshallError(w, &apiError{Code: "invalid_pattern", Message: err.Error()}, 400)
+		return params, false
+	}
+	return params, true
+}
+
+// Loads the word-filter rules into cfg.wordFilter and returns them
+func (cfg *apiConfig) syncWordFilters(ctx context.Context) ([]database.WordFilter, error) {
+	filters, err := cfg.store.GetWordFilters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]profanity.Rule, 0, len(filters))
+	for _, filter := range filters {
+		rules = append(rules, wordFilterRule(filter))
+	}
+	compiled, err := profanity.NewFilter(rules)
+	if err != nil {
+		return nil, err
+	}
+	cfg.wordFilter.Store(compiled)
+	return filters, nil
+}
+
+// Picks up rules changed on other instances every interval until ctx is done. A failed
+// read keeps the last known rules.
+func (cfg *apiConfig) syncWordFiltersEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := cfg.syncWordFilters(ctx)
+			if err != nil {
+				log.Printf("Error reading word filters: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Describes the hold rules that matched a chirp, for the details of its report
+func heldDetails(held []profanity.Rule) string {
+	matched := make([]string, 0, len(held))
+	for _, rule := range held {
+		matched = append(matched, fmt.Sprintf("%s %q", rule.Kind, rule.Pattern))
+	}
+	return "matched " + strings.Join(matched, ", ")
+}
+
+// Lists the word-filter rules, oldest first
+func (cfg *apiConfig) handlerListWordFilters(w http.ResponseWriter, r *http.Request) {
+	filters, err := cfg.syncWordFilters(r.Context())
+	if err != nil {
+		log.Printf("Error reading word filters: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	resp := make([]wordFilterResponse, 0, len(filters))
+	for _, filter := range filters {
+		resp = append(resp, newWordFilterResponse(filter))
+	}
+	render(w, r, 200, resp)
+}
+
+// Adds a word-filter rule, applied to chirps posted from now on
+func (cfg *apiConfig) handlerCreateWordFilter(w http.ResponseWriter, r *http.Request) {
+	params, ok := decodeWordFilter(w, r)
+	if !ok {
+		return
+	}
+	filter, err := cfg.store.CreateWordFilter(r.Context(), database.CreateWordFilterParams{
+		Pattern: params.Pattern,
+		Kind:    params.Kind,
+		Action:  params.Action,
+		Note:    params.Note,
+	})
+	if err != nil {
+		log.Printf("Error creating word filter: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.wordFiltersChanged(r.Context())
+	cfg.recordAudit(r, "word_filter.create", filter.ID.String(), newWordFilterResponse(filter))
+	log.Printf("Word filter %s created: %s %q -> %s", filter.ID, filter.Kind, filter.Pattern, filter.Action)
+	render(w, r, 201, newWordFilterResponse(filter))
+}
+
+// Replaces the word-filter rule with the id in the path
+func (cfg *apiConfig) handlerUpdateWordFilter(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("filterID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid word filter id"), 400)
+		return
+	}
+	params, ok := decodeWordFilter(w, r)
+	if !ok {
+		return
+	}
+	filter, err := cfg.store.UpdateWordFilter(r.Context(), database.UpdateWordFilterParams{
+		ID:      id,
+		Pattern: params.Pattern,
+		Kind:    params.Kind,
+		Action:  params.Action,
+		Note:    params.Note,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		marshallError(w, fmt.Errorf("word filter %s not found", id), 404)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating word filter: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	cfg.wordFiltersChanged(r.Context())
+	cfg.recordAudit(r, "word_filter.update", filter.ID.String(), newWordFilterResponse(filter))
+	log.Printf("Word filter %s updated: %s %q -> %s", filter.ID, filter.Kind, filter.Pattern, filter.Action)
+	render(w, r, 200, newWordFilterResponse(filter))
+}
+
+// Deletes the word-filter rule with the id in the path
+func (cfg *apiConfig) handlerDeleteWordFilter(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("filterID"))
+	if err != nil {
+		marshallError(w, fmt.Errorf("invalid word filter id"), 400)
+		return
+	}
+	n, err := cfg.store.DeleteWordFilter(r.Context(), id)
+	if err != nil {
+		log.Printf("Error deleting word filter: %s", err.Error())
+		marshallError(w, err, 500)
+		return
+	}
+	if n == 0 {
+		marshallError(w, fmt.Errorf("word filter %s not found", id), 404)
+		return
+	}
+	cfg.wordFiltersChanged(r.Context())
+	cfg.recordAudit(r, "word_filter.delete", id.String(), nil)
+	log.Printf("Word filter %s deleted", id)
+	w.WriteHeader(204)
+}
+
+// Applies an edit on this instance right away; others pick it up with syncWordFiltersEvery
+func (cfg *apiConfig) wordFiltersChanged(ctx context.Context) {
+	_, err := cfg.syncWordFilters(ctx)
+	if err != nil {
+		log.Printf("Error reading word filters: %s", err.Error())
+	}
+}